@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/yourusername/secure-messaging-poc/internal/certmanager"
+)
+
+// fingerprintList prints the fingerprint allowlist and denylist
+func fingerprintList(client *http.Client, serverURL string) error {
+	resp, err := client.Get(serverURL + "/api/admin/fingerprints")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+
+	var result struct {
+		Fingerprints []certmanager.FingerprintEntry `json:"fingerprints"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+
+	for _, entry := range result.Fingerprints {
+		fmt.Printf("%s  %-5s  %s  %s\n", entry.Fingerprint, entry.List,
+			entry.Added.Format("2006-01-02"), entry.Note)
+	}
+	return nil
+}
+
+// fingerprintSet adds a fingerprint, or a certificate file's fingerprint, to
+// the allowlist or denylist with an optional note
+func fingerprintSet(client *http.Client, serverURL, list string, args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("fingerprint-%s requires a fingerprint or certificate file", list)
+	}
+
+	fingerprint, err := resolveFingerprint(args[0])
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(map[string]string{
+		"fingerprint": fingerprint,
+		"list":        list,
+		"note":        strings.Join(args[1:], " "),
+	})
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Post(serverURL+"/api/admin/fingerprints", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+
+	var entry certmanager.FingerprintEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entry); err != nil {
+		return err
+	}
+
+	log.Printf("Added %s to the %slist", entry.Fingerprint, entry.List)
+	return nil
+}
+
+// fingerprintRemove takes a fingerprint off either list
+func fingerprintRemove(client *http.Client, serverURL string, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("fingerprint-remove requires a fingerprint or certificate file")
+	}
+
+	fingerprint, err := resolveFingerprint(args[0])
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodDelete, serverURL+"/api/admin/fingerprints?fingerprint="+url.QueryEscape(fingerprint), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+
+	log.Printf("Removed fingerprint %s", fingerprint)
+	return nil
+}
+
+// resolveFingerprint returns the SPKI fingerprint of a PEM certificate file,
+// or the argument itself if it is not a readable file
+func resolveFingerprint(arg string) (string, error) {
+	data, err := os.ReadFile(arg)
+	if err != nil {
+		return arg, nil
+	}
+
+	cert, err := certmanager.ParseCertificatePEM(data)
+	if err != nil {
+		return "", err
+	}
+	return certmanager.SPKIFingerprint(cert), nil
+}
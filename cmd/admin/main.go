@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/yourusername/secure-messaging-poc/internal/certmanager"
+)
+
+// admin talks to a server's admin API using an admin client certificate.
+//
+//	admin [flags] graph-export [-format json|cbor] [-out file]
+//	admin [flags] graph-import [-format json|cbor] file
+//	admin [flags] revoke-batch [-format json|csv] file
+//	admin [flags] trust-list
+//	admin [flags] trust-add file
+//	admin [flags] trust-remove id
+//	admin [flags] fingerprint-list
+//	admin [flags] fingerprint-allow|fingerprint-deny fingerprint|cert-file [note]
+//	admin [flags] fingerprint-remove fingerprint|cert-file
+//	admin warrant-keygen
+//	admin [flags] warrant-publish [-key private-key] [-valid 720h] statement-file
+func main() {
+	serverURL := flag.String("server", "https://localhost:8443", "Base URL of the server")
+	certPath := flag.String("cert", "admin.crt", "Admin client certificate")
+	keyPath := flag.String("key", "admin.key", "Admin client private key")
+	caPath := flag.String("ca", "certs/ca.crt", "CA certificate used to verify the server")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] graph-export|graph-import|revoke-batch|trust-list|trust-add|trust-remove|fingerprint-list|fingerprint-allow|fingerprint-deny|fingerprint-remove|warrant-keygen|warrant-publish [args]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// Key generation stays offline and needs no admin certificate
+	if flag.Arg(0) == "warrant-keygen" {
+		if err := warrantKeygen(); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	client, err := newClient(*certPath, *keyPath, *caPath)
+	if err != nil {
+		log.Fatalf("Failed to set up TLS client: %v", err)
+	}
+
+	switch flag.Arg(0) {
+	case "graph-export":
+		err = graphExport(client, *serverURL, flag.Args()[1:])
+	case "graph-import":
+		err = graphImport(client, *serverURL, flag.Args()[1:])
+	case "revoke-batch":
+		err = revokeBatch(client, *serverURL, flag.Args()[1:])
+	case "trust-list":
+		err = trustList(client, *serverURL)
+	case "trust-add":
+		err = trustAdd(client, *serverURL, flag.Args()[1:])
+	case "trust-remove":
+		err = trustRemove(client, *serverURL, flag.Args()[1:])
+	case "fingerprint-list":
+		err = fingerprintList(client, *serverURL)
+	case "fingerprint-allow":
+		err = fingerprintSet(client, *serverURL, certmanager.FingerprintAllow, flag.Args()[1:])
+	case "fingerprint-deny":
+		err = fingerprintSet(client, *serverURL, certmanager.FingerprintDeny, flag.Args()[1:])
+	case "fingerprint-remove":
+		err = fingerprintRemove(client, *serverURL, flag.Args()[1:])
+	case "warrant-publish":
+		err = warrantPublish(client, *serverURL, flag.Args()[1:])
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// graphExport downloads the signed referral graph
+func graphExport(client *http.Client, serverURL string, args []string) error {
+	fs := flag.NewFlagSet("graph-export", flag.ExitOnError)
+	format := fs.String("format", certmanager.GraphFormatJSON, "Document format (json or cbor)")
+	outPath := fs.String("out", "", "Output file (default stdout)")
+	fs.Parse(args)
+
+	resp, err := client.Get(serverURL + "/api/admin/graph/export?format=" + *format)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+
+	out := os.Stdout
+	if *outPath != "" {
+		f, err := os.Create(*outPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	_, err = io.Copy(out, resp.Body)
+	return err
+}
+
+// graphImport uploads a signed referral graph for merging
+func graphImport(client *http.Client, serverURL string, args []string) error {
+	fs := flag.NewFlagSet("graph-import", flag.ExitOnError)
+	format := fs.String("format", certmanager.GraphFormatJSON, "Document format (json or cbor)")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("graph-import requires a document file")
+	}
+
+	data, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	// Check the document locally before sending it
+	if _, err := certmanager.UnmarshalGraph(data, *format); err != nil {
+		return fmt.Errorf("invalid graph document: %w", err)
+	}
+
+	contentType := "application/json"
+	if *format == certmanager.GraphFormatCBOR {
+		contentType = "application/cbor"
+	}
+
+	resp, err := client.Post(serverURL+"/api/admin/graph/import", contentType, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+
+	var result struct {
+		IssuerID         string `json:"issuer_id"`
+		ReferralsAdded   int    `json:"referrals_added"`
+		RevocationsAdded int    `json:"revocations_added"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+
+	log.Printf("Merged graph from issuer %s: %d new referrals, %d new revocations",
+		result.IssuerID, result.ReferralsAdded, result.RevocationsAdded)
+	return nil
+}
+
+// revokeBatch revokes the certificates of a JSON or CSV list, without their
+// referred certificates
+func revokeBatch(client *http.Client, serverURL string, args []string) error {
+	fs := flag.NewFlagSet("revoke-batch", flag.ExitOnError)
+	format := fs.String("format", certmanager.RevocationListCSV, "List format (json or csv)")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("revoke-batch requires a list file")
+	}
+
+	data, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	// Check the list locally before sending it
+	if _, err := certmanager.ParseRevocationList(data, *format); err != nil {
+		return err
+	}
+
+	contentType := "application/json"
+	if *format == certmanager.RevocationListCSV {
+		contentType = "text/csv"
+	}
+
+	resp, err := client.Post(serverURL+"/api/admin/revocations", contentType, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+
+	var result struct {
+		Listed  int `json:"listed"`
+		Revoked int `json:"revoked"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+
+	log.Printf("Revoked %d of %d listed certificates; the rest were already revoked",
+		result.Revoked, result.Listed)
+	return nil
+}
+
+// trustList prints the CAs trusted for client certificates
+func trustList(client *http.Client, serverURL string) error {
+	resp, err := client.Get(serverURL + "/api/admin/trust")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+
+	var result struct {
+		Anchors []certmanager.TrustAnchor `json:"anchors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+
+	for _, anchor := range result.Anchors {
+		fmt.Printf("%s  %-9s  %s  %s\n", anchor.ID, anchor.Source,
+			anchor.NotAfter.Format("2006-01-02"), anchor.Subject)
+	}
+	return nil
+}
+
+// trustAdd trusts a PEM CA certificate until the server restarts
+func trustAdd(client *http.Client, serverURL string, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("trust-add requires a certificate file")
+	}
+
+	data, err := os.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Post(serverURL+"/api/admin/trust", "application/x-pem-file", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+
+	var anchor certmanager.TrustAnchor
+	if err := json.NewDecoder(resp.Body).Decode(&anchor); err != nil {
+		return err
+	}
+
+	log.Printf("Trusted %s: %s", anchor.ID, anchor.Subject)
+	return nil
+}
+
+// trustRemove stops trusting a CA
+func trustRemove(client *http.Client, serverURL string, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("trust-remove requires an anchor ID")
+	}
+
+	req, err := http.NewRequest(http.MethodDelete, serverURL+"/api/admin/trust?id="+url.QueryEscape(args[0]), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+
+	log.Printf("Removed trust anchor %s", args[0])
+	return nil
+}
+
+// newClient creates an HTTP client authenticating with the admin certificate
+func newClient(certPath, keyPath, caPath string) (*http.Client, error) {
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	caPEM, err := os.ReadFile(caPath)
+	if err != nil {
+		return nil, err
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("no certificates found in %s", caPath)
+	}
+
+	return &http.Client{
+		Timeout: time.Minute,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				Certificates: []tls.Certificate{cert},
+				RootCAs:      roots,
+				MinVersion:   tls.VersionTLS13,
+			},
+		},
+	}, nil
+}
+
+// responseError turns a non-OK response into an error
+func responseError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return fmt.Errorf("server returned %s: %s", resp.Status, bytes.TrimSpace(body))
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/yourusername/secure-messaging-poc/internal/warrant"
+)
+
+// warrantKeygen prints a new operator key pair for the warrant canary. The
+// public half goes in warrant_canary.public_key; the private half stays
+// with the operator, off the server.
+func warrantKeygen() error {
+	pub, priv, err := warrant.GenerateKeyPair()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("public_key:  %s\nprivate_key: %s\n", pub, priv)
+	return nil
+}
+
+// warrantPublish signs the statement in a file as a new warrant canary and
+// publishes it. The canary is issued now and goes stale after -valid.
+func warrantPublish(client *http.Client, serverURL string, args []string) error {
+	fs := flag.NewFlagSet("warrant-publish", flag.ExitOnError)
+	privateKey := fs.String("key", os.Getenv("ANONO_WARRANT_PRIVATE_KEY"), "Operator private key (base64)")
+	valid := fs.Duration("valid", 30*24*time.Hour, "Time until the canary goes stale")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("warrant-publish requires a statement file")
+	}
+	key, err := warrant.ParsePrivateKey(*privateKey)
+	if err != nil {
+		return err
+	}
+	statement, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	doc, err := warrant.Sign(warrant.Canary{
+		Statement:  strings.TrimSpace(string(statement)),
+		Issued:     now,
+		NextUpdate: now.Add(*valid),
+	}, key)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Post(serverURL+"/api/admin/warrant-canary", "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+
+	log.Printf("Published warrant canary; renew before %s", now.Add(*valid).Format(time.RFC3339))
+	return nil
+}
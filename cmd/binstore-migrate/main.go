@@ -0,0 +1,40 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"github.com/yourusername/secure-messaging-poc/internal/binmanager"
+)
+
+// binstore-migrate imports a snapshot written by an in-memory server
+// instance into a LevelDB bin store, for offline migrations
+func main() {
+	snapshotPath := flag.String("snapshot", "", "Path to the snapshot file written on shutdown")
+	storePath := flag.String("leveldb", "data/bins", "Path to the LevelDB bin store")
+	flag.Parse()
+
+	if *snapshotPath == "" {
+		log.Fatal("-snapshot is required")
+	}
+
+	f, err := os.Open(*snapshotPath)
+	if err != nil {
+		log.Fatalf("Failed to open snapshot: %v", err)
+	}
+	defer f.Close()
+
+	store, err := binmanager.OpenLevelDBStore(*storePath)
+	if err != nil {
+		log.Fatalf("Failed to open bin store: %v", err)
+	}
+	defer store.Close()
+
+	binIDs, count, err := binmanager.ImportSnapshot(f, store.ForBin)
+	if err != nil {
+		log.Fatalf("Import failed after %d messages: %v", count, err)
+	}
+
+	log.Printf("Imported %d messages into %d bins", count, len(binIDs))
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"sort"
+
+	"github.com/yourusername/secure-messaging-poc/internal/keystore"
+)
+
+// keystore-migrate upgrades every record in a key store file to the current
+// envelope version in place, so a server can be started on a store written
+// by an older release, or checks which versions a file holds with -dry-run
+func main() {
+	path := flag.String("keystore", "", "Path to the key store file")
+	dryRun := flag.Bool("dry-run", false, "Report record versions without rewriting the file")
+	flag.Parse()
+
+	if *path == "" {
+		log.Fatal("-keystore is required")
+	}
+
+	counts, err := keystore.MigrateFile(*path, *dryRun)
+	if err != nil {
+		log.Fatalf("Migration failed: %v", err)
+	}
+
+	versions := make([]int, 0, len(counts))
+	for version := range counts {
+		versions = append(versions, version)
+	}
+	sort.Ints(versions)
+	for _, version := range versions {
+		log.Printf("%d records at envelope version %d", counts[version], version)
+	}
+	if *dryRun {
+		log.Printf("Dry run: %s left unchanged", *path)
+		return
+	}
+	log.Printf("All records in %s are at envelope version %d", *path, keystore.EnvelopeVersion)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/yourusername/secure-messaging-poc/internal/logship"
+)
+
+// logread decrypts log files written by the server's log shipping sink.
+//
+//	logread -keygen
+//	logread -public KEY -private KEY file...
+func main() {
+	keygen := flag.Bool("keygen", false, "Generate an operator key pair and exit")
+	publicKey := flag.String("public", os.Getenv("ANONO_LOG_PUBLIC_KEY"), "Operator public key (base64)")
+	privateKey := flag.String("private", os.Getenv("ANONO_LOG_PRIVATE_KEY"), "Operator private key (base64)")
+	flag.Parse()
+
+	if *keygen {
+		pub, priv, err := logship.GenerateKeyPair()
+		if err != nil {
+			log.Fatalf("Failed to generate keys: %v", err)
+		}
+		fmt.Printf("public_key:  %s\nprivate_key: %s\n", pub, priv)
+		return
+	}
+
+	pub, err := logship.ParsePublicKey(*publicKey)
+	if err != nil {
+		log.Fatalf("Invalid public key: %v", err)
+	}
+	priv, err := logship.ParsePrivateKey(*privateKey)
+	if err != nil {
+		log.Fatalf("Invalid private key: %v", err)
+	}
+
+	for _, path := range flag.Args() {
+		if err := printFile(path, pub, priv); err != nil {
+			log.Fatalf("%s: %v", path, err)
+		}
+	}
+}
+
+// printFile writes the records of every batch in a log file to stdout
+func printFile(path string, pub, priv *[32]byte) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	batches, err := logship.ReadBatches(f)
+	if err != nil {
+		log.Printf("%s: truncated after %d batches: %v", path, len(batches), err)
+	}
+
+	for _, batch := range batches {
+		sequence, records, err := logship.OpenBatch(batch, pub, priv)
+		if err != nil {
+			return err
+		}
+		for _, record := range records {
+			fmt.Printf("%d %s %s\n", sequence, record.Time.Format(time.RFC3339Nano), record.Message)
+		}
+	}
+	return nil
+}
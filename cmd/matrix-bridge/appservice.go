@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/yourusername/secure-messaging-poc/internal/bridge"
+	"github.com/yourusername/secure-messaging-poc/pkg/client"
+	cryptopkg "github.com/yourusername/secure-messaging-poc/pkg/crypto"
+)
+
+// Event content fields carrying a message of an opaque room
+const (
+	ciphertextField = "fi.anono.ciphertext"
+	messageIDField  = "fi.anono.message_id"
+)
+
+// Homeserver delivery attempts, and how many transactions are remembered
+// to drop retries
+const (
+	sendAttempts = 3
+	recentSize   = 4096
+)
+
+// matrixEvent is the part of a room event the bridge reads
+type matrixEvent struct {
+	Type    string                 `json:"type"`
+	RoomID  string                 `json:"room_id"`
+	Sender  string                 `json:"sender"`
+	EventID string                 `json:"event_id"`
+	Content map[string]interface{} `json:"content"`
+}
+
+// matrixBridge is the homeserver side of the bridge
+type matrixBridge struct {
+	config     matrixConfig
+	relay      *bridge.Relay
+	httpClient *http.Client
+
+	transactions *bridge.Recent // Homeserver transactions already handled
+}
+
+// newMatrixBridge creates the transport; its relay is set once created
+func newMatrixBridge(config matrixConfig) *matrixBridge {
+	return &matrixBridge{
+		config:       config,
+		httpClient:   &http.Client{Timeout: 30 * time.Second},
+		transactions: bridge.NewRecent(recentSize),
+	}
+}
+
+// handler serves the application service API the homeserver pushes to
+func (b *matrixBridge) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/_matrix/app/v1/transactions/", b.handleTransaction)
+	mux.HandleFunc("/transactions/", b.handleTransaction) // Legacy path
+	return mux
+}
+
+// handleTransaction publishes the messages of a transaction of room events.
+// Message IDs derive from event IDs, so a transaction retried after a
+// partial failure does not publish anything twice.
+func (b *matrixBridge) handleTransaction(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		matrixError(w, http.StatusMethodNotAllowed, "M_UNRECOGNIZED", "Method not allowed")
+		return
+	}
+	if !b.authorized(r) {
+		matrixError(w, http.StatusForbidden, "M_FORBIDDEN", "Invalid homeserver token")
+		return
+	}
+	txnID := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+	if b.transactions.Contains(txnID) {
+		writeJSON(w, struct{}{})
+		return
+	}
+
+	var txn struct {
+		Events []matrixEvent `json:"events"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 16<<20)).Decode(&txn); err != nil {
+		matrixError(w, http.StatusBadRequest, "M_NOT_JSON", "Invalid transaction")
+		return
+	}
+	for _, event := range txn.Events {
+		err := b.fromMatrix(event)
+		if errors.Is(err, client.ErrNotConnected) {
+			// The homeserver retries the whole transaction later
+			matrixError(w, http.StatusServiceUnavailable, "M_UNKNOWN", "Not connected to the messaging server")
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to bridge event %s from %s: %v", event.EventID, event.RoomID, err)
+		}
+	}
+	b.transactions.Add(txnID)
+	writeJSON(w, struct{}{})
+}
+
+// authorized checks the homeserver token, sent as a bearer token or, by
+// older homeservers, as the access_token parameter
+func (b *matrixBridge) authorized(r *http.Request) bool {
+	presented := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if presented == "" {
+		presented = r.URL.Query().Get("access_token")
+	}
+	return cryptopkg.ConstantTimeEqualString(presented, b.config.HSToken)
+}
+
+// fromMatrix publishes a message event of a bridged room. Opaque rooms
+// forward the ciphertext of events that carry one; decrypting rooms encrypt
+// the body.
+func (b *matrixBridge) fromMatrix(event matrixEvent) error {
+	if event.Type != "m.room.message" || event.Sender == b.config.Sender {
+		return nil
+	}
+	body, _ := event.Content["body"].(string)
+	var ciphertext []byte
+	if encoded, _ := event.Content[ciphertextField].(string); encoded != "" {
+		var err error
+		if ciphertext, err = base64.StdEncoding.DecodeString(encoded); err != nil {
+			return fmt.Errorf("invalid %s: %v", ciphertextField, err)
+		}
+	}
+	return b.relay.FromRoom(event.RoomID, event.EventID, body, ciphertext)
+}
+
+// Send implements bridge.Transport, posting a message to its room as the
+// bridge's sender
+func (b *matrixBridge) Send(delivery bridge.Delivery) error {
+	content := map[string]interface{}{
+		"msgtype":       "m.notice",
+		"body":          "Encrypted message",
+		ciphertextField: base64.StdEncoding.EncodeToString(delivery.Message.Ciphertext),
+		messageIDField:  delivery.Message.MessageID,
+	}
+	if delivery.Plaintext != nil {
+		content = map[string]interface{}{
+			"msgtype": "m.text",
+			"body":    strings.ToValidUTF8(string(delivery.Plaintext), "�"),
+		}
+	}
+
+	// One transaction per message and room, so a resent message is shown
+	// once
+	txnID := uuid.NewSHA1(uuid.NameSpaceURL, []byte(delivery.Message.MessageID+"\x00"+delivery.Route.Room)).String()
+	return b.send(delivery.Route.Room, txnID, content)
+}
+
+// send puts a room message, retrying failures
+func (b *matrixBridge) send(roomID, txnID string, content map[string]interface{}) error {
+	body, err := json.Marshal(content)
+	if err != nil {
+		return err
+	}
+	endpoint := strings.TrimRight(b.config.Homeserver, "/") + "/_matrix/client/v3/rooms/" +
+		url.PathEscape(roomID) + "/send/m.room.message/" + url.PathEscape(txnID)
+	if b.config.Sender != "" {
+		endpoint += "?user_id=" + url.QueryEscape(b.config.Sender)
+	}
+
+	for attempt := 1; ; attempt++ {
+		err = b.put(endpoint, body)
+		if err == nil || attempt == sendAttempts {
+			return err
+		}
+		time.Sleep(client.DefaultBackoff.Delay(attempt))
+	}
+}
+
+// put sends one client-server API request with the appservice token
+func (b *matrixBridge) put(endpoint string, body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+b.config.ASToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := b.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("homeserver returned %s: %s", resp.Status, bytes.TrimSpace(message))
+	}
+	return nil
+}
+
+// matrixError writes an error in the Matrix format
+func matrixError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"errcode": code, "error": message})
+}
+
+// writeJSON writes a successful JSON response
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/yourusername/secure-messaging-poc/internal/bridge"
+)
+
+// matrix-bridge is a Matrix application service that bridges channels to
+// Matrix rooms, so a community can move between the two gradually.
+//
+//	matrix-bridge -config matrix-bridge.yaml
+//
+// The configuration names the messaging server, the bridge's client
+// certificate, the homeserver and the tokens of the appservice registration,
+// and the rooms:
+//
+//	server: https://localhost:8443
+//	cert: bridge.crt
+//	key: bridge.key
+//	ca: certs/ca.crt
+//	store: {path: bridge.db, key: <base64 backup key>}  # optional
+//	matrix:
+//	  homeserver: https://matrix.example.org
+//	  listen: ":8009"
+//	  as_token: ...
+//	  hs_token: ...
+//	  sender: "@anono:example.org"
+//	rooms:
+//	  - {channel: "0x1234", room: "!abc:example.org", mode: opaque}
+//	  - {channel: "42", room: "!def:example.org", mode: decrypt, key: <base64>}
+//
+// Opaque rooms carry ciphertext in the fi.anono.ciphertext field of each
+// event for members' own clients to decrypt; decrypting rooms exchange
+// plain m.text messages that the bridge encrypts with the channel key.
+func main() {
+	configPath := flag.String("config", "matrix-bridge.yaml", "Bridge configuration file")
+	flag.Parse()
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	b := newMatrixBridge(cfg.Matrix)
+	relay, err := bridge.NewRelay(cfg.Config, b)
+	if err != nil {
+		log.Fatalf("Failed to start bridge: %v", err)
+	}
+	defer relay.Close()
+	b.relay = relay
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	srv := &http.Server{Addr: cfg.Matrix.Listen, Handler: b.handler(), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		log.Printf("Appservice listening on %s", cfg.Matrix.Listen)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Appservice listener failed: %v", err)
+		}
+	}()
+
+	err = relay.Run(ctx)
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer shutdownCancel()
+	srv.Shutdown(shutdownCtx)
+	if err != nil && !errors.Is(err, context.Canceled) {
+		log.Fatalf("Bridge stopped: %v", err)
+	}
+}
+
+// config is the bridge configuration file
+type config struct {
+	bridge.Config `mapstructure:",squash"`
+	Matrix        matrixConfig `mapstructure:"matrix"`
+}
+
+// matrixConfig is the homeserver side of the bridge
+type matrixConfig struct {
+	Homeserver string `mapstructure:"homeserver"`
+	Listen     string `mapstructure:"listen"`
+	ASToken    string `mapstructure:"as_token"`
+	HSToken    string `mapstructure:"hs_token"`
+	Sender     string `mapstructure:"sender"`
+}
+
+// loadConfig reads and checks the configuration file
+func loadConfig(path string) (*config, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+	bridge.SetDefaults(v)
+	v.SetDefault("matrix.listen", ":8009")
+	if err := v.ReadInConfig(); err != nil {
+		return nil, err
+	}
+
+	var cfg config
+	if err := v.Unmarshal(&cfg); err != nil {
+		return nil, err
+	}
+	switch {
+	case cfg.Matrix.Homeserver == "":
+		return nil, errors.New("matrix.homeserver is required")
+	case cfg.Matrix.ASToken == "" || cfg.Matrix.HSToken == "":
+		return nil, errors.New("matrix.as_token and matrix.hs_token are required")
+	}
+	return &cfg, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"sync"
+	"time"
+
+	"github.com/yourusername/secure-messaging-poc/internal/canary"
+	"github.com/yourusername/secure-messaging-poc/internal/certmanager"
+	"github.com/yourusername/secure-messaging-poc/internal/config"
+	"github.com/yourusername/secure-messaging-poc/internal/metrics"
+)
+
+// canaryValidityDays is the lifetime of the probe's certificates, which are
+// renewed a day before they expire
+const canaryValidityDays = 7
+
+// setupCanary creates the end-to-end probe with a client certificate from
+// the CA. The server certificate is verified against the system roots and
+// the CA.
+func setupCanary(cfg *config.Config, ca *certmanager.CertificateAuthority, caCert *x509.Certificate) (*canary.Probe, error) {
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		roots = x509.NewCertPool()
+	}
+	roots.AddCert(caCert)
+
+	var mu sync.Mutex
+	var current *tls.Certificate
+	tlsConfig := &tls.Config{
+		RootCAs:    roots,
+		MinVersion: tls.VersionTLS12,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if current == nil || time.Until(current.Leaf.NotAfter) < 24*time.Hour {
+				cert, err := issueCanaryCertificate(ca)
+				if err != nil {
+					return nil, err
+				}
+				current = cert
+			}
+			return current, nil
+		},
+	}
+
+	return canary.New(canary.Config{
+		ServerURL: cfg.Canary.ServerURL,
+		TLSConfig: tlsConfig,
+		Channel:   cfg.Canary.Channel,
+		Interval:  cfg.Canary.Interval,
+		Timeout:   cfg.Canary.Timeout,
+	}, metrics.Default)
+}
+
+// issueCanaryCertificate issues a short-lived client certificate for the
+// probe
+func issueCanaryCertificate(ca *certmanager.CertificateAuthority) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: "anono-canary"},
+	}, key)
+	if err != nil {
+		return nil, err
+	}
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := ca.SignCSR(csr, "", canaryValidityDays)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{Certificate: [][]byte{cert.Raw}, PrivateKey: key, Leaf: cert}, nil
+}
@@ -0,0 +1,66 @@
+//go:build chaos
+
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/yourusername/secure-messaging-poc/internal/binmanager"
+	"github.com/yourusername/secure-messaging-poc/internal/chaos"
+	"github.com/yourusername/secure-messaging-poc/internal/server"
+)
+
+// Fault injection is configured from the environment in chaos builds:
+//
+//	ANONO_CHAOS_STORE="latency=2ms,error=0.01,partial=0.01"  bin store faults
+//	ANONO_CHAOS_SEND="error=0.05"                            delivery faults
+//	ANONO_CHAOS_SEED=42                                      reproducible runs
+
+// chaosInjector creates an injector from the named environment variable, or
+// nil if it is unset
+func chaosInjector(name string) *chaos.Injector {
+	spec := os.Getenv(name)
+	if spec == "" {
+		return nil
+	}
+
+	faults, err := chaos.ParseFaults(spec)
+	if err != nil {
+		log.Fatalf("Invalid %s: %v", name, err)
+	}
+
+	seed := time.Now().UnixNano()
+	if value := os.Getenv("ANONO_CHAOS_SEED"); value != "" {
+		if seed, err = strconv.ParseInt(value, 10, 64); err != nil {
+			log.Fatalf("Invalid ANONO_CHAOS_SEED: %v", err)
+		}
+	}
+
+	log.Printf("CHAOS BUILD: injecting %s faults %+v (seed %d)", name, faults, seed)
+	return chaos.NewInjector(faults, seed)
+}
+
+// wrapStoreFactory injects bin store faults
+func wrapStoreFactory(factory binmanager.StoreFactory) binmanager.StoreFactory {
+	in := chaosInjector("ANONO_CHAOS_STORE")
+	if in == nil {
+		return factory
+	}
+	return chaos.WrapFactory(factory, in)
+}
+
+// chaosOptions injects faults into message delivery
+func chaosOptions() []server.Option {
+	in := chaosInjector("ANONO_CHAOS_SEND")
+	if in == nil {
+		return nil
+	}
+	return []server.Option{
+		server.WithSubscriberWrapper(func(client binmanager.Client) binmanager.Client {
+			return chaos.WrapClient(client, in)
+		}),
+	}
+}
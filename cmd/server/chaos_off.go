@@ -0,0 +1,18 @@
+//go:build !chaos
+
+package main
+
+import (
+	"github.com/yourusername/secure-messaging-poc/internal/binmanager"
+	"github.com/yourusername/secure-messaging-poc/internal/server"
+)
+
+// wrapStoreFactory returns factory unchanged outside chaos builds
+func wrapStoreFactory(factory binmanager.StoreFactory) binmanager.StoreFactory {
+	return factory
+}
+
+// chaosOptions adds nothing outside chaos builds
+func chaosOptions() []server.Option {
+	return nil
+}
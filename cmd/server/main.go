@@ -2,20 +2,46 @@ package main
 
 import (
 	"context"
-	"crypto/tls"
 	"crypto/x509"
+	"encoding/base64"
 	"flag"
+	"fmt"
+	"io"
 	"log"
+	"math/bits"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/yourusername/secure-messaging-poc/internal/authz"
 	"github.com/yourusername/secure-messaging-poc/internal/binmanager"
+	"github.com/yourusername/secure-messaging-poc/internal/bulletin"
+	"github.com/yourusername/secure-messaging-poc/internal/canary"
 	"github.com/yourusername/secure-messaging-poc/internal/certmanager"
 	"github.com/yourusername/secure-messaging-poc/internal/config"
+	"github.com/yourusername/secure-messaging-poc/internal/directory"
+	"github.com/yourusername/secure-messaging-poc/internal/features"
 	"github.com/yourusername/secure-messaging-poc/internal/keystore"
+	"github.com/yourusername/secure-messaging-poc/internal/legalhold"
+	"github.com/yourusername/secure-messaging-poc/internal/logship"
+	"github.com/yourusername/secure-messaging-poc/internal/metrics"
+	"github.com/yourusername/secure-messaging-poc/internal/onion"
+	"github.com/yourusername/secure-messaging-poc/internal/proxyproto"
+	"github.com/yourusername/secure-messaging-poc/internal/push"
+	"github.com/yourusername/secure-messaging-poc/internal/ratelimit"
+	"github.com/yourusername/secure-messaging-poc/internal/recovery"
 	"github.com/yourusername/secure-messaging-poc/internal/server"
+	"github.com/yourusername/secure-messaging-poc/internal/smtpgate"
+	"github.com/yourusername/secure-messaging-poc/internal/sqlstore"
+	"github.com/yourusername/secure-messaging-poc/internal/tlsconfig"
+	"github.com/yourusername/secure-messaging-poc/internal/tlslimit"
+	"github.com/yourusername/secure-messaging-poc/internal/warrant"
+	"github.com/yourusername/secure-messaging-poc/pkg/plugin"
+	"github.com/yourusername/secure-messaging-poc/pkg/protocol"
 )
 
 func main() {
@@ -29,54 +55,337 @@ func main() {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
+	// Ship encrypted copies of the logs if configured
+	closeLogs, err := setupLogShipping(cfg)
+	if err != nil {
+		log.Fatalf("Failed to set up log shipping: %v", err)
+	}
+
 	// Initialize certificate authority
-	ca, err := certmanager.NewCertificateAuthority(
+	ca, err := certmanager.NewCertificateAuthorityWithAlgorithm(
 		cfg.CA.CertPath,
 		cfg.CA.KeyPath,
 		cfg.CA.Organization,
+		cfg.CA.KeyAlgorithm,
 	)
 	if err != nil {
 		log.Fatalf("Failed to initialize certificate authority: %v", err)
 	}
+	ca.SetDistributionURLs(cfg.CA.CRLURLs, cfg.CA.OCSPURLs, cfg.CA.IssuerURLs)
+	if cfg.CA.PseudonymURIs {
+		ca.SetPseudonymPolicy(certmanager.AllowWellFormedPseudonyms)
+	}
+	ca.SetValidityInheritance(cfg.CA.InheritValidity)
+	ca.SetIssuedKeyAlgorithm(cfg.CA.IssuedKeyAlgorithm)
+	// Check the persistent stores' files before they are loaded
+	var checker *recovery.Checker
+	if cfg.Recovery.Enabled {
+		checker = checkStoreFiles(cfg)
+	}
+	registry, err := certmanager.NewIssuanceRegistry(cfg.CA.RegistryPath)
+	if err != nil {
+		log.Fatalf("Failed to load issuance registry: %v", err)
+	}
+	ca.SetIssuanceRegistry(registry)
 
-	// Initialize revocation manager
+	// Initialize revocation manager with the referrals issued so far
 	revocationMgr := certmanager.NewRevocationManager()
+	revocationMgr.SetReferralLimit(cfg.CA.MaxReferrals)
+	registry.RestoreReferrals(revocationMgr)
+	metrics.Default.GaugeFunc("anono_referrals", "Referral edges kept for cascading revocation",
+		func() float64 { return float64(revocationMgr.ReferralCount()) })
+
+	// Open the SQL database if a backend keeps its state there
+	database, err := openDatabase(cfg)
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
 
 	// Initialize bin manager with power-of-2 bin masking
-	binMgr := binmanager.NewBinManager(
-		cfg.BinManager.InitialMask,
-		cfg.BinManager.MessageRetention,
-	)
+	binMgr, binStore, closeBinStore, err := setupBinManager(cfg, database)
+	if err != nil {
+		log.Fatalf("Failed to initialize bin manager: %v", err)
+	}
+	binMgr.SetRetentionBounds(cfg.BinManager.MinBinRetention, cfg.BinManager.MaxBinRetention)
+	binMgr.SetPresenceThreshold(cfg.WebSocket.PresenceThreshold)
 
 	// Initialize key store
 	keyStore := keystore.NewEncryptedKeyStore()
+	if cfg.KeyStore.Storage == "sql" {
+		keyStore = keystore.NewEncryptedKeyStoreWithBackend(database.KeyBackend())
+	} else if cfg.KeyStore.Path != "" {
+		keyStore, err = keystore.OpenEncryptedKeyStore(cfg.KeyStore.Path)
+		if err != nil {
+			log.Fatalf("Failed to open key store: %v", err)
+		}
+	}
+	keyStore.SetQuotaPolicy(keyQuotaPolicy(cfg, revocationMgr))
+
+	var recoveryReport *recovery.Report
+	if checker != nil {
+		// A sealed store keys its bins by pseudonym, which no mask describes
+		mask := binMgr.GetCurrentMask()
+		if cfg.BinManager.EncryptionKey != "" || cfg.BinManager.EncryptionKeyFile != "" {
+			mask = ^uint64(0)
+		}
+		recoveryReport = finishRecoveryCheck(cfg, checker, binStore, mask, registry, keyStore, revocationMgr)
+	}
 
+	// Trust anchors for client certificates: our CA plus any in the trust directory
+	caCert, err := ca.GetCACertificate()
+	if err != nil {
+		log.Fatalf("Failed to load CA certificate: %v", err)
+	}
+	trustStore := certmanager.NewTrustStore(cfg.CA.TrustDir, caCert)
+	if _, _, err := trustStore.Reload(); err != nil {
+		log.Printf("Failed to load trust anchors: %v", err)
+	}
+	
+	// Emergency blocks and admin pins by key, independent of the CA
+	fingerprints, err := certmanager.NewFingerprintList(cfg.CA.FingerprintListPath)
+	if err != nil {
+		log.Fatalf("Failed to load fingerprint list: %v", err)
+	}
+	
 	// Setup TLS config for client certificate authentication
-	tlsConfig, err := setupTLSConfig(ca, revocationMgr)
+	tlsBuilder := tlsconfig.New(tlsPolicy(cfg.TLS.Server)).
+		WithTrustStore(trustStore).
+		WithRevocation(revocationMgr).
+		WithFingerprints(fingerprints).
+		IdentifyClients()
+	if cfg.SubscribePolicy.RevokedReferrers == "read_only" {
+		tlsBuilder.AllowRevokedReferrers()
+	}
+	tlsConfig, err := tlsBuilder.Build()
 	if err != nil {
-		log.Fatalf("Failed to setup TLS config: %v", err)
+		log.Fatalf("Invalid server TLS policy: %v", err)
+	}
+
+	// Optional server subsystems
+	opts := []server.Option{
+		server.WithMetrics(metrics.Default),
+		server.WithTrustStore(trustStore),
+		server.WithFingerprintList(fingerprints),
+		server.WithMaxMessageSize(cfg.WebSocket.MaxMessageSize),
+		server.WithWebSocketBuffers(cfg.WebSocket.ReadBufferSize, cfg.WebSocket.WriteBufferSize),
+		server.WithWriteLimits(cfg.WebSocket.WriteTimeout, cfg.WebSocket.MaxPendingWrites),
+		server.WithSubscribeLimits(cfg.WebSocket.MaxSubscribeBins, cfg.WebSocket.MaxConnectionBins),
+		server.WithTransportPadding(cfg.WebSocket.PadBlock, cfg.WebSocket.PingJitter),
+		server.WithRequestLimits(cfg.Server.RequestTimeout, cfg.Server.MaxKeyRequestSize),
+		server.WithTimestampGranularity(cfg.WebSocket.TimestampGranularity, cfg.WebSocket.TimestampJitter),
+		server.WithInstance(cfg.Server.Instance.ID, cfg.Server.Instance.Region),
+		server.WithListenFamily(cfg.Server.IPFamily),
+		server.WithAdvertisedAddresses(cfg.Server.Advertise),
+		server.WithLegalHold(legalhold.Settings{
+			Storage:     cfg.BinManager.Storage,
+			Sealed:      cfg.BinManager.Storage != "memory" && (cfg.BinManager.EncryptionKey != "" || cfg.BinManager.EncryptionKeyFile != ""),
+			LogShipping: cfg.LogShipping.Enabled,
+		}),
+	}
+	if cfg.Server.ProxyProtocol.Enabled {
+		trusted, err := proxyproto.ParseTrusted(cfg.Server.ProxyProtocol.TrustedProxies)
+		if err != nil {
+			log.Fatalf("Invalid trusted proxies: %v", err)
+		}
+		opts = append(opts, server.WithProxyProtocol(trusted))
+	}
+	if cfg.Server.Handshakes.MaxConcurrent > 0 {
+		opts = append(opts, server.WithHandshakeLimit(tlslimit.Config{
+			MaxConcurrent:    cfg.Server.Handshakes.MaxConcurrent,
+			MaxQueued:        cfg.Server.Handshakes.MaxQueued,
+			QueueTimeout:     cfg.Server.Handshakes.QueueTimeout,
+			HandshakeTimeout: cfg.Server.Handshakes.Timeout,
+		}))
+	}
+	if cfg.WebSocket.PublishRate > 0 {
+		opts = append(opts, server.WithPublishLimiter(publishLimiter(cfg)))
+	}
+	if cfg.WebSocket.DeliveryThreshold > 0 {
+		opts = append(opts, server.WithDeliveryCounts(cfg.WebSocket.DeliveryThreshold))
+	}
+	if cfg.WebSocket.EphemeralSignatures != "off" {
+		opts = append(opts, server.WithEphemeralSigning(cfg.WebSocket.EphemeralSignatures == "required"))
+	}
+	opts = append(opts, publishPolicyOptions(cfg)...)
+	opts = append(opts, subscribePolicyOptions(cfg)...)
+	if cfg.Acme.Enabled {
+		enrollmentMgr := certmanager.NewEnrollmentManager(
+			ca,
+			cfg.Acme.OrderTTL,
+			cfg.Acme.InviteTTL,
+			cfg.Acme.ValidityDays,
+		)
+		enrollmentMgr.SetInviteLimit(cfg.Acme.MaxInvites)
+		enrollmentMgr.SetPendingLimit(cfg.Acme.MaxPending)
+		enrollmentMgr.SetOrderLimit(cfg.Acme.MaxOrders)
+		opts = append(opts, server.WithEnrollmentManager(enrollmentMgr))
+	}
+	if len(cfg.Admin.CertIDs) > 0 {
+		issuers, err := loadCertificates(cfg.Admin.TrustedIssuers)
+		if err != nil {
+			log.Fatalf("Failed to load trusted graph issuers: %v", err)
+		}
+		opts = append(opts, server.WithAdmins(cfg.Admin.CertIDs), server.WithGraphIssuers(issuers))
+		if cfg.Admin.PinnedOnly {
+			opts = append(opts, server.WithPinnedAdmins())
+		}
+	}
+	metrics.Default.GaugeFunc("anono_retained_bytes", "Retained message bytes, ciphertext plus envelope",
+		func() float64 { return float64(binMgr.Usage().Bytes) })
+	metrics.Default.GaugeFunc("anono_retained_messages", "Retained messages",
+		func() float64 { return float64(binMgr.Usage().Messages) })
+	metrics.Default.GaugeFunc("anono_bins", "Bins currently holding state",
+		func() float64 { return float64(binMgr.BinCount()) })
+	var history *metrics.History
+	if cfg.Stats.HistoryInterval > 0 {
+		history = setupStatsHistory(cfg)
+		opts = append(opts, server.WithStatsHistory(history))
+	}
+	var retentionCtl *binmanager.RetentionController
+	if cfg.BinManager.RetentionBudget > 0 {
+		retentionCtl = setupRetentionController(cfg, binMgr)
+		opts = append(opts, server.WithRetentionController(retentionCtl))
+	}
+	var maskCtl *binmanager.MaskController
+	if cfg.BinManager.AutoscaleSubscribers > 0 || cfg.BinManager.AutoscaleRate > 0 {
+		maskCtl = setupMaskController(cfg, binMgr)
+		opts = append(opts, server.WithMaskController(maskCtl))
+	}
+	if recoveryReport != nil {
+		opts = append(opts, server.WithRecoveryReport(*recoveryReport))
+	}
+	if cfg.WarrantCanary.PublicKey != "" {
+		store, err := setupWarrantCanary(cfg)
+		if err != nil {
+			log.Fatalf("Failed to load warrant canary: %v", err)
+		}
+		opts = append(opts, server.WithWarrantCanary(store))
+	}
+	var compactor *binmanager.Compactor
+	if binStore != nil && cfg.BinManager.CompactionInterval > 0 {
+		compactor = setupCompactor(cfg, binMgr, binStore)
+		opts = append(opts, server.WithCompactor(compactor))
+	}
+	if cfg.Bandwidth.Enabled {
+		opts = append(opts, server.WithBandwidthClasses(bandwidthClasses(cfg)))
+	}
+	if len(cfg.Auth.Rules) > 0 {
+		opts = append(opts, server.WithAuthRules(authRules(cfg)))
+	}
+	if len(cfg.Features.Flags) > 0 {
+		flags, err := setupFeatures(cfg)
+		if err != nil {
+			log.Fatalf("Failed to load feature flags: %v", err)
+		}
+		opts = append(opts, server.WithFeatures(flags))
+	}
+	if len(cfg.Plugins.Enabled) > 0 {
+		plugins, err := setupPlugins(cfg)
+		if err != nil {
+			log.Fatalf("Failed to load plugins: %v (compiled in: %v)", err, plugin.Names())
+		}
+		opts = append(opts, server.WithPlugins(cfg.Plugins.QueueSize, plugins...))
+	}
+	if cfg.Tor.Enabled {
+		address, err := onionAddress(cfg)
+		if err != nil {
+			log.Fatalf("Failed to load onion address: %v", err)
+		}
+		opts = append(opts, server.WithOnionService(address, cfg.Tor.Port, cfg.Tor.AltSvcMaxAge))
+	}
+	if cfg.SMTP.Enabled {
+		opts = append(opts, server.WithSMTPGateway(cfg.SMTP.Address, cfg.SMTP.Channel, smtpgate.Config{
+			Hostname:       cfg.SMTP.Hostname,
+			MaxSize:        cfg.SMTP.MaxSize,
+			Recipients:     cfg.SMTP.Recipients,
+			MaxConnections: cfg.SMTP.MaxConnections,
+			Timeout:        cfg.SMTP.Timeout,
+		}))
+	}
+	if cfg.Bulletin.Enabled {
+		board, err := bulletin.Open(cfg.Bulletin.Path, cfg.Bulletin.Channels)
+		if err != nil {
+			log.Fatalf("Failed to open bulletin logs: %v", err)
+		}
+		opts = append(opts, server.WithBulletinBoard(board))
+	}
+	var pushDispatcher *push.Dispatcher
+	if cfg.Push.Enabled {
+		pushDispatcher, err = setupPush(cfg)
+		if err != nil {
+			log.Fatalf("Failed to set up push: %v", err)
+		}
+		opts = append(opts, server.WithPush(pushDispatcher))
+	}
+	if cfg.Directory.Enabled {
+		opts = append(opts, server.WithDirectory(directory.New(keystore.NewEncryptedKeyStore())))
+	}
+	if cfg.ClientUpdate.Enabled {
+		opts = append(opts, server.WithClientUpdate(clientUpdate(cfg)))
+	}
+	opts = append(opts, chaosOptions()...)
+	if cfg.Discovery.Enabled {
+		limiter := ratelimit.NewTokenBucket(cfg.Discovery.RateLimit, cfg.Discovery.Burst)
+		discoveryTLS, err := tlsconfig.New(tlsPolicy(cfg.TLS.Discovery)).
+			WithTrustStore(trustStore).
+			WithRevocation(revocationMgr).
+			WithFingerprints(fingerprints).
+			Build()
+		if err != nil {
+			log.Fatalf("Invalid discovery TLS policy: %v", err)
+		}
+		opts = append(opts,
+			server.WithDiscoveryListener(cfg.Discovery.Address, limiter),
+			server.WithDiscoveryTLS(discoveryTLS),
+		)
 	}
 
 	// Initialize server
 	srv := server.NewServer(
-		cfg.Server.Address,
+		cfg.Server.ListenAddress,
 		tlsConfig,
 		binMgr,
 		revocationMgr,
 		ca,
 		keyStore,
+		opts...,
 	)
 
 	// Start message cleanup service
 	binMgr.StartCleanupService(time.Minute)
+	trustStore.Watch(cfg.CA.TrustReload)
+	if retentionCtl != nil {
+		retentionCtl.Start(cfg.BinManager.PressureInterval)
+	}
+	if maskCtl != nil {
+		maskCtl.Start(cfg.BinManager.AutoscaleInterval)
+	}
+	if compactor != nil {
+		compactor.Start(cfg.BinManager.CompactionInterval)
+	}
+	if history != nil {
+		history.Start()
+	}
+	if pushDispatcher != nil {
+		pushDispatcher.Start()
+	}
+	var probe *canary.Probe
+	if cfg.Canary.Enabled {
+		if probe, err = setupCanary(cfg, ca, caCert); err != nil {
+			log.Fatalf("Failed to set up canary probe: %v", err)
+		}
+	}
 
 	// Start the server
-	log.Printf("Starting secure messaging server on %s", cfg.Server.Address)
+	log.Printf("Starting secure messaging server on %s", cfg.Server.ListenAddress)
 	go func() {
 		if err := srv.Start(); err != nil {
 			log.Fatalf("Server failed: %v", err)
 		}
 	}()
+	if probe != nil {
+		probe.Start()
+	}
 
 	// Wait for termination signal
 	quit := make(chan os.Signal, 1)
@@ -85,6 +394,9 @@ func main() {
 
 	// Graceful shutdown
 	log.Println("Shutting down server...")
+	if probe != nil {
+		probe.Stop()
+	}
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
@@ -92,45 +404,645 @@ func main() {
 		log.Fatalf("Server forced to shutdown: %v", err)
 	}
 
+	if retentionCtl != nil {
+		retentionCtl.Stop()
+	}
+	if maskCtl != nil {
+		maskCtl.Stop()
+	}
+	if compactor != nil {
+		compactor.Stop()
+	}
+	if history != nil {
+		history.Stop()
+	}
+	if pushDispatcher != nil {
+		pushDispatcher.Stop()
+	}
+	trustStore.Stop()
+	binMgr.Stop()
+	closeBinStore()
+	if database != nil {
+		if err := database.Close(); err != nil {
+			log.Printf("Failed to close database: %v", err)
+		}
+	}
+
 	log.Println("Server exited properly")
+	closeLogs()
 }
 
-func setupTLSConfig(ca *certmanager.CertificateAuthority, rm *certmanager.RevocationManager) (*tls.Config, error) {
-	// Load CA certificate
-	caCert, err := ca.GetCACertificate()
-	if err != nil {
-		return nil, err
+// setupStatsHistory records the server metrics shown on the admin dashboard.
+// The server registers its own counters when it is created, so tracking them
+// by name here is fine; samples start once Start is called.
+func setupStatsHistory(cfg *config.Config) *metrics.History {
+	history := metrics.NewHistory(metrics.Default, cfg.Stats.HistoryInterval, cfg.Stats.HistoryRetention)
+	history.Track(
+		"anono_websocket_connections",
+		"anono_messages_published_total",
+		"anono_certificates_issued_total",
+		"anono_bins",
+		"anono_retained_messages",
+		"anono_retained_bytes",
+	)
+	return history
+}
+
+// diskBinStore is a storage backend that keeps messages across restarts
+type diskBinStore interface {
+	ForBin(binID uint64) binmanager.BinStore
+	Bins() ([]uint64, error)
+	Close() error
+}
+
+// sharedBinStore is a disk backend whose database is also used by others;
+// the bin manager leaves closing it to main
+type sharedBinStore struct {
+	diskBinStore
+}
+
+// Close leaves the database open
+func (sharedBinStore) Close() error {
+	return nil
+}
+
+// openDatabase opens the SQL database, migrating its schema, if the bin
+// manager or the key store is configured to keep its state there
+func openDatabase(cfg *config.Config) (*sqlstore.Store, error) {
+	if cfg.BinManager.Storage != "sql" && cfg.KeyStore.Storage != "sql" {
+		return nil, nil
 	}
+	return sqlstore.Open(cfg.Database.DSN)
+}
+
+// setupBinManager creates the bin manager for the configured storage backend
+// and returns the LevelDB store, if any, and a function that flushes or
+// closes the backend on shutdown. Bins kept by a disk backend are restored
+// with their retained messages.
+func setupBinManager(cfg *config.Config, database *sqlstore.Store) (*binmanager.BinManager, *binmanager.LevelDBStore, func(), error) {
+	snapshotPath := cfg.BinManager.SnapshotPath
 
-	// Create certificate pool with our CA
-	caPool := x509.NewCertPool()
-	caPool.AddCert(caCert)
+	if cfg.BinManager.Storage == "memory" {
+		binMgr := binmanager.NewBinManagerWithStore(
+			cfg.BinManager.InitialMask,
+			cfg.BinManager.MessageRetention,
+			wrapStoreFactory(nil),
+		)
 
-	return &tls.Config{
-		ClientCAs:  caPool,
-		ClientAuth: tls.RequireAndVerifyClientCert,
-		MinVersion: tls.VersionTLS13,
-		VerifyPeerCertificate: func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
-			// Custom verification including revocation check
-			if len(verifiedChains) == 0 || len(verifiedChains[0]) == 0 {
-				return nil // Standard verification already failed
+		// Preserve in-memory state for the next instance if requested
+		closeFn := func() {
+			if snapshotPath == "" {
+				return
 			}
-			
-			cert := verifiedChains[0][0]
-			certID := cert.SerialNumber.String()
-			
-			// Check if certificate is revoked
-			if rm.IsRevoked(certID) {
-				return certmanager.ErrCertificateRevoked
+			f, err := os.Create(snapshotPath)
+			if err != nil {
+				log.Printf("Failed to create snapshot: %v", err)
+				return
 			}
-			
-			// Check if referrer is revoked
-			referrerID, err := certmanager.ExtractReferrerID(cert)
-			if err == nil && referrerID != "" && rm.IsRevoked(referrerID) {
-				return certmanager.ErrReferrerRevoked
+			defer f.Close()
+
+			count, err := binMgr.WriteSnapshot(f)
+			if err != nil {
+				log.Printf("Failed to write snapshot: %v", err)
+				return
 			}
-			
-			return nil
-		},
+			log.Printf("Wrote %d retained messages to %s", count, snapshotPath)
+		}
+		return binMgr, nil, closeFn, nil
+	}
+
+	var store diskBinStore
+	var levelDB *binmanager.LevelDBStore
+	var err error
+	switch cfg.BinManager.Storage {
+	case "leveldb":
+		levelDB, err = binmanager.OpenLevelDBStore(cfg.BinManager.StoragePath)
+		store = levelDB
+	case "bolt":
+		store, err = binmanager.OpenBoltStore(cfg.BinManager.StoragePath)
+	case "sql":
+		store = sharedBinStore{database}
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	// Seal messages before they reach the backend
+	sealer, err := setupSealer(cfg)
+	if err != nil {
+		store.Close()
+		return nil, nil, nil, err
+	}
+	factory := sealer.Wrap(store.ForBin)
+
+	// Import a snapshot left behind by an in-memory instance
+	if snapshotPath != "" {
+		if f, err := os.Open(snapshotPath); err == nil {
+			_, count, err := binmanager.ImportSnapshot(f, factory)
+			f.Close()
+			if err != nil {
+				store.Close()
+				return nil, nil, nil, err
+			}
+			if err := os.Rename(snapshotPath, snapshotPath+".imported"); err != nil {
+				log.Printf("Failed to rename imported snapshot: %v", err)
+			}
+			log.Printf("Imported %d messages from %s", count, snapshotPath)
+		}
+	}
+
+	if cfg.BinManager.RecentCacheMessages > 0 {
+		factory = setupRecentCache(cfg).Wrap(factory)
+	}
+	binMgr := binmanager.NewBinManagerWithStore(
+		cfg.BinManager.InitialMask,
+		cfg.BinManager.MessageRetention,
+		wrapStoreFactory(factory),
+	)
+
+	binIDs, err := store.Bins()
+	if err != nil {
+		store.Close()
+		return nil, nil, nil, err
+	}
+	binMgr.RestoreBins(sealer.BinIDs(binIDs))
+
+	closeFn := func() {
+		if err := store.Close(); err != nil {
+			log.Printf("Failed to close bin store: %v", err)
+		}
+	}
+	return binMgr, levelDB, closeFn, nil
+}
+
+// setupSealer returns the sealer for the configured encryption key. Disk
+// stores are never left unsealed, so a missing or empty key is an error.
+func setupSealer(cfg *config.Config) (*binmanager.Sealer, error) {
+	encoded := cfg.BinManager.EncryptionKey
+	if cfg.BinManager.EncryptionKeyFile != "" {
+		data, err := os.ReadFile(cfg.BinManager.EncryptionKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		encoded = strings.TrimSpace(string(data))
+	}
+	if encoded == "" {
+		return nil, fmt.Errorf("bin_manager.encryption: no key configured")
+	}
+
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("bin_manager.encryption: %w", err)
+	}
+	sealer, err := binmanager.NewSealer(key)
+	if err != nil {
+		return nil, err
+	}
+	sealer.SetBucket(cfg.BinManager.EncryptionBucket)
+	return sealer, nil
+}
+
+// setupRecentCache creates the in-memory cache of recent messages in front
+// of the disk bin store and records its lookups in the metrics
+func setupRecentCache(cfg *config.Config) *binmanager.RecentCache {
+	hits := metrics.Default.Counter("anono_recent_cache_hits_total", "History replays served from the recent message cache")
+	misses := metrics.Default.Counter("anono_recent_cache_misses_total", "History replays that read the bin store")
+
+	cache := binmanager.NewRecentCache(cfg.BinManager.RecentCacheMessages, cfg.BinManager.RecentCacheBins)
+	cache.OnLookup(func(hit bool) {
+		if hit {
+			hits.Inc()
+		} else {
+			misses.Inc()
+		}
+	})
+	metrics.Default.GaugeFunc("anono_recent_cache_messages", "Messages held in the recent message cache", func() float64 {
+		return float64(cache.Stats().Messages)
+	})
+	return cache
+}
+
+// setupLogShipping copies the standard logger's output to an encrypted log
+// sink and returns a function that flushes it
+func setupLogShipping(cfg *config.Config) (func(), error) {
+	if !cfg.LogShipping.Enabled {
+		return func() {}, nil
+	}
+
+	recipient, err := logship.ParsePublicKey(cfg.LogShipping.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var shipper logship.Shipper
+	if cfg.LogShipping.Destination == "http" {
+		shipper = logship.NewHTTPShipper(cfg.LogShipping.URL, nil)
+	} else {
+		shipper, err = logship.NewFileShipper(cfg.LogShipping.Directory,
+			cfg.LogShipping.MaxFileBytes, cfg.LogShipping.MaxFiles)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sink, err := logship.NewSink(recipient, shipper, logship.Config{
+		BatchBytes:    cfg.LogShipping.BatchBytes,
+		FlushInterval: cfg.LogShipping.FlushInterval,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.SetOutput(io.MultiWriter(os.Stderr, sink))
+	return func() {
+		log.SetOutput(os.Stderr)
+		if err := sink.Close(); err != nil {
+			log.Printf("Failed to close log sink: %v", err)
+		}
 	}, nil
-}
\ No newline at end of file
+}
+
+// publishLimiter builds the per-certificate publish limiter. The Redis
+// window admits the burst once per the time the rate takes to refill it,
+// falling back to a local bucket while Redis is unreachable.
+func publishLimiter(cfg *config.Config) ratelimit.Limiter {
+	local := ratelimit.NewTokenBucket(cfg.WebSocket.PublishRate, cfg.WebSocket.PublishBurst)
+	if cfg.RateLimit.Backend != "redis" {
+		return local
+	}
+	burst := cfg.WebSocket.PublishBurst
+	if burst < 1 {
+		burst = 1
+	}
+	window := time.Duration(float64(burst) / cfg.WebSocket.PublishRate * float64(time.Second))
+	return ratelimit.NewRedisWindow(ratelimit.RedisConfig{
+		Address:  cfg.RateLimit.RedisAddress,
+		Password: cfg.RateLimit.RedisPassword,
+		Prefix:   cfg.RateLimit.KeyPrefix + "publish:",
+		Timeout:  cfg.RateLimit.Timeout,
+	}, burst, window, local)
+}
+
+// clientUpdate builds the update manifest from the configuration
+func clientUpdate(cfg *config.Config) protocol.ClientUpdate {
+	manifest := protocol.ClientUpdate{
+		MinProtocolVersion:         cfg.ClientUpdate.MinProtocolVersion,
+		RecommendedProtocolVersion: cfg.ClientUpdate.RecommendedProtocolVersion,
+	}
+	for _, download := range cfg.ClientUpdate.Downloads {
+		manifest.Downloads = append(manifest.Downloads, protocol.ClientDownload{
+			Platform:        download.Platform,
+			Version:         download.Version,
+			ProtocolVersion: download.ProtocolVersion,
+			URL:             download.URL,
+			SHA256:          download.SHA256,
+		})
+	}
+	return manifest
+}
+
+// bandwidthClasses converts the configured bandwidth classes, in name order
+func bandwidthClasses(cfg *config.Config) []server.BandwidthClass {
+	names := make([]string, 0, len(cfg.Bandwidth.Classes))
+	for name := range cfg.Bandwidth.Classes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	classes := make([]server.BandwidthClass, 0, len(names))
+	for _, name := range names {
+		class := cfg.Bandwidth.Classes[name]
+		classes = append(classes, server.BandwidthClass{
+			Name:            name,
+			CertIDs:         class.CertIDs,
+			ConnectionRate:  class.ConnectionRate,
+			ConnectionBurst: class.ConnectionBurst,
+			ClassRate:       class.ClassRate,
+			ClassBurst:      class.ClassBurst,
+		})
+	}
+	return classes
+}
+
+// authRules converts the configured per-endpoint authentication rules
+func authRules(cfg *config.Config) []server.AuthRule {
+	rules := make([]server.AuthRule, 0, len(cfg.Auth.Rules))
+	for _, rule := range cfg.Auth.Rules {
+		rules = append(rules, server.AuthRule{
+			Path:       rule.Path,
+			ClientCert: rule.ClientCert,
+			Roles:      rule.Roles,
+		})
+	}
+	return rules
+}
+
+// onionAddress returns the configured onion address, or the one Tor wrote
+// to the hidden service's hostname file
+func onionAddress(cfg *config.Config) (string, error) {
+	if cfg.Tor.OnionAddress != "" {
+		return onion.ParseAddress(cfg.Tor.OnionAddress)
+	}
+	return onion.ReadHostname(cfg.Tor.HostnameFile)
+}
+
+// setupPush loads the push registry and creates a dispatcher with the
+// UnifiedPush provider and, if a gateway is configured, the webhook provider
+func setupPush(cfg *config.Config) (*push.Dispatcher, error) {
+	key, err := base64.StdEncoding.DecodeString(cfg.Push.TokenKey)
+	if err != nil {
+		return nil, err
+	}
+	registry, err := push.NewRegistry(cfg.Push.RegistryPath, key)
+	if err != nil {
+		return nil, err
+	}
+
+	dispatcher := push.NewDispatcher(registry, cfg.Push.MinInterval)
+	dispatcher.AddProvider(push.ProviderUnifiedPush, push.NewUnifiedPush(nil, cfg.Push.UnifiedPushHosts...))
+	if cfg.Push.WebhookURL != "" {
+		dispatcher.AddProvider(push.ProviderWebhook, push.NewWebhook(cfg.Push.WebhookURL, nil))
+	}
+	return dispatcher, nil
+}
+
+// setupFeatures declares the configured feature flags
+func setupFeatures(cfg *config.Config) (*features.Set, error) {
+	flags := make([]features.Flag, 0, len(cfg.Features.Flags))
+	for _, flag := range cfg.Features.Flags {
+		flags = append(flags, features.Flag{
+			Name:    flag.Name,
+			Percent: flag.Percent,
+			Classes: flag.Classes,
+			CertIDs: flag.CertIDs,
+		})
+	}
+	return features.NewSet(server.FeatureFlags, flags)
+}
+
+// setupPlugins creates the enabled lifecycle event plugins. Plugins are
+// linked into the binary by blank imports of their packages.
+func setupPlugins(cfg *config.Config) ([]plugin.Plugin, error) {
+	plugins := make([]plugin.Plugin, 0, len(cfg.Plugins.Enabled))
+	for _, enabled := range cfg.Plugins.Enabled {
+		p, err := plugin.New(enabled.Name, enabled.Settings)
+		if err != nil {
+			return nil, err
+		}
+		plugins = append(plugins, p)
+		log.Printf("Plugin %s enabled", enabled.Name)
+	}
+	return plugins, nil
+}
+
+// setupRetentionController creates the adaptive retention controller and
+// reports its adjustments as metrics
+func setupRetentionController(cfg *config.Config, binMgr *binmanager.BinManager) *binmanager.RetentionController {
+	effective := metrics.Default.Gauge("anono_retention_effective_seconds", "Effective message retention")
+	reductions := metrics.Default.Counter("anono_retention_reductions_total", "Retention reductions under storage pressure")
+	restores := metrics.Default.Counter("anono_retention_restores_total", "Retention restores after pressure subsided")
+
+	effective.Set(binMgr.Retention().Seconds())
+
+	rc := binmanager.NewRetentionController(binMgr, cfg.BinManager.RetentionBudget, cfg.BinManager.RetentionFloor)
+	rc.OnEvent(func(event binmanager.RetentionEvent) {
+		effective.Set(float64(event.RetentionSeconds))
+		if event.Action == binmanager.RetentionReduced {
+			reductions.Inc()
+		} else {
+			restores.Inc()
+		}
+	})
+	return rc
+}
+
+// setupWarrantCanary loads the operator-signed warrant canary, warning if
+// it is missing or already stale
+func setupWarrantCanary(cfg *config.Config) (*warrant.Store, error) {
+	key, err := warrant.ParsePublicKey(cfg.WarrantCanary.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	store, err := warrant.Open(cfg.WarrantCanary.Path, key)
+	if err != nil {
+		return nil, err
+	}
+	if status := store.Status(time.Now()); !status.Present {
+		log.Printf("Warning: no warrant canary published yet; publish one with admin warrant-publish")
+	} else if status.Stale {
+		log.Printf("Warning: warrant canary went stale at %s", status.NextUpdate.Format(time.RFC3339))
+	}
+	return store, nil
+}
+
+// setupMaskController creates the bin mask autoscaler and reports its
+// changes as metrics. It contracts no further than the initial mask unless
+// configured otherwise.
+func setupMaskController(cfg *config.Config, binMgr *binmanager.BinManager) *binmanager.MaskController {
+	maskBits := metrics.Default.Gauge("anono_bin_mask_bits", "Bits set in the bin mask")
+	expansions := metrics.Default.Counter("anono_bin_mask_expansions_total", "Bin mask expansions under load")
+	contractions := metrics.Default.Counter("anono_bin_mask_contractions_total", "Bin mask contractions after load subsided")
+
+	maskBits.Set(float64(bits.OnesCount64(binMgr.GetCurrentMask())))
+
+	minBits := cfg.BinManager.AutoscaleMinBits
+	if minBits == 0 {
+		minBits = bits.OnesCount64(cfg.BinManager.InitialMask)
+	}
+	mc := binmanager.NewMaskController(binMgr, binmanager.MaskLimits{
+		MaxSubscribers: cfg.BinManager.AutoscaleSubscribers,
+		MaxRate:        cfg.BinManager.AutoscaleRate,
+		MinBits:        minBits,
+		MaxBits:        cfg.BinManager.AutoscaleMaxBits,
+	})
+	mc.OnEvent(func(event binmanager.MaskEvent) {
+		maskBits.Set(float64(bits.OnesCount64(binMgr.GetCurrentMask())))
+		if event.Action == binmanager.MaskExpanded {
+			expansions.Inc()
+		} else {
+			contractions.Inc()
+		}
+	})
+	return mc
+}
+
+// checkStoreFiles checks the issuance registry and key store files,
+// quarantining broken records if configured. It exits if a record that
+// would stop a store from loading was left in place.
+func checkStoreFiles(cfg *config.Config) *recovery.Checker {
+	quarantineDir := ""
+	if cfg.Recovery.Quarantine {
+		quarantineDir = cfg.Recovery.QuarantineDir
+	}
+	checker := recovery.NewChecker(quarantineDir)
+	if err := checker.CheckRegistryFile(cfg.CA.RegistryPath); err != nil {
+		log.Fatalf("Failed to check issuance registry: %v", err)
+	}
+	if err := checker.CheckKeyStoreFile(cfg.KeyStore.Path); err != nil {
+		log.Fatalf("Failed to check key store: %v", err)
+	}
+	if report := checker.Report(); report.Failed() {
+		emitRecoveryReport(cfg, report)
+		log.Fatalf("Corrupt records in the persistent stores; enable recovery.quarantine to start without them")
+	}
+	return checker
+}
+
+// finishRecoveryCheck checks the bin store and the loaded stores against
+// each other, then emits the report
+func finishRecoveryCheck(
+	cfg *config.Config,
+	checker *recovery.Checker,
+	binStore *binmanager.LevelDBStore,
+	mask uint64,
+	registry *certmanager.IssuanceRegistry,
+	keyStore *keystore.EncryptedKeyStore,
+	revocationMgr *certmanager.RevocationManager,
+) *recovery.Report {
+	if binStore != nil {
+		if err := checker.CheckBinStore(binStore, mask); err != nil {
+			log.Fatalf("Failed to check bin store: %v", err)
+		}
+	}
+	if cfg.CA.RegistryPath != "" {
+		checker.CrossCheck(registry, keyStore, revocationMgr)
+	}
+	report := checker.Report()
+	emitRecoveryReport(cfg, report)
+	return &report
+}
+
+// emitRecoveryReport logs a recovery report and writes it to the report path
+func emitRecoveryReport(cfg *config.Config, report recovery.Report) {
+	checked := 0
+	for _, count := range report.Checked {
+		checked += count
+	}
+	log.Printf("Recovery check: %d records checked, %d problems, %d quarantined",
+		checked, len(report.Problems), report.Quarantined())
+	for _, problem := range report.Problems {
+		log.Printf("Recovery check: %s %s: %s %s", problem.Store, problem.Record, problem.Issue, problem.Detail)
+	}
+	if cfg.Recovery.ReportPath == "" {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(cfg.Recovery.ReportPath), 0700); err != nil {
+		log.Printf("Failed to write recovery report: %v", err)
+		return
+	}
+	if err := report.WriteFile(cfg.Recovery.ReportPath); err != nil {
+		log.Printf("Failed to write recovery report: %v", err)
+	}
+}
+
+// setupCompactor creates the compactor of the LevelDB bin store and records
+// each pass in the metrics
+func setupCompactor(cfg *config.Config, binMgr *binmanager.BinManager, store *binmanager.LevelDBStore) *binmanager.Compactor {
+	duration := metrics.Default.Gauge("anono_compaction_duration_seconds", "Duration of the last bin store compaction")
+	reclaimed := metrics.Default.Counter("anono_compaction_reclaimed_bytes_total", "Disk bytes reclaimed by bin store compaction")
+	evicted := metrics.Default.Counter("anono_compaction_evicted_messages_total", "Messages evicted to keep the bin store within its disk budget")
+	metrics.Default.GaugeFunc("anono_bin_store_disk_bytes", "Disk bytes used by the bin store", func() float64 {
+		usage, _ := store.DiskUsage()
+		return float64(usage)
+	})
+
+	c := binmanager.NewCompactor(binMgr, store, cfg.BinManager.MaxDiskBytes)
+	c.OnCompaction(func(stats binmanager.CompactionStats) {
+		duration.Set(stats.Duration.Seconds())
+		reclaimed.Add(uint64(stats.ReclaimedBytes))
+		evicted.Add(uint64(stats.Evicted))
+	})
+	return c
+}
+
+// keyQuotaPolicy builds the configured key storage quotas, counting and
+// logging every write left past a soft limit
+func keyQuotaPolicy(cfg *config.Config, revocationMgr *certmanager.RevocationManager) *keystore.QuotaPolicy {
+	warnings := metrics.Default.Counter("anono_keystore_soft_quota_warnings_total", "Key writes that left a certificate or referral tree past its soft quota")
+	return &keystore.QuotaPolicy{
+		PerCertificate: keystore.Quota(cfg.KeyStore.CertificateQuota),
+		PerSubtree:     keystore.Quota(cfg.KeyStore.SubtreeQuota),
+		Subtree:        revocationMgr.ReferralTree,
+		OnSoftLimit: func(status keystore.QuotaStatus) {
+			warnings.Inc()
+			log.Printf("Key storage of %s %s past its soft quota: %d slots, %d bytes",
+				status.Scope, status.CertID, status.Usage.Slots, status.Usage.Bytes)
+		},
+	}
+}
+
+// loadCertificates reads PEM certificates from the given paths
+func loadCertificates(paths []string) ([]*x509.Certificate, error) {
+	certs := make([]*x509.Certificate, 0, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		cert, err := certmanager.ParseCertificatePEM(data)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+// publishPolicyOptions builds the configured publish authorization policies
+func publishPolicyOptions(cfg *config.Config) []server.Option {
+	var opts []server.Option
+	if len(cfg.PublishPolicy.BinACL) > 0 {
+		acl := authz.NewBinACL()
+		for binID, certIDs := range cfg.PublishPolicy.BinACL {
+			acl.Restrict(binID, certIDs...)
+		}
+		opts = append(opts, server.WithPublishAuthorizer(acl))
+	}
+	if len(cfg.PublishPolicy.SizeBuckets) > 0 {
+		opts = append(opts, server.WithPublishAuthorizer(authz.SizeBuckets(cfg.PublishPolicy.SizeBuckets...)))
+	}
+	if cfg.PublishPolicy.ThreadTagSize > 0 || cfg.PublishPolicy.ReplyToIDSize > 0 {
+		opts = append(opts, server.WithPublishAuthorizer(authz.TagValidator{
+			ThreadTagSize: cfg.PublishPolicy.ThreadTagSize,
+			ReplyToIDSize: cfg.PublishPolicy.ReplyToIDSize,
+		}))
+	}
+	if cfg.PublishPolicy.NewBinPoWBits > 0 {
+		publishers := cfg.PublishPolicy.PublisherIDs
+		if cfg.SMTP.Enabled {
+			// Tips cannot carry a proof of work
+			publishers = append(publishers, server.SMTPGatewayID)
+		}
+		opts = append(opts, server.WithBinCreationPoW(cfg.PublishPolicy.NewBinPoWBits, publishers))
+	}
+	if cfg.PublishPolicy.TrustedTimestampSkew > 0 {
+		opts = append(opts, server.WithIngestOptions(binmanager.WithTrustedTimestamps(cfg.PublishPolicy.TrustedTimestampSkew)))
+	}
+	return opts
+}
+
+// subscribePolicyOptions builds the configured subscribe authorization
+// policies, some of which also restrict publishing
+func subscribePolicyOptions(cfg *config.Config) []server.Option {
+	var opts []server.Option
+	if cfg.SubscribePolicy.RevokedReferrers == "read_only" {
+		opts = append(opts, server.WithRevokedReferrerReadOnly())
+	}
+	if len(cfg.SubscribePolicy.ReadOnlyCertIDs) > 0 {
+		readOnly := authz.NewReadOnly(cfg.SubscribePolicy.ReadOnlyMaxBins, cfg.SubscribePolicy.ReadOnlyCertIDs...)
+		opts = append(opts, server.WithPublishAuthorizer(readOnly), server.WithSubscribeAuthorizer(readOnly))
+	}
+	return opts
+}
+
+// tlsPolicy converts a configured listener policy
+func tlsPolicy(listener config.TLSListener) tlsconfig.Policy {
+	return tlsconfig.Policy{
+		MinVersion:     listener.MinVersion,
+		CipherSuites:   listener.CipherSuites,
+		Curves:         listener.Curves,
+		SessionTickets: listener.SessionTickets,
+		ClientAuth:     listener.ClientAuth,
+		ALPN:           listener.ALPN,
+	}
+}
@@ -0,0 +1,251 @@
+//go:build js && wasm
+
+// Command wasm exposes the client protocol core to browsers. Build it with
+//
+//	GOOS=js GOARCH=wasm go build -o anono.wasm ./cmd/wasm
+//
+// and load it with Go's wasm_exec.js. It registers a global "anono" object;
+// the page keeps its own WebSocket and fetch calls and uses these functions
+// for everything that must match native clients byte for byte. 64-bit IDs
+// and masks are passed as strings, since JavaScript numbers cannot hold
+// them, and binary values as base64. Functions throw on error.
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"syscall/js"
+
+	"github.com/yourusername/secure-messaging-poc/internal/keystore"
+	"github.com/yourusername/secure-messaging-poc/pkg/protocol"
+)
+
+func main() {
+	js.Global().Set("anono", js.ValueOf(map[string]interface{}{
+		"subscribeFrame": export(subscribeFrame),
+		"publishFrame":   export(publishFrame),
+		"decodeFrame":    export(decodeFrame),
+		"enrollment":     export(enrollment),
+		"generateSalt":   export(generateSalt),
+		"sealKey":        export(sealKey),
+		"openKey":        export(openKey),
+	}))
+
+	// Keep the exported functions alive
+	select {}
+}
+
+// export wraps a function so errors become JavaScript exceptions
+func export(fn func(args []js.Value) (interface{}, error)) js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		result, err := fn(args)
+		if err != nil {
+			panic(js.Global().Get("Error").New(err.Error()))
+		}
+		return result
+	})
+}
+
+// errArguments is returned when a function is called with too few arguments
+var errArguments = errors.New("anono: missing arguments")
+
+// subscribeFrame(clientID, channels[], mask) returns the subscribe frame as
+// JSON
+func subscribeFrame(args []js.Value) (interface{}, error) {
+	if len(args) < 3 {
+		return nil, errArguments
+	}
+	channels := make([]uint64, args[1].Length())
+	for i := range channels {
+		channelID, err := strconv.ParseUint(args[1].Index(i).String(), 0, 64)
+		if err != nil {
+			return nil, err
+		}
+		channels[i] = channelID
+	}
+	mask, err := protocol.ParseMask(args[2].String())
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(protocol.NewSubscribe(args[0].String(), channels, mask))
+	return string(data), err
+}
+
+// publishFrame({channel, mask, ciphertext, message_id, reply_to_id,
+// thread_tag, pow, ack, retention, retention_proof}) returns a message frame
+// as JSON
+func publishFrame(args []js.Value) (interface{}, error) {
+	if len(args) < 1 {
+		return nil, errArguments
+	}
+	in := args[0]
+
+	channelID, err := strconv.ParseUint(in.Get("channel").String(), 0, 64)
+	if err != nil {
+		return nil, err
+	}
+	mask, err := protocol.ParseMask(in.Get("mask").String())
+	if err != nil {
+		return nil, err
+	}
+	msg := protocol.Message{
+		BinID:     channelID & mask,
+		MessageID: optionalString(in, "message_id"),
+		ReplyToID: optionalString(in, "reply_to_id"),
+		Ack:       optionalString(in, "ack"),
+	}
+	if retention := in.Get("retention"); retention.Type() == js.TypeNumber {
+		msg.Retention = int64(retention.Int())
+	}
+	for field, target := range map[string]*[]byte{"ciphertext": &msg.Ciphertext, "thread_tag": &msg.ThreadTag, "pow": &msg.PoW, "retention_proof": &msg.RetentionProof} {
+		if *target, err = base64.StdEncoding.DecodeString(optionalString(in, field)); err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := json.Marshal(msg)
+	return string(data), err
+}
+
+// decodeFrame(json) returns {message}, {ack}, {publish_ack} or {error}; bin
+// IDs are strings
+func decodeFrame(args []js.Value) (interface{}, error) {
+	if len(args) < 1 {
+		return nil, errArguments
+	}
+	frame, err := protocol.DecodeFrame([]byte(args[0].String()))
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case frame.Message != nil:
+		return map[string]interface{}{"message": map[string]interface{}{
+			"bin_id":      strconv.FormatUint(frame.Message.BinID, 10),
+			"message_id":  frame.Message.MessageID,
+			"ciphertext":  base64.StdEncoding.EncodeToString(frame.Message.Ciphertext),
+			"reply_to_id": frame.Message.ReplyToID,
+			"thread_tag":  base64.StdEncoding.EncodeToString(frame.Message.ThreadTag),
+			"timestamp":   frame.Message.Timestamp.UnixMilli(),
+		}}, nil
+	case frame.Ack != nil:
+		return map[string]interface{}{"ack": map[string]interface{}{
+			"client_id": frame.Ack.ClientID,
+			"bin_count": frame.Ack.BinCount,
+		}}, nil
+	case frame.PublishAck != nil:
+		return map[string]interface{}{"publish_ack": map[string]interface{}{
+			"message_id": frame.PublishAck.MessageID,
+			"bin_id":     strconv.FormatUint(frame.PublishAck.BinID, 10),
+			"durable":    frame.PublishAck.Durable,
+			"duplicate":  frame.PublishAck.Duplicate,
+		}}, nil
+	default:
+		return map[string]interface{}{"error": map[string]interface{}{
+			"code":        frame.Error.Code,
+			"message":     frame.Error.Message,
+			"retryable":   frame.Error.Retryable,
+			"retry_after": frame.Error.RetryAfter,
+			"difficulty":  frame.Error.Difficulty,
+			"message_id":  frame.Error.MessageID,
+			"permanent":   protocol.PermanentCloseCodes[frame.Error.Code],
+		}}, nil
+	}
+}
+
+// enrollment(commonName, pseudonym) returns {csr, key}: the DER CSR as
+// base64 and the private key as PEM
+func enrollment(args []js.Value) (interface{}, error) {
+	if len(args) < 1 {
+		return nil, errArguments
+	}
+	pseudonym := ""
+	if len(args) > 1 && args[1].Type() == js.TypeString {
+		pseudonym = args[1].String()
+	}
+
+	request, err := protocol.NewEnrollmentRequest(args[0].String(), pseudonym)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := request.KeyPEM()
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"csr": base64.StdEncoding.EncodeToString(request.CSR),
+		"key": string(keyPEM),
+	}, nil
+}
+
+// generateSalt() returns a base64 salt for sealKey
+func generateSalt(args []js.Value) (interface{}, error) {
+	salt, err := keystore.GenerateSalt()
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.EncodeToString(salt), nil
+}
+
+// sealKey(key, password, salt) encrypts a base64 key for /api/key/store and
+// returns {encrypted_key, iv, hmac}
+func sealKey(args []js.Value) (interface{}, error) {
+	if len(args) < 3 {
+		return nil, errArguments
+	}
+	key, err := base64.StdEncoding.DecodeString(args[0].String())
+	if err != nil {
+		return nil, err
+	}
+	salt, err := base64.StdEncoding.DecodeString(args[2].String())
+	if err != nil {
+		return nil, err
+	}
+
+	ciphertext, nonce, mac, err := keystore.EncryptAndAuthenticate(key, keystore.DeriveKeyFromPassword(args[1].String(), salt))
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"encrypted_key": base64.StdEncoding.EncodeToString(ciphertext),
+		"iv":            base64.StdEncoding.EncodeToString(nonce),
+		"hmac":          base64.StdEncoding.EncodeToString(mac),
+	}, nil
+}
+
+// openKey({encrypted_key, iv, hmac}, password, salt) returns the base64 key
+func openKey(args []js.Value) (interface{}, error) {
+	if len(args) < 3 {
+		return nil, errArguments
+	}
+	var blob [3][]byte
+	for i, field := range []string{"encrypted_key", "iv", "hmac"} {
+		value, err := base64.StdEncoding.DecodeString(optionalString(args[0], field))
+		if err != nil {
+			return nil, err
+		}
+		blob[i] = value
+	}
+	salt, err := base64.StdEncoding.DecodeString(args[2].String())
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := keystore.VerifyAndDecrypt(blob[0], blob[1], blob[2], keystore.DeriveKeyFromPassword(args[1].String(), salt))
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// optionalString returns a string property, or "" if it is not set
+func optionalString(v js.Value, name string) string {
+	field := v.Get(name)
+	if field.Type() != js.TypeString {
+		return ""
+	}
+	return field.String()
+}
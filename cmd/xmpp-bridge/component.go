@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/yourusername/secure-messaging-poc/internal/bridge"
+	"github.com/yourusername/secure-messaging-poc/pkg/client"
+)
+
+// XML namespaces of the component protocol (XEP-0114)
+const (
+	nsComponent = "jabber:component:accept"
+	nsStream    = "http://etherx.jabber.org/streams"
+)
+
+// handshakeTimeout bounds connecting and authenticating to the XMPP server
+const handshakeTimeout = 30 * time.Second
+
+// errNotConnected is returned by Send while the component is disconnected
+var errNotConnected = errors.New("xmpp: not connected")
+
+// stanzaMessage is a message stanza. Ciphertext carries the messages of
+// opaque rooms for members' own clients to decrypt.
+type stanzaMessage struct {
+	XMLName    xml.Name  `xml:"message"`
+	From       string    `xml:"from,attr,omitempty"`
+	To         string    `xml:"to,attr,omitempty"`
+	ID         string    `xml:"id,attr,omitempty"`
+	Type       string    `xml:"type,attr,omitempty"`
+	Body       string    `xml:"body,omitempty"`
+	Ciphertext string    `xml:"urn:anono:bridge:0 ciphertext,omitempty"`
+	StanzaID   *stanzaID `xml:"urn:xmpp:sid:0 stanza-id"`
+	Delay      *struct{} `xml:"urn:xmpp:delay delay"`
+}
+
+// stanzaID is the ID a MUC service assigns a message (XEP-0359)
+type stanzaID struct {
+	ID string `xml:"id,attr"`
+	By string `xml:"by,attr"`
+}
+
+// mucJoin is the presence joining a room without history
+type mucJoin struct {
+	XMLName xml.Name `xml:"presence"`
+	From    string   `xml:"from,attr"`
+	To      string   `xml:"to,attr"`
+	X       struct {
+		XMLName xml.Name `xml:"http://jabber.org/protocol/muc x"`
+		History struct {
+			MaxStanzas int `xml:"maxstanzas,attr"`
+		} `xml:"history"`
+	}
+}
+
+// component is an XMPP external component joined to the bridged rooms
+type component struct {
+	config xmppConfig
+	rooms  []string
+	relay  *bridge.Relay
+
+	conn    net.Conn
+	mu      sync.Mutex
+	writeMu sync.Mutex
+}
+
+// newComponent creates the transport for rooms; its relay is set once
+// created
+func newComponent(config xmppConfig, rooms []string) *component {
+	return &component{config: config, rooms: rooms}
+}
+
+// jid is the address the component joins rooms and sends from
+func (c *component) jid() string {
+	return c.config.Nick + "@" + c.config.Domain
+}
+
+// run keeps the component connected until ctx is done
+func (c *component) run(ctx context.Context) {
+	for attempt := 1; ; attempt++ {
+		established, err := c.session(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if established {
+			attempt = 1
+		}
+		delay := client.DefaultBackoff.Delay(attempt)
+		log.Printf("XMPP connection lost (%v), reconnecting in %v", err, delay)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// session connects, authenticates, joins the rooms and relays messages
+// until the stream ends. It reports whether the handshake succeeded.
+func (c *component) session(ctx context.Context) (bool, error) {
+	dialer := net.Dialer{Timeout: handshakeTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", c.config.Address)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	decoder := xml.NewDecoder(conn)
+	if err := c.handshake(conn, decoder); err != nil {
+		return false, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	c.mu.Lock()
+	c.conn = conn
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		c.conn = nil
+		c.mu.Unlock()
+	}()
+
+	for _, room := range c.rooms {
+		join := mucJoin{From: c.jid(), To: room + "/" + c.config.Nick}
+		if err := c.write(join); err != nil {
+			return true, err
+		}
+	}
+	log.Printf("XMPP component %s joined %d rooms", c.config.Domain, len(c.rooms))
+
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return true, err
+		}
+		switch start := token.(type) {
+		case xml.StartElement:
+			if start.Name.Local != "message" {
+				if err := decoder.Skip(); err != nil {
+					return true, err
+				}
+				continue
+			}
+			var msg stanzaMessage
+			if err := decoder.DecodeElement(&msg, &start); err != nil {
+				return true, err
+			}
+			c.fromRoom(msg)
+		case xml.EndElement:
+			return true, io.EOF // The server closed the stream
+		}
+	}
+}
+
+// handshake opens the stream and authenticates with the shared secret
+func (c *component) handshake(conn net.Conn, decoder *xml.Decoder) error {
+	if _, err := fmt.Fprintf(conn, "<stream:stream xmlns='%s' xmlns:stream='%s' to='%s'>", nsComponent, nsStream, escapeAttr(c.config.Domain)); err != nil {
+		return err
+	}
+
+	var streamID string
+	for streamID == "" {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		if start, ok := token.(xml.StartElement); ok && start.Name.Local == "stream" {
+			for _, attr := range start.Attr {
+				if attr.Name.Local == "id" {
+					streamID = attr.Value
+				}
+			}
+			if streamID == "" {
+				return errors.New("xmpp: stream without an ID")
+			}
+		}
+	}
+
+	digest := sha1.Sum([]byte(streamID + c.config.Secret))
+	if _, err := fmt.Fprintf(conn, "<handshake>%s</handshake>", hex.EncodeToString(digest[:])); err != nil {
+		return err
+	}
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch start.Name.Local {
+		case "handshake":
+			return decoder.Skip()
+		case "error":
+			return errors.New("xmpp: handshake refused")
+		}
+	}
+}
+
+// fromRoom publishes a groupchat message of a bridged room, ignoring the
+// bridge's own messages reflected by the room and the history replayed on
+// joining
+func (c *component) fromRoom(msg stanzaMessage) {
+	if msg.Type != "groupchat" || msg.Delay != nil {
+		return
+	}
+	room, nick, _ := strings.Cut(msg.From, "/")
+	if nick == c.config.Nick {
+		return
+	}
+
+	// Prefer the room's own ID, which stays unique when senders reuse theirs
+	eventID := msg.From + "\x00" + msg.ID
+	if msg.StanzaID != nil && msg.StanzaID.By == room {
+		eventID = msg.StanzaID.ID
+	}
+	var ciphertext []byte
+	if msg.Ciphertext != "" {
+		var err error
+		if ciphertext, err = base64.StdEncoding.DecodeString(msg.Ciphertext); err != nil {
+			log.Printf("Invalid ciphertext from %s: %v", msg.From, err)
+			return
+		}
+	}
+	if err := c.relay.FromRoom(room, eventID, msg.Body, ciphertext); err != nil {
+		log.Printf("Failed to bridge message from %s: %v", room, err)
+	}
+}
+
+// Send implements bridge.Transport, sending a groupchat message to its room
+func (c *component) Send(delivery bridge.Delivery) error {
+	msg := stanzaMessage{
+		From: c.jid(),
+		To:   delivery.Route.Room,
+		ID:   uuid.NewSHA1(uuid.NameSpaceURL, []byte(delivery.Message.MessageID+"\x00"+delivery.Route.Room)).String(),
+		Type: "groupchat",
+		Body: "Encrypted message",
+	}
+	if delivery.Plaintext != nil {
+		msg.Body = strings.ToValidUTF8(string(delivery.Plaintext), "�")
+	} else {
+		msg.Ciphertext = base64.StdEncoding.EncodeToString(delivery.Message.Ciphertext)
+	}
+	return c.write(msg)
+}
+
+// write sends a stanza on the current connection
+func (c *component) write(stanza interface{}) error {
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+	if conn == nil {
+		return errNotConnected
+	}
+
+	data, err := xml.Marshal(stanza)
+	if err != nil {
+		return err
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_, err = conn.Write(data)
+	return err
+}
+
+// escapeAttr escapes a value for an XML attribute
+func escapeAttr(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
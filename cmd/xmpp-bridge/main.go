@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/spf13/viper"
+
+	"github.com/yourusername/secure-messaging-poc/internal/bridge"
+)
+
+// xmpp-bridge is an XMPP external component (XEP-0114) that bridges
+// channels to multi-user chat rooms, for legacy XMPP clients.
+//
+//	xmpp-bridge -config xmpp-bridge.yaml
+//
+// The configuration names the messaging server, the bridge's client
+// certificate, the XMPP server's component port and secret, and the rooms:
+//
+//	server: https://localhost:8443
+//	cert: bridge.crt
+//	key: bridge.key
+//	ca: certs/ca.crt
+//	store: {path: bridge.db, key: <base64 backup key>}  # optional
+//	xmpp:
+//	  address: localhost:5347
+//	  domain: anono.example.org
+//	  secret: ...
+//	  nick: anono
+//	rooms:
+//	  - {channel: "0x1234", room: "lobby@conference.example.org", mode: opaque}
+//	  - {channel: "42", room: "plain@conference.example.org", mode: decrypt, key: <base64>}
+//
+// Opaque rooms carry ciphertext in a urn:anono:bridge:0 ciphertext element
+// of each message for members' own clients to decrypt; decrypting rooms
+// exchange plain message bodies that the bridge encrypts with the channel
+// key.
+func main() {
+	configPath := flag.String("config", "xmpp-bridge.yaml", "Bridge configuration file")
+	flag.Parse()
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	rooms := make([]string, 0, len(cfg.Rooms))
+	for _, room := range cfg.Rooms {
+		rooms = append(rooms, room.Room)
+	}
+	c := newComponent(cfg.XMPP, rooms)
+	relay, err := bridge.NewRelay(cfg.Config, c)
+	if err != nil {
+		log.Fatalf("Failed to start bridge: %v", err)
+	}
+	defer relay.Close()
+	c.relay = relay
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	go c.run(ctx)
+	if err := relay.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+		log.Fatalf("Bridge stopped: %v", err)
+	}
+}
+
+// config is the bridge configuration file
+type config struct {
+	bridge.Config `mapstructure:",squash"`
+	XMPP          xmppConfig `mapstructure:"xmpp"`
+}
+
+// xmppConfig is the XMPP side of the bridge
+type xmppConfig struct {
+	Address string `mapstructure:"address"` // The server's component port
+	Domain  string `mapstructure:"domain"`  // The component's domain
+	Secret  string `mapstructure:"secret"`
+	Nick    string `mapstructure:"nick"` // Local part and room nickname of the bridge
+}
+
+// loadConfig reads and checks the configuration file
+func loadConfig(path string) (*config, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+	bridge.SetDefaults(v)
+	v.SetDefault("xmpp.address", "localhost:5347")
+	v.SetDefault("xmpp.nick", "anono")
+	if err := v.ReadInConfig(); err != nil {
+		return nil, err
+	}
+
+	var cfg config
+	if err := v.Unmarshal(&cfg); err != nil {
+		return nil, err
+	}
+	switch {
+	case cfg.XMPP.Domain == "":
+		return nil, errors.New("xmpp.domain is required")
+	case cfg.XMPP.Secret == "":
+		return nil, errors.New("xmpp.secret is required")
+	}
+	return &cfg, nil
+}
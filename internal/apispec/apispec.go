@@ -0,0 +1,213 @@
+// Package apispec describes the server's protocol for client code
+// generation: an OpenAPI document for the HTTP endpoints, built from their
+// registrations, and an AsyncAPI document for the WebSocket frames, whose
+// JSON schemas are derived from the Go types that carry them.
+package apispec
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Endpoint is an HTTP endpoint as registered with the server
+type Endpoint struct {
+	Path    string
+	Methods []string
+	MaxBody int64  // Largest accepted request body; 0 for none
+	Summary string // Optional
+}
+
+// Frame is a WebSocket frame and the Go type that carries it
+type Frame struct {
+	Name    string
+	Summary string
+	Type    interface{} // A value of the frame's type
+}
+
+// OpenAPI returns an OpenAPI 3.1 document for endpoints. Every endpoint
+// requires a client certificate.
+func OpenAPI(title, version string, endpoints []Endpoint) map[string]interface{} {
+	paths := make(map[string]interface{})
+	for _, endpoint := range endpoints {
+		operations, _ := paths[endpoint.Path].(map[string]interface{})
+		if operations == nil {
+			operations = make(map[string]interface{})
+			paths[endpoint.Path] = operations
+		}
+		for _, method := range endpoint.Methods {
+			operations[strings.ToLower(method)] = operation(endpoint, method)
+		}
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.1.0",
+		"info":    map[string]interface{}{"title": title, "version": version},
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"securitySchemes": map[string]interface{}{
+				"clientCertificate": map[string]interface{}{"type": "mutualTLS"},
+			},
+		},
+		"security": []interface{}{map[string]interface{}{"clientCertificate": []string{}}},
+	}
+}
+
+// operation describes one method of an endpoint
+func operation(endpoint Endpoint, method string) map[string]interface{} {
+	op := map[string]interface{}{
+		"operationId": operationID(method, endpoint.Path),
+		"responses": map[string]interface{}{
+			"200":     map[string]interface{}{"description": "Success"},
+			"default": map[string]interface{}{"description": "Error, with a plain text message"},
+		},
+	}
+	if endpoint.Summary != "" {
+		op["summary"] = endpoint.Summary
+	}
+	if endpoint.MaxBody > 0 && method != http.MethodGet {
+		op["requestBody"] = map[string]interface{}{
+			"content": map[string]interface{}{"*/*": map[string]interface{}{}},
+		}
+		op["x-max-body-bytes"] = endpoint.MaxBody
+	}
+	return op
+}
+
+// operationID names an operation after its method and path, for example
+// post_api_key_store
+func operationID(method, path string) string {
+	words := strings.FieldsFunc(path, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9')
+	})
+	return strings.ToLower(method) + "_" + strings.Join(words, "_")
+}
+
+// AsyncAPI returns an AsyncAPI 2.6 document for a WebSocket channel: the
+// frames clients send and the frames the server sends them
+func AsyncAPI(title, version, channel string, fromClient, fromServer []Frame) map[string]interface{} {
+	return map[string]interface{}{
+		"asyncapi": "2.6.0",
+		"info":     map[string]interface{}{"title": title, "version": version},
+		"channels": map[string]interface{}{
+			channel: map[string]interface{}{
+				"publish":   map[string]interface{}{"message": messages(fromClient)},
+				"subscribe": map[string]interface{}{"message": messages(fromServer)},
+			},
+		},
+	}
+}
+
+// messages describes a set of frames
+func messages(frames []Frame) map[string]interface{} {
+	oneOf := make([]interface{}, 0, len(frames))
+	for _, frame := range frames {
+		message := map[string]interface{}{
+			"name":        frame.Name,
+			"contentType": "application/json",
+			"payload":     Schema(reflect.TypeOf(frame.Type)),
+		}
+		if frame.Summary != "" {
+			message["summary"] = frame.Summary
+		}
+		oneOf = append(oneOf, message)
+	}
+	return map[string]interface{}{"oneOf": oneOf}
+}
+
+var (
+	timeType      = reflect.TypeOf(time.Time{})
+	byteSliceType = reflect.TypeOf([]byte(nil))
+)
+
+// Schema returns the JSON schema of the JSON encoding of t, following
+// encoding/json: field names and omitempty come from json tags, byte
+// slices are base64 strings and times are RFC 3339 strings
+func Schema(t reflect.Type) map[string]interface{} {
+	return schema(t, make(map[reflect.Type]bool))
+}
+
+// schema builds a schema, describing types already being described as any
+// value so recursive types terminate
+func schema(t reflect.Type, visiting map[reflect.Type]bool) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t == byteSliceType:
+		return map[string]interface{}{"type": "string", "contentEncoding": "base64"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": schema(t.Elem(), visiting)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schema(t.Elem(), visiting)}
+	case reflect.Struct:
+		if visiting[t] {
+			return map[string]interface{}{}
+		}
+		visiting[t] = true
+		defer delete(visiting, t)
+		return structSchema(t, visiting)
+	}
+	return map[string]interface{}{}
+}
+
+// structSchema describes a struct's exported fields, flattening embedded
+// structs as encoding/json does
+func structSchema(t reflect.Type, visiting map[reflect.Type]bool) map[string]interface{} {
+	properties := make(map[string]interface{})
+	var required []string
+
+	var addFields func(t reflect.Type)
+	addFields = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "-" && opts == "" {
+				continue
+			}
+			fieldType := field.Type
+			for fieldType.Kind() == reflect.Ptr {
+				fieldType = fieldType.Elem()
+			}
+			if field.Anonymous && name == "" && fieldType.Kind() == reflect.Struct {
+				addFields(fieldType)
+				continue
+			}
+			if !field.IsExported() {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			properties[name] = schema(field.Type, visiting)
+			if !strings.Contains(opts, "omitempty") {
+				required = append(required, name)
+			}
+		}
+	}
+	addFields(t)
+
+	s := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		sort.Strings(required)
+		s["required"] = required
+	}
+	return s
+}
@@ -0,0 +1,119 @@
+package apispec
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type inner struct {
+	Count int `json:"count"`
+}
+
+type sample struct {
+	inner
+	ID         uint64          `json:"id"`
+	Name       string          `json:"name,omitempty"`
+	Data       []byte          `json:"data"`
+	Tags       []string        `json:"tags,omitempty"`
+	Labels     map[string]bool `json:"labels,omitempty"`
+	At         time.Time       `json:"at"`
+	Next       *sample         `json:"next,omitempty"`
+	Skipped    string          `json:"-"`
+	Untagged   float64
+	unexported string
+}
+
+func TestSchema(t *testing.T) {
+	s := Schema(reflect.TypeOf(sample{}))
+	properties := s["properties"].(map[string]interface{})
+
+	expect := map[string]string{
+		"count":    "integer",
+		"id":       "integer",
+		"name":     "string",
+		"data":     "string",
+		"tags":     "array",
+		"labels":   "object",
+		"at":       "string",
+		"Untagged": "number",
+	}
+	if len(properties) != len(expect)+1 {
+		t.Errorf("Expected %d properties, got %v", len(expect), properties)
+	}
+	for name, typ := range expect {
+		property, ok := properties[name].(map[string]interface{})
+		if !ok {
+			t.Errorf("Missing property %s", name)
+			continue
+		}
+		if property["type"] != typ {
+			t.Errorf("Property %s: expected %s, got %v", name, typ, property["type"])
+		}
+	}
+	if properties["at"].(map[string]interface{})["format"] != "date-time" {
+		t.Error("Times should be described as date-time strings")
+	}
+	if properties["data"].(map[string]interface{})["contentEncoding"] != "base64" {
+		t.Error("Byte slices should be described as base64 strings")
+	}
+
+	// The recursive field is described as any value
+	if len(properties["next"].(map[string]interface{})) != 0 {
+		t.Error("Expected the recursion to stop")
+	}
+
+	required := s["required"].([]string)
+	if !reflect.DeepEqual(required, []string{"Untagged", "at", "count", "data", "id"}) {
+		t.Errorf("Unexpected required fields %v", required)
+	}
+}
+
+func TestOpenAPI(t *testing.T) {
+	doc := OpenAPI("test", "1.0", []Endpoint{
+		{Path: "/api/key/store", Methods: []string{http.MethodPost}, MaxBody: 1024},
+		{Path: "/api/directory", Methods: []string{http.MethodGet, http.MethodDelete}, MaxBody: 512},
+	})
+	if _, err := json.Marshal(doc); err != nil {
+		t.Fatalf("Document does not encode: %v", err)
+	}
+
+	paths := doc["paths"].(map[string]interface{})
+	store := paths["/api/key/store"].(map[string]interface{})["post"].(map[string]interface{})
+	if store["operationId"] != "post_api_key_store" {
+		t.Errorf("Unexpected operation ID %v", store["operationId"])
+	}
+	if store["x-max-body-bytes"] != int64(1024) || store["requestBody"] == nil {
+		t.Error("Expected the request body and its limit")
+	}
+
+	directory := paths["/api/directory"].(map[string]interface{})
+	if _, ok := directory["get"].(map[string]interface{})["requestBody"]; ok {
+		t.Error("GET operations should not take a body")
+	}
+	if _, ok := directory["delete"]; !ok {
+		t.Error("Expected an operation per method")
+	}
+}
+
+func TestAsyncAPI(t *testing.T) {
+	doc := AsyncAPI("test", "1.0", "/ws",
+		[]Frame{{Name: "subscribe", Type: sample{}}},
+		[]Frame{{Name: "ack", Type: inner{}}, {Name: "error", Type: &inner{}}})
+	if _, err := json.Marshal(doc); err != nil {
+		t.Fatalf("Document does not encode: %v", err)
+	}
+
+	channel := doc["channels"].(map[string]interface{})["/ws"].(map[string]interface{})
+	fromClient := channel["publish"].(map[string]interface{})["message"].(map[string]interface{})["oneOf"].([]interface{})
+	fromServer := channel["subscribe"].(map[string]interface{})["message"].(map[string]interface{})["oneOf"].([]interface{})
+	if len(fromClient) != 1 || len(fromServer) != 2 {
+		t.Fatalf("Expected 1 client and 2 server frames, got %d and %d", len(fromClient), len(fromServer))
+	}
+	payload := fromServer[1].(map[string]interface{})["payload"].(map[string]interface{})
+	if payload["type"] != "object" {
+		t.Errorf("Pointer frame types should be described by their element, got %v", payload)
+	}
+}
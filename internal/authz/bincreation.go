@@ -0,0 +1,66 @@
+package authz
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/yourusername/secure-messaging-poc/internal/binmanager"
+	cryptopkg "github.com/yourusername/secure-messaging-poc/pkg/crypto"
+)
+
+// ErrProofOfWorkRequired is matched by ProofOfWorkError
+var ErrProofOfWorkRequired = errors.New("authz: proof of work required to create a bin")
+
+// ProofOfWorkError is returned when a message that would create a bin lacks
+// a valid proof of work
+type ProofOfWorkError struct {
+	Difficulty int // Leading zero bits required
+}
+
+// Error implements error
+func (e *ProofOfWorkError) Error() string {
+	return fmt.Sprintf("%v (%d bits)", ErrProofOfWorkRequired, e.Difficulty)
+}
+
+// Is matches ErrProofOfWorkRequired
+func (e *ProofOfWorkError) Is(target error) bool {
+	return target == ErrProofOfWorkRequired
+}
+
+// BinCreation makes creating bins cost something, so a client cannot
+// exhaust memory by scattering messages over millions of bins. A message to
+// a bin that does not exist yet must carry a proof of work, unless it comes
+// from an admin or a listed publisher certificate. Messages to existing bins
+// are not affected.
+type BinCreation struct {
+	difficulty int
+	exists     func(binID uint64) bool
+	publishers map[string]bool
+}
+
+// NewBinCreation requires difficulty leading zero bits of work for messages
+// to bins exists does not know. The listed publisher certificates are exempt.
+func NewBinCreation(difficulty int, exists func(binID uint64) bool, publisherCertIDs ...string) *BinCreation {
+	publishers := make(map[string]bool, len(publisherCertIDs))
+	for _, certID := range publisherCertIDs {
+		publishers[certID] = true
+	}
+	return &BinCreation{difficulty: difficulty, exists: exists, publishers: publishers}
+}
+
+// Difficulty returns the leading zero bits required
+func (bc *BinCreation) Difficulty() int {
+	return bc.difficulty
+}
+
+// AuthorizePublish checks the proof of work of messages that create a bin
+func (bc *BinCreation) AuthorizePublish(ctx context.Context, info CertInfo, msg *binmanager.Message) error {
+	if bc.difficulty <= 0 || info.Admin || bc.publishers[info.CertID] || bc.exists(msg.BinID) {
+		return nil
+	}
+	if !cryptopkg.VerifyProofOfWork(msg.BinID, msg.Ciphertext, msg.PoW, bc.difficulty) {
+		return &ProofOfWorkError{Difficulty: bc.difficulty}
+	}
+	return nil
+}
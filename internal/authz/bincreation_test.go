@@ -0,0 +1,54 @@
+package authz
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/yourusername/secure-messaging-poc/internal/binmanager"
+	cryptopkg "github.com/yourusername/secure-messaging-poc/pkg/crypto"
+)
+
+func TestBinCreationProofOfWork(t *testing.T) {
+	existing := map[uint64]bool{0x1000: true}
+	policy := NewBinCreation(8, func(binID uint64) bool { return existing[binID] }, "publisher")
+	ctx := context.Background()
+	client := CertInfo{CertID: "client"}
+
+	// Existing bins need no work
+	if err := policy.AuthorizePublish(ctx, client, binmanager.NewMessage(0x1000, "m", []byte("x"))); err != nil {
+		t.Fatalf("Publishing to an existing bin denied: %v", err)
+	}
+
+	msg := binmanager.NewMessage(0x2000, "m", []byte("x"))
+	err := policy.AuthorizePublish(ctx, client, msg)
+	var powErr *ProofOfWorkError
+	if !errors.Is(err, ErrProofOfWorkRequired) || !errors.As(err, &powErr) || powErr.Difficulty != 8 {
+		t.Fatalf("Expected a ProofOfWorkError for 8 bits, got %v", err)
+	}
+
+	msg.PoW = cryptopkg.SolveProofOfWork(msg.BinID, msg.Ciphertext, 8)
+	if err := policy.AuthorizePublish(ctx, client, msg); err != nil {
+		t.Errorf("Valid proof of work denied: %v", err)
+	}
+
+	// A solution for one bin does not open another
+	other := binmanager.NewMessage(0x3000, "m", []byte("x"))
+	other.PoW = msg.PoW
+	if !cryptopkg.VerifyProofOfWork(other.BinID, other.Ciphertext, other.PoW, 8) {
+		if err := policy.AuthorizePublish(ctx, client, other); !errors.Is(err, ErrProofOfWorkRequired) {
+			t.Errorf("Reused proof of work should be refused, got %v", err)
+		}
+	}
+
+	// Publishers and admins are exempt
+	for _, info := range []CertInfo{{CertID: "publisher"}, {CertID: "operator", Admin: true}} {
+		if err := policy.AuthorizePublish(ctx, info, binmanager.NewMessage(0x4000, "m", []byte("x"))); err != nil {
+			t.Errorf("%s should be exempt, got %v", info.CertID, err)
+		}
+	}
+
+	if err := NewBinCreation(0, func(uint64) bool { return false }).AuthorizePublish(ctx, client, other); err != nil {
+		t.Errorf("Zero difficulty should allow every bin, got %v", err)
+	}
+}
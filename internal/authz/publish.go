@@ -0,0 +1,167 @@
+// Package authz holds the authorization hooks consulted when clients publish
+// messages, and the built-in policies deployments compose from configuration.
+package authz
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/yourusername/secure-messaging-poc/internal/binmanager"
+	"github.com/yourusername/secure-messaging-poc/internal/ratelimit"
+)
+
+var (
+	// ErrForbidden is returned when the certificate may not publish to a bin
+	ErrForbidden = errors.New("authz: publishing to this bin is not permitted")
+	// ErrRateLimited is matched by RateLimitError
+	ErrRateLimited = errors.New("authz: publish rate exceeded")
+	// ErrRejected is returned when a message does not meet a content policy
+	ErrRejected = errors.New("authz: message rejected by policy")
+)
+
+// CertInfo identifies the client certificate a request was made with
+type CertInfo struct {
+	CertID      string
+	ReferrerID  string
+	Admin       bool
+	Certificate *x509.Certificate
+}
+
+// PublishAuthorizer decides whether a client may publish a message. A nil
+// error allows it; the error returned otherwise is reported to the client.
+type PublishAuthorizer interface {
+	AuthorizePublish(ctx context.Context, info CertInfo, msg *binmanager.Message) error
+}
+
+// PublishAuthorizerFunc adapts a function to PublishAuthorizer
+type PublishAuthorizerFunc func(ctx context.Context, info CertInfo, msg *binmanager.Message) error
+
+// AuthorizePublish calls f
+func (f PublishAuthorizerFunc) AuthorizePublish(ctx context.Context, info CertInfo, msg *binmanager.Message) error {
+	return f(ctx, info, msg)
+}
+
+// PublishChain consults each authorizer in order; the first error denies
+type PublishChain []PublishAuthorizer
+
+// AuthorizePublish runs the chain
+func (c PublishChain) AuthorizePublish(ctx context.Context, info CertInfo, msg *binmanager.Message) error {
+	for _, authorizer := range c {
+		if err := authorizer.AuthorizePublish(ctx, info, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RateLimitError is returned when a certificate exceeds its publish rate
+type RateLimitError struct {
+	RetryAfter time.Duration // Zero if the limiter cannot tell
+}
+
+// Error implements error
+func (e *RateLimitError) Error() string {
+	return ErrRateLimited.Error()
+}
+
+// Is matches ErrRateLimited
+func (e *RateLimitError) Is(target error) bool {
+	return target == ErrRateLimited
+}
+
+// RateLimit limits publishes per client certificate
+func RateLimit(limiter ratelimit.Limiter) PublishAuthorizer {
+	return PublishAuthorizerFunc(func(ctx context.Context, info CertInfo, msg *binmanager.Message) error {
+		if limiter.Allow(info.CertID) {
+			return nil
+		}
+		err := &RateLimitError{}
+		if ra, ok := limiter.(interface{ RetryAfter(string) time.Duration }); ok {
+			err.RetryAfter = ra.RetryAfter(info.CertID)
+		}
+		return err
+	})
+}
+
+// BinACL restricts publishing to selected bins to listed certificates, or
+// to certificates referred by a listed certificate. Bins without a rule are
+// open to everyone.
+type BinACL struct {
+	rules map[uint64]map[string]bool
+	mu    sync.RWMutex
+}
+
+// NewBinACL creates an ACL without rules
+func NewBinACL() *BinACL {
+	return &BinACL{rules: make(map[uint64]map[string]bool)}
+}
+
+// Restrict allows only the given certificate IDs, and the certificates they
+// referred, to publish to binID
+func (acl *BinACL) Restrict(binID uint64, certIDs ...string) {
+	acl.mu.Lock()
+	defer acl.mu.Unlock()
+
+	allowed, exists := acl.rules[binID]
+	if !exists {
+		allowed = make(map[string]bool, len(certIDs))
+		acl.rules[binID] = allowed
+	}
+	for _, certID := range certIDs {
+		allowed[certID] = true
+	}
+}
+
+// AuthorizePublish checks the bin's rule
+func (acl *BinACL) AuthorizePublish(ctx context.Context, info CertInfo, msg *binmanager.Message) error {
+	acl.mu.RLock()
+	defer acl.mu.RUnlock()
+
+	allowed, restricted := acl.rules[msg.BinID]
+	if !restricted || allowed[info.CertID] || (info.ReferrerID != "" && allowed[info.ReferrerID]) {
+		return nil
+	}
+	return ErrForbidden
+}
+
+// SizeBuckets only accepts ciphertexts whose length is one of sizes, for
+// deployments where clients pad messages to fixed buckets so lengths do not
+// reveal content
+func SizeBuckets(sizes ...int) PublishAuthorizer {
+	buckets := make(map[int]bool, len(sizes))
+	for _, size := range sizes {
+		buckets[size] = true
+	}
+	return PublishAuthorizerFunc(func(ctx context.Context, info CertInfo, msg *binmanager.Message) error {
+		if !buckets[len(msg.Ciphertext)] {
+			return fmt.Errorf("%w: ciphertext length %d is not a padding bucket", ErrRejected, len(msg.Ciphertext))
+		}
+		return nil
+	})
+}
+
+// TagValidator requires the opaque thread tag and reply reference, when
+// present, to have exactly the configured sizes. A size of zero allows any
+// length within the protocol bounds.
+type TagValidator struct {
+	ThreadTagSize int
+	ReplyToIDSize int
+}
+
+// AuthorizePublish checks the tag sizes
+func (tv TagValidator) AuthorizePublish(ctx context.Context, info CertInfo, msg *binmanager.Message) error {
+	if err := msg.Validate(); err != nil {
+		return fmt.Errorf("%w: %v", ErrRejected, err)
+	}
+	if tv.ThreadTagSize > 0 && len(msg.ThreadTag) > 0 && len(msg.ThreadTag) != tv.ThreadTagSize {
+		return fmt.Errorf("%w: thread tag must be %d bytes", ErrRejected, tv.ThreadTagSize)
+	}
+	if tv.ReplyToIDSize > 0 && len(msg.ReplyToID) > 0 && len(msg.ReplyToID) != tv.ReplyToIDSize {
+		return fmt.Errorf("%w: reply reference must be %d bytes", ErrRejected, tv.ReplyToIDSize)
+	}
+	return nil
+}
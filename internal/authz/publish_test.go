@@ -0,0 +1,82 @@
+package authz
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/yourusername/secure-messaging-poc/internal/binmanager"
+	"github.com/yourusername/secure-messaging-poc/internal/ratelimit"
+)
+
+func TestRateLimit(t *testing.T) {
+	authorizer := RateLimit(ratelimit.NewTokenBucket(1, 2))
+	info := CertInfo{CertID: "client"}
+	msg := binmanager.NewMessage(1, "m", []byte("x"))
+
+	for i := 0; i < 2; i++ {
+		if err := authorizer.AuthorizePublish(context.Background(), info, msg); err != nil {
+			t.Fatalf("Publish %d within the burst denied: %v", i, err)
+		}
+	}
+
+	err := authorizer.AuthorizePublish(context.Background(), info, msg)
+	var rateErr *RateLimitError
+	if !errors.Is(err, ErrRateLimited) || !errors.As(err, &rateErr) {
+		t.Fatalf("Expected a RateLimitError, got %v", err)
+	}
+	if rateErr.RetryAfter <= 0 || rateErr.RetryAfter > time.Second {
+		t.Errorf("Unexpected retry hint %v", rateErr.RetryAfter)
+	}
+}
+
+func TestBinACL(t *testing.T) {
+	acl := NewBinACL()
+	acl.Restrict(0x1000, "operator")
+
+	tests := []struct {
+		binID uint64
+		info  CertInfo
+		err   error
+	}{
+		{0x1000, CertInfo{CertID: "operator"}, nil},
+		{0x1000, CertInfo{CertID: "referred", ReferrerID: "operator"}, nil},
+		{0x1000, CertInfo{CertID: "stranger"}, ErrForbidden},
+		{0x2000, CertInfo{CertID: "stranger"}, nil},
+	}
+	for _, tt := range tests {
+		msg := binmanager.NewMessage(tt.binID, "m", []byte("x"))
+		if err := acl.AuthorizePublish(context.Background(), tt.info, msg); err != tt.err {
+			t.Errorf("Bin %x as %s: expected %v, got %v", tt.binID, tt.info.CertID, tt.err, err)
+		}
+	}
+}
+
+func TestContentPolicies(t *testing.T) {
+	chain := PublishChain{
+		SizeBuckets(256, 1024),
+		TagValidator{ThreadTagSize: 16},
+	}
+	ctx := context.Background()
+
+	padded := binmanager.NewMessage(1, "m", make([]byte, 256))
+	if err := chain.AuthorizePublish(ctx, CertInfo{}, padded); err != nil {
+		t.Errorf("Padded message rejected: %v", err)
+	}
+
+	unpadded := binmanager.NewMessage(1, "m", make([]byte, 300))
+	if err := chain.AuthorizePublish(ctx, CertInfo{}, unpadded); !errors.Is(err, ErrRejected) {
+		t.Errorf("Expected ErrRejected for an unpadded message, got %v", err)
+	}
+
+	padded.ThreadTag = make([]byte, 8)
+	if err := chain.AuthorizePublish(ctx, CertInfo{}, padded); !errors.Is(err, ErrRejected) {
+		t.Errorf("Expected ErrRejected for a short thread tag, got %v", err)
+	}
+
+	padded.ThreadTag = make([]byte, 16)
+	if err := chain.AuthorizePublish(ctx, CertInfo{}, padded); err != nil {
+		t.Errorf("Correctly sized thread tag rejected: %v", err)
+	}
+}
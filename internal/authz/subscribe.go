@@ -0,0 +1,143 @@
+package authz
+
+import (
+	"context"
+	"sync"
+
+	"github.com/yourusername/secure-messaging-poc/internal/binmanager"
+)
+
+// maxJoinedBins bounds the bins remembered per certificate by RevokedReferrer
+const maxJoinedBins = 1024
+
+// SubscribeRequest is a request to subscribe to bins and replay what they hold
+type SubscribeRequest struct {
+	BinIDs   []uint64
+	Prefixes []binmanager.PrefixSubscription
+	Backfill bool // Retained messages will be replayed
+}
+
+// SubscribeAuthorizer decides whether a client may subscribe. A nil error
+// allows the whole request.
+type SubscribeAuthorizer interface {
+	AuthorizeSubscribe(ctx context.Context, info CertInfo, req SubscribeRequest) error
+}
+
+// SubscribeAuthorizerFunc adapts a function to SubscribeAuthorizer
+type SubscribeAuthorizerFunc func(ctx context.Context, info CertInfo, req SubscribeRequest) error
+
+// AuthorizeSubscribe calls f
+func (f SubscribeAuthorizerFunc) AuthorizeSubscribe(ctx context.Context, info CertInfo, req SubscribeRequest) error {
+	return f(ctx, info, req)
+}
+
+// SubscribeChain consults each authorizer in order; the first error denies
+type SubscribeChain []SubscribeAuthorizer
+
+// AuthorizeSubscribe runs the chain
+func (c SubscribeChain) AuthorizeSubscribe(ctx context.Context, info CertInfo, req SubscribeRequest) error {
+	for _, authorizer := range c {
+		if err := authorizer.AuthorizeSubscribe(ctx, info, req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadOnly limits listed certificates to reading: they may not publish, may
+// subscribe to at most maxBins bins and may not subscribe to bin ranges
+type ReadOnly struct {
+	certIDs map[string]bool
+	maxBins int
+}
+
+// NewReadOnly creates the policy for the given certificate IDs. A maxBins of
+// zero does not limit the number of bins.
+func NewReadOnly(maxBins int, certIDs ...string) *ReadOnly {
+	ro := &ReadOnly{certIDs: make(map[string]bool, len(certIDs)), maxBins: maxBins}
+	for _, certID := range certIDs {
+		ro.certIDs[certID] = true
+	}
+	return ro
+}
+
+// AuthorizePublish denies every publish from a read-only certificate
+func (ro *ReadOnly) AuthorizePublish(ctx context.Context, info CertInfo, msg *binmanager.Message) error {
+	if ro.certIDs[info.CertID] {
+		return ErrForbidden
+	}
+	return nil
+}
+
+// AuthorizeSubscribe enforces the bin limit
+func (ro *ReadOnly) AuthorizeSubscribe(ctx context.Context, info CertInfo, req SubscribeRequest) error {
+	if !ro.certIDs[info.CertID] {
+		return nil
+	}
+	if len(req.Prefixes) > 0 || (ro.maxBins > 0 && len(req.BinIDs) > ro.maxBins) {
+		return ErrForbidden
+	}
+	return nil
+}
+
+// RevokedReferrer lets certificates whose referrer has been revoked keep
+// reading the bins they had joined before, while refusing new bins and all
+// publishes. Joined bins are remembered in memory, so after a restart such
+// certificates can no longer subscribe at all.
+type RevokedReferrer struct {
+	isRevoked func(certID string) bool
+	joined    map[string]map[uint64]bool
+	mu        sync.Mutex
+}
+
+// NewRevokedReferrer creates the policy using isRevoked to check referrers
+func NewRevokedReferrer(isRevoked func(certID string) bool) *RevokedReferrer {
+	return &RevokedReferrer{
+		isRevoked: isRevoked,
+		joined:    make(map[string]map[uint64]bool),
+	}
+}
+
+// AuthorizePublish denies publishes once the referrer is revoked
+func (rr *RevokedReferrer) AuthorizePublish(ctx context.Context, info CertInfo, msg *binmanager.Message) error {
+	if info.ReferrerID != "" && rr.isRevoked(info.ReferrerID) {
+		return ErrForbidden
+	}
+	return nil
+}
+
+// AuthorizeSubscribe records the bins joined while the referrer is in good
+// standing, and afterwards allows only those
+func (rr *RevokedReferrer) AuthorizeSubscribe(ctx context.Context, info CertInfo, req SubscribeRequest) error {
+	if info.ReferrerID == "" {
+		return nil
+	}
+
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	joined := rr.joined[info.CertID]
+	if rr.isRevoked(info.ReferrerID) {
+		if len(req.Prefixes) > 0 {
+			return ErrForbidden
+		}
+		for _, binID := range req.BinIDs {
+			if !joined[binID] {
+				return ErrForbidden
+			}
+		}
+		return nil
+	}
+
+	if joined == nil {
+		joined = make(map[uint64]bool)
+		rr.joined[info.CertID] = joined
+	}
+	for _, binID := range req.BinIDs {
+		if len(joined) >= maxJoinedBins {
+			break
+		}
+		joined[binID] = true
+	}
+	return nil
+}
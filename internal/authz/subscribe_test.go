@@ -0,0 +1,54 @@
+package authz
+
+import (
+	"context"
+	"testing"
+
+	"github.com/yourusername/secure-messaging-poc/internal/binmanager"
+)
+
+func TestReadOnly(t *testing.T) {
+	ro := NewReadOnly(2, "reader")
+	ctx := context.Background()
+	reader := CertInfo{CertID: "reader"}
+
+	if err := ro.AuthorizeSubscribe(ctx, reader, SubscribeRequest{BinIDs: []uint64{1, 2}}); err != nil {
+		t.Errorf("Subscription within the limit denied: %v", err)
+	}
+	if err := ro.AuthorizeSubscribe(ctx, reader, SubscribeRequest{BinIDs: []uint64{1, 2, 3}}); err != ErrForbidden {
+		t.Errorf("Expected ErrForbidden over the limit, got %v", err)
+	}
+	if err := ro.AuthorizeSubscribe(ctx, CertInfo{CertID: "writer"}, SubscribeRequest{BinIDs: []uint64{1, 2, 3}}); err != nil {
+		t.Errorf("Unlisted certificate limited: %v", err)
+	}
+	if err := ro.AuthorizePublish(ctx, reader, binmanager.NewMessage(1, "m", nil)); err != ErrForbidden {
+		t.Errorf("Expected read-only publish to be denied, got %v", err)
+	}
+}
+
+func TestRevokedReferrer(t *testing.T) {
+	revoked := map[string]bool{}
+	rr := NewRevokedReferrer(func(certID string) bool { return revoked[certID] })
+	ctx := context.Background()
+	child := CertInfo{CertID: "child", ReferrerID: "parent"}
+
+	if err := rr.AuthorizeSubscribe(ctx, child, SubscribeRequest{BinIDs: []uint64{1, 2}}); err != nil {
+		t.Fatalf("Subscription denied before revocation: %v", err)
+	}
+
+	revoked["parent"] = true
+	if err := rr.AuthorizeSubscribe(ctx, child, SubscribeRequest{BinIDs: []uint64{2}}); err != nil {
+		t.Errorf("Previously joined bin denied after revocation: %v", err)
+	}
+	if err := rr.AuthorizeSubscribe(ctx, child, SubscribeRequest{BinIDs: []uint64{2, 3}}); err != ErrForbidden {
+		t.Errorf("Expected a new bin to be denied, got %v", err)
+	}
+	if err := rr.AuthorizePublish(ctx, child, binmanager.NewMessage(2, "m", nil)); err != ErrForbidden {
+		t.Errorf("Expected publishing to be denied, got %v", err)
+	}
+
+	// Certificates without a referrer are unaffected
+	if err := rr.AuthorizeSubscribe(ctx, CertInfo{CertID: "root"}, SubscribeRequest{BinIDs: []uint64{3}}); err != nil {
+		t.Errorf("Root certificate denied: %v", err)
+	}
+}
@@ -0,0 +1,190 @@
+package binmanager
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// A bin archive holds the retained messages of one bin, exported for
+// migration between servers. It is a magic header followed by chunks, each
+// a big-endian uint32 length and an AES-256-GCM sealed JSON ArchiveChunk.
+// The key is derived from the bin's ownership secret, so only its holders
+// can read the archive, and every chunk is bound to its position so chunks
+// cannot be dropped or reordered unnoticed. The last chunk is marked final.
+const archiveMagic = "ANONOARC1\n"
+
+// MaxArchiveChunkSize bounds a sealed archive chunk
+const MaxArchiveChunkSize = 16 << 20
+
+var (
+	// ErrNotBinOwner is returned when the claimant neither created the bin
+	// nor holds its ownership proof
+	ErrNotBinOwner = errors.New("only the bin's owner may do this")
+	// ErrArchiveFormat is returned for data that is not a bin archive
+	ErrArchiveFormat = errors.New("not a bin archive")
+	// ErrArchiveCorrupt is returned when a chunk does not open, because the
+	// archive was altered or the ownership secret is wrong
+	ErrArchiveCorrupt = errors.New("bin archive is corrupt or the ownership secret is wrong")
+	// ErrArchiveTruncated is returned when an archive ends before its final
+	// chunk
+	ErrArchiveTruncated = errors.New("bin archive is truncated")
+)
+
+// ArchiveChunk is the plaintext of one archive chunk
+type ArchiveChunk struct {
+	BinID    uint64     `json:"bin_id"`
+	Messages []*Message `json:"messages"`
+	Cursor   string     `json:"cursor,omitempty"` // Resumes an export after this chunk
+	Final    bool       `json:"final,omitempty"`
+}
+
+// ExportMessages returns the retained messages of a bin, oldest first, if
+// claim comes from its owner. Bins are owned once their creator has set a
+// retention for them.
+func (bm *BinManager) ExportMessages(binID uint64, claim RetentionClaim) ([]*Message, error) {
+	bin, exists := bm.lookupBin(binID)
+
+	if !exists || !bin.ownedBy(claim) {
+		return nil, ErrNotBinOwner
+	}
+	return bin.GetRecentMessages(bm.retentionFor(bin)), nil
+}
+
+// ArchiveWriter writes a bin archive
+type ArchiveWriter struct {
+	w     io.Writer
+	aead  cipher.AEAD
+	index uint64
+}
+
+// NewArchiveWriter writes the archive header to w and returns a writer
+// sealing chunks with a key derived from secret
+func NewArchiveWriter(w io.Writer, secret []byte) (*ArchiveWriter, error) {
+	aead, err := archiveAEAD(secret)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(w, archiveMagic); err != nil {
+		return nil, err
+	}
+	return &ArchiveWriter{w: w, aead: aead}, nil
+}
+
+// WriteChunk seals and writes the next chunk
+func (aw *ArchiveWriter) WriteChunk(chunk *ArchiveChunk) error {
+	plaintext, err := json.Marshal(chunk)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aw.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	sealed := aw.aead.Seal(nonce, nonce, plaintext, chunkAD(aw.index))
+	if len(sealed) > MaxArchiveChunkSize {
+		return fmt.Errorf("archive chunk of %d bytes exceeds %d", len(sealed), MaxArchiveChunkSize)
+	}
+
+	frame := make([]byte, 4, 4+len(sealed))
+	binary.BigEndian.PutUint32(frame, uint32(len(sealed)))
+	if _, err := aw.w.Write(append(frame, sealed...)); err != nil {
+		return err
+	}
+	aw.index++
+	return nil
+}
+
+// ArchiveReader reads a bin archive
+type ArchiveReader struct {
+	r     io.Reader
+	aead  cipher.AEAD
+	index uint64
+	binID uint64
+	done  bool
+}
+
+// NewArchiveReader checks the archive header in r and returns a reader
+// opening chunks with a key derived from secret
+func NewArchiveReader(r io.Reader, secret []byte) (*ArchiveReader, error) {
+	aead, err := archiveAEAD(secret)
+	if err != nil {
+		return nil, err
+	}
+	magic := make([]byte, len(archiveMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != archiveMagic {
+		return nil, ErrArchiveFormat
+	}
+	return &ArchiveReader{r: r, aead: aead}, nil
+}
+
+// Next returns the next chunk, or io.EOF after the final one
+func (ar *ArchiveReader) Next() (*ArchiveChunk, error) {
+	if ar.done {
+		return nil, io.EOF
+	}
+
+	var header [4]byte
+	if _, err := io.ReadFull(ar.r, header[:]); err != nil {
+		return nil, archiveReadError(err)
+	}
+	size := binary.BigEndian.Uint32(header[:])
+	nonceSize := ar.aead.NonceSize()
+	if size > MaxArchiveChunkSize || int(size) < nonceSize+ar.aead.Overhead() {
+		return nil, ErrArchiveCorrupt
+	}
+	sealed := make([]byte, size)
+	if _, err := io.ReadFull(ar.r, sealed); err != nil {
+		return nil, archiveReadError(err)
+	}
+
+	plaintext, err := ar.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], chunkAD(ar.index))
+	if err != nil {
+		return nil, ErrArchiveCorrupt
+	}
+	var chunk ArchiveChunk
+	if err := json.Unmarshal(plaintext, &chunk); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrArchiveCorrupt, err)
+	}
+	if ar.index > 0 && chunk.BinID != ar.binID {
+		return nil, ErrArchiveCorrupt
+	}
+
+	ar.binID = chunk.BinID
+	ar.index++
+	ar.done = chunk.Final
+	return &chunk, nil
+}
+
+// archiveReadError reports an archive that ends early as truncated
+func archiveReadError(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrArchiveTruncated
+	}
+	return err
+}
+
+// archiveAEAD derives the archive cipher from a bin's ownership secret
+func archiveAEAD(secret []byte) (cipher.AEAD, error) {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("anono bin archive"))
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// chunkAD binds a chunk to its position in the archive
+func chunkAD(index uint64) []byte {
+	var ad [8]byte
+	binary.BigEndian.PutUint64(ad[:], index)
+	return ad[:]
+}
@@ -0,0 +1,99 @@
+package binmanager
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestExportMessagesRequiresOwner(t *testing.T) {
+	bm := NewBinManager(0xFFFFFFFFFFFFF000, time.Hour)
+	bm.SetRetentionBounds(time.Hour, 48*time.Hour)
+	proof := []byte("ownership secret")
+
+	if _, err := bm.ExportMessages(0x1000, RetentionClaim{CertID: "creator"}); !errors.Is(err, ErrNotBinOwner) {
+		t.Fatalf("expected ErrNotBinOwner for an unknown bin, got %v", err)
+	}
+	if err := bm.SetBinRetention(0x1000, 2*time.Hour, RetentionClaim{CertID: "creator", Proof: proof}); err != nil {
+		t.Fatalf("SetBinRetention failed: %v", err)
+	}
+	if err := bm.PersistMessage(NewMessage(0x1000, "m1", []byte("one"))); err != nil {
+		t.Fatalf("PersistMessage failed: %v", err)
+	}
+
+	for _, claim := range []RetentionClaim{{CertID: "creator"}, {CertID: "member", Proof: proof}} {
+		messages, err := bm.ExportMessages(0x1000, claim)
+		if err != nil || len(messages) != 1 {
+			t.Errorf("claim %+v: expected one message, got %d, %v", claim, len(messages), err)
+		}
+	}
+	if _, err := bm.ExportMessages(0x1000, RetentionClaim{CertID: "member", Proof: []byte("guess")}); !errors.Is(err, ErrNotBinOwner) {
+		t.Errorf("expected ErrNotBinOwner for a wrong proof, got %v", err)
+	}
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	secret := []byte("ownership secret")
+	stamp := time.Date(2024, 5, 1, 12, 0, 10, 0, time.UTC)
+	chunks := []*ArchiveChunk{
+		{BinID: 0x1000, Messages: []*Message{{BinID: 0x1000, MessageID: "m1", Ciphertext: []byte("one"), Timestamp: stamp}}, Cursor: "c1"},
+		{BinID: 0x1000, Messages: []*Message{{BinID: 0x1000, MessageID: "m2", Ciphertext: []byte("two"), Timestamp: stamp}}, Final: true},
+	}
+
+	var buf bytes.Buffer
+	w, err := NewArchiveWriter(&buf, secret)
+	if err != nil {
+		t.Fatalf("NewArchiveWriter failed: %v", err)
+	}
+	var offsets []int
+	for _, chunk := range chunks {
+		offsets = append(offsets, buf.Len())
+		if err := w.WriteChunk(chunk); err != nil {
+			t.Fatalf("WriteChunk failed: %v", err)
+		}
+	}
+	archive := buf.Bytes()
+
+	r, err := NewArchiveReader(bytes.NewReader(archive), secret)
+	if err != nil {
+		t.Fatalf("NewArchiveReader failed: %v", err)
+	}
+	for i, want := range chunks {
+		got, err := r.Next()
+		if err != nil {
+			t.Fatalf("chunk %d: %v", i, err)
+		}
+		msg := got.Messages[0]
+		if msg.MessageID != want.Messages[0].MessageID || !bytes.Equal(msg.Ciphertext, want.Messages[0].Ciphertext) ||
+			!msg.Timestamp.Equal(stamp) || got.Cursor != want.Cursor {
+			t.Errorf("chunk %d: got %+v", i, got)
+		}
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("expected io.EOF after the final chunk, got %v", err)
+	}
+
+	// A wrong secret, a dropped chunk and a cut-off archive are all detected
+	r, _ = NewArchiveReader(bytes.NewReader(archive), []byte("wrong"))
+	if _, err := r.Next(); !errors.Is(err, ErrArchiveCorrupt) {
+		t.Errorf("wrong secret: expected ErrArchiveCorrupt, got %v", err)
+	}
+	dropped := append(append([]byte{}, archive[:offsets[0]]...), archive[offsets[1]:]...)
+	r, _ = NewArchiveReader(bytes.NewReader(dropped), secret)
+	if _, err := r.Next(); !errors.Is(err, ErrArchiveCorrupt) {
+		t.Errorf("dropped chunk: expected ErrArchiveCorrupt, got %v", err)
+	}
+	r, _ = NewArchiveReader(bytes.NewReader(archive[:offsets[1]]), secret)
+	if _, err := r.Next(); err != nil {
+		t.Fatalf("first chunk: %v", err)
+	}
+	if _, err := r.Next(); !errors.Is(err, ErrArchiveTruncated) {
+		t.Errorf("cut-off archive: expected ErrArchiveTruncated, got %v", err)
+	}
+
+	if _, err := NewArchiveReader(bytes.NewReader([]byte("not an archive")), secret); !errors.Is(err, ErrArchiveFormat) {
+		t.Errorf("expected ErrArchiveFormat, got %v", err)
+	}
+}
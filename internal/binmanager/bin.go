@@ -1,39 +1,138 @@
 package binmanager
 
 import (
+	"fmt"
+	"log"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// farFuture is used as the open upper bound when ranging over a store
+var farFuture = time.Unix(1<<40, 0)
+
 // Client interface represents a connected client that can receive messages
 type Client interface {
 	SendMessage(*Message) error
 }
 
+// Usage counts retained messages and their accounted bytes
+type Usage struct {
+	Messages int64 `json:"messages"`
+	Bytes    int64 `json:"bytes"`
+}
+
+// add applies a delta atomically
+func (u *Usage) add(messages, bytes int64) {
+	atomic.AddInt64(&u.Messages, messages)
+	atomic.AddInt64(&u.Bytes, bytes)
+}
+
+// load reads the counters atomically
+func (u *Usage) load() Usage {
+	return Usage{
+		Messages: atomic.LoadInt64(&u.Messages),
+		Bytes:    atomic.LoadInt64(&u.Bytes),
+	}
+}
+
 // Bin represents a message bin that clients can subscribe to
 type Bin struct {
 	ID       uint64
 	Messages []*Message
 	Clients  map[string]Client
+	store    BinStore
+	usage    Usage
+	global   *Usage // Manager-wide totals, if owned by a BinManager
+	override binRetention
+	epoch    atomic.Uint64     // Routing epoch the bin was last settled in
+	presence int               // Subscriber count last announced; guarded by clMutex
+	received atomic.Uint64     // Messages fanned out, for load measurements
+	masks    map[string]uint64 // Mask each client subscribed with, if any; guarded by clMutex
 	msgMutex sync.RWMutex
 	clMutex  sync.RWMutex
+	retMutex sync.Mutex
 }
 
-// NewBin creates a new message bin
+// NewBin creates a new message bin backed by the in-memory Messages slice
 func NewBin(id uint64) *Bin {
-	return &Bin{
+	b := &Bin{
 		ID:       id,
 		Messages: make([]*Message, 0, 100),
 		Clients:  make(map[string]Client),
 	}
+	b.store = newSliceStore(&b.Messages)
+	return b
+}
+
+// NewBinWithStore creates a new message bin backed by the given store.
+// Usage is initialized from whatever the store already holds.
+func NewBinWithStore(id uint64, store BinStore) *Bin {
+	b := &Bin{
+		ID:       id,
+		Messages: make([]*Message, 0),
+		Clients:  make(map[string]Client),
+		store:    store,
+	}
+	stats := store.Stats()
+	b.usage = Usage{Messages: int64(stats.MessageCount), Bytes: stats.Bytes}
+	return b
 }
 
 // AddMessage adds a message to the bin
-func (b *Bin) AddMessage(msg *Message) {
+func (b *Bin) AddMessage(msg *Message) error {
+	_, err := b.addMessage(msg, false)
+	return err
+}
+
+// AddMessageDurable adds a message to the bin and, if the store is durable,
+// waits until it is synced. It reports whether the message is durable.
+func (b *Bin) AddMessageDurable(msg *Message) (bool, error) {
+	return b.addMessage(msg, true)
+}
+
+// addMessage appends to the store, synced if durable is set and supported
+func (b *Bin) addMessage(msg *Message, durable bool) (bool, error) {
 	b.msgMutex.Lock()
 	defer b.msgMutex.Unlock()
 	
-	b.Messages = append(b.Messages, msg)
+	var err error
+	ds, synced := b.store.(DurableStore)
+	if durable && synced {
+		err = ds.AppendMessageDurable(msg)
+	} else {
+		synced = false
+		err = b.store.AppendMessage(msg)
+	}
+	if err != nil {
+		// The write may have landed anyway; trust the store's own count
+		b.resyncLocked()
+		return false, fmt.Errorf("bin %X: %w", b.ID, err)
+	}
+	b.account(1, msg.Size())
+	return synced, nil
+}
+
+// Usage returns the bin's retained message count and bytes without
+// consulting the store
+func (b *Bin) Usage() Usage {
+	return b.usage.load()
+}
+
+// account applies a usage delta to the bin and the manager totals
+func (b *Bin) account(messages, bytes int64) {
+	b.usage.add(messages, bytes)
+	if b.global != nil {
+		b.global.add(messages, bytes)
+	}
+}
+
+// resyncLocked recomputes usage from the store after bulk changes; callers
+// hold msgMutex
+func (b *Bin) resyncLocked() {
+	stats := b.store.Stats()
+	current := b.usage.load()
+	b.account(int64(stats.MessageCount)-current.Messages, stats.Bytes-current.Bytes)
 }
 
 // GetRecentMessages returns messages newer than the cutoff time
@@ -42,38 +141,37 @@ func (b *Bin) GetRecentMessages(retention time.Duration) []*Message {
 	defer b.msgMutex.RUnlock()
 	
 	cutoff := time.Now().Add(-retention)
-	result := make([]*Message, 0)
-	
-	// Find messages newer than cutoff
-	for _, msg := range b.Messages {
-		if msg.Timestamp.After(cutoff) {
-			result = append(result, msg)
-		}
+	result, err := b.store.RangeByTime(cutoff, farFuture)
+	if err != nil {
+		log.Printf("Failed to read messages for bin %X: %v", b.ID, err)
+		return []*Message{}
 	}
 	
 	return result
 }
 
-// RemoveMessagesBefore removes messages older than the specified time
-func (b *Bin) RemoveMessagesBefore(cutoff time.Time) {
+// RemoveMessagesBefore removes messages older than the specified time and
+// returns how many were removed
+func (b *Bin) RemoveMessagesBefore(cutoff time.Time) int {
 	b.msgMutex.Lock()
 	defer b.msgMutex.Unlock()
 	
-	// Find index of first message newer than cutoff
-	idx := 0
-	for i, msg := range b.Messages {
-		if msg.Timestamp.After(cutoff) {
-			idx = i
-			break
-		}
+	removed, err := b.store.DeleteBefore(cutoff)
+	if err != nil {
+		log.Printf("Failed to remove expired messages for bin %X: %v", b.ID, err)
 	}
-	
-	// If all messages are newer than cutoff, idx will be 0
-	// If all messages are older than cutoff, idx will be len(b.Messages)
-	if idx > 0 {
-		// Remove all messages before idx
-		b.Messages = b.Messages[idx:]
+	if removed > 0 || err != nil {
+		b.resyncLocked()
 	}
+	return removed
+}
+
+// Stats returns summary information about the bin's stored messages
+func (b *Bin) Stats() StoreStats {
+	b.msgMutex.RLock()
+	defer b.msgMutex.RUnlock()
+	
+	return b.store.Stats()
 }
 
 // AddClient adds a client to the bin's subscribers
@@ -82,6 +180,57 @@ func (b *Bin) AddClient(clientID string, client Client) {
 	defer b.clMutex.Unlock()
 	
 	b.Clients[clientID] = client
+	delete(b.masks, clientID)
+}
+
+// addSubscriber adds a client that subscribed with mask. Until it renews
+// the subscription, it also covers the bins later expansions split off
+// this one: those whose ID agrees with this bin's on mask.
+func (b *Bin) addSubscriber(clientID string, client Client, mask uint64) {
+	b.clMutex.Lock()
+	defer b.clMutex.Unlock()
+	
+	b.Clients[clientID] = client
+	if b.masks == nil {
+		b.masks = make(map[string]uint64)
+	}
+	b.masks[clientID] = mask
+}
+
+// coveringClients returns the subscribers that subscribed before binID was
+// split off this bin, so their subscription still covers it
+func (b *Bin) coveringClients(binID uint64) map[string]Client {
+	b.clMutex.RLock()
+	defer b.clMutex.RUnlock()
+	
+	clients := make(map[string]Client)
+	for id, mask := range b.masks {
+		if binID != b.ID && binID&mask == b.ID {
+			clients[id] = b.Clients[id]
+		}
+	}
+	return clients
+}
+
+// subscribedWithout returns which of bits some subscriber's mask lacks
+func (b *Bin) subscribedWithout(bits uint64) uint64 {
+	b.clMutex.RLock()
+	defer b.clMutex.RUnlock()
+	
+	var lacking uint64
+	for _, mask := range b.masks {
+		lacking |= bits &^ mask
+	}
+	return lacking
+}
+
+// hasClient reports whether a client is subscribed to the bin
+func (b *Bin) hasClient(clientID string) bool {
+	b.clMutex.RLock()
+	defer b.clMutex.RUnlock()
+	
+	_, exists := b.Clients[clientID]
+	return exists
 }
 
 // RemoveClient removes a client from the bin's subscribers
@@ -90,10 +239,12 @@ func (b *Bin) RemoveClient(clientID string) {
 	defer b.clMutex.Unlock()
 	
 	delete(b.Clients, clientID)
+	delete(b.masks, clientID)
 }
 
-// BroadcastMessage sends a message to all subscribed clients
-func (b *Bin) BroadcastMessage(msg *Message) {
+// BroadcastMessage sends a message to all subscribed clients and returns
+// the number it was sent to
+func (b *Bin) BroadcastMessage(msg *Message) int {
 	b.clMutex.RLock()
 	clients := make(map[string]Client, len(b.Clients))
 	for id, client := range b.Clients {
@@ -103,6 +254,7 @@ func (b *Bin) BroadcastMessage(msg *Message) {
 	
 	// Send to each client concurrently
 	var wg sync.WaitGroup
+	var sent int32
 	for id, client := range clients {
 		wg.Add(1)
 		go func(cid string, c Client) {
@@ -111,20 +263,38 @@ func (b *Bin) BroadcastMessage(msg *Message) {
 			if err != nil {
 				// Client might have disconnected
 				b.RemoveClient(cid)
+				return
 			}
+			atomic.AddInt32(&sent, 1)
 		}(id, client)
 	}
 	
 	wg.Wait()
+	return int(sent)
 }
 
-// mergeFrom merges messages and clients from another bin
+// mergeFrom merges messages, clients and retention overrides from another
+// bin. Merged subscriptions cover this bin whole, so their masks lose the
+// bits the two bin IDs differ in.
 func (b *Bin) mergeFrom(other *Bin) {
 	// Merge messages
 	b.msgMutex.Lock()
-	other.msgMutex.RLock()
-	b.Messages = append(b.Messages, other.Messages...)
-	other.msgMutex.RUnlock()
+	other.msgMutex.Lock()
+	messages, err := other.store.RangeByTime(time.Time{}, farFuture)
+	if err != nil {
+		log.Printf("Failed to read messages while merging bin %X: %v", other.ID, err)
+	}
+	for _, msg := range messages {
+		if err := b.store.AppendMessage(msg); err != nil {
+			log.Printf("Failed to move message into bin %X: %v", b.ID, err)
+		}
+	}
+	if _, err := other.store.DeleteBefore(farFuture); err != nil {
+		log.Printf("Failed to clear merged bin %X: %v", other.ID, err)
+	}
+	b.resyncLocked()
+	other.resyncLocked()
+	other.msgMutex.Unlock()
 	b.msgMutex.Unlock()
 	
 	// Merge clients
@@ -132,7 +302,17 @@ func (b *Bin) mergeFrom(other *Bin) {
 	other.clMutex.RLock()
 	for id, client := range other.Clients {
 		b.Clients[id] = client
+		if mask, exists := other.masks[id]; exists {
+			if b.masks == nil {
+				b.masks = make(map[string]uint64)
+			}
+			b.masks[id] = mask &^ (other.ID ^ b.ID)
+		} else {
+			delete(b.masks, id)
+		}
 	}
 	other.clMutex.RUnlock()
 	b.clMutex.Unlock()
-}
\ No newline at end of file
+
+	b.mergeRetention(other)
+}
@@ -0,0 +1,177 @@
+package binmanager
+
+import (
+	"crypto/sha256"
+	"errors"
+	"time"
+
+	"github.com/yourusername/secure-messaging-poc/internal/legalhold"
+	cryptopkg "github.com/yourusername/secure-messaging-poc/pkg/crypto"
+)
+
+var (
+	// ErrRetentionOverrideDisabled is returned when per-bin retention has
+	// not been enabled with SetRetentionBounds
+	ErrRetentionOverrideDisabled = errors.New("per-bin retention is not enabled")
+	// ErrRetentionOutOfBounds is returned for a retention outside the bounds
+	ErrRetentionOutOfBounds = errors.New("requested retention is outside the allowed range")
+	// ErrRetentionNotOwner is returned when the claimant neither created the
+	// bin nor holds its ownership proof
+	ErrRetentionNotOwner = errors.New("retention can only be set by the bin's creator")
+)
+
+// RetentionClaim identifies who asks for a bin's retention. The first
+// publisher to a bin becomes its owner; later requests must come from the
+// same certificate or carry the proof the owner supplied.
+type RetentionClaim struct {
+	CertID string
+	Proof  []byte // Channel-ownership secret; only its hash is kept
+}
+
+// binRetention is a bin's retention override, guarded by Bin.retMutex
+type binRetention struct {
+	retention time.Duration // 0 if the bin uses the manager's retention
+	owner     string
+	proof     []byte // SHA-256 of the ownership secret, if one was given
+}
+
+// claimRetention sets the override if claim is allowed to. A bin without an
+// owner can only be claimed before anything has been stored in it.
+func (b *Bin) claimRetention(retention time.Duration, claim RetentionClaim) error {
+	b.retMutex.Lock()
+	defer b.retMutex.Unlock()
+
+	if !b.claimOwnerLocked(claim) {
+		return ErrRetentionNotOwner
+	}
+	b.override.retention = retention
+	return nil
+}
+
+// claimOwnerLocked reports whether claim may act as the bin's owner, making
+// it the owner if the bin has none and is still empty. The owner's proof is
+// remembered. Callers hold retMutex.
+func (b *Bin) claimOwnerLocked(claim RetentionClaim) bool {
+	switch {
+	case b.override.owner == "":
+		if b.usage.load().Messages > 0 {
+			return false
+		}
+		b.override.owner = claim.CertID
+	case cryptopkg.ConstantTimeEqualString(b.override.owner, claim.CertID):
+	case b.override.proof != nil && len(claim.Proof) > 0:
+		sum := sha256.Sum256(claim.Proof)
+		if !cryptopkg.ConstantTimeEqual(sum[:], b.override.proof) {
+			return false
+		}
+	default:
+		return false
+	}
+
+	if len(claim.Proof) > 0 && cryptopkg.ConstantTimeEqualString(claim.CertID, b.override.owner) {
+		sum := sha256.Sum256(claim.Proof)
+		b.override.proof = sum[:]
+	}
+	return true
+}
+
+// ownedBy reports whether claim comes from the bin's owner or holds its
+// ownership proof
+func (b *Bin) ownedBy(claim RetentionClaim) bool {
+	b.retMutex.Lock()
+	defer b.retMutex.Unlock()
+
+	switch {
+	case b.override.owner == "":
+		return false
+	case cryptopkg.ConstantTimeEqualString(b.override.owner, claim.CertID):
+		return true
+	case b.override.proof != nil && len(claim.Proof) > 0:
+		sum := sha256.Sum256(claim.Proof)
+		return cryptopkg.ConstantTimeEqual(sum[:], b.override.proof)
+	}
+	return false
+}
+
+// retentionOverride returns the bin's override, or 0 if it has none
+func (b *Bin) retentionOverride() time.Duration {
+	b.retMutex.Lock()
+	defer b.retMutex.Unlock()
+	return b.override.retention
+}
+
+// mergeRetention keeps the longer of two overrides when bins are merged, so
+// contraction never shortens what a creator asked for
+func (b *Bin) mergeRetention(other *Bin) {
+	b.retMutex.Lock()
+	defer b.retMutex.Unlock()
+	other.retMutex.Lock()
+	defer other.retMutex.Unlock()
+
+	if other.override.retention > b.override.retention {
+		b.override = other.override
+	}
+}
+
+// SetRetentionBounds enables per-bin retention within [min, max]. A max of
+// zero disables it; bins then use the manager's retention again.
+func (bm *BinManager) SetRetentionBounds(min, max time.Duration) {
+	bm.mutex.Lock()
+	defer bm.mutex.Unlock()
+	bm.minOverride = min
+	bm.maxOverride = max
+}
+
+// RetentionBounds returns the range per-bin retention may be set within;
+// max is zero when it is disabled
+func (bm *BinManager) RetentionBounds() (min, max time.Duration) {
+	bm.mutex.RLock()
+	defer bm.mutex.RUnlock()
+	return bm.minOverride, bm.maxOverride
+}
+
+// SetBinRetention overrides the retention of a bin, creating it if needed.
+// Overrides are kept in memory only.
+func (bm *BinManager) SetBinRetention(binID uint64, retention time.Duration, claim RetentionClaim) error {
+	min, max := bm.RetentionBounds()
+	if max <= 0 {
+		return ErrRetentionOverrideDisabled
+	}
+	if retention < min || retention > max {
+		return ErrRetentionOutOfBounds
+	}
+	return bm.getOrCreateBin(binID).claimRetention(retention, claim)
+}
+
+// BinRetention returns the retention that applies to a bin
+func (bm *BinManager) BinRetention(binID uint64) time.Duration {
+	bin, exists := bm.lookupBin(binID)
+	if !exists {
+		return bm.Retention()
+	}
+	return bm.retentionFor(bin)
+}
+
+// retentionFor returns the bin's override, clamped to the current bounds,
+// or the manager's retention if it has none, never past the build's
+// retention ceiling
+func (bm *BinManager) retentionFor(bin *Bin) time.Duration {
+	bm.mutex.RLock()
+	retention, min, max := bm.retention, bm.minOverride, bm.maxOverride
+	bm.mutex.RUnlock()
+
+	// Whatever was configured, nothing outlives the build's ceiling
+	retention, min, max = legalhold.Clamp(retention), legalhold.Clamp(min), legalhold.Clamp(max)
+
+	override := bin.retentionOverride()
+	switch {
+	case override <= 0 || max <= 0:
+		return retention
+	case override > max:
+		return max
+	case override < min:
+		return min
+	default:
+		return override
+	}
+}
@@ -0,0 +1,137 @@
+package binmanager
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/yourusername/secure-messaging-poc/internal/legalhold"
+)
+
+func TestSetBinRetentionBounds(t *testing.T) {
+	bm := NewBinManager(0xFFFFFFFFFFFFF000, time.Hour)
+	claim := RetentionClaim{CertID: "creator"}
+
+	if err := bm.SetBinRetention(0x1000, 2*time.Hour, claim); !errors.Is(err, ErrRetentionOverrideDisabled) {
+		t.Fatalf("expected ErrRetentionOverrideDisabled, got %v", err)
+	}
+
+	bm.SetRetentionBounds(time.Hour, 48*time.Hour)
+	for _, retention := range []time.Duration{time.Minute, 72 * time.Hour} {
+		if err := bm.SetBinRetention(0x1000, retention, claim); !errors.Is(err, ErrRetentionOutOfBounds) {
+			t.Errorf("retention %s: expected ErrRetentionOutOfBounds, got %v", retention, err)
+		}
+	}
+
+	if err := bm.SetBinRetention(0x1000, 48*time.Hour, claim); err != nil {
+		t.Fatalf("SetBinRetention failed: %v", err)
+	}
+	if got := bm.BinRetention(0x1000); got != 48*time.Hour {
+		t.Errorf("expected 48h, got %s", got)
+	}
+	if got := bm.BinRetention(0x2000); got != time.Hour {
+		t.Errorf("bin without override: expected 1h, got %s", got)
+	}
+
+	// Narrowing the bounds clamps existing overrides
+	bm.SetRetentionBounds(time.Hour, 12*time.Hour)
+	if got := bm.BinRetention(0x1000); got != 12*time.Hour {
+		t.Errorf("expected clamped 12h, got %s", got)
+	}
+	bm.SetRetentionBounds(0, 0)
+	if got := bm.BinRetention(0x1000); got != time.Hour {
+		t.Errorf("expected global retention once disabled, got %s", got)
+	}
+}
+
+func TestSetBinRetentionOwnership(t *testing.T) {
+	bm := NewBinManager(0xFFFFFFFFFFFFF000, time.Hour)
+	bm.SetRetentionBounds(time.Hour, 48*time.Hour)
+	proof := []byte("channel ownership secret")
+
+	if err := bm.SetBinRetention(0x1000, 2*time.Hour, RetentionClaim{CertID: "creator", Proof: proof}); err != nil {
+		t.Fatalf("creator claim failed: %v", err)
+	}
+	if err := bm.SetBinRetention(0x1000, 3*time.Hour, RetentionClaim{CertID: "creator"}); err != nil {
+		t.Errorf("creator update failed: %v", err)
+	}
+	if err := bm.SetBinRetention(0x1000, 4*time.Hour, RetentionClaim{CertID: "other"}); !errors.Is(err, ErrRetentionNotOwner) {
+		t.Errorf("expected ErrRetentionNotOwner without proof, got %v", err)
+	}
+	if err := bm.SetBinRetention(0x1000, 4*time.Hour, RetentionClaim{CertID: "other", Proof: []byte("wrong")}); !errors.Is(err, ErrRetentionNotOwner) {
+		t.Errorf("expected ErrRetentionNotOwner with wrong proof, got %v", err)
+	}
+	if err := bm.SetBinRetention(0x1000, 4*time.Hour, RetentionClaim{CertID: "other", Proof: proof}); err != nil {
+		t.Errorf("proof holder update failed: %v", err)
+	}
+	if got := bm.BinRetention(0x1000); got != 4*time.Hour {
+		t.Errorf("expected 4h, got %s", got)
+	}
+
+	// A bin that already holds messages has no creator left to claim it
+	if err := bm.AddMessage(NewMessage(0x2000, "m1", []byte("data"))); err != nil {
+		t.Fatalf("AddMessage failed: %v", err)
+	}
+	if err := bm.SetBinRetention(0x2000, 2*time.Hour, RetentionClaim{CertID: "late"}); !errors.Is(err, ErrRetentionNotOwner) {
+		t.Errorf("expected ErrRetentionNotOwner for a used bin, got %v", err)
+	}
+}
+
+func TestCleanupUsesBinRetention(t *testing.T) {
+	bm := NewBinManager(0xFFFFFFFFFFFFF000, time.Hour)
+	bm.SetRetentionBounds(time.Hour, 48*time.Hour)
+	if err := bm.SetBinRetention(0x1000, 24*time.Hour, RetentionClaim{CertID: "creator"}); err != nil {
+		t.Fatalf("SetBinRetention failed: %v", err)
+	}
+
+	old := time.Now().Add(-2 * time.Hour)
+	for _, binID := range []uint64{0x1000, 0x2000} {
+		bin := bm.getOrCreateBin(binID)
+		msg := NewMessage(binID, "old", []byte("data"))
+		msg.Timestamp = old
+		if err := bin.AddMessage(msg); err != nil {
+			t.Fatalf("AddMessage failed: %v", err)
+		}
+	}
+
+	if got := len(bm.GetRecentMessages(0x1000)); got != 1 {
+		t.Errorf("expected the override to keep 1 message visible, got %d", got)
+	}
+	if got := len(bm.GetRecentMessages(0x2000)); got != 0 {
+		t.Errorf("expected global retention to hide the message, got %d", got)
+	}
+	if removed := bm.RunOnce(); removed != 1 {
+		t.Errorf("expected cleanup to remove 1 message, removed %d", removed)
+	}
+	if got := bm.BinRetention(0x1000); got != 24*time.Hour {
+		t.Errorf("expected 24h, got %s", got)
+	}
+}
+
+func TestContractBinsKeepsLongerRetention(t *testing.T) {
+	bm := NewBinManager(0xFFFFFFFFFFFFF000, time.Hour)
+	bm.SetRetentionBounds(time.Hour, 48*time.Hour)
+	if err := bm.SetBinRetention(0x1000, 2*time.Hour, RetentionClaim{CertID: "a"}); err != nil {
+		t.Fatalf("SetBinRetention failed: %v", err)
+	}
+	if err := bm.SetBinRetention(0x0000, 6*time.Hour, RetentionClaim{CertID: "b"}); err != nil {
+		t.Fatalf("SetBinRetention failed: %v", err)
+	}
+
+	bm.ContractBins()
+	if got := bm.BinRetention(0x0000); got != 6*time.Hour {
+		t.Errorf("expected merged bin to keep 6h, got %s", got)
+	}
+}
+
+func TestRetentionCeiling(t *testing.T) {
+	bm := NewBinManager(0xFFFFFFFFFFFFF000, 100000*time.Hour)
+	if bm.Retention() != legalhold.MaxRetention() {
+		t.Fatalf("Retention should be clamped to %s, got %s", legalhold.MaxRetention(), bm.Retention())
+	}
+
+	addAgedMessages(bm, legalhold.MaxRetention()+time.Hour, time.Hour)
+	if removed := bm.RunOnce(); removed != 1 {
+		t.Errorf("Message past the ceiling should be removed, %d removed", removed)
+	}
+}
@@ -0,0 +1,79 @@
+package binmanager
+
+import (
+	"time"
+)
+
+// subscriberBuckets bound the ranges subscriber counts are reported in
+var subscriberBuckets = []struct {
+	max   int
+	label string
+}{
+	{0, "0"},
+	{5, "1-5"},
+	{20, "6-20"},
+	{100, "21-100"},
+	{500, "101-500"},
+}
+
+// OwnerStats are coarse statistics about a bin for its owner. They identify
+// no subscriber: the subscriber count is bucketed and the last activity is
+// truncated to the hour.
+type OwnerStats struct {
+	BinID            uint64     `json:"bin_id"`
+	Retained         int        `json:"retained"` // Messages within the bin's retention
+	MessagesLastHour int        `json:"messages_last_hour"`
+	MessagesLastDay  int        `json:"messages_last_day"`
+	Subscribers      string     `json:"subscribers"`             // Bucket such as "6-20"
+	LastActivity     *time.Time `json:"last_activity,omitempty"` // Newest message, to the hour
+}
+
+// OwnerStats returns statistics about a bin to its owner or a holder of its
+// ownership proof. Unknown bins are refused like bins owned by others, so
+// the call does not reveal which bins exist.
+func (bm *BinManager) OwnerStats(binID uint64, claim RetentionClaim) (OwnerStats, error) {
+	bin, exists := bm.lookupBin(binID)
+
+	if !exists || !bin.ownedBy(claim) {
+		return OwnerStats{}, ErrNotBinOwner
+	}
+
+	now := time.Now()
+	stats := OwnerStats{BinID: binID, Subscribers: subscriberBucket(bin.clientCount())}
+	var newest time.Time
+	for _, msg := range bin.GetRecentMessages(bm.retentionFor(bin)) {
+		stats.Retained++
+		age := now.Sub(msg.Timestamp)
+		if age < time.Hour {
+			stats.MessagesLastHour++
+		}
+		if age < 24*time.Hour {
+			stats.MessagesLastDay++
+		}
+		if msg.Timestamp.After(newest) {
+			newest = msg.Timestamp
+		}
+	}
+	if !newest.IsZero() {
+		lastActivity := newest.Truncate(time.Hour)
+		stats.LastActivity = &lastActivity
+	}
+	return stats, nil
+}
+
+// clientCount returns the number of subscribed clients
+func (b *Bin) clientCount() int {
+	b.clMutex.RLock()
+	defer b.clMutex.RUnlock()
+	return len(b.Clients)
+}
+
+// subscriberBucket returns the range a subscriber count is reported in
+func subscriberBucket(n int) string {
+	for _, bucket := range subscriberBuckets {
+		if n <= bucket.max {
+			return bucket.label
+		}
+	}
+	return "over 500"
+}
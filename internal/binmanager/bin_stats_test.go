@@ -0,0 +1,60 @@
+package binmanager
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestOwnerStats(t *testing.T) {
+	bm := NewBinManager(0xFFFFFFFFFFFFF000, 48*time.Hour)
+	proof := []byte("ownership secret")
+	owner := RetentionClaim{CertID: "creator", Proof: proof}
+
+	if _, err := bm.OwnerStats(0x1000, owner); !errors.Is(err, ErrNotBinOwner) {
+		t.Fatalf("expected ErrNotBinOwner for an unknown bin, got %v", err)
+	}
+	if err := bm.ClaimOwnership(0x1000, owner); err != nil {
+		t.Fatalf("ClaimOwnership failed: %v", err)
+	}
+
+	older := NewMessage(0x1000, "m1", []byte("one"))
+	older.Timestamp = time.Now().Add(-3 * time.Hour)
+	if err := bm.getOrCreateBin(0x1000).AddMessage(older); err != nil {
+		t.Fatalf("AddMessage failed: %v", err)
+	}
+	if err := bm.PersistMessage(NewMessage(0x1000, "m2", []byte("two"))); err != nil {
+		t.Fatalf("PersistMessage failed: %v", err)
+	}
+	for _, id := range []string{"a", "b", "c"} {
+		bm.Subscribe(0x1000, id, NewMockClient())
+	}
+
+	stats, err := bm.OwnerStats(0x1000, RetentionClaim{CertID: "member", Proof: proof})
+	if err != nil {
+		t.Fatalf("OwnerStats failed for a proof holder: %v", err)
+	}
+	if stats.Retained != 2 || stats.MessagesLastHour != 1 || stats.MessagesLastDay != 2 {
+		t.Errorf("unexpected message counts: %+v", stats)
+	}
+	if stats.Subscribers != "1-5" {
+		t.Errorf("expected subscribers bucket 1-5, got %q", stats.Subscribers)
+	}
+	if stats.LastActivity == nil || stats.LastActivity.Truncate(time.Hour) != *stats.LastActivity ||
+		time.Since(*stats.LastActivity) > time.Hour {
+		t.Errorf("expected last activity within the hour, truncated to it, got %v", stats.LastActivity)
+	}
+
+	if _, err := bm.OwnerStats(0x1000, RetentionClaim{CertID: "member", Proof: []byte("guess")}); !errors.Is(err, ErrNotBinOwner) {
+		t.Errorf("expected ErrNotBinOwner for a wrong proof, got %v", err)
+	}
+}
+
+func TestSubscriberBucket(t *testing.T) {
+	tests := map[int]string{0: "0", 1: "1-5", 5: "1-5", 6: "6-20", 100: "21-100", 500: "101-500", 501: "over 500"}
+	for n, expected := range tests {
+		if got := subscriberBucket(n); got != expected {
+			t.Errorf("subscriberBucket(%d): expected %q, got %q", n, expected, got)
+		}
+	}
+}
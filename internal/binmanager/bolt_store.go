@@ -0,0 +1,209 @@
+package binmanager
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// boltFileName is the database file inside the storage directory
+const boltFileName = "bins.db"
+
+// BoltStore keeps the messages of all bins in a single BoltDB file, one
+// bucket per bin. Keys are timestamp | message ID, so a bucket is ordered
+// by time. Every write is synced when its transaction commits.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// OpenBoltStore opens or creates the BoltDB database in the directory dir
+func OpenBoltStore(dir string) (*BoltStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(filepath.Join(dir, boltFileName), 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	return &BoltStore{db: db}, nil
+}
+
+// Close closes the underlying database
+func (bs *BoltStore) Close() error {
+	return bs.db.Close()
+}
+
+// ForBin returns the BinStore view for a single bin; it matches StoreFactory
+func (bs *BoltStore) ForBin(binID uint64) BinStore {
+	return &boltBinStore{db: bs.db, name: boltBucketName(binID)}
+}
+
+// Bins lists the IDs of all bins that have stored messages
+func (bs *BoltStore) Bins() ([]uint64, error) {
+	binIDs := make([]uint64, 0)
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, bucket *bolt.Bucket) error {
+			if key, _ := bucket.Cursor().First(); len(name) == 8 && key != nil {
+				binIDs = append(binIDs, binary.BigEndian.Uint64(name))
+			}
+			return nil
+		})
+	})
+	return binIDs, err
+}
+
+// DiskUsage returns the size of the database file. Space freed by
+// DeleteBefore is reused by later writes but not returned to the
+// filesystem.
+func (bs *BoltStore) DiskUsage() (int64, error) {
+	info, err := os.Stat(bs.db.Path())
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// boltBinStore is the per-bin view of a BoltStore
+type boltBinStore struct {
+	db   *bolt.DB
+	name []byte
+}
+
+// AppendMessage writes the message under its time-ordered key, creating
+// the bin's bucket on first use
+func (s *boltBinStore) AppendMessage(msg *Message) error {
+	value, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(s.name)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(boltMessageKey(msg.Timestamp, msg.MessageID), value)
+	})
+}
+
+// AppendMessageDurable writes the message; commits are always synced
+func (s *boltBinStore) AppendMessageDurable(msg *Message) error {
+	return s.AppendMessage(msg)
+}
+
+// RangeByTime walks the bin's bucket between the two timestamps
+func (s *boltBinStore) RangeByTime(from, to time.Time) ([]*Message, error) {
+	result := make([]*Message, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return s.scan(tx, from, to, func(_, value []byte) error {
+			var msg Message
+			if err := json.Unmarshal(value, &msg); err != nil {
+				return err
+			}
+			result = append(result, &msg)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// DeleteBefore removes all keys up to and including cutoff in one
+// transaction
+func (s *boltBinStore) DeleteBefore(cutoff time.Time) (int, error) {
+	removed := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var keys [][]byte
+		err := s.scan(tx, time.Time{}, cutoff, func(key, _ []byte) error {
+			keys = append(keys, append([]byte(nil), key...))
+			return nil
+		})
+		if err != nil || len(keys) == 0 {
+			return err
+		}
+
+		bucket := tx.Bucket(s.name)
+		for _, key := range keys {
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+		removed = len(keys)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return removed, nil
+}
+
+// Stats walks the bin's bucket to count messages and bytes
+func (s *boltBinStore) Stats() StoreStats {
+	var stats StoreStats
+	s.db.View(func(tx *bolt.Tx) error {
+		return s.scan(tx, time.Time{}, farFuture, func(_, value []byte) error {
+			var msg Message
+			if err := json.Unmarshal(value, &msg); err != nil {
+				return nil
+			}
+			stats.MessageCount++
+			stats.Bytes += msg.Size()
+			if stats.Oldest.IsZero() {
+				stats.Oldest = msg.Timestamp
+			}
+			stats.Newest = msg.Timestamp
+			return nil
+		})
+	})
+	return stats
+}
+
+// scan calls fn for the records with from < Timestamp <= to, oldest first
+func (s *boltBinStore) scan(tx *bolt.Tx, from, to time.Time, fn func(key, value []byte) error) error {
+	bucket := tx.Bucket(s.name)
+	if bucket == nil {
+		return nil
+	}
+
+	start := timeKey(from)
+	if start < math.MaxInt64 {
+		start++
+	}
+	limit := timeKey(to)
+
+	cursor := bucket.Cursor()
+	for key, value := cursor.Seek(boltTimePrefix(start)); key != nil; key, value = cursor.Next() {
+		if len(key) < 8 || binary.BigEndian.Uint64(key[:8]) > limit {
+			break
+		}
+		if err := fn(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// boltBucketName returns the name of a bin's bucket
+func boltBucketName(binID uint64) []byte {
+	name := make([]byte, 8)
+	binary.BigEndian.PutUint64(name, binID)
+	return name
+}
+
+// boltTimePrefix returns the key prefix of the messages at a timestamp
+func boltTimePrefix(nanos uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, nanos)
+	return key
+}
+
+// boltMessageKey returns the full key of a message within its bucket
+func boltMessageKey(ts time.Time, messageID string) []byte {
+	return append(boltTimePrefix(timeKey(ts)), messageID...)
+}
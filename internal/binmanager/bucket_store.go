@@ -0,0 +1,162 @@
+package binmanager
+
+import (
+	"sort"
+	"time"
+)
+
+// DefaultBucketWidth is the time span of one segment in a BucketStore
+const DefaultBucketWidth = 5 * time.Minute
+
+// bucket holds the messages of one fixed time segment, oldest first
+type bucket struct {
+	start    time.Time
+	messages []*Message
+	bytes    int64
+}
+
+// BucketStore is an in-memory BinStore that groups messages into fixed time
+// segments. Expiry drops whole segments and only filters the one segment
+// straddling the cutoff, and reads touch only the segments in range, so
+// cleanup cost no longer grows with the number of retained messages.
+type BucketStore struct {
+	width   time.Duration
+	buckets []*bucket // ordered by start
+	count   int
+	bytes   int64
+}
+
+// NewBucketStore creates an empty store with segments of the given width
+func NewBucketStore(width time.Duration) *BucketStore {
+	if width <= 0 {
+		width = DefaultBucketWidth
+	}
+	return &BucketStore{width: width}
+}
+
+// AppendMessage adds the message to its segment. Messages normally arrive in
+// time order and land at the end of the newest segment.
+func (bs *BucketStore) AppendMessage(msg *Message) error {
+	b := bs.bucketFor(msg.Timestamp)
+
+	// Keep the segment ordered; out-of-order inserts only shift the tail
+	i := len(b.messages)
+	for i > 0 && b.messages[i-1].Timestamp.After(msg.Timestamp) {
+		i--
+	}
+	b.messages = append(b.messages, nil)
+	copy(b.messages[i+1:], b.messages[i:])
+	b.messages[i] = msg
+
+	size := msg.Size()
+	b.bytes += size
+	bs.bytes += size
+	bs.count++
+	return nil
+}
+
+// RangeByTime concatenates the segments overlapping the window, filtering
+// only the segments at either edge
+func (bs *BucketStore) RangeByTime(from, to time.Time) ([]*Message, error) {
+	result := make([]*Message, 0)
+
+	first := sort.Search(len(bs.buckets), func(i int) bool {
+		return bs.buckets[i].start.Add(bs.width).After(from)
+	})
+
+	for _, b := range bs.buckets[first:] {
+		if b.start.After(to) {
+			break
+		}
+
+		end := b.start.Add(bs.width)
+		if b.start.After(from) && !end.After(to) {
+			result = append(result, b.messages...)
+			continue
+		}
+
+		for _, msg := range b.messages {
+			if msg.Timestamp.After(from) && !msg.Timestamp.After(to) {
+				result = append(result, msg)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// DeleteBefore drops every segment that ends at or before cutoff and trims
+// the segment containing it
+func (bs *BucketStore) DeleteBefore(cutoff time.Time) (int, error) {
+	removed := 0
+
+	drop := 0
+	for drop < len(bs.buckets) && !bs.buckets[drop].start.Add(bs.width).After(cutoff) {
+		b := bs.buckets[drop]
+		removed += len(b.messages)
+		bs.bytes -= b.bytes
+		bs.buckets[drop] = nil
+		drop++
+	}
+	bs.buckets = bs.buckets[drop:]
+
+	// At most one remaining segment can hold expired messages
+	if len(bs.buckets) > 0 && !bs.buckets[0].messages[0].Timestamp.After(cutoff) {
+		b := bs.buckets[0]
+		n := sort.Search(len(b.messages), func(i int) bool {
+			return b.messages[i].Timestamp.After(cutoff)
+		})
+		for _, msg := range b.messages[:n] {
+			b.bytes -= msg.Size()
+			bs.bytes -= msg.Size()
+		}
+		b.messages = append([]*Message(nil), b.messages[n:]...)
+		removed += n
+
+		if len(b.messages) == 0 {
+			bs.buckets[0] = nil
+			bs.buckets = bs.buckets[1:]
+		}
+	}
+
+	bs.count -= removed
+	return removed, nil
+}
+
+// Stats is maintained incrementally and does not scan messages
+func (bs *BucketStore) Stats() StoreStats {
+	stats := StoreStats{
+		MessageCount: bs.count,
+		Bytes:        bs.bytes,
+	}
+	if len(bs.buckets) > 0 {
+		oldest := bs.buckets[0].messages
+		newest := bs.buckets[len(bs.buckets)-1].messages
+		stats.Oldest = oldest[0].Timestamp
+		stats.Newest = newest[len(newest)-1].Timestamp
+	}
+	return stats
+}
+
+// bucketFor returns the segment covering ts, creating it if needed
+func (bs *BucketStore) bucketFor(ts time.Time) *bucket {
+	start := ts.Truncate(bs.width)
+
+	// Fast path: the newest segment
+	if n := len(bs.buckets); n > 0 && bs.buckets[n-1].start.Equal(start) {
+		return bs.buckets[n-1]
+	}
+
+	i := sort.Search(len(bs.buckets), func(i int) bool {
+		return !bs.buckets[i].start.Before(start)
+	})
+	if i < len(bs.buckets) && bs.buckets[i].start.Equal(start) {
+		return bs.buckets[i]
+	}
+
+	b := &bucket{start: start}
+	bs.buckets = append(bs.buckets, nil)
+	copy(bs.buckets[i+1:], bs.buckets[i:])
+	bs.buckets[i] = b
+	return b
+}
@@ -0,0 +1,140 @@
+package binmanager
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestBucketStore(t *testing.T) {
+	testStoreBehavior(t, NewBucketStore(time.Minute))
+	testStoreBehavior(t, NewBucketStore(DefaultBucketWidth))
+}
+
+func TestBucketStoreDropsWholeBuckets(t *testing.T) {
+	store := NewBucketStore(time.Minute)
+	base := time.Now().Truncate(time.Minute)
+
+	// Three messages in each of five one-minute buckets
+	for i := 0; i < 15; i++ {
+		store.AppendMessage(&Message{
+			MessageID:  fmt.Sprintf("msg%d", i),
+			Ciphertext: []byte("x"),
+			Timestamp:  base.Add(time.Duration(i) * 20 * time.Second),
+		})
+	}
+	if len(store.buckets) != 5 {
+		t.Fatalf("Expected 5 buckets, got %d", len(store.buckets))
+	}
+
+	// Cutoff in the middle of the third bucket
+	removed, _ := store.DeleteBefore(base.Add(2*time.Minute + 20*time.Second))
+	if removed != 8 {
+		t.Errorf("Expected 8 messages removed, got %d", removed)
+	}
+	if len(store.buckets) != 3 {
+		t.Errorf("Two whole buckets should have been dropped, %d remain", len(store.buckets))
+	}
+
+	remaining, _ := store.RangeByTime(time.Time{}, farFuture)
+	var size int64
+	for _, msg := range remaining {
+		size += msg.Size()
+	}
+
+	stats := store.Stats()
+	if stats.MessageCount != 7 || stats.Bytes != size {
+		t.Errorf("Stats not updated after delete: %+v", stats)
+	}
+	if !stats.Oldest.Equal(base.Add(2*time.Minute + 40*time.Second)) {
+		t.Errorf("Oldest message incorrect: %v", stats.Oldest)
+	}
+}
+
+func TestBucketStoreOutOfOrder(t *testing.T) {
+	store := NewBucketStore(time.Minute)
+	base := time.Now().Truncate(time.Minute)
+
+	// Messages merged from another bin may arrive out of time order
+	for _, offset := range []time.Duration{90 * time.Second, 10 * time.Second, 30 * time.Second, 70 * time.Second} {
+		store.AppendMessage(&Message{MessageID: offset.String(), Timestamp: base.Add(offset)})
+	}
+
+	result, _ := store.RangeByTime(time.Time{}, farFuture)
+	if len(result) != 4 {
+		t.Fatalf("Expected 4 messages, got %d", len(result))
+	}
+	for i := 1; i < len(result); i++ {
+		if result[i].Timestamp.Before(result[i-1].Timestamp) {
+			t.Errorf("Messages not returned oldest first: %v before %v", result[i-1].Timestamp, result[i].Timestamp)
+		}
+	}
+}
+
+// retainedMessages is the store size used by the benchmarks
+const retainedMessages = 1000000
+
+// fillStore appends retainedMessages messages spread evenly over 24 hours
+func fillStore(b *testing.B, store BinStore, start time.Time) {
+	b.Helper()
+	step := 24 * time.Hour / retainedMessages
+	for i := 0; i < retainedMessages; i++ {
+		store.AppendMessage(&Message{
+			MessageID:  fmt.Sprintf("msg%d", i),
+			Ciphertext: make([]byte, 16),
+			Timestamp:  start.Add(time.Duration(i) * step),
+		})
+	}
+}
+
+// benchmarkCleanup expires one minute of messages per iteration, as the
+// cleanup service would, topping the store up so its size stays constant
+func benchmarkCleanup(b *testing.B, store BinStore) {
+	start := time.Now().Add(-24 * time.Hour)
+	fillStore(b, store, start)
+	perMinute := retainedMessages / (24 * 60)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cutoff := start.Add(time.Duration(i+1) * time.Minute)
+
+		b.StopTimer()
+		for j := 0; j < perMinute; j++ {
+			store.AppendMessage(&Message{
+				Ciphertext: make([]byte, 16),
+				Timestamp:  cutoff.Add(24 * time.Hour),
+			})
+		}
+		b.StartTimer()
+
+		store.DeleteBefore(cutoff)
+	}
+}
+
+// benchmarkRecent reads the last five minutes of a full store
+func benchmarkRecent(b *testing.B, store BinStore) {
+	start := time.Now().Add(-24 * time.Hour)
+	fillStore(b, store, start)
+	from := start.Add(24*time.Hour - 5*time.Minute)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		store.RangeByTime(from, farFuture)
+	}
+}
+
+func BenchmarkBucketStoreCleanup(b *testing.B) {
+	benchmarkCleanup(b, NewBucketStore(DefaultBucketWidth))
+}
+
+func BenchmarkMemoryStoreCleanup(b *testing.B) {
+	benchmarkCleanup(b, NewMemoryStore())
+}
+
+func BenchmarkBucketStoreRecent(b *testing.B) {
+	benchmarkRecent(b, NewBucketStore(DefaultBucketWidth))
+}
+
+func BenchmarkMemoryStoreRecent(b *testing.B) {
+	benchmarkRecent(b, NewMemoryStore())
+}
@@ -0,0 +1,98 @@
+package binmanager
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// quarantineKeyPrefix marks message records moved aside by a check. They
+// keep their original key after the prefix and are never read as messages.
+const quarantineKeyPrefix = 'q'
+
+// Issues found by LevelDBStore.Check
+const (
+	// IssueCorrupt is a record that does not decode as a message
+	IssueCorrupt = "corrupt"
+	// IssueMisfiled is a message stored under another bin's key
+	IssueMisfiled = "misfiled"
+	// IssueOrphaned is a bin no channel maps to under the current mask, left
+	// behind by a mask change; its messages are unreachable
+	IssueOrphaned = "orphaned"
+)
+
+// StoreProblem is a record found by LevelDBStore.Check
+type StoreProblem struct {
+	Key         string `json:"key"` // Hex
+	BinID       uint64 `json:"bin_id"`
+	Issue       string `json:"issue"`
+	Detail      string `json:"detail,omitempty"`
+	Quarantined bool   `json:"quarantined"`
+}
+
+// Check scans every message record for ones that do not decode or are
+// filed under the wrong bin, and reports each bin with bits outside mask
+// once. With quarantine, corrupt and misfiled records are moved out of the
+// message keyspace, where they stay for inspection. It returns the number
+// of records checked.
+func (ls *LevelDBStore) Check(mask uint64, quarantine bool) (int, []StoreProblem, error) {
+	iter := ls.db.NewIterator(util.BytesPrefix([]byte{messageKeyPrefix}), nil)
+	defer iter.Release()
+
+	checked := 0
+	problems := make([]StoreProblem, 0)
+	orphaned := make(map[uint64]bool)
+	batch := new(leveldb.Batch)
+	for iter.Next() {
+		key := iter.Key()
+		checked++
+		if len(key) < 17 {
+			problems = append(problems, StoreProblem{Key: hex.EncodeToString(key), Issue: IssueCorrupt, Detail: "short key", Quarantined: quarantine})
+			quarantineRecord(batch, key, iter.Value(), quarantine)
+			continue
+		}
+
+		binID := binary.BigEndian.Uint64(key[1:9])
+		problem := StoreProblem{Key: hex.EncodeToString(key), BinID: binID}
+		var msg Message
+		if err := json.Unmarshal(iter.Value(), &msg); err != nil {
+			problem.Issue, problem.Detail = IssueCorrupt, err.Error()
+		} else if msg.BinID != binID {
+			problem.Issue, problem.Detail = IssueMisfiled, fmt.Sprintf("message names bin %X", msg.BinID)
+		} else {
+			if binID&mask != binID && !orphaned[binID] {
+				orphaned[binID] = true
+				problems = append(problems, StoreProblem{Key: hex.EncodeToString(binKeyPrefix(binID)), BinID: binID, Issue: IssueOrphaned})
+			}
+			continue
+		}
+		problem.Quarantined = quarantine
+		problems = append(problems, problem)
+		quarantineRecord(batch, key, iter.Value(), quarantine)
+	}
+	if err := iter.Error(); err != nil {
+		return checked, problems, err
+	}
+
+	if batch.Len() > 0 {
+		if err := ls.db.Write(batch, nil); err != nil {
+			return checked, problems, err
+		}
+	}
+	return checked, problems, nil
+}
+
+// quarantineRecord adds the move of a record out of the message keyspace
+// to batch, if quarantine is set
+func quarantineRecord(batch *leveldb.Batch, key, value []byte, quarantine bool) {
+	if !quarantine {
+		return
+	}
+	moved := append([]byte{quarantineKeyPrefix}, key...)
+	batch.Put(moved, append([]byte(nil), value...))
+	batch.Delete(append([]byte(nil), key...))
+}
@@ -0,0 +1,43 @@
+package binmanager
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLevelDBStoreCheck(t *testing.T) {
+	store, err := OpenLevelDBStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to open LevelDB store: %v", err)
+	}
+	defer store.Close()
+
+	now := time.Now()
+	store.ForBin(0x1000).AppendMessage(&Message{BinID: 0x1000, MessageID: "good", Timestamp: now})
+	store.ForBin(0x2000).AppendMessage(&Message{BinID: 0x1000, MessageID: "misfiled", Timestamp: now})
+	if err := store.db.Put(messageKey(0x1000, now.Add(time.Second), "corrupt"), []byte("{"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	checked, problems, err := store.Check(0xFFFFFFFFFFFFF000, false)
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if checked != 3 || len(problems) != 2 {
+		t.Fatalf("Expected 2 problems in 3 records, got %d in %d: %+v", len(problems), checked, problems)
+	}
+	if count := store.ForBin(0x1000).Stats().MessageCount; count != 1 {
+		t.Errorf("Report-only check changed the store")
+	}
+
+	if _, problems, err = store.Check(0xFFFFFFFFFFFFF000, true); err != nil || !problems[0].Quarantined {
+		t.Fatalf("Quarantine failed: %v %+v", err, problems)
+	}
+	checked, problems, _ = store.Check(0xFFFFFFFFFFFFF000, false)
+	if checked != 1 || len(problems) != 0 {
+		t.Errorf("Quarantined records still in the message keyspace: %d checked, %+v", checked, problems)
+	}
+	if binIDs, _ := store.Bins(); len(binIDs) != 1 || binIDs[0] != 0x1000 {
+		t.Errorf("Unexpected bins after quarantine: %X", binIDs)
+	}
+}
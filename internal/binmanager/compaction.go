@@ -0,0 +1,208 @@
+package binmanager
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// CompactionStats records one compaction pass
+type CompactionStats struct {
+	Started        time.Time     `json:"started"`
+	Duration       time.Duration `json:"duration_ns"`
+	Expired        int           `json:"expired_messages"`
+	Evicted        int           `json:"evicted_messages"`
+	BytesBefore    int64         `json:"bytes_before"`
+	BytesAfter     int64         `json:"bytes_after"`
+	ReclaimedBytes int64         `json:"reclaimed_bytes"`
+}
+
+// CompactionStatus describes the compactor state for the admin API
+type CompactionStatus struct {
+	Running      bool             `json:"running"`
+	DiskBytes    int64            `json:"disk_bytes"`
+	MaxDiskBytes int64            `json:"max_disk_bytes"`
+	Last         *CompactionStats `json:"last,omitempty"`
+}
+
+// Compactor reclaims the disk space of a LevelDB bin store. Each pass drops
+// the messages past their retention, merges the store's tables so deleted
+// records leave the disk, and, if the store is still larger than its
+// budget, evicts the oldest messages of all bins until it fits. Evictions
+// go through the bins, so usage accounting stays correct.
+type Compactor struct {
+	bm        *BinManager
+	store     *LevelDBStore
+	maxBytes  int64
+	onCompact func(CompactionStats)
+	last      *CompactionStats
+	running   bool
+	runMu     sync.Mutex // Serializes passes
+	ticker    *time.Ticker
+	done      chan struct{}
+	mu        sync.Mutex
+}
+
+// NewCompactor creates a compactor for the store backing bm. A maxBytes of
+// 0 leaves the disk usage unbounded.
+func NewCompactor(bm *BinManager, store *LevelDBStore, maxBytes int64) *Compactor {
+	return &Compactor{bm: bm, store: store, maxBytes: maxBytes}
+}
+
+// OnCompaction registers a callback invoked after every pass
+func (c *Compactor) OnCompaction(fn func(CompactionStats)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onCompact = fn
+}
+
+// Compact runs one pass now, waiting for a pass in progress to finish first
+func (c *Compactor) Compact() (CompactionStats, error) {
+	c.runMu.Lock()
+	defer c.runMu.Unlock()
+	return c.compactLocked()
+}
+
+// Trigger starts a pass in the background and reports false if one is
+// already running
+func (c *Compactor) Trigger() bool {
+	if !c.runMu.TryLock() {
+		return false
+	}
+	c.setRunning(true)
+	go func() {
+		defer c.runMu.Unlock()
+		if _, err := c.compactLocked(); err != nil {
+			log.Printf("Failed to compact bin store: %v", err)
+		}
+	}()
+	return true
+}
+
+// setRunning records whether a pass is in progress
+func (c *Compactor) setRunning(running bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.running = running
+}
+
+// compactLocked runs one pass; callers hold c.runMu
+func (c *Compactor) compactLocked() (CompactionStats, error) {
+	c.setRunning(true)
+	defer c.setRunning(false)
+
+	stats := CompactionStats{Started: time.Now()}
+	before, err := c.store.DiskUsage()
+	if err != nil {
+		return stats, err
+	}
+	stats.BytesBefore = before
+
+	stats.Expired = c.bm.RunOnce()
+	if err := c.store.Compact(); err != nil {
+		return stats, err
+	}
+
+	if c.maxBytes > 0 {
+		usage, err := c.store.DiskUsage()
+		if err != nil {
+			return stats, err
+		}
+		if usage > c.maxBytes {
+			// Records shrink on disk about as much as they do in the table
+			cutoff, err := c.store.evictionCutoff(float64(usage-c.maxBytes) / float64(usage))
+			if err != nil {
+				return stats, err
+			}
+			if !cutoff.IsZero() {
+				stats.Evicted = c.bm.Flush(cutoff)
+				if err := c.store.Compact(); err != nil {
+					return stats, err
+				}
+			}
+		}
+	}
+
+	after, err := c.store.DiskUsage()
+	if err != nil {
+		return stats, err
+	}
+	stats.BytesAfter = after
+	if after < before {
+		stats.ReclaimedBytes = before - after
+	}
+	stats.Duration = time.Since(stats.Started)
+
+	c.mu.Lock()
+	c.last = &stats
+	onCompact := c.onCompact
+	c.mu.Unlock()
+
+	log.Printf("Compacted bin store in %v: %d expired and %d evicted messages, %d of %d bytes reclaimed",
+		stats.Duration, stats.Expired, stats.Evicted, stats.ReclaimedBytes, stats.BytesBefore)
+	if onCompact != nil {
+		onCompact(stats)
+	}
+	return stats, nil
+}
+
+// Status returns the current disk usage and the last pass
+func (c *Compactor) Status() CompactionStatus {
+	usage, err := c.store.DiskUsage()
+	if err != nil {
+		log.Printf("Failed to measure bin store: %v", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	status := CompactionStatus{
+		Running:      c.running,
+		DiskBytes:    usage,
+		MaxDiskBytes: c.maxBytes,
+	}
+	if c.last != nil {
+		last := *c.last
+		status.Last = &last
+	}
+	return status
+}
+
+// Start runs a pass every interval until Stop is called
+func (c *Compactor) Start(interval time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.ticker != nil {
+		return
+	}
+	c.ticker = time.NewTicker(interval)
+	c.done = make(chan struct{})
+
+	go func(ticker *time.Ticker, done chan struct{}) {
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := c.Compact(); err != nil {
+					log.Printf("Failed to compact bin store: %v", err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}(c.ticker, c.done)
+}
+
+// Stop stops periodic compaction and waits for a pass in progress, so the
+// store can be closed afterwards
+func (c *Compactor) Stop() {
+	c.mu.Lock()
+	if c.ticker != nil {
+		c.ticker.Stop()
+		close(c.done)
+		c.ticker = nil
+	}
+	c.mu.Unlock()
+
+	c.runMu.Lock()
+	c.runMu.Unlock()
+}
@@ -0,0 +1,99 @@
+package binmanager
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCompactorDropsExpiredMessages(t *testing.T) {
+	store, err := OpenLevelDBStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to open LevelDB store: %v", err)
+	}
+	defer store.Close()
+
+	manager := NewBinManagerWithStore(0xFFFFFFFFFFFFF000, time.Hour, store.ForBin)
+	payload := bytes.Repeat([]byte{0xAB}, 1024)
+	for i := 0; i < 200; i++ {
+		if err := manager.AddMessage(&Message{BinID: 0x1000, MessageID: fmt.Sprintf("msg%d", i), Ciphertext: payload}); err != nil {
+			t.Fatalf("Failed to add message: %v", err)
+		}
+	}
+	manager.SetRetention(time.Nanosecond)
+	time.Sleep(time.Millisecond)
+
+	var reported CompactionStats
+	compactor := NewCompactor(manager, store, 0)
+	compactor.OnCompaction(func(stats CompactionStats) { reported = stats })
+	stats, err := compactor.Compact()
+	if err != nil {
+		t.Fatalf("Compact failed: %v", err)
+	}
+	if stats.Expired != 200 {
+		t.Errorf("Expected 200 expired messages, got %d", stats.Expired)
+	}
+	if stats.BytesAfter >= stats.BytesBefore || stats.ReclaimedBytes == 0 {
+		t.Errorf("Compaction reclaimed nothing: %+v", stats)
+	}
+	if reported.Started != stats.Started {
+		t.Error("OnCompaction callback was not called")
+	}
+	if status := compactor.Status(); status.Last == nil || status.Running {
+		t.Errorf("Unexpected status %+v", status)
+	}
+
+	// A triggered pass runs in the background; Stop waits for it
+	if !compactor.Trigger() {
+		t.Error("Trigger refused with no pass running")
+	}
+	compactor.Stop()
+	if status := compactor.Status(); status.Running {
+		t.Error("Pass still running after Stop")
+	}
+}
+
+func TestCompactorBoundsDiskUsage(t *testing.T) {
+	store, err := OpenLevelDBStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to open LevelDB store: %v", err)
+	}
+	defer store.Close()
+
+	manager := NewBinManagerWithStore(0xFFFFFFFFFFFFF000, time.Hour, store.ForBin)
+	payload := bytes.Repeat([]byte{0xCD}, 1024)
+	for i := 0; i < 400; i++ {
+		binID := uint64(0x1000 * (1 + i%2))
+		if err := manager.AddMessage(&Message{BinID: binID, MessageID: fmt.Sprintf("msg%d", i), Ciphertext: payload}); err != nil {
+			t.Fatalf("Failed to add message: %v", err)
+		}
+	}
+	if _, err := NewCompactor(manager, store, 0).Compact(); err != nil {
+		t.Fatalf("Compact failed: %v", err)
+	}
+	usage, err := store.DiskUsage()
+	if err != nil {
+		t.Fatalf("DiskUsage failed: %v", err)
+	}
+
+	stats, err := NewCompactor(manager, store, usage/2).Compact()
+	if err != nil {
+		t.Fatalf("Compact failed: %v", err)
+	}
+	if stats.Evicted == 0 || stats.Expired != 0 {
+		t.Errorf("Expected only evictions, got %+v", stats)
+	}
+	if retained := manager.Usage().Messages; retained != int64(400-stats.Evicted) {
+		t.Errorf("Usage shows %d messages after evicting %d of 400", retained, stats.Evicted)
+	}
+
+	// The newest messages are kept
+	messages := manager.GetRecentMessages(0x2000)
+	if len(messages) == 0 || messages[len(messages)-1].MessageID != "msg399" {
+		t.Errorf("Newest message was evicted")
+	}
+	if messages := manager.GetRecentMessages(0x1000); len(messages) > 0 && messages[0].MessageID == "msg0" {
+		t.Errorf("Oldest message was kept")
+	}
+}
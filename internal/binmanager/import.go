@@ -0,0 +1,99 @@
+package binmanager
+
+import (
+	"io"
+	"time"
+)
+
+// ImportResult counts what became of the messages of an imported archive
+type ImportResult struct {
+	Imported   int `json:"imported"`
+	Duplicates int `json:"duplicates"`
+	Expired    int `json:"expired"`
+}
+
+// ClaimOwnership checks that claim may act as the owner of a bin, making it
+// the owner of a bin that has none and holds no messages yet
+func (bm *BinManager) ClaimOwnership(binID uint64, claim RetentionClaim) error {
+	bin := bm.getOrCreateBin(bm.GetBinID(binID))
+
+	bin.retMutex.Lock()
+	defer bin.retMutex.Unlock()
+	if !bin.claimOwnerLocked(claim) {
+		return ErrNotBinOwner
+	}
+	return nil
+}
+
+// importMessage stores an archived message under its own timestamp, without
+// broadcasting it
+func (bm *BinManager) importMessage(msg *Message) error {
+	bin := bm.getOrCreateBin(msg.BinID)
+	msg.compact()
+	return bin.AddMessage(msg)
+}
+
+// ImportArchive stores the messages of an archive in a bin as history only.
+// They pass the validators, sanitizing and dedup but not the authorizers,
+// which judge live publishers, are flagged Imported and are never fanned
+// out. Each message is stamped no earlier than its archived time, capped at
+// the present, and strictly after the one before it, so the bin keeps the
+// archive's order; messages already past the bin's retention are skipped.
+// Imports go to the bin manager even if the persister was replaced.
+//
+// Messages stored before an error are kept, and dedup drops them when the
+// import is retried.
+func (p *Pipeline) ImportArchive(binID uint64, archive *ArchiveReader) (ImportResult, error) {
+	var result ImportResult
+	binID = p.bins.GetBinID(binID)
+	now := time.Now()
+	cutoff := now.Add(-p.bins.BinRetention(binID))
+
+	var last time.Time
+	for {
+		chunk, err := archive.Next()
+		if err == io.EOF {
+			return result, nil
+		}
+		if err != nil {
+			return result, err
+		}
+
+		for _, msg := range chunk.Messages {
+			stamp := msg.Timestamp
+			msg.BinID = binID
+			for _, validator := range p.validators {
+				if err := validator.ValidateMessage(msg); err != nil {
+					return result, &StageError{Stage: StageValidate, Err: err}
+				}
+			}
+			msg.sanitize()
+
+			if !stamp.After(cutoff) {
+				result.Expired++
+				continue
+			}
+			if p.dedup != nil && p.dedup.Seen(msg) {
+				result.Duplicates++
+				continue
+			}
+
+			if stamp.After(now) {
+				stamp = now
+			}
+			if !stamp.After(last) {
+				stamp = last.Add(time.Nanosecond)
+			}
+			msg.Timestamp = stamp
+			msg.Imported = true
+			if err := p.bins.importMessage(msg); err != nil {
+				if p.dedup != nil {
+					p.dedup.Forget(msg)
+				}
+				return result, &StageError{Stage: StagePersist, Err: err}
+			}
+			last = stamp
+			result.Imported++
+		}
+	}
+}
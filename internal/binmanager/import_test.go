@@ -0,0 +1,95 @@
+package binmanager
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+// newTestArchive seals messages into a single-chunk archive
+func newTestArchive(t *testing.T, secret []byte, messages ...*Message) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewArchiveWriter(&buf, secret)
+	if err != nil {
+		t.Fatalf("NewArchiveWriter failed: %v", err)
+	}
+	if err := w.WriteChunk(&ArchiveChunk{BinID: 0x7000, Messages: messages, Final: true}); err != nil {
+		t.Fatalf("WriteChunk failed: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestImportArchive(t *testing.T) {
+	bm := NewBinManager(0xFFFFFFFFFFFFF000, time.Hour)
+	p := NewPipeline(bm, WithValidators(MaxCiphertext(8)))
+	client := NewMockClient()
+	bm.Subscribe(0x1000, "subscriber", client)
+
+	secret := []byte("ownership secret")
+	window := time.Now().Add(-10 * time.Minute).Truncate(time.Minute)
+	archive := newTestArchive(t, secret,
+		&Message{MessageID: "old", Ciphertext: []byte("old"), Timestamp: window.Add(-2 * time.Hour)},
+		&Message{MessageID: "m1", Ciphertext: []byte("one"), Timestamp: window, PoW: []byte("stripped")},
+		&Message{MessageID: "m2", Ciphertext: []byte("two"), Timestamp: window},
+	)
+
+	reader, _ := NewArchiveReader(bytes.NewReader(archive), secret)
+	result, err := p.ImportArchive(0x1000, reader)
+	if err != nil {
+		t.Fatalf("ImportArchive failed: %v", err)
+	}
+	if result != (ImportResult{Imported: 2, Expired: 1}) {
+		t.Errorf("Unexpected result %+v", result)
+	}
+	if n := len(client.GetMessages()); n != 0 {
+		t.Errorf("Imported messages must not be broadcast, got %d", n)
+	}
+
+	stored := bm.GetRecentMessages(0x1000)
+	if len(stored) != 2 || stored[0].MessageID != "m1" || stored[1].MessageID != "m2" {
+		t.Fatalf("Expected m1 and m2 in archive order, got %v", stored)
+	}
+	for _, msg := range stored {
+		if !msg.Imported || msg.BinID != 0x1000 || msg.PoW != nil {
+			t.Errorf("Unexpected stored message %+v", msg)
+		}
+	}
+	if !stored[0].Timestamp.Equal(window) || !stored[1].Timestamp.After(stored[0].Timestamp) {
+		t.Errorf("Expected strictly increasing local timestamps, got %v and %v", stored[0].Timestamp, stored[1].Timestamp)
+	}
+
+	// A retried import skips what is already there
+	reader, _ = NewArchiveReader(bytes.NewReader(archive), secret)
+	if result, _ := p.ImportArchive(0x1000, reader); result.Duplicates != 2 || result.Imported != 0 {
+		t.Errorf("Expected two duplicates on retry, got %+v", result)
+	}
+
+	// Size policies apply to imports as to publishes
+	archive = newTestArchive(t, secret, &Message{MessageID: "big", Ciphertext: []byte("far too large"), Timestamp: window})
+	reader, _ = NewArchiveReader(bytes.NewReader(archive), secret)
+	if _, err := p.ImportArchive(0x1000, reader); !errors.Is(err, ErrMessageTooLarge) {
+		t.Errorf("Expected ErrMessageTooLarge, got %v", err)
+	}
+}
+
+func TestClaimOwnership(t *testing.T) {
+	bm := NewBinManager(0xFFFFFFFFFFFFF000, time.Hour)
+	proof := []byte("ownership secret")
+
+	if err := bm.ClaimOwnership(0x1000, RetentionClaim{CertID: "creator", Proof: proof}); err != nil {
+		t.Fatalf("An empty bin should be claimable, got %v", err)
+	}
+	if err := bm.ClaimOwnership(0x1000, RetentionClaim{CertID: "member", Proof: proof}); err != nil {
+		t.Errorf("The proof holder should act as owner, got %v", err)
+	}
+	if err := bm.ClaimOwnership(0x1000, RetentionClaim{CertID: "stranger"}); !errors.Is(err, ErrNotBinOwner) {
+		t.Errorf("Expected ErrNotBinOwner, got %v", err)
+	}
+
+	bm.PersistMessage(NewMessage(0x2000, "m", []byte("x")))
+	if err := bm.ClaimOwnership(0x2000, RetentionClaim{CertID: "late", Proof: proof}); !errors.Is(err, ErrNotBinOwner) {
+		t.Errorf("A bin with messages cannot be claimed, got %v", err)
+	}
+}
@@ -0,0 +1,266 @@
+package binmanager
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io/fs"
+	"math"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// messageKeyPrefix marks message records in the LevelDB keyspace
+const messageKeyPrefix = 'm'
+
+// LevelDBStore keeps the messages of all bins in a single LevelDB database.
+// Keys are prefix | bin ID | timestamp | message ID, so each bin is a
+// contiguous, time-ordered range.
+type LevelDBStore struct {
+	db   *leveldb.DB
+	path string
+}
+
+// OpenLevelDBStore opens or creates a LevelDB database at path
+func OpenLevelDBStore(path string) (*LevelDBStore, error) {
+	db, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &LevelDBStore{db: db, path: path}, nil
+}
+
+// Close closes the underlying database
+func (ls *LevelDBStore) Close() error {
+	return ls.db.Close()
+}
+
+// ForBin returns the BinStore view for a single bin; it matches StoreFactory
+func (ls *LevelDBStore) ForBin(binID uint64) BinStore {
+	return &levelDBBinStore{db: ls.db, binID: binID}
+}
+
+// Bins lists the IDs of all bins that have stored messages
+func (ls *LevelDBStore) Bins() ([]uint64, error) {
+	iter := ls.db.NewIterator(util.BytesPrefix([]byte{messageKeyPrefix}), nil)
+	defer iter.Release()
+
+	binIDs := make([]uint64, 0)
+	for ok := iter.First(); ok; {
+		key := iter.Key()
+		if len(key) < 9 {
+			ok = iter.Next()
+			continue
+		}
+
+		binID := binary.BigEndian.Uint64(key[1:9])
+		binIDs = append(binIDs, binID)
+
+		if binID == math.MaxUint64 {
+			break
+		}
+		// Skip the rest of this bin's range
+		ok = iter.Seek(binKeyPrefix(binID + 1))
+	}
+
+	return binIDs, iter.Error()
+}
+
+// DiskUsage returns the bytes the database's files take up on disk
+func (ls *LevelDBStore) DiskUsage() (int64, error) {
+	var total int64
+	err := filepath.WalkDir(ls.path, func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		total += info.Size()
+		return nil
+	})
+	return total, err
+}
+
+// Compact merges the database's tables, dropping deleted and overwritten
+// records, so space freed by DeleteBefore is returned to the filesystem
+func (ls *LevelDBStore) Compact() error {
+	return ls.db.CompactRange(util.Range{})
+}
+
+// evictionCutoff returns the timestamp up to which the oldest messages of
+// all bins together make up fraction of the stored record bytes
+func (ls *LevelDBStore) evictionCutoff(fraction float64) (time.Time, error) {
+	type record struct {
+		nanos uint64
+		size  int64
+	}
+	iter := ls.db.NewIterator(util.BytesPrefix([]byte{messageKeyPrefix}), nil)
+	defer iter.Release()
+
+	var records []record
+	var total int64
+	for iter.Next() {
+		key := iter.Key()
+		if len(key) < 17 {
+			continue
+		}
+		size := int64(len(key) + len(iter.Value()))
+		records = append(records, record{nanos: binary.BigEndian.Uint64(key[9:17]), size: size})
+		total += size
+	}
+	if err := iter.Error(); err != nil {
+		return time.Time{}, err
+	}
+	if len(records) == 0 {
+		return time.Time{}, nil
+	}
+
+	sort.Slice(records, func(i, j int) bool { return records[i].nanos < records[j].nanos })
+	target := int64(fraction * float64(total))
+	var evicted int64
+	for _, r := range records {
+		evicted += r.size
+		if evicted >= target {
+			return time.Unix(0, int64(r.nanos)), nil
+		}
+	}
+	return time.Unix(0, int64(records[len(records)-1].nanos)), nil
+}
+
+// levelDBBinStore is the per-bin view of a LevelDBStore
+type levelDBBinStore struct {
+	db    *leveldb.DB
+	binID uint64
+}
+
+// AppendMessage writes the message under its time-ordered key
+func (s *levelDBBinStore) AppendMessage(msg *Message) error {
+	value, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return s.db.Put(messageKey(s.binID, msg.Timestamp, msg.MessageID), value, nil)
+}
+
+// AppendMessageDurable writes the message and syncs the journal
+func (s *levelDBBinStore) AppendMessageDurable(msg *Message) error {
+	value, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return s.db.Put(messageKey(s.binID, msg.Timestamp, msg.MessageID), value, &opt.WriteOptions{Sync: true})
+}
+
+// RangeByTime iterates the bin's key range between the two timestamps
+func (s *levelDBBinStore) RangeByTime(from, to time.Time) ([]*Message, error) {
+	iter := s.db.NewIterator(s.timeRange(from, to), nil)
+	defer iter.Release()
+
+	result := make([]*Message, 0)
+	for iter.Next() {
+		var msg Message
+		if err := json.Unmarshal(iter.Value(), &msg); err != nil {
+			return nil, err
+		}
+		result = append(result, &msg)
+	}
+
+	return result, iter.Error()
+}
+
+// DeleteBefore removes all keys up to and including cutoff in one batch
+func (s *levelDBBinStore) DeleteBefore(cutoff time.Time) (int, error) {
+	iter := s.db.NewIterator(s.timeRange(time.Time{}, cutoff), nil)
+	defer iter.Release()
+
+	batch := new(leveldb.Batch)
+	for iter.Next() {
+		batch.Delete(append([]byte(nil), iter.Key()...))
+	}
+	if err := iter.Error(); err != nil {
+		return 0, err
+	}
+
+	if batch.Len() == 0 {
+		return 0, nil
+	}
+
+	return batch.Len(), s.db.Write(batch, nil)
+}
+
+// Stats scans the bin's range to count messages and bytes
+func (s *levelDBBinStore) Stats() StoreStats {
+	iter := s.db.NewIterator(util.BytesPrefix(binKeyPrefix(s.binID)), nil)
+	defer iter.Release()
+
+	var stats StoreStats
+	for iter.Next() {
+		var msg Message
+		if err := json.Unmarshal(iter.Value(), &msg); err != nil {
+			continue
+		}
+		stats.MessageCount++
+		stats.Bytes += msg.Size()
+		if stats.Oldest.IsZero() {
+			stats.Oldest = msg.Timestamp
+		}
+		stats.Newest = msg.Timestamp
+	}
+
+	return stats
+}
+
+// timeRange returns the key range for from < Timestamp <= to
+func (s *levelDBBinStore) timeRange(from, to time.Time) *util.Range {
+	start := timeKey(from)
+	if start < math.MaxInt64 {
+		start++
+	}
+	limit := timeKey(to)
+	if limit < math.MaxInt64 {
+		limit++
+	}
+
+	return &util.Range{
+		Start: binTimePrefix(s.binID, start),
+		Limit: binTimePrefix(s.binID, limit),
+	}
+}
+
+// binKeyPrefix returns the key prefix shared by all messages of a bin
+func binKeyPrefix(binID uint64) []byte {
+	key := make([]byte, 9)
+	key[0] = messageKeyPrefix
+	binary.BigEndian.PutUint64(key[1:], binID)
+	return key
+}
+
+// binTimePrefix returns the key prefix for a bin at a given timestamp
+func binTimePrefix(binID, nanos uint64) []byte {
+	key := make([]byte, 17)
+	copy(key, binKeyPrefix(binID))
+	binary.BigEndian.PutUint64(key[9:], nanos)
+	return key
+}
+
+// messageKey returns the full key of a message
+func messageKey(binID uint64, ts time.Time, messageID string) []byte {
+	return append(binTimePrefix(binID, timeKey(ts)), messageID...)
+}
+
+// timeKey converts a timestamp to sortable nanoseconds, clamped to the int64 range
+func timeKey(ts time.Time) uint64 {
+	if ts.Before(time.Unix(0, 0)) {
+		return 0
+	}
+	if ts.After(time.Unix(0, math.MaxInt64)) {
+		return math.MaxInt64
+	}
+	return uint64(ts.UnixNano())
+}
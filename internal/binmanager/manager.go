@@ -1,55 +1,163 @@
 package binmanager
 
 import (
+	"slices"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/yourusername/secure-messaging-poc/internal/legalhold"
 )
 
 // BinManager handles the routing and storage of messages in bins
 type BinManager struct {
 	bins           map[uint64]*Bin
 	mutex          sync.RWMutex
-	currentMask    uint64
+	routing        atomic.Pointer[routingTable] // Current epoch; replaced under mutex
+	maskHistory    map[uint64]uint64            // Recent past epochs -> their masks
 	retention      time.Duration
+	minOverride    time.Duration // Bounds of per-bin retention; max 0 disables it
+	maxOverride    time.Duration
 	cleanupTicker  *time.Ticker
 	cleanupDone    chan struct{}
+	cleanupExited  chan struct{}
+	cleanupMu      sync.Mutex // Guards the cleanup service lifecycle
+	newStore       StoreFactory
+	usage          Usage
+	prefixSubs     map[string]*prefixSubscriber // clientID -> bin ranges
+	prefixMu       sync.RWMutex
+
+	presenceThreshold atomic.Int64 // Presence summaries are multiples of this; 0 for none
 }
 
 // NewBinManager creates a new bin manager with the specified initial mask and message retention period
 func NewBinManager(initialMask uint64, retention time.Duration) *BinManager {
-	return &BinManager{
+	return NewBinManagerWithStore(initialMask, retention, nil)
+}
+
+// NewBinManagerWithStore creates a bin manager whose bins are backed by stores
+// from newStore. A nil factory keeps messages in memory, in time buckets.
+func NewBinManagerWithStore(initialMask uint64, retention time.Duration, newStore StoreFactory) *BinManager {
+	bm := &BinManager{
 		bins:        make(map[uint64]*Bin),
-		currentMask: initialMask,
+		maskHistory: make(map[uint64]uint64),
 		retention:   retention,
-		cleanupDone: make(chan struct{}),
+		newStore:    newStore,
+		prefixSubs:  make(map[string]*prefixSubscriber),
 	}
+	bm.routing.Store(&routingTable{mask: initialMask})
+	return bm
 }
 
-// GetBinID calculates the bin ID from a channel ID using the current mask
+// MaskState is the bin mask together with its epoch, which increases with
+// every mask change, so routing decisions can be tied to the mask they were
+// made with
+type MaskState struct {
+	Mask  uint64
+	Epoch uint64
+}
+
+// GetBinID calculates the bin ID from a channel ID using the current mask.
+// Bin IDs computed with an outdated mask are normalized the same way.
 func (bm *BinManager) GetBinID(channelID uint64) uint64 {
-	return channelID & bm.currentMask
+	return channelID & bm.routing.Load().mask
+}
+
+// MaskState returns the current mask and its epoch as one snapshot
+func (bm *BinManager) MaskState() MaskState {
+	table := bm.routing.Load()
+	return MaskState{Mask: table.mask, Epoch: table.epoch}
 }
 
 // GetCurrentMask returns the current bin mask
 func (bm *BinManager) GetCurrentMask() uint64 {
-	bm.mutex.RLock()
-	defer bm.mutex.RUnlock()
-	return bm.currentMask
+	return bm.routing.Load().mask
 }
 
 // GetRetentionHours returns the message retention period in hours
 func (bm *BinManager) GetRetentionHours() float64 {
-	return bm.retention.Hours()
+	return bm.Retention().Hours()
+}
+
+// Retention returns the effective message retention period, at most the
+// build's retention ceiling
+func (bm *BinManager) Retention() time.Duration {
+	bm.mutex.RLock()
+	defer bm.mutex.RUnlock()
+	return legalhold.Clamp(bm.retention)
+}
+
+// SetRetention changes the effective message retention period. Messages
+// beyond the new period are dropped at the next cleanup.
+func (bm *BinManager) SetRetention(retention time.Duration) {
+	bm.mutex.Lock()
+	defer bm.mutex.Unlock()
+	bm.retention = retention
+}
+
+// Stats sums the store statistics of all bins
+func (bm *BinManager) Stats() StoreStats {
+	var total StoreStats
+	for _, bin := range bm.snapshotBins() {
+		stats := bin.Stats()
+		total.MessageCount += stats.MessageCount
+		total.Bytes += stats.Bytes
+		if !stats.Oldest.IsZero() && (total.Oldest.IsZero() || stats.Oldest.Before(total.Oldest)) {
+			total.Oldest = stats.Oldest
+		}
+		if stats.Newest.After(total.Newest) {
+			total.Newest = stats.Newest
+		}
+	}
+	return total
+}
+
+// Usage returns the retained message count and bytes across all bins. It is
+// maintained incrementally and cheap to call.
+func (bm *BinManager) Usage() Usage {
+	return bm.usage.load()
+}
+
+// BinCount returns the number of bins currently holding state, including
+// legacy bins not yet merged after a contraction
+func (bm *BinManager) BinCount() int {
+	bm.mutex.RLock()
+	defer bm.mutex.RUnlock()
+	return len(bm.bins)
+}
+
+// HasBin reports whether binID already exists, so publishing to it would
+// not create a bin
+func (bm *BinManager) HasBin(binID uint64) bool {
+	_, exists := bm.lookupBin(binID)
+	return exists
+}
+
+// BinUsage returns the retained message count and bytes of every bin
+func (bm *BinManager) BinUsage() map[uint64]Usage {
+	bins := bm.snapshotBins()
+	usage := make(map[uint64]Usage, len(bins))
+	for _, bin := range bins {
+		usage[bin.ID] = bin.Usage()
+	}
+	return usage
 }
 
-// ExpandBins increases the number of bins by adding a new bit to the mask
+// ExpandBins increases the number of bins by adding a new bit to the mask.
+// Like contraction it only publishes a new routing epoch: the bins keep
+// their messages and subscribers, and the half with the new bit set
+// reaches those of the bin it was split off through the routing table, so
+// clients subscribed before the expansion keep receiving both halves.
+// Legacy bins carrying the bit become valid bins again.
 func (bm *BinManager) ExpandBins() {
 	bm.mutex.Lock()
 	defer bm.mutex.Unlock()
 	
 	// Find lowest unset bit in mask
+	table := bm.routing.Load()
 	newBit := uint64(1)
-	for (bm.currentMask & newBit) != 0 && newBit != 0 {
+	for (table.mask & newBit) != 0 && newBit != 0 {
 		newBit <<= 1
 	}
 	
@@ -59,156 +167,319 @@ func (bm *BinManager) ExpandBins() {
 	}
 	
 	// Add the new bit to the mask
-	bm.currentMask |= newBit
+	bm.setMaskLocked(table.mask | newBit)
 }
 
-// ContractBins reduces the number of bins by removing a bit from the mask
+// ContractBins reduces the number of bins by removing a bit from the mask.
+// Only a new routing epoch is published: bins keyed with the removed bit
+// are merged lazily, when their merged bin is next used or by the cleanup
+// pass, so contraction does not rebuild the bins under the lock.
 func (bm *BinManager) ContractBins() {
 	bm.mutex.Lock()
 	defer bm.mutex.Unlock()
 	
 	// Find lowest set bit in mask
+	mask := bm.routing.Load().mask
 	lowestBit := uint64(1)
-	for (bm.currentMask & lowestBit) == 0 && lowestBit != 0 {
+	for (mask & lowestBit) == 0 && lowestBit != 0 {
 		lowestBit <<= 1
 	}
 	
-	if lowestBit == 0 || bm.currentMask == lowestBit {
+	if lowestBit == 0 || mask == lowestBit {
 		// No bits set or only one bit set, can't contract further
 		return
 	}
 	
 	// Clear the lowest bit from the mask
-	newMask := bm.currentMask &^ lowestBit
-	
-	// Merge bins according to new mask
-	newBins := make(map[uint64]*Bin)
-	for binID, bin := range bm.bins {
-		newBinID := binID & newMask
-		if existingBin, exists := newBins[newBinID]; exists {
-			// Merge bins
-			existingBin.mergeFrom(bin)
-		} else {
-			// Just rekey
-			newBins[newBinID] = bin
-		}
-	}
-	
-	bm.bins = newBins
-	bm.currentMask = newMask
+	bm.setMaskLocked(mask &^ lowestBit)
 }
 
 // AddMessage adds a message to the appropriate bin and broadcasts it to subscribers
-func (bm *BinManager) AddMessage(msg *Message) {
-	binID := msg.BinID
+func (bm *BinManager) AddMessage(msg *Message) error {
+	if err := bm.PersistMessage(msg); err != nil {
+		return err
+	}
+	bm.FanoutMessage(msg)
+	return nil
+}
+
+// PersistMessage stores a message in its bin, creating the bin if needed,
+// and stamps it with the server time unless the caller already did. The bin
+// ID is re-masked with the current mask first; a message the pipeline
+// routed with a mask that has since gained bits is refused with
+// ErrStaleMask.
+func (bm *BinManager) PersistMessage(msg *Message) error {
+	bin, err := bm.routedBin(msg)
+	if err != nil {
+		return err
+	}
 	
-	bm.mutex.RLock()
-	bin, exists := bm.bins[binID]
-	bm.mutex.RUnlock()
+	if msg.Timestamp.IsZero() {
+		msg.Timestamp = time.Now()
+	}
+	msg.compact()
+	return bin.AddMessage(msg)
+}
+
+// PersistMessageDurable stores a message like PersistMessage and, if the
+// bin's store is durable, waits until it is synced. It reports whether the
+// message is durable.
+func (bm *BinManager) PersistMessageDurable(msg *Message) (bool, error) {
+	bin, err := bm.routedBin(msg)
+	if err != nil {
+		return false, err
+	}
 	
-	if !exists {
-		bm.mutex.Lock()
-		// Check again to avoid race condition
-		bin, exists = bm.bins[binID]
-		if !exists {
-			bin = NewBin(binID)
-			bm.bins[binID] = bin
+	if msg.Timestamp.IsZero() {
+		msg.Timestamp = time.Now()
+	}
+	msg.compact()
+	return bin.AddMessageDurable(msg)
+}
+
+// FanoutMessage broadcasts a stored message to the subscribers of its bin
+// and to matching prefix subscribers
+func (bm *BinManager) FanoutMessage(msg *Message) {
+	bm.FanoutMessageCount(msg)
+}
+
+// FanoutMessageCount is FanoutMessage, reporting how many subscribers the
+// message was sent to
+func (bm *BinManager) FanoutMessageCount(msg *Message) int {
+	bin := bm.getOrCreateBin(msg.BinID)
+	bin.received.Add(1)
+	return bin.BroadcastMessage(msg) + bm.broadcastInherited(bin, msg) + bm.broadcastPrefix(bin, msg)
+}
+
+// broadcastInherited delivers a message to the subscribers of the bins its
+// bin was split off whose subscriptions predate the split, unless they are
+// subscribed to its bin directly, and returns the number it was sent to.
+// Subscribers that fail are dropped.
+func (bm *BinManager) broadcastInherited(bin *Bin, msg *Message) int {
+	targets := make(map[string]Client)
+	owners := make(map[string]*Bin)
+	for _, ancestor := range bm.ancestors(bin.ID) {
+		for clientID, client := range ancestor.bin.coveringClients(bin.ID) {
+			if _, exists := targets[clientID]; !exists && !bin.hasClient(clientID) {
+				targets[clientID] = client
+				owners[clientID] = ancestor.bin
+			}
+		}
+	}
+
+	sent := 0
+	for clientID, client := range targets {
+		if err := client.SendMessage(msg); err != nil {
+			owners[clientID].RemoveClient(clientID)
+			continue
 		}
-		bm.mutex.Unlock()
+		sent++
 	}
+	return sent
+}
+
+// Subscribe adds a client to the subscribers list for a bin. The
+// subscription is tied to the current mask, so it keeps covering the bins
+// later expansions split off this one.
+func (bm *BinManager) Subscribe(binID uint64, clientID string, client Client) {
+	mask := bm.routing.Load().mask
+	bin := bm.getOrCreateBin(binID)
+	bin.addSubscriber(clientID, client, mask)
+	bm.announcePresence(bin)
+}
+
+// RestoreBins registers bins that already hold messages in a persistent
+// store. Bins stored under a wider mask than the current one are restored
+// as legacy bins, merged into their bin under the mask when it is used,
+// like the bins a contraction leaves.
+func (bm *BinManager) RestoreBins(binIDs []uint64) {
+	bm.mutex.Lock()
+	defer bm.mutex.Unlock()
 	
-	// Set timestamp and store the message
-	msg.Timestamp = time.Now()
-	bin.AddMessage(msg)
+	table := bm.routing.Load()
+	var legacy uint64
+	for _, binID := range binIDs {
+		if _, exists := bm.bins[binID]; !exists {
+			bm.bins[binID] = bm.newBin(binID)
+		}
+		legacy |= binID &^ table.mask
+	}
+	if legacy&^table.unmigrated == 0 {
+		return
+	}
 	
-	// Broadcast to all subscribed clients
-	bin.BroadcastMessage(msg)
+	// A new epoch unsettles the bins the legacy bins map to
+	bm.setMaskLocked(table.mask)
+	restored := *bm.routing.Load()
+	restored.unmigrated |= legacy
+	bm.routing.Store(&restored)
 }
 
-// Subscribe adds a client to the subscribers list for a bin
-func (bm *BinManager) Subscribe(binID uint64, clientID string, client Client) {
+// getOrCreateBin returns the bin for binID under the current mask,
+// creating it if needed
+func (bm *BinManager) getOrCreateBin(binID uint64) *Bin {
+	binID &= bm.routing.Load().mask
 	bm.mutex.RLock()
 	bin, exists := bm.bins[binID]
+	settled := exists && bm.routing.Load().settled(bin)
 	bm.mutex.RUnlock()
 	
-	if !exists {
-		bm.mutex.Lock()
-		// Check again to avoid race condition
-		bin, exists = bm.bins[binID]
-		if !exists {
-			bin = NewBin(binID)
-			bm.bins[binID] = bin
-		}
-		bm.mutex.Unlock()
+	if settled {
+		return bin
+	}
+	
+	bm.mutex.Lock()
+	defer bm.mutex.Unlock()
+	
+	// Check again to avoid race condition
+	return bm.binLocked(binID)
+}
+
+// newBin creates a bin using the configured store factory and adds its
+// existing contents to the manager totals
+func (bm *BinManager) newBin(binID uint64) *Bin {
+	var bin *Bin
+	if bm.newStore == nil {
+		bin = NewBinWithStore(binID, NewBucketStore(DefaultBucketWidth))
+	} else {
+		bin = NewBinWithStore(binID, bm.newStore(binID))
 	}
 	
-	bin.AddClient(clientID, client)
+	usage := bin.Usage()
+	bin.global = &bm.usage
+	bm.usage.add(usage.Messages, usage.Bytes)
+	return bin
 }
 
-// Unsubscribe removes a client from the subscribers list for a bin
+// Unsubscribe removes a client from the subscribers list for a bin, which
+// also ends the coverage of the bins expansions split off it
 func (bm *BinManager) Unsubscribe(binID uint64, clientID string) {
-	bm.mutex.RLock()
-	bin, exists := bm.bins[binID]
-	bm.mutex.RUnlock()
+	bin, exists := bm.lookupBin(binID)
 	
 	if exists {
 		bin.RemoveClient(clientID)
+		bm.announcePresence(bin)
 	}
 }
 
-// GetRecentMessages retrieves messages from a bin within the retention period
+// GetRecentMessages retrieves messages from a bin within its retention
+// period, with those stored in the bins it was split off before the split,
+// oldest first
 func (bm *BinManager) GetRecentMessages(binID uint64) []*Message {
-	bm.mutex.RLock()
-	bin, exists := bm.bins[binID]
-	bm.mutex.RUnlock()
-	
-	if !exists {
-		return []*Message{}
+	messages := []*Message{}
+	if bin, exists := bm.lookupBin(binID); exists {
+		messages = bin.GetRecentMessages(bm.retentionFor(bin))
 	}
 	
-	return bin.GetRecentMessages(bm.retention)
+	ancestors := bm.ancestors(binID)
+	if len(ancestors) == 0 {
+		return messages
+	}
+	messages = slices.Clip(messages)
+	for _, ancestor := range ancestors {
+		for _, msg := range ancestor.bin.GetRecentMessages(bm.retentionFor(ancestor.bin)) {
+			if msg.Timestamp.Before(ancestor.splitAt) {
+				messages = append(messages, msg)
+			}
+		}
+	}
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].Timestamp.Before(messages[j].Timestamp)
+	})
+	return messages
 }
 
-// StartCleanupService starts a background service to clean up old messages
+// StartCleanupService starts a background service to clean up old messages.
+// Calling it while the service runs restarts it with the new interval; it
+// may be started again after Stop.
 func (bm *BinManager) StartCleanupService(interval time.Duration) {
-	if bm.cleanupTicker != nil {
-		bm.cleanupTicker.Stop()
-	}
+	bm.cleanupMu.Lock()
+	defer bm.cleanupMu.Unlock()
+	
+	bm.stopCleanupLocked()
 	
-	bm.cleanupTicker = time.NewTicker(interval)
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	bm.cleanupTicker = ticker
+	bm.cleanupDone = done
+	bm.cleanupExited = exited
 	
 	go func() {
+		defer close(exited)
 		for {
 			select {
-			case <-bm.cleanupTicker.C:
+			case <-ticker.C:
 				bm.cleanup()
-			case <-bm.cleanupDone:
+			case <-done:
 				return
 			}
 		}
 	}()
 }
 
-// Stop stops the cleanup service
+// Stop stops the cleanup service and waits for a pass in progress to
+// finish. It is safe to call more than once, or without a running service.
 func (bm *BinManager) Stop() {
-	if bm.cleanupTicker != nil {
-		bm.cleanupTicker.Stop()
-		close(bm.cleanupDone)
+	bm.cleanupMu.Lock()
+	defer bm.cleanupMu.Unlock()
+	
+	bm.stopCleanupLocked()
+}
+
+// stopCleanupLocked stops a running cleanup service; callers hold bm.cleanupMu
+func (bm *BinManager) stopCleanupLocked() {
+	if bm.cleanupTicker == nil {
+		return
 	}
+	bm.cleanupTicker.Stop()
+	close(bm.cleanupDone)
+	<-bm.cleanupExited
+	bm.cleanupTicker = nil
+	bm.cleanupDone = nil
+	bm.cleanupExited = nil
 }
 
-// cleanup removes old messages from all bins
-func (bm *BinManager) cleanup() {
-	cutoff := time.Now().Add(-bm.retention)
-	
+// RunOnce removes messages older than the retention period from every bin
+// immediately and returns how many were removed. It does not need the
+// cleanup service to be running.
+func (bm *BinManager) RunOnce() int {
+	return bm.cleanup()
+}
+
+// Flush removes every message older than cutoff regardless of the
+// retention period and returns how many were removed
+func (bm *BinManager) Flush(cutoff time.Time) int {
+	removed := 0
+	for _, bin := range bm.snapshotBins() {
+		removed += bin.RemoveMessagesBefore(cutoff)
+	}
+	return removed
+}
+
+// cleanup merges the remaining legacy bins, clears the expansion bits
+// nothing predates and removes messages older than each bin's retention
+// period
+func (bm *BinManager) cleanup() int {
+	bm.migrateBins()
+	now := time.Now()
+	bm.settleSplits(now)
+	removed := 0
+	for _, bin := range bm.snapshotBins() {
+		removed += bin.RemoveMessagesBefore(now.Add(-bm.retentionFor(bin)))
+	}
+	return removed
+}
+
+// snapshotBins returns the current bins so they can be visited without
+// holding the manager lock
+func (bm *BinManager) snapshotBins() []*Bin {
 	bm.mutex.RLock()
+	defer bm.mutex.RUnlock()
+	
 	bins := make([]*Bin, 0, len(bm.bins))
 	for _, bin := range bm.bins {
 		bins = append(bins, bin)
 	}
-	bm.mutex.RUnlock()
-	
-	for _, bin := range bins {
-		bin.RemoveMessagesBefore(cutoff)
-	}
+	return bins
 }
\ No newline at end of file
@@ -1,6 +1,7 @@
 package binmanager
 
 import (
+	"fmt"
 	"sync"
 	"testing"
 	"time"
@@ -23,7 +24,7 @@ func (c *MockClient) SendMessage(msg *Message) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	if c.closed {
-		return nil
+		return fmt.Errorf("client closed")
 	}
 	c.messages = append(c.messages, msg)
 	return nil
@@ -85,7 +86,7 @@ func TestBinManagerBasicFunctionality(t *testing.T) {
 	}
 
 	// Test a non-existent bin
-	nonExistentBinID := uint64(0xAAAAAAAAAAAAAA000)
+	nonExistentBinID := uint64(0xAAAAAAAAAAAAA000)
 	messages = manager.GetRecentMessages(nonExistentBinID)
 	
 	if len(messages) != 0 {
@@ -184,7 +185,7 @@ func TestBinManagerExpandContract(t *testing.T) {
 
 	// Test ExpandBins
 	manager.ExpandBins()
-	expandedMask := uint64(0xFFFFFFFFFFFF8000) // Added 1 bit, now 13 bits
+	expandedMask := uint64(0xFFFFFFFFFFFFF001) // Added the lowest unset bit
 	
 	if manager.GetCurrentMask() != expandedMask {
 		t.Errorf("After ExpandBins, mask should be %X, got %X", expandedMask, manager.GetCurrentMask())
@@ -192,7 +193,7 @@ func TestBinManagerExpandContract(t *testing.T) {
 
 	// Add messages to bins that will be merged when we contract
 	bin1 := uint64(0x1000)
-	bin2 := uint64(0x9000) // Will merge with bin1 when we contract mask by 1 bit
+	bin2 := uint64(0x1001) // Will merge with bin1 when we contract mask by 1 bit
 	
 	msg1 := &Message{
 		BinID:      bin1,
@@ -384,10 +385,10 @@ func TestBinManagerMultipleExpand(t *testing.T) {
 
 	// Test multiple expands
 	masks := []uint64{
-		0xFFFFFFFFFFFF8000, // First expand (13 bits)
-		0xFFFFFFFFFFFFC000, // Second expand (14 bits)
-		0xFFFFFFFFFFFFE000, // Third expand (15 bits)
-		0xFFFFFFFFFFFFF000, // Fourth expand (16 bits)
+		0xFFFFFFFFFFFFF001, // First expand (53 bits)
+		0xFFFFFFFFFFFFF003, // Second expand (54 bits)
+		0xFFFFFFFFFFFFF007, // Third expand (55 bits)
+		0xFFFFFFFFFFFFF00F, // Fourth expand (56 bits)
 	}
 
 	for i, expectedMask := range masks {
@@ -401,16 +402,16 @@ func TestBinManagerMultipleExpand(t *testing.T) {
 }
 
 func TestBinManagerMultipleContract(t *testing.T) {
-	// Create a bin manager with expanded mask (16 bits)
+	// Create a bin manager with expanded mask (48 bits)
 	expandedMask := uint64(0xFFFFFFFFFFFF0000)
 	manager := NewBinManager(expandedMask, 1*time.Hour)
 
 	// Test multiple contracts
 	masks := []uint64{
-		0xFFFFFFFFFFFFF000, // First contract (15 bits)
-		0xFFFFFFFFFFFFE000, // Second contract (14 bits)
-		0xFFFFFFFFFFFFC000, // Third contract (13 bits)
-		0xFFFFFFFFFFFF8000, // Fourth contract (12 bits)
+		0xFFFFFFFFFFFE0000, // First contract (47 bits)
+		0xFFFFFFFFFFFC0000, // Second contract (46 bits)
+		0xFFFFFFFFFFF80000, // Third contract (45 bits)
+		0xFFFFFFFFFFF00000, // Fourth contract (44 bits)
 	}
 
 	for i, expectedMask := range masks {
@@ -471,4 +472,283 @@ func TestBinManagerEdgeCases(t *testing.T) {
 	if manager.GetCurrentMask() != largeMask {
 		t.Errorf("Should not be able to expand beyond maximum mask, got %X", manager.GetCurrentMask())
 	}
-}
\ No newline at end of file
+}
+
+func TestBinManagerUsage(t *testing.T) {
+	manager := NewBinManager(0xFFFFFFFFFFFFF000, time.Hour)
+	now := time.Now()
+
+	old := &Message{BinID: 0x1000, MessageID: "old", Ciphertext: make([]byte, 100), Timestamp: now.Add(-2 * time.Hour)}
+	manager.getOrCreateBin(0x1000).AddMessage(old)
+	manager.AddMessage(&Message{BinID: 0x1000, MessageID: "a", Ciphertext: make([]byte, 10, 4096)})
+	manager.AddMessage(&Message{BinID: 0x2000, MessageID: "b", Ciphertext: make([]byte, 20)})
+
+	messages := manager.GetRecentMessages(0x1000)
+	if cap(messages[len(messages)-1].Ciphertext) != 10 {
+		t.Errorf("Oversized ciphertext buffer should have been compacted")
+	}
+
+	expected := old.Size() + messages[len(messages)-1].Size() + manager.GetRecentMessages(0x2000)[0].Size()
+	usage := manager.Usage()
+	if usage.Messages != 3 || usage.Bytes != expected {
+		t.Errorf("Expected 3 messages and %d bytes, got %+v", expected, usage)
+	}
+	if stats := manager.Stats(); stats.Bytes != usage.Bytes {
+		t.Errorf("Usage %d disagrees with store stats %d", usage.Bytes, stats.Bytes)
+	}
+	
+	// Expiry is reflected in both the bin and global totals
+	manager.cleanup()
+	
+	perBin := manager.BinUsage()
+	if perBin[0x1000].Messages != 1 || perBin[0x2000].Messages != 1 {
+		t.Errorf("Unexpected per-bin usage after cleanup: %+v", perBin)
+	}
+	if usage := manager.Usage(); usage.Messages != 2 || usage.Bytes != expected-old.Size() {
+		t.Errorf("Global usage not updated after cleanup: %+v", usage)
+	}
+}
+
+func TestCleanupServiceLifecycle(t *testing.T) {
+	manager := NewBinManager(0xFFFFFFFFFFFFF000, time.Hour)
+
+	// Stopping a service that never started is a no-op
+	manager.Stop()
+
+	// Restarting and stopping repeatedly must not panic
+	manager.StartCleanupService(time.Hour)
+	manager.StartCleanupService(time.Hour)
+	manager.Stop()
+	manager.Stop()
+	manager.StartCleanupService(10 * time.Millisecond)
+	defer manager.Stop()
+
+	now := time.Now()
+	for i, age := range []time.Duration{3 * time.Hour, 2 * time.Hour, time.Minute} {
+		// Added to the bin directly, as the manager stamps the current time
+		manager.getOrCreateBin(0x1000).AddMessage(&Message{
+			BinID:      0x1000,
+			MessageID:  fmt.Sprintf("msg-%d", i),
+			Ciphertext: []byte("data"),
+			Timestamp:  now.Add(-age),
+		})
+	}
+
+	// The restarted service runs on its new interval
+	deadline := time.Now().Add(2 * time.Second)
+	for manager.Usage().Messages != 1 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if count := manager.Usage().Messages; count != 1 {
+		t.Fatalf("Expected the service to expire two messages, %d remain", count)
+	}
+	manager.Stop()
+
+	manager.getOrCreateBin(0x2000).AddMessage(&Message{BinID: 0x2000, MessageID: "old", Ciphertext: []byte("data"), Timestamp: now.Add(-2 * time.Hour)})
+	if removed := manager.RunOnce(); removed != 1 {
+		t.Errorf("RunOnce should remove the expired message, removed %d", removed)
+	}
+	if removed := manager.Flush(time.Now().Add(time.Second)); removed != 1 {
+		t.Errorf("Flush should remove the remaining message, removed %d", removed)
+	}
+	if usage := manager.Usage(); usage.Messages != 0 {
+		t.Errorf("Expected no retained messages, got %+v", usage)
+	}
+}
+
+func TestMaskEpochAndNormalization(t *testing.T) {
+	manager := NewBinManager(0xFFFFFFFFFFFFF000, time.Hour)
+	if state := manager.MaskState(); state.Mask != 0xFFFFFFFFFFFFF000 || state.Epoch != 0 {
+		t.Fatalf("Unexpected initial state %+v", state)
+	}
+
+	manager.ContractBins()
+	state := manager.MaskState()
+	if state.Mask != 0xFFFFFFFFFFFFE000 || state.Epoch != 1 {
+		t.Fatalf("Contraction should clear a bit and bump the epoch: %+v", state)
+	}
+
+	// A publisher still using the old mask lands in the merged bin
+	msg := &Message{BinID: 0x1000, MessageID: "stale", Ciphertext: []byte("data")}
+	if err := manager.AddMessage(msg); err != nil {
+		t.Fatalf("AddMessage failed: %v", err)
+	}
+	if msg.BinID != 0 {
+		t.Errorf("Expected the bin ID to be normalized to 0, got %#x", msg.BinID)
+	}
+	if manager.HasBin(0x1000) || len(manager.GetRecentMessages(0)) != 1 {
+		t.Error("The message should be stored under the normalized bin only")
+	}
+
+	manager.ExpandBins()
+	if expanded := manager.MaskState(); expanded.Mask == state.Mask || expanded.Epoch != 2 {
+		t.Errorf("Expansion should change the mask and bump the epoch: %+v", expanded)
+	}
+}
+
+func TestContractMigratesLazily(t *testing.T) {
+	manager := NewBinManager(0xFFFFFFFFFFFFF000, time.Hour)
+	for i, binID := range []uint64{0x0000, 0x1000, 0x2000, 0x3000} {
+		msg := &Message{BinID: binID, MessageID: fmt.Sprintf("msg%d", i), Ciphertext: []byte("data")}
+		if err := manager.AddMessage(msg); err != nil {
+			t.Fatalf("AddMessage failed: %v", err)
+		}
+	}
+
+	// Contraction only starts a new epoch; the bins are left in place
+	manager.ContractBins()
+	if count := manager.BinCount(); count != 4 {
+		t.Fatalf("Contraction should not touch the bins, got %d", count)
+	}
+
+	// Using a bin merges the legacy bins that map to it
+	if messages := manager.GetRecentMessages(0x2000); len(messages) != 2 {
+		t.Errorf("Expected 2 messages after the merge, got %d", len(messages))
+	}
+	if manager.HasBin(0x3000) || manager.BinCount() != 3 {
+		t.Errorf("The legacy bin should have been merged, %d bins left", manager.BinCount())
+	}
+
+	// The cleanup pass merges the rest and settles the epoch
+	manager.RunOnce()
+	if count := manager.BinCount(); count != 2 {
+		t.Errorf("Expected 2 bins after the cleanup pass, got %d", count)
+	}
+	if table := manager.routing.Load(); table.unmigrated != 0 || table.epoch != 1 {
+		t.Errorf("Expected epoch 1 to be settled, got %+v", *table)
+	}
+	if messages := manager.GetRecentMessages(0); len(messages) != 2 {
+		t.Errorf("Expected 2 messages in bin 0, got %d", len(messages))
+	}
+}
+
+func TestExpandMergesRestoredBit(t *testing.T) {
+	manager := NewBinManager(0xFFFFFFFFFFFFFFFF, time.Hour)
+	if err := manager.AddMessage(&Message{BinID: 1, MessageID: "msg", Ciphertext: []byte("data")}); err != nil {
+		t.Fatalf("AddMessage failed: %v", err)
+	}
+
+	// Expansion restores the bit the contraction dropped, so the legacy bin
+	// is a valid bin again and keeps its message
+	manager.ContractBins()
+	manager.ExpandBins()
+	if manager.GetCurrentMask() != 0xFFFFFFFFFFFFFFFF {
+		t.Fatalf("Unexpected mask %X", manager.GetCurrentMask())
+	}
+	if len(manager.GetRecentMessages(1)) != 1 || len(manager.GetRecentMessages(0)) != 0 {
+		t.Error("The message should be back in its own bin")
+	}
+}
+
+func TestExpandSplitsBins(t *testing.T) {
+	manager := NewBinManager(0xFFFFFFFFFFFFF000, time.Hour)
+	binID := uint64(0x1000)
+	if err := manager.AddMessage(&Message{BinID: binID, MessageID: "before", Ciphertext: []byte("data")}); err != nil {
+		t.Fatalf("AddMessage failed: %v", err)
+	}
+	client := NewMockClient()
+	manager.Subscribe(binID, "client", client)
+
+	manager.ExpandBins()
+	twinID := binID | 1
+	if manager.GetCurrentMask() != 0xFFFFFFFFFFFFF001 {
+		t.Fatalf("Unexpected mask %X", manager.GetCurrentMask())
+	}
+
+	// Nothing is copied; which half the stored message belongs to is
+	// unknown, so the new half reads it through the routing table
+	if manager.BinCount() != 1 || manager.Usage().Messages != 1 {
+		t.Errorf("Expansion should not copy bins, got %d bins and %+v", manager.BinCount(), manager.Usage())
+	}
+	for _, id := range []uint64{binID, twinID} {
+		messages := manager.GetRecentMessages(id)
+		if len(messages) != 1 || messages[0].MessageID != "before" {
+			t.Errorf("Bin %X should reach the message, got %v", id, messages)
+		}
+	}
+
+	// The subscriber keeps receiving channels that now map to either half,
+	// while subscriptions made after the expansion cover their half only
+	direct := NewMockClient()
+	manager.Subscribe(twinID, "direct", direct)
+	narrow := NewMockClient()
+	manager.Subscribe(binID, "narrow", narrow)
+	for i, id := range []uint64{binID, twinID} {
+		msg := &Message{BinID: id, MessageID: fmt.Sprintf("after%d", i), Ciphertext: []byte("data")}
+		if err := manager.AddMessage(msg); err != nil {
+			t.Fatalf("AddMessage failed: %v", err)
+		}
+	}
+	if received := client.GetMessages(); len(received) != 2 {
+		t.Errorf("Subscriber should receive messages for both halves, got %d", len(received))
+	}
+	if len(direct.GetMessages()) != 1 || len(narrow.GetMessages()) != 1 {
+		t.Errorf("New subscriptions should receive their half only, got %d and %d",
+			len(direct.GetMessages()), len(narrow.GetMessages()))
+	}
+
+	// Only the new half's own subscriber counts towards its load
+	bin, _ := manager.lookupBin(twinID)
+	if count := bin.clientCount(); count != 1 {
+		t.Errorf("The new half should count 1 subscriber, got %d", count)
+	}
+
+	// Unsubscribing from the original bin ends its coverage of the new
+	// half but leaves subscriptions made to the new half alone
+	manager.Unsubscribe(binID, "client")
+	if err := manager.AddMessage(&Message{BinID: twinID, MessageID: "late", Ciphertext: []byte("data")}); err != nil {
+		t.Fatalf("AddMessage failed: %v", err)
+	}
+	if received := client.GetMessages(); len(received) != 2 {
+		t.Errorf("Unsubscribed client should receive nothing more, got %d", len(received))
+	}
+	if received := direct.GetMessages(); len(received) != 2 {
+		t.Errorf("Direct subscriber should keep receiving the new half, got %d", len(received))
+	}
+
+	// Once the messages from before the split have expired the bit is
+	// settled and bins no longer reach their ancestors
+	manager.SetRetention(time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	manager.RunOnce()
+	if table := manager.routing.Load(); table.unsplit != 0 {
+		t.Errorf("Expected the expansion to be settled, got %+v", *table)
+	}
+}
+
+func TestRestoreBinsOutsideMask(t *testing.T) {
+	stores := make(map[uint64]BinStore)
+	factory := func(binID uint64) BinStore {
+		if _, exists := stores[binID]; !exists {
+			stores[binID] = NewBucketStore(DefaultBucketWidth)
+		}
+		return stores[binID]
+	}
+	msg := &Message{BinID: 0x1001, MessageID: "stored", Ciphertext: []byte("data"), Timestamp: time.Now()}
+	if err := factory(0x1001).AppendMessage(msg); err != nil {
+		t.Fatalf("AppendMessage failed: %v", err)
+	}
+
+	// A bin stored under a wider mask is merged into its bin under the
+	// current one rather than kept under an ID nothing routes to
+	manager := NewBinManagerWithStore(0xFFFFFFFFFFFFF000, time.Hour, factory)
+	manager.RestoreBins([]uint64{0x1001})
+	if messages := manager.GetRecentMessages(0x1000); len(messages) != 1 {
+		t.Fatalf("Restored message should be in bin 1000, got %v", messages)
+	}
+
+	// Expanding afterwards must not find the bin to be its own twin
+	done := make(chan struct{})
+	go func() {
+		manager.ExpandBins()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("ExpandBins deadlocked")
+	}
+	if messages := manager.GetRecentMessages(0x1001); len(messages) != 1 {
+		t.Errorf("Bin 1001 should reach the restored message, got %v", messages)
+	}
+}
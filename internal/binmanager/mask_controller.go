@@ -0,0 +1,298 @@
+package binmanager
+
+import (
+	"fmt"
+	"log"
+	"math/bits"
+	"sync"
+	"time"
+)
+
+// Mask scaling actions
+const (
+	MaskExpanded   = "expand"
+	MaskContracted = "contract"
+)
+
+// maxMaskEvents bounds the event history kept by a MaskController
+const maxMaskEvents = 100
+
+// contractHeadroom is the fraction of the load thresholds that the busiest
+// bin may reach after a contraction; the gap avoids flapping
+const contractHeadroom = 0.5
+
+// expandRelief is the fraction of the load that triggered an expansion
+// that the busiest bin must fall below before the mask expands again
+const expandRelief = 0.9
+
+// MaskLimits configures a MaskController. A zero threshold leaves its
+// measure out of the decision.
+type MaskLimits struct {
+	MaxSubscribers int     // Expand once a bin has more subscribers than this
+	MaxRate        float64 // Expand once a bin receives more messages per second
+	MinBits        int     // Contract no further than this many mask bits
+	MaxBits        int     // Expand no further than this many mask bits; 0 for 64
+}
+
+// MaskEvent records one change of the mask
+type MaskEvent struct {
+	Time         time.Time `json:"time"`
+	Action       string    `json:"action"`
+	PreviousMask string    `json:"previous_mask"`
+	Mask         string    `json:"mask"`
+	MaskEpoch    uint64    `json:"mask_epoch"`
+	Subscribers  int       `json:"subscribers"`         // Of the busiest bin
+	Rate         float64   `json:"messages_per_second"` // Of the busiest bin
+}
+
+// MaskStatus describes the controller state for the admin API
+type MaskStatus struct {
+	Mask           string      `json:"mask"`
+	MaskEpoch      uint64      `json:"mask_epoch"`
+	Bits           int         `json:"bits"`
+	MinBits        int         `json:"min_bits"`
+	MaxBits        int         `json:"max_bits"`
+	MaxSubscribers int         `json:"max_subscribers"`
+	MaxRate        float64     `json:"max_messages_per_second"`
+	Subscribers    int         `json:"busiest_subscribers"`
+	Rate           float64     `json:"busiest_messages_per_second"`
+	Events         []MaskEvent `json:"events"`
+}
+
+// binLoad is the load on one bin over an evaluation interval
+type binLoad struct {
+	subscribers int
+	rate        float64
+}
+
+// MaskController expands the mask of a BinManager when its busiest bin has
+// more subscribers or receives more messages than the limits allow, and
+// contracts it again once the bins a contraction would merge stay well
+// within them. It changes the mask by one bit per evaluation, and measures
+// a whole interval under a mask before acting on it, so the rates it sees
+// are never mixed from two masks. Subscriptions made before an expansion
+// stay with the bin they were made to until clients renew them, so after
+// an expansion the mask only expands again once the busiest load drops.
+type MaskController struct {
+	bm           *BinManager
+	limits       MaskLimits
+	onEvent      func(MaskEvent)
+	events       []MaskEvent
+	samples      map[*Bin]uint64 // Messages published to each bin at the last evaluation
+	sampledAt    time.Time
+	sampledEpoch uint64
+	busiest      binLoad
+	trigger      *binLoad // Busiest load at the last expansion, until relieved
+	ticker       *time.Ticker
+	done         chan struct{}
+	mu           sync.Mutex
+}
+
+// NewMaskController creates a controller for bm
+func NewMaskController(bm *BinManager, limits MaskLimits) *MaskController {
+	if limits.MaxBits <= 0 || limits.MaxBits > 64 {
+		limits.MaxBits = 64
+	}
+	if limits.MinBits < 1 {
+		limits.MinBits = 1
+	}
+	return &MaskController{
+		bm:     bm,
+		limits: limits,
+		events: make([]MaskEvent, 0),
+	}
+}
+
+// OnEvent registers a callback invoked for every mask change
+func (mc *MaskController) OnEvent(fn func(MaskEvent)) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	mc.onEvent = fn
+}
+
+// Evaluate measures bin load once and expands or contracts the mask if
+// needed. It returns the event for a change, or nil. The first evaluation,
+// and the first after any mask change, only takes a sample.
+func (mc *MaskController) Evaluate() *MaskEvent {
+	mc.mu.Lock()
+
+	now := time.Now()
+	state := mc.bm.MaskState()
+	bins := mc.bm.snapshotBins()
+	samples := make(map[*Bin]uint64, len(bins))
+	for _, bin := range bins {
+		samples[bin] = bin.received.Load()
+	}
+	previous, elapsed := mc.samples, now.Sub(mc.sampledAt).Seconds()
+	stable := previous != nil && state.Epoch == mc.sampledEpoch && elapsed > 0
+	mc.samples, mc.sampledAt, mc.sampledEpoch = samples, now, state.Epoch
+	if !stable {
+		mc.mu.Unlock()
+		return nil
+	}
+
+	// Load of the bins under the current mask and of those a contraction
+	// would leave. Legacy bins count towards the bin they merge into.
+	contracted := state.Mask &^ (state.Mask & -state.Mask)
+	current := make(map[uint64]binLoad, len(bins))
+	merged := make(map[uint64]binLoad, len(bins))
+	for _, bin := range bins {
+		load := binLoad{
+			subscribers: bin.clientCount(),
+			rate:        float64(samples[bin]-previous[bin]) / elapsed,
+		}
+		current[bin.ID&state.Mask] = current[bin.ID&state.Mask].add(load)
+		merged[bin.ID&contracted] = merged[bin.ID&contracted].add(load)
+	}
+	mc.busiest = busiestLoad(current)
+	if !mc.exceeds(mc.busiest, 1) {
+		mc.trigger = nil
+	}
+
+	var action string
+	maskBits := bits.OnesCount64(state.Mask)
+	switch {
+	case mc.exceeds(mc.busiest, 1) && maskBits < mc.limits.MaxBits && mc.relievedLocked():
+		action = MaskExpanded
+		mc.bm.ExpandBins()
+	case maskBits > mc.limits.MinBits && !mc.exceeds(busiestLoad(merged), contractHeadroom):
+		action = MaskContracted
+		mc.bm.ContractBins()
+	default:
+		mc.mu.Unlock()
+		return nil
+	}
+
+	next := mc.bm.MaskState()
+	if next.Epoch == state.Epoch {
+		mc.mu.Unlock()
+		return nil
+	}
+	mc.trigger = nil
+	if action == MaskExpanded {
+		trigger := mc.busiest
+		mc.trigger = &trigger
+	}
+	event := mc.recordLocked(action, state.Mask, next)
+	onEvent := mc.onEvent
+	mc.mu.Unlock()
+
+	log.Printf("Bin mask %s: %s -> %s (busiest bin: %d subscribers, %.1f messages/s)",
+		event.Action, event.PreviousMask, event.Mask, event.Subscribers, event.Rate)
+	if onEvent != nil {
+		onEvent(*event)
+	}
+	return event
+}
+
+// exceeds reports whether load is over a fraction of either threshold
+func (mc *MaskController) exceeds(load binLoad, fraction float64) bool {
+	if mc.limits.MaxSubscribers > 0 && float64(load.subscribers) > fraction*float64(mc.limits.MaxSubscribers) {
+		return true
+	}
+	return mc.limits.MaxRate > 0 && load.rate > fraction*mc.limits.MaxRate
+}
+
+// relievedLocked reports whether the busiest load has dropped since the last
+// expansion, in subscribers or in rate; an expansion that did not spread
+// the load is not repeated. Callers hold mc.mu.
+func (mc *MaskController) relievedLocked() bool {
+	if mc.trigger == nil {
+		return true
+	}
+	return float64(mc.busiest.subscribers) < expandRelief*float64(mc.trigger.subscribers) ||
+		mc.busiest.rate < expandRelief*mc.trigger.rate
+}
+
+// add returns the combined load of two bins
+func (l binLoad) add(other binLoad) binLoad {
+	return binLoad{subscribers: l.subscribers + other.subscribers, rate: l.rate + other.rate}
+}
+
+// busiestLoad returns the highest subscriber count and rate among loads,
+// which may belong to different bins
+func busiestLoad(loads map[uint64]binLoad) binLoad {
+	var busiest binLoad
+	for _, load := range loads {
+		busiest.subscribers = max(busiest.subscribers, load.subscribers)
+		busiest.rate = max(busiest.rate, load.rate)
+	}
+	return busiest
+}
+
+// Status returns the current state and recent events
+func (mc *MaskController) Status() MaskStatus {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	events := make([]MaskEvent, len(mc.events))
+	copy(events, mc.events)
+
+	state := mc.bm.MaskState()
+	return MaskStatus{
+		Mask:           fmt.Sprintf("0x%X", state.Mask),
+		MaskEpoch:      state.Epoch,
+		Bits:           bits.OnesCount64(state.Mask),
+		MinBits:        mc.limits.MinBits,
+		MaxBits:        mc.limits.MaxBits,
+		MaxSubscribers: mc.limits.MaxSubscribers,
+		MaxRate:        mc.limits.MaxRate,
+		Subscribers:    mc.busiest.subscribers,
+		Rate:           mc.busiest.rate,
+		Events:         events,
+	}
+}
+
+// Start evaluates bin load every interval until Stop is called
+func (mc *MaskController) Start(interval time.Duration) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	if mc.ticker != nil {
+		return
+	}
+	mc.ticker = time.NewTicker(interval)
+	mc.done = make(chan struct{})
+
+	go func(ticker *time.Ticker, done chan struct{}) {
+		for {
+			select {
+			case <-ticker.C:
+				mc.Evaluate()
+			case <-done:
+				return
+			}
+		}
+	}(mc.ticker, mc.done)
+}
+
+// Stop stops periodic evaluation
+func (mc *MaskController) Stop() {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	if mc.ticker != nil {
+		mc.ticker.Stop()
+		close(mc.done)
+		mc.ticker = nil
+	}
+}
+
+// recordLocked appends an event to the bounded history; callers hold mc.mu
+func (mc *MaskController) recordLocked(action string, from uint64, to MaskState) *MaskEvent {
+	event := MaskEvent{
+		Time:         time.Now(),
+		Action:       action,
+		PreviousMask: fmt.Sprintf("0x%X", from),
+		Mask:         fmt.Sprintf("0x%X", to.Mask),
+		MaskEpoch:    to.Epoch,
+		Subscribers:  mc.busiest.subscribers,
+		Rate:         mc.busiest.rate,
+	}
+
+	mc.events = append(mc.events, event)
+	if len(mc.events) > maxMaskEvents {
+		mc.events = mc.events[len(mc.events)-maxMaskEvents:]
+	}
+	return &event
+}
@@ -0,0 +1,121 @@
+package binmanager
+
+import (
+	"fmt"
+	"math/bits"
+	"testing"
+	"time"
+)
+
+func TestMaskControllerExpandsAndContracts(t *testing.T) {
+	mask := uint64(0xFFFFFFFFFFFFF000)
+	bm := NewBinManager(mask, time.Hour)
+	minBits := bits.OnesCount64(mask)
+
+	var observed []MaskEvent
+	mc := NewMaskController(bm, MaskLimits{MaxSubscribers: 4, MinBits: minBits, MaxBits: minBits + 1})
+	mc.OnEvent(func(e MaskEvent) { observed = append(observed, e) })
+
+	for i := 0; i < 5; i++ {
+		bm.Subscribe(0x1000, fmt.Sprintf("client%d", i), NewMockClient())
+	}
+
+	// The first evaluation only samples
+	if event := mc.Evaluate(); event != nil {
+		t.Fatalf("First evaluation should not act, got %+v", event)
+	}
+	event := mc.Evaluate()
+	if event == nil || event.Action != MaskExpanded || event.Subscribers != 5 {
+		t.Fatalf("Expected an expand event for 5 subscribers, got %+v", event)
+	}
+	if got := bits.OnesCount64(bm.GetCurrentMask()); got != minBits+1 {
+		t.Fatalf("Mask should have %d bits, got %d", minBits+1, got)
+	}
+
+	// At the bit limit the mask stays, even under load
+	mc.Evaluate()
+	if event := mc.Evaluate(); event != nil {
+		t.Errorf("Mask should not expand past MaxBits, got %+v", event)
+	}
+
+	// Contracting would merge the remaining subscribers into a bin over
+	// half the limit
+	bm.Unsubscribe(0x1000, "client0")
+	bm.Unsubscribe(0x1000, "client1")
+	if event := mc.Evaluate(); event != nil {
+		t.Errorf("Mask should not contract with 3 subscribers, got %+v", event)
+	}
+	bm.Unsubscribe(0x1000, "client2")
+	event = mc.Evaluate()
+	if event == nil || event.Action != MaskContracted || bm.GetCurrentMask() != mask {
+		t.Fatalf("Expected a contraction back to %X, got %+v and %X", mask, event, bm.GetCurrentMask())
+	}
+
+	// Not below MinBits
+	mc.Evaluate()
+	if event := mc.Evaluate(); event != nil {
+		t.Errorf("Mask should not contract past MinBits, got %+v", event)
+	}
+
+	if len(observed) != 2 || len(mc.Status().Events) != 2 {
+		t.Errorf("Expected 2 events, observed %d", len(observed))
+	}
+}
+
+func TestMaskControllerSettlesUnderConstantLoad(t *testing.T) {
+	mask := uint64(0xFFFFFFFFFFFFF000)
+	bm := NewBinManager(mask, time.Hour)
+	minBits := bits.OnesCount64(mask)
+	mc := NewMaskController(bm, MaskLimits{MaxSubscribers: 4, MinBits: minBits, MaxBits: minBits + 8})
+
+	for i := 0; i < 10; i++ {
+		bm.Subscribe(0x1000, fmt.Sprintf("client%d", i), NewMockClient())
+	}
+
+	// The subscriptions stay with their bin after the expansion, so the
+	// load does not drop and the mask settles after one step
+	expansions := 0
+	for i := 0; i < 20; i++ {
+		if event := mc.Evaluate(); event != nil && event.Action == MaskExpanded {
+			expansions++
+		}
+	}
+	if expansions != 1 || bits.OnesCount64(bm.GetCurrentMask()) != minBits+1 {
+		t.Fatalf("Expected one expansion, got %d and mask %X", expansions, bm.GetCurrentMask())
+	}
+	if count := bm.BinCount(); count != 1 {
+		t.Errorf("Expansion should not create bins, got %d", count)
+	}
+
+	// Once clients renew their subscriptions across both halves the load
+	// drops, and the mask may expand again if it is still too high
+	for i := 0; i < 10; i++ {
+		clientID := fmt.Sprintf("client%d", i)
+		bm.Unsubscribe(0x1000, clientID)
+		bm.Subscribe(0x1000|uint64(i%2), clientID, NewMockClient())
+	}
+	event := mc.Evaluate()
+	if event == nil || event.Action != MaskExpanded || event.Subscribers != 5 {
+		t.Fatalf("Expected an expansion once the load dropped, got %+v", event)
+	}
+}
+
+func TestMaskControllerMessageRate(t *testing.T) {
+	mask := uint64(0xFFFFFFFFFFFFF000)
+	bm := NewBinManager(mask, time.Hour)
+	mc := NewMaskController(bm, MaskLimits{MaxRate: 1000, MinBits: bits.OnesCount64(mask)})
+
+	mc.Evaluate()
+	start := time.Now()
+	for i := 0; i < 50; i++ {
+		bm.AddMessage(&Message{BinID: 0x1000, MessageID: fmt.Sprintf("msg%d", i), Ciphertext: []byte("x")})
+	}
+	// The rate only exceeds the limit if the messages came fast enough
+	if time.Since(start) > 40*time.Millisecond {
+		t.Skip("Publishing too slow to measure the rate")
+	}
+	event := mc.Evaluate()
+	if event == nil || event.Action != MaskExpanded || event.Rate <= 1000 {
+		t.Fatalf("Expected an expand event over 1000 messages/s, got %+v", event)
+	}
+}
@@ -1,16 +1,58 @@
 package binmanager
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"time"
 )
 
+const (
+	// MaxReplyToIDLength bounds the opaque reply reference
+	MaxReplyToIDLength = 64
+
+	// MaxThreadTagLength bounds the opaque thread tag
+	MaxThreadTagLength = 32
+
+	// MaxSignatureLength bounds the ephemeral key signature and its key,
+	// sized for Ed25519
+	MaxSignatureLength = 64
+)
+
+// Acknowledgements a publisher may request in Message.Ack
+const (
+	AckAccepted = "accepted" // After the message is accepted in memory
+	AckDurable  = "durable"  // After a durable store has synced it, if one is configured
+)
+
+// messageOverhead is the in-memory size of a Message without its
+// variable-length contents: bin ID, timestamp and string/slice headers
+const messageOverhead = 112
+
+// ErrFieldTooLarge is returned when an opaque message field exceeds its bound
+var ErrFieldTooLarge = errors.New("message field exceeds maximum size")
+
 // Message represents a message in the system
 type Message struct {
-	BinID      uint64    `json:"bin_id"`
-	MessageID  string    `json:"message_id"`
-	Ciphertext []byte    `json:"ciphertext"`
-	Timestamp  time.Time `json:"timestamp,omitempty"` // Server-side only, not sent to clients
+	BinID          uint64    `json:"bin_id"`
+	MessageID      string    `json:"message_id"`
+	Ciphertext     []byte    `json:"ciphertext"`
+	ReplyToID      string    `json:"reply_to_id,omitempty"`     // Opaque, relayed verbatim
+	ThreadTag      []byte    `json:"thread_tag,omitempty"`      // Opaque, relayed verbatim
+	PoW            []byte    `json:"pow,omitempty"`             // Proof-of-work nonce, checked and stripped on publish
+	Ack            string    `json:"ack,omitempty"`             // Requested publish acknowledgement, stripped on publish
+	Retention      int64     `json:"retention,omitempty"`       // Requested bin retention in seconds, stripped on publish
+	RetentionProof []byte    `json:"retention_proof,omitempty"` // Channel-ownership secret for Retention, stripped on publish
+	Imported       bool      `json:"imported,omitempty"`        // Set by the server on messages imported from an archive
+	SessionID      string    `json:"session_id,omitempty"`      // Session of a multiplexed connection, stripped on publish
+	MaskEpoch      *uint64   `json:"mask_epoch,omitempty"`      // Epoch of the mask BinID was computed with, stripped on publish
+	Signature      []byte    `json:"signature,omitempty"`       // By the publishing session's ephemeral key, relayed verbatim
+	SignerKey      []byte    `json:"signer_key,omitempty"`      // Set by the server once Signature is verified
+	Timestamp      time.Time `json:"timestamp,omitempty"`       // Server-side only, not sent to clients
+
+	routed *MaskState // Mask the pipeline normalized BinID with
 }
 
 // NewMessage creates a new message
@@ -22,6 +64,94 @@ func NewMessage(binID uint64, messageID string, ciphertext []byte) *Message {
 	}
 }
 
+// Validate checks the bounded opaque fields of a client-supplied message
+func (m *Message) Validate() error {
+	if len(m.ReplyToID) > MaxReplyToIDLength {
+		return ErrFieldTooLarge
+	}
+	if len(m.ThreadTag) > MaxThreadTagLength {
+		return ErrFieldTooLarge
+	}
+	if len(m.Signature) > MaxSignatureLength || len(m.SignerKey) > MaxSignatureLength {
+		return ErrFieldTooLarge
+	}
+	return nil
+}
+
+// Size returns the number of bytes the message accounts for while retained:
+// the ciphertext plus its envelope
+func (m *Message) Size() int64 {
+	return int64(messageOverhead + len(m.MessageID) + len(m.Ciphertext) + len(m.ReplyToID) + len(m.ThreadTag) +
+		len(m.Signature) + len(m.SignerKey))
+}
+
+// compact copies byte slices whose backing arrays are much larger than their
+// contents, so retained messages do not pin oversized decode buffers
+func (m *Message) compact() {
+	m.Ciphertext = compactBytes(m.Ciphertext)
+	m.ThreadTag = compactBytes(m.ThreadTag)
+}
+
+// sanitize reduces m to the fields that are stored and relayed. Publish
+// parameters such as proof of work have been checked by then, and nothing
+// else a publisher or its connection supplied may reach storage.
+func (m *Message) sanitize() {
+	*m = Message{
+		BinID:      m.BinID,
+		MessageID:  m.MessageID,
+		Ciphertext: m.Ciphertext,
+		ReplyToID:  m.ReplyToID,
+		ThreadTag:  m.ThreadTag,
+		Signature:  m.Signature,
+		SignerKey:  m.SignerKey,
+	}
+}
+
+// Coarsened returns m for delivery with its timestamp truncated to a
+// multiple of granularity, so recipients cannot correlate it precisely with
+// network observations. The stored message keeps its exact time. Zero
+// granularity returns m itself.
+func (m *Message) Coarsened(granularity time.Duration) *Message {
+	if granularity <= 0 || m.Timestamp.IsZero() {
+		return m
+	}
+	coarse := *m
+	coarse.Timestamp = m.Timestamp.Truncate(granularity)
+	return &coarse
+}
+
+// Jittered returns m for delivery with its timestamp moved to a
+// pseudorandom point of its granularity window. The point is derived from
+// key, the bin and the window, so every message a bin received in one window
+// shows the same time, later windows show later times, and recipients learn
+// neither the send time nor where windows begin. Zero granularity returns m
+// itself.
+func (m *Message) Jittered(granularity time.Duration, key []byte) *Message {
+	if granularity <= 0 || m.Timestamp.IsZero() {
+		return m
+	}
+	window := m.Timestamp.Truncate(granularity)
+	
+	var input [16]byte
+	binary.BigEndian.PutUint64(input[:8], m.BinID)
+	binary.BigEndian.PutUint64(input[8:], uint64(window.UnixNano()))
+	mac := hmac.New(sha256.New, key)
+	mac.Write(input[:])
+	offset := binary.BigEndian.Uint64(mac.Sum(nil)) % uint64(granularity)
+	
+	jittered := *m
+	jittered.Timestamp = window.Add(time.Duration(offset))
+	return &jittered
+}
+
+// compactBytes returns b, or a right-sized copy if b wastes over 1/8 of its capacity
+func compactBytes(b []byte) []byte {
+	if cap(b)-len(b) <= len(b)/8 {
+		return b
+	}
+	return append(make([]byte, 0, len(b)), b...)
+}
+
 // MarshalJSON implements json.Marshaler interface
 // Ensures we don't expose the Timestamp field to clients
 func (m *Message) MarshalJSON() ([]byte, error) {
@@ -156,4 +156,115 @@ func TestMessageJSONUnmarshalingErrors(t *testing.T) {
 	if err == nil {
 		t.Error("Expected error when unmarshaling invalid JSON, got nil")
 	}
-}
\ No newline at end of file
+}
+
+func TestMessageThreadingFields(t *testing.T) {
+	msg := &Message{
+		BinID:      0x1000,
+		MessageID:  "test-msg-id",
+		Ciphertext: []byte("test-data"),
+		ReplyToID:  "parent-msg-id",
+		ThreadTag:  []byte{0x01, 0x02, 0x03},
+	}
+	
+	data, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatalf("Failed to marshal message: %v", err)
+	}
+	
+	expected := `{"bin_id":4096,"message_id":"test-msg-id","ciphertext":"dGVzdC1kYXRh","reply_to_id":"parent-msg-id","thread_tag":"AQID"}`
+	if string(data) != expected {
+		t.Errorf("JSON with threading fields is incorrect: %s", string(data))
+	}
+	
+	var decodedMsg Message
+	if err := json.Unmarshal(data, &decodedMsg); err != nil {
+		t.Fatalf("Failed to unmarshal message: %v", err)
+	}
+	
+	if decodedMsg.ReplyToID != msg.ReplyToID {
+		t.Errorf("ReplyToID doesn't match after JSON roundtrip: got %s, want %s", decodedMsg.ReplyToID, msg.ReplyToID)
+	}
+	
+	if string(decodedMsg.ThreadTag) != string(msg.ThreadTag) {
+		t.Errorf("ThreadTag doesn't match after JSON roundtrip: got %x, want %x", decodedMsg.ThreadTag, msg.ThreadTag)
+	}
+}
+
+func TestMessageValidate(t *testing.T) {
+	msg := &Message{
+		BinID:      0x1000,
+		MessageID:  "test-msg-id",
+		Ciphertext: []byte("test-data"),
+		ReplyToID:  string(make([]byte, MaxReplyToIDLength)),
+		ThreadTag:  make([]byte, MaxThreadTagLength),
+	}
+	
+	if err := msg.Validate(); err != nil {
+		t.Errorf("Fields at maximum size should be valid, got %v", err)
+	}
+	
+	msg.ReplyToID += "x"
+	if err := msg.Validate(); err != ErrFieldTooLarge {
+		t.Errorf("Oversized reply_to_id should be rejected, got %v", err)
+	}
+	
+	msg.ReplyToID = ""
+	msg.ThreadTag = append(msg.ThreadTag, 0x00)
+	if err := msg.Validate(); err != ErrFieldTooLarge {
+		t.Errorf("Oversized thread_tag should be rejected, got %v", err)
+	}
+	
+	msg.ThreadTag = nil
+	msg.Signature = make([]byte, MaxSignatureLength+1)
+	if err := msg.Validate(); err != ErrFieldTooLarge {
+		t.Errorf("Oversized signature should be rejected, got %v", err)
+	}
+}
+
+func TestMessageCoarsened(t *testing.T) {
+	exact := time.Date(2024, 5, 1, 12, 0, 17, 123456789, time.UTC)
+	msg := &Message{BinID: 0x1000, MessageID: "m", Timestamp: exact}
+	
+	coarse := msg.Coarsened(10 * time.Second)
+	if !coarse.Timestamp.Equal(time.Date(2024, 5, 1, 12, 0, 10, 0, time.UTC)) {
+		t.Errorf("Expected the timestamp truncated to 10s, got %v", coarse.Timestamp)
+	}
+	if !msg.Timestamp.Equal(exact) {
+		t.Error("The stored message must keep its exact timestamp")
+	}
+	if msg.Coarsened(0) != msg {
+		t.Error("Zero granularity should return the message itself")
+	}
+}
+
+func TestMessageJittered(t *testing.T) {
+	key := []byte("jitter key")
+	granularity := 10 * time.Second
+	window := time.Date(2024, 5, 1, 12, 0, 10, 0, time.UTC)
+	msg := &Message{BinID: 0x1000, MessageID: "m", Timestamp: window.Add(7 * time.Second)}
+	
+	first := msg.Jittered(granularity, key)
+	if first.Timestamp.Before(window) || !first.Timestamp.Before(window.Add(granularity)) {
+		t.Errorf("Jittered timestamp %v left its window", first.Timestamp)
+	}
+	if !msg.Timestamp.Equal(window.Add(7 * time.Second)) {
+		t.Error("The stored message must keep its exact timestamp")
+	}
+	
+	// Messages of a bin in the same window share a timestamp
+	same := &Message{BinID: 0x1000, MessageID: "n", Timestamp: window.Add(2 * time.Second)}
+	if !same.Jittered(granularity, key).Timestamp.Equal(first.Timestamp) {
+		t.Error("Messages in the same window should get the same timestamp")
+	}
+	
+	// Later windows stay later
+	later := &Message{BinID: 0x1000, MessageID: "o", Timestamp: window.Add(granularity)}
+	if !later.Jittered(granularity, key).Timestamp.After(first.Timestamp) {
+		t.Error("Jitter must preserve order across windows")
+	}
+	
+	if msg.Jittered(0, key) != msg {
+		t.Error("Zero granularity should return the message itself")
+	}
+}
@@ -0,0 +1,95 @@
+package binmanager
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ErrInvalidSnapshot is returned for a snapshot line that is not a message
+var ErrInvalidSnapshot = errors.New("invalid snapshot")
+
+// WriteSnapshot writes every retained message as one JSON object per line.
+// A snapshot taken from an in-memory instance can be imported into a disk
+// store by the next instance during a rolling upgrade.
+func (bm *BinManager) WriteSnapshot(w io.Writer) (int, error) {
+	bm.mutex.RLock()
+	bins := make([]*Bin, 0, len(bm.bins))
+	for _, bin := range bm.bins {
+		bins = append(bins, bin)
+	}
+	bm.mutex.RUnlock()
+
+	buffered := bufio.NewWriter(w)
+	encoder := json.NewEncoder(buffered)
+
+	count := 0
+	for _, bin := range bins {
+		bin.msgMutex.RLock()
+		messages, err := bin.store.RangeByTime(time.Time{}, farFuture)
+		bin.msgMutex.RUnlock()
+		if err != nil {
+			return count, fmt.Errorf("bin %X: %w", bin.ID, err)
+		}
+
+		for _, msg := range messages {
+			if err := encoder.Encode(msg); err != nil {
+				return count, err
+			}
+			count++
+		}
+	}
+
+	return count, buffered.Flush()
+}
+
+// ImportSnapshot reads a snapshot written by WriteSnapshot into stores created
+// by newStore and returns the IDs of the bins it populated
+func ImportSnapshot(r io.Reader, newStore StoreFactory) ([]uint64, int, error) {
+	stores := make(map[uint64]BinStore)
+	binIDs := make([]uint64, 0)
+
+	decoder := json.NewDecoder(bufio.NewReader(r))
+	count := 0
+	for {
+		var msg Message
+		if err := decoder.Decode(&msg); err == io.EOF {
+			break
+		} else if err != nil {
+			return binIDs, count, fmt.Errorf("%w: message %d: %v", ErrInvalidSnapshot, count+1, err)
+		}
+
+		store, exists := stores[msg.BinID]
+		if !exists {
+			store = newStore(msg.BinID)
+			stores[msg.BinID] = store
+			binIDs = append(binIDs, msg.BinID)
+		}
+
+		if err := store.AppendMessage(&msg); err != nil {
+			return binIDs, count, fmt.Errorf("bin %X: %w", msg.BinID, err)
+		}
+		count++
+	}
+
+	return binIDs, count, nil
+}
+
+// MigrateStore copies every message from src into dst
+func MigrateStore(src, dst BinStore) (int, error) {
+	messages, err := src.RangeByTime(time.Time{}, farFuture)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, msg := range messages {
+		if err := dst.AppendMessage(msg); err != nil {
+			return i, err
+		}
+	}
+
+	return len(messages), nil
+}
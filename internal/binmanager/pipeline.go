@@ -0,0 +1,406 @@
+package binmanager
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Ingestion stages, in the order a message passes through them
+const (
+	StageDecode    = "decode"
+	StageValidate  = "validate"
+	StageAuthorize = "authorize"
+	StageDedup     = "dedup"
+	StagePersist   = "persist"
+)
+
+// DefaultDedupCapacity is the number of recent message IDs the default
+// deduplicator remembers
+const DefaultDedupCapacity = 16384
+
+var (
+	// ErrMessageTooLarge is returned when a ciphertext exceeds the limit
+	ErrMessageTooLarge = errors.New("message exceeds maximum size")
+	// ErrDuplicateMessage is returned for a message ID already seen in its bin
+	ErrDuplicateMessage = errors.New("duplicate message")
+)
+
+// StageError reports the stage at which a message was rejected
+type StageError struct {
+	Stage string
+	Err   error
+}
+
+func (e *StageError) Error() string {
+	return e.Stage + ": " + e.Err.Error()
+}
+
+func (e *StageError) Unwrap() error {
+	return e.Err
+}
+
+// Decoder turns a raw frame into a message
+type Decoder interface {
+	Decode(data []byte) (*Message, error)
+}
+
+// DecoderFunc adapts a function to the Decoder interface
+type DecoderFunc func(data []byte) (*Message, error)
+
+// Decode calls f
+func (f DecoderFunc) Decode(data []byte) (*Message, error) {
+	return f(data)
+}
+
+// JSONDecoder decodes the JSON frames of the WebSocket protocol
+var JSONDecoder = DecoderFunc(func(data []byte) (*Message, error) {
+	var msg Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+})
+
+// Validator checks a message on its own, without regard to its sender
+type Validator interface {
+	ValidateMessage(msg *Message) error
+}
+
+// ValidatorFunc adapts a function to the Validator interface
+type ValidatorFunc func(msg *Message) error
+
+// ValidateMessage calls f
+func (f ValidatorFunc) ValidateMessage(msg *Message) error {
+	return f(msg)
+}
+
+// FieldBounds rejects messages whose opaque fields exceed their bounds
+var FieldBounds = ValidatorFunc(func(msg *Message) error {
+	return msg.Validate()
+})
+
+// MaxCiphertext rejects ciphertexts longer than maxSize; 0 allows any size
+func MaxCiphertext(maxSize int) Validator {
+	return ValidatorFunc(func(msg *Message) error {
+		if maxSize > 0 && len(msg.Ciphertext) > maxSize {
+			return ErrMessageTooLarge
+		}
+		return nil
+	})
+}
+
+// Authorizer decides whether the sender may publish a message. The sender's
+// identity travels in ctx, in whatever form the caller chose.
+type Authorizer interface {
+	AuthorizeMessage(ctx context.Context, msg *Message) error
+}
+
+// AuthorizerFunc adapts a function to the Authorizer interface
+type AuthorizerFunc func(ctx context.Context, msg *Message) error
+
+// AuthorizeMessage calls f
+func (f AuthorizerFunc) AuthorizeMessage(ctx context.Context, msg *Message) error {
+	return f(ctx, msg)
+}
+
+// Deduplicator drops messages that were already accepted. Seen records the
+// message and reports whether it was known; Forget undoes that when the
+// message is not stored after all, so a retry is accepted.
+type Deduplicator interface {
+	Seen(msg *Message) bool
+	Forget(msg *Message)
+}
+
+// Persister stores an accepted message
+type Persister interface {
+	PersistMessage(msg *Message) error
+}
+
+// DurablePersister is implemented by persisters that can wait until a
+// message is on stable storage, reporting whether it is
+type DurablePersister interface {
+	PersistMessageDurable(msg *Message) (bool, error)
+}
+
+// Fanout delivers a stored message
+type Fanout interface {
+	FanoutMessage(msg *Message)
+}
+
+// CountingFanout is implemented by fanouts that report how many live
+// subscribers they handed a message to
+type CountingFanout interface {
+	FanoutMessageCount(msg *Message) int
+}
+
+// Receipt is the outcome of an accepted message
+type Receipt struct {
+	Durable   bool // Synced by a durable persister
+	Delivered int  // Subscribers the counting fanouts handed the message to
+}
+
+// FanoutFunc adapts a function to the Fanout interface
+type FanoutFunc func(msg *Message)
+
+// FanoutMessage calls f
+func (f FanoutFunc) FanoutMessage(msg *Message) {
+	f(msg)
+}
+
+// trustedTimestampKey marks a context whose submissions may keep their
+// own timestamps
+type trustedTimestampKey struct{}
+
+// TrustTimestamps marks submissions made with the returned context as
+// coming from a trusted internal caller, such as federation or an import,
+// whose message timestamps are kept within the pipeline's clock-skew bound.
+// Publishes from clients must never carry it.
+func TrustTimestamps(ctx context.Context) context.Context {
+	return context.WithValue(ctx, trustedTimestampKey{}, true)
+}
+
+// PipelineOption configures a Pipeline
+type PipelineOption func(*Pipeline)
+
+// WithDecoder replaces the JSON decoder
+func WithDecoder(decoder Decoder) PipelineOption {
+	return func(p *Pipeline) {
+		p.decoder = decoder
+	}
+}
+
+// WithValidators adds validators after the existing ones
+func WithValidators(validators ...Validator) PipelineOption {
+	return func(p *Pipeline) {
+		p.validators = append(p.validators, validators...)
+	}
+}
+
+// WithAuthorizers adds authorizers after the existing ones
+func WithAuthorizers(authorizers ...Authorizer) PipelineOption {
+	return func(p *Pipeline) {
+		p.authorizers = append(p.authorizers, authorizers...)
+	}
+}
+
+// WithDeduplicator replaces the deduplicator; nil accepts duplicates
+func WithDeduplicator(dedup Deduplicator) PipelineOption {
+	return func(p *Pipeline) {
+		p.dedup = dedup
+	}
+}
+
+// WithPersister replaces the bin manager as the store of messages
+func WithPersister(persister Persister) PipelineOption {
+	return func(p *Pipeline) {
+		p.persister = persister
+	}
+}
+
+// WithTrustedTimestamps keeps the timestamps of messages submitted with a
+// TrustTimestamps context if they are at most skew in the past. Older or
+// missing timestamps get the server time and future ones are capped at it.
+// Zero, the default, stamps every message with the server time.
+func WithTrustedTimestamps(skew time.Duration) PipelineOption {
+	return func(p *Pipeline) {
+		p.timestampSkew = skew
+	}
+}
+
+// WithFanouts adds deliveries after the bin manager's own
+func WithFanouts(fanouts ...Fanout) PipelineOption {
+	return func(p *Pipeline) {
+		p.fanouts = append(p.fanouts, fanouts...)
+	}
+}
+
+// Pipeline carries inbound messages through decode, validate, authorize,
+// sanitize, dedup, persist and fan-out. Every stage but sanitizing is
+// replaceable or extendable, so checks such as padding or proof of work plug
+// in without touching the bin manager. Sanitizing always runs after the
+// authorizers and leaves only the fields that are stored and relayed.
+type Pipeline struct {
+	bins        *BinManager
+	decoder     Decoder
+	validators  []Validator
+	authorizers []Authorizer
+	dedup       Deduplicator
+	persister   Persister
+	fanouts     []Fanout
+
+	timestampSkew time.Duration // How far back trusted timestamps are kept
+}
+
+// NewPipeline creates a pipeline that stores into and broadcasts from bm.
+// By default it decodes JSON, checks field bounds, authorizes everything and
+// drops message IDs seen among the last DefaultDedupCapacity messages.
+func NewPipeline(bm *BinManager, opts ...PipelineOption) *Pipeline {
+	p := &Pipeline{
+		bins:       bm,
+		decoder:    JSONDecoder,
+		validators: []Validator{FieldBounds},
+		dedup:      NewRecentIDs(DefaultDedupCapacity),
+		persister:  bm,
+		fanouts:    []Fanout{bm},
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// Ingest decodes a raw frame and submits the message
+func (p *Pipeline) Ingest(ctx context.Context, data []byte) (*Message, error) {
+	msg, err := p.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	return msg, p.Submit(ctx, msg)
+}
+
+// Decode runs only the decode stage, for callers that look at the message
+// before submitting it
+func (p *Pipeline) Decode(data []byte) (*Message, error) {
+	msg, err := p.decoder.Decode(data)
+	if err != nil {
+		return nil, &StageError{Stage: StageDecode, Err: err}
+	}
+	return msg, nil
+}
+
+// Submit runs a decoded message through the remaining stages. Errors are
+// *StageError values wrapping the stage's own error.
+func (p *Pipeline) Submit(ctx context.Context, msg *Message) error {
+	_, err := p.SubmitReceipt(ctx, msg, false)
+	return err
+}
+
+// SubmitDurable is Submit, but persists durably if the persister supports
+// it and reports whether the message is durable before fanning it out
+func (p *Pipeline) SubmitDurable(ctx context.Context, msg *Message) (bool, error) {
+	receipt, err := p.SubmitReceipt(ctx, msg, true)
+	return receipt.Durable, err
+}
+
+// SubmitReceipt runs the stages after decode, persisting durably if
+// durable is set and the persister supports it. The bin ID is normalized to
+// the current mask first, so every stage sees the bin the message is
+// stored in; a message tagged with the mask epoch its bin ID was computed
+// with is re-binned from that mask, or refused at the validate stage with
+// ErrStaleMask if its bin under the current mask cannot be known.
+func (p *Pipeline) SubmitReceipt(ctx context.Context, msg *Message, durable bool) (Receipt, error) {
+	route, err := p.bins.routeMessage(msg)
+	if err != nil {
+		return Receipt{}, &StageError{Stage: StageValidate, Err: err}
+	}
+	for _, validator := range p.validators {
+		if err := validator.ValidateMessage(msg); err != nil {
+			return Receipt{}, &StageError{Stage: StageValidate, Err: err}
+		}
+	}
+	for _, authorizer := range p.authorizers {
+		if err := authorizer.AuthorizeMessage(ctx, msg); err != nil {
+			return Receipt{}, &StageError{Stage: StageAuthorize, Err: err}
+		}
+	}
+	stamp := msg.Timestamp
+	msg.sanitize()
+	msg.Timestamp = p.timestamp(ctx, stamp)
+	msg.routed = &route
+	if p.dedup != nil && p.dedup.Seen(msg) {
+		return Receipt{}, &StageError{Stage: StageDedup, Err: ErrDuplicateMessage}
+	}
+
+	var receipt Receipt
+	if dp, ok := p.persister.(DurablePersister); ok && durable {
+		receipt.Durable, err = dp.PersistMessageDurable(msg)
+	} else {
+		err = p.persister.PersistMessage(msg)
+	}
+	if err != nil {
+		if p.dedup != nil {
+			p.dedup.Forget(msg)
+		}
+		return Receipt{}, &StageError{Stage: StagePersist, Err: err}
+	}
+
+	for _, fanout := range p.fanouts {
+		if counting, ok := fanout.(CountingFanout); ok {
+			receipt.Delivered += counting.FanoutMessageCount(msg)
+		} else {
+			fanout.FanoutMessage(msg)
+		}
+	}
+	return receipt, nil
+}
+
+// dedupKey identifies a message within its bin
+type dedupKey struct {
+	binID     uint64
+	messageID string
+}
+
+// RecentIDs remembers the IDs of the most recent messages across all bins.
+// Messages without an ID are never duplicates.
+type RecentIDs struct {
+	seen map[dedupKey]struct{}
+	ring []dedupKey
+	next int
+	mu   sync.Mutex
+}
+
+// NewRecentIDs creates a deduplicator remembering capacity message IDs
+func NewRecentIDs(capacity int) *RecentIDs {
+	return &RecentIDs{
+		seen: make(map[dedupKey]struct{}, capacity),
+		ring: make([]dedupKey, 0, capacity),
+	}
+}
+
+// Seen records the message and reports whether its ID was already known
+func (r *RecentIDs) Seen(msg *Message) bool {
+	if msg.MessageID == "" || cap(r.ring) == 0 {
+		return false
+	}
+	key := dedupKey{binID: msg.BinID, messageID: msg.MessageID}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.seen[key]; exists {
+		return true
+	}
+	if len(r.ring) < cap(r.ring) {
+		r.ring = append(r.ring, key)
+	} else {
+		delete(r.seen, r.ring[r.next])
+		r.ring[r.next] = key
+		r.next = (r.next + 1) % len(r.ring)
+	}
+	r.seen[key] = struct{}{}
+	return false
+}
+
+// Forget removes the message's ID; its ring slot is reused in turn
+func (r *RecentIDs) Forget(msg *Message) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.seen, dedupKey{binID: msg.BinID, messageID: msg.MessageID})
+}
+
+// timestamp returns the time a message is stored under: the server time,
+// or the submitted one for trusted callers within the clock-skew bound
+func (p *Pipeline) timestamp(ctx context.Context, submitted time.Time) time.Time {
+	now := time.Now()
+	trusted, _ := ctx.Value(trustedTimestampKey{}).(bool)
+	if !trusted || p.timestampSkew <= 0 || submitted.IsZero() || submitted.Before(now.Add(-p.timestampSkew)) {
+		return now
+	}
+	if submitted.After(now) {
+		return now
+	}
+	return submitted
+}
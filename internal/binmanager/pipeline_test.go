@@ -0,0 +1,293 @@
+package binmanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestPipelineStages(t *testing.T) {
+	bm := NewBinManager(0xFFFFFFFFFFFFF000, time.Hour)
+	client := NewMockClient()
+	bm.Subscribe(0x1000, "client", client)
+
+	errDenied := errors.New("denied")
+	var delivered []string
+	p := NewPipeline(bm,
+		WithValidators(MaxCiphertext(8)),
+		WithAuthorizers(AuthorizerFunc(func(ctx context.Context, msg *Message) error {
+			if msg.MessageID == "blocked" {
+				return errDenied
+			}
+			return nil
+		})),
+		WithFanouts(FanoutFunc(func(msg *Message) {
+			delivered = append(delivered, msg.MessageID)
+		})),
+	)
+
+	msg, err := p.Ingest(context.Background(), []byte(`{"bin_id":4096,"message_id":"m1","ciphertext":"AQID"}`))
+	if err != nil {
+		t.Fatalf("Ingest failed: %v", err)
+	}
+	if msg.Timestamp.IsZero() || len(bm.GetRecentMessages(0x1000)) != 1 {
+		t.Errorf("Message was not stored")
+	}
+	if len(client.GetMessages()) != 1 || len(delivered) != 1 {
+		t.Errorf("Message was not fanned out to both deliveries")
+	}
+
+	cases := []struct {
+		name  string
+		frame string
+		stage string
+		err   error
+	}{
+		{"decode", `{"bin_id":`, StageDecode, nil},
+		{"size", `{"bin_id":4096,"message_id":"m2","ciphertext":"AAAAAAAAAAAAAA=="}`, StageValidate, ErrMessageTooLarge},
+		{"fields", `{"bin_id":4096,"message_id":"m3","thread_tag":"` + "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA" + `"}`, StageValidate, ErrFieldTooLarge},
+		{"authorize", `{"bin_id":4096,"message_id":"blocked"}`, StageAuthorize, errDenied},
+		{"dedup", `{"bin_id":4096,"message_id":"m1","ciphertext":"AQID"}`, StageDedup, ErrDuplicateMessage},
+	}
+	for _, tc := range cases {
+		_, err := p.Ingest(context.Background(), []byte(tc.frame))
+		var stageErr *StageError
+		if !errors.As(err, &stageErr) || stageErr.Stage != tc.stage {
+			t.Errorf("%s: expected a %s stage error, got %v", tc.name, tc.stage, err)
+			continue
+		}
+		if tc.err != nil && !errors.Is(err, tc.err) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.err, err)
+		}
+	}
+	if len(bm.GetRecentMessages(0x1000)) != 1 || len(delivered) != 1 {
+		t.Errorf("Rejected messages reached storage or delivery")
+	}
+}
+
+func TestPipelineForgetsFailedPersist(t *testing.T) {
+	errStore := errors.New("disk full")
+	fail := true
+	bm := NewBinManager(0xFFFFFFFFFFFFF000, time.Hour)
+	p := NewPipeline(bm, WithPersister(persisterFunc(func(msg *Message) error {
+		if fail {
+			return errStore
+		}
+		return bm.PersistMessage(msg)
+	})))
+
+	msg := NewMessage(0x1000, "m1", []byte{1})
+	if err := p.Submit(context.Background(), msg); !errors.Is(err, errStore) {
+		t.Fatalf("Expected the store error, got %v", err)
+	}
+
+	// The retry is not a duplicate
+	fail = false
+	if err := p.Submit(context.Background(), NewMessage(0x1000, "m1", []byte{1})); err != nil {
+		t.Errorf("Retry after a failed persist was rejected: %v", err)
+	}
+}
+
+func TestRecentIDsEviction(t *testing.T) {
+	r := NewRecentIDs(2)
+	for _, id := range []string{"a", "b", "c"} {
+		if r.Seen(NewMessage(1, id, nil)) {
+			t.Fatalf("%s reported as seen", id)
+		}
+	}
+	if r.Seen(NewMessage(1, "a", nil)) {
+		t.Errorf("Oldest ID should have been evicted")
+	}
+	if !r.Seen(NewMessage(1, "c", nil)) {
+		t.Errorf("Recent ID should be remembered")
+	}
+	if r.Seen(NewMessage(2, "c", nil)) {
+		t.Errorf("IDs are per bin")
+	}
+	if r.Seen(NewMessage(1, "", nil)) || r.Seen(NewMessage(1, "", nil)) {
+		t.Errorf("Messages without an ID are never duplicates")
+	}
+}
+
+func TestPipelineSubmitDurable(t *testing.T) {
+	stores := make(map[uint64]*durableStore)
+	bm := NewBinManagerWithStore(0xFFFFFFFFFFFFF000, time.Hour, func(binID uint64) BinStore {
+		store := &durableStore{MemoryStore: NewMemoryStore()}
+		stores[binID] = store
+		return store
+	})
+	p := NewPipeline(bm)
+
+	durable, err := p.SubmitDurable(context.Background(), NewMessage(0x1000, "m1", []byte{1}))
+	if err != nil || !durable {
+		t.Fatalf("Expected a durable write, got %v, %v", durable, err)
+	}
+	if err := p.Submit(context.Background(), NewMessage(0x1000, "m2", []byte{1})); err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+	if stores[0x1000].synced != 1 {
+		t.Errorf("Expected one synced append, got %d", stores[0x1000].synced)
+	}
+
+	// Memory stores accept the request but cannot make the message durable
+	memory := NewPipeline(NewBinManager(0xFFFFFFFFFFFFF000, time.Hour))
+	durable, err = memory.SubmitDurable(context.Background(), NewMessage(0x1000, "m1", []byte{1}))
+	if err != nil || durable {
+		t.Errorf("Expected an in-memory write, got %v, %v", durable, err)
+	}
+}
+
+func TestPipelineReceiptCountsDeliveries(t *testing.T) {
+	bm := NewBinManager(0xFFFFFFFFFFFFF000, time.Hour)
+	for i := 0; i < 3; i++ {
+		bm.Subscribe(0x1000, fmt.Sprintf("client%d", i), NewMockClient())
+	}
+	extra := 0
+	p := NewPipeline(bm, WithFanouts(FanoutFunc(func(*Message) { extra++ })))
+
+	receipt, err := p.SubmitReceipt(context.Background(), NewMessage(0x1234, "m1", []byte{1}), false)
+	if err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+	if receipt.Delivered != 3 || receipt.Durable || extra != 1 {
+		t.Errorf("Expected 3 deliveries counted and the other fanout run, got %+v and %d", receipt, extra)
+	}
+}
+
+// durableStore counts synced appends
+type durableStore struct {
+	*MemoryStore
+	synced int
+}
+
+func (s *durableStore) AppendMessageDurable(msg *Message) error {
+	s.synced++
+	return s.AppendMessage(msg)
+}
+
+// persisterFunc adapts a function to the Persister interface
+type persisterFunc func(msg *Message) error
+
+func (f persisterFunc) PersistMessage(msg *Message) error {
+	return f(msg)
+}
+
+func TestPipelineSanitizes(t *testing.T) {
+	bm := NewBinManager(0xFFFFFFFFFFFFF000, time.Hour)
+	var sawPoW bool
+	p := NewPipeline(bm, WithAuthorizers(AuthorizerFunc(func(ctx context.Context, msg *Message) error {
+		sawPoW = len(msg.PoW) > 0
+		return nil
+	})))
+
+	frame := `{"bin_id":4096,"message_id":"m1","ciphertext":"AQID","reply_to_id":"r","thread_tag":"dA==",` +
+		`"pow":"AAEC","ack":"accepted","retention":3600,"retention_proof":"c2VjcmV0",` +
+		`"timestamp":"2000-01-01T00:00:00Z"}`
+	if _, err := p.Ingest(context.Background(), []byte(frame)); err != nil {
+		t.Fatalf("Ingest failed: %v", err)
+	}
+	if !sawPoW {
+		t.Error("Authorizers should see the publish parameters")
+	}
+
+	stored := bm.GetRecentMessages(0x1000)
+	if len(stored) != 1 {
+		t.Fatalf("Expected one stored message, got %d", len(stored))
+	}
+	msg := stored[0]
+	if msg.PoW != nil || msg.Ack != "" || msg.Retention != 0 || msg.RetentionProof != nil {
+		t.Errorf("Publish parameters should not be stored: %+v", msg)
+	}
+	if msg.ReplyToID != "r" || string(msg.ThreadTag) != "t" || string(msg.Ciphertext) != "\x01\x02\x03" {
+		t.Errorf("Relayed fields should be kept: %+v", msg)
+	}
+	if msg.Timestamp.Year() == 2000 {
+		t.Error("A client-supplied timestamp should be replaced by the server's")
+	}
+}
+
+func TestPipelineTrustedTimestamps(t *testing.T) {
+	bm := NewBinManager(0xFFFFFFFFFFFFF000, time.Hour)
+	p := NewPipeline(bm, WithTrustedTimestamps(10*time.Minute))
+	trusted := TrustTimestamps(context.Background())
+	original := time.Now().Add(-5 * time.Minute)
+
+	submit := func(ctx context.Context, id string, stamp time.Time) time.Time {
+		msg := NewMessage(0x1000, id, []byte{1})
+		msg.Timestamp = stamp
+		if err := p.Submit(ctx, msg); err != nil {
+			t.Fatalf("Submit failed: %v", err)
+		}
+		return msg.Timestamp
+	}
+
+	if stamp := submit(trusted, "m1", original); !stamp.Equal(original) {
+		t.Errorf("A trusted timestamp within the bound should be kept, got %v", stamp)
+	}
+	if stamp := submit(context.Background(), "m2", original); stamp.Equal(original) {
+		t.Error("An untrusted timestamp should be replaced by the server's")
+	}
+	if stamp := submit(trusted, "m3", time.Now().Add(-time.Hour)); time.Since(stamp) > time.Minute {
+		t.Errorf("A trusted timestamp beyond the bound should be replaced, got %v", stamp)
+	}
+	if stamp := submit(trusted, "m4", time.Now().Add(time.Hour)); stamp.After(time.Now()) {
+		t.Errorf("A future timestamp should be capped at the server time, got %v", stamp)
+	}
+
+	// Without a bound trusted callers get the server time too
+	p = NewPipeline(bm)
+	if stamp := submit(trusted, "m5", original); stamp.Equal(original) {
+		t.Error("Timestamps should not be trusted unless the pipeline allows it")
+	}
+}
+
+func TestPipelineRebinsAcrossMaskChanges(t *testing.T) {
+	bm := NewBinManager(0xFFFFFFFFFFFFF000, time.Hour)
+	p := NewPipeline(bm)
+	publish := func(ctx context.Context, id string, binID, epoch uint64) (*Message, error) {
+		msg := NewMessage(binID, id, []byte{1})
+		msg.MaskEpoch = &epoch
+		return msg, p.Submit(ctx, msg)
+	}
+
+	if _, err := publish(context.Background(), "m1", 0x1000, 0); err != nil {
+		t.Fatalf("Publish with the current epoch failed: %v", err)
+	}
+
+	// The expanded mask needs a bit the old bin ID dropped
+	bm.ExpandBins()
+	if _, err := publish(context.Background(), "m2", 0x1000, 0); !errors.Is(err, ErrStaleMask) {
+		t.Errorf("Expected ErrStaleMask after expansion, got %v", err)
+	}
+	if _, err := publish(context.Background(), "m3", 0x1000, 7); !errors.Is(err, ErrStaleMask) {
+		t.Errorf("Expected ErrStaleMask for an unknown epoch, got %v", err)
+	}
+
+	// A contracted mask only drops bits, so older bin IDs map exactly
+	expanded := bm.MaskState()
+	bm.ContractBins()
+	msg, err := publish(context.Background(), "m4", 0x1000|(expanded.Mask&^0xFFFFFFFFFFFFF000), expanded.Epoch)
+	if err != nil || msg.BinID != 0x1000&bm.GetCurrentMask() {
+		t.Errorf("Expected the publish re-binned to the contracted mask, got bin %X: %v", msg.BinID, err)
+	}
+
+	// A mask change while the message is in the pipeline is caught when it
+	// is stored
+	racing := NewPipeline(bm, WithValidators(ValidatorFunc(func(*Message) error {
+		bm.ExpandBins()
+		return nil
+	})))
+	current := bm.MaskState()
+	msg = NewMessage(0x1000, "m5", []byte{1})
+	msg.MaskEpoch = &current.Epoch
+	err = racing.Submit(context.Background(), msg)
+	var stageErr *StageError
+	if !errors.As(err, &stageErr) || stageErr.Stage != StagePersist || !errors.Is(err, ErrStaleMask) {
+		t.Errorf("Expected ErrStaleMask at the persist stage, got %v", err)
+	}
+	if err := racing.Submit(context.Background(), msg); errors.Is(err, ErrDuplicateMessage) {
+		t.Error("A refused publish should be accepted when retried")
+	}
+}
@@ -0,0 +1,106 @@
+package binmanager
+
+import "sort"
+
+// PrefixSubscription matches every bin whose ID agrees with Prefix on the
+// bits set in Mask. A zero mask matches all bins.
+type PrefixSubscription struct {
+	Prefix uint64 `json:"prefix"`
+	Mask   uint64 `json:"mask"`
+}
+
+// Matches reports whether binID falls under the subscription
+func (p PrefixSubscription) Matches(binID uint64) bool {
+	return binID&p.Mask == p.Prefix&p.Mask
+}
+
+// prefixSubscriber is a client receiving messages for bin ranges
+type prefixSubscriber struct {
+	client   Client
+	prefixes []PrefixSubscription
+}
+
+// matches reports whether any of the subscriber's prefixes covers binID
+func (ps *prefixSubscriber) matches(binID uint64) bool {
+	for _, prefix := range ps.prefixes {
+		if prefix.Matches(binID) {
+			return true
+		}
+	}
+	return false
+}
+
+// SubscribePrefix delivers messages for every bin under the given prefixes to
+// client, including bins created later. It is meant for a few operator
+// services such as archival bridges; the client is kept in one list rather
+// than registered with each bin. Subscribing again replaces the prefixes.
+func (bm *BinManager) SubscribePrefix(clientID string, client Client, prefixes []PrefixSubscription) {
+	bm.prefixMu.Lock()
+	defer bm.prefixMu.Unlock()
+
+	bm.prefixSubs[clientID] = &prefixSubscriber{
+		client:   client,
+		prefixes: append([]PrefixSubscription(nil), prefixes...),
+	}
+}
+
+// UnsubscribePrefix removes a client's prefix subscriptions
+func (bm *BinManager) UnsubscribePrefix(clientID string) {
+	bm.prefixMu.Lock()
+	defer bm.prefixMu.Unlock()
+
+	delete(bm.prefixSubs, clientID)
+}
+
+// MatchingBins returns the existing bins under any of the prefixes, in order.
+// Legacy bins left by a contraction are listed as the bin they merge into.
+func (bm *BinManager) MatchingBins(prefixes []PrefixSubscription) []uint64 {
+	subscriber := &prefixSubscriber{prefixes: prefixes}
+
+	bm.mutex.RLock()
+	unmigrated := bm.routing.Load().unmigrated
+	matched := make(map[uint64]bool)
+	for binID := range bm.bins {
+		binID &^= unmigrated
+		if subscriber.matches(binID) {
+			matched[binID] = true
+		}
+	}
+	bm.mutex.RUnlock()
+
+	binIDs := make([]uint64, 0, len(matched))
+	for binID := range matched {
+		binIDs = append(binIDs, binID)
+	}
+
+	sort.Slice(binIDs, func(i, j int) bool { return binIDs[i] < binIDs[j] })
+	return binIDs
+}
+
+// broadcastPrefix delivers a message to prefix subscribers that are not
+// already subscribed to its bin directly, and returns the number it was
+// sent to. Subscribers that fail are dropped.
+func (bm *BinManager) broadcastPrefix(bin *Bin, msg *Message) int {
+	bm.prefixMu.RLock()
+	if len(bm.prefixSubs) == 0 {
+		bm.prefixMu.RUnlock()
+		return 0
+	}
+	targets := make(map[string]Client)
+	for clientID, subscriber := range bm.prefixSubs {
+		if subscriber.matches(msg.BinID) && !bin.hasClient(clientID) {
+			targets[clientID] = subscriber.client
+		}
+	}
+	bm.prefixMu.RUnlock()
+
+	sent := 0
+	for clientID, client := range targets {
+		if err := client.SendMessage(msg); err != nil {
+			bm.UnsubscribePrefix(clientID)
+			continue
+		}
+		sent++
+	}
+	return sent
+}
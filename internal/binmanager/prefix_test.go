@@ -0,0 +1,56 @@
+package binmanager
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPrefixSubscriptionMatches(t *testing.T) {
+	sub := PrefixSubscription{Prefix: 0xAB00, Mask: 0xFF00}
+	if !sub.Matches(0xAB00) || !sub.Matches(0xABF0) {
+		t.Error("Bins under the prefix should match")
+	}
+	if sub.Matches(0xAC00) {
+		t.Error("Bins outside the prefix should not match")
+	}
+	if !(PrefixSubscription{}).Matches(0x1234) {
+		t.Error("A zero mask should match every bin")
+	}
+}
+
+func TestBinManagerPrefixSubscription(t *testing.T) {
+	manager := NewBinManager(0xFFFFFFFFFFFFF000, time.Hour)
+	manager.AddMessage(&Message{BinID: 0xA000, MessageID: "existing"})
+
+	bridge := NewMockClient()
+	prefixes := []PrefixSubscription{{Prefix: 0xA000, Mask: 0xF000}}
+	manager.SubscribePrefix("bridge", bridge, prefixes)
+
+	if bins := manager.MatchingBins(prefixes); len(bins) != 1 || bins[0] != 0xA000 {
+		t.Errorf("Expected existing bin A000 to match, got %X", bins)
+	}
+
+	// Bins created after subscribing are covered too
+	manager.AddMessage(&Message{BinID: 0xA000, MessageID: "a"})
+	manager.AddMessage(&Message{BinID: 0xA100, MessageID: "b"})
+	manager.AddMessage(&Message{BinID: 0xB000, MessageID: "c"})
+
+	received := bridge.GetMessages()
+	if len(received) != 2 || received[0].MessageID != "a" || received[1].MessageID != "b" {
+		t.Errorf("Expected messages a and b, got %d messages", len(received))
+	}
+
+	// A client subscribed to the bin directly is not sent duplicates
+	manager.Subscribe(0xA000, "bridge", bridge)
+	manager.AddMessage(&Message{BinID: 0xA000, MessageID: "d"})
+	if got := len(bridge.GetMessages()); got != 3 {
+		t.Errorf("Expected a single delivery of d, have %d messages", got)
+	}
+
+	manager.Unsubscribe(0xA000, "bridge")
+	manager.UnsubscribePrefix("bridge")
+	manager.AddMessage(&Message{BinID: 0xA100, MessageID: "e"})
+	if got := len(bridge.GetMessages()); got != 3 {
+		t.Errorf("No messages expected after unsubscribing, have %d", got)
+	}
+}
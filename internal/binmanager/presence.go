@@ -0,0 +1,67 @@
+package binmanager
+
+import "sync"
+
+// PresenceClient is a Client that can also be told how many subscribers a
+// bin has. Subscribers that do not implement it receive no summaries.
+type PresenceClient interface {
+	Client
+	SendPresence(binID uint64, subscribers int) error
+}
+
+// SetPresenceThreshold enables presence summaries, which tell a bin's
+// subscribers how many subscribers it has whenever that changes. Counts are
+// rounded down to a multiple of threshold and never sent below it, so a
+// summary says "at least 10 subscribers" rather than that one joined or
+// left, and nothing is sent for bins with fewer than threshold. Zero
+// disables summaries.
+func (bm *BinManager) SetPresenceThreshold(threshold int) {
+	bm.presenceThreshold.Store(int64(threshold))
+}
+
+// presenceLevel returns the count a summary may reveal for n subscribers;
+// zero reveals nothing
+func presenceLevel(n, threshold int) int {
+	if threshold <= 0 || n < threshold {
+		return 0
+	}
+	return n - n%threshold
+}
+
+// announcePresence sends the bin's subscribers its rounded subscriber count
+// if that changed since the last summary. Falling below the threshold only
+// resets the bin, so a summary is sent again once it is reached.
+func (bm *BinManager) announcePresence(bin *Bin) {
+	threshold := int(bm.presenceThreshold.Load())
+	if threshold <= 0 {
+		return
+	}
+
+	bin.clMutex.Lock()
+	level := presenceLevel(len(bin.Clients), threshold)
+	if level == bin.presence {
+		bin.clMutex.Unlock()
+		return
+	}
+	bin.presence = level
+	recipients := make([]PresenceClient, 0, len(bin.Clients))
+	if level > 0 {
+		for _, client := range bin.Clients {
+			if pc, ok := client.(PresenceClient); ok {
+				recipients = append(recipients, pc)
+			}
+		}
+	}
+	bin.clMutex.Unlock()
+
+	// Failed sends are left to message delivery, which drops the client
+	var wg sync.WaitGroup
+	for _, client := range recipients {
+		wg.Add(1)
+		go func(c PresenceClient) {
+			defer wg.Done()
+			c.SendPresence(bin.ID, level)
+		}(client)
+	}
+	wg.Wait()
+}
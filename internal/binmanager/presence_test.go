@@ -0,0 +1,78 @@
+package binmanager
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// presenceClient records the presence summaries it receives
+type presenceClient struct {
+	*MockClient
+	counts []int
+	mu     sync.Mutex
+}
+
+func (c *presenceClient) SendPresence(binID uint64, subscribers int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counts = append(c.counts, subscribers)
+	return nil
+}
+
+func (c *presenceClient) summaries() []int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]int(nil), c.counts...)
+}
+
+func TestPresenceThreshold(t *testing.T) {
+	bm := NewBinManager(0xFFFFFFFFFFFFF000, time.Hour)
+	bm.SetPresenceThreshold(3)
+	binID := uint64(0x1000)
+
+	first := &presenceClient{MockClient: NewMockClient()}
+	bm.Subscribe(binID, "first", first)
+	bm.Subscribe(binID, "second", NewMockClient())
+	if got := first.summaries(); len(got) != 0 {
+		t.Fatalf("No summary should be sent below the threshold, got %v", got)
+	}
+
+	// Reaching the threshold announces it; joins within the same multiple
+	// do not
+	bm.Subscribe(binID, "third", NewMockClient())
+	bm.Subscribe(binID, "fourth", NewMockClient())
+	bm.Subscribe(binID, "first", first)
+	if got := first.summaries(); len(got) != 1 || got[0] != 3 {
+		t.Fatalf("Expected one summary of 3, got %v", got)
+	}
+
+	for i := 0; i < 2; i++ {
+		bm.Subscribe(binID, fmt.Sprintf("extra%d", i), NewMockClient())
+	}
+	if got := first.summaries(); len(got) != 2 || got[1] != 6 {
+		t.Fatalf("Expected a summary of 6, got %v", got)
+	}
+
+	// Departures are summarized the same way; falling below the threshold
+	// sends nothing, and reaching it again announces it again
+	bm.Unsubscribe(binID, "extra0")
+	bm.Unsubscribe(binID, "extra1")
+	bm.Unsubscribe(binID, "fourth")
+	bm.Unsubscribe(binID, "third")
+	if got := first.summaries(); len(got) != 3 || got[2] != 3 {
+		t.Fatalf("Expected a summary of 3, got %v", got)
+	}
+	bm.Subscribe(binID, "third", NewMockClient())
+	if got := first.summaries(); len(got) != 4 || got[3] != 3 {
+		t.Fatalf("Expected the threshold to be announced again, got %v", got)
+	}
+
+	bm.SetPresenceThreshold(0)
+	bm.Subscribe(binID, "late", NewMockClient())
+	bm.Subscribe(binID, "later", NewMockClient())
+	if got := first.summaries(); len(got) != 4 {
+		t.Errorf("Disabled presence should send nothing, got %v", got)
+	}
+}
@@ -0,0 +1,274 @@
+package binmanager
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// RecentCacheStats summarizes the contents of a RecentCache
+type RecentCacheStats struct {
+	Bins     int    `json:"bins"`
+	Messages int    `json:"messages"`
+	Hits     uint64 `json:"hits"`
+	Misses   uint64 `json:"misses"`
+}
+
+// RecentCache keeps the newest messages of the most recently read bins in
+// memory, in front of a disk store, so history replay for new subscribers
+// of hot bins does not read the disk on every subscribe. A bin is cached
+// after its first open-ended read; the least recently read bin is dropped
+// once more than maxBins are cached.
+type RecentCache struct {
+	perBin   int
+	maxBins  int
+	entries  map[uint64]*list.Element
+	lru      *list.List // Front is the most recently read bin
+	hits     uint64
+	misses   uint64
+	onLookup func(hit bool)
+	mu       sync.Mutex
+}
+
+// recentEntry holds the cached tail of one bin. Every stored message newer
+// than covers is in messages, oldest first; a zero covers means the cache
+// holds the whole bin.
+type recentEntry struct {
+	binID    uint64
+	messages []*Message
+	covers   time.Time
+}
+
+// NewRecentCache creates a cache of the newest perBin messages of up to
+// maxBins bins
+func NewRecentCache(perBin, maxBins int) *RecentCache {
+	return &RecentCache{
+		perBin:  perBin,
+		maxBins: maxBins,
+		entries: make(map[uint64]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// OnLookup registers a callback invoked after every cache lookup
+func (rc *RecentCache) OnLookup(fn func(hit bool)) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.onLookup = fn
+}
+
+// Stats returns the cached bins and messages and the lookup counts
+func (rc *RecentCache) Stats() RecentCacheStats {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	stats := RecentCacheStats{Bins: len(rc.entries), Hits: rc.hits, Misses: rc.misses}
+	for _, elem := range rc.entries {
+		stats.Messages += len(elem.Value.(*recentEntry).messages)
+	}
+	return stats
+}
+
+// Wrap returns a factory whose stores are served from the cache in front
+// of the stores created by factory
+func (rc *RecentCache) Wrap(factory StoreFactory) StoreFactory {
+	return func(binID uint64) BinStore {
+		inner := factory(binID)
+		cached := &cachedStore{inner: inner, cache: rc, binID: binID}
+		if ds, ok := inner.(DurableStore); ok {
+			return &cachedDurableStore{cachedStore: cached, durable: ds}
+		}
+		return cached
+	}
+}
+
+// lookup returns the cached messages with from < Timestamp <= to, if the
+// cache covers that window
+func (rc *RecentCache) lookup(binID uint64, from, to time.Time) ([]*Message, bool) {
+	rc.mu.Lock()
+	var result []*Message
+	elem, hit := rc.entries[binID]
+	if hit {
+		entry := elem.Value.(*recentEntry)
+		hit = !from.Before(entry.covers)
+		if hit {
+			result = make([]*Message, 0, len(entry.messages))
+			for _, msg := range entry.messages {
+				if msg.Timestamp.After(from) && !msg.Timestamp.After(to) {
+					result = append(result, msg)
+				}
+			}
+			rc.lru.MoveToFront(elem)
+		}
+	}
+	if hit {
+		rc.hits++
+	} else {
+		rc.misses++
+	}
+	onLookup := rc.onLookup
+	rc.mu.Unlock()
+
+	if onLookup != nil {
+		onLookup(hit)
+	}
+	return result, hit
+}
+
+// fill caches the tail of an open-ended read of every message after from
+func (rc *RecentCache) fill(binID uint64, from time.Time, messages []*Message) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	entry := &recentEntry{binID: binID, covers: from}
+	if len(messages) > rc.perBin {
+		entry.covers = messages[len(messages)-rc.perBin-1].Timestamp
+		messages = messages[len(messages)-rc.perBin:]
+	}
+	entry.messages = append(make([]*Message, 0, len(messages)), messages...)
+
+	if elem, exists := rc.entries[binID]; exists {
+		elem.Value = entry
+		rc.lru.MoveToFront(elem)
+		return
+	}
+	rc.entries[binID] = rc.lru.PushFront(entry)
+	for rc.lru.Len() > rc.maxBins {
+		oldest := rc.lru.Back()
+		rc.lru.Remove(oldest)
+		delete(rc.entries, oldest.Value.(*recentEntry).binID)
+	}
+}
+
+// appended adds a stored message to a cached bin. A message older than the
+// cached tail would break its ordering, so the bin is dropped instead.
+func (rc *RecentCache) appended(binID uint64, msg *Message) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	elem, exists := rc.entries[binID]
+	if !exists {
+		return
+	}
+	entry := elem.Value.(*recentEntry)
+	if n := len(entry.messages); n > 0 && msg.Timestamp.Before(entry.messages[n-1].Timestamp) {
+		rc.removeLocked(elem)
+		return
+	}
+
+	entry.messages = append(entry.messages, msg)
+	if excess := len(entry.messages) - rc.perBin; excess > 0 {
+		entry.covers = entry.messages[excess-1].Timestamp
+		for i := 0; i < excess; i++ {
+			entry.messages[i] = nil
+		}
+		entry.messages = entry.messages[excess:]
+	}
+}
+
+// deleted drops cached messages with Timestamp <= cutoff after the store
+// removed them
+func (rc *RecentCache) deleted(binID uint64, cutoff time.Time) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	elem, exists := rc.entries[binID]
+	if !exists {
+		return
+	}
+	entry := elem.Value.(*recentEntry)
+	kept := make([]*Message, 0, len(entry.messages))
+	for _, msg := range entry.messages {
+		if msg.Timestamp.After(cutoff) {
+			kept = append(kept, msg)
+		}
+	}
+	entry.messages = kept
+
+	// Nothing at or before cutoff is stored any more, so a cache that
+	// covered everything after it now covers the whole bin
+	if !entry.covers.After(cutoff) {
+		entry.covers = time.Time{}
+	}
+}
+
+// invalidate drops a bin whose store may have changed in an unknown way
+func (rc *RecentCache) invalidate(binID uint64) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if elem, exists := rc.entries[binID]; exists {
+		rc.removeLocked(elem)
+	}
+}
+
+// removeLocked drops a cached bin; callers hold rc.mu
+func (rc *RecentCache) removeLocked(elem *list.Element) {
+	rc.lru.Remove(elem)
+	delete(rc.entries, elem.Value.(*recentEntry).binID)
+}
+
+// cachedStore is the view of a bin store through a RecentCache
+type cachedStore struct {
+	inner BinStore
+	cache *RecentCache
+	binID uint64
+}
+
+// AppendMessage stores the message and adds it to the cached tail
+func (s *cachedStore) AppendMessage(msg *Message) error {
+	if err := s.inner.AppendMessage(msg); err != nil {
+		s.cache.invalidate(s.binID)
+		return err
+	}
+	s.cache.appended(s.binID, msg)
+	return nil
+}
+
+// RangeByTime serves the window from the cache if it covers it, and
+// caches the result of open-ended reads it does not cover
+func (s *cachedStore) RangeByTime(from, to time.Time) ([]*Message, error) {
+	if messages, hit := s.cache.lookup(s.binID, from, to); hit {
+		return messages, nil
+	}
+
+	messages, err := s.inner.RangeByTime(from, to)
+	if err == nil && !to.Before(farFuture) {
+		s.cache.fill(s.binID, from, messages)
+	}
+	return messages, err
+}
+
+// DeleteBefore removes the messages from the store and the cache
+func (s *cachedStore) DeleteBefore(cutoff time.Time) (int, error) {
+	removed, err := s.inner.DeleteBefore(cutoff)
+	if err != nil {
+		s.cache.invalidate(s.binID)
+		return removed, err
+	}
+	s.cache.deleted(s.binID, cutoff)
+	return removed, nil
+}
+
+// Stats is passed through to the store
+func (s *cachedStore) Stats() StoreStats {
+	return s.inner.Stats()
+}
+
+// cachedDurableStore is a cachedStore over a store that supports durable
+// appends
+type cachedDurableStore struct {
+	*cachedStore
+	durable DurableStore
+}
+
+// AppendMessageDurable stores and syncs the message and adds it to the
+// cached tail
+func (s *cachedDurableStore) AppendMessageDurable(msg *Message) error {
+	if err := s.durable.AppendMessageDurable(msg); err != nil {
+		s.cache.invalidate(s.binID)
+		return err
+	}
+	s.cache.appended(s.binID, msg)
+	return nil
+}
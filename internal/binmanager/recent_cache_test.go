@@ -0,0 +1,102 @@
+package binmanager
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// countingStore counts the range reads that reach the wrapped store
+type countingStore struct {
+	BinStore
+	ranges int
+}
+
+func (s *countingStore) RangeByTime(from, to time.Time) ([]*Message, error) {
+	s.ranges++
+	return s.BinStore.RangeByTime(from, to)
+}
+
+func TestRecentCacheStoreBehavior(t *testing.T) {
+	cache := NewRecentCache(2, 4)
+	factory := cache.Wrap(func(uint64) BinStore { return NewMemoryStore() })
+	testStoreBehavior(t, factory(0x1000))
+}
+
+func TestRecentCacheServesHotBins(t *testing.T) {
+	inner := &countingStore{BinStore: NewMemoryStore()}
+	cache := NewRecentCache(3, 4)
+	var hits, misses int
+	cache.OnLookup(func(hit bool) {
+		if hit {
+			hits++
+		} else {
+			misses++
+		}
+	})
+	store := cache.Wrap(func(uint64) BinStore { return inner })(0x1000)
+
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		msg := &Message{BinID: 0x1000, MessageID: fmt.Sprintf("msg%d", i), Timestamp: now.Add(time.Duration(i-5) * time.Minute)}
+		if err := store.AppendMessage(msg); err != nil {
+			t.Fatalf("Failed to append message: %v", err)
+		}
+	}
+
+	// The first replay reads the store and caches the newest three messages
+	messages, err := store.RangeByTime(now.Add(-time.Hour), farFuture)
+	if err != nil || len(messages) != 5 {
+		t.Fatalf("Expected 5 messages, got %d (%v)", len(messages), err)
+	}
+
+	// Replays within the cached tail, including new messages, skip the store
+	store.AppendMessage(&Message{BinID: 0x1000, MessageID: "msg5", Timestamp: now})
+	messages, _ = store.RangeByTime(now.Add(-150*time.Second), farFuture)
+	if len(messages) != 3 || messages[2].MessageID != "msg5" {
+		t.Errorf("Unexpected cached replay: %d messages", len(messages))
+	}
+	if inner.ranges != 1 {
+		t.Errorf("Expected 1 store read, got %d", inner.ranges)
+	}
+
+	// Older windows than the cached tail still read the store
+	messages, _ = store.RangeByTime(now.Add(-time.Hour), farFuture)
+	if len(messages) != 6 || inner.ranges != 2 {
+		t.Errorf("Expected 6 messages from the store, got %d after %d reads", len(messages), inner.ranges)
+	}
+
+	// Expired messages leave the cache together with the store
+	store.DeleteBefore(now.Add(-time.Second))
+	messages, _ = store.RangeByTime(time.Time{}, farFuture)
+	if len(messages) != 1 || inner.ranges != 2 {
+		t.Errorf("Expected 1 cached message after expiry, got %d after %d reads", len(messages), inner.ranges)
+	}
+
+	stats := cache.Stats()
+	if stats.Hits != 2 || stats.Misses != 2 || hits != 2 || misses != 2 {
+		t.Errorf("Unexpected lookup counts %+v (callback %d/%d)", stats, hits, misses)
+	}
+}
+
+func TestRecentCacheEvictsLeastRecentlyReadBin(t *testing.T) {
+	cache := NewRecentCache(10, 2)
+	factory := cache.Wrap(func(uint64) BinStore { return NewMemoryStore() })
+
+	stores := make([]BinStore, 3)
+	for i := range stores {
+		stores[i] = factory(uint64(i+1) << 12)
+		stores[i].AppendMessage(&Message{MessageID: "msg", Timestamp: time.Now()})
+		stores[i].RangeByTime(time.Time{}, farFuture)
+	}
+
+	stats := cache.Stats()
+	if stats.Bins != 2 || stats.Messages != 2 {
+		t.Errorf("Expected 2 cached bins, got %+v", stats)
+	}
+
+	stores[0].RangeByTime(time.Time{}, farFuture)
+	if stats := cache.Stats(); stats.Hits != 0 || stats.Misses != 4 {
+		t.Errorf("Evicted bin should miss, got %+v", stats)
+	}
+}
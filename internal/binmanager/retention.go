@@ -0,0 +1,205 @@
+package binmanager
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// Retention adjustment actions
+const (
+	RetentionReduced  = "reduce"
+	RetentionRestored = "restore"
+)
+
+// maxRetentionEvents bounds the event history kept by a RetentionController
+const maxRetentionEvents = 100
+
+// restoreThreshold is the fraction of the budget that retained bytes may
+// reach at the restored retention; the gap avoids flapping
+const restoreThreshold = 0.75
+
+// RetentionEvent records one change of the effective retention
+type RetentionEvent struct {
+	Time             time.Time `json:"time"`
+	Action           string    `json:"action"`
+	PreviousSeconds  int64     `json:"previous_seconds"`
+	RetentionSeconds int64     `json:"retention_seconds"`
+	RetainedBytes    int64     `json:"retained_bytes"`
+	BudgetBytes      int64     `json:"budget_bytes"`
+}
+
+// RetentionStatus describes the controller state for the admin API
+type RetentionStatus struct {
+	ConfiguredSeconds int64            `json:"configured_seconds"`
+	EffectiveSeconds  int64            `json:"effective_seconds"`
+	FloorSeconds      int64            `json:"floor_seconds"`
+	BudgetBytes       int64            `json:"budget_bytes"`
+	RetainedBytes     int64            `json:"retained_bytes"`
+	Events            []RetentionEvent `json:"events"`
+}
+
+// RetentionController shortens the effective retention of a BinManager when
+// retained bytes exceed a budget, halving it per evaluation down to a floor,
+// and lengthens it again once the projected usage at the longer retention
+// fits comfortably within the budget. This degrades history predictably
+// instead of running out of memory.
+type RetentionController struct {
+	bm         *BinManager
+	configured time.Duration
+	floor      time.Duration
+	budget     int64
+	onEvent    func(RetentionEvent)
+	events     []RetentionEvent
+	lastBytes  int64
+	ticker     *time.Ticker
+	done       chan struct{}
+	mu         sync.Mutex
+}
+
+// NewRetentionController creates a controller for bm. The retention bm was
+// created with is the maximum it restores to.
+func NewRetentionController(bm *BinManager, budget int64, floor time.Duration) *RetentionController {
+	configured := bm.Retention()
+	if floor > configured {
+		floor = configured
+	}
+	return &RetentionController{
+		bm:         bm,
+		configured: configured,
+		floor:      floor,
+		budget:     budget,
+		events:     make([]RetentionEvent, 0),
+	}
+}
+
+// OnEvent registers a callback invoked for every retention change
+func (rc *RetentionController) OnEvent(fn func(RetentionEvent)) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.onEvent = fn
+}
+
+// Evaluate checks storage pressure once and adjusts the retention if needed.
+// It returns the event for a change, or nil.
+func (rc *RetentionController) Evaluate() *RetentionEvent {
+	bytes := rc.bm.Usage().Bytes
+	effective := rc.bm.Retention()
+
+	rc.mu.Lock()
+	rc.lastBytes = bytes
+
+	var (
+		event *RetentionEvent
+		next  time.Duration
+	)
+	switch {
+	case bytes > rc.budget && effective > rc.floor:
+		next = effective / 2
+		if next < rc.floor {
+			next = rc.floor
+		}
+		event = rc.recordLocked(RetentionReduced, effective, next, bytes)
+
+	case effective < rc.configured:
+		next = effective * 2
+		if next > rc.configured {
+			next = rc.configured
+		}
+		// Assume bytes grow in proportion to retention
+		projected := float64(bytes) * float64(next) / float64(effective)
+		if projected <= restoreThreshold*float64(rc.budget) {
+			event = rc.recordLocked(RetentionRestored, effective, next, bytes)
+		}
+	}
+	onEvent := rc.onEvent
+	rc.mu.Unlock()
+
+	if event == nil {
+		return nil
+	}
+
+	rc.bm.SetRetention(next)
+	if event.Action == RetentionReduced {
+		// Release memory now rather than at the next cleanup tick
+		rc.bm.cleanup()
+	}
+
+	log.Printf("Retention %s: %v -> %v (%d of %d bytes retained)",
+		event.Action, effective, next, event.RetainedBytes, event.BudgetBytes)
+	if onEvent != nil {
+		onEvent(*event)
+	}
+	return event
+}
+
+// Status returns the current state and recent events
+func (rc *RetentionController) Status() RetentionStatus {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	events := make([]RetentionEvent, len(rc.events))
+	copy(events, rc.events)
+
+	return RetentionStatus{
+		ConfiguredSeconds: int64(rc.configured / time.Second),
+		EffectiveSeconds:  int64(rc.bm.Retention() / time.Second),
+		FloorSeconds:      int64(rc.floor / time.Second),
+		BudgetBytes:       rc.budget,
+		RetainedBytes:     rc.lastBytes,
+		Events:            events,
+	}
+}
+
+// Start evaluates storage pressure every interval until Stop is called
+func (rc *RetentionController) Start(interval time.Duration) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if rc.ticker != nil {
+		return
+	}
+	rc.ticker = time.NewTicker(interval)
+	rc.done = make(chan struct{})
+
+	go func(ticker *time.Ticker, done chan struct{}) {
+		for {
+			select {
+			case <-ticker.C:
+				rc.Evaluate()
+			case <-done:
+				return
+			}
+		}
+	}(rc.ticker, rc.done)
+}
+
+// Stop stops periodic evaluation
+func (rc *RetentionController) Stop() {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if rc.ticker != nil {
+		rc.ticker.Stop()
+		close(rc.done)
+		rc.ticker = nil
+	}
+}
+
+// recordLocked appends an event to the bounded history; callers hold rc.mu
+func (rc *RetentionController) recordLocked(action string, from, to time.Duration, bytes int64) *RetentionEvent {
+	event := RetentionEvent{
+		Time:             time.Now(),
+		Action:           action,
+		PreviousSeconds:  int64(from / time.Second),
+		RetentionSeconds: int64(to / time.Second),
+		RetainedBytes:    bytes,
+		BudgetBytes:      rc.budget,
+	}
+
+	rc.events = append(rc.events, event)
+	if len(rc.events) > maxRetentionEvents {
+		rc.events = rc.events[len(rc.events)-maxRetentionEvents:]
+	}
+	return &event
+}
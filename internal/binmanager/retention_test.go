@@ -0,0 +1,77 @@
+package binmanager
+
+import (
+	"testing"
+	"time"
+)
+
+// agedMessageSize is the accounted size of a message from addAgedMessages
+var agedMessageSize = (&Message{Ciphertext: make([]byte, 100)}).Size()
+
+// addAgedMessages stores 100-byte messages with the given ages in one bin
+func addAgedMessages(bm *BinManager, ages ...time.Duration) {
+	bin := bm.getOrCreateBin(0x1000)
+	now := time.Now()
+	for _, age := range ages {
+		bin.AddMessage(&Message{BinID: 0x1000, Ciphertext: make([]byte, 100), Timestamp: now.Add(-age)})
+	}
+}
+
+func TestRetentionControllerReducesAndRestores(t *testing.T) {
+	bm := NewBinManager(0xFFFFFFFFFFFFF000, 8*time.Hour)
+
+	ages := make([]time.Duration, 0, 10)
+	for i := 0; i < 10; i++ {
+		ages = append(ages, time.Duration(i)*48*time.Minute)
+	}
+	addAgedMessages(bm, ages...)
+
+	var observed []RetentionEvent
+	rc := NewRetentionController(bm, 5*agedMessageSize, time.Hour)
+	rc.OnEvent(func(e RetentionEvent) { observed = append(observed, e) })
+
+	// Ten messages against a budget of five halves the retention
+	event := rc.Evaluate()
+	if event == nil || event.Action != RetentionReduced {
+		t.Fatalf("Expected a reduce event, got %+v", event)
+	}
+	if bm.Retention() != 4*time.Hour {
+		t.Errorf("Retention should be 4h, got %v", bm.Retention())
+	}
+	if bytes := bm.Stats().Bytes; bytes != 5*agedMessageSize {
+		t.Errorf("Messages beyond 4h should be dropped immediately, %d bytes retained", bytes)
+	}
+
+	// Doubling would project ten messages again, so retention stays reduced
+	if event := rc.Evaluate(); event != nil {
+		t.Errorf("Retention should not change at the budget, got %+v", event)
+	}
+
+	// Once usage falls well below budget the retention is restored
+	bm.getOrCreateBin(0x1000).RemoveMessagesBefore(time.Now().Add(-30 * time.Minute))
+	event = rc.Evaluate()
+	if event == nil || event.Action != RetentionRestored || bm.Retention() != 8*time.Hour {
+		t.Errorf("Expected retention restored to 8h, got %+v and %v", event, bm.Retention())
+	}
+
+	if len(observed) != 2 || len(rc.Status().Events) != 2 {
+		t.Errorf("Expected 2 events, observed %d", len(observed))
+	}
+}
+
+func TestRetentionControllerFloor(t *testing.T) {
+	bm := NewBinManager(0xFFFFFFFFFFFFF000, 8*time.Hour)
+	addAgedMessages(bm, 0, time.Minute, 2*time.Minute)
+
+	rc := NewRetentionController(bm, 50, time.Hour)
+	for i := 0; i < 5; i++ {
+		rc.Evaluate()
+	}
+
+	if bm.Retention() != time.Hour {
+		t.Errorf("Retention should stop at the 1h floor, got %v", bm.Retention())
+	}
+	if status := rc.Status(); status.EffectiveSeconds != 3600 || len(status.Events) != 3 {
+		t.Errorf("Expected 3 reductions to the floor, got %+v", status)
+	}
+}
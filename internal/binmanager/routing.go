@@ -0,0 +1,368 @@
+package binmanager
+
+import (
+	"errors"
+	"math/bits"
+	"time"
+)
+
+// ErrStaleMask is returned for a bin ID computed with a mask that has since
+// gained bits: the bits the old mask dropped decide the bin now, so the
+// publisher must recompute it from the channel ID
+var ErrStaleMask = errors.New("bin ID computed with an outdated bin mask")
+
+// maskHistorySize is how many past masks are remembered for publishes
+// tagged with an older epoch
+const maskHistorySize = 64
+
+// routingTable is the bin mask of one epoch. Tables are never modified:
+// every mask change publishes a new one, so readers load the current table
+// without taking bm.mutex.
+type routingTable struct {
+	mask  uint64
+	epoch uint64
+	// unmigrated holds the bits contractions dropped while bins keyed with
+	// them may remain. Such a legacy bin is merged into its bin under mask
+	// when that bin is next used, or by the cleanup pass.
+	unmigrated uint64
+	// unsplit holds the bits expansions added while subscriptions made or
+	// messages stored without them may remain. A bin carrying such bits
+	// also reaches its ancestors, the bins that lack some of them: their
+	// subscribers whose mask maps the bin to them and their messages from
+	// before the split. Stored messages are not rehashed, so both halves
+	// of a split bin replay the same pre-split history, each seeing the
+	// other's messages from before the split until they expire. The cleanup
+	// pass clears bits nothing predates.
+	unsplit uint64
+	splitAt map[uint64]time.Time // When each unsplit bit was added
+}
+
+// legacy reports whether binID still carries a bit a contraction dropped
+func (t *routingTable) legacy(binID uint64) bool {
+	return binID&t.unmigrated != 0
+}
+
+// settled reports whether no legacy bin can map to bin any longer
+func (t *routingTable) settled(bin *Bin) bool {
+	return t.unmigrated == 0 || bin.epoch.Load() == t.epoch
+}
+
+// setMaskLocked publishes a table for mask, starting a new epoch and
+// remembering the old mask. Bits the new mask drops are left for lazy
+// migration and bits it adds for lazy splitting; callers hold bm.mutex for
+// writing.
+func (bm *BinManager) setMaskLocked(mask uint64) {
+	table := bm.routing.Load()
+	bm.maskHistory[table.epoch] = table.mask
+	if table.epoch >= maskHistorySize {
+		delete(bm.maskHistory, table.epoch-maskHistorySize)
+	}
+	added := mask &^ table.mask
+	next := &routingTable{
+		mask:       mask,
+		epoch:      table.epoch + 1,
+		unmigrated: (table.unmigrated | table.mask&^mask) &^ mask,
+		unsplit:    (table.unsplit | added) & mask,
+	}
+	if next.unsplit != 0 {
+		next.splitAt = make(map[uint64]time.Time, bits.OnesCount64(next.unsplit))
+		for bit, at := range table.splitAt {
+			if next.unsplit&bit != 0 {
+				next.splitAt[bit] = at
+			}
+		}
+		now := time.Now()
+		for rest := added; rest != 0; rest &= rest - 1 {
+			next.splitAt[rest&-rest] = now
+		}
+	}
+	bm.routing.Store(next)
+}
+
+// rebinLocked maps a bin ID computed with the mask of epoch to its bin
+// under the current mask. That is exact while the current mask only lacks
+// bits of the old one; callers hold bm.mutex.
+func (bm *BinManager) rebinLocked(binID, epoch uint64) (uint64, error) {
+	table := bm.routing.Load()
+	if epoch != table.epoch {
+		mask, known := bm.maskHistory[epoch]
+		if !known || table.mask&^mask != 0 {
+			return 0, ErrStaleMask
+		}
+	}
+	return binID & table.mask, nil
+}
+
+// routeMessage normalizes a publish's bin ID to the current mask and
+// returns that mask. A publish tagged with the mask epoch its bin ID was
+// computed with is re-binned from that epoch's mask; untagged bin IDs are
+// masked as they are, which suits raw channel IDs.
+func (bm *BinManager) routeMessage(msg *Message) (MaskState, error) {
+	bm.mutex.RLock()
+	defer bm.mutex.RUnlock()
+
+	table := bm.routing.Load()
+	state := MaskState{Mask: table.mask, Epoch: table.epoch}
+	if msg.MaskEpoch == nil {
+		msg.BinID &= table.mask
+		return state, nil
+	}
+	binID, err := bm.rebinLocked(msg.BinID, *msg.MaskEpoch)
+	if err != nil {
+		return state, err
+	}
+	msg.BinID = binID
+	return state, nil
+}
+
+// routedBin returns the bin a message is stored in. A message routed by
+// the pipeline is re-binned from the mask it was routed with, in case the
+// mask changed since; the bin is looked up under the same lock, so the
+// mask cannot change in between.
+func (bm *BinManager) routedBin(msg *Message) (*Bin, error) {
+	bm.mutex.RLock()
+	binID, err := bm.storedBinLocked(msg)
+	bin, exists := bm.bins[binID]
+	settled := exists && bm.routing.Load().settled(bin)
+	bm.mutex.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	if settled {
+		msg.BinID = binID
+		return bin, nil
+	}
+
+	bm.mutex.Lock()
+	defer bm.mutex.Unlock()
+	binID, err = bm.storedBinLocked(msg)
+	if err != nil {
+		return nil, err
+	}
+	msg.BinID = binID
+	return bm.binLocked(binID), nil
+}
+
+// storedBinLocked returns the current bin ID of a message about to be
+// stored; callers hold bm.mutex
+func (bm *BinManager) storedBinLocked(msg *Message) (uint64, error) {
+	if msg.routed == nil {
+		return msg.BinID & bm.routing.Load().mask, nil
+	}
+	return bm.rebinLocked(msg.BinID, msg.routed.Epoch)
+}
+
+// lookupBin returns an existing bin after merging in the legacy bins that
+// map to it. Legacy bin IDs are reported missing, as if their bins had
+// been merged already.
+func (bm *BinManager) lookupBin(binID uint64) (*Bin, bool) {
+	bm.mutex.RLock()
+	table := bm.routing.Load()
+	bin, exists := bm.bins[binID]
+	settled := table.unmigrated == 0 || exists && table.settled(bin)
+	bm.mutex.RUnlock()
+
+	if table.legacy(binID) {
+		return nil, false
+	}
+	if settled {
+		return bin, exists
+	}
+
+	bm.mutex.Lock()
+	defer bm.mutex.Unlock()
+	bin = bm.settleLocked(binID)
+	return bin, bin != nil
+}
+
+// binLocked returns the bin for binID under the current mask, settling or
+// creating it. A legacy bin ID resolves to the bin it is merged into.
+// Callers hold bm.mutex for writing.
+func (bm *BinManager) binLocked(binID uint64) *Bin {
+	table := bm.routing.Load()
+	binID &= table.mask
+	bin := bm.settleLocked(binID)
+	if bin == nil {
+		bin = bm.newBin(binID)
+		bin.epoch.Store(table.epoch)
+		bm.bins[binID] = bin
+	}
+	return bin
+}
+
+// settleLocked merges the legacy bins that map to binID into it, creating
+// it if any exist, and tags it with the current epoch. It returns the bin,
+// or nil if there is none; callers hold bm.mutex for writing.
+func (bm *BinManager) settleLocked(binID uint64) *Bin {
+	table := bm.routing.Load()
+	bin := bm.bins[binID]
+	if table.unmigrated != 0 && !table.legacy(binID) {
+		for _, legacyID := range bm.legacyBinsLocked(binID, table.unmigrated) {
+			if bin == nil {
+				bin = bm.newBin(binID)
+				bm.bins[binID] = bin
+			}
+			bin.mergeFrom(bm.bins[legacyID])
+			delete(bm.bins, legacyID)
+		}
+	}
+	if bin != nil {
+		bin.epoch.Store(table.epoch)
+	}
+	return bin
+}
+
+// legacyBinsLocked returns the IDs of the legacy bins that map to binID.
+// It probes every combination of the unmigrated bits, or scans the bins
+// when there are more combinations than bins; callers hold bm.mutex.
+func (bm *BinManager) legacyBinsLocked(binID, unmigrated uint64) []uint64 {
+	var legacyIDs []uint64
+	if bits.OnesCount64(unmigrated) < bits.Len(uint(len(bm.bins))) {
+		for subset := unmigrated; subset != 0; subset = (subset - 1) & unmigrated {
+			if _, exists := bm.bins[binID|subset]; exists {
+				legacyIDs = append(legacyIDs, binID|subset)
+			}
+		}
+		return legacyIDs
+	}
+	for id := range bm.bins {
+		if id != binID && id&^unmigrated == binID {
+			legacyIDs = append(legacyIDs, id)
+		}
+	}
+	return legacyIDs
+}
+
+// migrateBins merges the remaining legacy bins into their bins under the
+// current mask, taking the lock for one bin at a time, and publishes a
+// table without unmigrated bits once none are left
+func (bm *BinManager) migrateBins() {
+	table := bm.routing.Load()
+	if table.unmigrated == 0 {
+		return
+	}
+
+	for _, bin := range bm.snapshotBins() {
+		if !table.legacy(bin.ID) {
+			continue
+		}
+		bm.mutex.Lock()
+		if bm.bins[bin.ID] == bin {
+			bm.settleLocked(bin.ID &^ bm.routing.Load().unmigrated)
+		}
+		bm.mutex.Unlock()
+	}
+
+	// Bins created since the snapshot never carry unmigrated bits, so the
+	// table is settled unless the mask changed meanwhile
+	bm.mutex.Lock()
+	if bm.routing.Load() == table {
+		settled := *table
+		settled.unmigrated = 0
+		bm.routing.Store(&settled)
+	}
+	bm.mutex.Unlock()
+}
+
+// ancestor is a bin another was split off, with the time they were split
+type ancestor struct {
+	bin     *Bin
+	splitAt time.Time
+}
+
+// ancestors returns the bins binID was split off while their subscribers
+// and messages may still cover it, settling each
+func (bm *BinManager) ancestors(binID uint64) []ancestor {
+	table := bm.routing.Load()
+	if binID&table.unsplit == 0 || binID&^table.mask != 0 {
+		return nil
+	}
+
+	bm.mutex.RLock()
+	ancestorIDs := bm.ancestorIDsLocked(binID, table.unsplit)
+	bm.mutex.RUnlock()
+
+	var found []ancestor
+	for _, ancestorID := range ancestorIDs {
+		bin, exists := bm.lookupBin(ancestorID)
+		if !exists {
+			continue
+		}
+		// Only messages older than the first split between the two bins
+		// may belong to binID
+		var splitAt time.Time
+		for rest := binID &^ ancestorID; rest != 0; rest &= rest - 1 {
+			if at := table.splitAt[rest&-rest]; splitAt.IsZero() || at.Before(splitAt) {
+				splitAt = at
+			}
+		}
+		found = append(found, ancestor{bin: bin, splitAt: splitAt})
+	}
+	return found
+}
+
+// ancestorIDsLocked returns the IDs of the existing bins that lack some of
+// the unsplit bits binID carries and agree with it otherwise. It probes
+// every combination of those bits, or scans the bins when there are more
+// combinations than bins; callers hold bm.mutex.
+func (bm *BinManager) ancestorIDsLocked(binID, unsplit uint64) []uint64 {
+	split := binID & unsplit
+	var ancestorIDs []uint64
+	if bits.OnesCount64(split) < bits.Len(uint(len(bm.bins))) {
+		for subset := split; subset != 0; subset = (subset - 1) & split {
+			if _, exists := bm.bins[binID&^subset]; exists {
+				ancestorIDs = append(ancestorIDs, binID&^subset)
+			}
+		}
+		return ancestorIDs
+	}
+	for id := range bm.bins {
+		if id != binID && id&^binID == 0 && (binID&^id)&^split == 0 {
+			ancestorIDs = append(ancestorIDs, id)
+		}
+	}
+	return ancestorIDs
+}
+
+// settleSplits publishes a table without the unsplit bits that nothing
+// predates any longer: no subscription was made without them and every
+// message stored before them has expired
+func (bm *BinManager) settleSplits(now time.Time) {
+	table := bm.routing.Load()
+	if table.unsplit == 0 {
+		return
+	}
+
+	var pending uint64
+	for _, bin := range bm.snapshotBins() {
+		horizon := now.Add(-bm.retentionFor(bin))
+		for bit, at := range table.splitAt {
+			if at.After(horizon) {
+				pending |= bit
+			}
+		}
+		pending |= bin.subscribedWithout(table.unsplit)
+	}
+	if pending == table.unsplit {
+		return
+	}
+
+	// New subscriptions carry every bit of the mask, so the table is
+	// settled unless the mask changed meanwhile
+	bm.mutex.Lock()
+	if bm.routing.Load() == table {
+		settled := *table
+		settled.unsplit = pending
+		settled.splitAt = nil
+		if pending != 0 {
+			settled.splitAt = make(map[uint64]time.Time, bits.OnesCount64(pending))
+			for bit, at := range table.splitAt {
+				if pending&bit != 0 {
+					settled.splitAt[bit] = at
+				}
+			}
+		}
+		bm.routing.Store(&settled)
+	}
+	bm.mutex.Unlock()
+}
@@ -0,0 +1,262 @@
+package binmanager
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"sort"
+	"time"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// SealKeySize is the size of the key that seals persisted messages
+const SealKeySize = 32
+
+// SealBucket is the default length of the time buckets a sealed store files
+// records under. The backend learns the bucket a message was stored in and
+// nothing finer; expired messages stay on disk, unreadable, until their
+// whole bucket has expired.
+const SealBucket = time.Hour
+
+// ErrInvalidSealKey is returned for a seal key that is not SealKeySize bytes
+var ErrInvalidSealKey = errors.New("seal key must be 32 bytes")
+
+// Sealer encrypts messages before a disk backend stores them, so the
+// backend holds no ciphertext, bin ID, message ID or exact timestamp in the
+// clear. Records are kept under a keyed pseudonym of their bin, which the
+// sealer can reverse to restore bins on startup, and under the start of
+// their time bucket, so the backend can still range and expire them.
+type Sealer struct {
+	aead   cipher.AEAD
+	idKey  []byte        // Keys the bin pseudonyms and record IDs
+	bucket time.Duration // Length of the time buckets records are filed under
+}
+
+// NewSealer derives the sealing and pseudonym keys from key
+func NewSealer(key []byte) (*Sealer, error) {
+	if len(key) != SealKeySize {
+		return nil, ErrInvalidSealKey
+	}
+	derive := func(info string) ([]byte, error) {
+		derived := make([]byte, SealKeySize)
+		_, err := io.ReadFull(hkdf.New(sha256.New, key, nil, []byte(info)), derived)
+		return derived, err
+	}
+
+	sealKey, err := derive("anono bin store seal v1")
+	if err != nil {
+		return nil, err
+	}
+	idKey, err := derive("anono bin store pseudonyms v1")
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(sealKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Sealer{aead: aead, idKey: idKey, bucket: SealBucket}, nil
+}
+
+// SetBucket sets the length of the time buckets records are filed under.
+// Shorter buckets expire messages closer to their retention but tell the
+// backend more about when they were stored. It must be called before the
+// sealer wraps a store, with the length the stored records were filed
+// under; non-positive lengths are ignored.
+func (s *Sealer) SetBucket(bucket time.Duration) {
+	if bucket > 0 {
+		s.bucket = bucket
+	}
+}
+
+// Wrap returns a factory for bins whose messages are sealed into the
+// stores factory returns for their pseudonyms
+func (s *Sealer) Wrap(factory StoreFactory) StoreFactory {
+	return func(binID uint64) BinStore {
+		pseudonym := s.pseudonym(binID)
+		return &sealedBinStore{sealer: s, inner: factory(pseudonym), pseudonym: pseudonym}
+	}
+}
+
+// BinIDs maps the bins a backend lists, which are pseudonyms, back to the
+// bin IDs they stand for
+func (s *Sealer) BinIDs(pseudonyms []uint64) []uint64 {
+	binIDs := make([]uint64, len(pseudonyms))
+	for i, pseudonym := range pseudonyms {
+		binIDs[i] = s.binID(pseudonym)
+	}
+	return binIDs
+}
+
+// pseudonym permutes a bin ID with a four-round Feistel network keyed by
+// idKey, so it can be reversed by binID
+func (s *Sealer) pseudonym(binID uint64) uint64 {
+	left, right := uint32(binID>>32), uint32(binID)
+	for round := byte(0); round < 4; round++ {
+		left, right = right, left^s.roundFunction(round, right)
+	}
+	return uint64(left)<<32 | uint64(right)
+}
+
+// binID reverses pseudonym
+func (s *Sealer) binID(pseudonym uint64) uint64 {
+	left, right := uint32(pseudonym>>32), uint32(pseudonym)
+	for round := byte(4); round > 0; round-- {
+		left, right = right^s.roundFunction(round-1, left), left
+	}
+	return uint64(left)<<32 | uint64(right)
+}
+
+// roundFunction is the keyed function of one Feistel round
+func (s *Sealer) roundFunction(round byte, half uint32) uint32 {
+	mac := hmac.New(sha256.New, s.idKey)
+	var input [5]byte
+	input[0] = round
+	binary.BigEndian.PutUint32(input[1:], half)
+	mac.Write(input[:])
+	return binary.BigEndian.Uint32(mac.Sum(nil))
+}
+
+// recordID returns the ID a message is stored under. It is unique for the
+// message ID and exact timestamp but reveals neither.
+func (s *Sealer) recordID(msg *Message) string {
+	mac := hmac.New(sha256.New, s.idKey)
+	var nanos [8]byte
+	binary.BigEndian.PutUint64(nanos[:], timeKey(msg.Timestamp))
+	mac.Write(nanos[:])
+	mac.Write([]byte(msg.MessageID))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// sealedBinStore seals the messages of one bin into a backend store
+type sealedBinStore struct {
+	sealer    *Sealer
+	inner     BinStore
+	pseudonym uint64
+}
+
+// seal returns the record stored for msg: the sealed message under the
+// bin's pseudonym, a record ID and the start of its time bucket
+func (s *sealedBinStore) seal(msg *Message) (*Message, error) {
+	plaintext, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, s.sealer.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return &Message{
+		BinID:      s.pseudonym,
+		MessageID:  s.sealer.recordID(msg),
+		Ciphertext: s.sealer.aead.Seal(nonce, nonce, plaintext, s.additionalData()),
+		Timestamp:  msg.Timestamp.Truncate(s.sealer.bucket),
+	}, nil
+}
+
+// open decrypts a stored record
+func (s *sealedBinStore) open(record *Message) (*Message, error) {
+	size := s.sealer.aead.NonceSize()
+	if len(record.Ciphertext) < size {
+		return nil, errors.New("sealed record too short")
+	}
+	plaintext, err := s.sealer.aead.Open(nil, record.Ciphertext[:size], record.Ciphertext[size:], s.additionalData())
+	if err != nil {
+		return nil, err
+	}
+	var msg Message
+	if err := json.Unmarshal(plaintext, &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// additionalData binds records to their bin, so one moved to another bin
+// does not open
+func (s *sealedBinStore) additionalData() []byte {
+	var ad [8]byte
+	binary.BigEndian.PutUint64(ad[:], s.pseudonym)
+	return ad[:]
+}
+
+// AppendMessage seals the message and stores it
+func (s *sealedBinStore) AppendMessage(msg *Message) error {
+	record, err := s.seal(msg)
+	if err != nil {
+		return err
+	}
+	return s.inner.AppendMessage(record)
+}
+
+// AppendMessageDurable seals the message and stores it durably if the
+// backend supports that
+func (s *sealedBinStore) AppendMessageDurable(msg *Message) error {
+	record, err := s.seal(msg)
+	if err != nil {
+		return err
+	}
+	if ds, ok := s.inner.(DurableStore); ok {
+		return ds.AppendMessageDurable(record)
+	}
+	return s.inner.AppendMessage(record)
+}
+
+// RangeByTime reads the buckets overlapping the range and keeps the
+// messages whose exact timestamp falls within it. Records of a bucket are
+// stored in no particular order, so the result is sorted again.
+func (s *sealedBinStore) RangeByTime(from, to time.Time) ([]*Message, error) {
+	records, err := s.inner.RangeByTime(from.Truncate(s.sealer.bucket).Add(-time.Nanosecond), to)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*Message, 0, len(records))
+	for _, record := range records {
+		msg, err := s.open(record)
+		if err != nil {
+			return nil, err
+		}
+		if msg.Timestamp.After(from) && !msg.Timestamp.After(to) {
+			result = append(result, msg)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Timestamp.Before(result[j].Timestamp) })
+	return result, nil
+}
+
+// DeleteBefore removes the buckets that lie entirely before cutoff.
+// Messages of the bucket holding cutoff stay until a later call.
+func (s *sealedBinStore) DeleteBefore(cutoff time.Time) (int, error) {
+	return s.inner.DeleteBefore(cutoff.Truncate(s.sealer.bucket).Add(-time.Nanosecond))
+}
+
+// Stats opens every record, since sizes and exact timestamps are sealed
+func (s *sealedBinStore) Stats() StoreStats {
+	var stats StoreStats
+	messages, err := s.RangeByTime(time.Time{}, farFuture)
+	if err != nil {
+		return stats
+	}
+	for _, msg := range messages {
+		stats.MessageCount++
+		stats.Bytes += msg.Size()
+		if stats.Oldest.IsZero() || msg.Timestamp.Before(stats.Oldest) {
+			stats.Oldest = msg.Timestamp
+		}
+		if msg.Timestamp.After(stats.Newest) {
+			stats.Newest = msg.Timestamp
+		}
+	}
+	return stats
+}
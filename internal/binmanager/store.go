@@ -0,0 +1,116 @@
+package binmanager
+
+import (
+	"time"
+)
+
+// StoreStats summarizes the contents of a bin store. Bytes is the sum of
+// Message.Size, i.e. ciphertext plus envelope.
+type StoreStats struct {
+	MessageCount int
+	Bytes        int64
+	Oldest       time.Time
+	Newest       time.Time
+}
+
+// BinStore persists the retained messages of a single bin. It is the
+// extension point for message storage: a durable backend implements it and
+// is plugged into a manager with NewBinManagerWithStore, as LevelDBStore
+// is, without changes to the manager. Bins created with NewBin use a
+// MemoryStore and managers without a factory use a BucketStore.
+// Implementations are called with the owning bin's message lock held,
+// so they do not need to serialize access for a single bin themselves.
+type BinStore interface {
+	// AppendMessage stores a message; Timestamp is already set
+	AppendMessage(msg *Message) error
+
+	// RangeByTime returns messages with from < Timestamp <= to, oldest first
+	RangeByTime(from, to time.Time) ([]*Message, error)
+
+	// DeleteBefore removes messages with Timestamp <= cutoff and reports how many were removed
+	DeleteBefore(cutoff time.Time) (int, error)
+
+	// Stats returns summary information about the stored messages
+	Stats() StoreStats
+}
+
+// DurableStore is implemented by stores that can wait until an appended
+// message is on stable storage, for publishers that ask for durable
+// acknowledgements
+type DurableStore interface {
+	// AppendMessageDurable stores a message and syncs it before returning
+	AppendMessageDurable(msg *Message) error
+}
+
+// StoreFactory returns the store backing the bin with the given ID
+type StoreFactory func(binID uint64) BinStore
+
+// MemoryStore keeps messages in a flat slice. It backs Bin.Messages for bins
+// created with NewBin; bin managers use the bucketed BucketStore instead.
+type MemoryStore struct {
+	messages *[]*Message
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	messages := make([]*Message, 0, 100)
+	return &MemoryStore{messages: &messages}
+}
+
+// newSliceStore wraps an existing slice, used to back Bin.Messages
+func newSliceStore(messages *[]*Message) *MemoryStore {
+	return &MemoryStore{messages: messages}
+}
+
+// AppendMessage appends the message to the slice
+func (ms *MemoryStore) AppendMessage(msg *Message) error {
+	*ms.messages = append(*ms.messages, msg)
+	return nil
+}
+
+// RangeByTime scans the slice for messages inside the window
+func (ms *MemoryStore) RangeByTime(from, to time.Time) ([]*Message, error) {
+	result := make([]*Message, 0)
+	for _, msg := range *ms.messages {
+		if msg.Timestamp.After(from) && !msg.Timestamp.After(to) {
+			result = append(result, msg)
+		}
+	}
+	return result, nil
+}
+
+// DeleteBefore filters expired messages out of the slice in place
+func (ms *MemoryStore) DeleteBefore(cutoff time.Time) (int, error) {
+	messages := *ms.messages
+	kept := messages[:0]
+	for _, msg := range messages {
+		if msg.Timestamp.After(cutoff) {
+			kept = append(kept, msg)
+		}
+	}
+
+	// Clear the tail so dropped messages can be garbage collected
+	for i := len(kept); i < len(messages); i++ {
+		messages[i] = nil
+	}
+
+	removed := len(messages) - len(kept)
+	*ms.messages = kept
+	return removed, nil
+}
+
+// Stats returns the slice length, payload size and time range
+func (ms *MemoryStore) Stats() StoreStats {
+	var stats StoreStats
+	for _, msg := range *ms.messages {
+		stats.MessageCount++
+		stats.Bytes += msg.Size()
+		if stats.Oldest.IsZero() || msg.Timestamp.Before(stats.Oldest) {
+			stats.Oldest = msg.Timestamp
+		}
+		if msg.Timestamp.After(stats.Newest) {
+			stats.Newest = msg.Timestamp
+		}
+	}
+	return stats
+}
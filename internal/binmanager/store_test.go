@@ -0,0 +1,307 @@
+package binmanager
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testStoreBehavior runs the BinStore contract against a fresh store
+func testStoreBehavior(t *testing.T, store BinStore) {
+	now := time.Now()
+
+	messages := []*Message{
+		{BinID: 0x1000, MessageID: "msg1", Ciphertext: []byte("data1"), Timestamp: now.Add(-3 * time.Hour)},
+		{BinID: 0x1000, MessageID: "msg2", Ciphertext: []byte("data2"), Timestamp: now.Add(-2 * time.Hour)},
+		{BinID: 0x1000, MessageID: "msg3", Ciphertext: []byte("data3"), Timestamp: now.Add(-1 * time.Hour)},
+	}
+
+	for _, msg := range messages {
+		if err := store.AppendMessage(msg); err != nil {
+			t.Fatalf("Failed to append message: %v", err)
+		}
+	}
+
+	stats := store.Stats()
+	if stats.MessageCount != 3 {
+		t.Errorf("Stats should report 3 messages, got %d", stats.MessageCount)
+	}
+	var size int64
+	for _, msg := range messages {
+		size += msg.Size()
+	}
+	if stats.Bytes != size {
+		t.Errorf("Stats should report %d bytes, got %d", size, stats.Bytes)
+	}
+	if !stats.Oldest.Equal(messages[0].Timestamp) || !stats.Newest.Equal(messages[2].Timestamp) {
+		t.Errorf("Stats time range incorrect: %v - %v", stats.Oldest, stats.Newest)
+	}
+
+	// Range is exclusive of from and inclusive of to
+	result, err := store.RangeByTime(messages[0].Timestamp, messages[1].Timestamp)
+	if err != nil {
+		t.Fatalf("Failed to range messages: %v", err)
+	}
+	if len(result) != 1 || result[0].MessageID != "msg2" {
+		t.Errorf("RangeByTime returned incorrect messages: %v", result)
+	}
+
+	removed, err := store.DeleteBefore(now.Add(-90 * time.Minute))
+	if err != nil {
+		t.Fatalf("Failed to delete messages: %v", err)
+	}
+	if removed != 2 {
+		t.Errorf("DeleteBefore should remove 2 messages, removed %d", removed)
+	}
+
+	result, err = store.RangeByTime(time.Time{}, now)
+	if err != nil {
+		t.Fatalf("Failed to range messages: %v", err)
+	}
+	if len(result) != 1 || result[0].MessageID != "msg3" {
+		t.Errorf("Only msg3 should remain, got %v", result)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStoreBehavior(t, NewMemoryStore())
+}
+
+func TestLevelDBStore(t *testing.T) {
+	store, err := OpenLevelDBStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to open LevelDB store: %v", err)
+	}
+	defer store.Close()
+
+	testStoreBehavior(t, store.ForBin(0x1000))
+
+	// Bins are isolated from each other
+	other := store.ForBin(0x2000)
+	if err := other.AppendMessage(&Message{BinID: 0x2000, MessageID: "other", Timestamp: time.Now()}); err != nil {
+		t.Fatalf("Failed to append message: %v", err)
+	}
+	if count := store.ForBin(0x1000).Stats().MessageCount; count != 1 {
+		t.Errorf("Bin 0x1000 should still hold 1 message, got %d", count)
+	}
+
+	binIDs, err := store.Bins()
+	if err != nil {
+		t.Fatalf("Failed to list bins: %v", err)
+	}
+	if len(binIDs) != 2 || binIDs[0] != 0x1000 || binIDs[1] != 0x2000 {
+		t.Errorf("Bins returned incorrect IDs: %X", binIDs)
+	}
+}
+
+func TestBinManagerWithLevelDBStore(t *testing.T) {
+	path := t.TempDir()
+	store, err := OpenLevelDBStore(path)
+	if err != nil {
+		t.Fatalf("Failed to open LevelDB store: %v", err)
+	}
+
+	manager := NewBinManagerWithStore(0xFFFFFFFFFFFFF000, time.Hour, store.ForBin)
+	if err := manager.AddMessage(&Message{BinID: 0x1000, MessageID: "persisted", Ciphertext: []byte("data")}); err != nil {
+		t.Fatalf("Failed to add message: %v", err)
+	}
+	store.Close()
+
+	// Messages survive reopening the store
+	store, err = OpenLevelDBStore(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen LevelDB store: %v", err)
+	}
+	defer store.Close()
+
+	manager = NewBinManagerWithStore(0xFFFFFFFFFFFFF000, time.Hour, store.ForBin)
+	binIDs, _ := store.Bins()
+	manager.RestoreBins(binIDs)
+
+	messages := manager.GetRecentMessages(0x1000)
+	if len(messages) != 1 || messages[0].MessageID != "persisted" {
+		t.Errorf("Persisted message not restored: %v", messages)
+	}
+}
+
+func TestBoltStore(t *testing.T) {
+	store, err := OpenBoltStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to open Bolt store: %v", err)
+	}
+	defer store.Close()
+
+	testStoreBehavior(t, store.ForBin(0x1000))
+
+	// Bins are isolated from each other, and emptied ones are not listed
+	other := store.ForBin(0x2000)
+	if err := other.AppendMessage(&Message{BinID: 0x2000, MessageID: "other", Timestamp: time.Now()}); err != nil {
+		t.Fatalf("Failed to append message: %v", err)
+	}
+	if count := store.ForBin(0x1000).Stats().MessageCount; count != 1 {
+		t.Errorf("Bin 0x1000 should still hold 1 message, got %d", count)
+	}
+	if _, err := store.ForBin(0x3000).DeleteBefore(farFuture); err != nil {
+		t.Errorf("Deleting from a bin without messages failed: %v", err)
+	}
+
+	binIDs, err := store.Bins()
+	if err != nil {
+		t.Fatalf("Failed to list bins: %v", err)
+	}
+	if len(binIDs) != 2 || binIDs[0] != 0x1000 || binIDs[1] != 0x2000 {
+		t.Errorf("Bins returned incorrect IDs: %X", binIDs)
+	}
+}
+
+func TestBinManagerWithBoltStore(t *testing.T) {
+	path := t.TempDir()
+	store, err := OpenBoltStore(path)
+	if err != nil {
+		t.Fatalf("Failed to open Bolt store: %v", err)
+	}
+
+	manager := NewBinManagerWithStore(0xFFFFFFFFFFFFF000, time.Hour, store.ForBin)
+	if err := manager.AddMessage(&Message{BinID: 0x1000, MessageID: "persisted", Ciphertext: []byte("data")}); err != nil {
+		t.Fatalf("Failed to add message: %v", err)
+	}
+	store.Close()
+
+	// Messages survive reopening the store
+	store, err = OpenBoltStore(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen Bolt store: %v", err)
+	}
+	defer store.Close()
+
+	manager = NewBinManagerWithStore(0xFFFFFFFFFFFFF000, time.Hour, store.ForBin)
+	binIDs, _ := store.Bins()
+	manager.RestoreBins(binIDs)
+
+	messages := manager.GetRecentMessages(0x1000)
+	if len(messages) != 1 || messages[0].MessageID != "persisted" {
+		t.Errorf("Persisted message not restored: %v", messages)
+	}
+}
+
+func TestSealedStore(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, SealKeySize)
+	sealer, err := NewSealer(key)
+	if err != nil {
+		t.Fatalf("NewSealer failed: %v", err)
+	}
+	sealer.SetBucket(time.Minute)
+	store, err := OpenBoltStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to open Bolt store: %v", err)
+	}
+	defer store.Close()
+
+	testStoreBehavior(t, sealer.Wrap(store.ForBin)(0x1000))
+
+	// The backend only holds pseudonyms, bucket starts and sealed messages
+	pseudonyms, err := store.Bins()
+	if err != nil || len(pseudonyms) != 1 || pseudonyms[0] == 0x1000 {
+		t.Fatalf("Backend should list one pseudonymous bin, got %X, %v", pseudonyms, err)
+	}
+	records, err := store.ForBin(pseudonyms[0]).RangeByTime(time.Time{}, farFuture)
+	if err != nil || len(records) != 1 {
+		t.Fatalf("Backend should hold 1 record, got %d, %v", len(records), err)
+	}
+	if bytes.Contains(records[0].Ciphertext, []byte("data3")) || records[0].MessageID == "msg3" ||
+		records[0].Timestamp.Truncate(time.Minute) != records[0].Timestamp {
+		t.Errorf("Record leaks the message: %+v", records[0])
+	}
+	if binIDs := sealer.BinIDs(pseudonyms); binIDs[0] != 0x1000 {
+		t.Errorf("Pseudonym should map back to bin 1000, got %X", binIDs[0])
+	}
+	for _, binID := range []uint64{0, 1, 0xFFFFFFFFFFFFF000, 0x8000000000000000} {
+		if got := sealer.binID(sealer.pseudonym(binID)); got != binID {
+			t.Errorf("Pseudonym of %X reverses to %X", binID, got)
+		}
+	}
+
+	// Another key can neither find nor open the bin
+	other, _ := NewSealer(bytes.Repeat([]byte{8}, SealKeySize))
+	if _, err := (&sealedBinStore{sealer: other, inner: store.ForBin(pseudonyms[0]), pseudonym: pseudonyms[0]}).RangeByTime(time.Time{}, farFuture); err == nil {
+		t.Error("Records should not open under another key")
+	}
+	// By default the backend learns only the hour a message was stored in
+	hourly, _ := NewSealer(key)
+	backend := NewMemoryStore()
+	hourlyStore := hourly.Wrap(func(uint64) BinStore { return backend })(0x2000)
+	if err := hourlyStore.AppendMessage(&Message{BinID: 0x2000, MessageID: "msg4", Ciphertext: []byte("data4"), Timestamp: time.Now()}); err != nil {
+		t.Fatalf("Failed to append message: %v", err)
+	}
+	records, err = backend.RangeByTime(time.Time{}, farFuture)
+	if err != nil || len(records) != 1 || records[0].Timestamp.Truncate(SealBucket) != records[0].Timestamp {
+		t.Errorf("Record should be filed under its hour, got %v, %v", records, err)
+	}
+
+	if _, err := NewSealer(key[:16]); !errors.Is(err, ErrInvalidSealKey) {
+		t.Errorf("Expected ErrInvalidSealKey, got %v", err)
+	}
+}
+
+func TestSnapshotImport(t *testing.T) {
+	manager := NewBinManager(0xFFFFFFFFFFFFF000, time.Hour)
+	manager.AddMessage(&Message{BinID: 0x1000, MessageID: "msg1", Ciphertext: []byte("data1")})
+	manager.AddMessage(&Message{BinID: 0x2000, MessageID: "msg2", Ciphertext: []byte("data2")})
+	manager.AddMessage(&Message{BinID: 0x2000, MessageID: "msg3", Ciphertext: []byte("data3")})
+
+	var buf bytes.Buffer
+	count, err := manager.WriteSnapshot(&buf)
+	if err != nil {
+		t.Fatalf("Failed to write snapshot: %v", err)
+	}
+	if count != 3 {
+		t.Errorf("Snapshot should contain 3 messages, got %d", count)
+	}
+
+	store, err := OpenLevelDBStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to open LevelDB store: %v", err)
+	}
+	defer store.Close()
+
+	binIDs, imported, err := ImportSnapshot(&buf, store.ForBin)
+	if err != nil {
+		t.Fatalf("Failed to import snapshot: %v", err)
+	}
+	if imported != 3 || len(binIDs) != 2 {
+		t.Errorf("Import should cover 3 messages in 2 bins, got %d in %d", imported, len(binIDs))
+	}
+
+	if count := store.ForBin(0x2000).Stats().MessageCount; count != 2 {
+		t.Errorf("Bin 0x2000 should hold 2 imported messages, got %d", count)
+	}
+}
+
+func TestSnapshotImportInvalid(t *testing.T) {
+	snapshot := strings.NewReader(`{"bin_id":4096,"message_id":"msg1","ciphertext":"ZGF0YQ=="}` + "\nnot json\n")
+
+	binIDs, imported, err := ImportSnapshot(snapshot, func(uint64) BinStore { return NewMemoryStore() })
+	if !errors.Is(err, ErrInvalidSnapshot) {
+		t.Fatalf("Expected ErrInvalidSnapshot, got %v", err)
+	}
+	if imported != 1 || len(binIDs) != 1 {
+		t.Errorf("Messages before the bad line should be imported, got %d in %d bins", imported, len(binIDs))
+	}
+}
+
+func TestMigrateStore(t *testing.T) {
+	src := NewMemoryStore()
+	src.AppendMessage(&Message{BinID: 0x1000, MessageID: "msg1", Timestamp: time.Now()})
+	src.AppendMessage(&Message{BinID: 0x1000, MessageID: "msg2", Timestamp: time.Now()})
+
+	dst := NewMemoryStore()
+	count, err := MigrateStore(src, dst)
+	if err != nil {
+		t.Fatalf("Failed to migrate store: %v", err)
+	}
+	if count != 2 || dst.Stats().MessageCount != 2 {
+		t.Errorf("Migration should copy 2 messages, copied %d", count)
+	}
+}
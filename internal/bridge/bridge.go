@@ -0,0 +1,187 @@
+// Package bridge maps channels to the rooms of other chat systems for the
+// bridge commands. A room either carries a channel's ciphertext opaquely,
+// for communities whose members keep decrypting with their own clients, or
+// exchanges plaintext that the bridge encrypts and decrypts with the
+// channel key it holds.
+package bridge
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+
+	cryptopkg "github.com/yourusername/secure-messaging-poc/pkg/crypto"
+	"github.com/yourusername/secure-messaging-poc/pkg/protocol"
+)
+
+// How a room carries its channel's messages
+const (
+	// ModeOpaque passes ciphertext through untouched. An opaque room
+	// receives everything published to its channel's bin, as any
+	// subscriber does; members tell their channel's messages apart by
+	// decrypting.
+	ModeOpaque = "opaque"
+	// ModeDecrypt decrypts messages with the channel key, delivering only
+	// those of the channel, and encrypts what the room sends
+	ModeDecrypt = "decrypt"
+)
+
+// KeySize is the size of a channel key
+const KeySize = 32
+
+var (
+	// ErrUnknownMode is returned for a route with a mode other than
+	// ModeOpaque or ModeDecrypt
+	ErrUnknownMode = errors.New("bridge: unknown mode")
+	// ErrInvalidKey is returned for a decrypting route without a
+	// KeySize-byte key
+	ErrInvalidKey = errors.New("bridge: channel key must be 32 bytes")
+	// ErrDuplicateRoom is returned when a room is mapped more than once
+	ErrDuplicateRoom = errors.New("bridge: room mapped twice")
+	// ErrCiphertext is returned for a payload that does not decrypt
+	ErrCiphertext = errors.New("bridge: payload does not decrypt")
+)
+
+// Route maps a channel to a room of the bridged system
+type Route struct {
+	Channel uint64
+	Room    string
+	Mode    string // ModeOpaque or ModeDecrypt
+	Key     []byte // Channel key, for ModeDecrypt
+}
+
+// Delivery is a message bound for a room. Plaintext is nil for an opaque
+// room.
+type Delivery struct {
+	Route     Route
+	Message   *protocol.Message
+	Plaintext []byte
+}
+
+// Router finds the rooms of incoming messages and the channels of outgoing
+// ones
+type Router struct {
+	routes []Route
+	byRoom map[string]Route
+}
+
+// NewRouter validates routes and creates a router
+func NewRouter(routes []Route) (*Router, error) {
+	r := &Router{byRoom: make(map[string]Route)}
+	for _, route := range routes {
+		switch route.Mode {
+		case ModeOpaque:
+		case ModeDecrypt:
+			if len(route.Key) != KeySize {
+				return nil, fmt.Errorf("%w: room %s", ErrInvalidKey, route.Room)
+			}
+		default:
+			return nil, fmt.Errorf("%w %q: room %s", ErrUnknownMode, route.Mode, route.Room)
+		}
+		if _, exists := r.byRoom[route.Room]; exists {
+			return nil, fmt.Errorf("%w: %s", ErrDuplicateRoom, route.Room)
+		}
+		r.routes = append(r.routes, route)
+		r.byRoom[route.Room] = route
+	}
+	return r, nil
+}
+
+// Channels returns the channels to subscribe to, each once
+func (r *Router) Channels() []uint64 {
+	seen := make(map[uint64]bool)
+	channels := make([]uint64, 0, len(r.routes))
+	for _, route := range r.routes {
+		if !seen[route.Channel] {
+			seen[route.Channel] = true
+			channels = append(channels, route.Channel)
+		}
+	}
+	return channels
+}
+
+// Room returns the route of a room, if it is bridged
+func (r *Router) Room(room string) (Route, bool) {
+	route, ok := r.byRoom[room]
+	return route, ok
+}
+
+// Deliveries returns the rooms a message received under mask goes to.
+// Decrypting rooms only get messages that decrypt under their key.
+func (r *Router) Deliveries(msg *protocol.Message, mask uint64) []Delivery {
+	deliveries := make([]Delivery, 0)
+	for _, route := range r.routes {
+		if route.Channel&mask != msg.BinID {
+			continue
+		}
+		delivery := Delivery{Route: route, Message: msg}
+		if route.Mode == ModeDecrypt {
+			plaintext, err := Open(route.Key, msg.Ciphertext)
+			if err != nil {
+				continue
+			}
+			delivery.Plaintext = plaintext
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries
+}
+
+// Seal encrypts a payload with a channel key as AES-256-GCM, prefixed with
+// its nonce
+func Seal(key, plaintext []byte) ([]byte, error) {
+	ciphertext, nonce, err := cryptopkg.AESGCMEncrypt(plaintext, key)
+	if err != nil {
+		return nil, err
+	}
+	return append(nonce, ciphertext...), nil
+}
+
+// Open decrypts a payload written by Seal
+func Open(key, payload []byte) ([]byte, error) {
+	const nonceSize = 12
+	if len(payload) < nonceSize {
+		return nil, ErrCiphertext
+	}
+	plaintext, err := cryptopkg.AESGCMDecrypt(payload[nonceSize:], key, payload[:nonceSize])
+	if err != nil {
+		return nil, ErrCiphertext
+	}
+	return plaintext, nil
+}
+
+// ParseChannel parses a channel ID in decimal or, with a 0x prefix, hex
+func ParseChannel(s string) (uint64, error) {
+	return strconv.ParseUint(s, 0, 64)
+}
+
+// RouteConfig is a route as written in a bridge's configuration file
+type RouteConfig struct {
+	Channel string `mapstructure:"channel"` // Decimal or 0x-prefixed hex
+	Room    string `mapstructure:"room"`
+	Mode    string `mapstructure:"mode"`
+	Key     string `mapstructure:"key"` // Base64 channel key, for ModeDecrypt
+}
+
+// ParseRoutes converts configured routes, defaulting to ModeOpaque
+func ParseRoutes(configs []RouteConfig) ([]Route, error) {
+	routes := make([]Route, 0, len(configs))
+	for _, config := range configs {
+		channel, err := ParseChannel(config.Channel)
+		if err != nil {
+			return nil, fmt.Errorf("room %s: invalid channel %q: %v", config.Room, config.Channel, err)
+		}
+		route := Route{Channel: channel, Room: config.Room, Mode: config.Mode}
+		if route.Mode == "" {
+			route.Mode = ModeOpaque
+		}
+		if config.Key != "" {
+			if route.Key, err = base64.StdEncoding.DecodeString(config.Key); err != nil {
+				return nil, fmt.Errorf("room %s: invalid key: %v", config.Room, err)
+			}
+		}
+		routes = append(routes, route)
+	}
+	return routes, nil
+}
@@ -0,0 +1,105 @@
+package bridge
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/yourusername/secure-messaging-poc/pkg/protocol"
+)
+
+func TestRouterDeliveries(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, KeySize)
+	otherKey := bytes.Repeat([]byte{2}, KeySize)
+	router, err := NewRouter([]Route{
+		{Channel: 0x1001, Room: "!opaque", Mode: ModeOpaque},
+		{Channel: 0x1001, Room: "!plain", Mode: ModeDecrypt, Key: key},
+		{Channel: 0x2001, Room: "!other", Mode: ModeDecrypt, Key: otherKey},
+	})
+	if err != nil {
+		t.Fatalf("NewRouter failed: %v", err)
+	}
+	if channels := router.Channels(); len(channels) != 2 {
+		t.Errorf("Expected two channels, got %v", channels)
+	}
+
+	// Under a mask of 0xFF both channels share bin 1
+	sealed, err := Seal(key, []byte("hello"))
+	if err != nil {
+		t.Fatalf("Seal failed: %v", err)
+	}
+	deliveries := router.Deliveries(&protocol.Message{BinID: 0x01, Ciphertext: sealed}, 0xFF)
+	if len(deliveries) != 2 {
+		t.Fatalf("Expected the opaque room and the keyed room, got %+v", deliveries)
+	}
+	for _, delivery := range deliveries {
+		switch delivery.Route.Room {
+		case "!opaque":
+			if delivery.Plaintext != nil {
+				t.Error("An opaque room should not get plaintext")
+			}
+		case "!plain":
+			if !bytes.Equal(delivery.Plaintext, []byte("hello")) {
+				t.Errorf("Unexpected plaintext %q", delivery.Plaintext)
+			}
+		default:
+			t.Errorf("Message delivered to %s under another key", delivery.Route.Room)
+		}
+	}
+
+	if deliveries := router.Deliveries(&protocol.Message{BinID: 0x02, Ciphertext: sealed}, 0xFF); len(deliveries) != 0 {
+		t.Errorf("Expected no rooms for another bin, got %+v", deliveries)
+	}
+	if route, ok := router.Room("!plain"); !ok || route.Channel != 0x1001 {
+		t.Errorf("Unexpected route %+v, %v", route, ok)
+	}
+}
+
+func TestNewRouterValidates(t *testing.T) {
+	if _, err := NewRouter([]Route{{Channel: 1, Room: "!a", Mode: "plain"}}); !errors.Is(err, ErrUnknownMode) {
+		t.Errorf("Expected ErrUnknownMode, got %v", err)
+	}
+	if _, err := NewRouter([]Route{{Channel: 1, Room: "!a", Mode: ModeDecrypt, Key: []byte("short")}}); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("Expected ErrInvalidKey, got %v", err)
+	}
+	if _, err := NewRouter([]Route{{Channel: 1, Room: "!a", Mode: ModeOpaque}, {Channel: 2, Room: "!a", Mode: ModeOpaque}}); !errors.Is(err, ErrDuplicateRoom) {
+		t.Errorf("Expected ErrDuplicateRoom, got %v", err)
+	}
+}
+
+func TestRecentForgetsOldest(t *testing.T) {
+	recent := NewRecent(2)
+	recent.Add("a")
+	recent.Add("b")
+	recent.Add("b")
+	recent.Add("c")
+	if recent.Contains("a") || !recent.Contains("b") || !recent.Contains("c") {
+		t.Error("Expected only the two newest IDs to be remembered")
+	}
+}
+
+func TestParseRoutes(t *testing.T) {
+	routes, err := ParseRoutes([]RouteConfig{
+		{Channel: "0x10", Room: "!a"},
+		{Channel: "42", Room: "!b", Mode: ModeDecrypt, Key: "AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE="},
+	})
+	if err != nil {
+		t.Fatalf("ParseRoutes failed: %v", err)
+	}
+	if routes[0].Channel != 0x10 || routes[0].Mode != ModeOpaque {
+		t.Errorf("Expected an opaque route for channel 0x10, got %+v", routes[0])
+	}
+	if routes[1].Channel != 42 || len(routes[1].Key) != KeySize {
+		t.Errorf("Unexpected route %+v", routes[1])
+	}
+	if _, err := ParseRoutes([]RouteConfig{{Channel: "channel", Room: "!a"}}); err == nil {
+		t.Error("Expected an invalid channel to be refused")
+	}
+
+	if err := (Config{}).Validate(); err == nil {
+		t.Error("Expected a configuration without rooms to be refused")
+	}
+	if err := (Config{Rooms: []RouteConfig{{Channel: "1"}}, Store: StoreConfig{Path: "cache.db"}}).Validate(); err == nil {
+		t.Error("Expected a store without a key to be refused")
+	}
+}
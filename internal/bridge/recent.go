@@ -0,0 +1,50 @@
+package bridge
+
+import "sync"
+
+// Recent remembers the last IDs added to it, such as the messages a bridge
+// published, so they are not bridged back when the subscription delivers
+// them, or the transactions a homeserver retries
+type Recent struct {
+	size  int
+	order []string
+	next  int
+	set   map[string]bool
+	mu    sync.Mutex
+}
+
+// NewRecent creates a set remembering up to size IDs
+func NewRecent(size int) *Recent {
+	if size < 1 {
+		size = 1
+	}
+	return &Recent{
+		size:  size,
+		order: make([]string, 0, size),
+		set:   make(map[string]bool, size),
+	}
+}
+
+// Add remembers id, forgetting the oldest ID when the set is full
+func (r *Recent) Add(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.set[id] {
+		return
+	}
+	if len(r.order) < r.size {
+		r.order = append(r.order, id)
+	} else {
+		delete(r.set, r.order[r.next])
+		r.order[r.next] = id
+		r.next = (r.next + 1) % r.size
+	}
+	r.set[id] = true
+}
+
+// Contains reports whether id is remembered
+func (r *Recent) Contains(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.set[id]
+}
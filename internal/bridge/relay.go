@@ -0,0 +1,197 @@
+package bridge
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spf13/viper"
+
+	"github.com/yourusername/secure-messaging-poc/pkg/client"
+	"github.com/yourusername/secure-messaging-poc/pkg/client/sqlitestore"
+)
+
+// recentSize is how many published message IDs are remembered to drop
+// their echoes
+const recentSize = 4096
+
+// Transport is the chat system on the other side of a bridge
+type Transport interface {
+	// Send delivers a message to a room. Transports retry as their system
+	// allows; the relay only logs a failure.
+	Send(delivery Delivery) error
+}
+
+// Config is the part of a bridge's configuration file shared by every
+// bridge: the messaging server, the bridge's client certificate, the
+// optional message store and the rooms
+type Config struct {
+	Server string        `mapstructure:"server"`
+	Cert   string        `mapstructure:"cert"`
+	Key    string        `mapstructure:"key"`
+	CA     string        `mapstructure:"ca"`
+	Store  StoreConfig   `mapstructure:"store"`
+	Rooms  []RouteConfig `mapstructure:"rooms"`
+}
+
+// StoreConfig enables the encrypted message store, so a restarted bridge
+// neither misses nor repeats messages
+type StoreConfig struct {
+	Path string `mapstructure:"path"`
+	Key  string `mapstructure:"key"` // Base64 keystore backup key the store key derives from
+}
+
+// SetDefaults sets the defaults of Config on v
+func SetDefaults(v *viper.Viper) {
+	v.SetDefault("server", "https://localhost:8443")
+	v.SetDefault("cert", "bridge.crt")
+	v.SetDefault("key", "bridge.key")
+	v.SetDefault("ca", "certs/ca.crt")
+}
+
+// Validate checks the settings that need no files to be read
+func (c Config) Validate() error {
+	switch {
+	case len(c.Rooms) == 0:
+		return errors.New("no rooms configured")
+	case c.Store.Path != "" && c.Store.Key == "":
+		return errors.New("store.key is required with store.path")
+	}
+	return nil
+}
+
+// Relay subscribes to the channels of the configured rooms and relays
+// messages between them and a Transport
+type Relay struct {
+	router    *Router
+	transport Transport
+	client    *client.Client
+	store     *sqlitestore.Store
+	published *Recent
+}
+
+// NewRelay validates cfg and creates a relay for transport. Call Run to
+// connect.
+func NewRelay(cfg Config, transport Transport) (*Relay, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	routes, err := ParseRoutes(cfg.Rooms)
+	if err != nil {
+		return nil, err
+	}
+	router, err := NewRouter(routes)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := LoadTLSConfig(cfg.Cert, cfg.Key, cfg.CA)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Relay{router: router, transport: transport, published: NewRecent(recentSize)}
+	clientConfig := client.Config{
+		ServerURL: cfg.Server,
+		TLSConfig: tlsConfig,
+		Channels:  router.Channels(),
+		OnMessage: r.fromServer,
+		OnError: func(frame client.ErrorFrame) {
+			log.Printf("Server error %d: %s", frame.Code, frame.Message)
+		},
+		OnDisconnect: func(err error, retryIn time.Duration) {
+			log.Printf("Disconnected (%v), reconnecting in %v", err, retryIn)
+		},
+		OnStoreError: func(msg *client.Message, err error) {
+			log.Printf("Failed to store message %s: %v", msg.MessageID, err)
+		},
+	}
+	if cfg.Store.Path != "" {
+		if r.store, err = openStore(cfg.Store.Path, cfg.Store.Key); err != nil {
+			return nil, err
+		}
+		clientConfig.Store = r.store
+	}
+	if r.client, err = client.New(clientConfig); err != nil {
+		r.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// Run keeps the subscription alive until ctx is done
+func (r *Relay) Run(ctx context.Context) error {
+	return r.client.Run(ctx)
+}
+
+// Close closes the message store, if there is one
+func (r *Relay) Close() error {
+	if r.store == nil {
+		return nil
+	}
+	return r.store.Close()
+}
+
+// Route returns the route of a room, if it is bridged
+func (r *Relay) Route(room string) (Route, bool) {
+	return r.router.Room(room)
+}
+
+// FromRoom publishes a message sent in a bridged room. Opaque rooms publish
+// ciphertext, ignoring messages without any; decrypting rooms encrypt body.
+// The message ID derives from eventID, the message's ID in the bridged
+// system, so publishing an event again is harmless. It returns
+// client.ErrNotConnected while the subscription is down.
+func (r *Relay) FromRoom(room, eventID, body string, ciphertext []byte) error {
+	route, ok := r.router.Room(room)
+	if !ok {
+		return nil
+	}
+
+	payload := ciphertext
+	if route.Mode == ModeDecrypt {
+		if body == "" {
+			return nil
+		}
+		var err error
+		if payload, err = Seal(route.Key, []byte(body)); err != nil {
+			return err
+		}
+	}
+	if len(payload) == 0 {
+		return nil
+	}
+
+	messageID := uuid.NewSHA1(uuid.NameSpaceURL, []byte(room+"\x00"+eventID)).String()
+	r.published.Add(messageID)
+	return r.client.Publish(route.Channel, &client.Message{MessageID: messageID, Ciphertext: payload})
+}
+
+// fromServer sends a received message to the rooms of its bin, skipping
+// the relay's own messages as the subscription delivers them back
+func (r *Relay) fromServer(msg *client.Message) {
+	if r.published.Contains(msg.MessageID) {
+		return
+	}
+	for _, delivery := range r.router.Deliveries(msg, r.client.Mask()) {
+		if err := r.transport.Send(delivery); err != nil {
+			log.Printf("Failed to send message %s to %s: %v", msg.MessageID, delivery.Route.Room, err)
+		}
+	}
+}
+
+// openStore opens the encrypted message store with a key derived from the
+// base64 backup key
+func openStore(path, backupKey string) (*sqlitestore.Store, error) {
+	raw, err := base64.StdEncoding.DecodeString(backupKey)
+	if err != nil {
+		return nil, err
+	}
+	key, err := sqlitestore.DeriveKey(raw)
+	if err != nil {
+		return nil, err
+	}
+	return sqlitestore.Open(path, key)
+}
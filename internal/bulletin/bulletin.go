@@ -0,0 +1,258 @@
+// Package bulletin keeps the public, append-only history of bulletin
+// channels, for announcements and key-rotation broadcasts that anyone may
+// read. Each channel's history is a transparency log: every entry names the
+// hash of the one before it, and the entries are the leaves of a Merkle
+// tree whose signed head lets readers check that an entry is included and
+// that the server shows everyone the same history.
+package bulletin
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrUnknownChannel is returned for a channel that is not a bulletin
+	ErrUnknownChannel = errors.New("bulletin: not a bulletin channel")
+	// ErrIndexOutOfRange is returned for an entry or tree size the log does
+	// not have
+	ErrIndexOutOfRange = errors.New("bulletin: index out of range")
+	// ErrBrokenChain is returned when a stored log does not chain up
+	ErrBrokenChain = errors.New("bulletin: log entries do not chain")
+)
+
+// Entry is one message in a bulletin log. Prev is the hash of the entry
+// before it, empty for the first.
+type Entry struct {
+	Index      uint64    `json:"index"`
+	MessageID  string    `json:"message_id"`
+	Ciphertext []byte    `json:"ciphertext"`
+	Timestamp  time.Time `json:"timestamp"`
+	Prev       []byte    `json:"prev,omitempty"`
+}
+
+// encode returns the bytes an entry's hash covers
+func (e Entry) encode() []byte {
+	var buf bytes.Buffer
+	var scratch [8]byte
+	binary.BigEndian.PutUint64(scratch[:], e.Index)
+	buf.Write(scratch[:])
+	binary.BigEndian.PutUint64(scratch[:], uint64(e.Timestamp.UnixNano()))
+	buf.Write(scratch[:])
+	buf.WriteByte(byte(len(e.Prev)))
+	buf.Write(e.Prev)
+	binary.BigEndian.PutUint32(scratch[:4], uint32(len(e.MessageID)))
+	buf.Write(scratch[:4])
+	buf.WriteString(e.MessageID)
+	buf.Write(e.Ciphertext)
+	return buf.Bytes()
+}
+
+// Hash returns the entry's Merkle leaf hash, which the next entry names as
+// Prev
+func (e Entry) Hash() []byte {
+	return leafHash(e.encode())
+}
+
+// Head describes a log at a size: the Merkle root over its first Size
+// entries and the hash of the last of them
+type Head struct {
+	Channel   string `json:"channel"`
+	Size      uint64 `json:"size"`
+	Root      []byte `json:"root"`
+	ChainHead []byte `json:"chain_head,omitempty"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// Log is the append-only history of one bulletin channel, kept in memory
+// and in a file of one JSON entry per line
+type Log struct {
+	channel uint64
+	path    string
+	entries []Entry
+	leaves  [][]byte
+	mu      sync.RWMutex
+}
+
+// openLog loads the log at path, checking that its entries chain up, or
+// starts an empty one
+func openLog(channel uint64, path string) (*Log, error) {
+	l := &Log{channel: channel, path: path}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("%s: entry %d: %v", path, len(l.entries), err)
+		}
+		if entry.Index != uint64(len(l.entries)) || !bytes.Equal(entry.Prev, l.chainHead()) {
+			return nil, fmt.Errorf("%s: entry %d: %w", path, len(l.entries), ErrBrokenChain)
+		}
+		l.entries = append(l.entries, entry)
+		l.leaves = append(l.leaves, entry.Hash())
+	}
+	return l, scanner.Err()
+}
+
+// chainHead returns the hash of the last entry, or nil for an empty log
+func (l *Log) chainHead() []byte {
+	if len(l.leaves) == 0 {
+		return nil
+	}
+	return l.leaves[len(l.leaves)-1]
+}
+
+// Append adds a message to the log, writing it out before it is served
+func (l *Log) Append(messageID string, ciphertext []byte, timestamp time.Time) (Entry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry := Entry{
+		Index:      uint64(len(l.entries)),
+		MessageID:  messageID,
+		Ciphertext: ciphertext,
+		Timestamp:  timestamp.UTC(),
+		Prev:       l.chainHead(),
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return Entry{}, err
+	}
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return Entry{}, err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return Entry{}, err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return Entry{}, err
+	}
+	if err := f.Close(); err != nil {
+		return Entry{}, err
+	}
+
+	l.entries = append(l.entries, entry)
+	l.leaves = append(l.leaves, entry.Hash())
+	return entry, nil
+}
+
+// Entries returns up to limit entries from index since
+func (l *Log) Entries(since uint64, limit int) []Entry {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if since >= uint64(len(l.entries)) {
+		return []Entry{}
+	}
+	end := uint64(len(l.entries))
+	if limit > 0 && since+uint64(limit) < end {
+		end = since + uint64(limit)
+	}
+	return append([]Entry(nil), l.entries[since:end]...)
+}
+
+// Channel returns the channel the log belongs to
+func (l *Log) Channel() uint64 {
+	return l.channel
+}
+
+// Size returns the number of entries
+func (l *Log) Size() uint64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return uint64(len(l.entries))
+}
+
+// Head returns the head of the log at size, which may be any size up to the
+// current one
+func (l *Log) Head(size uint64) (Head, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if size > uint64(len(l.leaves)) {
+		return Head{}, ErrIndexOutOfRange
+	}
+	head := Head{
+		Channel: fmt.Sprintf("0x%X", l.channel),
+		Size:    size,
+		Root:    merkleRoot(l.leaves[:size]),
+	}
+	if size > 0 {
+		head.ChainHead = l.leaves[size-1]
+		head.Timestamp = l.entries[size-1].Timestamp.Unix()
+	}
+	return head, nil
+}
+
+// InclusionProof returns the audit path of entry index in the tree of the
+// first size entries
+func (l *Log) InclusionProof(index, size uint64) ([][]byte, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if size > uint64(len(l.leaves)) || index >= size {
+		return nil, ErrIndexOutOfRange
+	}
+	return inclusionPath(index, l.leaves[:size]), nil
+}
+
+// Board holds the logs of every bulletin channel
+type Board struct {
+	logs map[uint64]*Log
+}
+
+// Open loads or creates the logs of channels in dir
+func Open(dir string, channels []uint64) (*Board, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	b := &Board{logs: make(map[uint64]*Log, len(channels))}
+	for _, channel := range channels {
+		l, err := openLog(channel, filepath.Join(dir, fmt.Sprintf("%016x.jsonl", channel)))
+		if err != nil {
+			return nil, err
+		}
+		b.logs[channel] = l
+	}
+	return b, nil
+}
+
+// Log returns the log of a channel
+func (b *Board) Log(channel uint64) (*Log, error) {
+	l, ok := b.logs[channel]
+	if !ok {
+		return nil, ErrUnknownChannel
+	}
+	return l, nil
+}
+
+// Channels returns the bulletin channels in ascending order
+func (b *Board) Channels() []uint64 {
+	channels := make([]uint64, 0, len(b.logs))
+	for channel := range b.logs {
+		channels = append(channels, channel)
+	}
+	sort.Slice(channels, func(i, j int) bool { return channels[i] < channels[j] })
+	return channels
+}
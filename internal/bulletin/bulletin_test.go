@@ -0,0 +1,132 @@
+package bulletin
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInclusionProofs(t *testing.T) {
+	for size := 1; size <= 9; size++ {
+		leaves := make([][]byte, size)
+		for i := range leaves {
+			leaves[i] = leafHash([]byte{byte(i)})
+		}
+		root := merkleRoot(leaves)
+		for index := 0; index < size; index++ {
+			path := inclusionPath(uint64(index), leaves)
+			if !VerifyInclusion(leaves[index], uint64(index), uint64(size), path, root) {
+				t.Errorf("Proof of leaf %d in a tree of %d did not verify", index, size)
+			}
+			if VerifyInclusion(leafHash([]byte("other")), uint64(index), uint64(size), path, root) {
+				t.Errorf("Proof of leaf %d in a tree of %d verified another leaf", index, size)
+			}
+			if size > 1 && VerifyInclusion(leaves[index], uint64((index+1)%size), uint64(size), path, root) {
+				t.Errorf("Proof of leaf %d in a tree of %d verified at another index", index, size)
+			}
+		}
+	}
+}
+
+func TestLogAppendAndReopen(t *testing.T) {
+	dir := t.TempDir()
+	board, err := Open(dir, []uint64{0x1001})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if _, err := board.Log(0x2002); !errors.Is(err, ErrUnknownChannel) {
+		t.Errorf("Expected ErrUnknownChannel, got %v", err)
+	}
+	log, err := board.Log(0x1001)
+	if err != nil {
+		t.Fatalf("Log failed: %v", err)
+	}
+
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		entry, err := log.Append(fmt.Sprintf("msg-%d", i), []byte{byte(i)}, now)
+		if err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+		if entry.Index != uint64(i) {
+			t.Errorf("Expected index %d, got %d", i, entry.Index)
+		}
+	}
+	head, err := log.Head(log.Size())
+	if err != nil {
+		t.Fatalf("Head failed: %v", err)
+	}
+	entries := log.Entries(0, 0)
+	if len(entries) != 5 {
+		t.Fatalf("Expected 5 entries, got %d", len(entries))
+	}
+	for i := 1; i < len(entries); i++ {
+		if !bytes.Equal(entries[i].Prev, entries[i-1].Hash()) {
+			t.Errorf("Entry %d does not chain to entry %d", i, i-1)
+		}
+	}
+	if !bytes.Equal(head.ChainHead, entries[4].Hash()) {
+		t.Error("Head does not name the last entry")
+	}
+	if page := log.Entries(3, 10); len(page) != 2 || page[0].Index != 3 {
+		t.Errorf("Unexpected page %+v", page)
+	}
+
+	// An old head stays provable after the log grows
+	old, _ := log.Head(3)
+	path, err := log.InclusionProof(1, 3)
+	if err != nil {
+		t.Fatalf("InclusionProof failed: %v", err)
+	}
+	if !VerifyInclusion(entries[1].Hash(), 1, 3, path, old.Root) {
+		t.Error("Proof against an old head did not verify")
+	}
+	if _, err := log.InclusionProof(5, 5); !errors.Is(err, ErrIndexOutOfRange) {
+		t.Errorf("Expected ErrIndexOutOfRange, got %v", err)
+	}
+
+	reopened, err := Open(dir, []uint64{0x1001})
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	log, _ = reopened.Log(0x1001)
+	again, err := log.Head(log.Size())
+	if err != nil {
+		t.Fatalf("Head failed: %v", err)
+	}
+	if again.Size != 5 || !bytes.Equal(again.Root, head.Root) {
+		t.Errorf("Reopened log has head %+v, expected %+v", again, head)
+	}
+}
+
+func TestLogDetectsTampering(t *testing.T) {
+	dir := t.TempDir()
+	board, err := Open(dir, []uint64{0x1001})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	log, _ := board.Log(0x1001)
+	for i := 0; i < 3; i++ {
+		if _, err := log.Append(fmt.Sprintf("msg-%d", i), []byte("payload"), time.Now()); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+
+	path := filepath.Join(dir, fmt.Sprintf("%016x.jsonl", 0x1001))
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := strings.Replace(string(data), `"message_id":"msg-1"`, `"message_id":"msg-X"`, 1)
+	if err := os.WriteFile(path, []byte(tampered), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(dir, []uint64{0x1001}); !errors.Is(err, ErrBrokenChain) {
+		t.Errorf("Expected ErrBrokenChain, got %v", err)
+	}
+}
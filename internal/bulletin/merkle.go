@@ -0,0 +1,95 @@
+package bulletin
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"math/bits"
+)
+
+// Hashes of the Merkle tree over a log's entries, as in RFC 6962, section
+// 2.1: leaves and interior nodes are hashed with distinct prefixes, so a
+// leaf can never be passed off as a node.
+
+// leafHash hashes the encoding of an entry
+func leafHash(data []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{0x00})
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// nodeHash hashes two child hashes
+func nodeHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{0x01})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// emptyRoot is the root of a tree without leaves
+func emptyRoot() []byte {
+	sum := sha256.Sum256(nil)
+	return sum[:]
+}
+
+// splitPoint is the largest power of two smaller than n, for n > 1
+func splitPoint(n uint64) uint64 {
+	return 1 << (bits.Len64(n-1) - 1)
+}
+
+// merkleRoot returns the root of the tree over leaves
+func merkleRoot(leaves [][]byte) []byte {
+	switch len(leaves) {
+	case 0:
+		return emptyRoot()
+	case 1:
+		return leaves[0]
+	}
+	k := splitPoint(uint64(len(leaves)))
+	return nodeHash(merkleRoot(leaves[:k]), merkleRoot(leaves[k:]))
+}
+
+// inclusionPath returns the audit path of leaf m in the tree over leaves
+// (RFC 6962, section 2.1.1)
+func inclusionPath(m uint64, leaves [][]byte) [][]byte {
+	n := uint64(len(leaves))
+	if n <= 1 {
+		return [][]byte{}
+	}
+	k := splitPoint(n)
+	if m < k {
+		return append(inclusionPath(m, leaves[:k]), merkleRoot(leaves[k:]))
+	}
+	return append(inclusionPath(m-k, leaves[k:]), merkleRoot(leaves[:k]))
+}
+
+// VerifyInclusion checks that the leaf hash at index is in the tree of size
+// entries with the given root, using an audit path from InclusionProof
+func VerifyInclusion(leaf []byte, index, size uint64, path [][]byte, root []byte) bool {
+	if index >= size {
+		return false
+	}
+	// RFC 9162, section 2.1.3.2
+	fn, sn := index, size-1
+	hash := leaf
+	for _, sibling := range path {
+		if sn == 0 {
+			return false
+		}
+		if fn&1 == 1 || fn == sn {
+			hash = nodeHash(sibling, hash)
+			if fn&1 == 0 {
+				for fn&1 == 0 && fn != 0 {
+					fn >>= 1
+					sn >>= 1
+				}
+			}
+		} else {
+			hash = nodeHash(hash, sibling)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	return sn == 0 && bytes.Equal(hash, root)
+}
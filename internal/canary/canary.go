@@ -0,0 +1,207 @@
+// Package canary probes the server from the outside. A built-in client
+// subscribes to a dedicated canary channel over TLS and WebSocket like any
+// other client, publishes to it periodically and times how long each
+// message takes to come back through the broadcast path. The results are
+// exported as metrics, so operators are alerted to partial outages that
+// /health cannot see, such as a stuck broadcast or a bad server certificate.
+package canary
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yourusername/secure-messaging-poc/internal/metrics"
+	"github.com/yourusername/secure-messaging-poc/pkg/client"
+)
+
+const (
+	// DefaultInterval is how often a probe is sent unless configured
+	DefaultInterval = 30 * time.Second
+	// DefaultTimeout is how long a probe may take to come back unless
+	// configured
+	DefaultTimeout = 10 * time.Second
+	// probeSize is the ciphertext size of a probe, random bytes
+	probeSize = 32
+)
+
+// ErrProbeTimeout is recorded for a probe that did not come back in time
+var ErrProbeTimeout = errors.New("canary: probe timed out")
+
+// Config configures a Probe
+type Config struct {
+	ServerURL string      // Public base URL, e.g. https://example.com:8443
+	TLSConfig *tls.Config // The probe's client certificate and server trust
+	Channel   uint64      // Dedicated canary channel
+	Interval  time.Duration
+	Timeout   time.Duration
+}
+
+// Probe publishes to the canary channel and measures the round trip
+type Probe struct {
+	config   Config
+	client   *client.Client
+	received chan string
+
+	probes    *metrics.Counter
+	failures  *metrics.Counter
+	latency   *metrics.Gauge
+	up        *metrics.Gauge
+	lastOK    *metrics.Gauge
+	lastError error
+	mu        sync.Mutex
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// New creates a probe exporting its results to registry
+func New(config Config, registry *metrics.Registry) (*Probe, error) {
+	if config.Interval <= 0 {
+		config.Interval = DefaultInterval
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultTimeout
+	}
+
+	p := &Probe{
+		config:   config,
+		received: make(chan string, 16),
+		probes:   registry.Counter("anono_canary_probes_total", "Canary probes sent"),
+		failures: registry.Counter("anono_canary_failures_total", "Canary probes that failed or timed out"),
+		latency:  registry.Gauge("anono_canary_latency_seconds", "Round trip of the last successful canary probe"),
+		up:       registry.Gauge("anono_canary_up", "1 if the last canary probe came back in time"),
+		lastOK:   registry.Gauge("anono_canary_last_success_timestamp_seconds", "Unix time of the last successful canary probe"),
+	}
+
+	c, err := client.New(client.Config{
+		ServerURL: config.ServerURL,
+		TLSConfig: config.TLSConfig,
+		Channels:  []uint64{config.Channel},
+		OnMessage: func(msg *client.Message) {
+			select {
+			case p.received <- msg.MessageID:
+			default:
+			}
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	p.client = c
+	return p, nil
+}
+
+// Start connects and begins probing
+func (p *Probe) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+	p.done = make(chan struct{})
+
+	go func() {
+		if err := p.client.Run(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Canary client stopped: %v", err)
+		}
+	}()
+	go p.run(ctx)
+}
+
+// Stop stops probing and disconnects
+func (p *Probe) Stop() {
+	if p.cancel == nil {
+		return
+	}
+	p.cancel()
+	<-p.done
+}
+
+// LastError returns why the last probe failed, or nil if it succeeded
+func (p *Probe) LastError() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.lastError
+}
+
+// run sends a probe every interval
+func (p *Probe) run(ctx context.Context) {
+	defer close(p.done)
+	ticker := time.NewTicker(p.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.record(p.probe(ctx))
+		}
+	}
+}
+
+// probe publishes one message and waits for it to be delivered back
+func (p *Probe) probe(ctx context.Context) (time.Duration, error) {
+	ciphertext := make([]byte, probeSize)
+	if _, err := rand.Read(ciphertext); err != nil {
+		return 0, err
+	}
+	messageID := uuid.New().String()
+
+	// Deliveries of earlier probes that timed out are stale
+	for len(p.received) > 0 {
+		<-p.received
+	}
+
+	start := time.Now()
+	msg := &client.Message{MessageID: messageID, Ciphertext: ciphertext}
+	if err := p.client.Publish(p.config.Channel, msg); err != nil {
+		return 0, err
+	}
+
+	timeout := time.NewTimer(p.config.Timeout)
+	defer timeout.Stop()
+	for {
+		select {
+		case id := <-p.received:
+			if id == messageID {
+				return time.Since(start), nil
+			}
+		case <-timeout.C:
+			return 0, ErrProbeTimeout
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+}
+
+// record exports the outcome of a probe
+func (p *Probe) record(latency time.Duration, err error) {
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	p.probes.Inc()
+
+	p.mu.Lock()
+	previous := p.lastError
+	p.lastError = err
+	p.mu.Unlock()
+
+	if err != nil {
+		p.failures.Inc()
+		p.up.Set(0)
+		if previous == nil {
+			log.Printf("Canary probe failed: %v", err)
+		}
+		return
+	}
+	p.up.Set(1)
+	p.latency.Set(latency.Seconds())
+	p.lastOK.Set(float64(time.Now().Unix()))
+	if previous != nil {
+		log.Printf("Canary probe recovered after: %v", previous)
+	}
+}
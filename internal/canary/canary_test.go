@@ -0,0 +1,105 @@
+package canary
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/yourusername/secure-messaging-poc/internal/metrics"
+	"github.com/yourusername/secure-messaging-poc/pkg/client"
+)
+
+// newEchoServer acknowledges a subscription, then broadcasts every message
+// back to the publisher unless dropping is set
+func newEchoServer(t *testing.T, dropping *int32) *httptest.Server {
+	upgrader := websocket.Upgrader{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/info", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"bin_mask": "0xFF"})
+	})
+	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		var subscribe map[string]interface{}
+		if err := conn.ReadJSON(&subscribe); err != nil {
+			return
+		}
+		conn.WriteJSON(map[string]interface{}{"type": "subscribe_ack", "bin_mask": "0xFF"})
+		for {
+			var msg client.Message
+			if err := conn.ReadJSON(&msg); err != nil {
+				return
+			}
+			if atomic.LoadInt32(dropping) == 0 {
+				conn.WriteJSON(msg)
+			}
+		}
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestProbe(t *testing.T) {
+	var dropping int32
+	server := newEchoServer(t, &dropping)
+	registry := metrics.NewRegistry()
+	probe, err := New(Config{
+		ServerURL: server.URL,
+		Channel:   0xCA,
+		Interval:  20 * time.Millisecond,
+		Timeout:   100 * time.Millisecond,
+	}, registry)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	probe.Start()
+	defer probe.Stop()
+
+	waitFor(t, "a successful probe", func() bool {
+		up, _, _ := registry.Read("anono_canary_up")
+		return up == 1
+	})
+	if latency, _, _ := registry.Read("anono_canary_latency_seconds"); latency <= 0 {
+		t.Errorf("Expected a latency, got %v", latency)
+	}
+	if last, _, _ := registry.Read("anono_canary_last_success_timestamp_seconds"); last == 0 {
+		t.Error("Expected the time of the last success")
+	}
+
+	// Probes that do not come back are failures
+	atomic.StoreInt32(&dropping, 1)
+	waitFor(t, "a failed probe", func() bool {
+		up, _, _ := registry.Read("anono_canary_up")
+		return up == 0
+	})
+	if err := probe.LastError(); err != ErrProbeTimeout {
+		t.Errorf("Expected ErrProbeTimeout, got %v", err)
+	}
+	if failures, _, _ := registry.Read("anono_canary_failures_total"); failures == 0 {
+		t.Error("Expected the failure to be counted")
+	}
+
+	atomic.StoreInt32(&dropping, 0)
+	waitFor(t, "recovery", func() bool { return probe.LastError() == nil })
+}
+
+// waitFor polls cond for up to five seconds
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -1,29 +1,76 @@
 package certmanager
 
 import (
+	"crypto"
+	"crypto/hmac"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
 	"errors"
-	"io/ioutil"
+	"fmt"
 	"math/big"
+	"net"
 	"os"
+	"sync"
 	"time"
+
+	cryptopkg "github.com/yourusername/secure-messaging-poc/pkg/crypto"
+)
+
+var (
+	// ErrCANotInitialized is returned when the CA has no certificate or key
+	ErrCANotInitialized = errors.New("CA not initialized")
+	// ErrInvalidCSR is returned for a CSR whose signature does not verify
+	ErrInvalidCSR = errors.New("invalid CSR signature")
+	// ErrInvalidPEM is returned when a CA file holds no PEM block
+	ErrInvalidPEM = errors.New("invalid PEM data")
+	// ErrSelfReferral is returned for a CSR whose key is its referrer's, as
+	// the certificate would refer itself
+	ErrSelfReferral = errors.New("certificate cannot be its own referrer")
 )
 
 // CertificateAuthority manages the CA operations
 type CertificateAuthority struct {
 	caCert       *x509.Certificate
-	caPrivKey    *rsa.PrivateKey
+	caPrivKey    crypto.Signer
+	issuedKeys   cryptopkg.KeyAlgorithm // For keys the CA generates itself
 	organization string
+	crlURLs      []string
+	ocspURLs     []string
+	issuerURLs   []string
+
+	// Checks pseudonym URI SANs; nil refuses them
+	pseudonymPolicy PseudonymPolicy
+
+	// Every certificate signed, in memory unless a persisted registry is set
+	registry *IssuanceRegistry
+
+	// Expiry of certificates seen but not issued here, for validity inheritance
+	validityMu      sync.Mutex
+	inheritValidity bool
+	notAfter        map[string]time.Time
+	recorded        int // Since the last sweep of notAfter
 }
 
-// NewCertificateAuthority creates a new certificate authority
+// NewCertificateAuthority creates a new certificate authority, generating
+// a key of the default algorithm if none exists yet
 func NewCertificateAuthority(certPath, keyPath, organization string) (*CertificateAuthority, error) {
+	return NewCertificateAuthorityWithAlgorithm(certPath, keyPath, organization, cryptopkg.DefaultKeyAlgorithm)
+}
+
+// NewCertificateAuthorityWithAlgorithm creates a new certificate authority,
+// generating a key of the given algorithm if none exists yet. An existing
+// key is used whatever its algorithm.
+func NewCertificateAuthorityWithAlgorithm(certPath, keyPath, organization string, algorithm cryptopkg.KeyAlgorithm) (*CertificateAuthority, error) {
+	registry, _ := NewIssuanceRegistry("")
 	ca := &CertificateAuthority{
 		organization: organization,
+		registry:     registry,
+		issuedKeys:   cryptopkg.DefaultKeyAlgorithm,
 	}
 	
 	// Check if the CA certificate and key exist
@@ -32,7 +79,7 @@ func NewCertificateAuthority(certPath, keyPath, organization string) (*Certifica
 	
 	if os.IsNotExist(certErr) || os.IsNotExist(keyErr) {
 		// Create new CA certificate and key
-		cert, key, err := ca.generateCA(organization)
+		cert, key, err := ca.generateCA(organization, algorithm)
 		if err != nil {
 			return nil, err
 		}
@@ -61,20 +108,86 @@ func NewCertificateAuthority(certPath, keyPath, organization string) (*Certifica
 // GetCACertificate returns the CA certificate
 func (ca *CertificateAuthority) GetCACertificate() (*x509.Certificate, error) {
 	if ca.caCert == nil {
-		return nil, errors.New("CA certificate not initialized")
+		return nil, ErrCANotInitialized
 	}
 	return ca.caCert, nil
 }
 
+// DeriveSecret returns a 32-byte secret for label, derived from the CA key so
+// it is stable across restarts without being stored anywhere
+func (ca *CertificateAuthority) DeriveSecret(label string) ([]byte, error) {
+	if ca.caPrivKey == nil {
+		return nil, ErrCANotInitialized
+	}
+	// RSA keys keep their PKCS #1 encoding so secrets survive the upgrade
+	var keyDER []byte
+	if rsaKey, ok := ca.caPrivKey.(*rsa.PrivateKey); ok {
+		keyDER = x509.MarshalPKCS1PrivateKey(rsaKey)
+	} else {
+		var err error
+		if keyDER, err = x509.MarshalPKCS8PrivateKey(ca.caPrivKey); err != nil {
+			return nil, err
+		}
+	}
+	keyHash := sha256.Sum256(keyDER)
+	mac := hmac.New(sha256.New, keyHash[:])
+	mac.Write([]byte(label))
+	return mac.Sum(nil), nil
+}
+
+// SetDistributionURLs configures the CRL distribution points and Authority
+// Information Access URLs embedded in issued certificates, so standard TLS
+// stacks trusting this CA can check revocation. Call before issuing.
+func (ca *CertificateAuthority) SetDistributionURLs(crlURLs, ocspURLs, issuerURLs []string) {
+	ca.crlURLs = crlURLs
+	ca.ocspURLs = ocspURLs
+	ca.issuerURLs = issuerURLs
+}
+
+// SetIssuanceRegistry replaces the registry the CA records what it signs
+// in, typically with one persisted alongside the CA. Call before issuing.
+func (ca *CertificateAuthority) SetIssuanceRegistry(registry *IssuanceRegistry) {
+	ca.registry = registry
+}
+
+// SetIssuedKeyAlgorithm sets the algorithm of the keys the CA generates for
+// certificates it issues itself, such as server certificates. Call before
+// issuing.
+func (ca *CertificateAuthority) SetIssuedKeyAlgorithm(algorithm cryptopkg.KeyAlgorithm) {
+	ca.issuedKeys = algorithm
+}
+
+// IssuedKeyAlgorithm returns the algorithm of keys the CA generates
+func (ca *CertificateAuthority) IssuedKeyAlgorithm() cryptopkg.KeyAlgorithm {
+	return ca.issuedKeys
+}
+
+// IssuanceRegistry returns the record of every certificate the CA signed
+func (ca *CertificateAuthority) IssuanceRegistry() *IssuanceRegistry {
+	return ca.registry
+}
+
 // SignCSR signs a certificate signing request
 func (ca *CertificateAuthority) SignCSR(csr *x509.CertificateRequest, referrerID string, validityDays int) (*x509.Certificate, error) {
 	if ca.caCert == nil || ca.caPrivKey == nil {
-		return nil, errors.New("CA not initialized")
+		return nil, ErrCANotInitialized
 	}
 	
 	// Validate CSR
 	if err := csr.CheckSignature(); err != nil {
-		return nil, errors.New("invalid CSR signature")
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCSR, err)
+	}
+	if err := cryptopkg.CheckPublicKey(csr.PublicKey); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedKey, err)
+	}
+	if referrerID != "" && cryptopkg.ConstantTimeEqualString(spkiID(csr.RawSubjectPublicKeyInfo), referrerID) {
+		return nil, ErrSelfReferral
+	}
+	
+	// Only validated pseudonym URIs are carried over from the CSR
+	uris, err := ca.pseudonymURIs(csr, referrerID)
+	if err != nil {
+		return nil, err
 	}
 	
 	// Generate a random serial number
@@ -85,7 +198,10 @@ func (ca *CertificateAuthority) SignCSR(csr *x509.CertificateRequest, referrerID
 	
 	// Prepare certificate template
 	notBefore := time.Now()
-	notAfter := notBefore.AddDate(0, 0, validityDays)
+	notAfter, err := ca.inheritedNotAfter(referrerID, notBefore, notBefore.AddDate(0, 0, validityDays))
+	if err != nil {
+		return nil, err
+	}
 	
 	template := &x509.Certificate{
 		SerialNumber: serialNumber,
@@ -97,6 +213,13 @@ func (ca *CertificateAuthority) SignCSR(csr *x509.CertificateRequest, referrerID
 		NotAfter:    notAfter,
 		KeyUsage:    x509.KeyUsageDigitalSignature,
 		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		
+		// Revocation checking endpoints
+		CRLDistributionPoints: ca.crlURLs,
+		OCSPServer:            ca.ocspURLs,
+		IssuingCertificateURL: ca.issuerURLs,
+		
+		URIs: uris,
 	}
 	
 	// Add referrer extension if provided
@@ -127,14 +250,75 @@ func (ca *CertificateAuthority) SignCSR(csr *x509.CertificateRequest, referrerID
 	if err != nil {
 		return nil, err
 	}
+	if _, err := ca.registry.Record(cert); err != nil {
+		return nil, fmt.Errorf("record issued certificate: %w", err)
+	}
 	
 	return cert, nil
 }
 
+// IssueServerCertificate issues a certificate for a TLS listener serving
+// hosts, which may be DNS names or IP addresses, with a fresh key of the
+// issued key algorithm
+func (ca *CertificateAuthority) IssueServerCertificate(hosts []string, validityDays int) (*tls.Certificate, error) {
+	if ca.caCert == nil || ca.caPrivKey == nil {
+		return nil, ErrCANotInitialized
+	}
+	
+	key, err := cryptopkg.GenerateKey(ca.issuedKeys)
+	if err != nil {
+		return nil, err
+	}
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	
+	notBefore := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			Organization: []string{ca.organization},
+		},
+		NotBefore:   notBefore,
+		NotAfter:    notBefore.AddDate(0, 0, validityDays),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	if len(hosts) > 0 {
+		template.Subject.CommonName = hosts[0]
+	}
+	
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, ca.caCert, key.Public(), ca.caPrivKey)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(certBytes)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := ca.registry.Record(leaf); err != nil {
+		return nil, fmt.Errorf("record issued certificate: %w", err)
+	}
+	
+	return &tls.Certificate{
+		Certificate: [][]byte{certBytes, ca.caCert.Raw},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
+
 // generateCA generates a new CA certificate and private key
-func (ca *CertificateAuthority) generateCA(organization string) (*x509.Certificate, *rsa.PrivateKey, error) {
+func (ca *CertificateAuthority) generateCA(organization string, algorithm cryptopkg.KeyAlgorithm) (*x509.Certificate, crypto.Signer, error) {
 	// Generate a new private key
-	caPrivKey, err := rsa.GenerateKey(rand.Reader, 4096)
+	caPrivKey, err := cryptopkg.GenerateKey(algorithm)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -164,7 +348,7 @@ func (ca *CertificateAuthority) generateCA(organization string) (*x509.Certifica
 		rand.Reader,
 		template,
 		template,
-		&caPrivKey.PublicKey,
+		caPrivKey.Public(),
 		caPrivKey,
 	)
 	if err != nil {
@@ -181,7 +365,7 @@ func (ca *CertificateAuthority) generateCA(organization string) (*x509.Certifica
 }
 
 // saveCertAndKey saves the certificate and private key to files
-func (ca *CertificateAuthority) saveCertAndKey(cert *x509.Certificate, key *rsa.PrivateKey, certPath, keyPath string) error {
+func (ca *CertificateAuthority) saveCertAndKey(cert *x509.Certificate, key crypto.Signer, certPath, keyPath string) error {
 	// Save certificate
 	certOut, err := os.Create(certPath)
 	if err != nil {
@@ -198,55 +382,44 @@ func (ca *CertificateAuthority) saveCertAndKey(cert *x509.Certificate, key *rsa.
 	}
 	
 	// Save private key
-	keyOut, err := os.Create(keyPath)
+	keyPEM, err := cryptopkg.MarshalSignerToPEM(key)
 	if err != nil {
 		return err
 	}
-	defer keyOut.Close()
-	
-	err = pem.Encode(keyOut, &pem.Block{
-		Type:  "RSA PRIVATE KEY",
-		Bytes: x509.MarshalPKCS1PrivateKey(key),
-	})
-	if err != nil {
-		return err
-	}
-	
-	return nil
+	return os.WriteFile(keyPath, keyPEM, 0600)
 }
 
 // loadCertAndKey loads the certificate and private key from files
-func (ca *CertificateAuthority) loadCertAndKey(certPath, keyPath string) (*x509.Certificate, *rsa.PrivateKey, error) {
+func (ca *CertificateAuthority) loadCertAndKey(certPath, keyPath string) (*x509.Certificate, crypto.Signer, error) {
 	// Load certificate
-	certPEM, err := ioutil.ReadFile(certPath)
+	certPEM, err := os.ReadFile(certPath)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, fmt.Errorf("reading CA certificate: %w", err)
 	}
 	
 	certBlock, _ := pem.Decode(certPEM)
 	if certBlock == nil {
-		return nil, nil, errors.New("failed to parse certificate PEM")
+		return nil, nil, fmt.Errorf("%w in %s", ErrInvalidPEM, certPath)
 	}
 	
 	cert, err := x509.ParseCertificate(certBlock.Bytes)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, fmt.Errorf("parsing CA certificate %s: %w", certPath, err)
 	}
 	
 	// Load private key
-	keyPEM, err := ioutil.ReadFile(keyPath)
+	keyPEM, err := os.ReadFile(keyPath)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, fmt.Errorf("reading CA key: %w", err)
 	}
 	
-	keyBlock, _ := pem.Decode(keyPEM)
-	if keyBlock == nil {
-		return nil, nil, errors.New("failed to parse key PEM")
+	if block, _ := pem.Decode(keyPEM); block == nil {
+		return nil, nil, fmt.Errorf("%w in %s", ErrInvalidPEM, keyPath)
 	}
 	
-	key, err := x509.ParsePKCS1PrivateKey(keyBlock.Bytes)
+	key, err := cryptopkg.ParseSignerFromPEM(keyPEM)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, fmt.Errorf("parsing CA key %s: %w", keyPath, err)
 	}
 	
 	return cert, key, nil
@@ -0,0 +1,191 @@
+package certmanager
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestSignCSRDistributionURLs(t *testing.T) {
+	ca := newTestCA(t)
+
+	ca.SetDistributionURLs(
+		[]string{"http://pki.example.com/ca.crl"},
+		[]string{"http://pki.example.com/ocsp"},
+		[]string{"http://pki.example.com/ca.crt"},
+	)
+	t.Cleanup(func() { ca.SetDistributionURLs(nil, nil, nil) })
+
+	csr, _ := newTestCSR(t, "client")
+	cert, err := ca.SignCSR(csr, "", 30)
+	if err != nil {
+		t.Fatalf("Failed to sign CSR: %v", err)
+	}
+
+	if len(cert.CRLDistributionPoints) != 1 || cert.CRLDistributionPoints[0] != "http://pki.example.com/ca.crl" {
+		t.Errorf("CRL distribution points incorrect: %v", cert.CRLDistributionPoints)
+	}
+	if len(cert.OCSPServer) != 1 || cert.OCSPServer[0] != "http://pki.example.com/ocsp" {
+		t.Errorf("OCSP server URLs incorrect: %v", cert.OCSPServer)
+	}
+	if len(cert.IssuingCertificateURL) != 1 || cert.IssuingCertificateURL[0] != "http://pki.example.com/ca.crt" {
+		t.Errorf("Issuing certificate URLs incorrect: %v", cert.IssuingCertificateURL)
+	}
+}
+
+func TestSignCSRWithoutDistributionURLs(t *testing.T) {
+	ca := newTestCA(t)
+
+	csr, _ := newTestCSR(t, "client")
+	cert, err := ca.SignCSR(csr, "", 30)
+	if err != nil {
+		t.Fatalf("Failed to sign CSR: %v", err)
+	}
+
+	if len(cert.CRLDistributionPoints) != 0 || len(cert.OCSPServer) != 0 || len(cert.IssuingCertificateURL) != 0 {
+		t.Error("No revocation endpoints should be embedded when none are configured")
+	}
+}
+
+func TestCertificateID(t *testing.T) {
+	ca := newTestCA(t)
+
+	csr, _ := newTestCSR(t, "client")
+	first, err := ca.SignCSR(csr, "", 30)
+	if err != nil {
+		t.Fatalf("Failed to sign CSR: %v", err)
+	}
+	second, err := ca.SignCSR(csr, "", 30)
+	if err != nil {
+		t.Fatalf("Failed to sign CSR: %v", err)
+	}
+
+	id := CertificateID(first)
+	if len(id) != 32 {
+		t.Errorf("Certificate ID should be 32 hex characters, got %q", id)
+	}
+
+	// The ID is derived from the key, not the serial number
+	if first.SerialNumber.Cmp(second.SerialNumber) == 0 {
+		t.Fatal("Serial numbers should differ between issuances")
+	}
+	if CertificateID(second) != id {
+		t.Error("Certificates for the same key should share an ID")
+	}
+
+	otherCSR, _ := newTestCSR(t, "client")
+	other, _ := ca.SignCSR(otherCSR, "", 30)
+	if CertificateID(other) == id {
+		t.Error("Certificates for different keys should have different IDs")
+	}
+
+	if info := GetCertificateInfo(first); info["cert_id"] != id {
+		t.Errorf("Certificate info should expose the certificate ID, got %v", info["cert_id"])
+	}
+}
+
+func TestSignCSRSelfReferral(t *testing.T) {
+	ca := newTestCA(t)
+
+	csr, _ := newTestCSR(t, "client")
+	cert, err := ca.SignCSR(csr, "", 30)
+	if err != nil {
+		t.Fatalf("Failed to sign CSR: %v", err)
+	}
+
+	// A CSR for the referrer's own key would make the certificate its own
+	// referrer
+	if _, err := ca.SignCSR(csr, CertificateID(cert), 30); !errors.Is(err, ErrSelfReferral) {
+		t.Errorf("Expected ErrSelfReferral, got %v", err)
+	}
+}
+
+func TestSignCSRPseudonymURI(t *testing.T) {
+	ca := newTestCA(t)
+
+	pseudonym := strings.Repeat("ab", 32)
+	newCSR := func(uris ...*url.URL) *x509.CertificateRequest {
+		key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+			Subject: pkix.Name{CommonName: "client"},
+			URIs:    uris,
+		}, key)
+		if err != nil {
+			t.Fatalf("Failed to create CSR: %v", err)
+		}
+		csr, _ := x509.ParseCertificateRequest(der)
+		return csr
+	}
+
+	// Refused until a policy is configured
+	if _, err := ca.SignCSR(newCSR(PseudonymURI(pseudonym)), "", 30); !errors.Is(err, ErrPseudonymNotAllowed) {
+		t.Fatalf("Expected ErrPseudonymNotAllowed without a policy, got %v", err)
+	}
+
+	var seenReferrer string
+	ca.SetPseudonymPolicy(func(p, referrerID string) error {
+		seenReferrer = referrerID
+		if p == strings.Repeat("00", 32) {
+			return errors.New("reserved")
+		}
+		return nil
+	})
+	t.Cleanup(func() { ca.SetPseudonymPolicy(nil) })
+
+	cert, err := ca.SignCSR(newCSR(PseudonymURI(pseudonym)), "referrer", 30)
+	if err != nil {
+		t.Fatalf("Failed to sign CSR: %v", err)
+	}
+	if got, ok := ExtractPseudonym(cert); !ok || got != pseudonym {
+		t.Errorf("Expected pseudonym %s, got %q", pseudonym, got)
+	}
+	if seenReferrer != "referrer" {
+		t.Errorf("Policy should see the referrer, got %q", seenReferrer)
+	}
+	if GetCertificateInfo(cert)["pseudonym"] != pseudonym {
+		t.Error("Certificate info should include the pseudonym")
+	}
+
+	// Certificates without one are unaffected
+	plain, err := ca.SignCSR(newCSR(), "", 30)
+	if err != nil {
+		t.Fatalf("Failed to sign CSR: %v", err)
+	}
+	if _, ok := ExtractPseudonym(plain); ok || len(plain.URIs) != 0 {
+		t.Error("No URI SANs should be embedded when none are requested")
+	}
+
+	if _, err := ca.SignCSR(newCSR(PseudonymURI(strings.Repeat("00", 32))), "", 30); !errors.Is(err, ErrPseudonymNotAllowed) {
+		t.Errorf("Expected the policy refusal, got %v", err)
+	}
+
+	invalid := [][]*url.URL{
+		{{Scheme: "https", Host: pseudonym}},
+		{{Scheme: PseudonymScheme, Host: "abc"}},
+		{{Scheme: PseudonymScheme, Host: strings.ToUpper(pseudonym)}},
+		{{Scheme: PseudonymScheme, Host: pseudonym, Path: "/extra"}},
+		{PseudonymURI(pseudonym), PseudonymURI(strings.Repeat("cd", 32))},
+	}
+	for _, uris := range invalid {
+		if _, err := ca.SignCSR(newCSR(uris...), "", 30); !errors.Is(err, ErrInvalidPseudonym) {
+			t.Errorf("Expected ErrInvalidPseudonym for %v, got %v", uris, err)
+		}
+	}
+}
+
+func TestSignCSRInvalidSignature(t *testing.T) {
+	ca := newTestCA(t)
+
+	csr, _ := newTestCSR(t, "client")
+	csr.Signature[len(csr.Signature)-1] ^= 0xFF
+
+	if _, err := ca.SignCSR(csr, "", 30); !errors.Is(err, ErrInvalidCSR) {
+		t.Errorf("Expected ErrInvalidCSR, got %v", err)
+	}
+}
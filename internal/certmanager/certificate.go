@@ -2,9 +2,10 @@ package certmanager
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"crypto/x509"
-	"crypto/x509/pkix"
 	"encoding/asn1"
+	"encoding/hex"
 	"encoding/pem"
 	"errors"
 
@@ -20,8 +21,25 @@ var (
 	
 	// ErrReferrerRevoked is returned when a certificate's referrer is revoked
 	ErrReferrerRevoked = errors.New("referrer certificate is revoked")
+	
+	// ErrNoReferrer is returned for a certificate without a referrer
+	// extension
+	ErrNoReferrer = errors.New("referrer ID not found")
 )
 
+// CertificateID returns the opaque identifier used for a certificate in all
+// external APIs: a truncated SHA-256 of its SubjectPublicKeyInfo. Unlike the
+// serial number it carries no issuance-order information.
+func CertificateID(cert *x509.Certificate) string {
+	return spkiID(cert.RawSubjectPublicKeyInfo)
+}
+
+// spkiID returns the certificate ID of a DER SubjectPublicKeyInfo
+func spkiID(spki []byte) string {
+	sum := sha256.Sum256(spki)
+	return hex.EncodeToString(sum[:16])
+}
+
 // ExtractReferrerID extracts the referrer ID from a certificate
 func ExtractReferrerID(cert *x509.Certificate) (string, error) {
 	for _, ext := range cert.Extensions {
@@ -30,7 +48,7 @@ func ExtractReferrerID(cert *x509.Certificate) (string, error) {
 		}
 	}
 	
-	return "", errors.New("referrer ID not found")
+	return "", ErrNoReferrer
 }
 
 // CreateCSR creates a Certificate Signing Request
@@ -66,7 +84,7 @@ func EncodeCertificatePEM(cert *x509.Certificate) ([]byte, error) {
 // GetCertificateInfo returns basic information about a certificate
 func GetCertificateInfo(cert *x509.Certificate) map[string]interface{} {
 	info := map[string]interface{}{
-		"serial":     cert.SerialNumber.String(),
+		"cert_id":    CertificateID(cert),
 		"subject":    cert.Subject.CommonName,
 		"issuer":     cert.Issuer.CommonName,
 		"not_before": cert.NotBefore,
@@ -78,5 +96,9 @@ func GetCertificateInfo(cert *x509.Certificate) map[string]interface{} {
 		info["referrer_id"] = referrerID
 	}
 	
+	if pseudonym, ok := ExtractPseudonym(cert); ok {
+		info["pseudonym"] = pseudonym
+	}
+	
 	return info
 }
\ No newline at end of file
@@ -0,0 +1,180 @@
+package certmanager
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"sync"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// COSE identifiers used by signed revocation entries (RFC 9052, 9360); the
+// algorithms are in signing.go
+const (
+	coseSign1Tag      = 18
+	coseHeaderAlg     = 1
+	coseHeaderX5Chain = 33
+)
+
+// ErrInvalidCOSE is returned for malformed or unverifiable COSE_Sign1 entries
+var ErrInvalidCOSE = errors.New("invalid COSE_Sign1 entry")
+
+// RevocationEntry is the payload of a signed revocation. Entries are
+// self-contained so clients and peers can cache and re-share them.
+type RevocationEntry struct {
+	Seq       uint64 `cbor:"1,keyasint" json:"seq"`
+	CertID    string `cbor:"2,keyasint" json:"cert_id"`
+	RevokedAt int64  `cbor:"3,keyasint" json:"revoked_at"`
+	IssuerID  string `cbor:"4,keyasint" json:"issuer_id"`
+	Reason    string `cbor:"5,keyasint,omitempty" json:"reason,omitempty"`
+}
+
+// coseSign1 is the untagged COSE_Sign1 array
+type coseSign1 struct {
+	_           struct{} `cbor:",toarray"`
+	Protected   []byte
+	Unprotected map[int]interface{}
+	Payload     []byte
+	Signature   []byte
+}
+
+// coseProtected is the protected header: the algorithm and the signing
+// certificate, so an entry verifies without the transport
+type coseProtected struct {
+	Alg     int    `cbor:"1,keyasint"`
+	X5Chain []byte `cbor:"33,keyasint"`
+}
+
+// coseEncMode encodes payloads and headers deterministically
+var coseEncMode, _ = cbor.CoreDetEncOptions().EncMode()
+
+// SignRevocationEntry signs entry with the CA key as a tagged COSE_Sign1
+func (ca *CertificateAuthority) SignRevocationEntry(entry RevocationEntry) ([]byte, error) {
+	if ca.caCert == nil || ca.caPrivKey == nil {
+		return nil, ErrCANotInitialized
+	}
+	scheme, ok := schemeFor(ca.caPrivKey.Public())
+	if !ok {
+		return nil, ErrUnsupportedKey
+	}
+
+	entry.IssuerID = CertificateID(ca.caCert)
+	payload, err := coseEncMode.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+	protected, err := coseEncMode.Marshal(coseProtected{Alg: scheme.cose, X5Chain: ca.caCert.Raw})
+	if err != nil {
+		return nil, err
+	}
+
+	toBeSigned, err := sigStructure(protected, payload)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := scheme.sign(ca.caPrivKey, toBeSigned)
+	if err != nil {
+		return nil, err
+	}
+
+	return coseEncMode.Marshal(cbor.Tag{
+		Number: coseSign1Tag,
+		Content: coseSign1{
+			Protected:   protected,
+			Unprotected: map[int]interface{}{},
+			Payload:     payload,
+			Signature:   signature,
+		},
+	})
+}
+
+// VerifyRevocationEntry verifies a COSE_Sign1 entry produced by
+// SignRevocationEntry against a trusted CA certificate and returns its payload
+func VerifyRevocationEntry(data []byte, trusted *x509.Certificate) (*RevocationEntry, error) {
+	var tag cbor.RawTag
+	if err := cbor.Unmarshal(data, &tag); err != nil || tag.Number != coseSign1Tag {
+		return nil, ErrInvalidCOSE
+	}
+	var msg coseSign1
+	if err := cbor.Unmarshal(tag.Content, &msg); err != nil {
+		return nil, ErrInvalidCOSE
+	}
+
+	// The algorithm must be the one the trusted key signs with
+	scheme, ok := schemeFor(trusted.PublicKey)
+	if !ok {
+		return nil, ErrInvalidCOSE
+	}
+	var header coseProtected
+	if err := cbor.Unmarshal(msg.Protected, &header); err != nil || header.Alg != scheme.cose {
+		return nil, ErrInvalidCOSE
+	}
+	if !bytes.Equal(header.X5Chain, trusted.Raw) {
+		return nil, ErrInvalidCOSE
+	}
+
+	toBeSigned, err := sigStructure(msg.Protected, msg.Payload)
+	if err != nil {
+		return nil, ErrInvalidCOSE
+	}
+	if !scheme.verify(trusted.PublicKey, toBeSigned, msg.Signature) {
+		return nil, ErrInvalidCOSE
+	}
+
+	var entry RevocationEntry
+	if err := cbor.Unmarshal(msg.Payload, &entry); err != nil {
+		return nil, ErrInvalidCOSE
+	}
+	return &entry, nil
+}
+
+// sigStructure builds the Sig_structure signed for a COSE_Sign1 without
+// external additional data
+func sigStructure(protected, payload []byte) ([]byte, error) {
+	return coseEncMode.Marshal([]interface{}{"Signature1", protected, []byte{}, payload})
+}
+
+// RevocationFeed serves the revocation delta feed as signed entries. Each
+// entry is signed once, when first requested, and reused afterwards.
+type RevocationFeed struct {
+	ca     *CertificateAuthority
+	rm     *RevocationManager
+	signed map[uint64][]byte
+	mu     sync.Mutex
+}
+
+// NewRevocationFeed creates a feed of rm's revocations signed by ca
+func NewRevocationFeed(ca *CertificateAuthority, rm *RevocationManager) *RevocationFeed {
+	return &RevocationFeed{ca: ca, rm: rm, signed: make(map[uint64][]byte)}
+}
+
+// Since returns up to limit signed entries after seq and the sequence
+// number to request next
+func (f *RevocationFeed) Since(seq uint64, limit int) ([][]byte, uint64, error) {
+	events := f.rm.RevocationsSince(seq, limit)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	entries := make([][]byte, 0, len(events))
+	for _, event := range events {
+		signed, exists := f.signed[event.Seq]
+		if !exists {
+			var err error
+			signed, err = f.ca.SignRevocationEntry(RevocationEntry{
+				Seq:       event.Seq,
+				CertID:    event.CertID,
+				RevokedAt: event.RevokedAt.Unix(),
+				Reason:    event.Reason,
+			})
+			if err != nil {
+				return nil, seq, err
+			}
+			f.signed[event.Seq] = signed
+		}
+		entries = append(entries, signed)
+		seq = event.Seq
+	}
+	return entries, seq, nil
+}
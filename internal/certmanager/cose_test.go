@@ -0,0 +1,57 @@
+package certmanager
+
+import (
+	"testing"
+)
+
+func TestRevocationFeedSignedEntries(t *testing.T) {
+	ca := newTestCA(t)
+	caCert, _ := ca.GetCACertificate()
+
+	rm := NewRevocationManager()
+	rm.RegisterCertificate("child", "parent")
+	rm.Revoke("lost")
+	rm.RevokeWithChildren("parent")
+	rm.Revoke("lost") // Already revoked, not a new delta
+
+	feed := NewRevocationFeed(ca, rm)
+	entries, next, err := feed.Since(0, 0)
+	if err != nil {
+		t.Fatalf("Failed to read feed: %v", err)
+	}
+	if len(entries) != 3 || next != 3 {
+		t.Fatalf("Expected 3 entries up to seq 3, got %d up to %d", len(entries), next)
+	}
+
+	expected := []string{"lost", "parent", "child"}
+	for i, data := range entries {
+		entry, err := VerifyRevocationEntry(data, caCert)
+		if err != nil {
+			t.Fatalf("Entry %d failed verification: %v", i, err)
+		}
+		if entry.Seq != uint64(i+1) || entry.CertID != expected[i] || entry.IssuerID != CertificateID(caCert) {
+			t.Errorf("Unexpected entry %d: %+v", i, entry)
+		}
+	}
+
+	// Paging continues from the returned sequence number
+	page, next, _ := feed.Since(1, 1)
+	if len(page) != 1 || next != 2 || string(page[0]) != string(entries[1]) {
+		t.Errorf("Expected the cached second entry, got %d entries up to %d", len(page), next)
+	}
+	if rest, next, _ := feed.Since(3, 0); len(rest) != 0 || next != 3 {
+		t.Errorf("Expected no entries after the last one, got %d up to %d", len(rest), next)
+	}
+
+	// Flipping a byte of the payload breaks the entry
+	tampered := append([]byte(nil), entries[0]...)
+	tampered[len(tampered)-300] ^= 0xFF
+	if _, err := VerifyRevocationEntry(tampered, caCert); err != ErrInvalidCOSE {
+		t.Errorf("Tampered entry should fail verification, got %v", err)
+	}
+
+	other := newSelfSignedCert(t, "other", true)
+	if _, err := VerifyRevocationEntry(entries[0], other); err != ErrInvalidCOSE {
+		t.Errorf("Entry should not verify against another CA, got %v", err)
+	}
+}
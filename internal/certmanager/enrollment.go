@@ -0,0 +1,422 @@
+package certmanager
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	cryptopkg "github.com/yourusername/secure-messaging-poc/pkg/crypto"
+)
+
+// Order and challenge states for automated enrollment
+const (
+	OrderStatusPending = "pending"
+	OrderStatusReady   = "ready"
+	OrderStatusValid   = "valid"
+	OrderStatusInvalid = "invalid"
+
+	// ChallengeCertSignature requires the client to sign the challenge token
+	// with the private key of the certificate the order is bound to
+	ChallengeCertSignature = "cert-signature"
+
+	// ChallengeInvite is satisfied by redeeming an invite code at order creation
+	ChallengeInvite = "invite"
+)
+
+var (
+	// ErrOrderNotFound is returned when an order does not exist or has expired
+	ErrOrderNotFound = errors.New("order not found")
+
+	// ErrOrderNotReady is returned when finalizing an order whose challenge is unsolved
+	ErrOrderNotReady = errors.New("order is not ready for finalization")
+
+	// ErrInvalidInvite is returned when an invite code is unknown, used or expired
+	ErrInvalidInvite = errors.New("invalid or expired invite")
+
+	// ErrInviteLimit is returned when a referrer already has as many
+	// outstanding invites as allowed
+	ErrInviteLimit = errors.New("too many outstanding invites")
+
+	// ErrEnrollmentBusy is returned when the server already holds as many
+	// outstanding orders and invites as allowed
+	ErrEnrollmentBusy = errors.New("too many outstanding enrollments")
+
+	// ErrOrderLimit is returned when a certificate already has as many
+	// outstanding orders as allowed
+	ErrOrderLimit = errors.New("too many outstanding orders for this certificate")
+
+	// ErrChallengeFailed is returned when a challenge response does not verify
+	ErrChallengeFailed = errors.New("challenge verification failed")
+
+	// ErrUnsupportedKey is returned for a key type, curve or size that
+	// certificates, challenges or signed documents cannot use
+	ErrUnsupportedKey = errors.New("unsupported public key type")
+)
+
+// Order tracks a single automated enrollment from creation to finalization
+type Order struct {
+	ID            string    `json:"order_id"`
+	Status        string    `json:"status"`
+	ChallengeType string    `json:"challenge_type"`
+	Token         string    `json:"token"`
+	Expires       time.Time `json:"expires"`
+
+	// ReferrerID is embedded into the certificate issued on finalization
+	ReferrerID string `json:"-"`
+
+	// boundKey is the public key of the certificate the order was bound to
+	// and boundCert the digest of that certificate
+	boundKey  crypto.PublicKey
+	boundCert [sha256.Size]byte
+}
+
+// Invite is a single-use code that lets a client without a certificate enroll
+type Invite struct {
+	Code       string
+	ReferrerID string
+	Expires    time.Time
+}
+
+// EnrollmentManager implements an ACME-inspired order/challenge/finalize flow
+// for non-interactive clients rotating their certificates on top of the CA
+type EnrollmentManager struct {
+	ca           *CertificateAuthority
+	orders       map[string]*Order
+	invites      map[string]*Invite
+	orderTTL     time.Duration
+	inviteTTL    time.Duration
+	validityDays int
+	maxInvites   int // Outstanding invites per referrer; 0 is unlimited
+	maxPending   int // Outstanding orders and invites in total; 0 is unlimited
+	maxOrders    int // Outstanding orders per certificate; 0 is unlimited
+	mu           sync.Mutex
+}
+
+// NewEnrollmentManager creates a new enrollment manager backed by the CA
+func NewEnrollmentManager(ca *CertificateAuthority, orderTTL, inviteTTL time.Duration, validityDays int) *EnrollmentManager {
+	return &EnrollmentManager{
+		ca:           ca,
+		orders:       make(map[string]*Order),
+		invites:      make(map[string]*Invite),
+		orderTTL:     orderTTL,
+		inviteTTL:    inviteTTL,
+		validityDays: validityDays,
+	}
+}
+
+// SetInviteLimit bounds the invites a referrer may have outstanding, that
+// is issued but neither redeemed nor expired. Zero removes the bound.
+func (em *EnrollmentManager) SetInviteLimit(limit int) {
+	em.mu.Lock()
+	defer em.mu.Unlock()
+	em.maxInvites = limit
+}
+
+// SetPendingLimit bounds the orders and invites outstanding at once across
+// all clients, so unauthenticated order creation cannot grow them without
+// limit. Zero removes the bound.
+func (em *EnrollmentManager) SetPendingLimit(limit int) {
+	em.mu.Lock()
+	defer em.mu.Unlock()
+	em.maxPending = limit
+}
+
+// SetOrderLimit bounds the orders a certificate may have outstanding, so one
+// client cannot fill the pending limit on its own. Zero removes the bound.
+func (em *EnrollmentManager) SetOrderLimit(limit int) {
+	em.mu.Lock()
+	defer em.mu.Unlock()
+	em.maxOrders = limit
+}
+
+// RemainingInvites returns how many more invites referrerID may create
+// now. limited is false when invites are not bounded.
+func (em *EnrollmentManager) RemainingInvites(referrerID string) (remaining int, limited bool) {
+	em.mu.Lock()
+	defer em.mu.Unlock()
+
+	if em.maxInvites <= 0 {
+		return 0, false
+	}
+	em.purgeExpiredLocked(time.Now())
+	if remaining = em.maxInvites - em.outstandingLocked(referrerID); remaining < 0 {
+		remaining = 0
+	}
+	return remaining, true
+}
+
+// CreateInvite issues a single-use invite code on behalf of a referrer
+func (em *EnrollmentManager) CreateInvite(referrerID string) (*Invite, error) {
+	code, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	em.mu.Lock()
+	defer em.mu.Unlock()
+
+	em.purgeExpiredLocked(time.Now())
+	if em.maxInvites > 0 && em.outstandingLocked(referrerID) >= em.maxInvites {
+		return nil, ErrInviteLimit
+	}
+	if em.fullLocked() {
+		return nil, ErrEnrollmentBusy
+	}
+
+	invite := &Invite{
+		Code:       code,
+		ReferrerID: referrerID,
+		Expires:    time.Now().Add(em.inviteTTL),
+	}
+	em.invites[code] = invite
+
+	copied := *invite
+	return &copied, nil
+}
+
+// NewOrderForCertificate opens an order bound to an existing certificate.
+// The issued certificate keeps the referrer of the certificate it replaces,
+// as recorded at issuance when the certificate was issued here.
+func (em *EnrollmentManager) NewOrderForCertificate(cert *x509.Certificate) (*Order, error) {
+	referrerID, _ := ExtractReferrerID(cert)
+	if record, ok := em.ca.IssuanceRegistry().Lookup(SerialString(cert)); ok {
+		referrerID = record.ReferrerID
+	}
+
+	order, err := em.newOrder(ChallengeCertSignature, referrerID)
+	if err != nil {
+		return nil, err
+	}
+	order.boundKey = cert.PublicKey
+	order.boundCert = sha256.Sum256(cert.Raw)
+
+	return em.storeOrder(order)
+}
+
+// NewOrderForInvite opens an order by redeeming an invite code.
+// The challenge is satisfied by the invite itself, so the order is ready immediately.
+func (em *EnrollmentManager) NewOrderForInvite(code string) (*Order, error) {
+	order, err := em.newOrder(ChallengeInvite, "")
+	if err != nil {
+		return nil, err
+	}
+	order.Status = OrderStatusReady
+
+	em.mu.Lock()
+	defer em.mu.Unlock()
+
+	em.purgeExpiredLocked(time.Now())
+	invite, exists := em.invites[code]
+	if !exists {
+		return nil, ErrInvalidInvite
+	}
+
+	// The order takes the invite's place, so it fits the pending limit
+	delete(em.invites, code)
+	order.ReferrerID = invite.ReferrerID
+	em.orders[order.ID] = order
+
+	copied := *order
+	return &copied, nil
+}
+
+// RespondToChallenge verifies a signature over the order token made with the
+// private key of the bound certificate and marks the order ready
+func (em *EnrollmentManager) RespondToChallenge(orderID string, signature []byte) (*Order, error) {
+	em.mu.Lock()
+	defer em.mu.Unlock()
+
+	order, err := em.getOrderLocked(orderID)
+	if err != nil {
+		return nil, err
+	}
+
+	if order.Status == OrderStatusReady {
+		copied := *order
+		return &copied, nil
+	}
+
+	if order.Status != OrderStatusPending || order.ChallengeType != ChallengeCertSignature {
+		return nil, ErrChallengeFailed
+	}
+
+	if err := VerifySignature(order.boundKey, []byte(order.Token), signature); err != nil {
+		order.Status = OrderStatusInvalid
+		return nil, ErrChallengeFailed
+	}
+
+	order.Status = OrderStatusReady
+
+	copied := *order
+	return &copied, nil
+}
+
+// Finalize signs the CSR for a ready order. Each order can be finalized once.
+func (em *EnrollmentManager) Finalize(orderID string, csr *x509.CertificateRequest) (*x509.Certificate, string, error) {
+	em.mu.Lock()
+	order, err := em.getOrderLocked(orderID)
+	if err != nil {
+		em.mu.Unlock()
+		return nil, "", err
+	}
+
+	if order.Status != OrderStatusReady {
+		em.mu.Unlock()
+		return nil, "", ErrOrderNotReady
+	}
+
+	// Consume the order before signing so it cannot be finalized twice
+	order.Status = OrderStatusValid
+	referrerID := order.ReferrerID
+	em.mu.Unlock()
+
+	cert, err := em.ca.SignCSR(csr, referrerID, em.validityDays)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return cert, referrerID, nil
+}
+
+// GetOrder returns a copy of an order
+func (em *EnrollmentManager) GetOrder(orderID string) (*Order, error) {
+	em.mu.Lock()
+	defer em.mu.Unlock()
+
+	order, err := em.getOrderLocked(orderID)
+	if err != nil {
+		return nil, err
+	}
+
+	copied := *order
+	return &copied, nil
+}
+
+// newOrder prepares an order with a fresh challenge token
+func (em *EnrollmentManager) newOrder(challengeType, referrerID string) (*Order, error) {
+	token, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Order{
+		ID:            uuid.New().String(),
+		Status:        OrderStatusPending,
+		ChallengeType: challengeType,
+		Token:         token,
+		Expires:       time.Now().Add(em.orderTTL),
+		ReferrerID:    referrerID,
+	}, nil
+}
+
+// storeOrder saves an order and returns a copy for the caller
+func (em *EnrollmentManager) storeOrder(order *Order) (*Order, error) {
+	em.mu.Lock()
+	defer em.mu.Unlock()
+
+	em.purgeExpiredLocked(time.Now())
+	if em.maxOrders > 0 && order.ChallengeType == ChallengeCertSignature && em.certificateOrdersLocked(order.boundCert) >= em.maxOrders {
+		return nil, ErrOrderLimit
+	}
+	if em.fullLocked() {
+		return nil, ErrEnrollmentBusy
+	}
+	em.orders[order.ID] = order
+
+	copied := *order
+	return &copied, nil
+}
+
+// fullLocked reports whether the pending limit is reached; callers must
+// hold em.mu and have purged expired orders and invites
+func (em *EnrollmentManager) fullLocked() bool {
+	return em.maxPending > 0 && len(em.orders)+len(em.invites) >= em.maxPending
+}
+
+// certificateOrdersLocked counts the live orders bound to the certificate
+// with digest boundCert; callers must hold em.mu and have purged expired
+// orders
+func (em *EnrollmentManager) certificateOrdersLocked(boundCert [sha256.Size]byte) int {
+	count := 0
+	for _, order := range em.orders {
+		if order.ChallengeType == ChallengeCertSignature && order.boundCert == boundCert {
+			count++
+		}
+	}
+	return count
+}
+
+// getOrderLocked looks up a live order; callers must hold em.mu
+func (em *EnrollmentManager) getOrderLocked(orderID string) (*Order, error) {
+	order, exists := em.orders[orderID]
+	if !exists || time.Now().After(order.Expires) {
+		return nil, ErrOrderNotFound
+	}
+	return order, nil
+}
+
+// purgeExpiredLocked drops expired orders and invites; callers must hold em.mu
+func (em *EnrollmentManager) purgeExpiredLocked(now time.Time) {
+	for id, order := range em.orders {
+		if now.After(order.Expires) {
+			delete(em.orders, id)
+		}
+	}
+	for code, invite := range em.invites {
+		if now.After(invite.Expires) {
+			delete(em.invites, code)
+		}
+	}
+}
+
+// outstandingLocked counts the unexpired invites of referrerID; callers
+// must hold em.mu and have purged expired invites
+func (em *EnrollmentManager) outstandingLocked(referrerID string) int {
+	count := 0
+	for _, invite := range em.invites {
+		if cryptopkg.ConstantTimeEqualString(invite.ReferrerID, referrerID) {
+			count++
+		}
+	}
+	return count
+}
+
+// randomToken returns a URL-safe random token
+func randomToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// VerifySignature checks a signature over SHA-256(data) for the supported key types
+func VerifySignature(pub crypto.PublicKey, data, signature []byte) error {
+	digest := sha256.Sum256(data)
+
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature)
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(key, digest[:], signature) {
+			return ErrChallengeFailed
+		}
+		return nil
+	case ed25519.PublicKey:
+		if !ed25519.Verify(key, data, signature) {
+			return ErrChallengeFailed
+		}
+		return nil
+	default:
+		return ErrUnsupportedKey
+	}
+}
@@ -0,0 +1,309 @@
+package certmanager
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+var (
+	testCAOnce sync.Once
+	testCA     *CertificateAuthority
+	testCAErr  error
+)
+
+// newTestCA returns a CA shared by the tests in this package, with a key of
+// the default algorithm
+func newTestCA(t *testing.T) *CertificateAuthority {
+	t.Helper()
+	testCAOnce.Do(func() {
+		dir, err := os.MkdirTemp("", "certmanager-test")
+		if err != nil {
+			testCAErr = err
+			return
+		}
+		testCA, testCAErr = NewCertificateAuthority(
+			filepath.Join(dir, "ca.crt"),
+			filepath.Join(dir, "ca.key"),
+			"Test Org",
+		)
+	})
+	if testCAErr != nil {
+		t.Fatalf("Failed to create CA: %v", testCAErr)
+	}
+	return testCA
+}
+
+// newTestCSR creates a CSR and returns it along with its private key
+func newTestCSR(t *testing.T, commonName string) (*x509.CertificateRequest, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: commonName},
+	}, key)
+	if err != nil {
+		t.Fatalf("Failed to create CSR: %v", err)
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		t.Fatalf("Failed to parse CSR: %v", err)
+	}
+	return csr, key
+}
+
+func signToken(t *testing.T, key crypto.Signer, token string) []byte {
+	t.Helper()
+	digest := sha256.Sum256([]byte(token))
+	sig, err := key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+	return sig
+}
+
+func TestEnrollmentCertificateRotation(t *testing.T) {
+	ca := newTestCA(t)
+	em := NewEnrollmentManager(ca, time.Minute, time.Hour, 30)
+
+	// Existing service certificate referred by "parent"
+	csr, key := newTestCSR(t, "bridge-bot")
+	current, err := ca.SignCSR(csr, "parent", 30)
+	if err != nil {
+		t.Fatalf("Failed to sign initial certificate: %v", err)
+	}
+
+	order, err := em.NewOrderForCertificate(current)
+	if err != nil {
+		t.Fatalf("Failed to create order: %v", err)
+	}
+	if order.Status != OrderStatusPending {
+		t.Errorf("New order should be pending, got %s", order.Status)
+	}
+
+	// Finalizing before the challenge is solved must fail
+	newCSR, _ := newTestCSR(t, "bridge-bot")
+	if _, _, err := em.Finalize(order.ID, newCSR); err != ErrOrderNotReady {
+		t.Errorf("Expected ErrOrderNotReady, got %v", err)
+	}
+
+	order, err = em.RespondToChallenge(order.ID, signToken(t, key, order.Token))
+	if err != nil {
+		t.Fatalf("Challenge should verify: %v", err)
+	}
+	if order.Status != OrderStatusReady {
+		t.Errorf("Order should be ready after challenge, got %s", order.Status)
+	}
+
+	cert, referrerID, err := em.Finalize(order.ID, newCSR)
+	if err != nil {
+		t.Fatalf("Failed to finalize order: %v", err)
+	}
+	if referrerID != "parent" {
+		t.Errorf("Rotated certificate should keep referrer, got %q", referrerID)
+	}
+	if got, _ := ExtractReferrerID(cert); got != "parent" {
+		t.Errorf("Issued certificate referrer incorrect: got %q", got)
+	}
+
+	// An order can only be finalized once
+	if _, _, err := em.Finalize(order.ID, newCSR); err != ErrOrderNotReady {
+		t.Errorf("Second finalize should fail with ErrOrderNotReady, got %v", err)
+	}
+}
+
+func TestEnrollmentChallengeWrongKey(t *testing.T) {
+	ca := newTestCA(t)
+	em := NewEnrollmentManager(ca, time.Minute, time.Hour, 30)
+
+	csr, _ := newTestCSR(t, "bot")
+	current, err := ca.SignCSR(csr, "", 30)
+	if err != nil {
+		t.Fatalf("Failed to sign certificate: %v", err)
+	}
+
+	order, err := em.NewOrderForCertificate(current)
+	if err != nil {
+		t.Fatalf("Failed to create order: %v", err)
+	}
+
+	_, otherKey := newTestCSR(t, "attacker")
+	if _, err := em.RespondToChallenge(order.ID, signToken(t, otherKey, order.Token)); err != ErrChallengeFailed {
+		t.Errorf("Expected ErrChallengeFailed, got %v", err)
+	}
+
+	order, _ = em.GetOrder(order.ID)
+	if order.Status != OrderStatusInvalid {
+		t.Errorf("Failed challenge should invalidate order, got %s", order.Status)
+	}
+}
+
+func TestEnrollmentInvite(t *testing.T) {
+	ca := newTestCA(t)
+	em := NewEnrollmentManager(ca, time.Minute, time.Hour, 30)
+
+	invite, err := em.CreateInvite("referrer-1")
+	if err != nil {
+		t.Fatalf("Failed to create invite: %v", err)
+	}
+
+	order, err := em.NewOrderForInvite(invite.Code)
+	if err != nil {
+		t.Fatalf("Failed to redeem invite: %v", err)
+	}
+	if order.Status != OrderStatusReady {
+		t.Errorf("Invite order should be ready immediately, got %s", order.Status)
+	}
+
+	// Invites are single use
+	if _, err := em.NewOrderForInvite(invite.Code); err != ErrInvalidInvite {
+		t.Errorf("Reused invite should fail with ErrInvalidInvite, got %v", err)
+	}
+
+	csr, _ := newTestCSR(t, "service")
+	_, referrerID, err := em.Finalize(order.ID, csr)
+	if err != nil {
+		t.Fatalf("Failed to finalize invite order: %v", err)
+	}
+	if referrerID != "referrer-1" {
+		t.Errorf("Invite order referrer incorrect: got %q", referrerID)
+	}
+}
+
+func TestEnrollmentInviteLimit(t *testing.T) {
+	ca := newTestCA(t)
+	em := NewEnrollmentManager(ca, time.Minute, time.Hour, 30)
+
+	if _, limited := em.RemainingInvites("referrer"); limited {
+		t.Error("Invites should be unlimited by default")
+	}
+
+	em.SetInviteLimit(2)
+	first, err := em.CreateInvite("referrer")
+	if err != nil {
+		t.Fatalf("Failed to create invite: %v", err)
+	}
+	if _, err := em.CreateInvite("referrer"); err != nil {
+		t.Fatalf("Failed to create invite: %v", err)
+	}
+	if remaining, limited := em.RemainingInvites("referrer"); !limited || remaining != 0 {
+		t.Errorf("RemainingInvites = %d %v, want 0 true", remaining, limited)
+	}
+	if _, err := em.CreateInvite("referrer"); err != ErrInviteLimit {
+		t.Errorf("Invite over the limit should fail with ErrInviteLimit, got %v", err)
+	}
+	if _, err := em.CreateInvite("other"); err != nil {
+		t.Errorf("The limit should apply per referrer, got %v", err)
+	}
+
+	// Redeeming an invite frees its place
+	if _, err := em.NewOrderForInvite(first.Code); err != nil {
+		t.Fatalf("Failed to redeem invite: %v", err)
+	}
+	if remaining, _ := em.RemainingInvites("referrer"); remaining != 1 {
+		t.Errorf("RemainingInvites after a redemption = %d, want 1", remaining)
+	}
+}
+
+func TestEnrollmentPendingLimit(t *testing.T) {
+	ca := newTestCA(t)
+	em := NewEnrollmentManager(ca, time.Minute, time.Hour, 30)
+	em.SetPendingLimit(2)
+
+	invite, err := em.CreateInvite("referrer")
+	if err != nil {
+		t.Fatalf("Failed to create invite: %v", err)
+	}
+	if _, err := em.CreateInvite("other"); err != nil {
+		t.Fatalf("Failed to create invite: %v", err)
+	}
+	if _, err := em.CreateInvite("third"); err != ErrEnrollmentBusy {
+		t.Errorf("Invite over the pending limit should fail with ErrEnrollmentBusy, got %v", err)
+	}
+
+	// Redeeming an invite at the limit replaces it with its order
+	if _, err := em.NewOrderForInvite(invite.Code); err != nil {
+		t.Fatalf("Failed to redeem invite at the limit: %v", err)
+	}
+	if _, err := em.CreateInvite("third"); err != ErrEnrollmentBusy {
+		t.Errorf("The order should still count towards the limit, got %v", err)
+	}
+}
+
+func TestEnrollmentOrderExpiry(t *testing.T) {
+	ca := newTestCA(t)
+	em := NewEnrollmentManager(ca, 10*time.Millisecond, 10*time.Millisecond, 30)
+
+	invite, err := em.CreateInvite("referrer")
+	if err != nil {
+		t.Fatalf("Failed to create invite: %v", err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	if _, err := em.NewOrderForInvite(invite.Code); err != ErrInvalidInvite {
+		t.Errorf("Expired invite should fail with ErrInvalidInvite, got %v", err)
+	}
+
+	csr, _ := newTestCSR(t, "bot")
+	current, _ := ca.SignCSR(csr, "", 30)
+	order, err := em.NewOrderForCertificate(current)
+	if err != nil {
+		t.Fatalf("Failed to create order: %v", err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	if _, err := em.GetOrder(order.ID); err != ErrOrderNotFound {
+		t.Errorf("Expired order should fail with ErrOrderNotFound, got %v", err)
+	}
+}
+
+func TestEnrollmentOrderLimit(t *testing.T) {
+	ca := newTestCA(t)
+	em := NewEnrollmentManager(ca, time.Minute, time.Hour, 30)
+	em.SetPendingLimit(10)
+	em.SetOrderLimit(2)
+
+	csr, _ := newTestCSR(t, "busy-bot")
+	busy, err := ca.SignCSR(csr, "parent", 30)
+	if err != nil {
+		t.Fatalf("Failed to sign certificate: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := em.NewOrderForCertificate(busy); err != nil {
+			t.Fatalf("Failed to create order: %v", err)
+		}
+	}
+	if _, err := em.NewOrderForCertificate(busy); err != ErrOrderLimit {
+		t.Errorf("Order over the certificate limit should fail with ErrOrderLimit, got %v", err)
+	}
+
+	// Other certificates and invites still find room under the pending limit
+	csr, _ = newTestCSR(t, "quiet-bot")
+	quiet, err := ca.SignCSR(csr, "parent", 30)
+	if err != nil {
+		t.Fatalf("Failed to sign certificate: %v", err)
+	}
+	if _, err := em.NewOrderForCertificate(quiet); err != nil {
+		t.Errorf("The limit should apply per certificate, got %v", err)
+	}
+	invite, err := em.CreateInvite("referrer")
+	if err != nil {
+		t.Fatalf("Failed to create invite: %v", err)
+	}
+	if _, err := em.NewOrderForInvite(invite.Code); err != nil {
+		t.Errorf("Invite orders should not count towards a certificate's limit, got %v", err)
+	}
+}
@@ -0,0 +1,237 @@
+package certmanager
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Fingerprint list kinds
+const (
+	FingerprintAllow = "allow"
+	FingerprintDeny  = "deny"
+)
+
+var (
+	// ErrFingerprintDenied is returned for a certificate on the denylist
+	ErrFingerprintDenied = errors.New("certificate fingerprint is denied")
+	// ErrInvalidFingerprint is returned for a malformed SPKI fingerprint
+	ErrInvalidFingerprint = errors.New("invalid SPKI fingerprint")
+	// ErrFingerprintNotFound is returned when removing an unlisted fingerprint
+	ErrFingerprintNotFound = errors.New("fingerprint not listed")
+)
+
+// FingerprintEntry is one allowed or denied SPKI fingerprint
+type FingerprintEntry struct {
+	Fingerprint string    `json:"fingerprint"`
+	List        string    `json:"list"`
+	Note        string    `json:"note,omitempty"`
+	Added       time.Time `json:"added"`
+}
+
+// fingerprintSnapshot is an immutable view of the lists for handshakes
+type fingerprintSnapshot struct {
+	allowed map[string]struct{}
+	denied  map[string]struct{}
+}
+
+// FingerprintList is an admin-managed allowlist and denylist of certificate
+// SPKI fingerprints, consulted independently of the CA and referral tree.
+// Denied keys are rejected during the handshake; allowed keys can be
+// required for admin endpoints. Lookups read a lock-free snapshot.
+type FingerprintList struct {
+	entries  map[string]*FingerprintEntry
+	snapshot atomic.Pointer[fingerprintSnapshot]
+	path     string
+	mu       sync.Mutex
+}
+
+// SPKIFingerprint returns the hex SHA-256 of a certificate's public key
+func SPKIFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return hex.EncodeToString(sum[:])
+}
+
+// NewFingerprintList creates a fingerprint list. If path is not empty the
+// list is loaded from it, when it exists, and saved to it on every change.
+func NewFingerprintList(path string) (*FingerprintList, error) {
+	fl := &FingerprintList{
+		entries: make(map[string]*FingerprintEntry),
+		path:    path,
+	}
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		switch {
+		case os.IsNotExist(err):
+		case err != nil:
+			return nil, err
+		default:
+			var entries []FingerprintEntry
+			if err := json.Unmarshal(data, &entries); err != nil {
+				return nil, err
+			}
+			for i := range entries {
+				entry := entries[i]
+				fl.entries[entry.Fingerprint] = &entry
+			}
+		}
+	}
+
+	fl.publishLocked()
+	return fl, nil
+}
+
+// Entries lists the allowed and denied fingerprints ordered by fingerprint
+func (fl *FingerprintList) Entries() []FingerprintEntry {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+
+	entries := make([]FingerprintEntry, 0, len(fl.entries))
+	for _, entry := range fl.entries {
+		entries = append(entries, *entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Fingerprint < entries[j].Fingerprint
+	})
+	return entries
+}
+
+// Allow adds a fingerprint to the allowlist, moving it off the denylist
+func (fl *FingerprintList) Allow(fingerprint, note string) (FingerprintEntry, error) {
+	return fl.set(fingerprint, FingerprintAllow, note)
+}
+
+// Deny adds a fingerprint to the denylist, moving it off the allowlist
+func (fl *FingerprintList) Deny(fingerprint, note string) (FingerprintEntry, error) {
+	return fl.set(fingerprint, FingerprintDeny, note)
+}
+
+// Remove takes a fingerprint off either list
+func (fl *FingerprintList) Remove(fingerprint string) error {
+	fingerprint, err := normalizeFingerprint(fingerprint)
+	if err != nil {
+		return err
+	}
+
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+
+	entry, exists := fl.entries[fingerprint]
+	if !exists {
+		return ErrFingerprintNotFound
+	}
+	delete(fl.entries, fingerprint)
+	if err := fl.saveLocked(); err != nil {
+		fl.entries[fingerprint] = entry
+		return err
+	}
+
+	fl.publishLocked()
+	log.Printf("Fingerprint %s removed from the %slist", fingerprint, entry.List)
+	return nil
+}
+
+// Check returns ErrFingerprintDenied if the certificate's key is denied
+func (fl *FingerprintList) Check(cert *x509.Certificate) error {
+	if _, denied := fl.snapshot.Load().denied[SPKIFingerprint(cert)]; denied {
+		return ErrFingerprintDenied
+	}
+	return nil
+}
+
+// IsAllowed reports whether the certificate's key is on the allowlist
+func (fl *FingerprintList) IsAllowed(cert *x509.Certificate) bool {
+	_, allowed := fl.snapshot.Load().allowed[SPKIFingerprint(cert)]
+	return allowed
+}
+
+// set records a fingerprint on one list
+func (fl *FingerprintList) set(fingerprint, list, note string) (FingerprintEntry, error) {
+	fingerprint, err := normalizeFingerprint(fingerprint)
+	if err != nil {
+		return FingerprintEntry{}, err
+	}
+
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+
+	previous := fl.entries[fingerprint]
+	entry := &FingerprintEntry{
+		Fingerprint: fingerprint,
+		List:        list,
+		Note:        note,
+		Added:       time.Now().UTC(),
+	}
+	fl.entries[fingerprint] = entry
+	if err := fl.saveLocked(); err != nil {
+		if previous != nil {
+			fl.entries[fingerprint] = previous
+		} else {
+			delete(fl.entries, fingerprint)
+		}
+		return FingerprintEntry{}, err
+	}
+
+	fl.publishLocked()
+	log.Printf("Fingerprint %s added to the %slist", fingerprint, list)
+	return *entry, nil
+}
+
+// publishLocked rebuilds the snapshot read by Check and IsAllowed. Callers
+// hold fl.mu.
+func (fl *FingerprintList) publishLocked() {
+	snapshot := &fingerprintSnapshot{
+		allowed: make(map[string]struct{}),
+		denied:  make(map[string]struct{}),
+	}
+	for fingerprint, entry := range fl.entries {
+		if entry.List == FingerprintDeny {
+			snapshot.denied[fingerprint] = struct{}{}
+		} else {
+			snapshot.allowed[fingerprint] = struct{}{}
+		}
+	}
+	fl.snapshot.Store(snapshot)
+}
+
+// saveLocked writes the list to its file, replacing it atomically. Callers
+// hold fl.mu.
+func (fl *FingerprintList) saveLocked() error {
+	if fl.path == "" {
+		return nil
+	}
+
+	entries := make([]FingerprintEntry, 0, len(fl.entries))
+	for _, entry := range fl.entries {
+		entries = append(entries, *entry)
+	}
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := fl.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, fl.path)
+}
+
+// normalizeFingerprint accepts hex SHA-256 fingerprints with or without colons
+func normalizeFingerprint(fingerprint string) (string, error) {
+	fingerprint = strings.ToLower(strings.ReplaceAll(fingerprint, ":", ""))
+	if raw, err := hex.DecodeString(fingerprint); err != nil || len(raw) != sha256.Size {
+		return "", ErrInvalidFingerprint
+	}
+	return fingerprint, nil
+}
@@ -0,0 +1,80 @@
+package certmanager
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFingerprintListAllowDeny(t *testing.T) {
+	cert := newSelfSignedCert(t, "client", false)
+	fingerprint := SPKIFingerprint(cert)
+
+	fl, err := NewFingerprintList("")
+	if err != nil {
+		t.Fatalf("Failed to create list: %v", err)
+	}
+	if fl.Check(cert) != nil || fl.IsAllowed(cert) {
+		t.Fatalf("An empty list should neither deny nor allow")
+	}
+
+	// Colon-separated upper case fingerprints are accepted
+	var colons []string
+	for i := 0; i < len(fingerprint); i += 2 {
+		colons = append(colons, strings.ToUpper(fingerprint[i:i+2]))
+	}
+	if _, err := fl.Deny(strings.Join(colons, ":"), "lost laptop"); err != nil {
+		t.Fatalf("Deny failed: %v", err)
+	}
+	if fl.Check(cert) != ErrFingerprintDenied {
+		t.Errorf("Denied certificate passed the check")
+	}
+
+	// Allowing moves the key off the denylist
+	if _, err := fl.Allow(fingerprint, ""); err != nil {
+		t.Fatalf("Allow failed: %v", err)
+	}
+	if fl.Check(cert) != nil || !fl.IsAllowed(cert) {
+		t.Errorf("Allowed certificate should pass and be allowlisted")
+	}
+	if entries := fl.Entries(); len(entries) != 1 || entries[0].List != FingerprintAllow {
+		t.Errorf("Expected one allow entry, got %+v", entries)
+	}
+
+	if err := fl.Remove(fingerprint); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if fl.IsAllowed(cert) {
+		t.Errorf("Removed fingerprint is still allowed")
+	}
+	if err := fl.Remove(fingerprint); err != ErrFingerprintNotFound {
+		t.Errorf("Expected ErrFingerprintNotFound, got %v", err)
+	}
+	if _, err := fl.Deny("abc", ""); err != ErrInvalidFingerprint {
+		t.Errorf("Expected ErrInvalidFingerprint, got %v", err)
+	}
+}
+
+func TestFingerprintListPersistence(t *testing.T) {
+	cert := newSelfSignedCert(t, "client", false)
+	path := filepath.Join(t.TempDir(), "fingerprints.json")
+
+	fl, err := NewFingerprintList(path)
+	if err != nil {
+		t.Fatalf("Failed to create list: %v", err)
+	}
+	if _, err := fl.Deny(SPKIFingerprint(cert), "compromised"); err != nil {
+		t.Fatalf("Deny failed: %v", err)
+	}
+
+	reloaded, err := NewFingerprintList(path)
+	if err != nil {
+		t.Fatalf("Failed to reload list: %v", err)
+	}
+	if reloaded.Check(cert) != ErrFingerprintDenied {
+		t.Errorf("Denylist did not survive a reload")
+	}
+	if entries := reloaded.Entries(); len(entries) != 1 || entries[0].Note != "compromised" {
+		t.Errorf("Unexpected entries after reload: %+v", entries)
+	}
+}
@@ -0,0 +1,205 @@
+package certmanager
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	cryptopkg "github.com/yourusername/secure-messaging-poc/pkg/crypto"
+)
+
+// GraphFormatVersion is the version written to exported referral graphs
+const GraphFormatVersion = 1
+
+// Supported encodings for referral graph documents
+const (
+	GraphFormatJSON = "json"
+	GraphFormatCBOR = "cbor"
+)
+
+var (
+	// ErrGraphVersion is returned for documents of an unknown version
+	ErrGraphVersion = errors.New("unsupported referral graph version")
+
+	// ErrGraphSignature is returned when a document is unsigned, tampered
+	// with, or signed by an untrusted issuer
+	ErrGraphSignature = errors.New("invalid referral graph signature")
+
+	// ErrGraphFormat is returned for unknown encodings
+	ErrGraphFormat = errors.New("unsupported referral graph format")
+)
+
+// GraphDocument is a portable, signed snapshot of the referral mapping and
+// the revocation set. Times are Unix seconds so the document encodes the
+// same way in JSON and CBOR, and the signature covers both.
+type GraphDocument struct {
+	Version     int                 `json:"version"`
+	IssuerID    string              `json:"issuer_id"`
+	GeneratedAt int64               `json:"generated_at"`
+	Referrals   map[string][]string `json:"referrals"`
+	Revoked     map[string]int64    `json:"revoked"`
+	Signature   []byte              `json:"signature,omitempty"`
+}
+
+// ExportGraph returns an unsigned snapshot of the referral graph
+func (rm *RevocationManager) ExportGraph() *GraphDocument {
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+
+	doc := &GraphDocument{
+		Version:     GraphFormatVersion,
+		GeneratedAt: time.Now().Unix(),
+		Referrals:   make(map[string][]string, len(rm.referrerMapping)),
+		Revoked:     make(map[string]int64, len(rm.revokedCerts)),
+	}
+
+	for referrerID, children := range rm.referrerMapping {
+		seen := make(map[string]bool, len(children))
+		unique := make([]string, 0, len(children))
+		for _, childID := range children {
+			if !seen[childID] {
+				seen[childID] = true
+				unique = append(unique, childID)
+			}
+		}
+		sort.Strings(unique)
+		doc.Referrals[referrerID] = unique
+	}
+
+	for certID, revokedAt := range rm.revokedCerts {
+		doc.Revoked[certID] = revokedAt.Unix()
+	}
+
+	return doc
+}
+
+// MergeGraph merges a verified document into the local graph. Merging is
+// idempotent: known edges are skipped and a certificate revoked on both
+// sides keeps the earlier revocation time. Edges that would close a cycle
+// with the local graph or earlier edges of the document are dropped. It returns the number of
+// referral edges and revocations that were new.
+func (rm *RevocationManager) MergeGraph(doc *GraphDocument) (referrals, revocations int) {
+	rm.mu.Lock()
+	defer rm.unlockAndNotify(len(rm.events))
+
+	for referrerID, children := range doc.Referrals {
+		referrerID = rm.resolveLocked(referrerID)
+		for _, childID := range children {
+			childID = rm.resolveLocked(childID)
+			if rm.addReferralLocked(referrerID, childID, time.Time{}) {
+				referrals++
+			}
+		}
+	}
+	rm.boundReferralsLocked(rm.now())
+
+	for certID, revokedAt := range doc.Revoked {
+		certID = rm.resolveLocked(certID)
+		remote := time.Unix(revokedAt, 0)
+		local, exists := rm.revokedCerts[certID]
+		if !exists {
+			rm.revokedCerts[certID] = remote
+			rm.recordLocked(certID, remote, "")
+			revocations++
+		} else if remote.Before(local) {
+			rm.revokedCerts[certID] = remote
+		}
+	}
+	if revocations > 0 {
+		rm.publishLocked()
+	}
+
+	return referrals, revocations
+}
+
+// SignGraph stamps the document with this CA's ID and signs it with the CA key
+func (ca *CertificateAuthority) SignGraph(doc *GraphDocument) error {
+	if ca.caCert == nil || ca.caPrivKey == nil {
+		return ErrCANotInitialized
+	}
+
+	doc.IssuerID = CertificateID(ca.caCert)
+
+	data, err := doc.signingBytes()
+	if err != nil {
+		return err
+	}
+	doc.Signature, err = signSHA256(ca.caPrivKey, data)
+	return err
+}
+
+// VerifyGraph checks the document version and that it was signed by one of
+// the trusted issuer certificates
+func VerifyGraph(doc *GraphDocument, issuers []*x509.Certificate) error {
+	if doc.Version != GraphFormatVersion {
+		return ErrGraphVersion
+	}
+	if len(doc.Signature) == 0 {
+		return ErrGraphSignature
+	}
+
+	data, err := doc.signingBytes()
+	if err != nil {
+		return err
+	}
+
+	for _, issuer := range issuers {
+		if !cryptopkg.ConstantTimeEqualString(CertificateID(issuer), doc.IssuerID) {
+			continue
+		}
+		if err := VerifySignature(issuer.PublicKey, data, doc.Signature); err != nil {
+			return ErrGraphSignature
+		}
+		return nil
+	}
+
+	return ErrGraphSignature
+}
+
+// MarshalGraph encodes a document in the given format
+func MarshalGraph(doc *GraphDocument, format string) ([]byte, error) {
+	switch format {
+	case GraphFormatJSON:
+		return json.Marshal(doc)
+	case GraphFormatCBOR:
+		return cbor.Marshal(doc)
+	default:
+		return nil, ErrGraphFormat
+	}
+}
+
+// UnmarshalGraph decodes a document in the given format
+func UnmarshalGraph(data []byte, format string) (*GraphDocument, error) {
+	var doc GraphDocument
+
+	var err error
+	switch format {
+	case GraphFormatJSON:
+		err = json.Unmarshal(data, &doc)
+	case GraphFormatCBOR:
+		err = cbor.Unmarshal(data, &doc)
+	default:
+		return nil, ErrGraphFormat
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if doc.Version != GraphFormatVersion {
+		return nil, ErrGraphVersion
+	}
+
+	return &doc, nil
+}
+
+// signingBytes returns the canonical encoding covered by the signature: the
+// JSON form of the document without its signature. Map keys are sorted by
+// encoding/json, so the encoding is deterministic.
+func (doc *GraphDocument) signingBytes() ([]byte, error) {
+	unsigned := *doc
+	unsigned.Signature = nil
+	return json.Marshal(&unsigned)
+}
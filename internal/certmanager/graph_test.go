@@ -0,0 +1,117 @@
+package certmanager
+
+import (
+	"crypto/x509"
+	"testing"
+	"time"
+)
+
+func TestGraphExportImport(t *testing.T) {
+	ca := newTestCA(t)
+	caCert, _ := ca.GetCACertificate()
+
+	src := NewRevocationManager()
+	src.RegisterCertificate("child1", "root")
+	src.RegisterCertificate("child2", "root")
+	src.RegisterCertificate("grandchild", "child1")
+	src.Revoke("child2")
+
+	doc := src.ExportGraph()
+	if err := ca.SignGraph(doc); err != nil {
+		t.Fatalf("Failed to sign graph: %v", err)
+	}
+
+	for _, format := range []string{GraphFormatJSON, GraphFormatCBOR} {
+		data, err := MarshalGraph(doc, format)
+		if err != nil {
+			t.Fatalf("Failed to marshal %s graph: %v", format, err)
+		}
+
+		decoded, err := UnmarshalGraph(data, format)
+		if err != nil {
+			t.Fatalf("Failed to unmarshal %s graph: %v", format, err)
+		}
+		if err := VerifyGraph(decoded, []*x509.Certificate{caCert}); err != nil {
+			t.Fatalf("Signature should verify after %s round trip: %v", format, err)
+		}
+
+		dst := NewRevocationManager()
+		referrals, revocations := dst.MergeGraph(decoded)
+		if referrals != 3 || revocations != 1 {
+			t.Errorf("First merge should add 3 edges and 1 revocation, got %d and %d", referrals, revocations)
+		}
+		if dst.GetChildCount("root") != 2 || !dst.IsRevoked("child2") {
+			t.Errorf("Merged graph does not match the source")
+		}
+
+		// Merging the same document again changes nothing
+		referrals, revocations = dst.MergeGraph(decoded)
+		if referrals != 0 || revocations != 0 || dst.GetChildCount("root") != 2 {
+			t.Errorf("Second merge should be a no-op, added %d and %d", referrals, revocations)
+		}
+	}
+}
+
+func TestGraphMergeKeepsEarliestRevocation(t *testing.T) {
+	rm := NewRevocationManager()
+	rm.Revoke("cert")
+
+	earlier := time.Now().Add(-48 * time.Hour).Unix()
+	rm.MergeGraph(&GraphDocument{
+		Version: GraphFormatVersion,
+		Revoked: map[string]int64{"cert": earlier},
+	})
+
+	if revokedAt := rm.GetRevokedCertificates()["cert"]; revokedAt.Unix() != earlier {
+		t.Errorf("Merge should keep the earlier revocation time, got %v", revokedAt)
+	}
+}
+
+func TestGraphMergeRejectsCycles(t *testing.T) {
+	rm := NewRevocationManager()
+	rm.RegisterCertificate("b", "a")
+
+	// b -> a would close a cycle with the local edge, c -> c refers itself
+	referrals, _ := rm.MergeGraph(&GraphDocument{
+		Version:   GraphFormatVersion,
+		Referrals: map[string][]string{"b": {"a", "d"}, "c": {"c"}},
+	})
+	if referrals != 1 || rm.IsReferredBy("a", "b") || rm.IsReferredBy("c", "c") {
+		t.Errorf("Only the acyclic edge should be merged, got %d", referrals)
+	}
+
+	// Revoking through the merged graph terminates
+	rm.RevokeWithChildren("a")
+	if !rm.IsRevoked("d") {
+		t.Error("Revocation should reach the merged descendant")
+	}
+}
+
+func TestGraphRejectsTampering(t *testing.T) {
+	ca := newTestCA(t)
+	caCert, _ := ca.GetCACertificate()
+
+	rm := NewRevocationManager()
+	rm.RegisterCertificate("child", "root")
+
+	doc := rm.ExportGraph()
+	if err := ca.SignGraph(doc); err != nil {
+		t.Fatalf("Failed to sign graph: %v", err)
+	}
+
+	doc.Revoked["root"] = time.Now().Unix()
+	if err := VerifyGraph(doc, []*x509.Certificate{caCert}); err != ErrGraphSignature {
+		t.Errorf("Tampered graph should fail verification, got %v", err)
+	}
+
+	// Documents from issuers that are not trusted are refused
+	delete(doc.Revoked, "root")
+	if err := VerifyGraph(doc, nil); err != ErrGraphSignature {
+		t.Errorf("Graph from untrusted issuer should fail verification, got %v", err)
+	}
+
+	doc.Version = GraphFormatVersion + 1
+	if err := VerifyGraph(doc, []*x509.Certificate{caCert}); err != ErrGraphVersion {
+		t.Errorf("Unknown version should be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,80 @@
+package certmanager
+
+import (
+	"crypto/x509"
+	"errors"
+	"time"
+)
+
+// recordSweepInterval is how many recorded certificates pass between sweeps
+// of expired records
+const recordSweepInterval = 1024
+
+var (
+	// ErrReferrerUnknown is returned when validity inheritance is enabled and
+	// the CA has no record of the referrer's certificate
+	ErrReferrerUnknown = errors.New("referrer certificate unknown")
+
+	// ErrReferrerExpired is returned when the referrer's certificate has
+	// already expired
+	ErrReferrerExpired = errors.New("referrer certificate has expired")
+)
+
+// SetValidityInheritance caps the NotAfter of every referred certificate at
+// its referrer's, so no certificate outlives the chain that admitted it. The
+// referrer must be known to the CA, either in its issuance registry or
+// recorded with RecordCertificate; requests naming an unknown referrer are
+// refused. Call before issuing.
+func (ca *CertificateAuthority) SetValidityInheritance(enabled bool) {
+	ca.validityMu.Lock()
+	defer ca.validityMu.Unlock()
+	ca.inheritValidity = enabled
+}
+
+// RecordCertificate remembers when a certificate the CA did not issue
+// expires, so certificates it refers can inherit its validity. Referrers
+// presenting a certificate over TLS should be recorded before their request
+// is signed. Expired records are dropped every recordSweepInterval calls.
+func (ca *CertificateAuthority) RecordCertificate(cert *x509.Certificate) {
+	ca.validityMu.Lock()
+	defer ca.validityMu.Unlock()
+	if ca.notAfter == nil {
+		ca.notAfter = make(map[string]time.Time)
+	}
+	ca.notAfter[CertificateID(cert)] = cert.NotAfter
+
+	ca.recorded++
+	if ca.recorded >= recordSweepInterval {
+		ca.recorded = 0
+		now := time.Now()
+		for certID, notAfter := range ca.notAfter {
+			if !notAfter.After(now) {
+				delete(ca.notAfter, certID)
+			}
+		}
+	}
+}
+
+// inheritedNotAfter returns notAfter, capped at the referrer's expiry when
+// validity inheritance is enabled
+func (ca *CertificateAuthority) inheritedNotAfter(referrerID string, notBefore, notAfter time.Time) (time.Time, error) {
+	ca.validityMu.Lock()
+	defer ca.validityMu.Unlock()
+	if !ca.inheritValidity || referrerID == "" {
+		return notAfter, nil
+	}
+
+	limit, known := ca.notAfter[referrerID]
+	if record, issued := ca.registry.LookupID(referrerID); issued {
+		limit, known = record.NotAfter, true
+	}
+	switch {
+	case !known:
+		return time.Time{}, ErrReferrerUnknown
+	case !limit.After(notBefore):
+		return time.Time{}, ErrReferrerExpired
+	case notAfter.After(limit):
+		return limit, nil
+	}
+	return notAfter, nil
+}
@@ -0,0 +1,71 @@
+package certmanager
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestValidityInheritance(t *testing.T) {
+	ca := newTestCA(t)
+	ca.SetValidityInheritance(true)
+	t.Cleanup(func() { ca.SetValidityInheritance(false) })
+
+	csr, _ := newTestCSR(t, "referrer")
+	referrer, err := ca.SignCSR(csr, "", 30)
+	if err != nil {
+		t.Fatalf("Failed to sign referrer: %v", err)
+	}
+	referrerID := CertificateID(referrer)
+
+	// Issued certificates are known to the CA; a longer validity is capped
+	csr, _ = newTestCSR(t, "child")
+	child, err := ca.SignCSR(csr, referrerID, 365)
+	if err != nil {
+		t.Fatalf("Failed to sign child: %v", err)
+	}
+	if !child.NotAfter.Equal(referrer.NotAfter) {
+		t.Errorf("Child expires %v, referrer %v", child.NotAfter, referrer.NotAfter)
+	}
+
+	// A shorter validity is kept
+	csr, _ = newTestCSR(t, "short")
+	short, err := ca.SignCSR(csr, referrerID, 1)
+	if err != nil {
+		t.Fatalf("Failed to sign short-lived child: %v", err)
+	}
+	if !short.NotAfter.Before(referrer.NotAfter) {
+		t.Errorf("Shorter validity should not be extended: %v", short.NotAfter)
+	}
+
+	csr, _ = newTestCSR(t, "orphan")
+	if _, err := ca.SignCSR(csr, "unknown-referrer", 30); !errors.Is(err, ErrReferrerUnknown) {
+		t.Errorf("Expected ErrReferrerUnknown, got %v", err)
+	}
+
+	// Referrers recorded from elsewhere are refused once expired
+	expired := &x509.Certificate{
+		RawSubjectPublicKeyInfo: []byte("expired referrer"),
+		NotAfter:                time.Now().Add(-time.Hour),
+	}
+	ca.RecordCertificate(expired)
+	if _, err := ca.SignCSR(csr, CertificateID(expired), 30); !errors.Is(err, ErrReferrerExpired) {
+		t.Errorf("Expected ErrReferrerExpired, got %v", err)
+	}
+}
+
+func TestRecordCertificateSweepsExpired(t *testing.T) {
+	ca := &CertificateAuthority{}
+
+	for i := 0; i < recordSweepInterval; i++ {
+		ca.RecordCertificate(&x509.Certificate{
+			RawSubjectPublicKeyInfo: []byte(fmt.Sprintf("expired %d", i)),
+			NotAfter:                time.Now().Add(-time.Hour),
+		})
+	}
+	if n := len(ca.notAfter); n != 0 {
+		t.Errorf("Expired records should have been swept, %d left", n)
+	}
+}
@@ -0,0 +1,39 @@
+package certmanager
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestSignAndVerifyJWS(t *testing.T) {
+	ca := newTestCA(t)
+	caCert, _ := ca.GetCACertificate()
+
+	payload := []byte(`{"bin_mask":"0xFFFFFFFFFFFFF000","pow_difficulty":0}`)
+	token, err := ca.SignJWS(payload)
+	if err != nil {
+		t.Fatalf("Failed to sign JWS: %v", err)
+	}
+
+	verified, err := VerifyJWS(token, caCert)
+	if err != nil {
+		t.Fatalf("Failed to verify JWS: %v", err)
+	}
+	if !bytes.Equal(verified, payload) {
+		t.Errorf("Verified payload mismatch: %s", verified)
+	}
+
+	// Swapping in a different payload breaks the signature
+	parts := strings.Split(token, ".")
+	forgedPayload := base64.RawURLEncoding.EncodeToString([]byte(`{"pow_difficulty":99}`))
+	forged := parts[0] + "." + forgedPayload + "." + parts[2]
+	if _, err := VerifyJWS(forged, caCert); err != ErrInvalidJWS {
+		t.Errorf("Tampered JWS should fail verification, got %v", err)
+	}
+
+	if _, err := VerifyJWS("not-a-jws", caCert); err != ErrInvalidJWS {
+		t.Errorf("Malformed JWS should be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,82 @@
+package certmanager
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+)
+
+// PKCS#7 content type OIDs (RFC 2315)
+var (
+	oidPKCS7Data       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidPKCS7SignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+)
+
+// ErrInvalidPKCS7 is returned for a malformed certificates-only PKCS#7 bundle
+var ErrInvalidPKCS7 = errors.New("invalid PKCS#7 certificate bundle")
+
+// pkcs7ContentInfo is the outer PKCS#7 structure. Content holds the
+// explicit [0] wrapper itself, so its Bytes are the encoded content.
+type pkcs7ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"optional"`
+}
+
+// pkcs7SignedData is a SignedData without signers, used only to carry
+// certificates
+type pkcs7SignedData struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	ContentInfo      pkcs7ContentInfo
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	SignerInfos      asn1.RawValue
+}
+
+// emptySet is an empty DER SET
+var emptySet = asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: []byte{}}
+
+// EncodePKCS7Certificates encodes certificates as a degenerate, certs-only
+// PKCS#7 SignedData, the format of application/pkcs7-mime bundles
+func EncodePKCS7Certificates(certs ...*x509.Certificate) ([]byte, error) {
+	var raw []byte
+	for _, cert := range certs {
+		raw = append(raw, cert.Raw...)
+	}
+
+	signedData, err := asn1.Marshal(pkcs7SignedData{
+		Version:          1,
+		DigestAlgorithms: emptySet,
+		ContentInfo:      pkcs7ContentInfo{ContentType: oidPKCS7Data},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: raw},
+		SignerInfos:      emptySet,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(pkcs7ContentInfo{
+		ContentType: oidPKCS7SignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: signedData},
+	})
+}
+
+// ParsePKCS7Certificates returns the certificates in a bundle produced by
+// EncodePKCS7Certificates
+func ParsePKCS7Certificates(data []byte) ([]*x509.Certificate, error) {
+	var outer pkcs7ContentInfo
+	if rest, err := asn1.Unmarshal(data, &outer); err != nil || len(rest) > 0 || !outer.ContentType.Equal(oidPKCS7SignedData) ||
+		outer.Content.Class != asn1.ClassContextSpecific || outer.Content.Tag != 0 {
+		return nil, ErrInvalidPKCS7
+	}
+
+	var signedData pkcs7SignedData
+	if _, err := asn1.Unmarshal(outer.Content.Bytes, &signedData); err != nil {
+		return nil, ErrInvalidPKCS7
+	}
+
+	certs, err := x509.ParseCertificates(signedData.Certificates.Bytes)
+	if err != nil {
+		return nil, ErrInvalidPKCS7
+	}
+	return certs, nil
+}
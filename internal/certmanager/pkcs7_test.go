@@ -0,0 +1,28 @@
+package certmanager
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPKCS7CertificateBundle(t *testing.T) {
+	leaf := newSelfSignedCert(t, "client", false)
+	ca := newSelfSignedCert(t, "ca", true)
+
+	bundle, err := EncodePKCS7Certificates(leaf, ca)
+	if err != nil {
+		t.Fatalf("Failed to encode bundle: %v", err)
+	}
+
+	certs, err := ParsePKCS7Certificates(bundle)
+	if err != nil {
+		t.Fatalf("Failed to parse bundle: %v", err)
+	}
+	if len(certs) != 2 || !bytes.Equal(certs[0].Raw, leaf.Raw) || !bytes.Equal(certs[1].Raw, ca.Raw) {
+		t.Errorf("Bundle did not round-trip the certificates in order")
+	}
+
+	if _, err := ParsePKCS7Certificates(leaf.Raw); err != ErrInvalidPKCS7 {
+		t.Errorf("Expected ErrInvalidPKCS7 for a bare certificate, got %v", err)
+	}
+}
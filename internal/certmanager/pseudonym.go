@@ -0,0 +1,97 @@
+package certmanager
+
+import (
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// PseudonymScheme is the URI scheme of pseudonym SANs, anono://<hash>
+const PseudonymScheme = "anono"
+
+// pseudonymHashLen is the length of a hex SHA-256 pseudonym hash
+const pseudonymHashLen = 64
+
+var (
+	// ErrInvalidPseudonym is returned for a malformed pseudonym URI
+	ErrInvalidPseudonym = errors.New("invalid pseudonym URI")
+
+	// ErrPseudonymNotAllowed is returned when policy refuses a pseudonym
+	ErrPseudonymNotAllowed = errors.New("pseudonym URI not allowed")
+)
+
+// PseudonymPolicy decides whether a certificate requested under referrerID
+// may be bound to pseudonym. Returning an error refuses the request.
+type PseudonymPolicy func(pseudonym, referrerID string) error
+
+// AllowWellFormedPseudonyms accepts any pseudonym that passes format checks
+func AllowWellFormedPseudonyms(pseudonym, referrerID string) error {
+	return nil
+}
+
+// PseudonymURI returns the SAN URI for a pseudonym hash
+func PseudonymURI(pseudonym string) *url.URL {
+	return &url.URL{Scheme: PseudonymScheme, Host: pseudonym}
+}
+
+// ParsePseudonymURI returns the pseudonym hash of an anono:// URI. The hash
+// must be 64 lowercase hex characters and the URI must carry nothing else.
+func ParsePseudonymURI(u *url.URL) (string, error) {
+	if u == nil || u.Scheme != PseudonymScheme || u.Opaque != "" || u.User != nil ||
+		u.Path != "" || u.RawQuery != "" || u.Fragment != "" || u.Port() != "" {
+		return "", ErrInvalidPseudonym
+	}
+	if len(u.Host) != pseudonymHashLen || strings.ToLower(u.Host) != u.Host {
+		return "", ErrInvalidPseudonym
+	}
+	if _, err := hex.DecodeString(u.Host); err != nil {
+		return "", ErrInvalidPseudonym
+	}
+	return u.Host, nil
+}
+
+// ExtractPseudonym returns the pseudonym a certificate is bound to, if any
+func ExtractPseudonym(cert *x509.Certificate) (string, bool) {
+	for _, u := range cert.URIs {
+		if pseudonym, err := ParsePseudonymURI(u); err == nil {
+			return pseudonym, true
+		}
+	}
+	return "", false
+}
+
+// SetPseudonymPolicy enables pseudonym URI SANs in issued certificates,
+// checked by policy. With no policy, CSRs requesting one are refused. Call
+// before issuing.
+func (ca *CertificateAuthority) SetPseudonymPolicy(policy PseudonymPolicy) {
+	ca.pseudonymPolicy = policy
+}
+
+// pseudonymURIs validates the URI SANs of a CSR and returns those to embed.
+// Only a single pseudonym URI may be requested.
+func (ca *CertificateAuthority) pseudonymURIs(csr *x509.CertificateRequest, referrerID string) ([]*url.URL, error) {
+	if len(csr.URIs) == 0 {
+		return nil, nil
+	}
+	if len(csr.URIs) > 1 {
+		return nil, ErrInvalidPseudonym
+	}
+
+	pseudonym, err := ParsePseudonymURI(csr.URIs[0])
+	if err != nil {
+		return nil, err
+	}
+	if ca.pseudonymPolicy == nil {
+		return nil, ErrPseudonymNotAllowed
+	}
+	if err := ca.pseudonymPolicy(pseudonym, referrerID); err != nil {
+		if errors.Is(err, ErrPseudonymNotAllowed) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %v", ErrPseudonymNotAllowed, err)
+	}
+	return []*url.URL{PseudonymURI(pseudonym)}, nil
+}
@@ -0,0 +1,211 @@
+package certmanager
+
+import (
+	"container/list"
+	"time"
+
+	cryptopkg "github.com/yourusername/secure-messaging-poc/pkg/crypto"
+)
+
+// referralSweepInterval is how many registrations pass between sweeps of
+// expired referrals
+const referralSweepInterval = 1024
+
+// referralKey identifies one edge of the referral graph
+type referralKey struct {
+	referrerID string
+	certID     string
+}
+
+// referral is an edge of the referral graph in least recently registered
+// order. A zero notAfter means the child's expiry is unknown.
+type referral struct {
+	referralKey
+	notAfter time.Time
+}
+
+// SetReferralLimit bounds the referral edges kept in memory. Edges of
+// expired certificates are dropped first; when that is not enough the
+// least recently registered edges go, and revoking their referrer no longer
+// reaches them. A limit of zero keeps every edge.
+func (rm *RevocationManager) SetReferralLimit(limit int) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	rm.maxReferrals = limit
+	rm.boundReferralsLocked(rm.now())
+}
+
+// RegisterCertificateUntil registers a certificate with its referrer and
+// drops the referral once the certificate expires at notAfter. Registering
+// a known referral again, as every reconnect does, marks it recently used.
+func (rm *RevocationManager) RegisterCertificateUntil(certID, referrerID string, notAfter time.Time) {
+	if referrerID == "" {
+		return // No referrer to register
+	}
+
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	now := rm.now()
+	if !notAfter.IsZero() && !notAfter.After(now) {
+		return
+	}
+	rm.addReferralLocked(rm.resolveLocked(referrerID), rm.resolveLocked(certID), notAfter)
+
+	rm.registrations++
+	if rm.registrations >= referralSweepInterval {
+		rm.registrations = 0
+		rm.pruneExpiredLocked(now)
+	}
+	rm.boundReferralsLocked(now)
+}
+
+// IsReferredBy reports whether the referral graph records referrerID as the
+// referrer of certID
+func (rm *RevocationManager) IsReferredBy(certID, referrerID string) bool {
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+	key := referralKey{referrerID: rm.resolveLocked(referrerID), certID: rm.resolveLocked(certID)}
+	_, exists := rm.referrals[key]
+	return exists
+}
+
+// ReferralCount returns the number of referral edges kept in memory
+func (rm *RevocationManager) ReferralCount() int {
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+	return rm.referralOrder.Len()
+}
+
+// PruneExpiredReferrals drops the referrals of expired certificates and
+// returns how many were dropped
+func (rm *RevocationManager) PruneExpiredReferrals() int {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	return rm.pruneExpiredLocked(rm.now())
+}
+
+// addReferralLocked records an edge, or marks a known one recently used and
+// updates its expiry if notAfter is known; callers must hold rm.mu for
+// writing. It reports whether the edge is new. An edge that would close a
+// cycle, including a self-referral, is refused.
+func (rm *RevocationManager) addReferralLocked(referrerID, certID string, notAfter time.Time) bool {
+	key := referralKey{referrerID: referrerID, certID: certID}
+	if elem, exists := rm.referrals[key]; exists {
+		if !notAfter.IsZero() {
+			elem.Value.(*referral).notAfter = notAfter
+		}
+		rm.referralOrder.MoveToBack(elem)
+		return false
+	}
+	if rm.createsCycleLocked(referrerID, certID) {
+		return false
+	}
+
+	rm.referrals[key] = rm.referralOrder.PushBack(&referral{referralKey: key, notAfter: notAfter})
+	rm.referrerMapping[referrerID] = append(rm.referrerMapping[referrerID], certID)
+	return true
+}
+
+// createsCycleLocked reports whether an edge from referrerID to certID
+// would close a cycle, that is whether certID is referrerID or refers it
+// through the graph; callers must hold rm.mu
+func (rm *RevocationManager) createsCycleLocked(referrerID, certID string) bool {
+	visited := make(map[string]bool)
+	pending := []string{certID}
+	for len(pending) > 0 {
+		id := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if cryptopkg.ConstantTimeEqualString(id, referrerID) {
+			return true
+		}
+		if visited[id] {
+			continue
+		}
+		visited[id] = true
+		pending = append(pending, rm.referrerMapping[id]...)
+	}
+	return false
+}
+
+// removeReferralLocked drops an edge from the graph; callers must hold
+// rm.mu for writing
+func (rm *RevocationManager) removeReferralLocked(elem *list.Element) {
+	ref := rm.referralOrder.Remove(elem).(*referral)
+	delete(rm.referrals, ref.referralKey)
+
+	children := rm.referrerMapping[ref.referrerID]
+	kept := children[:0]
+	for _, childID := range children {
+		if !cryptopkg.ConstantTimeEqualString(childID, ref.certID) {
+			kept = append(kept, childID)
+		}
+	}
+	if len(kept) == 0 {
+		delete(rm.referrerMapping, ref.referrerID)
+	} else {
+		rm.referrerMapping[ref.referrerID] = kept
+	}
+}
+
+// pruneExpiredLocked drops the edges of certificates expired at now;
+// callers must hold rm.mu for writing
+func (rm *RevocationManager) pruneExpiredLocked(now time.Time) int {
+	pruned := 0
+	for elem := rm.referralOrder.Front(); elem != nil; {
+		next := elem.Next()
+		if notAfter := elem.Value.(*referral).notAfter; !notAfter.IsZero() && !notAfter.After(now) {
+			rm.removeReferralLocked(elem)
+			pruned++
+		}
+		elem = next
+	}
+	return pruned
+}
+
+// boundReferralsLocked enforces the referral limit, pruning expired edges
+// before evicting the least recently registered ones; callers must hold
+// rm.mu for writing
+func (rm *RevocationManager) boundReferralsLocked(now time.Time) {
+	if rm.maxReferrals <= 0 || rm.referralOrder.Len() <= rm.maxReferrals {
+		return
+	}
+	rm.pruneExpiredLocked(now)
+	for rm.referralOrder.Len() > rm.maxReferrals {
+		rm.removeReferralLocked(rm.referralOrder.Front())
+	}
+}
+
+// renameReferralsLocked rewrites the edges that name serial to name certID
+// instead, merging edges that become duplicates and dropping ones that
+// become self-referrals, and rebuilds the referrer mapping from them;
+// callers must hold rm.mu for writing
+func (rm *RevocationManager) renameReferralsLocked(serial, certID string) {
+	for elem := rm.referralOrder.Front(); elem != nil; {
+		next := elem.Next()
+		ref := elem.Value.(*referral)
+		renamed := ref.referralKey
+		if cryptopkg.ConstantTimeEqualString(renamed.referrerID, serial) {
+			renamed.referrerID = certID
+		}
+		if cryptopkg.ConstantTimeEqualString(renamed.certID, serial) {
+			renamed.certID = certID
+		}
+		if renamed != ref.referralKey {
+			delete(rm.referrals, ref.referralKey)
+			if _, exists := rm.referrals[renamed]; exists || cryptopkg.ConstantTimeEqualString(renamed.referrerID, renamed.certID) {
+				rm.referralOrder.Remove(elem)
+			} else {
+				ref.referralKey = renamed
+				rm.referrals[renamed] = elem
+			}
+		}
+		elem = next
+	}
+
+	rm.referrerMapping = make(map[string][]string, len(rm.referrerMapping))
+	for elem := rm.referralOrder.Front(); elem != nil; elem = elem.Next() {
+		ref := elem.Value.(*referral)
+		rm.referrerMapping[ref.referrerID] = append(rm.referrerMapping[ref.referrerID], ref.certID)
+	}
+}
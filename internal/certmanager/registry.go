@@ -0,0 +1,231 @@
+package certmanager
+
+import (
+	"bufio"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Roles recorded for issued certificates, from their extended key usages
+const (
+	RoleClient = "client"
+	RoleServer = "server"
+)
+
+// IssuedCertificate is the CA's record of a certificate it signed
+type IssuedCertificate struct {
+	Seq           uint64    `json:"seq"`
+	Serial        string    `json:"serial"`
+	CertificateID string    `json:"certificate_id"`
+	PublicKeyHash string    `json:"public_key_hash"`
+	ReferrerID    string    `json:"referrer_id,omitempty"`
+	NotBefore     time.Time `json:"not_before"`
+	NotAfter      time.Time `json:"not_after"`
+	Roles         []string  `json:"roles,omitempty"`
+}
+
+// IssuanceRegistry remembers every certificate the CA signs, in issuance
+// order. Records are appended to a JSON lines file when a path is set, so
+// the registry survives restarts; otherwise it is kept in memory only.
+type IssuanceRegistry struct {
+	records    []IssuedCertificate
+	bySerial   map[string]int   // serial -> index into records
+	byID       map[string]int   // certificate ID -> latest record
+	byReferrer map[string][]int // referrer ID -> records it referred
+	path       string
+	mu         sync.RWMutex
+}
+
+// NewIssuanceRegistry creates a registry, loading the records in path when
+// it exists
+func NewIssuanceRegistry(path string) (*IssuanceRegistry, error) {
+	r := &IssuanceRegistry{
+		bySerial:   make(map[string]int),
+		byID:       make(map[string]int),
+		byReferrer: make(map[string][]int),
+		path:       path,
+	}
+	if path == "" {
+		return r, nil
+	}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var record IssuedCertificate
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		r.indexLocked(record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Record adds a signed certificate to the registry
+func (r *IssuanceRegistry) Record(cert *x509.Certificate) (IssuedCertificate, error) {
+	referrerID, _ := ExtractReferrerID(cert)
+	record := IssuedCertificate{
+		Serial:        SerialString(cert),
+		CertificateID: CertificateID(cert),
+		PublicKeyHash: SPKIFingerprint(cert),
+		ReferrerID:    referrerID,
+		NotBefore:     cert.NotBefore.UTC(),
+		NotAfter:      cert.NotAfter.UTC(),
+		Roles:         CertificateRoles(cert),
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	record.Seq = uint64(len(r.records)) + 1
+	if err := r.appendLocked(record); err != nil {
+		return IssuedCertificate{}, err
+	}
+	r.indexLocked(record)
+	return record, nil
+}
+
+// Lookup returns the record of the certificate with the given serial
+func (r *IssuanceRegistry) Lookup(serial string) (IssuedCertificate, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	i, ok := r.bySerial[strings.ToUpper(serial)]
+	if !ok {
+		return IssuedCertificate{}, false
+	}
+	return r.records[i], true
+}
+
+// LookupID returns the record of the latest certificate issued for the key
+// with the given certificate ID
+func (r *IssuanceRegistry) LookupID(certID string) (IssuedCertificate, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	i, ok := r.byID[certID]
+	if !ok {
+		return IssuedCertificate{}, false
+	}
+	return r.records[i], true
+}
+
+// Referred returns the certificates issued under referrerID, oldest first
+func (r *IssuanceRegistry) Referred(referrerID string) []IssuedCertificate {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	indexes := r.byReferrer[referrerID]
+	records := make([]IssuedCertificate, 0, len(indexes))
+	for _, i := range indexes {
+		records = append(records, r.records[i])
+	}
+	return records
+}
+
+// Since returns up to limit records with a sequence number greater than
+// seq, oldest first. A limit of zero returns all of them.
+func (r *IssuanceRegistry) Since(seq uint64, limit int) []IssuedCertificate {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if seq >= uint64(len(r.records)) {
+		return nil
+	}
+	records := r.records[seq:]
+	if limit > 0 && len(records) > limit {
+		records = records[:limit]
+	}
+	return append([]IssuedCertificate(nil), records...)
+}
+
+// RestoreReferrals registers every recorded referral with rm, so the
+// referral tree survives restarts. Referrals of expired certificates are
+// not restored.
+func (r *IssuanceRegistry) RestoreReferrals(rm *RevocationManager) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, record := range r.records {
+		if record.ReferrerID != "" {
+			rm.RegisterCertificateUntil(record.CertificateID, record.ReferrerID, record.NotAfter)
+		}
+	}
+}
+
+// SerialString formats a certificate's serial number as the registry keys
+// it: upper-case hex without separators
+func SerialString(cert *x509.Certificate) string {
+	return strings.ToUpper(cert.SerialNumber.Text(16))
+}
+
+// indexLocked appends record and indexes it; callers hold r.mu for writing
+func (r *IssuanceRegistry) indexLocked(record IssuedCertificate) {
+	i := len(r.records)
+	r.records = append(r.records, record)
+	r.bySerial[record.Serial] = i
+	r.byID[record.CertificateID] = i
+	if record.ReferrerID != "" {
+		r.byReferrer[record.ReferrerID] = append(r.byReferrer[record.ReferrerID], i)
+	}
+}
+
+// appendLocked writes record to the registry file; callers hold r.mu
+func (r *IssuanceRegistry) appendLocked(record IssuedCertificate) error {
+	if r.path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// CertificateRoles lists the roles a certificate's key usages grant, sorted
+func CertificateRoles(cert *x509.Certificate) []string {
+	var roles []string
+	for _, usage := range cert.ExtKeyUsage {
+		switch usage {
+		case x509.ExtKeyUsageClientAuth:
+			roles = append(roles, RoleClient)
+		case x509.ExtKeyUsageServerAuth:
+			roles = append(roles, RoleServer)
+		}
+	}
+	sort.Strings(roles)
+	return roles
+}
@@ -0,0 +1,74 @@
+package certmanager
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestIssuanceRegistryPersistence(t *testing.T) {
+	ca := newTestCA(t)
+	path := filepath.Join(t.TempDir(), "issued.jsonl")
+	registry, err := NewIssuanceRegistry(path)
+	if err != nil {
+		t.Fatalf("Failed to create registry: %v", err)
+	}
+	previous := ca.IssuanceRegistry()
+	ca.SetIssuanceRegistry(registry)
+	t.Cleanup(func() { ca.SetIssuanceRegistry(previous) })
+
+	csr, _ := newTestCSR(t, "referrer")
+	referrer, err := ca.SignCSR(csr, "", 30)
+	if err != nil {
+		t.Fatalf("Failed to sign referrer: %v", err)
+	}
+	referrerID := CertificateID(referrer)
+	csr, _ = newTestCSR(t, "child")
+	child, err := ca.SignCSR(csr, referrerID, 30)
+	if err != nil {
+		t.Fatalf("Failed to sign child: %v", err)
+	}
+	if _, err := ca.IssueServerCertificate([]string{"localhost"}, 30); err != nil {
+		t.Fatalf("Failed to issue server certificate: %v", err)
+	}
+
+	// Reload from disk
+	registry, err = NewIssuanceRegistry(path)
+	if err != nil {
+		t.Fatalf("Failed to reload registry: %v", err)
+	}
+
+	record, ok := registry.Lookup(SerialString(child))
+	if !ok {
+		t.Fatal("Child certificate missing after reload")
+	}
+	if record.CertificateID != CertificateID(child) || record.ReferrerID != referrerID ||
+		record.PublicKeyHash != SPKIFingerprint(child) || !record.NotAfter.Equal(child.NotAfter) {
+		t.Errorf("Unexpected record %+v", record)
+	}
+	if len(record.Roles) != 1 || record.Roles[0] != RoleClient {
+		t.Errorf("Expected client role, got %v", record.Roles)
+	}
+	if byID, ok := registry.LookupID(CertificateID(child)); !ok || byID.Serial != record.Serial {
+		t.Errorf("Lookup by certificate ID returned %+v", byID)
+	}
+
+	referred := registry.Referred(referrerID)
+	if len(referred) != 1 || referred[0].Serial != record.Serial {
+		t.Errorf("Expected the child under its referrer, got %+v", referred)
+	}
+
+	all := registry.Since(0, 0)
+	if len(all) != 3 || all[2].Seq != 3 || all[2].Roles[0] != RoleServer {
+		t.Fatalf("Expected three records in issuance order, got %+v", all)
+	}
+	if rest := registry.Since(1, 1); len(rest) != 1 || rest[0].Seq != 2 {
+		t.Errorf("Since(1, 1) returned %+v", rest)
+	}
+
+	rm := NewRevocationManager()
+	registry.RestoreReferrals(rm)
+	rm.RevokeWithChildren(referrerID)
+	if !rm.IsRevoked(CertificateID(child)) {
+		t.Error("Restored referrals should let revocation reach the child")
+	}
+}
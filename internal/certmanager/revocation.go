@@ -1,60 +1,212 @@
 package certmanager
 
 import (
+	"container/list"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	cryptopkg "github.com/yourusername/secure-messaging-poc/pkg/crypto"
 )
 
 // RevocationManager handles certificate revocation
 type RevocationManager struct {
 	revokedCerts    map[string]time.Time // certificate ID -> revocation time
 	referrerMapping map[string][]string  // referrerID -> []childIDs
+	referrals       map[referralKey]*list.Element
+	referralOrder   *list.List // Referral edges, least recently registered first
+	maxReferrals    int
+	registrations   int // Registrations since expired referrals were swept
+	now             func() time.Time
+	aliases         map[string]string    // legacy serial -> certificate ID
+	events          []RevocationEvent    // Revocations in the order they were learned
+	onRevoke        []func(RevocationEvent)
+	snapshot        atomic.Pointer[revocationSnapshot]
 	mu              sync.RWMutex
 }
 
+// revocationSnapshot is an immutable copy of the state needed to answer
+// IsRevoked, so handshakes never wait on rm.mu
+type revocationSnapshot struct {
+	revoked map[string]struct{}
+	aliases map[string]string
+}
+
+// RevocationEvent is one entry of the revocation delta feed
+type RevocationEvent struct {
+	Seq       uint64
+	CertID    string
+	RevokedAt time.Time
+	Reason    string // One of the Reason constants; empty if none was given
+}
+
 // NewRevocationManager creates a new revocation manager
 func NewRevocationManager() *RevocationManager {
-	return &RevocationManager{
+	rm := &RevocationManager{
 		revokedCerts:    make(map[string]time.Time),
 		referrerMapping: make(map[string][]string),
+		referrals:       make(map[referralKey]*list.Element),
+		referralOrder:   list.New(),
+		aliases:         make(map[string]string),
+		now:             time.Now,
 	}
+	rm.publishLocked()
+	return rm
 }
 
-// RegisterCertificate registers a new certificate with its referrer
-func (rm *RevocationManager) RegisterCertificate(certID, referrerID string) {
-	if referrerID == "" {
-		return // No referrer to register
+// publishLocked replaces the lookup snapshot after revocations or aliases
+// change; callers must hold rm.mu for writing. Readers see the change as
+// soon as the mutating call returns.
+func (rm *RevocationManager) publishLocked() {
+	snapshot := &revocationSnapshot{
+		revoked: make(map[string]struct{}, len(rm.revokedCerts)),
+		aliases: make(map[string]string, len(rm.aliases)),
+	}
+	for certID := range rm.revokedCerts {
+		snapshot.revoked[certID] = struct{}{}
+	}
+	for serial, certID := range rm.aliases {
+		snapshot.aliases[serial] = certID
+	}
+	rm.snapshot.Store(snapshot)
+}
+
+// RegisterAlias records that a legacy serial-number identifier refers to the
+// certificate with the given ID, and moves any state recorded under the serial
+// to the certificate ID. All other methods accept either form afterwards.
+func (rm *RevocationManager) RegisterAlias(serial, certID string) {
+	if serial == "" || certID == "" || cryptopkg.ConstantTimeEqualString(serial, certID) {
+		return
+	}
+	
+	// Called on every handshake; skip the lock once the alias is known
+	if _, exists := rm.snapshot.Load().aliases[serial]; exists {
+		return
 	}
 	
 	rm.mu.Lock()
 	defer rm.mu.Unlock()
 	
-	// Add to referrer mapping
-	if _, exists := rm.referrerMapping[referrerID]; !exists {
-		rm.referrerMapping[referrerID] = make([]string, 0)
+	if _, exists := rm.aliases[serial]; exists {
+		return
+	}
+	rm.aliases[serial] = certID
+	
+	if revokedAt, revoked := rm.revokedCerts[serial]; revoked {
+		delete(rm.revokedCerts, serial)
+		if _, exists := rm.revokedCerts[certID]; !exists {
+			rm.revokedCerts[certID] = revokedAt
+		}
+	}
+	
+	rm.renameReferralsLocked(serial, certID)
+	
+	rm.publishLocked()
+}
+
+// recordLocked appends a revocation to the delta feed; callers must hold
+// rm.mu for writing
+func (rm *RevocationManager) recordLocked(certID string, revokedAt time.Time, reason string) {
+	rm.events = append(rm.events, RevocationEvent{
+		Seq:       uint64(len(rm.events)) + 1,
+		CertID:    certID,
+		RevokedAt: revokedAt,
+		Reason:    reason,
+	})
+}
+
+// OnRevoke registers a callback invoked for every newly revoked
+// certificate, once the revocation is visible to IsRevoked. Callbacks run
+// on the revoking goroutine after rm.mu is released.
+func (rm *RevocationManager) OnRevoke(fn func(RevocationEvent)) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	rm.onRevoke = append(rm.onRevoke, fn)
+}
+
+// unlockAndNotify releases rm.mu, held for writing, then passes the feed
+// entries recorded since it had first entries on to the OnRevoke callbacks
+func (rm *RevocationManager) unlockAndNotify(first int) {
+	callbacks := rm.onRevoke
+	var events []RevocationEvent
+	if len(callbacks) > 0 && first < len(rm.events) {
+		events = append(events, rm.events[first:]...)
 	}
+	rm.mu.Unlock()
+
+	for _, event := range events {
+		for _, fn := range callbacks {
+			fn(event)
+		}
+	}
+}
+
+// RevocationsSince returns up to limit revocations with a sequence number
+// greater than seq, oldest first. A limit of zero returns all of them.
+func (rm *RevocationManager) RevocationsSince(seq uint64, limit int) []RevocationEvent {
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
 	
-	rm.referrerMapping[referrerID] = append(rm.referrerMapping[referrerID], certID)
+	if seq >= uint64(len(rm.events)) {
+		return nil
+	}
+	events := rm.events[seq:]
+	if limit > 0 && len(events) > limit {
+		events = events[:limit]
+	}
+	return append([]RevocationEvent(nil), events...)
+}
+
+// resolveLocked maps a legacy serial to its certificate ID; callers must hold rm.mu
+func (rm *RevocationManager) resolveLocked(id string) string {
+	if certID, ok := rm.aliases[id]; ok {
+		return certID
+	}
+	return id
+}
+
+// RegisterCertificate registers a new certificate with its referrer. The
+// referral is kept until it is evicted by the referral limit; use
+// RegisterCertificateUntil when the certificate's expiry is known.
+func (rm *RevocationManager) RegisterCertificate(certID, referrerID string) {
+	rm.RegisterCertificateUntil(certID, referrerID, time.Time{})
 }
 
 // Revoke marks a certificate as revoked
 func (rm *RevocationManager) Revoke(certID string) {
 	rm.mu.Lock()
-	defer rm.mu.Unlock()
+	defer rm.unlockAndNotify(len(rm.events))
 	
-	rm.revokedCerts[certID] = time.Now()
+	certID = rm.resolveLocked(certID)
+	now := time.Now()
+	if _, exists := rm.revokedCerts[certID]; !exists {
+		rm.recordLocked(certID, now, "")
+	}
+	rm.revokedCerts[certID] = now
+	rm.publishLocked()
 }
 
 // RevokeWithChildren revokes a certificate and all its descendants
 func (rm *RevocationManager) RevokeWithChildren(certID string) {
 	rm.mu.Lock()
-	defer rm.mu.Unlock()
+	defer rm.unlockAndNotify(len(rm.events))
 	
-	// Helper function for recursive revocation
+	// Helper function for recursive revocation; visited stops it at a
+	// cycle in the referral graph
+	visited := make(map[string]bool)
 	var revokeRecursive func(string)
 	revokeRecursive = func(id string) {
+		if visited[id] {
+			return
+		}
+		visited[id] = true
+		
 		// Mark as revoked
-		rm.revokedCerts[id] = time.Now()
+		now := time.Now()
+		if _, exists := rm.revokedCerts[id]; !exists {
+			rm.recordLocked(id, now, "")
+		}
+		rm.revokedCerts[id] = now
 		
 		// Revoke all children
 		if children, ok := rm.referrerMapping[id]; ok {
@@ -64,15 +216,19 @@ func (rm *RevocationManager) RevokeWithChildren(certID string) {
 		}
 	}
 	
-	revokeRecursive(certID)
+	revokeRecursive(rm.resolveLocked(certID))
+	rm.publishLocked()
 }
 
-// IsRevoked checks if a certificate is revoked
+// IsRevoked checks if a certificate is revoked. It reads a snapshot without
+// locking, so it is safe to call on every TLS handshake.
 func (rm *RevocationManager) IsRevoked(certID string) bool {
-	rm.mu.RLock()
-	defer rm.mu.RUnlock()
+	snapshot := rm.snapshot.Load()
+	if resolved, ok := snapshot.aliases[certID]; ok {
+		certID = resolved
+	}
 	
-	_, revoked := rm.revokedCerts[certID]
+	_, revoked := snapshot.revoked[certID]
 	return revoked
 }
 
@@ -95,9 +251,81 @@ func (rm *RevocationManager) GetChildCount(referrerID string) int {
 	rm.mu.RLock()
 	defer rm.mu.RUnlock()
 	
-	if children, ok := rm.referrerMapping[referrerID]; ok {
+	if children, ok := rm.referrerMapping[rm.resolveLocked(referrerID)]; ok {
 		return len(children)
 	}
 	
 	return 0
-}
\ No newline at end of file
+}
+
+// ReferralTree returns the certificate IDs of the referral tree certID
+// belongs to: the certificate at the top of its referral chain and every
+// certificate referred below it, including certID itself
+func (rm *RevocationManager) ReferralTree(certID string) []string {
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+	
+	parents := rm.parentsLocked()
+	
+	// Climb to the top; a cycle in a merged graph stops the climb
+	root := rm.resolveLocked(certID)
+	seen := map[string]bool{root: true}
+	for {
+		parent, ok := parents[root]
+		if !ok || seen[parent] {
+			break
+		}
+		seen[parent] = true
+		root = parent
+	}
+	
+	tree := make([]string, 0)
+	visited := make(map[string]bool)
+	var walk func(string)
+	walk = func(id string) {
+		if visited[id] {
+			return
+		}
+		visited[id] = true
+		tree = append(tree, id)
+		for _, childID := range rm.referrerMapping[id] {
+			walk(childID)
+		}
+	}
+	walk(root)
+	return tree
+}
+
+// ReferrerChain returns the referrers above certID, nearest first, as far
+// as the referral graph knows them. A cycle in a merged graph ends the
+// chain.
+func (rm *RevocationManager) ReferrerChain(certID string) []string {
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+	
+	parents := rm.parentsLocked()
+	chain := make([]string, 0)
+	id := rm.resolveLocked(certID)
+	seen := map[string]bool{id: true}
+	for {
+		parent, ok := parents[id]
+		if !ok || seen[parent] {
+			return chain
+		}
+		seen[parent] = true
+		chain = append(chain, parent)
+		id = parent
+	}
+}
+
+// parentsLocked maps every referred certificate to its referrer; callers
+// must hold rm.mu
+func (rm *RevocationManager) parentsLocked() map[string]string {
+	parents := make(map[string]string)
+	for referrerID, children := range rm.referrerMapping {
+		for _, childID := range children {
+			parents[childID] = referrerID
+		}
+	}
+	return parents
+}
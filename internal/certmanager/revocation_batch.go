@@ -0,0 +1,144 @@
+package certmanager
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Revocation reasons, named after the CRLReason values of RFC 5280
+const (
+	ReasonUnspecified          = "unspecified"
+	ReasonKeyCompromise        = "keyCompromise"
+	ReasonAffiliationChanged   = "affiliationChanged"
+	ReasonSuperseded           = "superseded"
+	ReasonCessationOfOperation = "cessationOfOperation"
+	ReasonPrivilegeWithdrawn   = "privilegeWithdrawn"
+)
+
+// revocationReasons are the reasons a revocation may be recorded with
+var revocationReasons = map[string]bool{
+	ReasonUnspecified:          true,
+	ReasonKeyCompromise:        true,
+	ReasonAffiliationChanged:   true,
+	ReasonSuperseded:           true,
+	ReasonCessationOfOperation: true,
+	ReasonPrivilegeWithdrawn:   true,
+}
+
+// Encodings of revocation lists
+const (
+	RevocationListJSON = "json"
+	RevocationListCSV  = "csv"
+)
+
+// ErrInvalidRevocationList is returned for a revocation list that cannot
+// be parsed or names an unknown reason
+var ErrInvalidRevocationList = errors.New("invalid revocation list")
+
+// RevocationRequest names one certificate of a batch revocation, by
+// certificate ID or legacy serial
+type RevocationRequest struct {
+	CertID string `json:"cert_id"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// ParseRevocationList reads a revocation list. JSON lists are an array of
+// requests or an object holding one under "revocations". CSV lists have the
+// certificate ID in the first column and an optional reason in the second;
+// a first row naming cert_id is taken as a header.
+func ParseRevocationList(data []byte, format string) ([]RevocationRequest, error) {
+	var requests []RevocationRequest
+	switch format {
+	case RevocationListJSON:
+		trimmed := bytes.TrimSpace(data)
+		var err error
+		if len(trimmed) > 0 && trimmed[0] == '{' {
+			var doc struct {
+				Revocations []RevocationRequest `json:"revocations"`
+			}
+			err = json.Unmarshal(trimmed, &doc)
+			requests = doc.Revocations
+		} else {
+			err = json.Unmarshal(trimmed, &requests)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidRevocationList, err)
+		}
+	case RevocationListCSV:
+		reader := csv.NewReader(bytes.NewReader(data))
+		reader.FieldsPerRecord = -1
+		reader.TrimLeadingSpace = true
+		reader.Comment = '#'
+		for line := 1; ; line++ {
+			record, err := reader.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidRevocationList, err)
+			}
+			if len(record) > 2 {
+				return nil, fmt.Errorf("%w: line %d has %d columns", ErrInvalidRevocationList, line, len(record))
+			}
+			if line == 1 && strings.EqualFold(strings.TrimSpace(record[0]), "cert_id") {
+				continue
+			}
+			request := RevocationRequest{CertID: record[0]}
+			if len(record) == 2 {
+				request.Reason = record[1]
+			}
+			requests = append(requests, request)
+		}
+	default:
+		return nil, fmt.Errorf("%w: unknown format %q", ErrInvalidRevocationList, format)
+	}
+
+	for i := range requests {
+		requests[i].CertID = strings.TrimSpace(requests[i].CertID)
+		requests[i].Reason = strings.TrimSpace(requests[i].Reason)
+		if requests[i].CertID == "" {
+			return nil, fmt.Errorf("%w: entry %d has no certificate ID", ErrInvalidRevocationList, i+1)
+		}
+		if requests[i].Reason != "" && !revocationReasons[requests[i].Reason] {
+			return nil, fmt.Errorf("%w: entry %d has unknown reason %q", ErrInvalidRevocationList, i+1, requests[i].Reason)
+		}
+	}
+	return requests, nil
+}
+
+// RevokeBatch revokes the listed certificates, but not the certificates
+// they referred. The batch is applied under one lock, so IsRevoked and the
+// delta feed show either none or all of it. Certificates already revoked
+// keep their revocation. It returns how many certificates were newly
+// revoked; a request with an unknown reason fails the whole batch.
+func (rm *RevocationManager) RevokeBatch(requests []RevocationRequest) (int, error) {
+	for i, request := range requests {
+		if request.CertID == "" || (request.Reason != "" && !revocationReasons[request.Reason]) {
+			return 0, fmt.Errorf("%w: entry %d", ErrInvalidRevocationList, i+1)
+		}
+	}
+
+	rm.mu.Lock()
+	defer rm.unlockAndNotify(len(rm.events))
+
+	now := rm.now()
+	revoked := 0
+	for _, request := range requests {
+		certID := rm.resolveLocked(request.CertID)
+		if _, exists := rm.revokedCerts[certID]; exists {
+			continue
+		}
+		rm.revokedCerts[certID] = now
+		rm.recordLocked(certID, now, request.Reason)
+		revoked++
+	}
+	if revoked > 0 {
+		rm.publishLocked()
+	}
+	return revoked, nil
+}
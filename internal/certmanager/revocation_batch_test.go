@@ -0,0 +1,80 @@
+package certmanager
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseRevocationList(t *testing.T) {
+	csvList := "cert_id,reason\nabc,keyCompromise\n# compromised subtree\ndef\n"
+	requests, err := ParseRevocationList([]byte(csvList), RevocationListCSV)
+	if err != nil {
+		t.Fatalf("Failed to parse CSV list: %v", err)
+	}
+	if len(requests) != 2 || requests[0] != (RevocationRequest{"abc", ReasonKeyCompromise}) || requests[1] != (RevocationRequest{CertID: "def"}) {
+		t.Errorf("CSV list parsed as %+v", requests)
+	}
+
+	for _, jsonList := range []string{
+		`[{"cert_id":"abc","reason":"superseded"},{"cert_id":"def"}]`,
+		`{"revocations":[{"cert_id":"abc","reason":"superseded"},{"cert_id":"def"}]}`,
+	} {
+		requests, err := ParseRevocationList([]byte(jsonList), RevocationListJSON)
+		if err != nil || len(requests) != 2 || requests[0].Reason != ReasonSuperseded {
+			t.Errorf("JSON list %s parsed as %+v, %v", jsonList, requests, err)
+		}
+	}
+
+	for _, bad := range []struct{ data, format string }{
+		{"abc,stolen\n", RevocationListCSV},
+		{"abc,keyCompromise,extra\n", RevocationListCSV},
+		{`[{"reason":"superseded"}]`, RevocationListJSON},
+		{`[{"cert_id":`, RevocationListJSON},
+		{"abc", "xml"},
+	} {
+		if _, err := ParseRevocationList([]byte(bad.data), bad.format); !errors.Is(err, ErrInvalidRevocationList) {
+			t.Errorf("ParseRevocationList(%q, %s) should fail, got %v", bad.data, bad.format, err)
+		}
+	}
+}
+
+func TestRevokeBatch(t *testing.T) {
+	rm := NewRevocationManager()
+	rm.RegisterCertificate("child", "compromised1")
+	rm.Revoke("compromised2")
+
+	var notified []RevocationEvent
+	rm.OnRevoke(func(event RevocationEvent) {
+		notified = append(notified, event)
+	})
+
+	// An unknown reason rejects the batch before anything is revoked
+	if _, err := rm.RevokeBatch([]RevocationRequest{{CertID: "compromised1"}, {CertID: "x", Reason: "stolen"}}); !errors.Is(err, ErrInvalidRevocationList) {
+		t.Fatalf("Batch with an unknown reason should fail, got %v", err)
+	}
+	if rm.IsRevoked("compromised1") {
+		t.Fatal("A rejected batch should revoke nothing")
+	}
+
+	revoked, err := rm.RevokeBatch([]RevocationRequest{
+		{CertID: "compromised1", Reason: ReasonKeyCompromise},
+		{CertID: "compromised2", Reason: ReasonKeyCompromise},
+		{CertID: "compromised3"},
+	})
+	if err != nil || revoked != 2 {
+		t.Fatalf("RevokeBatch = %d, %v, want 2 newly revoked", revoked, err)
+	}
+	if !rm.IsRevoked("compromised1") || !rm.IsRevoked("compromised3") {
+		t.Error("Batch certificates should be revoked")
+	}
+	if rm.IsRevoked("child") {
+		t.Error("A batch should not revoke referred certificates")
+	}
+
+	if len(notified) != 2 || notified[0].Reason != ReasonKeyCompromise || notified[1].Reason != "" {
+		t.Errorf("Expected 2 notifications with their reasons, got %+v", notified)
+	}
+	if events := rm.RevocationsSince(1, 0); len(events) != 2 || events[0].CertID != "compromised1" {
+		t.Errorf("Delta feed after the batch = %+v", events)
+	}
+}
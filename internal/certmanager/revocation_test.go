@@ -1,6 +1,8 @@
 package certmanager
 
 import (
+	"fmt"
+	"sync"
 	"testing"
 	"time"
 )
@@ -104,6 +106,32 @@ func TestRevocationWithChildren(t *testing.T) {
 	}
 }
 
+func TestRevocationWithChildrenCycle(t *testing.T) {
+	rm := NewRevocationManager()
+	rm.RegisterCertificate("child", "root")
+	
+	// A cycle in the graph must not make the revocation recurse forever
+	rm.referrerMapping["child"] = append(rm.referrerMapping["child"], "root")
+	rm.referrerMapping["root"] = append(rm.referrerMapping["root"], "root")
+	rm.RevokeWithChildren("root")
+	
+	if !rm.IsRevoked("root") || !rm.IsRevoked("child") {
+		t.Error("Both certificates of the cycle should be revoked")
+	}
+}
+
+func TestIsReferredBy(t *testing.T) {
+	rm := NewRevocationManager()
+	rm.RegisterCertificate("child", "parent")
+	
+	if !rm.IsReferredBy("child", "parent") {
+		t.Error("parent should be recorded as the referrer of child")
+	}
+	if rm.IsReferredBy("parent", "child") || rm.IsReferredBy("other", "parent") {
+		t.Error("Only recorded referrals should match")
+	}
+}
+
 func TestGetRevokedCertificates(t *testing.T) {
 	rm := NewRevocationManager()
 	
@@ -205,4 +233,207 @@ func TestConcurrentRevocation(t *testing.T) {
 	if rm.IsRevoked("child3") {
 		t.Error("child3 should not be revoked")
 	}
-}
\ No newline at end of file
+}
+
+func TestRevocationLegacySerialAlias(t *testing.T) {
+	rm := NewRevocationManager()
+	
+	// State recorded under legacy serial identifiers
+	rm.RegisterCertificate("1001", "root-serial")
+	rm.Revoke("1001")
+	
+	// Aliases learned at handshake time migrate that state
+	rm.RegisterAlias("1001", "child-id")
+	rm.RegisterAlias("root-serial", "root-id")
+	
+	if !rm.IsRevoked("child-id") {
+		t.Error("Revocation recorded under serial should carry over to the certificate ID")
+	}
+	
+	if !rm.IsRevoked("1001") {
+		t.Error("Legacy serial should still resolve after migration")
+	}
+	
+	if count := rm.GetChildCount("root-id"); count != 1 {
+		t.Errorf("Referrer mapping should move to the certificate ID, got %d children", count)
+	}
+	
+	// Revoking by either identifier affects the same certificate
+	rm.RegisterCertificate("grandchild-id", "child-id")
+	rm.RevokeWithChildren("root-serial")
+	
+	if !rm.IsRevoked("grandchild-id") {
+		t.Error("Revoking by legacy serial should cascade through certificate IDs")
+	}
+	
+	revoked := rm.GetRevokedCertificates()
+	if _, exists := revoked["1001"]; exists {
+		t.Error("Revoked set should be keyed by certificate ID, not legacy serial")
+	}
+}
+
+func TestRevocationSnapshotFreshness(t *testing.T) {
+	rm := NewRevocationManager()
+	rm.RegisterCertificate("child", "parent")
+	
+	// Readers hammer IsRevoked while revocations are published
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					rm.IsRevoked("parent")
+				}
+			}
+		}()
+	}
+	
+	// Every change is visible as soon as the mutating call returns
+	for i := 0; i < 100; i++ {
+		id := fmt.Sprintf("cert%d", i)
+		rm.Revoke(id)
+		if !rm.IsRevoked(id) {
+			t.Fatalf("%s not visible immediately after Revoke", id)
+		}
+	}
+	
+	rm.RevokeWithChildren("parent")
+	if !rm.IsRevoked("child") {
+		t.Error("Cascaded revocation not visible after RevokeWithChildren")
+	}
+	
+	rm.RegisterAlias("4242", "cert7")
+	if !rm.IsRevoked("4242") {
+		t.Error("Alias not visible after RegisterAlias")
+	}
+	
+	rm.MergeGraph(&GraphDocument{Revoked: map[string]int64{"remote": time.Now().Unix()}})
+	if !rm.IsRevoked("remote") {
+		t.Error("Merged revocation not visible after MergeGraph")
+	}
+	
+	close(stop)
+	wg.Wait()
+}
+
+func TestRevocationCallbacks(t *testing.T) {
+	rm := NewRevocationManager()
+	rm.RegisterCertificate("child", "parent")
+	
+	var revoked []string
+	rm.OnRevoke(func(event RevocationEvent) {
+		// Callbacks run after the revocation is visible and rm.mu is free
+		if !rm.IsRevoked(event.CertID) {
+			t.Errorf("%s not revoked when its callback ran", event.CertID)
+		}
+		rm.RevocationsSince(0, 0)
+		revoked = append(revoked, event.CertID)
+	})
+	
+	rm.RevokeWithChildren("parent")
+	rm.Revoke("child") // Already revoked, so not reported again
+	rm.MergeGraph(&GraphDocument{Revoked: map[string]int64{"remote": time.Now().Unix()}})
+	
+	want := []string{"parent", "child", "remote"}
+	if fmt.Sprint(revoked) != fmt.Sprint(want) {
+		t.Errorf("Expected callbacks for %v, got %v", want, revoked)
+	}
+}
+
+func BenchmarkIsRevokedParallel(b *testing.B) {
+	rm := NewRevocationManager()
+	rm.mu.Lock()
+	for i := 0; i < 10000; i++ {
+		rm.revokedCerts[fmt.Sprintf("cert%d", i)] = time.Now()
+	}
+	rm.publishLocked()
+	rm.mu.Unlock()
+	
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			rm.IsRevoked("cert5000")
+		}
+	})
+}
+
+func TestReferralTree(t *testing.T) {
+	rm := NewRevocationManager()
+	rm.RegisterCertificate("child1", "root")
+	rm.RegisterCertificate("child2", "root")
+	rm.RegisterCertificate("grandchild1", "child1")
+	rm.RegisterCertificate("other", "elsewhere")
+
+	for _, certID := range []string{"root", "child2", "grandchild1"} {
+		tree := rm.ReferralTree(certID)
+		if len(tree) != 4 || tree[0] != "root" {
+			t.Errorf("ReferralTree(%q) = %v, want root and its 3 descendants", certID, tree)
+		}
+	}
+
+	if tree := rm.ReferralTree("unknown"); len(tree) != 1 || tree[0] != "unknown" {
+		t.Errorf("ReferralTree of an unreferred certificate = %v, want only itself", tree)
+	}
+}
+
+func TestReferrerChain(t *testing.T) {
+	rm := NewRevocationManager()
+	rm.RegisterCertificate("child", "root")
+	rm.RegisterCertificate("grandchild", "child")
+
+	chain := rm.ReferrerChain("grandchild")
+	if len(chain) != 2 || chain[0] != "child" || chain[1] != "root" {
+		t.Errorf("ReferrerChain = %v, want [child root]", chain)
+	}
+	if chain := rm.ReferrerChain("root"); len(chain) != 0 {
+		t.Errorf("ReferrerChain of the root = %v, want none", chain)
+	}
+
+	// A cycle from a merged graph ends the chain
+	rm.RegisterCertificate("root", "grandchild")
+	if chain := rm.ReferrerChain("grandchild"); len(chain) != 2 {
+		t.Errorf("ReferrerChain through a cycle = %v, want 2 referrers", chain)
+	}
+}
+
+func TestReferralBounds(t *testing.T) {
+	rm := NewRevocationManager()
+	now := time.Now()
+	rm.now = func() time.Time { return now }
+
+	// Reconnects register the same referral again without growing the graph
+	rm.RegisterCertificateUntil("child1", "root", now.Add(time.Hour))
+	rm.RegisterCertificateUntil("child1", "root", now.Add(time.Hour))
+	rm.RegisterCertificateUntil("child2", "root", now.Add(2*time.Hour))
+	rm.RegisterCertificateUntil("expired", "root", now.Add(-time.Hour))
+	if count := rm.ReferralCount(); count != 2 {
+		t.Fatalf("Expected 2 referrals, got %d", count)
+	}
+
+	now = now.Add(90 * time.Minute)
+	if pruned := rm.PruneExpiredReferrals(); pruned != 1 || rm.GetChildCount("root") != 1 {
+		t.Errorf("Expected child1 pruned once expired, pruned %d leaving %d children", pruned, rm.GetChildCount("root"))
+	}
+
+	// Over the limit, expired referrals go first and then the least
+	// recently registered ones
+	rm.RegisterCertificate("child3", "root")
+	rm.RegisterCertificate("child4", "other")
+	rm.RegisterCertificate("child3", "root")
+	now = now.Add(time.Hour)
+	rm.SetReferralLimit(1)
+	if rm.GetChildCount("root") != 1 || rm.GetChildCount("other") != 0 {
+		t.Errorf("Expected only child3 kept, got %d under root and %d under other",
+			rm.GetChildCount("root"), rm.GetChildCount("other"))
+	}
+	rm.RevokeWithChildren("root")
+	if !rm.IsRevoked("child3") {
+		t.Error("Kept referrals should still cascade")
+	}
+}
@@ -0,0 +1,110 @@
+package certmanager
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/asn1"
+	"math/big"
+)
+
+// signatureScheme is how documents in standard formats, JWS and COSE, are
+// signed with a key of a given type: the identifiers each format uses for
+// the algorithm, the hash and, for ECDSA, the fixed width of r and s in the
+// raw signature encoding both formats require
+type signatureScheme struct {
+	jws     string
+	cose    int
+	hash    crypto.Hash // Zero for Ed25519, which signs the message itself
+	ecWidth int
+}
+
+// COSE algorithm identifiers (RFC 9053, 8812)
+const (
+	coseAlgES256 = -7
+	coseAlgEdDSA = -8
+	coseAlgES384 = -35
+	coseAlgRS256 = -257
+)
+
+// schemeFor returns the scheme for a public key, or false for key types the
+// standard formats are not produced with
+func schemeFor(pub crypto.PublicKey) (signatureScheme, bool) {
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
+		return signatureScheme{jws: "RS256", cose: coseAlgRS256, hash: crypto.SHA256}, true
+	case *ecdsa.PublicKey:
+		switch key.Curve {
+		case elliptic.P256():
+			return signatureScheme{jws: "ES256", cose: coseAlgES256, hash: crypto.SHA256, ecWidth: 32}, true
+		case elliptic.P384():
+			return signatureScheme{jws: "ES384", cose: coseAlgES384, hash: crypto.SHA384, ecWidth: 48}, true
+		}
+	case ed25519.PublicKey:
+		return signatureScheme{jws: "EdDSA", cose: coseAlgEdDSA}, true
+	}
+	return signatureScheme{}, false
+}
+
+// digest hashes data for signing, or returns it unchanged for Ed25519
+func (sc signatureScheme) digest(data []byte) []byte {
+	switch sc.hash {
+	case crypto.SHA256:
+		sum := sha256.Sum256(data)
+		return sum[:]
+	case crypto.SHA384:
+		sum := sha512.Sum384(data)
+		return sum[:]
+	}
+	return data
+}
+
+// sign signs data with signer, encoding ECDSA signatures as r || s
+func (sc signatureScheme) sign(signer crypto.Signer, data []byte) ([]byte, error) {
+	signature, err := signer.Sign(rand.Reader, sc.digest(data), sc.hash)
+	if err != nil || sc.ecWidth == 0 {
+		return signature, err
+	}
+
+	var parsed struct{ R, S *big.Int }
+	if _, err := asn1.Unmarshal(signature, &parsed); err != nil {
+		return nil, err
+	}
+	raw := make([]byte, 2*sc.ecWidth)
+	parsed.R.FillBytes(raw[:sc.ecWidth])
+	parsed.S.FillBytes(raw[sc.ecWidth:])
+	return raw, nil
+}
+
+// verify checks a signature made by sign
+func (sc signatureScheme) verify(pub crypto.PublicKey, data, signature []byte) bool {
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, sc.hash, sc.digest(data), signature) == nil
+	case *ecdsa.PublicKey:
+		if len(signature) != 2*sc.ecWidth {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:sc.ecWidth])
+		s := new(big.Int).SetBytes(signature[sc.ecWidth:])
+		return ecdsa.Verify(key, sc.digest(data), r, s)
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, data, signature)
+	}
+	return false
+}
+
+// signSHA256 signs SHA-256(data) with signer in the form VerifySignature
+// checks: PKCS #1 v1.5 for RSA, ASN.1 for ECDSA, and plain Ed25519 over data
+func signSHA256(signer crypto.Signer, data []byte) ([]byte, error) {
+	if _, ok := signer.Public().(ed25519.PublicKey); ok {
+		return signer.Sign(rand.Reader, data, crypto.Hash(0))
+	}
+	digest := sha256.Sum256(data)
+	return signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+}
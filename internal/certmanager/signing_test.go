@@ -0,0 +1,88 @@
+package certmanager
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	cryptopkg "github.com/yourusername/secure-messaging-poc/pkg/crypto"
+)
+
+func TestCAKeyAlgorithms(t *testing.T) {
+	for _, algorithm := range []cryptopkg.KeyAlgorithm{cryptopkg.RSA2048, cryptopkg.ECDSAP256, cryptopkg.ECDSAP384, cryptopkg.Ed25519} {
+		t.Run(string(algorithm), func(t *testing.T) {
+			dir := t.TempDir()
+			certPath, keyPath := filepath.Join(dir, "ca.crt"), filepath.Join(dir, "ca.key")
+			ca, err := NewCertificateAuthorityWithAlgorithm(certPath, keyPath, "Test Org", algorithm)
+			if err != nil {
+				t.Fatalf("Failed to create CA: %v", err)
+			}
+			caCert, _ := ca.GetCACertificate()
+
+			token, err := ca.SignJWS([]byte(`{"ok":true}`))
+			if err != nil {
+				t.Fatalf("SignJWS failed: %v", err)
+			}
+			if _, err := VerifyJWS(token, caCert); err != nil {
+				t.Errorf("VerifyJWS failed: %v", err)
+			}
+
+			entry, err := ca.SignRevocationEntry(RevocationEntry{Seq: 1, CertID: "abc", RevokedAt: time.Now().Unix()})
+			if err != nil {
+				t.Fatalf("SignRevocationEntry failed: %v", err)
+			}
+			if _, err := VerifyRevocationEntry(entry, caCert); err != nil {
+				t.Errorf("VerifyRevocationEntry failed: %v", err)
+			}
+
+			doc := &GraphDocument{Version: GraphFormatVersion}
+			if err := ca.SignGraph(doc); err != nil {
+				t.Fatalf("SignGraph failed: %v", err)
+			}
+			if err := VerifyGraph(doc, []*x509.Certificate{caCert}); err != nil {
+				t.Errorf("VerifyGraph failed: %v", err)
+			}
+
+			csr, _ := newTestCSR(t, "client")
+			if _, err := ca.SignCSR(csr, "", 1); err != nil {
+				t.Errorf("SignCSR failed: %v", err)
+			}
+
+			// The key is reloaded, and secrets derived from it stay the same
+			secret, _ := ca.DeriveSecret("label")
+			reloaded, err := NewCertificateAuthority(certPath, keyPath, "Test Org")
+			if err != nil {
+				t.Fatalf("Failed to reload CA: %v", err)
+			}
+			if again, _ := reloaded.DeriveSecret("label"); string(again) != string(secret) {
+				t.Error("Derived secret changed after reloading the CA")
+			}
+		})
+	}
+}
+
+func TestSignCSRRefusesWeakKeys(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: "weak"},
+	}, key)
+	if err != nil {
+		t.Fatalf("Failed to create CSR: %v", err)
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		t.Fatalf("Failed to parse CSR: %v", err)
+	}
+
+	if _, err := newTestCA(t).SignCSR(csr, "", 1); !errors.Is(err, ErrUnsupportedKey) {
+		t.Errorf("Expected ErrUnsupportedKey for a 1024-bit RSA key, got %v", err)
+	}
+}
@@ -0,0 +1,289 @@
+package certmanager
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Trust anchor sources
+const (
+	AnchorPinned    = "pinned"
+	AnchorDirectory = "directory"
+	AnchorAdmin     = "admin"
+)
+
+var (
+	// ErrNotCA is returned when a trust anchor is not a CA certificate
+	ErrNotCA = errors.New("certificate is not a CA")
+	// ErrAnchorNotFound is returned when removing an unknown trust anchor
+	ErrAnchorNotFound = errors.New("trust anchor not found")
+	// ErrPinnedAnchor is returned when removing an anchor that cannot be removed
+	ErrPinnedAnchor = errors.New("trust anchor is pinned")
+	// ErrNoUsableCA is returned when a trust directory file holds no CA
+	// certificate
+	ErrNoUsableCA = errors.New("no usable CA certificate")
+)
+
+// TrustAnchor is a CA certificate accepted for client authentication
+type TrustAnchor struct {
+	ID       string    `json:"id"`
+	Subject  string    `json:"subject"`
+	NotAfter time.Time `json:"not_after"`
+	Source   string    `json:"source"`
+	cert     *x509.Certificate
+}
+
+// TrustStore holds the CA certificates client certificates may chain to.
+// Pinned anchors, normally this server's own CA, are always trusted; others
+// are loaded from a trust directory or added through the admin API and can
+// change without a restart. Changes apply to new TLS handshakes.
+type TrustStore struct {
+	anchors map[string]*TrustAnchor
+	pool    atomic.Value // *x509.CertPool
+	dir     string
+	ticker  *time.Ticker
+	done    chan struct{}
+	mu      sync.Mutex
+}
+
+// NewTrustStore creates a trust store with the given pinned anchors. If dir is
+// not empty, Reload loads the *.pem and *.crt files in it.
+func NewTrustStore(dir string, pinned ...*x509.Certificate) *TrustStore {
+	ts := &TrustStore{
+		anchors: make(map[string]*TrustAnchor),
+		dir:     dir,
+	}
+	for _, cert := range pinned {
+		ts.anchors[CertificateID(cert)] = newTrustAnchor(cert, AnchorPinned)
+	}
+	ts.rebuildLocked()
+	return ts
+}
+
+// Pool returns the current pool of trusted CAs
+func (ts *TrustStore) Pool() *x509.CertPool {
+	return ts.pool.Load().(*x509.CertPool)
+}
+
+// Anchors lists the trusted CAs ordered by ID
+func (ts *TrustStore) Anchors() []TrustAnchor {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	anchors := make([]TrustAnchor, 0, len(ts.anchors))
+	for _, anchor := range ts.anchors {
+		anchors = append(anchors, *anchor)
+	}
+	sort.Slice(anchors, func(i, j int) bool {
+		return anchors[i].ID < anchors[j].ID
+	})
+	return anchors
+}
+
+// Add trusts a CA certificate until it is removed or the server restarts
+func (ts *TrustStore) Add(cert *x509.Certificate) (TrustAnchor, error) {
+	if !cert.BasicConstraintsValid || !cert.IsCA {
+		return TrustAnchor{}, ErrNotCA
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	id := CertificateID(cert)
+	if existing, exists := ts.anchors[id]; exists {
+		return *existing, nil
+	}
+
+	anchor := newTrustAnchor(cert, AnchorAdmin)
+	ts.anchors[id] = anchor
+	ts.rebuildLocked()
+	log.Printf("Trust anchor %s added: %s", id, anchor.Subject)
+	return *anchor, nil
+}
+
+// Remove stops trusting a CA. Pinned anchors cannot be removed, and anchors
+// from the trust directory return on the next reload unless their file is
+// deleted.
+func (ts *TrustStore) Remove(id string) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	anchor, exists := ts.anchors[id]
+	if !exists {
+		return ErrAnchorNotFound
+	}
+	if anchor.Source == AnchorPinned {
+		return ErrPinnedAnchor
+	}
+
+	delete(ts.anchors, id)
+	ts.rebuildLocked()
+	log.Printf("Trust anchor %s removed: %s", id, anchor.Subject)
+	return nil
+}
+
+// Reload replaces the anchors from the trust directory with its current
+// contents. Files that cannot be parsed are skipped and reported in the
+// error; the remaining anchors are still applied.
+func (ts *TrustStore) Reload() (added, removed int, err error) {
+	if ts.dir == "" {
+		return 0, 0, nil
+	}
+
+	certs, err := loadTrustDir(ts.dir)
+	if certs == nil {
+		return 0, 0, err
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	current := make(map[string]bool, len(certs))
+	for _, cert := range certs {
+		id := CertificateID(cert)
+		current[id] = true
+		if _, exists := ts.anchors[id]; !exists {
+			ts.anchors[id] = newTrustAnchor(cert, AnchorDirectory)
+			added++
+		}
+	}
+	for id, anchor := range ts.anchors {
+		if anchor.Source == AnchorDirectory && !current[id] {
+			delete(ts.anchors, id)
+			removed++
+		}
+	}
+
+	if added > 0 || removed > 0 {
+		ts.rebuildLocked()
+		log.Printf("Trust anchors reloaded from %s: %d added, %d removed", ts.dir, added, removed)
+	}
+	return added, removed, err
+}
+
+// Watch reloads the trust directory every interval until Stop is called
+func (ts *TrustStore) Watch(interval time.Duration) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if ts.ticker != nil || ts.dir == "" || interval <= 0 {
+		return
+	}
+	ts.ticker = time.NewTicker(interval)
+	ts.done = make(chan struct{})
+
+	go func(ticker *time.Ticker, done chan struct{}) {
+		for {
+			select {
+			case <-ticker.C:
+				if _, _, err := ts.Reload(); err != nil {
+					log.Printf("Failed to reload trust anchors: %v", err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}(ts.ticker, ts.done)
+}
+
+// Stop stops watching the trust directory
+func (ts *TrustStore) Stop() {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if ts.ticker != nil {
+		ts.ticker.Stop()
+		close(ts.done)
+		ts.ticker = nil
+	}
+}
+
+// TLSConfig returns a copy of base that verifies client certificates against
+// the trust store's current pool on every handshake
+func (ts *TrustStore) TLSConfig(base *tls.Config) *tls.Config {
+	config := base.Clone()
+	config.ClientCAs = ts.Pool()
+	config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		handshake := base.Clone()
+		handshake.ClientCAs = ts.Pool()
+		return handshake, nil
+	}
+	return config
+}
+
+// rebuildLocked publishes a new pool from the anchors; callers hold ts.mu
+func (ts *TrustStore) rebuildLocked() {
+	pool := x509.NewCertPool()
+	for _, anchor := range ts.anchors {
+		pool.AddCert(anchor.cert)
+	}
+	ts.pool.Store(pool)
+}
+
+// newTrustAnchor describes a trusted certificate
+func newTrustAnchor(cert *x509.Certificate, source string) *TrustAnchor {
+	return &TrustAnchor{
+		ID:       CertificateID(cert),
+		Subject:  cert.Subject.String(),
+		NotAfter: cert.NotAfter,
+		Source:   source,
+		cert:     cert,
+	}
+}
+
+// loadTrustDir parses every CA certificate in the *.pem and *.crt files of
+// dir. A file may hold several certificates. It returns nil certificates only
+// if the directory itself cannot be read.
+func loadTrustDir(dir string) ([]*x509.Certificate, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	certs := make([]*x509.Certificate, 0)
+	var failed []string
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		if entry.IsDir() || (ext != ".pem" && ext != ".crt") {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			failed = append(failed, entry.Name())
+			continue
+		}
+
+		found := false
+		for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+			if block.Type != "CERTIFICATE" {
+				continue
+			}
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil || !cert.BasicConstraintsValid || !cert.IsCA {
+				continue
+			}
+			certs = append(certs, cert)
+			found = true
+		}
+		if !found {
+			failed = append(failed, entry.Name())
+		}
+	}
+
+	if len(failed) > 0 {
+		return certs, fmt.Errorf("%w in %s", ErrNoUsableCA, strings.Join(failed, ", "))
+	}
+	return certs, nil
+}
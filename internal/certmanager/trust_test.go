@@ -0,0 +1,141 @@
+package certmanager
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newSelfSignedCert creates a self-signed certificate, a CA if isCA is set
+func newSelfSignedCert(t *testing.T, commonName string, isCA bool) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %v", err)
+	}
+	return cert
+}
+
+// writeAnchor stores a certificate as PEM in dir
+func writeAnchor(t *testing.T, dir, name string, cert *x509.Certificate) {
+	t.Helper()
+	data, err := EncodeCertificatePEM(cert)
+	if err != nil {
+		t.Fatalf("Failed to encode certificate: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+		t.Fatalf("Failed to write anchor: %v", err)
+	}
+}
+
+func TestTrustStoreAddRemove(t *testing.T) {
+	pinned := newSelfSignedCert(t, "Pinned CA", true)
+	ts := NewTrustStore("", pinned)
+
+	if err := ts.Remove(CertificateID(pinned)); err != ErrPinnedAnchor {
+		t.Errorf("Expected ErrPinnedAnchor, got %v", err)
+	}
+
+	if _, err := ts.Add(newSelfSignedCert(t, "Leaf", false)); err != ErrNotCA {
+		t.Errorf("Expected ErrNotCA for a leaf certificate, got %v", err)
+	}
+
+	partner := newSelfSignedCert(t, "Partner CA", true)
+	before := ts.Pool()
+	anchor, err := ts.Add(partner)
+	if err != nil {
+		t.Fatalf("Failed to add anchor: %v", err)
+	}
+	if anchor.Source != AnchorAdmin || len(ts.Anchors()) != 2 {
+		t.Errorf("Unexpected anchors after add: %+v", ts.Anchors())
+	}
+	if before == ts.Pool() {
+		t.Errorf("Adding an anchor should publish a new pool")
+	}
+
+	if err := ts.Remove(anchor.ID); err != nil {
+		t.Fatalf("Failed to remove anchor: %v", err)
+	}
+	if err := ts.Remove(anchor.ID); err != ErrAnchorNotFound {
+		t.Errorf("Expected ErrAnchorNotFound, got %v", err)
+	}
+}
+
+func TestTrustStoreReload(t *testing.T) {
+	dir := t.TempDir()
+	first := newSelfSignedCert(t, "First CA", true)
+	writeAnchor(t, dir, "first.pem", first)
+	os.WriteFile(filepath.Join(dir, "README"), []byte("ignored"), 0644)
+
+	ts := NewTrustStore(dir, newSelfSignedCert(t, "Pinned CA", true))
+	if added, removed, err := ts.Reload(); err != nil || added != 1 || removed != 0 {
+		t.Fatalf("Initial reload: added %d, removed %d, err %v", added, removed, err)
+	}
+
+	// Rotate: the first CA is replaced by a second one
+	second := newSelfSignedCert(t, "Second CA", true)
+	os.Remove(filepath.Join(dir, "first.pem"))
+	writeAnchor(t, dir, "second.crt", second)
+	if added, removed, err := ts.Reload(); err != nil || added != 1 || removed != 1 {
+		t.Fatalf("Rotation reload: added %d, removed %d, err %v", added, removed, err)
+	}
+
+	ids := make(map[string]string)
+	for _, anchor := range ts.Anchors() {
+		ids[anchor.ID] = anchor.Source
+	}
+	if ids[CertificateID(second)] != AnchorDirectory {
+		t.Errorf("Second CA should be trusted from the directory: %v", ids)
+	}
+	if _, exists := ids[CertificateID(first)]; exists {
+		t.Errorf("First CA should no longer be trusted")
+	}
+
+	// A broken file is reported without dropping the usable anchors
+	os.WriteFile(filepath.Join(dir, "broken.pem"), []byte("not a certificate"), 0644)
+	if _, removed, err := ts.Reload(); err == nil || removed != 0 {
+		t.Errorf("Expected an error and no removals, got removed %d, err %v", removed, err)
+	}
+}
+
+func TestTrustStoreTLSConfig(t *testing.T) {
+	ts := NewTrustStore("", newSelfSignedCert(t, "Pinned CA", true))
+	config := ts.TLSConfig(&tls.Config{ClientAuth: tls.RequireAndVerifyClientCert})
+
+	ts.Add(newSelfSignedCert(t, "Partner CA", true))
+
+	handshake, err := config.GetConfigForClient(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatalf("GetConfigForClient failed: %v", err)
+	}
+	if handshake.ClientCAs != ts.Pool() {
+		t.Errorf("Handshake should use the current pool")
+	}
+	if handshake.ClientAuth != tls.RequireAndVerifyClientCert || handshake.GetConfigForClient != nil {
+		t.Errorf("Handshake config should be derived from the base config")
+	}
+}
@@ -0,0 +1,127 @@
+//go:build chaos
+
+// Package chaos injects latency, errors and partial writes into bin stores and
+// message delivery. It is only compiled with the chaos build tag and must not
+// be used in production builds.
+package chaos
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInjected is returned by operations failed on purpose
+var ErrInjected = errors.New("chaos: injected fault")
+
+// Faults describes what to inject. Rates are fractions of operations.
+type Faults struct {
+	Latency     time.Duration // Added before every operation
+	Jitter      time.Duration // Random extra latency up to this much
+	ErrorRate   float64       // Operations failing without effect
+	PartialRate float64       // Operations taking partial effect, then failing
+}
+
+// ParseFaults parses a spec such as "latency=5ms,jitter=2ms,error=0.01,partial=0.01"
+func ParseFaults(spec string) (Faults, error) {
+	var faults Faults
+	for _, field := range strings.Split(spec, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return Faults{}, fmt.Errorf("chaos: invalid fault %q", field)
+		}
+
+		var err error
+		switch key {
+		case "latency":
+			faults.Latency, err = time.ParseDuration(value)
+		case "jitter":
+			faults.Jitter, err = time.ParseDuration(value)
+		case "error":
+			faults.ErrorRate, err = strconv.ParseFloat(value, 64)
+		case "partial":
+			faults.PartialRate, err = strconv.ParseFloat(value, 64)
+		default:
+			return Faults{}, fmt.Errorf("chaos: unknown fault %q", key)
+		}
+		if err != nil {
+			return Faults{}, fmt.Errorf("chaos: invalid %s: %w", key, err)
+		}
+	}
+	return faults, nil
+}
+
+// outcome is the injected result of one operation
+type outcome int
+
+const (
+	succeed outcome = iota
+	fail
+	partial
+)
+
+// Injector decides the fate of each operation. Decisions come from a seeded
+// source so a failing run can be reproduced.
+type Injector struct {
+	faults   Faults
+	rng      *rand.Rand
+	injected map[string]int
+	mu       sync.Mutex
+}
+
+// NewInjector creates an injector for the given faults
+func NewInjector(faults Faults, seed int64) *Injector {
+	return &Injector{
+		faults:   faults,
+		rng:      rand.New(rand.NewSource(seed)),
+		injected: make(map[string]int),
+	}
+}
+
+// Injected returns how many faults were injected per operation
+func (in *Injector) Injected() map[string]int {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	counts := make(map[string]int, len(in.injected))
+	for op, n := range in.injected {
+		counts[op] = n
+	}
+	return counts
+}
+
+// next sleeps for the configured latency and picks the outcome of op
+func (in *Injector) next(op string) outcome {
+	in.mu.Lock()
+	delay := in.faults.Latency
+	if in.faults.Jitter > 0 {
+		delay += time.Duration(in.rng.Int63n(int64(in.faults.Jitter)))
+	}
+	roll := in.rng.Float64()
+
+	result := succeed
+	switch {
+	case roll < in.faults.ErrorRate:
+		result = fail
+	case roll < in.faults.ErrorRate+in.faults.PartialRate:
+		result = partial
+	}
+	if result != succeed {
+		in.injected[op]++
+	}
+	in.mu.Unlock()
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+	return result
+}
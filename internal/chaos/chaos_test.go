@@ -0,0 +1,123 @@
+//go:build chaos
+
+package chaos
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yourusername/secure-messaging-poc/internal/binmanager"
+)
+
+// recordingClient collects the messages delivered to it
+type recordingClient struct {
+	messages []*binmanager.Message
+	mu       sync.Mutex
+}
+
+func (c *recordingClient) SendMessage(msg *binmanager.Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.messages = append(c.messages, msg)
+	return nil
+}
+
+func (c *recordingClient) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.messages)
+}
+
+func TestParseFaults(t *testing.T) {
+	faults, err := ParseFaults("latency=5ms, jitter=1ms,error=0.1,partial=0.2")
+	if err != nil {
+		t.Fatalf("Failed to parse faults: %v", err)
+	}
+	expected := Faults{Latency: 5 * time.Millisecond, Jitter: time.Millisecond, ErrorRate: 0.1, PartialRate: 0.2}
+	if faults != expected {
+		t.Errorf("Expected %+v, got %+v", expected, faults)
+	}
+
+	if _, err := ParseFaults("explode=1"); err == nil {
+		t.Error("Unknown faults should be rejected")
+	}
+}
+
+func TestRetentionCleanupUnderStoreFaults(t *testing.T) {
+	in := NewInjector(Faults{ErrorRate: 0.3, PartialRate: 0.3}, 1)
+	bm := binmanager.NewBinManagerWithStore(0xFFFFFFFFFFFFF000, 50*time.Millisecond, WrapFactory(nil, in))
+
+	for i := 0; i < 200; i++ {
+		bm.AddMessage(&binmanager.Message{
+			BinID:      uint64(i%4) << 12,
+			MessageID:  fmt.Sprintf("msg%d", i),
+			Ciphertext: make([]byte, 64),
+		})
+	}
+
+	// Lost acknowledgements must not skew the byte accounting
+	if usage, stats := bm.Usage(), bm.Stats(); usage.Bytes != stats.Bytes || usage.Messages != int64(stats.MessageCount) {
+		t.Fatalf("Usage %+v disagrees with stores %+v after faulty appends", usage, stats)
+	}
+
+	// Repeated cleanups eventually expire everything despite failed and
+	// partial deletes
+	time.Sleep(60 * time.Millisecond)
+	bm.StartCleanupService(5 * time.Millisecond)
+	defer bm.Stop()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for bm.Stats().MessageCount > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if stats := bm.Stats(); stats.MessageCount != 0 {
+		t.Errorf("%d messages survived cleanup", stats.MessageCount)
+	}
+	if usage := bm.Usage(); usage.Messages != 0 || usage.Bytes != 0 {
+		t.Errorf("Usage not reset after cleanup: %+v", usage)
+	}
+	if injected := in.Injected(); injected["append"] == 0 || injected["delete"] == 0 {
+		t.Errorf("Expected faults on appends and deletes, got %v", injected)
+	}
+}
+
+func TestBroadcastUnderClientFaults(t *testing.T) {
+	bm := binmanager.NewBinManager(0xFFFFFFFFFFFFF000, time.Hour)
+	in := NewInjector(Faults{ErrorRate: 0.5, PartialRate: 0.5}, 1)
+
+	healthy := make([]*recordingClient, 5)
+	for i := range healthy {
+		healthy[i] = &recordingClient{}
+		bm.Subscribe(0x1000, fmt.Sprintf("healthy%d", i), healthy[i])
+	}
+	faulty := make([]*recordingClient, 5)
+	for i := range faulty {
+		faulty[i] = &recordingClient{}
+		bm.Subscribe(0x1000, fmt.Sprintf("faulty%d", i), WrapClient(faulty[i], in))
+	}
+
+	for i := 0; i < 20; i++ {
+		if err := bm.AddMessage(&binmanager.Message{BinID: 0x1000, Ciphertext: make([]byte, 32)}); err != nil {
+			t.Fatalf("Publishing should not fail because of subscribers: %v", err)
+		}
+	}
+
+	for i, client := range healthy {
+		if client.count() != 20 {
+			t.Errorf("Healthy client %d received %d of 20 messages", i, client.count())
+		}
+	}
+
+	// Every send to a faulty client fails, so each is dropped after its first
+	for i, client := range faulty {
+		if client.count() > 1 {
+			t.Errorf("Faulty client %d should have been dropped, received %d messages", i, client.count())
+		}
+	}
+	if injected := in.Injected()["send"]; injected != len(faulty) {
+		t.Errorf("Expected one failed send per faulty client, got %d", injected)
+	}
+}
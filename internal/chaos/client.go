@@ -0,0 +1,33 @@
+//go:build chaos
+
+package chaos
+
+import (
+	"github.com/yourusername/secure-messaging-poc/internal/binmanager"
+)
+
+// Client wraps a subscriber with fault injection on the send path
+type Client struct {
+	inner binmanager.Client
+	in    *Injector
+}
+
+// WrapClient injects faults into messages sent to inner
+func WrapClient(inner binmanager.Client, in *Injector) *Client {
+	return &Client{inner: inner, in: in}
+}
+
+// SendMessage fails, or delivers a truncated ciphertext and then fails as a
+// connection dropping mid-frame would
+func (c *Client) SendMessage(msg *binmanager.Message) error {
+	switch c.in.next("send") {
+	case fail:
+		return ErrInjected
+	case partial:
+		truncated := *msg
+		truncated.Ciphertext = msg.Ciphertext[:len(msg.Ciphertext)/2]
+		c.inner.SendMessage(&truncated)
+		return ErrInjected
+	}
+	return c.inner.SendMessage(msg)
+}
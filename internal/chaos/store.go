@@ -0,0 +1,80 @@
+//go:build chaos
+
+package chaos
+
+import (
+	"time"
+
+	"github.com/yourusername/secure-messaging-poc/internal/binmanager"
+)
+
+// Store wraps a bin store with fault injection
+type Store struct {
+	inner binmanager.BinStore
+	in    *Injector
+}
+
+// WrapStore injects faults into every operation on inner
+func WrapStore(inner binmanager.BinStore, in *Injector) *Store {
+	return &Store{inner: inner, in: in}
+}
+
+// WrapFactory wraps every store created by factory. A nil factory wraps the
+// default in-memory store.
+func WrapFactory(factory binmanager.StoreFactory, in *Injector) binmanager.StoreFactory {
+	return func(binID uint64) binmanager.BinStore {
+		var inner binmanager.BinStore
+		if factory == nil {
+			inner = binmanager.NewBucketStore(binmanager.DefaultBucketWidth)
+		} else {
+			inner = factory(binID)
+		}
+		return WrapStore(inner, in)
+	}
+}
+
+// AppendMessage fails, or stores the message but reports failure as if the
+// acknowledgement was lost
+func (s *Store) AppendMessage(msg *binmanager.Message) error {
+	switch s.in.next("append") {
+	case fail:
+		return ErrInjected
+	case partial:
+		s.inner.AppendMessage(msg)
+		return ErrInjected
+	}
+	return s.inner.AppendMessage(msg)
+}
+
+// RangeByTime fails, or returns only the older half of the result
+func (s *Store) RangeByTime(from, to time.Time) ([]*binmanager.Message, error) {
+	switch s.in.next("range") {
+	case fail:
+		return nil, ErrInjected
+	case partial:
+		messages, _ := s.inner.RangeByTime(from, to)
+		return messages[:len(messages)/2], ErrInjected
+	}
+	return s.inner.RangeByTime(from, to)
+}
+
+// DeleteBefore fails, or deletes only part of the expired range
+func (s *Store) DeleteBefore(cutoff time.Time) (int, error) {
+	switch s.in.next("delete") {
+	case fail:
+		return 0, ErrInjected
+	case partial:
+		oldest := s.inner.Stats().Oldest
+		if oldest.IsZero() || !oldest.Before(cutoff) {
+			return 0, ErrInjected
+		}
+		removed, _ := s.inner.DeleteBefore(oldest.Add(cutoff.Sub(oldest) / 2))
+		return removed, ErrInjected
+	}
+	return s.inner.DeleteBefore(cutoff)
+}
+
+// Stats is passed through; it cannot report failure
+func (s *Store) Stats() binmanager.StoreStats {
+	return s.inner.Stats()
+}
@@ -2,26 +2,314 @@ package config
 
 import (
 	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/spf13/viper"
+
+	"github.com/yourusername/secure-messaging-poc/internal/legalhold"
+	"github.com/yourusername/secure-messaging-poc/internal/listenaddr"
+	cryptopkg "github.com/yourusername/secure-messaging-poc/pkg/crypto"
 )
 
 // Config holds the application configuration
 type Config struct {
 	Server struct {
-		Address string
-		Port    int
+		Address           string // Host to bind; every interface if empty
+		Port              int
+		IPFamily          string   // listenaddr family of the listener
+		ListenAddress     string   // Address and port joined for listening
+		Advertise         []string // Endpoints named in the server info, in RFC 8305 order
+		RequestTimeout    time.Duration
+		MaxKeyRequestSize int64
+		ProxyProtocol     struct {
+			Enabled        bool
+			TrustedProxies []string
+		}
+		Handshakes struct {
+			MaxConcurrent int // 0 leaves handshakes to the HTTP server
+			MaxQueued     int
+			QueueTimeout  time.Duration
+			Timeout       time.Duration
+		}
+		Instance struct {
+			ID     string // Stable across restarts; the host name if unset
+			Region string
+		}
 	}
 	CA struct {
 		CertPath     string
 		KeyPath      string
 		Organization string
+		CRLURLs      []string
+		OCSPURLs     []string
+		IssuerURLs   []string
+		TrustDir     string
+		TrustReload  time.Duration
+		FingerprintListPath string
+		PseudonymURIs       bool
+		InheritValidity     bool
+		MaxReferrals        int // Referral edges kept in memory, 0 for no limit
+		RegistryPath        string
+		KeyAlgorithm        cryptopkg.KeyAlgorithm // Of a newly generated CA key
+		IssuedKeyAlgorithm  cryptopkg.KeyAlgorithm // Of keys the CA generates for certificates it issues
 	}
 	BinManager struct {
 		InitialMask     uint64
 		MessageRetention time.Duration
+		Storage          string
+		StoragePath      string
+		SnapshotPath     string
+		RetentionBudget  int64
+		RetentionFloor   time.Duration
+		PressureInterval time.Duration
+		MinBinRetention  time.Duration
+		MaxBinRetention  time.Duration
+		CompactionInterval time.Duration // LevelDB only; 0 disables compaction
+		MaxDiskBytes       int64         // 0 leaves disk usage unbounded
+		RecentCacheMessages int // Per bin, disk stores only; 0 disables the cache
+		RecentCacheBins     int
+		EncryptionKey       string        // Base64; seals messages in disk stores
+		EncryptionKeyFile   string        // Holds EncryptionKey, e.g. written by a KMS agent
+		EncryptionBucket    time.Duration // Time buckets sealed records are filed under
+		AutoscaleSubscribers int     // Expand the mask past this many subscribers in a bin; 0 ignores them
+		AutoscaleRate        float64 // Expand the mask past this many messages per second to a bin; 0 ignores it
+		AutoscaleMinBits     int     // 0 for the bits of InitialMask
+		AutoscaleMaxBits     int     // 0 for 64
+		AutoscaleInterval    time.Duration
+	}
+	Acme struct {
+		Enabled      bool
+		OrderTTL     time.Duration
+		InviteTTL    time.Duration
+		ValidityDays int
+		MaxInvites   int // Outstanding invites per referrer; 0 is unlimited
+		MaxPending   int // Outstanding orders and invites in total; 0 is unlimited
+		MaxOrders    int // Outstanding orders per certificate; 0 is unlimited
+	}
+	WebSocket struct {
+		MaxMessageSize       int
+		PublishRate          float64
+		PublishBurst         int
+		ReadBufferSize       int
+		WriteBufferSize      int
+		WriteTimeout         time.Duration
+		MaxPendingWrites     int
+		MaxSubscribeBins     int
+		MaxConnectionBins    int
+		PadBlock             int
+		PingJitter           float64
+		TimestampGranularity time.Duration
+		TimestampJitter      bool
+		EphemeralSignatures  string // off, optional or required
+		DeliveryThreshold    int    // Publish acks count deliveries in multiples of this; 0 for none
+		PresenceThreshold    int    // Presence summaries count subscribers in multiples of this; 0 for none
+	}
+	PublishPolicy struct {
+		SizeBuckets          []int
+		ThreadTagSize        int
+		ReplyToIDSize        int
+		BinACL               map[uint64][]string
+		NewBinPoWBits        int
+		PublisherIDs         []string
+		TrustedTimestampSkew time.Duration // How far back trusted internal submissions keep their timestamps
+	}
+	SubscribePolicy struct {
+		RevokedReferrers string
+		ReadOnlyCertIDs  []string
+		ReadOnlyMaxBins  int
+	}
+	Admin struct {
+		CertIDs        []string
+		TrustedIssuers []string
+		PinnedOnly     bool
+	}
+	Stats struct {
+		HistoryInterval  time.Duration
+		HistoryRetention time.Duration
+	}
+	LogShipping struct {
+		Enabled       bool
+		PublicKey     string
+		Destination   string
+		Directory     string
+		URL           string
+		BatchBytes    int
+		FlushInterval time.Duration
+		MaxFileBytes  int64
+		MaxFiles      int
+	}
+	Bandwidth struct {
+		Enabled bool
+		Classes map[string]BandwidthClass
+	}
+	Auth struct {
+		Rules []AuthRule
+	}
+	Push struct {
+		Enabled          bool
+		RegistryPath     string
+		TokenKey         string
+		MinInterval      time.Duration
+		UnifiedPushHosts []string
+		WebhookURL       string
+	}
+	Directory struct {
+		Enabled bool
+	}
+	KeyStore struct {
+		Storage          string // file or sql
+		Path             string // Empty keeps keys in memory only
+		CertificateQuota KeyQuota
+		SubtreeQuota     KeyQuota // Shared by a referral tree
+	}
+	Database struct {
+		DSN string // For the sql storage backends
+	}
+	Recovery struct {
+		Enabled       bool
+		Quarantine    bool
+		QuarantineDir string
+		ReportPath    string // Empty only logs the report
+	}
+	TLS struct {
+		Server    TLSListener
+		Discovery TLSListener
+	}
+	Discovery struct {
+		Enabled   bool
+		Address   string
+		RateLimit float64
+		Burst     int
+	}
+	Canary struct {
+		Enabled   bool
+		ServerURL string
+		Channel   uint64
+		Interval  time.Duration
+		Timeout   time.Duration
+	}
+	WarrantCanary struct {
+		PublicKey string // Operator's Ed25519 key, base64; empty disables the canary
+		Path      string
 	}
+	Tor struct {
+		Enabled      bool
+		OnionAddress string
+		HostnameFile string // Read at startup when OnionAddress is empty
+		Port         int
+		AltSvcMaxAge time.Duration // 0 leaves the Alt-Svc hint out
+	}
+	SMTP struct {
+		Enabled        bool
+		Address        string
+		Hostname       string
+		Channel        uint64   // Drop-box channel tips are published to
+		Recipients     []string // Accepted addresses; any if empty
+		MaxSize        int64
+		MaxConnections int
+		Timeout        time.Duration
+	}
+	Bulletin struct {
+		Enabled  bool
+		Path     string   // Directory of the bulletin logs
+		Channels []uint64 // Channels served as public bulletins
+	}
+	RateLimit struct {
+		Backend       string
+		RedisAddress  string
+		RedisPassword string
+		KeyPrefix     string
+		Timeout       time.Duration
+	}
+	ClientUpdate struct {
+		Enabled                    bool
+		MinProtocolVersion         int
+		RecommendedProtocolVersion int
+		Downloads                  []ClientDownload
+	}
+	Plugins struct {
+		QueueSize int
+		Enabled   []PluginConfig
+	}
+	Features struct {
+		Flags []FeatureFlag
+	}
+}
+
+// FeatureFlag declares the rollout of a gated behavior
+type FeatureFlag struct {
+	Name    string   `mapstructure:"name"`
+	Percent float64  `mapstructure:"percent"`  // Share of certificates, 0 to 100
+	Classes []string `mapstructure:"classes"`  // Bandwidth classes always included
+	CertIDs []string `mapstructure:"cert_ids"` // Certificates always included
+}
+
+// PluginConfig enables one compiled-in lifecycle event plugin
+type PluginConfig struct {
+	Name     string                 `mapstructure:"name"`
+	Settings map[string]interface{} `mapstructure:"settings"` // Passed to the plugin's factory
+}
+
+// BandwidthClass is the write shaping of one class of certificates, in bytes
+// per second; zero rates leave the limit off
+type BandwidthClass struct {
+	CertIDs         []string `mapstructure:"cert_ids"`
+	ConnectionRate  float64  `mapstructure:"connection_rate"`
+	ConnectionBurst int      `mapstructure:"connection_burst"`
+	ClassRate       float64  `mapstructure:"class_rate"`
+	ClassBurst      int      `mapstructure:"class_burst"`
+}
+
+// AuthRule is the authentication required for the endpoints under a path,
+// an endpoint or a prefix ending in "/"
+type AuthRule struct {
+	Path       string   `mapstructure:"path"`
+	ClientCert string   `mapstructure:"client_cert"` // required, optional or none
+	Roles      []string `mapstructure:"roles"`       // admin, client or server
+}
+
+// Accepted values of AuthRule fields
+var (
+	authClientCerts = map[string]bool{"required": true, "optional": true, "none": true}
+	authRoles       = map[string]bool{"admin": true, "client": true, "server": true}
+)
+
+// bandwidthClassName matches class names, which become part of metric names
+var bandwidthClassName = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// ClientDownload is a client build listed in the update manifest
+type ClientDownload struct {
+	Platform        string `mapstructure:"platform"`
+	Version         string `mapstructure:"version"`
+	ProtocolVersion int    `mapstructure:"protocol_version"`
+	URL             string `mapstructure:"url"`
+	SHA256          string `mapstructure:"sha256"`
+}
+
+// sha256Hex matches a hex SHA-256 digest
+var sha256Hex = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// TLSListener is the TLS policy of one listener
+type TLSListener struct {
+	MinVersion     string
+	CipherSuites   []string
+	Curves         []string
+	SessionTickets bool
+	ClientAuth     string
+	ALPN           []string
+}
+
+// KeyQuota is a soft and hard limit on stored key slots and bytes; zero
+// leaves a limit off
+type KeyQuota struct {
+	SoftBytes int64
+	HardBytes int64
+	SoftSlots int
+	HardSlots int
 }
 
 // LoadConfig loads the configuration from a file
@@ -30,13 +318,174 @@ func LoadConfig(configPath string) (*Config, error) {
 	viper.SetConfigType("yaml")
 	
 	// Set defaults
-	viper.SetDefault("server.address", "0.0.0.0")
+	viper.SetDefault("server.address", "")
 	viper.SetDefault("server.port", 8443)
+	viper.SetDefault("server.ip_family", listenaddr.FamilyDual)
+	viper.SetDefault("server.advertise", []string{})
+	viper.SetDefault("server.request_timeout", "30s")
+	viper.SetDefault("server.max_key_request_size", 262144)
+	viper.SetDefault("server.proxy_protocol.enabled", false)
+	viper.SetDefault("server.proxy_protocol.trusted_proxies", []string{})
+	viper.SetDefault("server.handshakes.max_concurrent", 0)
+	viper.SetDefault("server.handshakes.max_queued", 1024)
+	viper.SetDefault("server.handshakes.queue_timeout", "5s")
+	viper.SetDefault("server.handshakes.timeout", "10s")
+	viper.SetDefault("server.instance.id", "")
+	viper.SetDefault("server.instance.region", "")
 	viper.SetDefault("ca.cert_path", "certs/ca.crt")
 	viper.SetDefault("ca.key_path", "certs/ca.key")
 	viper.SetDefault("ca.organization", "Secure Messaging POC")
+	viper.SetDefault("ca.crl_urls", []string{})
+	viper.SetDefault("ca.ocsp_urls", []string{})
+	viper.SetDefault("ca.issuer_urls", []string{})
+	viper.SetDefault("ca.trust_dir", "")
+	viper.SetDefault("ca.trust_reload_interval", "1m")
+	viper.SetDefault("ca.fingerprint_list_path", "")
+	viper.SetDefault("ca.pseudonym_uris", false)
+	viper.SetDefault("ca.inherit_referrer_validity", false)
+	viper.SetDefault("ca.max_referrals", 1000000)
+	viper.SetDefault("ca.registry_path", "certs/issued.jsonl")
+	viper.SetDefault("ca.key_algorithm", string(cryptopkg.DefaultKeyAlgorithm))
+	viper.SetDefault("ca.issued_key_algorithm", string(cryptopkg.DefaultKeyAlgorithm))
 	viper.SetDefault("bin_manager.initial_mask", "0xFFFFFFFFFFFFF000")
 	viper.SetDefault("bin_manager.message_retention", "24h")
+	viper.SetDefault("bin_manager.storage", "memory")
+	viper.SetDefault("bin_manager.storage_path", "data/bins")
+	viper.SetDefault("bin_manager.snapshot_path", "")
+	viper.SetDefault("bin_manager.encryption.key", "")
+	viper.SetDefault("bin_manager.encryption.key_file", "")
+	viper.SetDefault("bin_manager.encryption.time_bucket", "1h")
+	viper.SetDefault("bin_manager.retention_budget_bytes", 0)
+	viper.SetDefault("bin_manager.retention_floor", "1h")
+	viper.SetDefault("bin_manager.pressure_check_interval", "30s")
+	viper.SetDefault("bin_manager.bin_retention.min", "1h")
+	viper.SetDefault("bin_manager.bin_retention.max", 0)
+	viper.SetDefault("bin_manager.compaction.interval", "1h")
+	viper.SetDefault("bin_manager.compaction.max_disk_bytes", 0)
+	viper.SetDefault("bin_manager.recent_cache.messages_per_bin", 0)
+	viper.SetDefault("bin_manager.recent_cache.max_bins", 1024)
+	viper.SetDefault("bin_manager.autoscale.max_subscribers", 0)
+	viper.SetDefault("bin_manager.autoscale.max_messages_per_second", 0)
+	viper.SetDefault("bin_manager.autoscale.min_bits", 0)
+	viper.SetDefault("bin_manager.autoscale.max_bits", 0)
+	viper.SetDefault("bin_manager.autoscale.interval", "1m")
+	viper.SetDefault("acme.enabled", false)
+	viper.SetDefault("acme.order_ttl", "15m")
+	viper.SetDefault("acme.invite_ttl", "24h")
+	viper.SetDefault("acme.validity_days", 30)
+	viper.SetDefault("acme.max_invites", 10)
+	viper.SetDefault("acme.max_pending", 1024)
+	viper.SetDefault("acme.max_orders", 4)
+	viper.SetDefault("websocket.max_message_size", 65536)
+	viper.SetDefault("websocket.publish_rate", 5.0)
+	viper.SetDefault("websocket.publish_burst", 20)
+	viper.SetDefault("websocket.read_buffer_size", 1024)
+	viper.SetDefault("websocket.write_buffer_size", 1024)
+	viper.SetDefault("websocket.write_timeout", "10s")
+	viper.SetDefault("websocket.max_pending_writes", 64)
+	viper.SetDefault("websocket.max_subscribe_bins", 256)
+	viper.SetDefault("websocket.max_connection_bins", 1024)
+	viper.SetDefault("websocket.pad_block", 256)
+	viper.SetDefault("websocket.ping_jitter", 0.3)
+	viper.SetDefault("websocket.timestamp_granularity", "10s")
+	viper.SetDefault("websocket.timestamp_jitter", false)
+	viper.SetDefault("websocket.ephemeral_signatures", "off")
+	viper.SetDefault("websocket.delivery_count_threshold", 0)
+	viper.SetDefault("websocket.presence_threshold", 0)
+	viper.SetDefault("publish_policy.size_buckets", []int{})
+	viper.SetDefault("publish_policy.thread_tag_size", 0)
+	viper.SetDefault("publish_policy.reply_to_id_size", 0)
+	viper.SetDefault("publish_policy.bin_acl", map[string][]string{})
+	viper.SetDefault("publish_policy.new_bin_pow_bits", 0)
+	viper.SetDefault("publish_policy.publisher_cert_ids", []string{})
+	viper.SetDefault("publish_policy.trusted_timestamp_skew", "0s")
+	viper.SetDefault("subscribe_policy.revoked_referrers", "reject")
+	viper.SetDefault("subscribe_policy.read_only.cert_ids", []string{})
+	viper.SetDefault("subscribe_policy.read_only.max_bins", 16)
+	viper.SetDefault("admin.cert_ids", []string{})
+	viper.SetDefault("admin.trusted_issuers", []string{})
+	viper.SetDefault("admin.pinned_only", false)
+	viper.SetDefault("stats.history_interval", "10s")
+	viper.SetDefault("stats.history_retention", "24h")
+	viper.SetDefault("log_shipping.enabled", false)
+	viper.SetDefault("log_shipping.public_key", "")
+	viper.SetDefault("log_shipping.destination", "file")
+	viper.SetDefault("log_shipping.directory", "data/logs")
+	viper.SetDefault("log_shipping.url", "")
+	viper.SetDefault("log_shipping.batch_bytes", 262144)
+	viper.SetDefault("log_shipping.flush_interval", "5s")
+	viper.SetDefault("log_shipping.max_file_bytes", 67108864)
+	viper.SetDefault("log_shipping.max_files", 0)
+	viper.SetDefault("bandwidth.enabled", false)
+	viper.SetDefault("auth.rules", []interface{}{})
+	viper.SetDefault("plugins.queue_size", 1024)
+	viper.SetDefault("plugins.enabled", []interface{}{})
+	viper.SetDefault("features.flags", []interface{}{})
+	viper.SetDefault("push.enabled", false)
+	viper.SetDefault("push.registry_path", "data/push.json")
+	viper.SetDefault("push.token_key", "")
+	viper.SetDefault("push.min_interval", "30s")
+	viper.SetDefault("push.unifiedpush_hosts", []string{})
+	viper.SetDefault("push.webhook_url", "")
+	viper.SetDefault("directory.enabled", false)
+	viper.SetDefault("keystore.storage", "file")
+	viper.SetDefault("keystore.path", "")
+	viper.SetDefault("database.dsn", "")
+	for _, scope := range []string{"certificate", "subtree"} {
+		for _, limit := range []string{"soft_bytes", "hard_bytes", "soft_slots", "hard_slots"} {
+			viper.SetDefault("keystore.quota."+scope+"."+limit, 0)
+		}
+	}
+	viper.SetDefault("recovery.enabled", true)
+	viper.SetDefault("recovery.quarantine", false)
+	viper.SetDefault("recovery.quarantine_dir", "data/quarantine")
+	viper.SetDefault("recovery.report_path", "data/recovery-report.json")
+	viper.SetDefault("tls.server.min_version", "1.3")
+	viper.SetDefault("tls.server.cipher_suites", []string{})
+	viper.SetDefault("tls.server.curves", []string{})
+	viper.SetDefault("tls.server.session_tickets", true)
+	viper.SetDefault("tls.server.client_auth", "require_and_verify")
+	viper.SetDefault("tls.server.alpn", []string{})
+	viper.SetDefault("tls.discovery.min_version", "1.3")
+	viper.SetDefault("tls.discovery.cipher_suites", []string{})
+	viper.SetDefault("tls.discovery.curves", []string{})
+	viper.SetDefault("tls.discovery.session_tickets", true)
+	viper.SetDefault("tls.discovery.client_auth", "none")
+	viper.SetDefault("tls.discovery.alpn", []string{})
+	viper.SetDefault("discovery.enabled", false)
+	viper.SetDefault("discovery.address", "0.0.0.0:8444")
+	viper.SetDefault("discovery.rate_limit", 1.0)
+	viper.SetDefault("discovery.burst", 10)
+	viper.SetDefault("canary.enabled", false)
+	viper.SetDefault("canary.server_url", "")
+	viper.SetDefault("canary.channel", "0xCA7A5E5")
+	viper.SetDefault("canary.interval", "30s")
+	viper.SetDefault("canary.timeout", "10s")
+	viper.SetDefault("warrant_canary.public_key", "")
+	viper.SetDefault("warrant_canary.path", "data/warrant-canary.json")
+	viper.SetDefault("tor.enabled", false)
+	viper.SetDefault("tor.onion_address", "")
+	viper.SetDefault("tor.hostname_file", "")
+	viper.SetDefault("tor.port", 443)
+	viper.SetDefault("tor.alt_svc_max_age", "0")
+	viper.SetDefault("smtp.enabled", false)
+	viper.SetDefault("smtp.address", ":2525")
+	viper.SetDefault("smtp.hostname", "localhost")
+	viper.SetDefault("smtp.channel", "")
+	viper.SetDefault("smtp.recipients", []string{})
+	viper.SetDefault("smtp.max_size", 1048576)
+	viper.SetDefault("smtp.max_connections", 100)
+	viper.SetDefault("smtp.timeout", "5m")
+	viper.SetDefault("bulletin.enabled", false)
+	viper.SetDefault("bulletin.path", "./data/bulletin")
+	viper.SetDefault("bulletin.channels", []string{})
+	viper.SetDefault("rate_limit.backend", "memory")
+	viper.SetDefault("rate_limit.redis_address", "127.0.0.1:6379")
+	viper.SetDefault("rate_limit.key_prefix", "anono:ratelimit:")
+	viper.SetDefault("rate_limit.timeout", "500ms")
+	viper.SetDefault("client_update.enabled", false)
+	viper.SetDefault("client_update.min_protocol_version", 0)
+	viper.SetDefault("client_update.recommended_protocol_version", 1)
 	
 	// Read config file
 	if err := viper.ReadInConfig(); err != nil {
@@ -52,11 +501,67 @@ func LoadConfig(configPath string) (*Config, error) {
 	// Server configuration
 	cfg.Server.Address = viper.GetString("server.address")
 	cfg.Server.Port = viper.GetInt("server.port")
+	cfg.Server.IPFamily = viper.GetString("server.ip_family")
+	_, listenAddress, err := listenaddr.Resolve(cfg.Server.IPFamily, cfg.Server.Address, cfg.Server.Port)
+	if err != nil {
+		return nil, fmt.Errorf("invalid server listener: %w", err)
+	}
+	cfg.Server.ListenAddress = listenAddress
+	cfg.Server.Advertise, err = listenaddr.Advertised(viper.GetStringSlice("server.advertise"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid server.advertise: %w", err)
+	}
+	cfg.Server.RequestTimeout = viper.GetDuration("server.request_timeout")
+	cfg.Server.MaxKeyRequestSize = viper.GetInt64("server.max_key_request_size")
+	cfg.Server.ProxyProtocol.Enabled = viper.GetBool("server.proxy_protocol.enabled")
+	cfg.Server.ProxyProtocol.TrustedProxies = viper.GetStringSlice("server.proxy_protocol.trusted_proxies")
+	if cfg.Server.ProxyProtocol.Enabled && len(cfg.Server.ProxyProtocol.TrustedProxies) == 0 {
+		return nil, fmt.Errorf("proxy protocol is enabled but server.proxy_protocol.trusted_proxies is empty")
+	}
+	cfg.Server.Handshakes.MaxConcurrent = viper.GetInt("server.handshakes.max_concurrent")
+	cfg.Server.Handshakes.MaxQueued = viper.GetInt("server.handshakes.max_queued")
+	cfg.Server.Handshakes.QueueTimeout = viper.GetDuration("server.handshakes.queue_timeout")
+	cfg.Server.Handshakes.Timeout = viper.GetDuration("server.handshakes.timeout")
+	if cfg.Server.Handshakes.MaxConcurrent < 0 || cfg.Server.Handshakes.MaxQueued < 0 {
+		return nil, fmt.Errorf("server handshake limits cannot be negative")
+	}
+	cfg.Server.Instance.ID = viper.GetString("server.instance.id")
+	cfg.Server.Instance.Region = viper.GetString("server.instance.region")
+	if cfg.Server.Instance.ID == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("server.instance.id is unset and the host name is unknown: %w", err)
+		}
+		cfg.Server.Instance.ID = hostname
+	}
 	
 	// CA configuration
 	cfg.CA.CertPath = viper.GetString("ca.cert_path")
 	cfg.CA.KeyPath = viper.GetString("ca.key_path")
 	cfg.CA.Organization = viper.GetString("ca.organization")
+	cfg.CA.CRLURLs = viper.GetStringSlice("ca.crl_urls")
+	cfg.CA.OCSPURLs = viper.GetStringSlice("ca.ocsp_urls")
+	cfg.CA.IssuerURLs = viper.GetStringSlice("ca.issuer_urls")
+	cfg.CA.TrustDir = viper.GetString("ca.trust_dir")
+	cfg.CA.TrustReload = viper.GetDuration("ca.trust_reload_interval")
+	cfg.CA.FingerprintListPath = viper.GetString("ca.fingerprint_list_path")
+	cfg.CA.PseudonymURIs = viper.GetBool("ca.pseudonym_uris")
+	cfg.CA.InheritValidity = viper.GetBool("ca.inherit_referrer_validity")
+	cfg.CA.MaxReferrals = viper.GetInt("ca.max_referrals")
+	if cfg.CA.MaxReferrals < 0 {
+		return nil, fmt.Errorf("ca.max_referrals cannot be negative")
+	}
+	cfg.CA.RegistryPath = viper.GetString("ca.registry_path")
+	keyAlgorithm, err := cryptopkg.ParseKeyAlgorithm(viper.GetString("ca.key_algorithm"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid ca.key_algorithm: %w", err)
+	}
+	cfg.CA.KeyAlgorithm = keyAlgorithm
+	issuedKeyAlgorithm, err := cryptopkg.ParseKeyAlgorithm(viper.GetString("ca.issued_key_algorithm"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid ca.issued_key_algorithm: %w", err)
+	}
+	cfg.CA.IssuedKeyAlgorithm = issuedKeyAlgorithm
 	
 	// Bin manager configuration
 	maskStr := viper.GetString("bin_manager.initial_mask")
@@ -65,6 +570,451 @@ func LoadConfig(configPath string) (*Config, error) {
 	}
 	
 	cfg.BinManager.MessageRetention = viper.GetDuration("bin_manager.message_retention")
+	cfg.BinManager.Storage = viper.GetString("bin_manager.storage")
+	cfg.BinManager.StoragePath = viper.GetString("bin_manager.storage_path")
+	cfg.BinManager.SnapshotPath = viper.GetString("bin_manager.snapshot_path")
+	cfg.BinManager.RetentionBudget = viper.GetInt64("bin_manager.retention_budget_bytes")
+	cfg.BinManager.RetentionFloor = viper.GetDuration("bin_manager.retention_floor")
+	cfg.BinManager.PressureInterval = viper.GetDuration("bin_manager.pressure_check_interval")
+	cfg.BinManager.MinBinRetention = viper.GetDuration("bin_manager.bin_retention.min")
+	cfg.BinManager.MaxBinRetention = viper.GetDuration("bin_manager.bin_retention.max")
+	if cfg.BinManager.MaxBinRetention > 0 && cfg.BinManager.MinBinRetention > cfg.BinManager.MaxBinRetention {
+		return nil, fmt.Errorf("bin retention minimum %s exceeds maximum %s",
+			cfg.BinManager.MinBinRetention, cfg.BinManager.MaxBinRetention)
+	}
+	
+	switch cfg.BinManager.Storage {
+	case "memory", "leveldb", "bolt", "sql":
+	default:
+		return nil, fmt.Errorf("unknown bin storage backend: %s", cfg.BinManager.Storage)
+	}
+	cfg.BinManager.EncryptionKey = viper.GetString("bin_manager.encryption.key")
+	cfg.BinManager.EncryptionKeyFile = viper.GetString("bin_manager.encryption.key_file")
+	if cfg.BinManager.EncryptionKey != "" && cfg.BinManager.EncryptionKeyFile != "" {
+		return nil, fmt.Errorf("set only one of bin_manager.encryption.key and key_file")
+	}
+	if cfg.BinManager.Storage != "memory" && cfg.BinManager.EncryptionKey == "" && cfg.BinManager.EncryptionKeyFile == "" {
+		return nil, fmt.Errorf("bin storage %s requires bin_manager.encryption.key or key_file", cfg.BinManager.Storage)
+	}
+	cfg.BinManager.EncryptionBucket = viper.GetDuration("bin_manager.encryption.time_bucket")
+	if cfg.BinManager.EncryptionBucket <= 0 {
+		return nil, fmt.Errorf("bin_manager.encryption.time_bucket must be positive")
+	}
+	cfg.BinManager.CompactionInterval = viper.GetDuration("bin_manager.compaction.interval")
+	cfg.BinManager.MaxDiskBytes = viper.GetInt64("bin_manager.compaction.max_disk_bytes")
+	if cfg.BinManager.CompactionInterval < 0 || cfg.BinManager.MaxDiskBytes < 0 {
+		return nil, fmt.Errorf("bin_manager.compaction settings cannot be negative")
+	}
+	cfg.BinManager.RecentCacheMessages = viper.GetInt("bin_manager.recent_cache.messages_per_bin")
+	cfg.BinManager.RecentCacheBins = viper.GetInt("bin_manager.recent_cache.max_bins")
+	if cfg.BinManager.RecentCacheMessages < 0 || cfg.BinManager.RecentCacheBins < 0 {
+		return nil, fmt.Errorf("bin_manager.recent_cache settings cannot be negative")
+	}
+	cfg.BinManager.AutoscaleSubscribers = viper.GetInt("bin_manager.autoscale.max_subscribers")
+	cfg.BinManager.AutoscaleRate = viper.GetFloat64("bin_manager.autoscale.max_messages_per_second")
+	cfg.BinManager.AutoscaleMinBits = viper.GetInt("bin_manager.autoscale.min_bits")
+	cfg.BinManager.AutoscaleMaxBits = viper.GetInt("bin_manager.autoscale.max_bits")
+	cfg.BinManager.AutoscaleInterval = viper.GetDuration("bin_manager.autoscale.interval")
+	if cfg.BinManager.AutoscaleSubscribers < 0 || cfg.BinManager.AutoscaleRate < 0 {
+		return nil, fmt.Errorf("bin_manager.autoscale thresholds cannot be negative")
+	}
+	if cfg.BinManager.AutoscaleMinBits < 0 || cfg.BinManager.AutoscaleMaxBits < 0 || cfg.BinManager.AutoscaleMaxBits > 64 {
+		return nil, fmt.Errorf("bin_manager.autoscale bit limits must be between 0 and 64")
+	}
+	if cfg.BinManager.AutoscaleMaxBits > 0 && cfg.BinManager.AutoscaleMinBits > cfg.BinManager.AutoscaleMaxBits {
+		return nil, fmt.Errorf("bin_manager.autoscale.min_bits exceeds max_bits")
+	}
+	if (cfg.BinManager.AutoscaleSubscribers > 0 || cfg.BinManager.AutoscaleRate > 0) && cfg.BinManager.AutoscaleInterval <= 0 {
+		return nil, fmt.Errorf("bin_manager.autoscale.interval must be positive")
+	}
+	
+	// Automated enrollment configuration
+	cfg.Acme.Enabled = viper.GetBool("acme.enabled")
+	cfg.Acme.OrderTTL = viper.GetDuration("acme.order_ttl")
+	cfg.Acme.InviteTTL = viper.GetDuration("acme.invite_ttl")
+	cfg.Acme.ValidityDays = viper.GetInt("acme.validity_days")
+	cfg.Acme.MaxInvites = viper.GetInt("acme.max_invites")
+	if cfg.Acme.MaxInvites < 0 {
+		return nil, fmt.Errorf("acme.max_invites cannot be negative")
+	}
+	cfg.Acme.MaxPending = viper.GetInt("acme.max_pending")
+	if cfg.Acme.MaxPending < 0 {
+		return nil, fmt.Errorf("acme.max_pending cannot be negative")
+	}
+	cfg.Acme.MaxOrders = viper.GetInt("acme.max_orders")
+	if cfg.Acme.MaxOrders < 0 {
+		return nil, fmt.Errorf("acme.max_orders cannot be negative")
+	}
+	
+	// WebSocket limits
+	cfg.WebSocket.MaxMessageSize = viper.GetInt("websocket.max_message_size")
+	cfg.WebSocket.PublishRate = viper.GetFloat64("websocket.publish_rate")
+	cfg.WebSocket.PublishBurst = viper.GetInt("websocket.publish_burst")
+	cfg.WebSocket.ReadBufferSize = viper.GetInt("websocket.read_buffer_size")
+	cfg.WebSocket.WriteBufferSize = viper.GetInt("websocket.write_buffer_size")
+	cfg.WebSocket.WriteTimeout = viper.GetDuration("websocket.write_timeout")
+	cfg.WebSocket.MaxPendingWrites = viper.GetInt("websocket.max_pending_writes")
+	cfg.WebSocket.MaxSubscribeBins = viper.GetInt("websocket.max_subscribe_bins")
+	cfg.WebSocket.MaxConnectionBins = viper.GetInt("websocket.max_connection_bins")
+	if cfg.WebSocket.MaxSubscribeBins < 0 || cfg.WebSocket.MaxConnectionBins < 0 {
+		return nil, fmt.Errorf("websocket bin limits cannot be negative")
+	}
+	cfg.WebSocket.PadBlock = viper.GetInt("websocket.pad_block")
+	cfg.WebSocket.PingJitter = viper.GetFloat64("websocket.ping_jitter")
+	if cfg.WebSocket.PingJitter < 0 || cfg.WebSocket.PingJitter >= 1 {
+		return nil, fmt.Errorf("websocket ping jitter must be at least 0 and below 1, got %g", cfg.WebSocket.PingJitter)
+	}
+	cfg.WebSocket.TimestampGranularity = viper.GetDuration("websocket.timestamp_granularity")
+	cfg.WebSocket.TimestampJitter = viper.GetBool("websocket.timestamp_jitter")
+	cfg.WebSocket.EphemeralSignatures = viper.GetString("websocket.ephemeral_signatures")
+	switch cfg.WebSocket.EphemeralSignatures {
+	case "off", "optional", "required":
+	default:
+		return nil, fmt.Errorf("unknown websocket.ephemeral_signatures mode: %s", cfg.WebSocket.EphemeralSignatures)
+	}
+	// A threshold of 1 would reveal exact counts, down to a single recipient
+	cfg.WebSocket.DeliveryThreshold = viper.GetInt("websocket.delivery_count_threshold")
+	if cfg.WebSocket.DeliveryThreshold < 0 || cfg.WebSocket.DeliveryThreshold == 1 {
+		return nil, fmt.Errorf("websocket.delivery_count_threshold must be 0 or at least 2")
+	}
+	cfg.WebSocket.PresenceThreshold = viper.GetInt("websocket.presence_threshold")
+	if cfg.WebSocket.PresenceThreshold < 0 || cfg.WebSocket.PresenceThreshold == 1 {
+		return nil, fmt.Errorf("websocket.presence_threshold must be 0 or at least 2")
+	}
+	
+	// Publish authorization policies
+	cfg.PublishPolicy.SizeBuckets = viper.GetIntSlice("publish_policy.size_buckets")
+	cfg.PublishPolicy.ThreadTagSize = viper.GetInt("publish_policy.thread_tag_size")
+	cfg.PublishPolicy.ReplyToIDSize = viper.GetInt("publish_policy.reply_to_id_size")
+	cfg.PublishPolicy.BinACL = make(map[uint64][]string)
+	for key, certIDs := range viper.GetStringMapStringSlice("publish_policy.bin_acl") {
+		binID, err := strconv.ParseUint(key, 0, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bin ID in publish ACL: %s", key)
+		}
+		cfg.PublishPolicy.BinACL[binID] = certIDs
+	}
+	cfg.PublishPolicy.NewBinPoWBits = viper.GetInt("publish_policy.new_bin_pow_bits")
+	cfg.PublishPolicy.PublisherIDs = viper.GetStringSlice("publish_policy.publisher_cert_ids")
+	if cfg.PublishPolicy.NewBinPoWBits < 0 || cfg.PublishPolicy.NewBinPoWBits > 32 {
+		return nil, fmt.Errorf("new bin proof of work must be 0-32 bits, got %d", cfg.PublishPolicy.NewBinPoWBits)
+	}
+	cfg.PublishPolicy.TrustedTimestampSkew = viper.GetDuration("publish_policy.trusted_timestamp_skew")
+	if cfg.PublishPolicy.TrustedTimestampSkew < 0 {
+		return nil, fmt.Errorf("publish_policy.trusted_timestamp_skew cannot be negative")
+	}
+	
+	// Subscribe authorization policies
+	cfg.SubscribePolicy.RevokedReferrers = viper.GetString("subscribe_policy.revoked_referrers")
+	cfg.SubscribePolicy.ReadOnlyCertIDs = viper.GetStringSlice("subscribe_policy.read_only.cert_ids")
+	cfg.SubscribePolicy.ReadOnlyMaxBins = viper.GetInt("subscribe_policy.read_only.max_bins")
+	
+	switch cfg.SubscribePolicy.RevokedReferrers {
+	case "reject", "read_only":
+	default:
+		return nil, fmt.Errorf("unknown revoked referrer policy: %s", cfg.SubscribePolicy.RevokedReferrers)
+	}
+	
+	// Admin API
+	cfg.Admin.CertIDs = viper.GetStringSlice("admin.cert_ids")
+	cfg.Admin.TrustedIssuers = viper.GetStringSlice("admin.trusted_issuers")
+	cfg.Admin.PinnedOnly = viper.GetBool("admin.pinned_only")
+	
+	// Metric history for the admin dashboard
+	cfg.Stats.HistoryInterval = viper.GetDuration("stats.history_interval")
+	cfg.Stats.HistoryRetention = viper.GetDuration("stats.history_retention")
+	
+	// Encrypted log shipping
+	cfg.LogShipping.Enabled = viper.GetBool("log_shipping.enabled")
+	cfg.LogShipping.PublicKey = viper.GetString("log_shipping.public_key")
+	cfg.LogShipping.Destination = viper.GetString("log_shipping.destination")
+	cfg.LogShipping.Directory = viper.GetString("log_shipping.directory")
+	cfg.LogShipping.URL = viper.GetString("log_shipping.url")
+	cfg.LogShipping.BatchBytes = viper.GetInt("log_shipping.batch_bytes")
+	cfg.LogShipping.FlushInterval = viper.GetDuration("log_shipping.flush_interval")
+	cfg.LogShipping.MaxFileBytes = viper.GetInt64("log_shipping.max_file_bytes")
+	cfg.LogShipping.MaxFiles = viper.GetInt("log_shipping.max_files")
+	
+	switch cfg.LogShipping.Destination {
+	case "file", "http":
+	default:
+		return nil, fmt.Errorf("unknown log shipping destination: %s", cfg.LogShipping.Destination)
+	}
+	
+	// Per-endpoint authentication
+	if err := viper.UnmarshalKey("auth.rules", &cfg.Auth.Rules); err != nil {
+		return nil, fmt.Errorf("invalid auth rules: %w", err)
+	}
+	for i, rule := range cfg.Auth.Rules {
+		if !strings.HasPrefix(rule.Path, "/") {
+			return nil, fmt.Errorf("auth rule %d: path must start with /: %q", i, rule.Path)
+		}
+		if rule.ClientCert == "" {
+			rule.ClientCert = "required"
+		}
+		if !authClientCerts[rule.ClientCert] {
+			return nil, fmt.Errorf("auth rule %s: unknown client_cert %q", rule.Path, rule.ClientCert)
+		}
+		for _, role := range rule.Roles {
+			if !authRoles[role] {
+				return nil, fmt.Errorf("auth rule %s: unknown role %q", rule.Path, role)
+			}
+		}
+		if rule.ClientCert == "none" && len(rule.Roles) > 0 {
+			return nil, fmt.Errorf("auth rule %s: roles require a client certificate", rule.Path)
+		}
+		cfg.Auth.Rules[i] = rule
+	}
+	
+	// WebSocket write shaping
+	cfg.Bandwidth.Enabled = viper.GetBool("bandwidth.enabled")
+	if err := viper.UnmarshalKey("bandwidth.classes", &cfg.Bandwidth.Classes); err != nil {
+		return nil, fmt.Errorf("invalid bandwidth classes: %w", err)
+	}
+	for name, class := range cfg.Bandwidth.Classes {
+		if !bandwidthClassName.MatchString(name) {
+			return nil, fmt.Errorf("invalid bandwidth class name: %s", name)
+		}
+		if class.ConnectionRate < 0 || class.ClassRate < 0 {
+			return nil, fmt.Errorf("bandwidth class %s has a negative rate", name)
+		}
+		
+		// Bursts default to one second at the rate
+		if class.ConnectionBurst <= 0 {
+			class.ConnectionBurst = int(class.ConnectionRate)
+		}
+		if class.ClassBurst <= 0 {
+			class.ClassBurst = int(class.ClassRate)
+		}
+		cfg.Bandwidth.Classes[name] = class
+	}
+	
+	// Wake-up pushes for clients without a permanent connection
+	cfg.Push.Enabled = viper.GetBool("push.enabled")
+	cfg.Push.RegistryPath = viper.GetString("push.registry_path")
+	cfg.Push.TokenKey = viper.GetString("push.token_key")
+	cfg.Push.MinInterval = viper.GetDuration("push.min_interval")
+	cfg.Push.UnifiedPushHosts = viper.GetStringSlice("push.unifiedpush_hosts")
+	cfg.Push.WebhookURL = viper.GetString("push.webhook_url")
+	
+	// Channel directory
+	cfg.Directory.Enabled = viper.GetBool("directory.enabled")
+	
+	// Key store
+	cfg.KeyStore.Storage = viper.GetString("keystore.storage")
+	if cfg.KeyStore.Storage != "file" && cfg.KeyStore.Storage != "sql" {
+		return nil, fmt.Errorf("unknown key store backend: %s", cfg.KeyStore.Storage)
+	}
+	cfg.KeyStore.Path = viper.GetString("keystore.path")
+	cfg.KeyStore.CertificateQuota, err = loadKeyQuota("keystore.quota.certificate")
+	if err != nil {
+		return nil, err
+	}
+	cfg.KeyStore.SubtreeQuota, err = loadKeyQuota("keystore.quota.subtree")
+	if err != nil {
+		return nil, err
+	}
+	
+	// SQL database shared by the sql backends
+	cfg.Database.DSN = viper.GetString("database.dsn")
+	if cfg.Database.DSN == "" && (cfg.BinManager.Storage == "sql" || cfg.KeyStore.Storage == "sql") {
+		return nil, fmt.Errorf("sql storage requires database.dsn")
+	}
+	
+	// Startup consistency check
+	cfg.Recovery.Enabled = viper.GetBool("recovery.enabled")
+	cfg.Recovery.Quarantine = viper.GetBool("recovery.quarantine")
+	cfg.Recovery.QuarantineDir = viper.GetString("recovery.quarantine_dir")
+	cfg.Recovery.ReportPath = viper.GetString("recovery.report_path")
+	if cfg.Recovery.Quarantine && cfg.Recovery.QuarantineDir == "" {
+		return nil, fmt.Errorf("recovery.quarantine requires recovery.quarantine_dir")
+	}
+	
+	if cfg.Push.Enabled && cfg.Push.TokenKey == "" {
+		return nil, fmt.Errorf("push is enabled but push.token_key is not set")
+	}
+	
+	// Per-listener TLS policy
+	cfg.TLS.Server = loadTLSListener("tls.server")
+	cfg.TLS.Discovery = loadTLSListener("tls.discovery")
+	
+	// The server listener identifies clients by their certificate, so it
+	// must verify every certificate it accepts; rules that serve clients
+	// without one need verify_if_given
+	switch cfg.TLS.Server.ClientAuth {
+	case "verify_if_given", "require_and_verify":
+	default:
+		return nil, fmt.Errorf("tls.server.client_auth must be verify_if_given or require_and_verify, got %q", cfg.TLS.Server.ClientAuth)
+	}
+	for _, rule := range cfg.Auth.Rules {
+		if rule.ClientCert != "required" && cfg.TLS.Server.ClientAuth != "verify_if_given" {
+			return nil, fmt.Errorf("auth rule %s: client_cert %s requires tls.server.client_auth verify_if_given", rule.Path, rule.ClientCert)
+		}
+	}
+	
+	// Unauthenticated discovery listener configuration
+	cfg.Discovery.Enabled = viper.GetBool("discovery.enabled")
+	cfg.Discovery.Address = viper.GetString("discovery.address")
+	cfg.Discovery.RateLimit = viper.GetFloat64("discovery.rate_limit")
+	cfg.Discovery.Burst = viper.GetInt("discovery.burst")
+	
+	// End-to-end canary probe
+	cfg.Canary.Enabled = viper.GetBool("canary.enabled")
+	cfg.Canary.ServerURL = viper.GetString("canary.server_url")
+	if cfg.Canary.ServerURL == "" {
+		cfg.Canary.ServerURL = fmt.Sprintf("https://localhost:%d", cfg.Server.Port)
+	}
+	channelStr := viper.GetString("canary.channel")
+	if _, err := fmt.Sscanf(channelStr, "0x%X", &cfg.Canary.Channel); err != nil {
+		return nil, fmt.Errorf("invalid canary channel: %s", channelStr)
+	}
+	cfg.Canary.Interval = viper.GetDuration("canary.interval")
+	cfg.Canary.Timeout = viper.GetDuration("canary.timeout")
+	
+	// Retention past the build's ceiling is refused here rather than
+	// silently shortened
+	if err := legalhold.CheckRetention(cfg.BinManager.MessageRetention); err != nil {
+		return nil, fmt.Errorf("bin_manager.message_retention: %w", err)
+	}
+	if err := legalhold.CheckRetention(cfg.BinManager.MaxBinRetention); err != nil {
+		return nil, fmt.Errorf("bin_manager.bin_retention.max: %w", err)
+	}
+	
+	// Operator-signed warrant canary
+	cfg.WarrantCanary.PublicKey = viper.GetString("warrant_canary.public_key")
+	cfg.WarrantCanary.Path = viper.GetString("warrant_canary.path")
+	if cfg.WarrantCanary.PublicKey != "" && cfg.WarrantCanary.Path == "" {
+		return nil, fmt.Errorf("warrant_canary.path is required with a public key")
+	}
+	
+	// Onion service advertised in the server info
+	cfg.Tor.Enabled = viper.GetBool("tor.enabled")
+	cfg.Tor.OnionAddress = viper.GetString("tor.onion_address")
+	cfg.Tor.HostnameFile = viper.GetString("tor.hostname_file")
+	cfg.Tor.Port = viper.GetInt("tor.port")
+	cfg.Tor.AltSvcMaxAge = viper.GetDuration("tor.alt_svc_max_age")
+	if cfg.Tor.Enabled && cfg.Tor.OnionAddress == "" && cfg.Tor.HostnameFile == "" {
+		return nil, fmt.Errorf("tor is enabled but neither tor.onion_address nor tor.hostname_file is set")
+	}
+	if cfg.Tor.Port <= 0 || cfg.Tor.Port > 65535 {
+		return nil, fmt.Errorf("invalid tor.port: %d", cfg.Tor.Port)
+	}
+	
+	// SMTP gateway for encrypted tips
+	cfg.SMTP.Enabled = viper.GetBool("smtp.enabled")
+	cfg.SMTP.Address = viper.GetString("smtp.address")
+	cfg.SMTP.Hostname = viper.GetString("smtp.hostname")
+	cfg.SMTP.Recipients = viper.GetStringSlice("smtp.recipients")
+	cfg.SMTP.MaxSize = viper.GetInt64("smtp.max_size")
+	cfg.SMTP.MaxConnections = viper.GetInt("smtp.max_connections")
+	cfg.SMTP.Timeout = viper.GetDuration("smtp.timeout")
+	if cfg.SMTP.Enabled {
+		channelStr := viper.GetString("smtp.channel")
+		if _, err := fmt.Sscanf(channelStr, "0x%X", &cfg.SMTP.Channel); err != nil {
+			return nil, fmt.Errorf("invalid smtp.channel: %q", channelStr)
+		}
+		if cfg.SMTP.MaxSize <= 0 {
+			return nil, fmt.Errorf("invalid smtp.max_size: %d", cfg.SMTP.MaxSize)
+		}
+	}
+	
+	// Public bulletin channels
+	cfg.Bulletin.Enabled = viper.GetBool("bulletin.enabled")
+	cfg.Bulletin.Path = viper.GetString("bulletin.path")
+	for _, channelStr := range viper.GetStringSlice("bulletin.channels") {
+		var channel uint64
+		if _, err := fmt.Sscanf(channelStr, "0x%X", &channel); err != nil {
+			return nil, fmt.Errorf("invalid bulletin channel: %q", channelStr)
+		}
+		cfg.Bulletin.Channels = append(cfg.Bulletin.Channels, channel)
+	}
+	if cfg.Bulletin.Enabled && len(cfg.Bulletin.Channels) == 0 {
+		return nil, fmt.Errorf("bulletin.channels must list at least one channel")
+	}
+	
+	// Where publish quotas are kept
+	cfg.RateLimit.Backend = viper.GetString("rate_limit.backend")
+	cfg.RateLimit.RedisAddress = viper.GetString("rate_limit.redis_address")
+	cfg.RateLimit.RedisPassword = viper.GetString("rate_limit.redis_password")
+	cfg.RateLimit.KeyPrefix = viper.GetString("rate_limit.key_prefix")
+	cfg.RateLimit.Timeout = viper.GetDuration("rate_limit.timeout")
+	switch cfg.RateLimit.Backend {
+	case "memory", "redis":
+	default:
+		return nil, fmt.Errorf("unknown rate limit backend: %s", cfg.RateLimit.Backend)
+	}
+	
+	// Signed client update manifest and minimum protocol version
+	cfg.ClientUpdate.Enabled = viper.GetBool("client_update.enabled")
+	cfg.ClientUpdate.MinProtocolVersion = viper.GetInt("client_update.min_protocol_version")
+	cfg.ClientUpdate.RecommendedProtocolVersion = viper.GetInt("client_update.recommended_protocol_version")
+	if err := viper.UnmarshalKey("client_update.downloads", &cfg.ClientUpdate.Downloads); err != nil {
+		return nil, fmt.Errorf("invalid client downloads: %w", err)
+	}
+	if cfg.ClientUpdate.MinProtocolVersion < 0 || cfg.ClientUpdate.RecommendedProtocolVersion < cfg.ClientUpdate.MinProtocolVersion {
+		return nil, fmt.Errorf("client_update.recommended_protocol_version must be at least min_protocol_version, which must not be negative")
+	}
+	for _, download := range cfg.ClientUpdate.Downloads {
+		if download.Platform == "" || download.URL == "" {
+			return nil, fmt.Errorf("client download needs a platform and url")
+		}
+		if !sha256Hex.MatchString(download.SHA256) {
+			return nil, fmt.Errorf("client download %s %s: sha256 must be a lowercase hex digest", download.Platform, download.Version)
+		}
+	}
+	
+	// Lifecycle event plugins compiled into the binary
+	cfg.Plugins.QueueSize = viper.GetInt("plugins.queue_size")
+	if err := viper.UnmarshalKey("plugins.enabled", &cfg.Plugins.Enabled); err != nil {
+		return nil, fmt.Errorf("invalid plugins: %w", err)
+	}
+	if cfg.Plugins.QueueSize <= 0 {
+		return nil, fmt.Errorf("plugins.queue_size must be positive")
+	}
+	for _, p := range cfg.Plugins.Enabled {
+		if p.Name == "" {
+			return nil, fmt.Errorf("plugin needs a name")
+		}
+	}
+	
+	// Gradual rollout of gated behaviors; names are checked by the server
+	if err := viper.UnmarshalKey("features.flags", &cfg.Features.Flags); err != nil {
+		return nil, fmt.Errorf("invalid feature flags: %w", err)
+	}
+	for _, flag := range cfg.Features.Flags {
+		if flag.Percent < 0 || flag.Percent > 100 {
+			return nil, fmt.Errorf("feature flag %s: percent must be between 0 and 100", flag.Name)
+		}
+	}
 	
 	return &cfg, nil
-}
\ No newline at end of file
+}
+
+// loadTLSListener reads the TLS policy under key
+func loadTLSListener(key string) TLSListener {
+	return TLSListener{
+		MinVersion:     viper.GetString(key + ".min_version"),
+		CipherSuites:   viper.GetStringSlice(key + ".cipher_suites"),
+		Curves:         viper.GetStringSlice(key + ".curves"),
+		SessionTickets: viper.GetBool(key + ".session_tickets"),
+		ClientAuth:     viper.GetString(key + ".client_auth"),
+		ALPN:           viper.GetStringSlice(key + ".alpn"),
+	}
+}
+
+// loadKeyQuota reads the key storage quota under key
+func loadKeyQuota(key string) (KeyQuota, error) {
+	quota := KeyQuota{
+		SoftBytes: viper.GetInt64(key + ".soft_bytes"),
+		HardBytes: viper.GetInt64(key + ".hard_bytes"),
+		SoftSlots: viper.GetInt(key + ".soft_slots"),
+		HardSlots: viper.GetInt(key + ".hard_slots"),
+	}
+	if quota.SoftBytes < 0 || quota.HardBytes < 0 || quota.SoftSlots < 0 || quota.HardSlots < 0 {
+		return quota, fmt.Errorf("%s limits cannot be negative", key)
+	}
+	if (quota.HardBytes > 0 && quota.SoftBytes > quota.HardBytes) || (quota.HardSlots > 0 && quota.SoftSlots > quota.HardSlots) {
+		return quota, fmt.Errorf("%s soft limits cannot exceed hard limits", key)
+	}
+	return quota, nil
+}
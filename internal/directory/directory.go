@@ -0,0 +1,147 @@
+// Package directory lets communities publish discoverable channels without
+// the server learning what they are. Clients file encrypted channel
+// descriptors under opaque tags, typically derived from a shared secret, and
+// look listings up by tag. The server sees only tags and ciphertext.
+package directory
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"regexp"
+	"time"
+
+	"github.com/yourusername/secure-messaging-poc/internal/keystore"
+)
+
+// Directory limits
+const (
+	MaxDescriptorSize = 4096
+	MaxListingsPerTag = 1000
+	DefaultPageSize   = 50
+	MaxPageSize       = 200
+)
+
+var (
+	// ErrInvalidTag is returned for a tag that is empty, too long or not
+	// base64url or hex text
+	ErrInvalidTag = errors.New("directory: invalid tag")
+	// ErrInvalidListing is returned for an empty or oversized descriptor
+	ErrInvalidListing = errors.New("directory: invalid listing")
+	// ErrTagFull is returned when a tag already holds MaxListingsPerTag
+	// listings
+	ErrTagFull = errors.New("directory: tag has too many listings")
+	// ErrNotListed is returned when the caller has no listing under a tag
+	ErrNotListed = errors.New("directory: no listing under tag")
+)
+
+// validTag matches the opaque tags clients file listings under
+var validTag = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// Listing is a channel descriptor as returned to clients. The descriptor,
+// nonce and MAC are opaque to the server.
+type Listing struct {
+	ID         string    `json:"listing_id"`
+	Descriptor []byte    `json:"descriptor"`
+	Nonce      []byte    `json:"nonce,omitempty"`
+	MAC        []byte    `json:"mac,omitempty"`
+	Version    uint64    `json:"version"`
+	Updated    time.Time `json:"updated"`
+}
+
+// Directory stores listings in a key store, one entry per tag with a slot
+// per listing. A certificate holds at most one listing per tag; its slot is
+// derived from the tag and the certificate ID, so listings do not reveal who
+// published them.
+type Directory struct {
+	store *keystore.EncryptedKeyStore
+}
+
+// New creates a directory backed by store, which should not hold anything
+// else
+func New(store *keystore.EncryptedKeyStore) *Directory {
+	return &Directory{store: store}
+}
+
+// Publish files or replaces certID's listing under tag and returns it
+func (d *Directory) Publish(certID, tag string, descriptor, nonce, mac []byte) (Listing, error) {
+	if !validTag.MatchString(tag) {
+		return Listing{}, ErrInvalidTag
+	}
+	if len(descriptor) == 0 || len(descriptor)+len(nonce)+len(mac) > MaxDescriptorSize {
+		return Listing{}, ErrInvalidListing
+	}
+
+	id := listingID(tag, certID)
+	manifest := d.store.Manifest(tag)
+	if _, exists := manifest[id]; !exists && len(manifest) >= MaxListingsPerTag {
+		return Listing{}, ErrTagFull
+	}
+
+	if _, err := d.store.StoreSlot(tag, id, descriptor, nonce, mac); err != nil {
+		return Listing{}, err
+	}
+	entry, err := d.store.GetSlot(tag, id)
+	if err != nil {
+		return Listing{}, err
+	}
+	return listing(entry), nil
+}
+
+// Remove deletes certID's listing under tag
+func (d *Directory) Remove(certID, tag string) error {
+	if !validTag.MatchString(tag) {
+		return ErrInvalidTag
+	}
+	if err := d.store.DeleteSlot(tag, listingID(tag, certID)); err != nil {
+		return ErrNotListed
+	}
+	return nil
+}
+
+// List returns up to limit listings under tag in listing ID order, starting
+// after the cursor, and the cursor for the next page, which is empty after
+// the last one
+func (d *Directory) List(tag, cursor string, limit int) ([]Listing, string, error) {
+	if !validTag.MatchString(tag) {
+		return nil, "", ErrInvalidTag
+	}
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+
+	// Sync against an empty manifest returns every slot, sorted
+	entries, _ := d.store.Sync(tag, nil)
+	listings := make([]Listing, 0, limit)
+	for _, entry := range entries {
+		if entry.Slot <= cursor {
+			continue
+		}
+		if len(listings) == limit {
+			return listings, listings[limit-1].ID, nil
+		}
+		listings = append(listings, listing(entry))
+	}
+	return listings, "", nil
+}
+
+// listingID is the slot of certID's listing under tag
+func listingID(tag, certID string) string {
+	sum := sha256.Sum256([]byte(tag + "\x00" + certID))
+	return hex.EncodeToString(sum[:16])
+}
+
+// listing converts a key store entry
+func listing(entry keystore.EncryptedKeyData) Listing {
+	return Listing{
+		ID:         entry.Slot,
+		Descriptor: entry.EncryptedKey,
+		Nonce:      entry.IV,
+		MAC:        entry.HMAC,
+		Version:    entry.Version,
+		Updated:    entry.UpdatedAt,
+	}
+}
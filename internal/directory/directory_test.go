@@ -0,0 +1,123 @@
+package directory
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/yourusername/secure-messaging-poc/internal/keystore"
+)
+
+func TestPublishReplacesListing(t *testing.T) {
+	d := New(keystore.NewEncryptedKeyStore())
+
+	first, err := d.Publish("cert-a", "community_1", []byte("v1"), []byte("nonce"), nil)
+	if err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	second, err := d.Publish("cert-a", "community_1", []byte("v2"), []byte("nonce"), nil)
+	if err != nil {
+		t.Fatalf("Republish failed: %v", err)
+	}
+	if second.ID != first.ID || second.Version != 2 {
+		t.Errorf("Republish should update the listing in place: %+v then %+v", first, second)
+	}
+
+	listings, next, err := d.List("community_1", "", 0)
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(listings) != 1 || next != "" || !bytes.Equal(listings[0].Descriptor, []byte("v2")) {
+		t.Errorf("Unexpected listings %+v, next %q", listings, next)
+	}
+
+	// The same certificate gets an unrelated ID under another tag
+	other, _ := d.Publish("cert-a", "community_2", []byte("v1"), nil, nil)
+	if other.ID == first.ID {
+		t.Error("Listing IDs should differ between tags")
+	}
+}
+
+func TestPublishValidation(t *testing.T) {
+	d := New(keystore.NewEncryptedKeyStore())
+
+	for _, tag := range []string{"", "has space", "slash/tag", string(bytes.Repeat([]byte("a"), 65))} {
+		if _, err := d.Publish("cert-a", tag, []byte("d"), nil, nil); err != ErrInvalidTag {
+			t.Errorf("Tag %q: expected ErrInvalidTag, got %v", tag, err)
+		}
+	}
+	if _, err := d.Publish("cert-a", "tag", nil, nil, nil); err != ErrInvalidListing {
+		t.Errorf("Expected ErrInvalidListing for an empty descriptor, got %v", err)
+	}
+	if _, err := d.Publish("cert-a", "tag", make([]byte, MaxDescriptorSize+1), nil, nil); err != ErrInvalidListing {
+		t.Errorf("Expected ErrInvalidListing for an oversized descriptor, got %v", err)
+	}
+}
+
+func TestListPagination(t *testing.T) {
+	d := New(keystore.NewEncryptedKeyStore())
+	for i := 0; i < 5; i++ {
+		if _, err := d.Publish(fmt.Sprintf("cert-%d", i), "tag", []byte("d"), nil, nil); err != nil {
+			t.Fatalf("Publish failed: %v", err)
+		}
+	}
+
+	seen := make(map[string]bool)
+	cursor := ""
+	for page := 0; ; page++ {
+		listings, next, err := d.List("tag", cursor, 2)
+		if err != nil {
+			t.Fatalf("List failed: %v", err)
+		}
+		for _, l := range listings {
+			if seen[l.ID] {
+				t.Errorf("Listing %s returned twice", l.ID)
+			}
+			seen[l.ID] = true
+		}
+		if next == "" {
+			break
+		}
+		if page > 5 {
+			t.Fatal("Pagination did not terminate")
+		}
+		cursor = next
+	}
+	if len(seen) != 5 {
+		t.Errorf("Expected 5 listings across pages, got %d", len(seen))
+	}
+}
+
+func TestRemove(t *testing.T) {
+	d := New(keystore.NewEncryptedKeyStore())
+	d.Publish("cert-a", "tag", []byte("a"), nil, nil)
+	d.Publish("cert-b", "tag", []byte("b"), nil, nil)
+
+	if err := d.Remove("cert-c", "tag"); err != ErrNotListed {
+		t.Errorf("Expected ErrNotListed for a certificate without a listing, got %v", err)
+	}
+	if err := d.Remove("cert-a", "tag"); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+
+	listings, _, _ := d.List("tag", "", 0)
+	if len(listings) != 1 || !bytes.Equal(listings[0].Descriptor, []byte("b")) {
+		t.Errorf("Only the other listing should remain: %+v", listings)
+	}
+}
+
+func TestTagFull(t *testing.T) {
+	d := New(keystore.NewEncryptedKeyStore())
+	for i := 0; i < MaxListingsPerTag; i++ {
+		if _, err := d.Publish(fmt.Sprintf("cert-%d", i), "tag", []byte("d"), nil, nil); err != nil {
+			t.Fatalf("Publish %d failed: %v", i, err)
+		}
+	}
+
+	if _, err := d.Publish("cert-new", "tag", []byte("d"), nil, nil); err != ErrTagFull {
+		t.Errorf("Expected ErrTagFull, got %v", err)
+	}
+	if _, err := d.Publish("cert-0", "tag", []byte("d2"), nil, nil); err != nil {
+		t.Errorf("Existing listings should still update in a full tag: %v", err)
+	}
+}
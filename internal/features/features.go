@@ -0,0 +1,189 @@
+// Package features gates behaviors that are being rolled out. A flag turns
+// its behavior on for certificates listed by ID, for certificate classes,
+// and for a percentage of all other certificates. A certificate's place in
+// the percentage is derived from the flag name and certificate ID, so it is
+// stable across reconnects and restarts, and raising the percentage only
+// ever adds certificates. Operators override flags at runtime through the
+// admin API; overrides last until they are reset or the server restarts.
+package features
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	cryptopkg "github.com/yourusername/secure-messaging-poc/pkg/crypto"
+)
+
+var (
+	// ErrUnknownFlag is returned for a flag the server does not gate
+	// anything with
+	ErrUnknownFlag = errors.New("features: unknown flag")
+	// ErrInvalidFlag is returned for a flag with an invalid percentage
+	ErrInvalidFlag = errors.New("features: invalid flag")
+)
+
+// percentBuckets is the resolution of rollout percentages
+const percentBuckets = 10000
+
+// flagName matches flag names, which appear in configuration and URLs
+var flagName = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// Flag is the rollout of one behavior
+type Flag struct {
+	Name    string   `json:"name"`
+	Percent float64  `json:"percent"`            // Share of certificates, 0 to 100
+	Classes []string `json:"classes,omitempty"`  // Certificate classes always included
+	CertIDs []string `json:"cert_ids,omitempty"` // Certificates always included
+}
+
+// Subject is the connection a flag is evaluated for
+type Subject struct {
+	CertID string
+	Class  string
+}
+
+// Enabled reports whether the flag turns its behavior on for subject
+func (f Flag) Enabled(subject Subject) bool {
+	for _, certID := range f.CertIDs {
+		if cryptopkg.ConstantTimeEqualString(certID, subject.CertID) {
+			return true
+		}
+	}
+	for _, class := range f.Classes {
+		if class == subject.Class {
+			return true
+		}
+	}
+	return f.bucket(subject.CertID) < uint64(f.Percent*percentBuckets/100)
+}
+
+// bucket places a certificate in the flag's rollout order
+func (f Flag) bucket(certID string) uint64 {
+	sum := sha256.Sum256([]byte(f.Name + "\x00" + certID))
+	return binary.BigEndian.Uint64(sum[:8]) % percentBuckets
+}
+
+// validate checks a flag for one of the known names
+func (f Flag) validate(known map[string]bool) error {
+	switch {
+	case !flagName.MatchString(f.Name) || !known[f.Name]:
+		return fmt.Errorf("%w %q", ErrUnknownFlag, f.Name)
+	case f.Percent < 0 || f.Percent > 100:
+		return fmt.Errorf("%w: %s percent must be between 0 and 100", ErrInvalidFlag, f.Name)
+	}
+	return nil
+}
+
+// State is a known flag as currently in effect. An undeclared flag gates
+// nothing: its behavior runs for every certificate, as it would without
+// the flag.
+type State struct {
+	Flag
+	Declared   bool `json:"declared"`
+	Overridden bool `json:"overridden"`
+}
+
+// Set holds the configured flags and the runtime overrides. Lookups on a
+// nil *Set find no flags.
+type Set struct {
+	known      map[string]bool
+	configured map[string]Flag
+	overrides  map[string]Flag
+	effective  atomic.Pointer[map[string]Flag] // Read on every lookup without s.mu
+	mu         sync.Mutex
+}
+
+// NewSet creates a set of the flags known by the server with the
+// configured ones declared
+func NewSet(known []string, configured []Flag) (*Set, error) {
+	s := &Set{
+		known:      make(map[string]bool, len(known)),
+		configured: make(map[string]Flag, len(configured)),
+		overrides:  make(map[string]Flag),
+	}
+	for _, name := range known {
+		s.known[name] = true
+	}
+	for _, flag := range configured {
+		if err := flag.validate(s.known); err != nil {
+			return nil, err
+		}
+		if _, exists := s.configured[flag.Name]; exists {
+			return nil, fmt.Errorf("%w: %s declared twice", ErrInvalidFlag, flag.Name)
+		}
+		s.configured[flag.Name] = flag
+	}
+	s.publishLocked()
+	return s, nil
+}
+
+// Lookup returns the flag in effect for name, if it is declared
+func (s *Set) Lookup(name string) (Flag, bool) {
+	if s == nil {
+		return Flag{}, false
+	}
+	flag, declared := (*s.effective.Load())[name]
+	return flag, declared
+}
+
+// Override replaces the flag of the same name until it is reset
+func (s *Set) Override(flag Flag) error {
+	if err := flag.validate(s.known); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.overrides[flag.Name] = flag
+	s.publishLocked()
+	return nil
+}
+
+// Reset drops the override of name, restoring the configured flag if any
+func (s *Set) Reset(name string) error {
+	if !s.known[name] {
+		return fmt.Errorf("%w %q", ErrUnknownFlag, name)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.overrides, name)
+	s.publishLocked()
+	return nil
+}
+
+// States returns every known flag, sorted by name
+func (s *Set) States() []State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	effective := *s.effective.Load()
+	states := make([]State, 0, len(s.known))
+	for name := range s.known {
+		flag, declared := effective[name]
+		if !declared {
+			flag.Name = name
+		}
+		_, overridden := s.overrides[name]
+		states = append(states, State{Flag: flag, Declared: declared, Overridden: overridden})
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Name < states[j].Name })
+	return states
+}
+
+// publishLocked replaces the flags lookups see; callers hold s.mu or have
+// not shared s yet
+func (s *Set) publishLocked() {
+	effective := make(map[string]Flag, len(s.configured)+len(s.overrides))
+	for name, flag := range s.configured {
+		effective[name] = flag
+	}
+	for name, flag := range s.overrides {
+		effective[name] = flag
+	}
+	s.effective.Store(&effective)
+}
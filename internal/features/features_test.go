@@ -0,0 +1,84 @@
+package features
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestFlagRollout(t *testing.T) {
+	flag := Flag{Name: "experiment", Percent: 25, Classes: []string{"admin"}, CertIDs: []string{"pinned"}}
+
+	if !flag.Enabled(Subject{CertID: "pinned"}) || !flag.Enabled(Subject{CertID: "x", Class: "admin"}) {
+		t.Error("Listed certificates and classes should always be enabled")
+	}
+
+	// Roughly the configured share is enabled, and raising the percentage
+	// keeps every certificate that was already in
+	wider := flag
+	wider.Percent = 50
+	enabled := 0
+	for i := 0; i < 4000; i++ {
+		subject := Subject{CertID: fmt.Sprintf("cert%d", i), Class: "default"}
+		if flag.Enabled(subject) {
+			enabled++
+			if !wider.Enabled(subject) {
+				t.Fatalf("%s dropped out when the rollout widened", subject.CertID)
+			}
+		}
+	}
+	if enabled < 800 || enabled > 1200 {
+		t.Errorf("Expected about 1000 of 4000 certificates enabled, got %d", enabled)
+	}
+
+	if (Flag{Name: "off"}).Enabled(Subject{CertID: "cert1"}) {
+		t.Error("A flag at 0 percent should be off")
+	}
+	if !(Flag{Name: "on", Percent: 100}).Enabled(Subject{CertID: "cert1"}) {
+		t.Error("A flag at 100 percent should be on")
+	}
+}
+
+func TestSetOverrides(t *testing.T) {
+	known := []string{"alpha", "beta"}
+	if _, err := NewSet(known, []Flag{{Name: "gamma"}}); !errors.Is(err, ErrUnknownFlag) {
+		t.Errorf("Expected ErrUnknownFlag for an unknown configured flag, got %v", err)
+	}
+	if _, err := NewSet(known, []Flag{{Name: "alpha", Percent: 101}}); !errors.Is(err, ErrInvalidFlag) {
+		t.Errorf("Expected ErrInvalidFlag for a percentage over 100, got %v", err)
+	}
+
+	set, err := NewSet(known, []Flag{{Name: "alpha", Percent: 10}})
+	if err != nil {
+		t.Fatalf("Failed to create set: %v", err)
+	}
+	if _, declared := set.Lookup("beta"); declared {
+		t.Error("beta should not be declared")
+	}
+
+	if err := set.Override(Flag{Name: "alpha", Percent: 100}); err != nil {
+		t.Fatalf("Override failed: %v", err)
+	}
+	if flag, _ := set.Lookup("alpha"); flag.Percent != 100 {
+		t.Errorf("Expected the override in effect, got %+v", flag)
+	}
+	states := set.States()
+	if len(states) != 2 || states[0].Name != "alpha" || !states[0].Overridden || states[1].Declared {
+		t.Errorf("Unexpected states %+v", states)
+	}
+
+	if err := set.Reset("alpha"); err != nil {
+		t.Fatalf("Reset failed: %v", err)
+	}
+	if flag, _ := set.Lookup("alpha"); flag.Percent != 10 {
+		t.Errorf("Expected the configured flag after reset, got %+v", flag)
+	}
+	if err := set.Override(Flag{Name: "gamma"}); !errors.Is(err, ErrUnknownFlag) {
+		t.Errorf("Expected ErrUnknownFlag for an unknown override, got %v", err)
+	}
+
+	var none *Set
+	if _, declared := none.Lookup("alpha"); declared {
+		t.Error("A nil set should declare nothing")
+	}
+}
@@ -0,0 +1,106 @@
+package keystore
+
+import (
+	"crypto/x509"
+	"errors"
+	"time"
+
+	"github.com/yourusername/secure-messaging-poc/pkg/crypto"
+)
+
+var (
+	// ErrAccessDenied is returned when a caller may not touch a key slot
+	ErrAccessDenied = errors.New("access to key denied")
+
+	// ErrGrantInvalid is returned for grants that fail verification
+	ErrGrantInvalid = errors.New("grant is invalid")
+
+	// ErrGrantExpired is returned for grants past their expiry
+	ErrGrantExpired = errors.New("grant has expired")
+
+	// ErrKeyUnavailable is reported to callers in place of ErrKeyNotFound
+	// and the access errors, so a response never tells whether a
+	// certificate has stored keys
+	ErrKeyUnavailable = errors.New("key not found")
+)
+
+// Conceal returns ErrKeyUnavailable for errors that differ between a missing
+// key and a key the caller may not access, and err otherwise. Grant errors
+// are included: a grant naming a certificate without keys must fail the
+// same way as one naming a certificate whose keys it does not unlock.
+func Conceal(err error) error {
+	for _, revealing := range []error{ErrKeyNotFound, ErrAccessDenied, ErrGrantInvalid, ErrGrantExpired} {
+		if errors.Is(err, revealing) {
+			return ErrKeyUnavailable
+		}
+	}
+	return err
+}
+
+// AccessPolicy decides which key slots an authenticated certificate may use.
+// Callers always act on their own certificate ID; reading another slot
+// requires a Grant signed by a valid, unrevoked certificate of its owner.
+type AccessPolicy struct {
+	roots     *x509.CertPool
+	isRevoked func(certID string) bool
+	now       func() time.Time
+}
+
+// NewAccessPolicy creates a policy trusting grants from certificates issued
+// under roots. isRevoked may be nil.
+func NewAccessPolicy(roots *x509.CertPool, isRevoked func(certID string) bool) *AccessPolicy {
+	if isRevoked == nil {
+		isRevoked = func(string) bool { return false }
+	}
+	return &AccessPolicy{
+		roots:     roots,
+		isRevoked: isRevoked,
+		now:       time.Now,
+	}
+}
+
+// AuthorizeWrite returns the slot the caller may write. requestedID is the
+// slot named by the request, if any; it must be the caller's own.
+func (ap *AccessPolicy) AuthorizeWrite(callerID, requestedID string) (string, error) {
+	if callerID == "" || ap.isRevoked(callerID) {
+		return "", ErrAccessDenied
+	}
+	if requestedID != "" && !crypto.ConstantTimeEqualString(requestedID, callerID) {
+		return "", ErrAccessDenied
+	}
+	return callerID, nil
+}
+
+// AuthorizeRead returns the slot the caller may read. Reading a slot other
+// than the caller's own requires a grant from that slot's owner.
+func (ap *AccessPolicy) AuthorizeRead(callerID, requestedID string, grant *Grant) (string, error) {
+	if callerID == "" || ap.isRevoked(callerID) {
+		return "", ErrAccessDenied
+	}
+	if requestedID == "" || crypto.ConstantTimeEqualString(requestedID, callerID) {
+		return callerID, nil
+	}
+	if grant == nil || !crypto.ConstantTimeEqualString(grant.OwnerID, requestedID) {
+		return "", ErrAccessDenied
+	}
+
+	now := ap.now()
+	ownerCert, err := grant.Verify(callerID, now)
+	if err != nil {
+		return "", err
+	}
+
+	// The signing certificate must still be trusted by this server
+	if _, err := ownerCert.Verify(x509.VerifyOptions{
+		Roots:       ap.roots,
+		CurrentTime: now,
+		KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		return "", ErrGrantInvalid
+	}
+	if ap.isRevoked(grant.OwnerID) {
+		return "", ErrAccessDenied
+	}
+
+	return requestedID, nil
+}
@@ -0,0 +1,209 @@
+package keystore
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/yourusername/secure-messaging-poc/internal/certmanager"
+)
+
+// testPKI is a throwaway CA with helpers to issue client certificates
+type testPKI struct {
+	caCert *x509.Certificate
+	caKey  *ecdsa.PrivateKey
+	roots  *x509.CertPool
+	serial int64
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate CA key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create CA certificate: %v", err)
+	}
+	caCert, _ := x509.ParseCertificate(der)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(caCert)
+
+	return &testPKI{caCert: caCert, caKey: key, roots: roots, serial: 1}
+}
+
+// issue creates a client certificate and key signed by the test CA
+func (p *testPKI) issue(t *testing.T) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate client key: %v", err)
+	}
+
+	p.serial++
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(p.serial),
+		Subject:      pkix.Name{CommonName: "client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, p.caCert, &key.PublicKey, p.caKey)
+	if err != nil {
+		t.Fatalf("Failed to create client certificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+
+	return cert, key
+}
+
+func TestAuthorizeWrite(t *testing.T) {
+	policy := NewAccessPolicy(x509.NewCertPool(), nil)
+
+	id, err := policy.AuthorizeWrite("alice", "")
+	if err != nil || id != "alice" {
+		t.Errorf("Caller should write own slot, got %q, %v", id, err)
+	}
+
+	if _, err := policy.AuthorizeWrite("alice", "bob"); err != ErrAccessDenied {
+		t.Errorf("Writing another slot should be denied, got %v", err)
+	}
+
+	if _, err := policy.AuthorizeWrite("", ""); err != ErrAccessDenied {
+		t.Errorf("Unauthenticated write should be denied, got %v", err)
+	}
+}
+
+func TestAuthorizeReadWithGrant(t *testing.T) {
+	pki := newTestPKI(t)
+	ownerCert, ownerKey := pki.issue(t)
+	deviceCert, _ := pki.issue(t)
+
+	ownerID := certmanager.CertificateID(ownerCert)
+	deviceID := certmanager.CertificateID(deviceCert)
+
+	revoked := make(map[string]bool)
+	policy := NewAccessPolicy(pki.roots, func(certID string) bool { return revoked[certID] })
+
+	// Without a grant the device cannot read the owner's slot
+	if _, err := policy.AuthorizeRead(deviceID, ownerID, nil); err != ErrAccessDenied {
+		t.Errorf("Read without grant should be denied, got %v", err)
+	}
+
+	grant, err := NewGrant(ownerCert, ownerKey, deviceID, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to create grant: %v", err)
+	}
+
+	id, err := policy.AuthorizeRead(deviceID, ownerID, grant)
+	if err != nil || id != ownerID {
+		t.Errorf("Granted read should be allowed, got %q, %v", id, err)
+	}
+
+	// A grant only works for its grantee
+	otherCert, _ := pki.issue(t)
+	if _, err := policy.AuthorizeRead(certmanager.CertificateID(otherCert), ownerID, grant); err != ErrGrantInvalid {
+		t.Errorf("Grant presented by another certificate should be invalid, got %v", err)
+	}
+
+	// Revoking the owner invalidates its grants
+	revoked[ownerID] = true
+	if _, err := policy.AuthorizeRead(deviceID, ownerID, grant); err != ErrAccessDenied {
+		t.Errorf("Grant from revoked owner should be denied, got %v", err)
+	}
+}
+
+func TestGrantRejectsTamperingAndExpiry(t *testing.T) {
+	pki := newTestPKI(t)
+	ownerCert, ownerKey := pki.issue(t)
+	deviceCert, _ := pki.issue(t)
+	deviceID := certmanager.CertificateID(deviceCert)
+
+	grant, err := NewGrant(ownerCert, ownerKey, deviceID, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to create grant: %v", err)
+	}
+
+	tampered := *grant
+	tampered.Expires = grant.Expires.Add(24 * time.Hour)
+	if _, err := tampered.Verify(deviceID, time.Now()); err != ErrGrantInvalid {
+		t.Errorf("Tampered grant should be invalid, got %v", err)
+	}
+
+	if _, err := grant.Verify(deviceID, grant.Expires.Add(time.Second)); err != ErrGrantExpired {
+		t.Errorf("Expired grant should be rejected, got %v", err)
+	}
+
+	// A grant signed by a certificate outside the trusted CA is refused
+	untrusted := newTestPKI(t)
+	strangerCert, strangerKey := untrusted.issue(t)
+	forged, err := NewGrant(strangerCert, strangerKey, deviceID, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to create grant: %v", err)
+	}
+
+	policy := NewAccessPolicy(pki.roots, nil)
+	if _, err := policy.AuthorizeRead(deviceID, forged.OwnerID, forged); err != ErrGrantInvalid {
+		t.Errorf("Grant from untrusted certificate should be invalid, got %v", err)
+	}
+}
+
+func TestConcealMakesMissAndDenialIndistinguishable(t *testing.T) {
+	pki := newTestPKI(t)
+	ownerCert, _ := pki.issue(t)
+	strangerCert, _ := pki.issue(t)
+	ownerID := certmanager.CertificateID(ownerCert)
+	strangerID := certmanager.CertificateID(strangerCert)
+
+	store := NewEncryptedKeyStore()
+	if err := store.StoreKey(ownerID, []byte("key"), []byte("iv"), []byte("hmac")); err != nil {
+		t.Fatalf("Failed to store key: %v", err)
+	}
+	policy := NewAccessPolicy(pki.roots, nil)
+
+	// A stranger probing a certificate with keys is denied...
+	_, denied := policy.AuthorizeRead(strangerID, ownerID, nil)
+	// ...and the owner of a certificate without keys finds none
+	_, missing := store.GetKey(strangerID)
+	if denied == nil || missing == nil {
+		t.Fatalf("Expected both lookups to fail, got %v and %v", denied, missing)
+	}
+
+	denied, missing = Conceal(denied), Conceal(missing)
+	if denied != ErrKeyUnavailable || missing != ErrKeyUnavailable {
+		t.Errorf("Expected ErrKeyUnavailable for both, got %v and %v", denied, missing)
+	}
+	if denied.Error() != missing.Error() {
+		t.Errorf("Concealed errors differ: %q and %q", denied, missing)
+	}
+
+	for _, err := range []error{ErrGrantInvalid, ErrGrantExpired, fmt.Errorf("wrapped: %w", ErrKeyNotFound)} {
+		if Conceal(err) != ErrKeyUnavailable {
+			t.Errorf("Expected %v to be concealed", err)
+		}
+	}
+	if err := Conceal(ErrInvalidSlot); err != ErrInvalidSlot {
+		t.Errorf("Errors about the request itself should pass through, got %v", err)
+	}
+}
@@ -0,0 +1,124 @@
+package keystore
+
+import (
+	"errors"
+	"log"
+)
+
+// ErrSlotConflict is returned when a slot was written by another instance
+// sharing the backend since it was read
+var ErrSlotConflict = errors.New("key slot changed concurrently")
+
+// Backend keeps the slots of a key store outside the process, so several
+// server instances can share them. The store reloads the slots of the
+// certificates an operation touches from the backend first and writes each
+// change through to it.
+type Backend interface {
+	// Slots returns every slot of a certificate
+	Slots(certID string) ([]EncryptedKeyData, error)
+
+	// CertIDs returns the certificates that have slots
+	CertIDs() ([]string, error)
+
+	// Put writes a slot. It fails with ErrSlotConflict unless the stored
+	// slot, if any, has an older version.
+	Put(keyData EncryptedKeyData) error
+
+	// Delete removes a slot of a certificate, or all of them if slot is ""
+	Delete(certID, slot string) error
+}
+
+// NewEncryptedKeyStoreWithBackend creates a key store kept in backend
+func NewEncryptedKeyStoreWithBackend(backend Backend) *EncryptedKeyStore {
+	eks := NewEncryptedKeyStore()
+	eks.backend = backend
+	return eks
+}
+
+// lock locks the store for an operation on the certificates. With a
+// backend the lock is exclusive and their slots are reloaded first, so
+// changes made by other instances are seen; a failed reload is logged and
+// the cached slots are used. It returns the matching unlock function.
+func (eks *EncryptedKeyStore) lock(write bool, certIDs ...string) func() {
+	if eks.backend == nil {
+		if write {
+			eks.mu.Lock()
+			return eks.mu.Unlock
+		}
+		eks.mu.RLock()
+		return eks.mu.RUnlock
+	}
+
+	eks.mu.Lock()
+	for _, certID := range certIDs {
+		if err := eks.reloadLocked(certID); err != nil {
+			log.Printf("Failed to load key slots of %s: %v", certID, err)
+		}
+	}
+	return eks.mu.Unlock
+}
+
+// reloadLocked replaces the cached slots of a certificate with the
+// backend's; callers hold eks.mu for writing
+func (eks *EncryptedKeyStore) reloadLocked(certID string) error {
+	records, err := eks.backend.Slots(certID)
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		delete(eks.store, certID)
+		return nil
+	}
+	slots := make(map[string]EncryptedKeyData, len(records))
+	for _, keyData := range records {
+		slots[keyData.Slot] = keyData
+	}
+	eks.store[certID] = slots
+	return nil
+}
+
+// putLocked persists a slot already updated in the cache. A failed write
+// to the backend reloads the certificate, dropping the update. Callers hold
+// eks.mu for writing.
+func (eks *EncryptedKeyStore) putLocked(keyData EncryptedKeyData) error {
+	if eks.backend == nil {
+		return eks.saveLocked()
+	}
+	if err := eks.backend.Put(keyData); err != nil {
+		eks.reloadLocked(keyData.CertID)
+		return err
+	}
+	return nil
+}
+
+// deleteLocked persists the removal of a slot, or of every slot of the
+// certificate if slot is "", already applied to the cache; callers hold
+// eks.mu for writing
+func (eks *EncryptedKeyStore) deleteLocked(certID, slot string) error {
+	if eks.backend == nil {
+		return eks.saveLocked()
+	}
+	if err := eks.backend.Delete(certID, slot); err != nil {
+		eks.reloadLocked(certID)
+		return err
+	}
+	return nil
+}
+
+// saveMigrationLocked persists a MigrateID from oldID to newID, which moved
+// the slots unless newID already had some; callers hold eks.mu for writing
+func (eks *EncryptedKeyStore) saveMigrationLocked(oldID, newID string, taken bool) error {
+	if eks.backend == nil {
+		return eks.saveLocked()
+	}
+	if !taken {
+		for _, keyData := range eks.store[newID] {
+			if err := eks.backend.Put(keyData); err != nil {
+				eks.reloadLocked(oldID)
+				eks.reloadLocked(newID)
+				return err
+			}
+		}
+	}
+	return eks.deleteLocked(oldID, "")
+}
@@ -1,15 +1,24 @@
 package keystore
 
 import (
+	"crypto/aes"
+	"crypto/cipher"
 	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
 	"errors"
 
-	"github.com/yourusername/secure-messaging-poc/pkg/crypto"
 	"golang.org/x/crypto/argon2"
 )
 
+var (
+	// ErrInvalidNonce is returned for a nonce of the wrong size
+	ErrInvalidNonce = errors.New("invalid nonce size")
+	
+	// ErrAuthenticationFailed is returned when a MAC does not verify
+	ErrAuthenticationFailed = errors.New("HMAC verification failed")
+)
+
 // KeyPair holds the encryption and HMAC keys derived from a password
 type KeyPair struct {
 	EncryptionKey []byte
@@ -80,7 +89,7 @@ func DecryptKey(ciphertext, nonce, encryptionKey []byte) ([]byte, error) {
 	
 	// Check nonce size
 	if len(nonce) != gcm.NonceSize() {
-		return nil, errors.New("invalid nonce size")
+		return nil, ErrInvalidNonce
 	}
 	
 	// Decrypt
@@ -133,7 +142,7 @@ func VerifyAndDecrypt(ciphertext, nonce, mac []byte, keypair KeyPair) ([]byte, e
 	expectedMAC := h.Sum(nil)
 	
 	if !hmac.Equal(mac, expectedMAC) {
-		return nil, errors.New("HMAC verification failed")
+		return nil, ErrAuthenticationFailed
 	}
 	
 	// Decrypt
@@ -144,13 +153,3 @@ func VerifyAndDecrypt(ciphertext, nonce, mac []byte, keypair KeyPair) ([]byte, e
 	
 	return plaintext, nil
 }
-
-// EncryptKey encrypts a key with AES-256-GCM
-func EncryptKey(keyData, encryptionKey []byte) (ciphertext, nonce []byte, err error) {
-	return crypto.AESGCMEncrypt(keyData, encryptionKey)
-}
-
-// DecryptKey decrypts a key with AES-256-GCM
-func DecryptKey(ciphertext, nonce, encryptionKey []byte) ([]byte, error) {
-	return crypto.AESGCMDecrypt(ciphertext, encryptionKey, nonce)
-}
\ No newline at end of file
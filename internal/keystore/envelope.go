@@ -0,0 +1,292 @@
+package keystore
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// EnvelopeVersion is the version of the on-disk record written for each
+// stored key. Records of older versions are upgraded when read.
+const EnvelopeVersion = 1
+
+// Algorithm identifiers recorded in a Format
+const (
+	CipherAES256GCM = "aes-256-gcm"
+	MACHMACSHA256   = "hmac-sha256"
+	KDFArgon2id     = "argon2id"
+)
+
+var (
+	// ErrEnvelopeVersion is returned for a record written by a newer server
+	ErrEnvelopeVersion = errors.New("unsupported key envelope version")
+
+	// ErrEnvelopeFormat is returned for a record that cannot be decoded
+	ErrEnvelopeFormat = errors.New("malformed key envelope")
+
+	// ErrUnknownFormat is returned for a format naming an unknown algorithm
+	ErrUnknownFormat = errors.New("unknown key encryption format")
+
+	// ErrRecordIntegrity is returned by CheckRecord for a key whose MAC or
+	// nonce cannot be right for its format
+	ErrRecordIntegrity = errors.New("key record fails integrity check")
+)
+
+// KDFParams are the parameters a client derived its encryption keys with.
+// The salt is optional; clients that keep it elsewhere leave it empty.
+type KDFParams struct {
+	Algorithm string `json:"algorithm"`
+	Time      uint32 `json:"time,omitempty"`
+	MemoryKiB uint32 `json:"memory_kib,omitempty"`
+	Threads   uint8  `json:"threads,omitempty"`
+	KeyLength uint32 `json:"key_length,omitempty"`
+	Salt      []byte `json:"salt,omitempty"`
+}
+
+// Format describes how a client encrypted a key, so the key can still be
+// decrypted after the defaults change
+type Format struct {
+	Cipher string    `json:"cipher"`
+	MAC    string    `json:"mac"`
+	KDF    KDFParams `json:"kdf"`
+}
+
+// DefaultFormat is the scheme of EncryptAndAuthenticate over keys from
+// DeriveKeyFromPassword, assumed for keys stored without a format
+var DefaultFormat = Format{
+	Cipher: CipherAES256GCM,
+	MAC:    MACHMACSHA256,
+	KDF: KDFParams{
+		Algorithm: KDFArgon2id,
+		Time:      1,
+		MemoryKiB: 64 * 1024,
+		Threads:   4,
+		KeyLength: 64,
+	},
+}
+
+// Algorithms a Format may name, by kind
+var (
+	knownCiphers = map[string]bool{CipherAES256GCM: true}
+	knownMACs    = map[string]bool{MACHMACSHA256: true}
+	knownKDFs    = map[string]bool{KDFArgon2id: true}
+)
+
+// Validate checks that the format only names known algorithms
+func (f Format) Validate() error {
+	switch {
+	case !knownCiphers[f.Cipher]:
+		return fmt.Errorf("%w: cipher %q", ErrUnknownFormat, f.Cipher)
+	case !knownMACs[f.MAC]:
+		return fmt.Errorf("%w: MAC %q", ErrUnknownFormat, f.MAC)
+	case !knownKDFs[f.KDF.Algorithm]:
+		return fmt.Errorf("%w: KDF %q", ErrUnknownFormat, f.KDF.Algorithm)
+	}
+	return nil
+}
+
+// Sizes of the MAC and nonce each algorithm produces
+var (
+	macSizes   = map[string]int{MACHMACSHA256: sha256.Size}
+	nonceSizes = map[string]int{CipherAES256GCM: 12}
+)
+
+// CheckRecord checks a stored key as far as the server can without the
+// client's keys: its format is known, its MAC and nonce have the sizes the
+// format gives them, and the ciphertext is at least a GCM tag long
+func CheckRecord(keyData EncryptedKeyData) error {
+	if err := keyData.Format.Validate(); err != nil {
+		return err
+	}
+	switch {
+	case len(keyData.HMAC) != macSizes[keyData.Format.MAC]:
+		return fmt.Errorf("%w: %d byte MAC", ErrRecordIntegrity, len(keyData.HMAC))
+	case len(keyData.IV) != nonceSizes[keyData.Format.Cipher]:
+		return fmt.Errorf("%w: %d byte nonce", ErrRecordIntegrity, len(keyData.IV))
+	case len(keyData.EncryptedKey) < 16:
+		return fmt.Errorf("%w: %d byte ciphertext", ErrRecordIntegrity, len(keyData.EncryptedKey))
+	}
+	return nil
+}
+
+// Envelope is the on-disk record of a stored key. Envelope is the record's
+// version; records without it predate versioning and are version 0.
+type Envelope struct {
+	Envelope     int       `json:"envelope"`
+	CertID       string    `json:"cert_id"`
+	Slot         string    `json:"slot"`
+	Version      uint64    `json:"version"`
+	Format       Format    `json:"format"`
+	EncryptedKey []byte    `json:"encrypted_key"`
+	IV           []byte    `json:"iv"`
+	HMAC         []byte    `json:"hmac"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// envelopeUpgrades converts a record of the version of its index to the next
+// version. Upgrades work on the raw record, so a version may change the
+// shape of any field.
+var envelopeUpgrades = []func(json.RawMessage) (json.RawMessage, error){
+	upgradeEnvelopeV0,
+}
+
+// upgradeEnvelopeV0 wraps a bare EncryptedKeyData, as written before
+// envelopes were versioned, assuming the default format of the time
+func upgradeEnvelopeV0(raw json.RawMessage) (json.RawMessage, error) {
+	var legacy struct {
+		CertID       string
+		Slot         string
+		Version      uint64
+		EncryptedKey []byte
+		IV           []byte
+		HMAC         []byte
+		CreatedAt    time.Time
+		UpdatedAt    time.Time
+	}
+	if err := json.Unmarshal(raw, &legacy); err != nil {
+		return nil, err
+	}
+	if legacy.Slot == "" {
+		legacy.Slot = DefaultSlot
+	}
+	return json.Marshal(Envelope{
+		Envelope:     1,
+		CertID:       legacy.CertID,
+		Slot:         legacy.Slot,
+		Version:      legacy.Version,
+		Format:       DefaultFormat,
+		EncryptedKey: legacy.EncryptedKey,
+		IV:           legacy.IV,
+		HMAC:         legacy.HMAC,
+		CreatedAt:    legacy.CreatedAt,
+		UpdatedAt:    legacy.UpdatedAt,
+	})
+}
+
+// MarshalEnvelope encodes a stored key as a current version envelope
+func MarshalEnvelope(keyData EncryptedKeyData) ([]byte, error) {
+	return json.Marshal(Envelope{
+		Envelope:     EnvelopeVersion,
+		CertID:       keyData.CertID,
+		Slot:         keyData.Slot,
+		Version:      keyData.Version,
+		Format:       keyData.Format,
+		EncryptedKey: keyData.EncryptedKey,
+		IV:           keyData.IV,
+		HMAC:         keyData.HMAC,
+		CreatedAt:    keyData.CreatedAt,
+		UpdatedAt:    keyData.UpdatedAt,
+	})
+}
+
+// UnmarshalEnvelope decodes an envelope of any supported version, upgrading
+// it to the current one. It also returns the version the record had.
+func UnmarshalEnvelope(data []byte) (EncryptedKeyData, int, error) {
+	var header struct {
+		Envelope int `json:"envelope"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return EncryptedKeyData{}, 0, fmt.Errorf("%w: %v", ErrEnvelopeFormat, err)
+	}
+	if header.Envelope < 0 || header.Envelope > EnvelopeVersion {
+		return EncryptedKeyData{}, header.Envelope, fmt.Errorf("%w: %d", ErrEnvelopeVersion, header.Envelope)
+	}
+
+	raw := json.RawMessage(data)
+	for version := header.Envelope; version < EnvelopeVersion; version++ {
+		upgraded, err := envelopeUpgrades[version](raw)
+		if err != nil {
+			return EncryptedKeyData{}, header.Envelope, fmt.Errorf("%w: upgrading version %d: %v", ErrEnvelopeFormat, version, err)
+		}
+		raw = upgraded
+	}
+
+	var envelope Envelope
+	if err := json.Unmarshal(raw, &envelope); err != nil {
+		return EncryptedKeyData{}, header.Envelope, fmt.Errorf("%w: %v", ErrEnvelopeFormat, err)
+	}
+	if envelope.CertID == "" || envelope.Slot == "" {
+		return EncryptedKeyData{}, header.Envelope, fmt.Errorf("%w: missing certificate ID or slot", ErrEnvelopeFormat)
+	}
+	return EncryptedKeyData{
+		CertID:       envelope.CertID,
+		Slot:         envelope.Slot,
+		Version:      envelope.Version,
+		Format:       envelope.Format,
+		EncryptedKey: envelope.EncryptedKey,
+		IV:           envelope.IV,
+		HMAC:         envelope.HMAC,
+		CreatedAt:    envelope.CreatedAt,
+		UpdatedAt:    envelope.UpdatedAt,
+	}, header.Envelope, nil
+}
+
+// readEnvelopes decodes a key store file of one envelope per line, calling
+// fn with each record and the version it was stored with
+func readEnvelopes(r io.Reader, fn func(keyData EncryptedKeyData, version int)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		keyData, version, err := UnmarshalEnvelope(data)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		fn(keyData, version)
+	}
+	return scanner.Err()
+}
+
+// writeEnvelopes replaces the file at path with one envelope per record
+func writeEnvelopes(path string, records []EncryptedKeyData) error {
+	var buf bytes.Buffer
+	for _, keyData := range records {
+		data, err := MarshalEnvelope(keyData)
+		if err != nil {
+			return err
+		}
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// MigrateFile upgrades every record in a key store file to the current
+// envelope version, rewriting the file in place. It returns how many records
+// were found of each version. With dryRun the file is only checked.
+func MigrateFile(path string, dryRun bool) (map[int]int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[int]int)
+	records := make([]EncryptedKeyData, 0)
+	err = readEnvelopes(f, func(keyData EncryptedKeyData, version int) {
+		counts[version]++
+		records = append(records, keyData)
+	})
+	f.Close()
+	if err != nil {
+		return counts, fmt.Errorf("%s: %w", path, err)
+	}
+
+	if dryRun || counts[EnvelopeVersion] == len(records) {
+		return counts, nil
+	}
+	return counts, writeEnvelopes(path, records)
+}
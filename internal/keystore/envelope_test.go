@@ -0,0 +1,163 @@
+package keystore
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	format := DefaultFormat
+	format.KDF.Salt = []byte("salt")
+	keyData := EncryptedKeyData{
+		CertID:       "cert-id",
+		Slot:         "backup",
+		Version:      3,
+		Format:       format,
+		EncryptedKey: []byte("key"),
+		IV:           []byte("iv"),
+		HMAC:         []byte("mac"),
+		CreatedAt:    time.Now().UTC().Truncate(time.Second),
+		UpdatedAt:    time.Now().UTC().Truncate(time.Second),
+	}
+
+	data, err := MarshalEnvelope(keyData)
+	if err != nil {
+		t.Fatalf("MarshalEnvelope failed: %v", err)
+	}
+	decoded, version, err := UnmarshalEnvelope(data)
+	if err != nil {
+		t.Fatalf("UnmarshalEnvelope failed: %v", err)
+	}
+	if version != EnvelopeVersion {
+		t.Errorf("Expected version %d, got %d", EnvelopeVersion, version)
+	}
+	if decoded.CertID != keyData.CertID || decoded.Slot != keyData.Slot || decoded.Version != keyData.Version ||
+		!bytes.Equal(decoded.EncryptedKey, keyData.EncryptedKey) || !bytes.Equal(decoded.Format.KDF.Salt, format.KDF.Salt) ||
+		!decoded.UpdatedAt.Equal(keyData.UpdatedAt) {
+		t.Errorf("Decoded envelope differs: %+v", decoded)
+	}
+}
+
+func TestEnvelopeUpgradesLegacyRecords(t *testing.T) {
+	// A bare EncryptedKeyData as encoding/json writes it, before versioning
+	legacy, err := json.Marshal(struct {
+		CertID       string
+		Version      uint64
+		EncryptedKey []byte
+		IV           []byte
+		HMAC         []byte
+	}{"cert-id", 2, []byte("key"), []byte("iv"), []byte("mac")})
+	if err != nil {
+		t.Fatalf("Failed to encode legacy record: %v", err)
+	}
+
+	keyData, version, err := UnmarshalEnvelope(legacy)
+	if err != nil {
+		t.Fatalf("UnmarshalEnvelope failed: %v", err)
+	}
+	if version != 0 {
+		t.Errorf("Expected a version 0 record, got %d", version)
+	}
+	if keyData.Slot != DefaultSlot || keyData.Version != 2 || !bytes.Equal(keyData.EncryptedKey, []byte("key")) {
+		t.Errorf("Legacy record not upgraded: %+v", keyData)
+	}
+	if keyData.Format.Cipher != CipherAES256GCM || keyData.Format.KDF.Algorithm != KDFArgon2id {
+		t.Errorf("Expected the default format for a legacy record, got %+v", keyData.Format)
+	}
+}
+
+func TestEnvelopeRejectsNewerVersions(t *testing.T) {
+	if _, _, err := UnmarshalEnvelope([]byte(`{"envelope": 99, "cert_id": "a", "slot": "b"}`)); !errors.Is(err, ErrEnvelopeVersion) {
+		t.Errorf("Expected ErrEnvelopeVersion, got %v", err)
+	}
+	if _, _, err := UnmarshalEnvelope([]byte(`not json`)); !errors.Is(err, ErrEnvelopeFormat) {
+		t.Errorf("Expected ErrEnvelopeFormat, got %v", err)
+	}
+}
+
+func TestOpenEncryptedKeyStorePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.jsonl")
+
+	eks, err := OpenEncryptedKeyStore(path)
+	if err != nil {
+		t.Fatalf("OpenEncryptedKeyStore failed: %v", err)
+	}
+	if err := eks.StoreKey("cert-id", []byte("key"), []byte("iv"), []byte("mac")); err != nil {
+		t.Fatalf("Failed to store key: %v", err)
+	}
+	if _, err := eks.StoreSlot("cert-id", "other", []byte("key2"), []byte("iv"), []byte("mac")); err != nil {
+		t.Fatalf("Failed to store key: %v", err)
+	}
+	if err := eks.DeleteSlot("cert-id", "other"); err != nil {
+		t.Fatalf("Failed to delete slot: %v", err)
+	}
+
+	reopened, err := OpenEncryptedKeyStore(path)
+	if err != nil {
+		t.Fatalf("Reopening failed: %v", err)
+	}
+	keyData, err := reopened.GetKey("cert-id")
+	if err != nil || !bytes.Equal(keyData.EncryptedKey, []byte("key")) {
+		t.Errorf("Stored key not reloaded: %+v, %v", keyData, err)
+	}
+	if _, err := reopened.GetSlot("cert-id", "other"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Deleted slot should stay deleted, got %v", err)
+	}
+}
+
+func TestMigrateFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.jsonl")
+	current, err := MarshalEnvelope(EncryptedKeyData{CertID: "b", Slot: DefaultSlot, Version: 1, Format: DefaultFormat})
+	if err != nil {
+		t.Fatalf("MarshalEnvelope failed: %v", err)
+	}
+	legacy := `{"CertID": "a", "Slot": "default", "Version": 4, "EncryptedKey": "a2V5"}`
+	if err := os.WriteFile(path, []byte(legacy+"\n"+string(current)+"\n"), 0600); err != nil {
+		t.Fatalf("Failed to write key store: %v", err)
+	}
+
+	counts, err := MigrateFile(path, true)
+	if err != nil || counts[0] != 1 || counts[EnvelopeVersion] != 1 {
+		t.Fatalf("Dry run reported %v, %v", counts, err)
+	}
+	if data, _ := os.ReadFile(path); !bytes.HasPrefix(data, []byte(legacy)) {
+		t.Error("Dry run should leave the file untouched")
+	}
+
+	if _, err := MigrateFile(path, false); err != nil {
+		t.Fatalf("MigrateFile failed: %v", err)
+	}
+	counts, err = MigrateFile(path, true)
+	if err != nil || counts[EnvelopeVersion] != 2 || len(counts) != 1 {
+		t.Errorf("Expected every record at version %d after migrating, got %v, %v", EnvelopeVersion, counts, err)
+	}
+}
+
+func TestCheckRecord(t *testing.T) {
+	keyData := EncryptedKeyData{
+		CertID:       "cert-id",
+		Slot:         DefaultSlot,
+		Format:       DefaultFormat,
+		EncryptedKey: make([]byte, 48),
+		IV:           make([]byte, 12),
+		HMAC:         make([]byte, 32),
+	}
+	if err := CheckRecord(keyData); err != nil {
+		t.Errorf("Well-formed record failed: %v", err)
+	}
+
+	keyData.HMAC = []byte("mac")
+	if err := CheckRecord(keyData); !errors.Is(err, ErrRecordIntegrity) {
+		t.Errorf("Expected ErrRecordIntegrity for a short MAC, got %v", err)
+	}
+	keyData.HMAC = make([]byte, 32)
+	keyData.Format.MAC = "poly1305"
+	if err := CheckRecord(keyData); !errors.Is(err, ErrUnknownFormat) {
+		t.Errorf("Expected ErrUnknownFormat, got %v", err)
+	}
+}
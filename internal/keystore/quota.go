@@ -0,0 +1,181 @@
+package keystore
+
+import "errors"
+
+// ErrQuotaExceeded is returned for a write that would take a certificate or
+// its referral tree past a hard quota
+var ErrQuotaExceeded = errors.New("key storage quota exceeded")
+
+// Quota scopes reported in a QuotaStatus
+const (
+	ScopeCertificate = "certificate"
+	ScopeSubtree     = "subtree"
+)
+
+// Quota limits the slots and bytes stored under a scope. Past a soft limit
+// writes still succeed but are flagged; a write past a hard limit fails. A
+// zero limit is unlimited.
+type Quota struct {
+	SoftBytes int64 `json:"soft_bytes,omitempty"`
+	HardBytes int64 `json:"hard_bytes,omitempty"`
+	SoftSlots int   `json:"soft_slots,omitempty"`
+	HardSlots int   `json:"hard_slots,omitempty"`
+}
+
+// limited reports whether any limit is set
+func (q Quota) limited() bool {
+	return q.SoftBytes > 0 || q.HardBytes > 0 || q.SoftSlots > 0 || q.HardSlots > 0
+}
+
+// overSoft reports whether usage is past a soft limit
+func (q Quota) overSoft(u Usage) bool {
+	return (q.SoftBytes > 0 && u.Bytes > q.SoftBytes) || (q.SoftSlots > 0 && u.Slots > q.SoftSlots)
+}
+
+// overHard reports whether usage is past a hard limit
+func (q Quota) overHard(u Usage) bool {
+	return (q.HardBytes > 0 && u.Bytes > q.HardBytes) || (q.HardSlots > 0 && u.Slots > q.HardSlots)
+}
+
+// Usage is the storage held under a scope. Bytes counts the encrypted key,
+// nonce and MAC of every slot.
+type Usage struct {
+	Slots int   `json:"slots"`
+	Bytes int64 `json:"bytes"`
+}
+
+// add returns the usage with slots and bytes added; negative counts remove
+// them
+func (u Usage) add(slots int, bytes int64) Usage {
+	return Usage{Slots: u.Slots + slots, Bytes: u.Bytes + bytes}
+}
+
+// QuotaStatus is the usage of one scope against its quota
+type QuotaStatus struct {
+	Scope        string `json:"scope"`
+	CertID       string `json:"cert_id"` // The certificate, or the top of the referral tree
+	Usage        Usage  `json:"usage"`
+	Quota        Quota  `json:"quota"`
+	SoftExceeded bool   `json:"soft_exceeded"`
+}
+
+// QuotaPolicy sets the quotas a key store enforces: one for each
+// certificate and one shared by every certificate of a referral tree, so a
+// referrer cannot multiply its storage by inviting itself new identities.
+// Subtree returns the certificates of certID's referral tree, starting with
+// its top; without it only the per-certificate quota applies.
+type QuotaPolicy struct {
+	PerCertificate Quota
+	PerSubtree     Quota
+	Subtree        func(certID string) []string
+	// OnSoftLimit is called after a write that leaves a scope past a soft
+	// limit
+	OnSoftLimit func(QuotaStatus)
+}
+
+// SetQuotaPolicy makes the store enforce policy on every write; nil removes
+// the limits
+func (eks *EncryptedKeyStore) SetQuotaPolicy(policy *QuotaPolicy) {
+	eks.mu.Lock()
+	defer eks.mu.Unlock()
+	eks.quota = policy
+}
+
+// quotaPolicy returns the current policy
+func (eks *EncryptedKeyStore) quotaPolicy() *QuotaPolicy {
+	eks.mu.RLock()
+	defer eks.mu.RUnlock()
+	return eks.quota
+}
+
+// quotaSubtree returns the referral tree whose quota applies to certID, its
+// top first, or nil if none does. It consults the referral tree, so it runs
+// before the store is locked.
+func quotaSubtree(policy *QuotaPolicy, certID string) []string {
+	if policy == nil || policy.Subtree == nil || !policy.PerSubtree.limited() {
+		return nil
+	}
+	return policy.Subtree(certID)
+}
+
+// slotSize returns the bytes a slot counts against a quota
+func slotSize(keyData EncryptedKeyData) int64 {
+	return int64(len(keyData.EncryptedKey) + len(keyData.IV) + len(keyData.HMAC))
+}
+
+// usageLocked sums the slots of certIDs; callers hold eks.mu
+func (eks *EncryptedKeyStore) usageLocked(certIDs ...string) Usage {
+	var usage Usage
+	for _, certID := range certIDs {
+		for _, keyData := range eks.store[certID] {
+			usage = usage.add(1, slotSize(keyData))
+		}
+	}
+	return usage
+}
+
+// Usage returns the slots and bytes a certificate holds
+func (eks *EncryptedKeyStore) Usage(certID string) Usage {
+	defer eks.lock(false, certID)()
+	return eks.usageLocked(certID)
+}
+
+// QuotaStatus returns the usage of every scope certID is limited by under
+// the store's policy, the certificate first
+func (eks *EncryptedKeyStore) QuotaStatus(certID string) []QuotaStatus {
+	policy := eks.quotaPolicy()
+	subtree := quotaSubtree(policy, certID)
+
+	defer eks.lock(false, append([]string{certID}, subtree...)...)()
+	return eks.quotaStatusLocked(policy, certID, subtree)
+}
+
+// quotaStatusLocked reports the scopes of certID; callers hold eks.mu
+func (eks *EncryptedKeyStore) quotaStatusLocked(policy *QuotaPolicy, certID string, subtree []string) []QuotaStatus {
+	var quota Quota
+	if policy != nil {
+		quota = policy.PerCertificate
+	}
+	usage := eks.usageLocked(certID)
+	statuses := []QuotaStatus{{
+		Scope:        ScopeCertificate,
+		CertID:       certID,
+		Usage:        usage,
+		Quota:        quota,
+		SoftExceeded: quota.overSoft(usage),
+	}}
+	if len(subtree) > 0 {
+		usage := eks.usageLocked(subtree...)
+		statuses = append(statuses, QuotaStatus{
+			Scope:        ScopeSubtree,
+			CertID:       subtree[0],
+			Usage:        usage,
+			Quota:        policy.PerSubtree,
+			SoftExceeded: policy.PerSubtree.overSoft(usage),
+		})
+	}
+	return statuses
+}
+
+// checkQuotaLocked fails a write of keyData that would take a scope past a
+// hard limit. Writes that do not grow a scope pass even when it is already
+// over, so a certificate can always shrink its slots. Callers hold eks.mu.
+func (eks *EncryptedKeyStore) checkQuotaLocked(policy *QuotaPolicy, subtree []string, keyData EncryptedKeyData) error {
+	if policy == nil {
+		return nil
+	}
+	slots, bytes := 1, slotSize(keyData)
+	if existing, ok := eks.store[keyData.CertID][keyData.Slot]; ok {
+		slots, bytes = 0, bytes-slotSize(existing)
+	}
+	if slots == 0 && bytes <= 0 {
+		return nil
+	}
+	if policy.PerCertificate.overHard(eks.usageLocked(keyData.CertID).add(slots, bytes)) {
+		return ErrQuotaExceeded
+	}
+	if len(subtree) > 0 && policy.PerSubtree.overHard(eks.usageLocked(subtree...).add(slots, bytes)) {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package keystore
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCertificateQuota(t *testing.T) {
+	eks := NewEncryptedKeyStore()
+	var warnings []QuotaStatus
+	eks.SetQuotaPolicy(&QuotaPolicy{
+		PerCertificate: Quota{SoftSlots: 1, HardSlots: 2, HardBytes: 64},
+		OnSoftLimit:    func(status QuotaStatus) { warnings = append(warnings, status) },
+	})
+
+	if _, err := eks.StoreSlot("cert", "a", []byte("key"), []byte("iv"), []byte("mac")); err != nil {
+		t.Fatalf("First slot should be stored: %v", err)
+	}
+	if len(warnings) != 0 {
+		t.Fatalf("No warning expected below the soft limit, got %+v", warnings)
+	}
+
+	if _, err := eks.StoreSlot("cert", "b", []byte("key"), []byte("iv"), []byte("mac")); err != nil {
+		t.Fatalf("Slot past the soft limit should be stored: %v", err)
+	}
+	if len(warnings) != 1 || warnings[0].Scope != ScopeCertificate || warnings[0].Usage.Slots != 2 {
+		t.Fatalf("Expected one certificate warning at 2 slots, got %+v", warnings)
+	}
+
+	if _, err := eks.StoreSlot("cert", "c", []byte("key"), []byte("iv"), []byte("mac")); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("Slot past the hard limit should fail with ErrQuotaExceeded, got %v", err)
+	}
+	if _, err := eks.StoreSlot("cert", "a", make([]byte, 64), []byte("iv"), []byte("mac")); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("Growing a slot past the hard byte limit should fail, got %v", err)
+	}
+
+	// Rewriting a slot at the same size does not grow the certificate
+	if _, err := eks.StoreSlot("cert", "a", []byte("yek"), []byte("iv"), []byte("mac")); err != nil {
+		t.Errorf("Rewriting a slot at the hard limit should succeed: %v", err)
+	}
+	if usage := eks.Usage("cert"); usage.Slots != 2 || usage.Bytes != 16 {
+		t.Errorf("Usage = %+v, want 2 slots of 8 bytes", usage)
+	}
+}
+
+func TestSubtreeQuota(t *testing.T) {
+	trees := map[string][]string{
+		"root":  {"root", "child"},
+		"child": {"root", "child"},
+	}
+	eks := NewEncryptedKeyStore()
+	eks.SetQuotaPolicy(&QuotaPolicy{
+		PerSubtree: Quota{SoftBytes: 8, HardBytes: 16},
+		Subtree: func(certID string) []string {
+			if tree, ok := trees[certID]; ok {
+				return tree
+			}
+			return []string{certID}
+		},
+	})
+
+	if _, err := eks.StoreSlot("root", DefaultSlot, []byte("key"), []byte("iv"), []byte("mac")); err != nil {
+		t.Fatalf("Root key should be stored: %v", err)
+	}
+	if _, err := eks.StoreSlot("child", DefaultSlot, []byte("key"), []byte("iv"), []byte("mac")); err != nil {
+		t.Fatalf("Child key should be stored: %v", err)
+	}
+
+	statuses := eks.QuotaStatus("child")
+	if len(statuses) != 2 {
+		t.Fatalf("Expected certificate and subtree status, got %+v", statuses)
+	}
+	subtree := statuses[1]
+	if subtree.Scope != ScopeSubtree || subtree.CertID != "root" || subtree.Usage.Bytes != 16 || !subtree.SoftExceeded {
+		t.Errorf("Subtree status = %+v, want 16 bytes under root past the soft limit", subtree)
+	}
+	if statuses[0].SoftExceeded {
+		t.Error("Certificate without a quota should not be past a soft limit")
+	}
+
+	if _, err := eks.StoreSlot("child", "other", []byte("k"), nil, nil); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Write past the subtree's hard limit should fail, got %v", err)
+	}
+	if _, err := eks.StoreSlot("unrelated", DefaultSlot, []byte("key"), []byte("iv"), []byte("mac")); err != nil {
+		t.Errorf("Another tree should have its own quota: %v", err)
+	}
+}
@@ -2,13 +2,41 @@ package keystore
 
 import (
 	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
 	"sync"
 	"time"
+
+	cryptopkg "github.com/yourusername/secure-messaging-poc/pkg/crypto"
+)
+
+// DefaultSlot is the slot used by the single-key StoreKey/GetKey API
+const DefaultSlot = "default"
+
+// MaxSlotNameLength bounds client-chosen slot names
+const MaxSlotNameLength = 64
+
+var (
+	// ErrKeyNotFound is returned when no key is stored in a slot
+	ErrKeyNotFound = errors.New("key not found for certificate ID")
+	
+	// ErrInvalidSlot is returned for empty or oversized slot names
+	ErrInvalidSlot = errors.New("invalid key slot name")
+	
+	// ErrInvalidCertID is returned when a key is stored without a
+	// certificate ID
+	ErrInvalidCertID = errors.New("certificate ID cannot be empty")
 )
 
 // EncryptedKeyData represents an encrypted key
 type EncryptedKeyData struct {
 	CertID       string
+	Slot         string
+	Version      uint64
+	Format       Format
 	EncryptedKey []byte
 	IV           []byte
 	HMAC         []byte
@@ -16,82 +44,265 @@ type EncryptedKeyData struct {
 	UpdatedAt    time.Time
 }
 
-// EncryptedKeyStore manages encrypted keys
+// EncryptedKeyStore manages encrypted keys. Each certificate owns any number
+// of named slots; every write to a slot bumps its version so devices can
+// reconcile with a manifest instead of fetching every slot.
 type EncryptedKeyStore struct {
-	store map[string]EncryptedKeyData
-	mu    sync.RWMutex
+	store   map[string]map[string]EncryptedKeyData // Cache of the backend's slots, if it has one
+	path    string
+	backend Backend
+	quota   *QuotaPolicy
+	mu      sync.RWMutex
 }
 
 // NewEncryptedKeyStore creates a new encrypted key store
 func NewEncryptedKeyStore() *EncryptedKeyStore {
 	return &EncryptedKeyStore{
-		store: make(map[string]EncryptedKeyData),
+		store: make(map[string]map[string]EncryptedKeyData),
+	}
+}
+
+// OpenEncryptedKeyStore creates a key store saved to path on every change,
+// loading the envelopes in path when it exists. Envelopes of older versions
+// are upgraded in memory and written in the current version on the next
+// save; cmd/keystore-migrate upgrades a file without starting a server.
+func OpenEncryptedKeyStore(path string) (*EncryptedKeyStore, error) {
+	eks := NewEncryptedKeyStore()
+	eks.path = path
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return eks, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	err = readEnvelopes(f, func(keyData EncryptedKeyData, _ int) {
+		slots, exists := eks.store[keyData.CertID]
+		if !exists {
+			slots = make(map[string]EncryptedKeyData)
+			eks.store[keyData.CertID] = slots
+		}
+		slots[keyData.Slot] = keyData
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
 	}
+	return eks, nil
 }
 
-// StoreKey stores an encrypted key
+// StoreKey stores an encrypted key in the certificate's default slot
 func (eks *EncryptedKeyStore) StoreKey(certID string, encryptedKey, iv, hmac []byte) error {
+	_, err := eks.StoreSlot(certID, DefaultSlot, encryptedKey, iv, hmac)
+	return err
+}
+
+// StoreSlot stores an encrypted key in a named slot and returns its new version
+func (eks *EncryptedKeyStore) StoreSlot(certID, slot string, encryptedKey, iv, hmac []byte) (uint64, error) {
+	return eks.StoreSlotWithFormat(certID, slot, DefaultFormat, encryptedKey, iv, hmac)
+}
+
+// StoreSlotWithFormat stores an encrypted key together with the format it
+// was encrypted in and returns its new version
+func (eks *EncryptedKeyStore) StoreSlotWithFormat(certID, slot string, format Format, encryptedKey, iv, hmac []byte) (uint64, error) {
+	if err := format.Validate(); err != nil {
+		return 0, err
+	}
 	if certID == "" {
-		return errors.New("certificate ID cannot be empty")
+		return 0, ErrInvalidCertID
 	}
+	if slot == "" || len(slot) > MaxSlotNameLength {
+		return 0, ErrInvalidSlot
+	}
+	
+	policy := eks.quotaPolicy()
+	subtree := quotaSubtree(policy, certID)
 	
+	version, statuses, err := eks.storeSlot(policy, subtree, EncryptedKeyData{
+		CertID:       certID,
+		Slot:         slot,
+		Format:       format,
+		EncryptedKey: encryptedKey,
+		IV:           iv,
+		HMAC:         hmac,
+	})
+	if err == nil && policy != nil && policy.OnSoftLimit != nil {
+		for _, status := range statuses {
+			if status.SoftExceeded {
+				policy.OnSoftLimit(status)
+			}
+		}
+	}
+	return version, err
+}
+
+// storeSlot writes keyData into its slot within the quotas of policy and
+// returns the new version and the resulting quota status
+func (eks *EncryptedKeyStore) storeSlot(policy *QuotaPolicy, subtree []string, keyData EncryptedKeyData) (uint64, []QuotaStatus, error) {
 	now := time.Now()
 	
-	eks.mu.Lock()
-	defer eks.mu.Unlock()
+	defer eks.lock(true, append([]string{keyData.CertID}, subtree...)...)()
+	
+	if err := eks.checkQuotaLocked(policy, subtree, keyData); err != nil {
+		return 0, nil, err
+	}
+	
+	slots, exists := eks.store[keyData.CertID]
+	if !exists {
+		slots = make(map[string]EncryptedKeyData)
+		eks.store[keyData.CertID] = slots
+	}
 	
 	// Check if key already exists
-	existing, exists := eks.store[certID]
+	existing, exists := slots[keyData.Slot]
 	if exists {
 		// Update existing key
-		existing.EncryptedKey = encryptedKey
-		existing.IV = iv
-		existing.HMAC = hmac
+		existing.Format = keyData.Format
+		existing.EncryptedKey = keyData.EncryptedKey
+		existing.IV = keyData.IV
+		existing.HMAC = keyData.HMAC
+		existing.Version++
 		existing.UpdatedAt = now
-		eks.store[certID] = existing
+		slots[keyData.Slot] = existing
 	} else {
 		// Create new key
-		eks.store[certID] = EncryptedKeyData{
-			CertID:       certID,
-			EncryptedKey: encryptedKey,
-			IV:           iv,
-			HMAC:         hmac,
-			CreatedAt:    now,
-			UpdatedAt:    now,
-		}
+		existing = keyData
+		existing.Version = 1
+		existing.CreatedAt = now
+		existing.UpdatedAt = now
+		slots[keyData.Slot] = existing
 	}
 	
-	return nil
+	if err := eks.putLocked(existing); err != nil {
+		return 0, nil, err
+	}
+	return existing.Version, eks.quotaStatusLocked(policy, keyData.CertID, subtree), nil
 }
 
-// GetKey retrieves an encrypted key
+// GetKey retrieves the encrypted key in the certificate's default slot
 func (eks *EncryptedKeyStore) GetKey(certID string) (EncryptedKeyData, error) {
-	eks.mu.RLock()
-	defer eks.mu.RUnlock()
+	return eks.GetSlot(certID, DefaultSlot)
+}
+
+// GetSlot retrieves the encrypted key in a named slot
+func (eks *EncryptedKeyStore) GetSlot(certID, slot string) (EncryptedKeyData, error) {
+	defer eks.lock(false, certID)()
 	
-	keyData, exists := eks.store[certID]
+	keyData, exists := eks.store[certID][slot]
 	if !exists {
-		return EncryptedKeyData{}, errors.New("key not found for certificate ID")
+		return EncryptedKeyData{}, ErrKeyNotFound
 	}
 	
 	return keyData, nil
 }
 
-// DeleteKey deletes an encrypted key
+// Manifest returns the current version of every slot of a certificate
+func (eks *EncryptedKeyStore) Manifest(certID string) map[string]uint64 {
+	defer eks.lock(false, certID)()
+	
+	manifest := make(map[string]uint64, len(eks.store[certID]))
+	for slot, keyData := range eks.store[certID] {
+		manifest[slot] = keyData.Version
+	}
+	
+	return manifest
+}
+
+// Sync compares a client manifest against the stored slots. It returns the
+// slots whose version differs from the client's, or that the client lacks,
+// along with the server manifest. Slots only the client knows are left for
+// the client to upload.
+func (eks *EncryptedKeyStore) Sync(certID string, clientManifest map[string]uint64) ([]EncryptedKeyData, map[string]uint64) {
+	defer eks.lock(false, certID)()
+	
+	slots := eks.store[certID]
+	changed := make([]EncryptedKeyData, 0)
+	manifest := make(map[string]uint64, len(slots))
+	
+	for slot, keyData := range slots {
+		manifest[slot] = keyData.Version
+		if version, known := clientManifest[slot]; !known || version != keyData.Version {
+			changed = append(changed, keyData)
+		}
+	}
+	
+	sort.Slice(changed, func(i, j int) bool {
+		return changed[i].Slot < changed[j].Slot
+	})
+	
+	return changed, manifest
+}
+
+// DeleteKey deletes all encrypted keys of a certificate
 func (eks *EncryptedKeyStore) DeleteKey(certID string) error {
-	eks.mu.Lock()
-	defer eks.mu.Unlock()
+	defer eks.lock(true, certID)()
 	
 	if _, exists := eks.store[certID]; !exists {
-		return errors.New("key not found for certificate ID")
+		return ErrKeyNotFound
 	}
 	
 	delete(eks.store, certID)
-	return nil
+	return eks.deleteLocked(certID, "")
+}
+
+// DeleteSlot deletes one slot of a certificate
+func (eks *EncryptedKeyStore) DeleteSlot(certID, slot string) error {
+	defer eks.lock(true, certID)()
+	
+	slots := eks.store[certID]
+	if _, exists := slots[slot]; !exists {
+		return ErrKeyNotFound
+	}
+	
+	delete(slots, slot)
+	if len(slots) == 0 {
+		delete(eks.store, certID)
+	}
+	return eks.deleteLocked(certID, slot)
+}
+
+// MigrateID moves keys stored under a legacy identifier to a new identifier.
+// Entries already stored under newID take precedence and are left untouched.
+func (eks *EncryptedKeyStore) MigrateID(oldID, newID string) bool {
+	if oldID == "" || newID == "" || cryptopkg.ConstantTimeEqualString(oldID, newID) {
+		return false
+	}
+	
+	defer eks.lock(true, oldID, newID)()
+	
+	slots, exists := eks.store[oldID]
+	if !exists {
+		return false
+	}
+	delete(eks.store, oldID)
+	
+	_, taken := eks.store[newID]
+	if !taken {
+		for slot, keyData := range slots {
+			keyData.CertID = newID
+			slots[slot] = keyData
+		}
+		eks.store[newID] = slots
+	}
+	
+	if err := eks.saveMigrationLocked(oldID, newID, taken); err != nil {
+		log.Printf("Failed to save key store after migrating %s: %v", oldID, err)
+	}
+	return !taken
 }
 
 // ListKeys returns a list of all certificate IDs with stored keys
 func (eks *EncryptedKeyStore) ListKeys() []string {
+	if eks.backend != nil {
+		keys, err := eks.backend.CertIDs()
+		if err != nil {
+			log.Printf("Failed to list key store certificates: %v", err)
+		}
+		return keys
+	}
+	
 	eks.mu.RLock()
 	defer eks.mu.RUnlock()
 	
@@ -101,4 +312,25 @@ func (eks *EncryptedKeyStore) ListKeys() []string {
 	}
 	
 	return keys
-}
\ No newline at end of file
+}
+// saveLocked writes every stored key to the store's file, if it has one;
+// callers hold eks.mu
+func (eks *EncryptedKeyStore) saveLocked() error {
+	if eks.path == "" {
+		return nil
+	}
+
+	records := make([]EncryptedKeyData, 0, len(eks.store))
+	for _, slots := range eks.store {
+		for _, keyData := range slots {
+			records = append(records, keyData)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if order := strings.Compare(records[i].CertID, records[j].CertID); order != 0 {
+			return order < 0
+		}
+		return records[i].Slot < records[j].Slot
+	})
+	return writeEnvelopes(eks.path, records)
+}
@@ -0,0 +1,129 @@
+package keystore
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestMigrateID(t *testing.T) {
+	eks := NewEncryptedKeyStore()
+
+	if err := eks.StoreKey("12345", []byte("key"), []byte("iv"), []byte("mac")); err != nil {
+		t.Fatalf("Failed to store key: %v", err)
+	}
+
+	if !eks.MigrateID("12345", "cert-id") {
+		t.Fatal("Key stored under legacy ID should migrate")
+	}
+
+	keyData, err := eks.GetKey("cert-id")
+	if err != nil {
+		t.Fatalf("Migrated key not found: %v", err)
+	}
+	if keyData.CertID != "cert-id" || !bytes.Equal(keyData.EncryptedKey, []byte("key")) {
+		t.Errorf("Migrated key data incorrect: %+v", keyData)
+	}
+
+	if _, err := eks.GetKey("12345"); err == nil {
+		t.Error("Legacy ID should no longer hold a key after migration")
+	}
+
+	// Nothing to migrate on a second call
+	if eks.MigrateID("12345", "cert-id") {
+		t.Error("Second migration should be a no-op")
+	}
+}
+
+func TestMigrateIDKeepsNewerKey(t *testing.T) {
+	eks := NewEncryptedKeyStore()
+
+	eks.StoreKey("12345", []byte("old"), []byte("iv"), []byte("mac"))
+	eks.StoreKey("cert-id", []byte("new"), []byte("iv"), []byte("mac"))
+
+	if eks.MigrateID("12345", "cert-id") {
+		t.Error("Migration should not overwrite a key stored under the new ID")
+	}
+
+	keyData, _ := eks.GetKey("cert-id")
+	if !bytes.Equal(keyData.EncryptedKey, []byte("new")) {
+		t.Errorf("Existing key was overwritten: %s", keyData.EncryptedKey)
+	}
+}
+
+func TestSlotVersions(t *testing.T) {
+	eks := NewEncryptedKeyStore()
+
+	version, err := eks.StoreSlot("cert-id", "contacts", []byte("v1"), []byte("iv"), []byte("mac"))
+	if err != nil || version != 1 {
+		t.Fatalf("First write should create version 1, got %d, %v", version, err)
+	}
+
+	version, _ = eks.StoreSlot("cert-id", "contacts", []byte("v2"), []byte("iv"), []byte("mac"))
+	if version != 2 {
+		t.Errorf("Second write should bump version to 2, got %d", version)
+	}
+
+	if _, err := eks.StoreSlot("cert-id", "", []byte("key"), nil, nil); err != ErrInvalidSlot {
+		t.Errorf("Empty slot name should be rejected, got %v", err)
+	}
+
+	// The single-key API uses the default slot
+	eks.StoreKey("cert-id", []byte("identity"), []byte("iv"), []byte("mac"))
+	keyData, err := eks.GetSlot("cert-id", DefaultSlot)
+	if err != nil || !bytes.Equal(keyData.EncryptedKey, []byte("identity")) {
+		t.Errorf("StoreKey should write the default slot, got %+v, %v", keyData, err)
+	}
+}
+
+func TestSync(t *testing.T) {
+	eks := NewEncryptedKeyStore()
+
+	eks.StoreSlot("cert-id", "a", []byte("a1"), nil, nil)
+	eks.StoreSlot("cert-id", "b", []byte("b1"), nil, nil)
+	eks.StoreSlot("cert-id", "b", []byte("b2"), nil, nil)
+	eks.StoreSlot("cert-id", "c", []byte("c1"), nil, nil)
+
+	// Client is current on a, stale on b, missing c and has an unknown d
+	changed, manifest := eks.Sync("cert-id", map[string]uint64{"a": 1, "b": 1, "d": 3})
+
+	if len(changed) != 2 || changed[0].Slot != "b" || changed[1].Slot != "c" {
+		t.Fatalf("Sync should return slots b and c, got %+v", changed)
+	}
+	if !bytes.Equal(changed[0].EncryptedKey, []byte("b2")) || changed[0].Version != 2 {
+		t.Errorf("Sync returned stale data for slot b: %+v", changed[0])
+	}
+
+	if len(manifest) != 3 || manifest["a"] != 1 || manifest["b"] != 2 || manifest["c"] != 1 {
+		t.Errorf("Server manifest incorrect: %v", manifest)
+	}
+
+	// Nothing changes for a client that is up to date
+	changed, _ = eks.Sync("cert-id", manifest)
+	if len(changed) != 0 {
+		t.Errorf("Up-to-date client should receive no blobs, got %d", len(changed))
+	}
+}
+
+func TestDeleteSlot(t *testing.T) {
+	eks := NewEncryptedKeyStore()
+	eks.StoreSlot("cert-id", "contacts", []byte("c"), []byte("iv"), []byte("mac"))
+	eks.StoreSlot("cert-id", "settings", []byte("s"), []byte("iv"), []byte("mac"))
+
+	if err := eks.DeleteSlot("cert-id", "contacts"); err != nil {
+		t.Fatalf("DeleteSlot failed: %v", err)
+	}
+	if _, err := eks.GetSlot("cert-id", "contacts"); err != ErrKeyNotFound {
+		t.Errorf("Deleted slot still readable: %v", err)
+	}
+	if _, err := eks.GetSlot("cert-id", "settings"); err != nil {
+		t.Errorf("Other slot should survive: %v", err)
+	}
+	if err := eks.DeleteSlot("cert-id", "contacts"); err != ErrKeyNotFound {
+		t.Errorf("Expected ErrKeyNotFound for a missing slot, got %v", err)
+	}
+
+	eks.DeleteSlot("cert-id", "settings")
+	if len(eks.ListKeys()) != 0 {
+		t.Errorf("Certificate without slots should not be listed: %v", eks.ListKeys())
+	}
+}
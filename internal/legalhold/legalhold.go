@@ -0,0 +1,91 @@
+// Package legalhold holds what this build guarantees whatever its
+// configuration says: messages are never kept past the retention ceiling
+// the server was built with, and payloads never reach the logs in the
+// clear. There is no legal-hold mode to switch on. The ceiling is fixed at
+// build time, so changing it takes a rebuild rather than an edited setting,
+// and the bin manager applies it on every retention it enforces.
+//
+// Log lines written through package trace redact payloads unconditionally,
+// and log shipping seals batches to the operator's key; neither has a
+// setting that turns this off. Assert reports the storage, logging and
+// retention the server actually runs with, next to the ceiling.
+package legalhold
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// maxRetention is the longest time this build keeps a message. Set it when
+// building:
+//
+//	go build -ldflags "-X github.com/yourusername/secure-messaging-poc/internal/legalhold.maxRetention=720h" ./cmd/server
+var maxRetention = "2160h"
+
+// ceiling is maxRetention parsed; a build with an invalid value does not
+// start
+var ceiling = mustParse(maxRetention)
+
+// ErrBeyondPolicy is returned for a retention longer than the build allows
+var ErrBeyondPolicy = errors.New("legalhold: retention exceeds the ceiling this server was built with")
+
+// Settings is the configuration an Assertion reports, as the server runs
+// with it
+type Settings struct {
+	Storage     string        // Bin storage backend, such as memory or sql
+	Sealed      bool          // Messages are encrypted before they reach storage
+	LogShipping bool          // Logs leave the host, sealed to the operator's key
+	Retention   time.Duration // Configured message retention
+}
+
+// Assertion describes how this server keeps messages and logs, for
+// /api/info
+type Assertion struct {
+	Storage             string `json:"storage"`
+	Persistent          bool   `json:"persistent"` // Messages survive a restart
+	Sealed              bool   `json:"sealed"`
+	LogShipping         bool   `json:"log_shipping"`
+	RetentionSeconds    int64  `json:"retention_seconds"` // After the ceiling is applied
+	MaxRetentionSeconds int64  `json:"max_retention_seconds"`
+}
+
+// mustParse parses the build-time ceiling
+func mustParse(value string) time.Duration {
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		panic(fmt.Sprintf("legalhold: invalid build-time retention ceiling %q", value))
+	}
+	return d
+}
+
+// MaxRetention returns the retention ceiling of this build
+func MaxRetention() time.Duration {
+	return ceiling
+}
+
+// CheckRetention returns ErrBeyondPolicy for a retention over the ceiling
+func CheckRetention(retention time.Duration) error {
+	if retention > ceiling {
+		return fmt.Errorf("%w: %s exceeds %s", ErrBeyondPolicy, retention, ceiling)
+	}
+	return nil
+}
+
+// Clamp returns retention, shortened to the ceiling if it exceeds it
+func Clamp(retention time.Duration) time.Duration {
+	return min(retention, ceiling)
+}
+
+// Assert describes a server running with settings under this build's
+// ceiling
+func Assert(settings Settings) Assertion {
+	return Assertion{
+		Storage:             settings.Storage,
+		Persistent:          settings.Storage != "memory",
+		Sealed:              settings.Sealed,
+		LogShipping:         settings.LogShipping,
+		RetentionSeconds:    int64(Clamp(settings.Retention) / time.Second),
+		MaxRetentionSeconds: int64(ceiling / time.Second),
+	}
+}
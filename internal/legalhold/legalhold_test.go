@@ -0,0 +1,34 @@
+package legalhold
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRetentionCeiling(t *testing.T) {
+	if MaxRetention() != 2160*time.Hour {
+		t.Fatalf("Unexpected default ceiling %s", MaxRetention())
+	}
+	if err := CheckRetention(MaxRetention()); err != nil {
+		t.Errorf("Retention at the ceiling should pass, got %v", err)
+	}
+	if err := CheckRetention(MaxRetention() + time.Second); !errors.Is(err, ErrBeyondPolicy) {
+		t.Errorf("Expected ErrBeyondPolicy, got %v", err)
+	}
+	if got := Clamp(10000 * time.Hour); got != MaxRetention() {
+		t.Errorf("Clamp = %s, want %s", got, MaxRetention())
+	}
+	if got := Clamp(time.Hour); got != time.Hour {
+		t.Errorf("Clamp should keep shorter retention, got %s", got)
+	}
+
+	a := Assert(Settings{Storage: "memory", LogShipping: true, Retention: 10000 * time.Hour})
+	if a.Persistent || a.Sealed || !a.LogShipping || a.RetentionSeconds != 2160*3600 || a.MaxRetentionSeconds != 2160*3600 {
+		t.Errorf("Unexpected assertion %+v", a)
+	}
+	a = Assert(Settings{Storage: "leveldb", Sealed: true, Retention: time.Hour})
+	if !a.Persistent || !a.Sealed || a.LogShipping || a.RetentionSeconds != 3600 {
+		t.Errorf("Unexpected assertion %+v", a)
+	}
+}
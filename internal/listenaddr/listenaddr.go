@@ -0,0 +1,102 @@
+// Package listenaddr resolves the addresses the server listens on and the
+// ones it advertises to clients. Hosts may be IPv4 or IPv6 literals, with
+// or without brackets, host names, or empty for every interface. With the
+// dual family an empty or "::" host binds one dual-stack socket that also
+// accepts IPv4 connections as mapped addresses; the ipv6 family binds IPv6
+// only, for hosts without IPv4.
+package listenaddr
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// IP families a listener can be restricted to
+const (
+	FamilyDual = "dual"
+	FamilyIPv4 = "ipv4"
+	FamilyIPv6 = "ipv6"
+)
+
+// ErrInvalidAddress is returned for an address that cannot be listened on
+// or advertised
+var ErrInvalidAddress = errors.New("listenaddr: invalid address")
+
+// networks maps families to the network names of net.Listen
+var networks = map[string]string{
+	FamilyDual: "tcp",
+	FamilyIPv4: "tcp4",
+	FamilyIPv6: "tcp6",
+}
+
+// Resolve returns the network and address to listen on for host and port in
+// family. An IP literal of the other family is refused.
+func Resolve(family, host string, port int) (network, address string, err error) {
+	network, ok := networks[family]
+	if !ok {
+		return "", "", fmt.Errorf("%w: unknown IP family %q", ErrInvalidAddress, family)
+	}
+	if port < 0 || port > 65535 {
+		return "", "", fmt.Errorf("%w: port %d", ErrInvalidAddress, port)
+	}
+
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	if ip := net.ParseIP(host); ip != nil {
+		isIPv4 := ip.To4() != nil
+		if (family == FamilyIPv4 && !isIPv4) || (family == FamilyIPv6 && isIPv4) {
+			return "", "", fmt.Errorf("%w: %s is not an %s address", ErrInvalidAddress, host, family)
+		}
+	}
+	return network, net.JoinHostPort(host, strconv.Itoa(port)), nil
+}
+
+// Listen listens on address in family
+func Listen(family, address string) (net.Listener, error) {
+	network, ok := networks[family]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown IP family %q", ErrInvalidAddress, family)
+	}
+	return net.Listen(network, address)
+}
+
+// Advertised validates host:port addresses for the server info and orders
+// them the way RFC 8305 clients race them: families alternate, IPv6 first,
+// each family keeping its configured order. Host names are kept after the
+// literals, since clients resolve those themselves.
+func Advertised(addresses []string) ([]string, error) {
+	var ipv6, ipv4, names []string
+	for _, address := range addresses {
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidAddress, err)
+		}
+		if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+			return nil, fmt.Errorf("%w: port of %s", ErrInvalidAddress, address)
+		}
+		ip := net.ParseIP(host)
+		switch {
+		case ip == nil && host != "":
+			names = append(names, address)
+		case ip == nil || ip.IsUnspecified():
+			return nil, fmt.Errorf("%w: %s names no host clients can reach", ErrInvalidAddress, address)
+		case ip.To4() != nil:
+			ipv4 = append(ipv4, net.JoinHostPort(ip.String(), port))
+		default:
+			ipv6 = append(ipv6, net.JoinHostPort(ip.String(), port))
+		}
+	}
+
+	ordered := make([]string, 0, len(addresses))
+	for i := 0; i < len(ipv6) || i < len(ipv4); i++ {
+		if i < len(ipv6) {
+			ordered = append(ordered, ipv6[i])
+		}
+		if i < len(ipv4) {
+			ordered = append(ordered, ipv4[i])
+		}
+	}
+	return append(ordered, names...), nil
+}
@@ -0,0 +1,133 @@
+package listenaddr
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestResolve(t *testing.T) {
+	cases := []struct {
+		family, host     string
+		network, address string
+	}{
+		{FamilyDual, "", "tcp", ":8443"},
+		{FamilyDual, "::", "tcp", "[::]:8443"},
+		{FamilyIPv6, "[2001:db8::1]", "tcp6", "[2001:db8::1]:8443"},
+		{FamilyIPv4, "0.0.0.0", "tcp4", "0.0.0.0:8443"},
+		{FamilyDual, "relay.example", "tcp", "relay.example:8443"},
+	}
+	for _, c := range cases {
+		network, address, err := Resolve(c.family, c.host, 8443)
+		if err != nil || network != c.network || address != c.address {
+			t.Errorf("Resolve(%s, %q) = %s %s %v, want %s %s", c.family, c.host, network, address, err, c.network, c.address)
+		}
+	}
+
+	for _, bad := range []struct{ family, host string }{
+		{FamilyIPv6, "127.0.0.1"},
+		{FamilyIPv4, "::1"},
+		{"ipx", ""},
+	} {
+		if _, _, err := Resolve(bad.family, bad.host, 8443); !errors.Is(err, ErrInvalidAddress) {
+			t.Errorf("Resolve(%s, %q) should fail, got %v", bad.family, bad.host, err)
+		}
+	}
+}
+
+func TestAdvertised(t *testing.T) {
+	ordered, err := Advertised([]string{
+		"203.0.113.5:8443",
+		"relay.example:8443",
+		"198.51.100.7:8443",
+		"[2001:db8:0:0::5]:8443",
+	})
+	if err != nil {
+		t.Fatalf("Advertised failed: %v", err)
+	}
+	want := []string{"[2001:db8::5]:8443", "203.0.113.5:8443", "198.51.100.7:8443", "relay.example:8443"}
+	if len(ordered) != len(want) {
+		t.Fatalf("Advertised = %v, want %v", ordered, want)
+	}
+	for i := range want {
+		if ordered[i] != want[i] {
+			t.Errorf("Advertised = %v, want %v", ordered, want)
+			break
+		}
+	}
+
+	for _, bad := range []string{"[::]:8443", ":8443", "203.0.113.5", "203.0.113.5:0"} {
+		if _, err := Advertised([]string{bad}); !errors.Is(err, ErrInvalidAddress) {
+			t.Errorf("Advertised(%q) should fail, got %v", bad, err)
+		}
+	}
+}
+
+// requireIPv6 skips tests on hosts without IPv6 loopback
+func requireIPv6(t *testing.T) {
+	listener, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 loopback unavailable: %v", err)
+	}
+	listener.Close()
+}
+
+// dialable reports whether a connection to host on the listener's port is
+// accepted
+func dialable(listener net.Listener, host string) bool {
+	port := listener.Addr().(*net.TCPAddr).Port
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, strconv.Itoa(port)), time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+func TestListenFamilies(t *testing.T) {
+	requireIPv6(t)
+
+	network, address, err := Resolve(FamilyDual, "", 0)
+	if err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+	dual, err := net.Listen(network, address)
+	if err != nil {
+		t.Fatalf("Failed to listen dual-stack: %v", err)
+	}
+	defer dual.Close()
+	go acceptAll(dual)
+	if !dialable(dual, "::1") || !dialable(dual, "127.0.0.1") {
+		t.Error("A dual-stack listener should accept IPv6 and IPv4 connections")
+	}
+
+	_, address, err = Resolve(FamilyIPv6, "::", 0)
+	if err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+	v6only, err := Listen(FamilyIPv6, address)
+	if err != nil {
+		t.Fatalf("Failed to listen IPv6 only: %v", err)
+	}
+	defer v6only.Close()
+	go acceptAll(v6only)
+	if !dialable(v6only, "::1") {
+		t.Error("An IPv6 listener should accept IPv6 connections")
+	}
+	if dialable(v6only, "127.0.0.1") {
+		t.Error("An IPv6-only listener should not accept IPv4 connections")
+	}
+}
+
+// acceptAll accepts and closes connections until listener is closed
+func acceptAll(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		conn.Close()
+	}
+}
@@ -0,0 +1,186 @@
+package logship
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testKeys returns a fresh operator key pair
+func testKeys(t *testing.T) (*[32]byte, *[32]byte) {
+	t.Helper()
+	pubEncoded, privEncoded, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("Failed to generate keys: %v", err)
+	}
+	pub, err := ParsePublicKey(pubEncoded)
+	if err != nil {
+		t.Fatalf("Failed to parse public key: %v", err)
+	}
+	priv, err := ParsePrivateKey(privEncoded)
+	if err != nil {
+		t.Fatalf("Failed to parse private key: %v", err)
+	}
+	return pub, priv
+}
+
+func TestSinkFileRoundTrip(t *testing.T) {
+	pub, priv := testKeys(t)
+	dir := t.TempDir()
+
+	shipper, err := NewFileShipper(dir, 0, 0)
+	if err != nil {
+		t.Fatalf("Failed to create shipper: %v", err)
+	}
+	sink, err := NewSink(pub, shipper, Config{BatchBytes: 512, FlushInterval: time.Hour})
+	if err != nil {
+		t.Fatalf("Failed to create sink: %v", err)
+	}
+
+	logger := log.New(sink, "", 0)
+	for i := 0; i < 200; i++ {
+		logger.Printf("client cert-%03d connected", i)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Failed to close sink: %v", err)
+	}
+
+	files, err := ListFiles(dir)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("Expected one log file, got %d (%v)", len(files), err)
+	}
+	data, _ := os.ReadFile(files[0])
+	if bytes.Contains(data, []byte("connected")) {
+		t.Fatalf("Log file contains plaintext")
+	}
+
+	batches, err := ReadBatches(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Failed to read batches: %v", err)
+	}
+
+	var records []Record
+	for i, batch := range batches {
+		sequence, batchRecords, err := OpenBatch(batch, pub, priv)
+		if err != nil {
+			t.Fatalf("Failed to open batch: %v", err)
+		}
+		if sequence != uint64(i+1) {
+			t.Errorf("Batch %d has sequence %d", i, sequence)
+		}
+		records = append(records, batchRecords...)
+	}
+
+	if len(records) != 200 {
+		t.Fatalf("Expected 200 records, got %d", len(records))
+	}
+	for i, record := range records {
+		if expected := fmt.Sprintf("client cert-%03d connected", i); record.Message != expected {
+			t.Fatalf("Record %d is %q, expected %q", i, record.Message, expected)
+		}
+	}
+	if sink.Dropped() != 0 {
+		t.Errorf("No batches should have been dropped, got %d", sink.Dropped())
+	}
+}
+
+func TestFileShipperRotation(t *testing.T) {
+	dir := t.TempDir()
+
+	// Four 500 byte batches fit in each file; only the newest three files stay
+	shipper, err := NewFileShipper(dir, 2048, 3)
+	if err != nil {
+		t.Fatalf("Failed to create shipper: %v", err)
+	}
+	for i := 0; i < 20; i++ {
+		if err := shipper.Ship(bytes.Repeat([]byte{byte(i)}, 500)); err != nil {
+			t.Fatalf("Failed to ship batch: %v", err)
+		}
+	}
+	shipper.Close()
+
+	files, _ := ListFiles(dir)
+	if len(files) != 3 {
+		t.Fatalf("Expected 3 files after rotation, got %d", len(files))
+	}
+
+	f, _ := os.Open(files[len(files)-1])
+	defer f.Close()
+	batches, err := ReadBatches(f)
+	if err != nil || len(batches) != 4 || batches[3][0] != 19 {
+		t.Errorf("Newest file should hold batches 16-19, got %d batches (%v)", len(batches), err)
+	}
+}
+
+func TestSinkHTTPShipping(t *testing.T) {
+	pub, priv := testKeys(t)
+
+	var (
+		received [][]byte
+		mu       sync.Mutex
+	)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		received = append(received, body)
+		mu.Unlock()
+	}))
+	defer collector.Close()
+
+	sink, err := NewSink(pub, NewHTTPShipper(collector.URL, nil), Config{FlushInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Failed to create sink: %v", err)
+	}
+	fmt.Fprintln(sink, "flushed by the interval")
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		mu.Lock()
+		count := len(received)
+		mu.Unlock()
+		if count > 0 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	sink.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received) != 1 {
+		t.Fatalf("Expected one batch, got %d", len(received))
+	}
+	_, records, err := OpenBatch(received[0], pub, priv)
+	if err != nil || len(records) != 1 || records[0].Message != "flushed by the interval" {
+		t.Errorf("Unexpected batch contents: %+v, %v", records, err)
+	}
+
+	// Another key cannot open it
+	otherPub, otherPriv := testKeys(t)
+	if _, _, err := OpenBatch(received[0], otherPub, otherPriv); err != ErrInvalidBatch {
+		t.Errorf("Expected ErrInvalidBatch with the wrong key, got %v", err)
+	}
+}
+
+func TestSinkCountsFailedShipments(t *testing.T) {
+	pub, _ := testKeys(t)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer collector.Close()
+
+	sink, _ := NewSink(pub, NewHTTPShipper(collector.URL, nil), Config{FlushInterval: time.Hour})
+	fmt.Fprintln(sink, "lost")
+	sink.Close()
+
+	if sink.Dropped() != 1 {
+		t.Errorf("Expected one dropped batch, got %d", sink.Dropped())
+	}
+}
@@ -0,0 +1,170 @@
+package logship
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultMaxFileBytes is the size at which FileShipper starts a new file
+const DefaultMaxFileBytes = 64 << 20
+
+// fileSuffix names files written by FileShipper
+const fileSuffix = ".anlog"
+
+// FileShipper appends length-prefixed batches to files in a directory,
+// rotating by size and keeping at most maxFiles files
+type FileShipper struct {
+	dir      string
+	maxBytes int64
+	maxFiles int
+	file     *os.File
+	written  int64
+	opened   int
+}
+
+// NewFileShipper creates the directory if needed. A maxFiles of zero keeps
+// every file.
+func NewFileShipper(dir string, maxBytes int64, maxFiles int) (*FileShipper, error) {
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxFileBytes
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &FileShipper{dir: dir, maxBytes: maxBytes, maxFiles: maxFiles}, nil
+}
+
+// Ship appends a batch, rotating first if the current file is full
+func (fs *FileShipper) Ship(batch []byte) error {
+	if fs.file == nil || fs.written+int64(len(batch))+4 > fs.maxBytes {
+		if err := fs.rotate(); err != nil {
+			return err
+		}
+	}
+
+	frame := make([]byte, 4, 4+len(batch))
+	binary.BigEndian.PutUint32(frame, uint32(len(batch)))
+	frame = append(frame, batch...)
+
+	n, err := fs.file.Write(frame)
+	fs.written += int64(n)
+	return err
+}
+
+// Close closes the current file
+func (fs *FileShipper) Close() error {
+	if fs.file == nil {
+		return nil
+	}
+	return fs.file.Close()
+}
+
+// rotate starts a new file and removes the oldest beyond maxFiles
+func (fs *FileShipper) rotate() error {
+	if fs.file != nil {
+		fs.file.Close()
+	}
+
+	// The counter keeps names unique, and ordered, within one clock tick
+	fs.opened++
+	name := fmt.Sprintf("logs-%s-%06d%s", time.Now().UTC().Format("20060102T150405.000000000"), fs.opened, fileSuffix)
+	file, err := os.OpenFile(filepath.Join(fs.dir, name), os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0600)
+	if err != nil {
+		fs.file = nil
+		return err
+	}
+	fs.file = file
+	fs.written = 0
+
+	if fs.maxFiles > 0 {
+		files, err := ListFiles(fs.dir)
+		if err != nil {
+			return err
+		}
+		for len(files) > fs.maxFiles {
+			os.Remove(files[0])
+			files = files[1:]
+		}
+	}
+	return nil
+}
+
+// ListFiles returns the log files in dir, oldest first
+func ListFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), fileSuffix) {
+			files = append(files, filepath.Join(dir, entry.Name()))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// ReadBatches returns the batches stored in a file written by FileShipper
+func ReadBatches(r io.Reader) ([][]byte, error) {
+	batches := make([][]byte, 0)
+	header := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(r, header); err == io.EOF {
+			return batches, nil
+		} else if err != nil {
+			return batches, err
+		}
+
+		batch := make([]byte, binary.BigEndian.Uint32(header))
+		if _, err := io.ReadFull(r, batch); err != nil {
+			return batches, err
+		}
+		batches = append(batches, batch)
+	}
+}
+
+// HTTPShipper POSTs each batch to a collector endpoint
+type HTTPShipper struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTPShipper creates a shipper for the given URL. A nil client uses one
+// with a 30 second timeout.
+func NewHTTPShipper(url string, client *http.Client) *HTTPShipper {
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	return &HTTPShipper{url: url, client: client}
+}
+
+// Ship POSTs a batch; any non-2xx response is an error
+func (hs *HTTPShipper) Ship(batch []byte) error {
+	resp, err := hs.client.Post(hs.url, "application/octet-stream", bytes.NewReader(batch))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("logship: collector returned %s", resp.Status)
+	}
+	return nil
+}
+
+// Close releases idle connections
+func (hs *HTTPShipper) Close() error {
+	hs.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,308 @@
+// Package logship batches operator logs, compresses them with zstd and seals
+// each batch to an operator public key before shipping it to a file or a
+// remote endpoint. Only the holder of the private key can read shipped logs.
+package logship
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"golang.org/x/crypto/nacl/box"
+)
+
+// Defaults used when a Config leaves a limit unset
+const (
+	DefaultBatchBytes    = 256 << 10
+	DefaultFlushInterval = 5 * time.Second
+)
+
+// maxBufferedBatches is how many batches worth of records are held while the
+// shipping queue is full before records are dropped
+const maxBufferedBatches = 16
+
+// batchMagic starts every shipped batch
+var batchMagic = []byte("ANLOG1")
+
+var (
+	// ErrInvalidKey is returned for a malformed operator key
+	ErrInvalidKey = errors.New("logship: invalid key")
+	// ErrInvalidBatch is returned when a batch cannot be opened
+	ErrInvalidBatch = errors.New("logship: invalid or undecryptable batch")
+)
+
+// Record is one log line in a batch
+type Record struct {
+	Time    time.Time `json:"time"`
+	Message string    `json:"msg"`
+}
+
+// Shipper delivers sealed batches
+type Shipper interface {
+	Ship(batch []byte) error
+	Close() error
+}
+
+// Config controls batching
+type Config struct {
+	BatchBytes    int           // Uncompressed bytes that trigger a flush
+	FlushInterval time.Duration // Maximum time a record waits to be shipped
+}
+
+// Sink is an io.Writer that collects log lines into sealed batches. Writes
+// never block on shipping: while the shipper is behind, records accumulate
+// up to a limit, and beyond it or on shipping errors batches are dropped and
+// counted.
+type Sink struct {
+	recipient *[32]byte
+	shipper   Shipper
+	config    Config
+	encoder   *zstd.Encoder
+	buf       bytes.Buffer
+	sequence  uint64
+	dropped   uint64
+	batches   chan []byte
+	done      chan struct{}
+	stopped   chan struct{}
+	mu        sync.Mutex
+}
+
+// ParsePublicKey decodes a base64 X25519 public key
+func ParsePublicKey(encoded string) (*[32]byte, error) {
+	return parseKey(encoded)
+}
+
+// ParsePrivateKey decodes a base64 X25519 private key
+func ParsePrivateKey(encoded string) (*[32]byte, error) {
+	return parseKey(encoded)
+}
+
+// parseKey decodes a base64 32-byte key
+func parseKey(encoded string) (*[32]byte, error) {
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(raw) != 32 {
+		return nil, ErrInvalidKey
+	}
+	var key [32]byte
+	copy(key[:], raw)
+	return &key, nil
+}
+
+// GenerateKeyPair creates an operator key pair, base64 encoded
+func GenerateKeyPair() (publicKey, privateKey string, err error) {
+	pub, priv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	return base64.StdEncoding.EncodeToString(pub[:]), base64.StdEncoding.EncodeToString(priv[:]), nil
+}
+
+// NewSink creates a sink sealing batches to recipient and starts shipping
+func NewSink(recipient *[32]byte, shipper Shipper, config Config) (*Sink, error) {
+	if config.BatchBytes <= 0 {
+		config.BatchBytes = DefaultBatchBytes
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = DefaultFlushInterval
+	}
+
+	encoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Sink{
+		recipient: recipient,
+		shipper:   shipper,
+		config:    config,
+		encoder:   encoder,
+		batches:   make(chan []byte, 16),
+		done:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+	}
+	go s.run()
+	return s, nil
+}
+
+// Write adds one log line. The log package calls it once per line.
+func (s *Sink) Write(p []byte) (int, error) {
+	line, err := json.Marshal(Record{
+		Time:    time.Now().UTC(),
+		Message: string(bytes.TrimRight(p, "\n")),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var batch []byte
+	s.mu.Lock()
+	s.buf.Write(line)
+	s.buf.WriteByte('\n')
+	if s.buf.Len() >= s.config.BatchBytes {
+		batch = s.takeLocked(false)
+	}
+	s.mu.Unlock()
+
+	if batch != nil {
+		s.enqueue(batch, false)
+	}
+	return len(p), nil
+}
+
+// Dropped returns the number of batches that could not be shipped
+func (s *Sink) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Close ships any buffered records and closes the shipper
+func (s *Sink) Close() error {
+	s.mu.Lock()
+	batch := s.takeLocked(true)
+	s.mu.Unlock()
+
+	if batch != nil {
+		s.enqueue(batch, true)
+	}
+	close(s.done)
+	<-s.stopped
+	return s.shipper.Close()
+}
+
+// takeLocked seals the buffered records into a batch. It returns nil if
+// there is nothing to send, or if the queue is full and the buffer is under
+// its limit, in which case the records wait for a later flush unless force
+// is set. Callers hold s.mu.
+func (s *Sink) takeLocked(force bool) []byte {
+	if s.buf.Len() == 0 {
+		return nil
+	}
+	full := len(s.batches) == cap(s.batches)
+	if full && !force && s.buf.Len() < maxBufferedBatches*s.config.BatchBytes {
+		return nil
+	}
+
+	s.sequence++
+	batch, err := s.seal(s.sequence, s.buf.Bytes())
+	s.buf.Reset()
+	if err != nil {
+		s.drop(err)
+		return nil
+	}
+	return batch
+}
+
+// enqueue queues a batch for shipping. Unless wait is set, a batch that
+// finds the queue full is dropped.
+func (s *Sink) enqueue(batch []byte, wait bool) {
+	if wait {
+		s.batches <- batch
+		return
+	}
+	select {
+	case s.batches <- batch:
+	default:
+		s.drop(errors.New("shipping queue full"))
+	}
+}
+
+// seal compresses records and encrypts them to the recipient. The sequence
+// number is authenticated so missing or replayed batches can be detected.
+func (s *Sink) seal(sequence uint64, records []byte) ([]byte, error) {
+	plain := make([]byte, 8, 8+len(records)/4)
+	binary.BigEndian.PutUint64(plain, sequence)
+	plain = s.encoder.EncodeAll(records, plain)
+
+	sealed, err := box.SealAnonymous(nil, plain, s.recipient, rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte{}, batchMagic...), sealed...), nil
+}
+
+// run ships queued batches and flushes on the interval
+func (s *Sink) run() {
+	defer close(s.stopped)
+
+	ticker := time.NewTicker(s.config.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case batch := <-s.batches:
+			s.ship(batch)
+		case <-ticker.C:
+			s.mu.Lock()
+			batch := s.takeLocked(false)
+			s.mu.Unlock()
+			if batch != nil {
+				s.ship(batch)
+			}
+		case <-s.done:
+			for {
+				select {
+				case batch := <-s.batches:
+					s.ship(batch)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// ship delivers one batch
+func (s *Sink) ship(batch []byte) {
+	if err := s.shipper.Ship(batch); err != nil {
+		s.drop(err)
+	}
+}
+
+// drop counts a lost batch. It reports to stderr directly because the
+// standard logger may be writing to this sink.
+func (s *Sink) drop(err error) {
+	atomic.AddUint64(&s.dropped, 1)
+	fmt.Fprintf(os.Stderr, "logship: dropped batch: %v\n", err)
+}
+
+// OpenBatch decrypts and decompresses a batch with the operator key pair
+func OpenBatch(batch []byte, publicKey, privateKey *[32]byte) (uint64, []Record, error) {
+	if !bytes.HasPrefix(batch, batchMagic) {
+		return 0, nil, ErrInvalidBatch
+	}
+
+	plain, ok := box.OpenAnonymous(nil, batch[len(batchMagic):], publicKey, privateKey)
+	if !ok || len(plain) < 8 {
+		return 0, nil, ErrInvalidBatch
+	}
+	sequence := binary.BigEndian.Uint64(plain)
+
+	decoder, err := zstd.NewReader(nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer decoder.Close()
+
+	data, err := decoder.DecodeAll(plain[8:], nil)
+	if err != nil {
+		return 0, nil, ErrInvalidBatch
+	}
+
+	records := make([]Record, 0)
+	for _, line := range bytes.Split(bytes.TrimRight(data, "\n"), []byte("\n")) {
+		var record Record
+		if err := json.Unmarshal(line, &record); err != nil {
+			return 0, nil, ErrInvalidBatch
+		}
+		records = append(records, record)
+	}
+	return sequence, records, nil
+}
@@ -0,0 +1,196 @@
+package metrics
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrUnknownSeries is returned when querying a metric that is not tracked
+var ErrUnknownSeries = errors.New("unknown series")
+
+// Point is one sample of a series
+type Point struct {
+	Time  int64   `json:"t"` // Unix seconds
+	Value float64 `json:"v"`
+}
+
+// ring is a fixed-size circular buffer of points, oldest first
+type ring struct {
+	points []Point
+	next   int
+	full   bool
+}
+
+// add stores a point, overwriting the oldest once full
+func (r *ring) add(p Point) {
+	r.points[r.next] = p
+	r.next = (r.next + 1) % len(r.points)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// since returns the points at or after t, oldest first
+func (r *ring) since(t int64) []Point {
+	ordered := r.points[:r.next]
+	if r.full {
+		ordered = append(append([]Point{}, r.points[r.next:]...), r.points[:r.next]...)
+	}
+	i := sort.Search(len(ordered), func(i int) bool { return ordered[i].Time >= t })
+	return ordered[i:]
+}
+
+// History samples registry metrics at a fixed interval into ring buffers, so
+// recent trends can be served without an external time-series database.
+// Gauges are recorded as values and counters as per-second rates.
+type History struct {
+	registry *Registry
+	interval time.Duration
+	capacity int
+	series   map[string]*ring
+	last     map[string]float64 // Previous counter values
+	lastTime time.Time
+	ticker   *time.Ticker
+	done     chan struct{}
+	mu       sync.Mutex
+}
+
+// NewHistory keeps retention worth of samples taken every interval
+func NewHistory(registry *Registry, interval, retention time.Duration) *History {
+	capacity := int(retention / interval)
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &History{
+		registry: registry,
+		interval: interval,
+		capacity: capacity,
+		series:   make(map[string]*ring),
+		last:     make(map[string]float64),
+	}
+}
+
+// Track records the named metric from the next sample on
+func (h *History) Track(names ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, name := range names {
+		if _, exists := h.series[name]; !exists {
+			h.series[name] = &ring{points: make([]Point, h.capacity)}
+		}
+	}
+}
+
+// Names returns the tracked series
+func (h *History) Names() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	names := make([]string, 0, len(h.series))
+	for name := range h.series {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Sample records one point per tracked metric. Counters need two samples
+// before the first rate is recorded.
+func (h *History) Sample(now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	elapsed := now.Sub(h.lastTime).Seconds()
+	for name, series := range h.series {
+		value, counter, ok := h.registry.Read(name)
+		if !ok {
+			continue
+		}
+
+		if counter {
+			previous, seen := h.last[name]
+			h.last[name] = value
+			if !seen || elapsed <= 0 || value < previous {
+				continue
+			}
+			value = (value - previous) / elapsed
+		}
+		series.add(Point{Time: now.Unix(), Value: value})
+	}
+	h.lastTime = now
+}
+
+// Query returns the series since the given time, averaged down to at most
+// maxPoints points
+func (h *History) Query(name string, since time.Time, maxPoints int) ([]Point, error) {
+	h.mu.Lock()
+	series, exists := h.series[name]
+	if !exists {
+		h.mu.Unlock()
+		return nil, ErrUnknownSeries
+	}
+	points := series.since(since.Unix())
+	points = append([]Point(nil), points...)
+	h.mu.Unlock()
+
+	return downsample(points, maxPoints), nil
+}
+
+// Start samples every interval until Stop is called
+func (h *History) Start() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.ticker != nil {
+		return
+	}
+	h.ticker = time.NewTicker(h.interval)
+	h.done = make(chan struct{})
+
+	go func(ticker *time.Ticker, done chan struct{}) {
+		for {
+			select {
+			case now := <-ticker.C:
+				h.Sample(now)
+			case <-done:
+				return
+			}
+		}
+	}(h.ticker, h.done)
+}
+
+// Stop stops sampling
+func (h *History) Stop() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.ticker != nil {
+		h.ticker.Stop()
+		close(h.done)
+		h.ticker = nil
+	}
+}
+
+// downsample averages consecutive points into at most maxPoints buckets,
+// each stamped with the time of its first point
+func downsample(points []Point, maxPoints int) []Point {
+	if maxPoints <= 0 || len(points) <= maxPoints {
+		return points
+	}
+
+	result := make([]Point, 0, maxPoints)
+	for i := 0; i < maxPoints; i++ {
+		start := i * len(points) / maxPoints
+		end := (i + 1) * len(points) / maxPoints
+
+		var sum float64
+		for _, p := range points[start:end] {
+			sum += p.Value
+		}
+		result = append(result, Point{Time: points[start].Time, Value: sum / float64(end-start)})
+	}
+	return result
+}
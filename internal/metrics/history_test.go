@@ -0,0 +1,52 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHistoryGaugesAndRates(t *testing.T) {
+	r := NewRegistry()
+	connections := r.Gauge("connections", "Open connections")
+	published := r.Counter("published_total", "Messages published")
+
+	h := NewHistory(r, 10*time.Second, time.Minute)
+	h.Track("connections", "published_total", "missing")
+
+	start := time.Unix(1000000, 0)
+	for i := 0; i < 10; i++ {
+		connections.Set(float64(i))
+		published.Add(50) // 5 per second at 10s intervals
+		h.Sample(start.Add(time.Duration(i) * 10 * time.Second))
+	}
+
+	// Six samples fit in a minute at 10s resolution
+	gauge, err := h.Query("connections", time.Time{}, 0)
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(gauge) != 6 || gauge[0].Value != 4 || gauge[5].Value != 9 {
+		t.Errorf("Ring should hold the newest six samples, got %+v", gauge)
+	}
+
+	rate, _ := h.Query("published_total", time.Time{}, 0)
+	for _, p := range rate {
+		if p.Value != 5 {
+			t.Errorf("Counter should be recorded as 5/s, got %+v", rate)
+			break
+		}
+	}
+
+	// Restricting the range and downsampling
+	recent, _ := h.Query("connections", start.Add(60*time.Second), 2)
+	if len(recent) != 2 || recent[0].Value != 6.5 || recent[1].Value != 8.5 {
+		t.Errorf("Expected two averaged points, got %+v", recent)
+	}
+
+	if _, err := h.Query("unknown", time.Time{}, 0); err != ErrUnknownSeries {
+		t.Errorf("Expected ErrUnknownSeries, got %v", err)
+	}
+	if points, _ := h.Query("missing", time.Time{}, 0); len(points) != 0 {
+		t.Errorf("Unregistered metrics should have no points, got %+v", points)
+	}
+}
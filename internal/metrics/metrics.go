@@ -0,0 +1,178 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Counter is a monotonically increasing value
+type Counter struct {
+	value uint64
+}
+
+// Inc adds one to the counter
+func (c *Counter) Inc() {
+	atomic.AddUint64(&c.value, 1)
+}
+
+// Add adds delta to the counter
+func (c *Counter) Add(delta uint64) {
+	atomic.AddUint64(&c.value, delta)
+}
+
+// Value returns the current count
+func (c *Counter) Value() uint64 {
+	return atomic.LoadUint64(&c.value)
+}
+
+// Gauge is a value that can go up and down
+type Gauge struct {
+	bits uint64
+}
+
+// Set replaces the gauge value
+func (g *Gauge) Set(value float64) {
+	atomic.StoreUint64(&g.bits, math.Float64bits(value))
+}
+
+// Add adds delta to the gauge
+func (g *Gauge) Add(delta float64) {
+	for {
+		old := atomic.LoadUint64(&g.bits)
+		updated := math.Float64bits(math.Float64frombits(old) + delta)
+		if atomic.CompareAndSwapUint64(&g.bits, old, updated) {
+			return
+		}
+	}
+}
+
+// Value returns the current gauge value
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&g.bits))
+}
+
+// metric is a registered counter or gauge with its description
+type metric struct {
+	name    string
+	help    string
+	kind    string
+	counter *Counter
+	gauge   *Gauge
+	fn      func() float64
+}
+
+// Registry holds named metrics and renders them in the Prometheus text format
+type Registry struct {
+	metrics map[string]*metric
+	mu      sync.RWMutex
+}
+
+// Default is the registry used by packages that do not take one explicitly
+var Default = NewRegistry()
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{
+		metrics: make(map[string]*metric),
+	}
+}
+
+// Counter returns the counter with the given name, registering it on first use
+func (r *Registry) Counter(name, help string) *Counter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if m, exists := r.metrics[name]; exists && m.counter != nil {
+		return m.counter
+	}
+
+	c := &Counter{}
+	r.metrics[name] = &metric{name: name, help: help, kind: "counter", counter: c}
+	return c
+}
+
+// Gauge returns the gauge with the given name, registering it on first use
+func (r *Registry) Gauge(name, help string) *Gauge {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if m, exists := r.metrics[name]; exists && m.gauge != nil {
+		return m.gauge
+	}
+
+	g := &Gauge{}
+	r.metrics[name] = &metric{name: name, help: help, kind: "gauge", gauge: g}
+	return g
+}
+
+// GaugeFunc registers a gauge whose value is read from fn at exposition time,
+// replacing any metric of the same name
+func (r *Registry) GaugeFunc(name, help string, fn func() float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.metrics[name] = &metric{name: name, help: help, kind: "gauge", fn: fn}
+}
+
+// Read returns the current value of a metric and whether it is a counter
+func (r *Registry) Read(name string) (value float64, counter bool, ok bool) {
+	r.mu.RLock()
+	m, exists := r.metrics[name]
+	r.mu.RUnlock()
+
+	switch {
+	case !exists:
+		return 0, false, false
+	case m.counter != nil:
+		return float64(m.counter.Value()), true, true
+	case m.fn != nil:
+		return m.fn(), false, true
+	default:
+		return m.gauge.Value(), false, true
+	}
+}
+
+// WritePrometheus writes all metrics in the Prometheus text exposition format
+func (r *Registry) WritePrometheus(w io.Writer) error {
+	r.mu.RLock()
+	metrics := make([]*metric, 0, len(r.metrics))
+	for _, m := range r.metrics {
+		metrics = append(metrics, m)
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(metrics, func(i, j int) bool {
+		return metrics[i].name < metrics[j].name
+	})
+
+	for _, m := range metrics {
+		var value string
+		if m.counter != nil {
+			value = fmt.Sprintf("%d", m.counter.Value())
+		} else if m.fn != nil {
+			value = fmt.Sprintf("%g", m.fn())
+		} else {
+			value = fmt.Sprintf("%g", m.gauge.Value())
+		}
+
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %s\n",
+			m.name, m.help, m.name, m.kind, m.name, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Handler serves the registry in the Prometheus text format
+func (r *Registry) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		r.WritePrometheus(w)
+	}
+}
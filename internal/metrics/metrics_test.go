@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRegistryPrometheusOutput(t *testing.T) {
+	r := NewRegistry()
+
+	r.Counter("test_events_total", "Events seen").Add(3)
+	r.Gauge("test_bytes", "Bytes held").Set(1.5)
+
+	// Registering a name again returns the same metric
+	r.Counter("test_events_total", "Events seen").Inc()
+
+	var buf bytes.Buffer
+	if err := r.WritePrometheus(&buf); err != nil {
+		t.Fatalf("Failed to write metrics: %v", err)
+	}
+
+	expected := "# HELP test_bytes Bytes held\n" +
+		"# TYPE test_bytes gauge\n" +
+		"test_bytes 1.5\n" +
+		"# HELP test_events_total Events seen\n" +
+		"# TYPE test_events_total counter\n" +
+		"test_events_total 4\n"
+	if buf.String() != expected {
+		t.Errorf("Unexpected output:\n%s", buf.String())
+	}
+}
+
+func TestGaugeAdd(t *testing.T) {
+	g := &Gauge{}
+	g.Add(2)
+	g.Add(-0.5)
+	if g.Value() != 1.5 {
+		t.Errorf("Gauge should be 1.5, got %v", g.Value())
+	}
+
+	var buf bytes.Buffer
+	r := NewRegistry()
+	r.Gauge("g", "help")
+	r.WritePrometheus(&buf)
+	if !strings.Contains(buf.String(), "g 0\n") {
+		t.Errorf("Unset gauge should render as 0, got %q", buf.String())
+	}
+}
+
+func TestGaugeFunc(t *testing.T) {
+	r := NewRegistry()
+	value := 1.0
+	r.GaugeFunc("live", "Read at exposition", func() float64 { return value })
+
+	value = 42
+	var buf bytes.Buffer
+	r.WritePrometheus(&buf)
+	if !strings.Contains(buf.String(), "live 42\n") {
+		t.Errorf("Gauge func should be evaluated when written, got %q", buf.String())
+	}
+}
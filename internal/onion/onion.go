@@ -0,0 +1,84 @@
+// Package onion validates Tor v3 onion service addresses and formats the
+// hints that let clearnet clients discover a server's onion endpoint.
+package onion
+
+import (
+	"bytes"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// Suffix ends every onion address
+const Suffix = ".onion"
+
+// v3 addresses encode a 32 byte public key, a 2 byte checksum and a version
+// byte in 56 base32 characters
+const (
+	v3Version    = 3
+	v3EncodedLen = 56
+	v3DecodedLen = 35
+)
+
+// ErrInvalidAddress is returned for a string that is not a valid v3 onion
+// address
+var ErrInvalidAddress = errors.New("onion: invalid v3 onion address")
+
+// ParseAddress validates a v3 onion address, with or without its suffix, and
+// returns it in lower case with the suffix
+func ParseAddress(address string) (string, error) {
+	name := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(address)), Suffix)
+	if len(name) != v3EncodedLen {
+		return "", fmt.Errorf("%w: %q", ErrInvalidAddress, address)
+	}
+	decoded, err := base32.StdEncoding.DecodeString(strings.ToUpper(name))
+	if err != nil || len(decoded) != v3DecodedLen {
+		return "", fmt.Errorf("%w: %q", ErrInvalidAddress, address)
+	}
+
+	pubkey, checksum, version := decoded[:32], decoded[32:34], decoded[34]
+	if version != v3Version || !bytes.Equal(checksum, addressChecksum(pubkey, version)) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidAddress, address)
+	}
+	return name + Suffix, nil
+}
+
+// Address returns the v3 onion address of an ed25519 public key
+func Address(pubkey []byte) string {
+	encoded := make([]byte, 0, v3DecodedLen)
+	encoded = append(encoded, pubkey...)
+	encoded = append(encoded, addressChecksum(pubkey, v3Version)...)
+	encoded = append(encoded, v3Version)
+	return strings.ToLower(base32.StdEncoding.EncodeToString(encoded)) + Suffix
+}
+
+// addressChecksum is the checksum of rend-spec-v3: the first two bytes of
+// SHA3-256(".onion checksum" || pubkey || version)
+func addressChecksum(pubkey []byte, version byte) []byte {
+	h := sha3.New256()
+	h.Write([]byte(".onion checksum"))
+	h.Write(pubkey)
+	h.Write([]byte{version})
+	return h.Sum(nil)[:2]
+}
+
+// ReadHostname reads the address Tor writes to the hostname file of a
+// hidden service directory
+func ReadHostname(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return ParseAddress(string(data))
+}
+
+// AltSvc formats an Alt-Svc value advertising the onion endpoint for HTTP/2
+// on port for maxAge
+func AltSvc(address string, port int, maxAge time.Duration) string {
+	return fmt.Sprintf(`h2="%s:%d"; ma=%d`, address, port, int64(maxAge/time.Second))
+}
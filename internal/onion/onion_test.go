@@ -0,0 +1,69 @@
+package onion
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseAddress(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	address := Address(pub)
+
+	for _, input := range []string{address, strings.ToUpper(address), strings.TrimSuffix(address, Suffix), " " + address + "\n"} {
+		parsed, err := ParseAddress(input)
+		if err != nil || parsed != address {
+			t.Errorf("ParseAddress(%q) = %q, %v", input, parsed, err)
+		}
+	}
+
+	// Changing a character breaks the checksum
+	tampered := []byte(address)
+	if tampered[0] == 'a' {
+		tampered[0] = 'b'
+	} else {
+		tampered[0] = 'a'
+	}
+	for _, input := range []string{string(tampered), "example.onion", "", "expyuzz4wqqyqhjn.onion"} {
+		if _, err := ParseAddress(input); !errors.Is(err, ErrInvalidAddress) {
+			t.Errorf("Expected ErrInvalidAddress for %q, got %v", input, err)
+		}
+	}
+}
+
+func TestParseKnownAddress(t *testing.T) {
+	const known = "duckduckgogg42xjoc72x3sjasowoarfbgcmvfimaftt6twagswzczad.onion"
+	if parsed, err := ParseAddress(known); err != nil || parsed != known {
+		t.Errorf("ParseAddress(%q) = %q, %v", known, parsed, err)
+	}
+}
+
+func TestReadHostname(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "hostname")
+	if err := os.WriteFile(path, []byte(Address(pub)+"\n"), 0600); err != nil {
+		t.Fatalf("Failed to write hostname: %v", err)
+	}
+
+	address, err := ReadHostname(path)
+	if err != nil || address != Address(pub) {
+		t.Errorf("ReadHostname = %q, %v", address, err)
+	}
+}
+
+func TestAltSvc(t *testing.T) {
+	if got := AltSvc("abc.onion", 443, time.Hour); got != `h2="abc.onion:443"; ma=3600` {
+		t.Errorf("Unexpected Alt-Svc value %q", got)
+	}
+}
@@ -0,0 +1,285 @@
+// Package proxyproto accepts connections relayed by reverse proxies that
+// speak the PROXY protocol, versions 1 and 2, so the server sees each
+// client's own address. Only configured proxies may supply an address:
+// connections from other peers are refused, and a trusted proxy must open
+// every connection with a valid header.
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultHeaderTimeout is how long a proxy may take to send the header
+const DefaultHeaderTimeout = 5 * time.Second
+
+const (
+	// maxV1HeaderLength is the longest version 1 header, CRLF included
+	maxV1HeaderLength = 107
+	// maxV2PayloadLength bounds the addresses and TLVs of a version 2 header
+	maxV2PayloadLength = 2048
+)
+
+// v2Signature opens every version 2 header
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+var (
+	// ErrInvalidHeader is returned by reads from a connection whose proxy
+	// sent a missing or malformed header
+	ErrInvalidHeader = errors.New("proxyproto: invalid PROXY protocol header")
+	// ErrNoTrustedProxies is returned by ParseTrusted for an empty list
+	ErrNoTrustedProxies = errors.New("proxyproto: no trusted proxies")
+)
+
+// ParseTrusted parses the addresses of trusted proxies, given as IPs or
+// CIDR ranges
+func ParseTrusted(addrs []string) ([]*net.IPNet, error) {
+	if len(addrs) == 0 {
+		return nil, ErrNoTrustedProxies
+	}
+	trusted := make([]*net.IPNet, 0, len(addrs))
+	for _, addr := range addrs {
+		if !strings.Contains(addr, "/") {
+			ip := net.ParseIP(addr)
+			if ip == nil {
+				return nil, fmt.Errorf("proxyproto: invalid proxy address %q", addr)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			trusted = append(trusted, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(addr)
+		if err != nil {
+			return nil, fmt.Errorf("proxyproto: invalid proxy range %q", addr)
+		}
+		trusted = append(trusted, network)
+	}
+	return trusted, nil
+}
+
+// Listener accepts connections from trusted proxies only
+type Listener struct {
+	net.Listener
+	trusted       []*net.IPNet
+	headerTimeout time.Duration
+}
+
+// NewListener wraps inner so connections report the addresses their proxy
+// sends. A headerTimeout of zero uses DefaultHeaderTimeout.
+func NewListener(inner net.Listener, trusted []*net.IPNet, headerTimeout time.Duration) *Listener {
+	if headerTimeout <= 0 {
+		headerTimeout = DefaultHeaderTimeout
+	}
+	return &Listener{Listener: inner, trusted: trusted, headerTimeout: headerTimeout}
+}
+
+// Accept returns the next connection from a trusted proxy. Connections
+// from other peers are closed. The header is read on first use of the
+// connection, so a slow proxy does not hold up the accept loop.
+func (l *Listener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if l.isTrusted(conn.RemoteAddr()) {
+			return &Conn{Conn: conn, reader: bufio.NewReader(conn), headerTimeout: l.headerTimeout}, nil
+		}
+		log.Printf("Refused connection from %s: not a trusted proxy", conn.RemoteAddr())
+		conn.Close()
+	}
+}
+
+// isTrusted reports whether addr belongs to a trusted proxy
+func (l *Listener) isTrusted(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, network := range l.trusted {
+		if network.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// Conn is a proxied connection. RemoteAddr and LocalAddr report the
+// addresses from the header, or the proxy's own for its health checks.
+type Conn struct {
+	net.Conn
+	reader        *bufio.Reader
+	headerTimeout time.Duration
+
+	once   sync.Once
+	source net.Addr
+	dest   net.Addr
+	err    error
+}
+
+// Read reads past the header, failing if it is invalid
+func (c *Conn) Read(b []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+// RemoteAddr returns the client's address
+func (c *Conn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.source != nil {
+		return c.source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the address the client connected to
+func (c *Conn) LocalAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.dest != nil {
+		return c.dest
+	}
+	return c.Conn.LocalAddr()
+}
+
+// readHeader reads the header within the header timeout
+func (c *Conn) readHeader() {
+	c.Conn.SetReadDeadline(time.Now().Add(c.headerTimeout))
+	defer c.Conn.SetReadDeadline(time.Time{})
+
+	c.source, c.dest, c.err = ReadHeader(c.reader)
+	if c.err != nil {
+		log.Printf("Rejected proxied connection from %s: %v", c.Conn.RemoteAddr(), c.err)
+	}
+}
+
+// ReadHeader reads a version 1 or 2 header from r. The addresses are nil
+// for headers that carry none, such as a proxy's own health checks.
+func ReadHeader(r *bufio.Reader) (source, dest net.Addr, err error) {
+	prefix, err := r.Peek(len(v2Signature))
+	if err != nil {
+		return nil, nil, headerError(err)
+	}
+	switch {
+	case bytes.Equal(prefix, v2Signature):
+		return readV2(r)
+	case bytes.HasPrefix(prefix, []byte("PROXY ")):
+		return readV1(r)
+	}
+	return nil, nil, ErrInvalidHeader
+}
+
+// readV1 reads a text header such as "PROXY TCP4 src dst sport dport\r\n"
+func readV1(r *bufio.Reader) (source, dest net.Addr, err error) {
+	line := make([]byte, 0, maxV1HeaderLength)
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) == maxV1HeaderLength {
+			return nil, nil, ErrInvalidHeader
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, nil, headerError(err)
+		}
+		line = append(line, b)
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, nil, ErrInvalidHeader
+	}
+	v4 := fields[1] == "TCP4"
+	srcIP, dstIP := parseIP(fields[2], v4), parseIP(fields[3], v4)
+	srcPort, srcOK := parsePort(fields[4])
+	dstPort, dstOK := parsePort(fields[5])
+	if srcIP == nil || dstIP == nil || !srcOK || !dstOK {
+		return nil, nil, ErrInvalidHeader
+	}
+	return &net.TCPAddr{IP: srcIP, Port: srcPort}, &net.TCPAddr{IP: dstIP, Port: dstPort}, nil
+}
+
+// readV2 reads a binary header
+func readV2(r *bufio.Reader) (source, dest net.Addr, err error) {
+	var header [16]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, nil, headerError(err)
+	}
+	version, command := header[12]>>4, header[12]&0x0F
+	family := header[13]
+	length := binary.BigEndian.Uint16(header[14:])
+	if version != 2 || command > 1 || length > maxV2PayloadLength {
+		return nil, nil, ErrInvalidHeader
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, nil, headerError(err)
+	}
+
+	// LOCAL connections come from the proxy itself
+	if command == 0 {
+		return nil, nil, nil
+	}
+	switch family {
+	case 0x00: // Unspecified
+		return nil, nil, nil
+	case 0x11: // TCP over IPv4
+		if len(payload) < 12 {
+			return nil, nil, ErrInvalidHeader
+		}
+		return v2Addr(payload[0:4], payload[8:10]), v2Addr(payload[4:8], payload[10:12]), nil
+	case 0x21: // TCP over IPv6
+		if len(payload) < 36 {
+			return nil, nil, ErrInvalidHeader
+		}
+		return v2Addr(payload[0:16], payload[32:34]), v2Addr(payload[16:32], payload[34:36]), nil
+	}
+	return nil, nil, ErrInvalidHeader
+}
+
+// v2Addr builds an address from the raw IP and port of a version 2 header
+func v2Addr(ip, port []byte) *net.TCPAddr {
+	return &net.TCPAddr{IP: append(net.IP(nil), ip...), Port: int(binary.BigEndian.Uint16(port))}
+}
+
+// parseIP parses an address of the family a version 1 header declares
+func parseIP(s string, v4 bool) net.IP {
+	ip := net.ParseIP(s)
+	if ip == nil || (ip.To4() != nil) != v4 || strings.Contains(s, ":") == v4 {
+		return nil
+	}
+	return ip
+}
+
+// parsePort parses a version 1 port, which has no leading zeros
+func parsePort(s string) (int, bool) {
+	port, err := strconv.ParseUint(s, 10, 16)
+	if err != nil || strconv.FormatUint(port, 10) != s {
+		return 0, false
+	}
+	return int(port), true
+}
+
+// headerError reports a header cut short as invalid
+func headerError(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrInvalidHeader
+	}
+	return err
+}
@@ -0,0 +1,131 @@
+package proxyproto
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadHeader(t *testing.T) {
+	v2 := func(command, family byte, payload ...byte) string {
+		header := append([]byte(nil), v2Signature...)
+		header = append(header, 0x20|command, family, 0, byte(len(payload)))
+		return string(append(header, payload...))
+	}
+	ipv4Payload := []byte{192, 0, 2, 1, 198, 51, 100, 7, 0x30, 0x39, 0x20, 0xFB}
+
+	tests := []struct {
+		name   string
+		header string
+		source string
+		dest   string
+		err    error
+	}{
+		{"v1 TCP4", "PROXY TCP4 192.0.2.1 198.51.100.7 12345 8443\r\n", "192.0.2.1:12345", "198.51.100.7:8443", nil},
+		{"v1 TCP6", "PROXY TCP6 2001:db8::1 2001:db8::2 12345 8443\r\n", "[2001:db8::1]:12345", "[2001:db8::2]:8443", nil},
+		{"v1 unknown", "PROXY UNKNOWN\r\n", "", "", nil},
+		{"v1 family mismatch", "PROXY TCP4 2001:db8::1 198.51.100.7 12345 8443\r\n", "", "", ErrInvalidHeader},
+		{"v1 leading zero", "PROXY TCP4 192.0.2.1 198.51.100.7 012345 8443\r\n", "", "", ErrInvalidHeader},
+		{"v1 no CRLF", "PROXY TCP4 192.0.2.1 198.51.100.7 12345 8443\n", "", "", ErrInvalidHeader},
+		{"v1 too long", "PROXY TCP4 " + strings.Repeat("1", 120) + "\r\n", "", "", ErrInvalidHeader},
+		{"v2 IPv4", v2(1, 0x11, ipv4Payload...), "192.0.2.1:12345", "198.51.100.7:8443", nil},
+		{"v2 local", v2(0, 0x00), "", "", nil},
+		{"v2 short payload", v2(1, 0x11, 192, 0, 2, 1), "", "", ErrInvalidHeader},
+		{"v2 UDP", v2(1, 0x12, ipv4Payload...), "", "", ErrInvalidHeader},
+		{"no header", "GET / HTTP/1.1\r\nHost: example\r\n\r\n", "", "", ErrInvalidHeader},
+		{"cut short", "PROXY TCP4 192.0.2.1", "", "", ErrInvalidHeader},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := bufio.NewReader(strings.NewReader(tt.header + "payload"))
+			source, dest, err := ReadHeader(r)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected %v, got %v", tt.err, err)
+			}
+			if err != nil {
+				return
+			}
+			if got := addrString(source); got != tt.source {
+				t.Errorf("source: expected %q, got %q", tt.source, got)
+			}
+			if got := addrString(dest); got != tt.dest {
+				t.Errorf("dest: expected %q, got %q", tt.dest, got)
+			}
+			if rest, _ := io.ReadAll(r); string(rest) != "payload" {
+				t.Errorf("header read too much or too little, left %q", rest)
+			}
+		})
+	}
+}
+
+func TestListenerTrust(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer inner.Close()
+
+	trusted, err := ParseTrusted([]string{"127.0.0.1"})
+	if err != nil {
+		t.Fatalf("ParseTrusted failed: %v", err)
+	}
+	listener := NewListener(inner, trusted, time.Second)
+
+	go func() {
+		conn, err := net.Dial("tcp", inner.Addr().String())
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.WriteString(conn, "PROXY TCP4 192.0.2.1 198.51.100.7 12345 8443\r\nhello")
+		io.ReadAll(conn)
+	}()
+
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("Accept failed: %v", err)
+	}
+	defer conn.Close()
+	if got := conn.RemoteAddr().String(); got != "192.0.2.1:12345" {
+		t.Errorf("Expected the client's address, got %s", got)
+	}
+	data := make([]byte, 5)
+	if _, err := io.ReadFull(conn, data); err != nil || string(data) != "hello" {
+		t.Errorf("Expected the payload after the header, got %q, %v", data, err)
+	}
+
+	// Peers outside the trusted ranges are refused
+	untrusted, _ := ParseTrusted([]string{"192.0.2.0/24"})
+	listener.trusted = untrusted
+	go func() {
+		conn, err := net.Dial("tcp", inner.Addr().String())
+		if err == nil {
+			io.ReadAll(conn)
+			conn.Close()
+		}
+		inner.Close()
+	}()
+	if conn, err := listener.Accept(); err == nil {
+		conn.Close()
+		t.Error("A connection from an untrusted peer was accepted")
+	}
+
+	if _, err := ParseTrusted(nil); !errors.Is(err, ErrNoTrustedProxies) {
+		t.Errorf("Expected ErrNoTrustedProxies, got %v", err)
+	}
+	if _, err := ParseTrusted([]string{"not-an-ip"}); err == nil {
+		t.Error("Expected an error for an invalid address")
+	}
+}
+
+// addrString formats an optional address
+func addrString(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	return addr.String()
+}
@@ -0,0 +1,206 @@
+package push
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Dispatcher defaults
+const (
+	DefaultMinInterval = 30 * time.Second
+	queueSize          = 256
+	pushTimeout        = 10 * time.Second
+)
+
+// job is one wake-up waiting to be sent
+type job struct {
+	provider Provider
+	target   target
+}
+
+// Dispatcher sends wake-ups to registered clients that are not subscribed to
+// a bin when a message arrives in it. Wake-ups for a certificate are
+// coalesced to at most one per minimum interval, and dropped if the queue is
+// full; a client that wakes fetches everything that is waiting anyway.
+type Dispatcher struct {
+	registry    *Registry
+	providers   map[string]Provider
+	minInterval time.Duration
+	skip        func(certID string) bool
+
+	active   map[string]map[uint64]int // Certificate ID -> subscribed bins -> sessions
+	lastPush map[string]time.Time
+	mu       sync.Mutex
+
+	queue    chan job
+	stop     chan struct{}
+	wg       sync.WaitGroup
+	stopOnce sync.Once
+}
+
+// NewDispatcher creates a dispatcher for the registry. A minInterval of zero
+// uses DefaultMinInterval.
+func NewDispatcher(registry *Registry, minInterval time.Duration) *Dispatcher {
+	if minInterval <= 0 {
+		minInterval = DefaultMinInterval
+	}
+	return &Dispatcher{
+		registry:    registry,
+		providers:   make(map[string]Provider),
+		minInterval: minInterval,
+		active:      make(map[string]map[uint64]int),
+		lastPush:    make(map[string]time.Time),
+		queue:       make(chan job, queueSize),
+		stop:        make(chan struct{}),
+	}
+}
+
+// AddProvider makes a provider available to registrations under name. Call
+// it before Start.
+func (d *Dispatcher) AddProvider(name string, provider Provider) {
+	d.providers[name] = provider
+}
+
+// SkipCertificates stops wake-ups to certificates for which skip returns
+// true, such as revoked ones. Call it before Start.
+func (d *Dispatcher) SkipCertificates(skip func(certID string) bool) {
+	d.skip = skip
+}
+
+// Registry returns the registry the dispatcher reads
+func (d *Dispatcher) Registry() *Registry {
+	return d.registry
+}
+
+// Register validates the provider and token, then records the registration
+func (d *Dispatcher) Register(certID, provider, token string, binIDs []uint64) error {
+	p, exists := d.providers[provider]
+	if !exists {
+		return ErrInvalidRegistration
+	}
+	if validator, ok := p.(TokenValidator); ok {
+		if err := validator.ValidateToken(token); err != nil {
+			return err
+		}
+	}
+	return d.registry.Register(certID, provider, token, binIDs)
+}
+
+// Start runs the delivery worker
+func (d *Dispatcher) Start() {
+	d.wg.Add(1)
+	go d.run()
+}
+
+// Stop ends the delivery worker; queued wake-ups are discarded
+func (d *Dispatcher) Stop() {
+	d.stopOnce.Do(func() {
+		close(d.stop)
+	})
+	d.wg.Wait()
+}
+
+// Connected records a session of certID subscribed to binIDs. Call the
+// returned function when the session ends.
+func (d *Dispatcher) Connected(certID string, binIDs []uint64) func() {
+	binIDs = append([]uint64(nil), binIDs...)
+	d.mu.Lock()
+	d.adjustLocked(certID, binIDs, 1)
+	d.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			d.mu.Lock()
+			d.adjustLocked(certID, binIDs, -1)
+			d.mu.Unlock()
+		})
+	}
+}
+
+// adjustLocked changes the session counts of certID's bins by delta;
+// callers hold d.mu
+func (d *Dispatcher) adjustLocked(certID string, binIDs []uint64, delta int) {
+	bins, exists := d.active[certID]
+	if !exists {
+		bins = make(map[uint64]int)
+		d.active[certID] = bins
+	}
+	for _, binID := range binIDs {
+		if bins[binID] += delta; bins[binID] <= 0 {
+			delete(bins, binID)
+		}
+	}
+	if len(bins) == 0 {
+		delete(d.active, certID)
+	}
+}
+
+// Notify queues wake-ups for a message in binID. It never blocks.
+func (d *Dispatcher) Notify(binID uint64) {
+	targets, err := d.registry.targets(binID)
+	if err != nil {
+		log.Printf("Push registry: %v", err)
+	}
+	if len(targets) == 0 {
+		return
+	}
+
+	now := time.Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, t := range targets {
+		if d.active[t.certID][binID] > 0 {
+			continue // The subscriber receives the message directly
+		}
+		if d.skip != nil && d.skip(t.certID) {
+			continue
+		}
+		if last, exists := d.lastPush[t.certID]; exists && now.Sub(last) < d.minInterval {
+			continue
+		}
+		provider, exists := d.providers[t.provider]
+		if !exists {
+			continue
+		}
+
+		select {
+		case d.queue <- job{provider: provider, target: t}:
+			d.lastPush[t.certID] = now
+		default:
+			return // Queue full; the next message retries
+		}
+	}
+}
+
+// run delivers queued wake-ups and prunes stale coalescing entries
+func (d *Dispatcher) run() {
+	defer d.wg.Done()
+
+	ticker := time.NewTicker(d.minInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.stop:
+			return
+		case j := <-d.queue:
+			ctx, cancel := context.WithTimeout(context.Background(), pushTimeout)
+			if err := j.provider.Push(ctx, j.target.token); err != nil {
+				log.Printf("Push to %s failed: %v", j.target.provider, err)
+			}
+			cancel()
+		case now := <-ticker.C:
+			d.mu.Lock()
+			for certID, last := range d.lastPush {
+				if now.Sub(last) >= d.minInterval {
+					delete(d.lastPush, certID)
+				}
+			}
+			d.mu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package push
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingProvider records the tokens it is asked to push to
+type recordingProvider struct {
+	tokens chan string
+}
+
+func (p *recordingProvider) Push(ctx context.Context, token string) error {
+	p.tokens <- token
+	return nil
+}
+
+func newTestDispatcher(t *testing.T, minInterval time.Duration) (*Dispatcher, *recordingProvider) {
+	r, err := NewRegistry("", testKey(1))
+	if err != nil {
+		t.Fatalf("Failed to create registry: %v", err)
+	}
+	provider := &recordingProvider{tokens: make(chan string, 16)}
+	d := NewDispatcher(r, minInterval)
+	d.AddProvider(ProviderWebhook, provider)
+	d.Start()
+	t.Cleanup(d.Stop)
+	return d, provider
+}
+
+func expectPush(t *testing.T, provider *recordingProvider, token string) {
+	t.Helper()
+	select {
+	case got := <-provider.tokens:
+		if got != token {
+			t.Errorf("Expected push to %s, got %s", token, got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("No push to %s", token)
+	}
+}
+
+func expectNoPush(t *testing.T, provider *recordingProvider) {
+	t.Helper()
+	select {
+	case got := <-provider.tokens:
+		t.Errorf("Unexpected push to %s", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestDispatcherSkipsSubscribedClients(t *testing.T) {
+	d, provider := newTestDispatcher(t, time.Millisecond)
+	if err := d.Register("cert-a", ProviderWebhook, "token-a", []uint64{1, 2}); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+
+	// Subscribed to bin 1 only: bin 2 still wakes the client
+	disconnect := d.Connected("cert-a", []uint64{1})
+	d.Notify(1)
+	expectNoPush(t, provider)
+	d.Notify(2)
+	expectPush(t, provider, "token-a")
+
+	disconnect()
+	disconnect() // Idempotent
+	time.Sleep(5 * time.Millisecond)
+	d.Notify(1)
+	expectPush(t, provider, "token-a")
+
+	// Unregistered bins and unknown providers never push
+	d.Notify(3)
+	if err := d.Register("cert-b", "apns", "token-b", []uint64{3}); err != ErrInvalidRegistration {
+		t.Errorf("Expected ErrInvalidRegistration for an unknown provider, got %v", err)
+	}
+	expectNoPush(t, provider)
+}
+
+func TestDispatcherCoalescesAndSkips(t *testing.T) {
+	d, provider := newTestDispatcher(t, time.Hour)
+	revoked := map[string]bool{"cert-b": true}
+	var mu sync.Mutex
+	d.SkipCertificates(func(certID string) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return revoked[certID]
+	})
+
+	d.Register("cert-a", ProviderWebhook, "token-a", []uint64{1})
+	d.Register("cert-b", ProviderWebhook, "token-b", []uint64{1})
+
+	d.Notify(1)
+	expectPush(t, provider, "token-a")
+	d.Notify(1)
+	expectNoPush(t, provider)
+}
+
+func TestUnifiedPushProvider(t *testing.T) {
+	var body []byte
+	endpoint := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer endpoint.Close()
+
+	up := NewUnifiedPush(endpoint.Client())
+	if err := up.Push(context.Background(), endpoint.URL+"/up/abc"); err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	if string(body) != string(wakeBody) {
+		t.Errorf("Wake-up carried %q", body)
+	}
+
+	if err := up.ValidateToken("http://push.example/up"); err != ErrInvalidToken {
+		t.Errorf("Expected ErrInvalidToken for plain HTTP, got %v", err)
+	}
+	restricted := NewUnifiedPush(nil, "push.example")
+	if err := restricted.ValidateToken("https://push.example/up/abc"); err != nil {
+		t.Errorf("Allowed host rejected: %v", err)
+	}
+	if err := restricted.ValidateToken("https://10.0.0.1/up/abc"); err != ErrInvalidToken {
+		t.Errorf("Expected ErrInvalidToken for another host, got %v", err)
+	}
+}
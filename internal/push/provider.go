@@ -0,0 +1,126 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Provider names used in registrations
+const (
+	ProviderUnifiedPush = "unifiedpush"
+	ProviderWebhook     = "webhook"
+)
+
+// ErrInvalidToken is returned by a provider that cannot use a token
+var ErrInvalidToken = errors.New("push: invalid token for provider")
+
+// wakeBody is the whole payload of a wake-up. It says nothing about the bin
+// or the message; the client connects to find out.
+var wakeBody = []byte(`{"type":"wake"}`)
+
+// Provider delivers a wake-up to a push token
+type Provider interface {
+	Push(ctx context.Context, token string) error
+}
+
+// TokenValidator is implemented by providers that can reject a token when
+// it is registered rather than when it is first used
+type TokenValidator interface {
+	ValidateToken(token string) error
+}
+
+// UnifiedPush delivers to UnifiedPush distributors. The token is the
+// endpoint URL the distributor handed to the app, which must use HTTPS and,
+// if hosts are configured, point at one of them.
+type UnifiedPush struct {
+	client *http.Client
+	hosts  map[string]bool
+}
+
+// NewUnifiedPush creates the provider. A nil client uses one with a 10
+// second timeout; an empty host list allows any HTTPS endpoint.
+func NewUnifiedPush(client *http.Client, hosts ...string) *UnifiedPush {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	allowed := make(map[string]bool, len(hosts))
+	for _, host := range hosts {
+		allowed[strings.ToLower(host)] = true
+	}
+	return &UnifiedPush{client: client, hosts: allowed}
+}
+
+// ValidateToken checks that the endpoint may be pushed to
+func (up *UnifiedPush) ValidateToken(token string) error {
+	endpoint, err := url.Parse(token)
+	if err != nil || endpoint.Scheme != "https" || endpoint.Host == "" || endpoint.User != nil {
+		return ErrInvalidToken
+	}
+	if len(up.hosts) > 0 && !up.hosts[strings.ToLower(endpoint.Hostname())] {
+		return ErrInvalidToken
+	}
+	return nil
+}
+
+// Push POSTs the wake-up to the endpoint
+func (up *UnifiedPush) Push(ctx context.Context, token string) error {
+	if err := up.ValidateToken(token); err != nil {
+		return err
+	}
+	return post(ctx, up.client, token, wakeBody)
+}
+
+// Webhook hands tokens to a gateway, such as a service that relays to FCM
+// or APNs with credentials this server does not hold. Each wake-up is a
+// POST of {"token": "..."} to the gateway URL.
+type Webhook struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhook creates the provider. A nil client uses one with a 10 second
+// timeout.
+func NewWebhook(url string, client *http.Client) *Webhook {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Webhook{url: url, client: client}
+}
+
+// Push POSTs the token to the gateway
+func (wh *Webhook) Push(ctx context.Context, token string) error {
+	body, err := json.Marshal(map[string]string{"token": token})
+	if err != nil {
+		return err
+	}
+	return post(ctx, wh.client, wh.url, body)
+}
+
+// post sends a JSON body; any non-2xx response is an error
+func post(ctx context.Context, client *http.Client, endpoint string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("push: provider returned %s", resp.Status)
+	}
+	return nil
+}
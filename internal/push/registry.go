@@ -0,0 +1,255 @@
+// Package push wakes mobile clients that hold no open connection. Clients
+// register a push token for the bins they follow; when a message arrives in
+// one of those bins and the client is not subscribed to it at that moment,
+// a content-free wake-up is sent through the token's provider, and the
+// client connects to fetch the message.
+package push
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Registration limits
+const (
+	MaxTokenLength = 4096
+	MaxBins        = 64
+)
+
+var (
+	// ErrInvalidKey is returned for a token key that is not 32 bytes
+	ErrInvalidKey = errors.New("push: token key must be 32 bytes")
+	// ErrInvalidRegistration is returned for an empty or oversized token or
+	// bin list
+	ErrInvalidRegistration = errors.New("push: invalid registration")
+	// ErrNotRegistered is returned for a certificate without a registration
+	ErrNotRegistered = errors.New("push: not registered")
+	// ErrSealedToken is returned when a stored token cannot be decrypted
+	ErrSealedToken = errors.New("push: stored token cannot be decrypted")
+)
+
+// Registration is a certificate's push target. The token is sealed with the
+// registry key, bound to the certificate ID, so a leaked registry file does
+// not reveal where clients can be reached.
+type Registration struct {
+	CertID   string    `json:"cert_id"`
+	Provider string    `json:"provider"`
+	Token    []byte    `json:"token"` // Nonce followed by the sealed token
+	BinIDs   []uint64  `json:"bin_ids"`
+	Updated  time.Time `json:"updated"`
+}
+
+// target is a decrypted registration ready to be pushed to
+type target struct {
+	certID   string
+	provider string
+	token    string
+}
+
+// Registry records push registrations, optionally persisted to a file
+type Registry struct {
+	aead  cipher.AEAD
+	regs  map[string]*Registration
+	byBin map[uint64]map[string]bool
+	path  string
+	mu    sync.RWMutex
+}
+
+// NewRegistry creates a registry sealing tokens with key. If path is not
+// empty the registry is loaded from it, when it exists, and saved to it on
+// every change.
+func NewRegistry(path string, key []byte) (*Registry, error) {
+	if len(key) != 32 {
+		return nil, ErrInvalidKey
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Registry{
+		aead:  aead,
+		regs:  make(map[string]*Registration),
+		byBin: make(map[uint64]map[string]bool),
+		path:  path,
+	}
+	if path == "" {
+		return r, nil
+	}
+
+	data, err := os.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+		return r, nil
+	case err != nil:
+		return nil, err
+	}
+	var regs []Registration
+	if err := json.Unmarshal(data, &regs); err != nil {
+		return nil, err
+	}
+	for i := range regs {
+		r.addLocked(&regs[i])
+	}
+	return r, nil
+}
+
+// Register records or replaces the registration of certID
+func (r *Registry) Register(certID, provider, token string, binIDs []uint64) error {
+	if token == "" || len(token) > MaxTokenLength || len(binIDs) == 0 || len(binIDs) > MaxBins {
+		return ErrInvalidRegistration
+	}
+
+	nonce := make([]byte, r.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	reg := &Registration{
+		CertID:   certID,
+		Provider: provider,
+		Token:    r.aead.Seal(nonce, nonce, []byte(token), []byte(certID)),
+		BinIDs:   append([]uint64(nil), binIDs...),
+		Updated:  time.Now().UTC(),
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.removeLocked(certID)
+	r.addLocked(reg)
+	return r.saveLocked()
+}
+
+// Unregister removes the registration of certID
+func (r *Registry) Unregister(certID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.removeLocked(certID) {
+		return ErrNotRegistered
+	}
+	return r.saveLocked()
+}
+
+// Lookup returns the provider and bins certID is registered for
+func (r *Registry) Lookup(certID string) (string, []uint64, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	reg, exists := r.regs[certID]
+	if !exists {
+		return "", nil, false
+	}
+	return reg.Provider, append([]uint64(nil), reg.BinIDs...), true
+}
+
+// Count returns the number of registrations
+func (r *Registry) Count() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.regs)
+}
+
+// targets returns the decrypted registrations that follow binID
+func (r *Registry) targets(binID uint64) ([]target, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	certIDs := make([]string, 0, len(r.byBin[binID]))
+	for certID := range r.byBin[binID] {
+		certIDs = append(certIDs, certID)
+	}
+	sort.Strings(certIDs)
+
+	targets := make([]target, 0, len(certIDs))
+	var firstErr error
+	for _, certID := range certIDs {
+		reg := r.regs[certID]
+		token, err := r.open(reg)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		targets = append(targets, target{certID: certID, provider: reg.Provider, token: token})
+	}
+	return targets, firstErr
+}
+
+// open decrypts the token of a registration
+func (r *Registry) open(reg *Registration) (string, error) {
+	size := r.aead.NonceSize()
+	if len(reg.Token) < size {
+		return "", ErrSealedToken
+	}
+	token, err := r.aead.Open(nil, reg.Token[:size], reg.Token[size:], []byte(reg.CertID))
+	if err != nil {
+		return "", ErrSealedToken
+	}
+	return string(token), nil
+}
+
+// addLocked indexes a registration; callers hold r.mu
+func (r *Registry) addLocked(reg *Registration) {
+	r.regs[reg.CertID] = reg
+	for _, binID := range reg.BinIDs {
+		certIDs, exists := r.byBin[binID]
+		if !exists {
+			certIDs = make(map[string]bool)
+			r.byBin[binID] = certIDs
+		}
+		certIDs[reg.CertID] = true
+	}
+}
+
+// removeLocked drops a registration and its index entries, reporting
+// whether there was one; callers hold r.mu
+func (r *Registry) removeLocked(certID string) bool {
+	reg, exists := r.regs[certID]
+	if !exists {
+		return false
+	}
+	delete(r.regs, certID)
+	for _, binID := range reg.BinIDs {
+		delete(r.byBin[binID], certID)
+		if len(r.byBin[binID]) == 0 {
+			delete(r.byBin, binID)
+		}
+	}
+	return true
+}
+
+// saveLocked writes the registry to its file, if it has one; callers hold
+// r.mu
+func (r *Registry) saveLocked() error {
+	if r.path == "" {
+		return nil
+	}
+
+	regs := make([]Registration, 0, len(r.regs))
+	for _, reg := range r.regs {
+		regs = append(regs, *reg)
+	}
+	sort.Slice(regs, func(i, j int) bool { return regs[i].CertID < regs[j].CertID })
+	data, err := json.MarshalIndent(regs, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := r.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.path)
+}
@@ -0,0 +1,102 @@
+package push
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testKey(fill byte) []byte {
+	return bytes.Repeat([]byte{fill}, 32)
+}
+
+func TestRegistryRegisterAndLookup(t *testing.T) {
+	r, err := NewRegistry("", testKey(1))
+	if err != nil {
+		t.Fatalf("Failed to create registry: %v", err)
+	}
+
+	if err := r.Register("cert-a", ProviderUnifiedPush, "https://push.example/a", []uint64{1, 2}); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	if err := r.Register("cert-b", ProviderWebhook, "fcm-token-b", []uint64{2}); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+
+	targets, err := r.targets(2)
+	if err != nil || len(targets) != 2 {
+		t.Fatalf("Expected two targets for bin 2, got %+v, %v", targets, err)
+	}
+	if targets[0].token != "https://push.example/a" || targets[1].token != "fcm-token-b" {
+		t.Errorf("Tokens did not decrypt: %+v", targets)
+	}
+
+	// Registering again replaces the bins
+	if err := r.Register("cert-a", ProviderUnifiedPush, "https://push.example/a", []uint64{3}); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	if targets, _ := r.targets(1); len(targets) != 0 {
+		t.Errorf("Replaced registration still follows bin 1")
+	}
+	if provider, binIDs, ok := r.Lookup("cert-a"); !ok || provider != ProviderUnifiedPush || len(binIDs) != 1 || binIDs[0] != 3 {
+		t.Errorf("Unexpected lookup: %s %v %v", provider, binIDs, ok)
+	}
+
+	if err := r.Unregister("cert-a"); err != nil {
+		t.Fatalf("Unregister failed: %v", err)
+	}
+	if err := r.Unregister("cert-a"); err != ErrNotRegistered {
+		t.Errorf("Expected ErrNotRegistered, got %v", err)
+	}
+	if r.Count() != 1 {
+		t.Errorf("Expected one registration, got %d", r.Count())
+	}
+
+	if err := r.Register("cert-c", ProviderWebhook, "", []uint64{1}); err != ErrInvalidRegistration {
+		t.Errorf("Expected ErrInvalidRegistration for an empty token, got %v", err)
+	}
+	if err := r.Register("cert-c", ProviderWebhook, "token", nil); err != ErrInvalidRegistration {
+		t.Errorf("Expected ErrInvalidRegistration without bins, got %v", err)
+	}
+	if _, err := NewRegistry("", []byte("short")); err != ErrInvalidKey {
+		t.Errorf("Expected ErrInvalidKey, got %v", err)
+	}
+}
+
+func TestRegistryPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "push.json")
+
+	r, err := NewRegistry(path, testKey(1))
+	if err != nil {
+		t.Fatalf("Failed to create registry: %v", err)
+	}
+	if err := r.Register("cert-a", ProviderWebhook, "secret-token", []uint64{7}); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Registry was not saved: %v", err)
+	}
+	if bytes.Contains(data, []byte("secret-token")) {
+		t.Errorf("Token stored in the clear")
+	}
+
+	reloaded, err := NewRegistry(path, testKey(1))
+	if err != nil {
+		t.Fatalf("Failed to reload registry: %v", err)
+	}
+	if targets, err := reloaded.targets(7); err != nil || len(targets) != 1 || targets[0].token != "secret-token" {
+		t.Errorf("Unexpected targets after reload: %+v, %v", targets, err)
+	}
+
+	// A different key loads the registrations but cannot open the tokens
+	wrongKey, err := NewRegistry(path, testKey(2))
+	if err != nil {
+		t.Fatalf("Failed to load registry: %v", err)
+	}
+	if _, err := wrongKey.targets(7); err != ErrSealedToken {
+		t.Errorf("Expected ErrSealedToken, got %v", err)
+	}
+}
@@ -0,0 +1,50 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// ByteBucket shapes a byte stream to a rate with a burst allowance. Unlike
+// TokenBucket it never refuses: Reserve takes the bytes, going into debt if
+// needed, and returns how long the caller should wait before sending them.
+// One bucket may be shared by several streams to shape their total.
+type ByteBucket struct {
+	rate   float64 // bytes added per second
+	burst  float64
+	tokens float64
+	last   time.Time
+	mu     sync.Mutex
+	now    func() time.Time
+}
+
+// NewByteBucket creates a bucket refilling rate bytes per second up to
+// burst, starting full
+func NewByteBucket(rate float64, burst int) *ByteBucket {
+	return &ByteBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+		now:    time.Now,
+	}
+}
+
+// Reserve takes n bytes from the bucket and returns the delay after which
+// sending them keeps the stream within the rate
+func (bb *ByteBucket) Reserve(n int) time.Duration {
+	bb.mu.Lock()
+	defer bb.mu.Unlock()
+
+	now := bb.now()
+	elapsed := now.Sub(bb.last).Seconds()
+	bb.tokens = math.Min(bb.burst, bb.tokens+elapsed*bb.rate)
+	bb.last = now
+
+	bb.tokens -= float64(n)
+	if bb.tokens >= 0 || bb.rate <= 0 {
+		return 0
+	}
+	return time.Duration(-bb.tokens / bb.rate * float64(time.Second))
+}
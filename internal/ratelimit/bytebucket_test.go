@@ -0,0 +1,35 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestByteBucketShaping(t *testing.T) {
+	now := time.Now()
+	bb := NewByteBucket(1000, 2000)
+	bb.now = func() time.Time { return now }
+	bb.last = now
+
+	// The burst goes out immediately
+	if delay := bb.Reserve(2000); delay != 0 {
+		t.Errorf("Burst should not be delayed, got %v", delay)
+	}
+
+	// Beyond the burst, writes wait for the bytes to refill
+	if delay := bb.Reserve(500); delay != 500*time.Millisecond {
+		t.Errorf("Expected 500ms delay, got %v", delay)
+	}
+	if delay := bb.Reserve(500); delay != time.Second {
+		t.Errorf("Debt should accumulate to 1s, got %v", delay)
+	}
+
+	// Idle time pays the debt and refills up to the burst only
+	now = now.Add(time.Hour)
+	if delay := bb.Reserve(2000); delay != 0 {
+		t.Errorf("Refilled burst should not be delayed, got %v", delay)
+	}
+	if delay := bb.Reserve(1); delay == 0 {
+		t.Errorf("Refill should be capped at the burst")
+	}
+}
@@ -0,0 +1,100 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Limiter decides whether an action identified by key may proceed
+type Limiter interface {
+	// Allow reports whether one more event for key is permitted now
+	Allow(key string) bool
+}
+
+// bucket holds the token state for a single key
+type bucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+// TokenBucket is an in-memory per-key token bucket limiter
+type TokenBucket struct {
+	rate    float64 // tokens added per second
+	burst   float64
+	buckets map[string]*bucket
+	mu      sync.Mutex
+	now     func() time.Time
+	sweeps  int
+}
+
+// NewTokenBucket creates a limiter refilling rate tokens per second up to burst
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	return &TokenBucket{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+// Allow consumes a token for key if one is available
+func (tb *TokenBucket) Allow(key string) bool {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	now := tb.now()
+	tb.maybeSweepLocked(now)
+
+	b, exists := tb.buckets[key]
+	if !exists {
+		b = &bucket{tokens: tb.burst, lastSeen: now}
+		tb.buckets[key] = b
+	} else {
+		elapsed := now.Sub(b.lastSeen).Seconds()
+		b.tokens = math.Min(tb.burst, b.tokens+elapsed*tb.rate)
+		b.lastSeen = now
+	}
+
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+	return true
+}
+
+// RetryAfter returns how long until key will have a token available
+func (tb *TokenBucket) RetryAfter(key string) time.Duration {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	b, exists := tb.buckets[key]
+	if !exists || tb.rate <= 0 {
+		return 0
+	}
+
+	elapsed := tb.now().Sub(b.lastSeen).Seconds()
+	tokens := math.Min(tb.burst, b.tokens+elapsed*tb.rate)
+	if tokens >= 1 {
+		return 0
+	}
+
+	return time.Duration((1 - tokens) / tb.rate * float64(time.Second))
+}
+
+// maybeSweepLocked periodically drops buckets that have refilled completely,
+// so the map does not grow with every address ever seen
+func (tb *TokenBucket) maybeSweepLocked(now time.Time) {
+	tb.sweeps++
+	if tb.sweeps < 1024 {
+		return
+	}
+	tb.sweeps = 0
+
+	for key, b := range tb.buckets {
+		if b.tokens+now.Sub(b.lastSeen).Seconds()*tb.rate >= tb.burst {
+			delete(tb.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucketBurstAndRefill(t *testing.T) {
+	now := time.Now()
+	tb := NewTokenBucket(1, 3)
+	tb.now = func() time.Time { return now }
+
+	// Burst is available immediately
+	for i := 0; i < 3; i++ {
+		if !tb.Allow("a") {
+			t.Fatalf("Request %d within burst should be allowed", i+1)
+		}
+	}
+
+	if tb.Allow("a") {
+		t.Error("Request beyond burst should be rejected")
+	}
+
+	if retry := tb.RetryAfter("a"); retry <= 0 || retry > time.Second {
+		t.Errorf("RetryAfter should be within one refill period, got %v", retry)
+	}
+
+	// Other keys are independent
+	if !tb.Allow("b") {
+		t.Error("A different key should have its own bucket")
+	}
+
+	// One token refills after one second
+	now = now.Add(time.Second)
+	if !tb.Allow("a") {
+		t.Error("Request should be allowed after refill")
+	}
+	if tb.Allow("a") {
+		t.Error("Only one token should have been refilled")
+	}
+}
+
+func TestTokenBucketSweep(t *testing.T) {
+	now := time.Now()
+	tb := NewTokenBucket(10, 1)
+	tb.now = func() time.Time { return now }
+
+	tb.Allow("stale")
+	now = now.Add(time.Minute)
+
+	for i := 0; i < 1024; i++ {
+		tb.Allow("active")
+	}
+
+	tb.mu.Lock()
+	_, exists := tb.buckets["stale"]
+	tb.mu.Unlock()
+
+	if exists {
+		t.Error("Fully refilled buckets should be swept")
+	}
+}
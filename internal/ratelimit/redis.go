@@ -0,0 +1,206 @@
+package ratelimit
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultRedisTimeout bounds each Redis round trip unless configured
+const DefaultRedisTimeout = 500 * time.Millisecond
+
+// maxIdleRedisConns bounds the connections kept open between calls
+const maxIdleRedisConns = 8
+
+// slidingWindowScript admits an event if fewer than the limit happened in
+// the window before it. The server's clock is used so that every instance
+// agrees on the window. It returns 1 if admitted, or 0 and the
+// microseconds until the oldest event leaves the window.
+const slidingWindowScript = `
+local now = redis.call('TIME')
+local micros = tonumber(now[1]) * 1000000 + tonumber(now[2])
+local window = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', micros - window)
+if redis.call('ZCARD', KEYS[1]) < limit then
+  redis.call('ZADD', KEYS[1], micros, micros .. '-' .. ARGV[3])
+  redis.call('PEXPIRE', KEYS[1], math.ceil(window / 1000))
+  return {1, 0}
+end
+local oldest = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+return {0, tonumber(oldest[2]) + window - micros}
+`
+
+// slidingWindowSHA names the script for EVALSHA
+var slidingWindowSHA = func() string {
+	sum := sha1.Sum([]byte(slidingWindowScript))
+	return hex.EncodeToString(sum[:])
+}()
+
+// RedisConfig locates the Redis server shared by all instances
+type RedisConfig struct {
+	Address  string
+	Password string
+	Prefix   string        // Prepended to every key
+	Timeout  time.Duration // Per round trip; zero for DefaultRedisTimeout
+}
+
+// RedisWindow is a sliding window limiter whose state lives in Redis, so
+// instances sharing the server enforce one quota per key between them.
+// While Redis is unreachable, decisions fall back to a local limiter.
+type RedisWindow struct {
+	config   RedisConfig
+	limit    int
+	window   time.Duration
+	fallback Limiter
+
+	idle     chan *respConn
+	mu       sync.Mutex
+	retry    map[string]time.Duration // Hints from denied events, until read
+	degraded bool
+}
+
+// NewRedisWindow creates a limiter admitting at most limit events per key
+// in any window. fallback decides while Redis is unreachable; nil admits
+// everything then.
+func NewRedisWindow(config RedisConfig, limit int, window time.Duration, fallback Limiter) *RedisWindow {
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultRedisTimeout
+	}
+	return &RedisWindow{
+		config:   config,
+		limit:    limit,
+		window:   window,
+		fallback: fallback,
+		idle:     make(chan *respConn, maxIdleRedisConns),
+		retry:    make(map[string]time.Duration),
+	}
+}
+
+// Allow records an event for key if the window has room for it
+func (rw *RedisWindow) Allow(key string) bool {
+	allowed, retryAfter, err := rw.eval(key)
+	rw.setDegraded(err)
+	if err != nil {
+		if rw.fallback == nil {
+			return true
+		}
+		return rw.fallback.Allow(key)
+	}
+
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	if allowed {
+		delete(rw.retry, key)
+	} else {
+		rw.retry[key] = retryAfter
+	}
+	return allowed
+}
+
+// RetryAfter returns how long until key had room when it was last denied
+func (rw *RedisWindow) RetryAfter(key string) time.Duration {
+	rw.mu.Lock()
+	retryAfter, ok := rw.retry[key]
+	delete(rw.retry, key)
+	degraded := rw.degraded
+	rw.mu.Unlock()
+
+	if !ok && degraded {
+		if ra, ok := rw.fallback.(interface{ RetryAfter(string) time.Duration }); ok {
+			return ra.RetryAfter(key)
+		}
+	}
+	return retryAfter
+}
+
+// eval runs the window script for key
+func (rw *RedisWindow) eval(key string) (allowed bool, retryAfter time.Duration, err error) {
+	conn, err := rw.conn()
+	if err != nil {
+		return false, 0, err
+	}
+
+	member := make([]byte, 8)
+	rand.Read(member)
+	args := []string{
+		"1", rw.config.Prefix + key,
+		strconv.FormatInt(rw.window.Microseconds(), 10),
+		strconv.Itoa(rw.limit),
+		hex.EncodeToString(member),
+	}
+	reply, err := conn.do(append([]string{"EVALSHA", slidingWindowSHA}, args...)...)
+	if replyErr, ok := err.(respError); ok && strings.HasPrefix(string(replyErr), "NOSCRIPT") {
+		// The script is cached on first use; later calls send only its hash
+		reply, err = conn.do(append([]string{"EVAL", slidingWindowScript}, args...)...)
+	}
+	if err != nil {
+		if _, ok := err.(respError); ok {
+			rw.release(conn)
+		} else {
+			conn.Close()
+		}
+		return false, 0, err
+	}
+	rw.release(conn)
+
+	result, ok := reply.([]interface{})
+	if !ok || len(result) != 2 {
+		return false, 0, errRESPProtocol
+	}
+	admitted, ok1 := result[0].(int64)
+	micros, ok2 := result[1].(int64)
+	if !ok1 || !ok2 {
+		return false, 0, errRESPProtocol
+	}
+	return admitted == 1, time.Duration(micros) * time.Microsecond, nil
+}
+
+// conn takes an idle connection or dials a new one
+func (rw *RedisWindow) conn() (*respConn, error) {
+	select {
+	case conn := <-rw.idle:
+		return conn, nil
+	default:
+		return dialRESP(rw.config.Address, rw.config.Password, rw.config.Timeout)
+	}
+}
+
+// release keeps a healthy connection for reuse
+func (rw *RedisWindow) release(conn *respConn) {
+	select {
+	case rw.idle <- conn:
+	default:
+		conn.Close()
+	}
+}
+
+// setDegraded logs when Redis becomes unreachable and when it recovers
+func (rw *RedisWindow) setDegraded(err error) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	switch {
+	case err != nil && !rw.degraded:
+		log.Printf("Rate limiter: Redis at %s failed, limiting locally: %v", rw.config.Address, err)
+	case err == nil && rw.degraded:
+		log.Printf("Rate limiter: Redis at %s recovered", rw.config.Address)
+	}
+	rw.degraded = err != nil
+}
+
+// Close closes the idle connections
+func (rw *RedisWindow) Close() error {
+	for {
+		select {
+		case conn := <-rw.idle:
+			conn.Close()
+		default:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package ratelimit
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis speaks enough RESP to run the sliding window script, which it
+// emulates, and records the commands it receives
+type fakeRedis struct {
+	listener net.Listener
+	mu       sync.Mutex
+	scripts  map[string]bool
+	events   map[string][]time.Time
+	commands []string
+	now      time.Time
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	fr := &fakeRedis{
+		listener: listener,
+		scripts:  make(map[string]bool),
+		events:   make(map[string][]time.Time),
+		now:      time.Now(),
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go fr.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { listener.Close() })
+	return fr
+}
+
+func (fr *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		args := make([]string, n)
+		for i := range args {
+			header, _ := r.ReadString('\n')
+			size, _ := strconv.Atoi(strings.TrimSpace(header[1:]))
+			data := make([]byte, size+2)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return
+			}
+			args[i] = string(data[:size])
+		}
+		io.WriteString(conn, fr.handle(args))
+	}
+}
+
+func (fr *fakeRedis) handle(args []string) string {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	fr.commands = append(fr.commands, args[0])
+
+	switch args[0] {
+	case "AUTH":
+		if args[1] != "secret" {
+			return "-WRONGPASS invalid password\r\n"
+		}
+		return "+OK\r\n"
+	case "EVALSHA":
+		if !fr.scripts[args[1]] {
+			return "-NOSCRIPT No matching script\r\n"
+		}
+	case "EVAL":
+		fr.scripts[slidingWindowSHA] = args[1] == slidingWindowScript
+	default:
+		return "-ERR unknown command\r\n"
+	}
+
+	// KEYS[1], then window in microseconds, limit and a unique member
+	key := args[3]
+	window := time.Duration(mustAtoi(args[4])) * time.Microsecond
+	limit := mustAtoi(args[5])
+	var kept []time.Time
+	for _, at := range fr.events[key] {
+		if fr.now.Sub(at) < window {
+			kept = append(kept, at)
+		}
+	}
+	if len(kept) < limit {
+		fr.events[key] = append(kept, fr.now)
+		return "*2\r\n:1\r\n:0\r\n"
+	}
+	fr.events[key] = kept
+	return fmt.Sprintf("*2\r\n:0\r\n:%d\r\n", (kept[0].Add(window).Sub(fr.now)).Microseconds())
+}
+
+func mustAtoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+func TestRedisWindow(t *testing.T) {
+	fr := newFakeRedis(t)
+	config := RedisConfig{Address: fr.listener.Addr().String(), Password: "secret", Prefix: "test:"}
+	rw := NewRedisWindow(config, 3, time.Minute, nil)
+	defer rw.Close()
+
+	for i := 0; i < 3; i++ {
+		if !rw.Allow("a") {
+			t.Fatalf("Event %d within the limit was denied", i+1)
+		}
+	}
+	if rw.Allow("a") {
+		t.Fatal("Event beyond the limit was allowed")
+	}
+	if retry := rw.RetryAfter("a"); retry != time.Minute {
+		t.Errorf("Expected to retry after the window, got %v", retry)
+	}
+	if !rw.Allow("b") {
+		t.Error("A different key should have its own window")
+	}
+
+	// A second instance shares the quota
+	other := NewRedisWindow(config, 3, time.Minute, nil)
+	defer other.Close()
+	if other.Allow("a") {
+		t.Error("The quota should hold across instances")
+	}
+
+	// The window slides
+	fr.mu.Lock()
+	fr.now = fr.now.Add(time.Minute)
+	fr.mu.Unlock()
+	if !rw.Allow("a") {
+		t.Error("Events should be allowed once the window has passed")
+	}
+
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	if fr.commands[0] != "AUTH" {
+		t.Errorf("Expected to authenticate first, got %v", fr.commands)
+	}
+	if strings.Count(strings.Join(fr.commands, " "), "EVAL ") > 2 {
+		t.Errorf("The script should be sent once per connection at most, got %v", fr.commands)
+	}
+	if _, ok := fr.events["test:a"]; !ok {
+		t.Error("Expected keys to carry the prefix")
+	}
+}
+
+func TestRedisWindowFallback(t *testing.T) {
+	// Nothing listens here once the listener is closed
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	address := listener.Addr().String()
+	listener.Close()
+
+	fallback := NewTokenBucket(0, 1)
+	rw := NewRedisWindow(RedisConfig{Address: address, Timeout: 100 * time.Millisecond}, 10, time.Minute, fallback)
+	if !rw.Allow("a") {
+		t.Error("The fallback's burst should be allowed")
+	}
+	if rw.Allow("a") {
+		t.Error("Expected the fallback to limit while Redis is unreachable")
+	}
+
+	open := NewRedisWindow(RedisConfig{Address: address, Timeout: 100 * time.Millisecond}, 10, time.Minute, nil)
+	if !open.Allow("a") {
+		t.Error("Without a fallback, events should be allowed while Redis is unreachable")
+	}
+}
@@ -0,0 +1,132 @@
+package ratelimit
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxRESPBulkLength bounds a bulk string read from the server; replies to
+// the limiter's commands are short
+const maxRESPBulkLength = 1 << 20
+
+// errRESPProtocol is returned for a reply that is not valid RESP
+var errRESPProtocol = errors.New("redis: protocol error")
+
+// respError is an error reply from the server
+type respError string
+
+func (e respError) Error() string { return "redis: " + string(e) }
+
+// respConn is a connection speaking the Redis serialization protocol, just
+// enough of it for commands with string arguments
+type respConn struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	timeout time.Duration
+}
+
+// dialRESP connects to a Redis server and authenticates if a password is set
+func dialRESP(address, password string, timeout time.Duration) (*respConn, error) {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	rc := &respConn{conn: conn, reader: bufio.NewReader(conn), timeout: timeout}
+	if password != "" {
+		if _, err := rc.do("AUTH", password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return rc, nil
+}
+
+// do sends a command and reads its reply: a string, an int64, nil, a
+// []interface{} of those, or a respError
+func (rc *respConn) do(args ...string) (interface{}, error) {
+	rc.conn.SetDeadline(time.Now().Add(rc.timeout))
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(rc.conn, b.String()); err != nil {
+		return nil, err
+	}
+
+	reply, err := rc.readReply()
+	if err != nil {
+		return nil, err
+	}
+	if replyErr, ok := reply.(respError); ok {
+		return nil, replyErr
+	}
+	return reply, nil
+}
+
+// readReply reads one reply. Error replies are returned as values so that
+// arrays holding them still parse.
+func (rc *respConn) readReply() (interface{}, error) {
+	line, err := rc.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errRESPProtocol
+	}
+	kind, body := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return respError(body), nil
+	case ':':
+		n, err := strconv.ParseInt(body, 10, 64)
+		if err != nil {
+			return nil, errRESPProtocol
+		}
+		return n, nil
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < -1 || n > maxRESPBulkLength {
+			return nil, errRESPProtocol
+		}
+		if n == -1 {
+			return nil, nil
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(rc.reader, data); err != nil {
+			return nil, err
+		}
+		return string(data[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < -1 || n > maxRESPBulkLength {
+			return nil, errRESPProtocol
+		}
+		if n == -1 {
+			return nil, nil
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = rc.readReply(); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, errRESPProtocol
+}
+
+// Close closes the connection
+func (rc *respConn) Close() error {
+	return rc.conn.Close()
+}
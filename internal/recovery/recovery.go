@@ -0,0 +1,269 @@
+// Package recovery checks the persistent stores when the server starts. It
+// looks for records that would stop a store from loading, records that
+// load but cannot be right, and disagreements between stores, and collects
+// what it finds in a report. Optionally, broken records are quarantined:
+// moved aside, where an operator can inspect them, so the server starts
+// with the rest.
+package recovery
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/yourusername/secure-messaging-poc/internal/binmanager"
+	"github.com/yourusername/secure-messaging-poc/internal/certmanager"
+	"github.com/yourusername/secure-messaging-poc/internal/keystore"
+)
+
+// Stores named in a report
+const (
+	StoreRegistry = "registry"
+	StoreKeyStore = "keystore"
+	StoreBins     = "bins"
+	StoreCross    = "cross-check"
+)
+
+// Problem is one finding of a check
+type Problem struct {
+	Store  string `json:"store"`
+	Record string `json:"record"` // Line, key or certificate ID
+	Issue  string `json:"issue"`
+	Detail string `json:"detail,omitempty"`
+	// Corrupt records stop their store from loading unless quarantined
+	Corrupt     bool `json:"corrupt"`
+	Quarantined bool `json:"quarantined"`
+}
+
+// Report collects the findings of a startup check
+type Report struct {
+	Started       time.Time      `json:"started"`
+	Finished      time.Time      `json:"finished"`
+	Checked       map[string]int `json:"checked"` // Records checked per store
+	Problems      []Problem      `json:"problems"`
+	QuarantineDir string         `json:"quarantine_dir,omitempty"`
+}
+
+// Failed reports whether a corrupt record was left in place, so a store
+// will not load
+func (r Report) Failed() bool {
+	for _, problem := range r.Problems {
+		if problem.Corrupt && !problem.Quarantined {
+			return true
+		}
+	}
+	return false
+}
+
+// Quarantined returns the number of records moved aside
+func (r Report) Quarantined() int {
+	count := 0
+	for _, problem := range r.Problems {
+		if problem.Quarantined {
+			count++
+		}
+	}
+	return count
+}
+
+// WriteFile writes the report as indented JSON
+func (r Report) WriteFile(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0600)
+}
+
+// Checker runs the checks and builds the report. Checks of files run before
+// the stores are opened, so they can quarantine records the loaders would
+// refuse; the other checks run on open stores.
+type Checker struct {
+	quarantineDir string // Quarantines if set
+	report        Report
+}
+
+// NewChecker creates a checker. Broken records are quarantined into
+// quarantineDir; an empty quarantineDir only reports them.
+func NewChecker(quarantineDir string) *Checker {
+	return &Checker{
+		quarantineDir: quarantineDir,
+		report: Report{
+			Started:       time.Now().UTC(),
+			Checked:       make(map[string]int),
+			Problems:      make([]Problem, 0),
+			QuarantineDir: quarantineDir,
+		},
+	}
+}
+
+// Report finishes and returns the report
+func (c *Checker) Report() Report {
+	c.report.Finished = time.Now().UTC()
+	return c.report
+}
+
+// CheckRegistryFile checks the issuance registry's file: every line must be
+// a record with a serial and certificate ID, serials must be unique and
+// sequence numbers must follow the line order. Undecodable and incomplete
+// records are quarantined. Errors are only returned for I/O failures.
+func (c *Checker) CheckRegistryFile(path string) error {
+	serials := make(map[string]bool)
+	var seq uint64
+	return c.checkLines(path, StoreRegistry, func(data []byte) (Problem, bool) {
+		var record certmanager.IssuedCertificate
+		if err := json.Unmarshal(data, &record); err != nil {
+			return Problem{Issue: "undecodable", Detail: err.Error(), Corrupt: true}, true
+		}
+		switch {
+		case record.Serial == "" || record.CertificateID == "":
+			return Problem{Issue: "incomplete", Detail: "missing serial or certificate ID"}, true
+		case serials[record.Serial]:
+			return Problem{Issue: "duplicate", Detail: "serial " + record.Serial}, true
+		}
+		serials[record.Serial] = true
+		seq++
+		if record.Seq != seq {
+			// Kept: sequence numbers are informational
+			c.add(Problem{Store: StoreRegistry, Record: record.Serial, Issue: "out of sequence",
+				Detail: fmt.Sprintf("seq %d at position %d", record.Seq, seq)})
+		}
+		return Problem{}, false
+	})
+}
+
+// CheckKeyStoreFile checks the key store's file: every line must be an
+// envelope the store can read, holding a key that passes
+// keystore.CheckRecord. Failing records are quarantined. Errors are only
+// returned for I/O failures.
+func (c *Checker) CheckKeyStoreFile(path string) error {
+	return c.checkLines(path, StoreKeyStore, func(data []byte) (Problem, bool) {
+		keyData, _, err := keystore.UnmarshalEnvelope(data)
+		if err != nil {
+			return Problem{Issue: "undecodable", Detail: err.Error(), Corrupt: true}, true
+		}
+		if err := keystore.CheckRecord(keyData); err != nil {
+			return Problem{Issue: "integrity", Detail: fmt.Sprintf("%s/%s: %v", keyData.CertID, keyData.Slot, err)}, true
+		}
+		return Problem{}, false
+	})
+}
+
+// CheckBinStore checks the messages of a LevelDB bin store against the
+// current bin mask
+func (c *Checker) CheckBinStore(store *binmanager.LevelDBStore, mask uint64) error {
+	checked, problems, err := store.Check(mask, c.quarantineDir != "")
+	c.report.Checked[StoreBins] += checked
+	for _, problem := range problems {
+		c.add(Problem{
+			Store:       StoreBins,
+			Record:      problem.Key,
+			Issue:       problem.Issue,
+			Detail:      problem.Detail,
+			Quarantined: problem.Quarantined,
+		})
+	}
+	return err
+}
+
+// CrossCheck compares the loaded stores: every referrer, stored key and
+// revocation should belong to a certificate the registry recorded. Findings
+// are only reported; certificates issued before the registry existed
+// explain some of them.
+func (c *Checker) CrossCheck(registry *certmanager.IssuanceRegistry, keys *keystore.EncryptedKeyStore, revocations *certmanager.RevocationManager) {
+	known := func(certID string) bool {
+		_, ok := registry.LookupID(certID)
+		return ok
+	}
+
+	referrers := make(map[string]bool)
+	for _, record := range registry.Since(0, 0) {
+		c.report.Checked[StoreCross]++
+		if record.ReferrerID != "" && !referrers[record.ReferrerID] && !known(record.ReferrerID) {
+			referrers[record.ReferrerID] = true
+			c.add(Problem{Store: StoreCross, Record: record.ReferrerID, Issue: "unknown referrer",
+				Detail: "referred " + record.CertificateID})
+		}
+	}
+	for _, certID := range keys.ListKeys() {
+		c.report.Checked[StoreCross]++
+		if !known(certID) {
+			c.add(Problem{Store: StoreCross, Record: certID, Issue: "orphaned keys", Detail: "no issued certificate"})
+		}
+	}
+	for certID := range revocations.GetRevokedCertificates() {
+		c.report.Checked[StoreCross]++
+		if !known(certID) {
+			c.add(Problem{Store: StoreCross, Record: certID, Issue: "unknown revocation", Detail: "no issued certificate"})
+		}
+	}
+}
+
+// add records a problem
+func (c *Checker) add(problem Problem) {
+	c.report.Problems = append(c.report.Problems, problem)
+}
+
+// checkLines runs check on every line of a JSON lines file. Lines check
+// reports as broken are recorded and, when quarantining, moved to a file in
+// the quarantine directory while the rest of the file is rewritten without
+// them. A missing file has nothing to check.
+func (c *Checker) checkLines(path, store string, check func(data []byte) (Problem, bool)) error {
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var kept, quarantined bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		record := bytes.TrimSpace(scanner.Bytes())
+		if len(record) == 0 {
+			continue
+		}
+		c.report.Checked[store]++
+		problem, broken := check(record)
+		if !broken {
+			kept.Write(record)
+			kept.WriteByte('\n')
+			continue
+		}
+		problem.Store = store
+		problem.Record = "line " + strconv.Itoa(line)
+		problem.Quarantined = c.quarantineDir != ""
+		c.add(problem)
+		quarantined.Write(record)
+		quarantined.WriteByte('\n')
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	if c.quarantineDir == "" || quarantined.Len() == 0 {
+		return nil
+	}
+	if err := os.MkdirAll(c.quarantineDir, 0700); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s.%s.quarantine", filepath.Base(path), c.report.Started.Format("20060102T150405Z"))
+	if err := os.WriteFile(filepath.Join(c.quarantineDir, name), quarantined.Bytes(), 0600); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, kept.Bytes(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,154 @@
+package recovery
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yourusername/secure-messaging-poc/internal/binmanager"
+	"github.com/yourusername/secure-messaging-poc/internal/certmanager"
+	"github.com/yourusername/secure-messaging-poc/internal/keystore"
+)
+
+// registryLines is a registry file with a broken second record
+const registryLines = `{"seq":1,"serial":"0A","certificate_id":"root"}
+{"seq":2,"serial":
+{"seq":3,"serial":"0B","certificate_id":"child","referrer_id":"root"}
+`
+
+func TestCheckRegistryFileReportsOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "issued.jsonl")
+	if err := os.WriteFile(path, []byte(registryLines), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	checker := NewChecker("")
+	if err := checker.CheckRegistryFile(path); err != nil {
+		t.Fatalf("CheckRegistryFile failed: %v", err)
+	}
+	report := checker.Report()
+	if !report.Failed() || report.Quarantined() != 0 {
+		t.Errorf("Expected a failed report without quarantine, got %+v", report)
+	}
+	if report.Checked[StoreRegistry] != 3 {
+		t.Errorf("Expected 3 records checked, got %d", report.Checked[StoreRegistry])
+	}
+
+	data, _ := os.ReadFile(path)
+	if string(data) != registryLines {
+		t.Error("Registry file changed without quarantine")
+	}
+}
+
+func TestCheckRegistryFileQuarantines(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "issued.jsonl")
+	if err := os.WriteFile(path, []byte(registryLines), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	checker := NewChecker(filepath.Join(dir, "quarantine"))
+	if err := checker.CheckRegistryFile(path); err != nil {
+		t.Fatalf("CheckRegistryFile failed: %v", err)
+	}
+	report := checker.Report()
+	if report.Failed() || report.Quarantined() != 1 {
+		t.Errorf("Expected one quarantined record, got %+v", report)
+	}
+
+	// The registry loads with what is left
+	registry, err := certmanager.NewIssuanceRegistry(path)
+	if err != nil {
+		t.Fatalf("Registry does not load after quarantine: %v", err)
+	}
+	if _, ok := registry.LookupID("child"); !ok {
+		t.Error("Intact record lost")
+	}
+	files, _ := os.ReadDir(filepath.Join(dir, "quarantine"))
+	if len(files) != 1 {
+		t.Fatalf("Expected one quarantine file, got %d", len(files))
+	}
+	moved, _ := os.ReadFile(filepath.Join(dir, "quarantine", files[0].Name()))
+	if !strings.HasPrefix(string(moved), `{"seq":2`) {
+		t.Errorf("Unexpected quarantined record %q", moved)
+	}
+}
+
+func TestCheckKeyStoreFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "keys.jsonl")
+	good, err := keystore.MarshalEnvelope(keystore.EncryptedKeyData{
+		CertID: "root", Slot: "default", Version: 1, Format: keystore.DefaultFormat,
+		EncryptedKey: make([]byte, 48), IV: make([]byte, 12), HMAC: make([]byte, 32),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	short, err := keystore.MarshalEnvelope(keystore.EncryptedKeyData{
+		CertID: "other", Slot: "default", Version: 1, Format: keystore.DefaultFormat,
+		EncryptedKey: make([]byte, 48), IV: make([]byte, 12), HMAC: make([]byte, 4),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := string(good) + "\n" + string(short) + "\nnot json\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	checker := NewChecker(filepath.Join(dir, "quarantine"))
+	if err := checker.CheckKeyStoreFile(path); err != nil {
+		t.Fatalf("CheckKeyStoreFile failed: %v", err)
+	}
+	report := checker.Report()
+	if report.Quarantined() != 2 || report.Failed() {
+		t.Fatalf("Expected two quarantined records, got %+v", report.Problems)
+	}
+
+	keys, err := keystore.OpenEncryptedKeyStore(path)
+	if err != nil {
+		t.Fatalf("Key store does not load after quarantine: %v", err)
+	}
+	if ids := keys.ListKeys(); len(ids) != 1 || ids[0] != "root" {
+		t.Errorf("Expected only the intact key, got %v", ids)
+	}
+}
+
+func TestCheckBinStoreAndCrossCheck(t *testing.T) {
+	dir := t.TempDir()
+	store, err := binmanager.OpenLevelDBStore(filepath.Join(dir, "bins"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	// A bin left behind by a wider mask
+	store.ForBin(0x1001).AppendMessage(&binmanager.Message{BinID: 0x1001, MessageID: "old", Timestamp: time.Now()})
+
+	registry, err := certmanager.NewIssuanceRegistry("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := keystore.NewEncryptedKeyStore()
+	keys.StoreKey("stranger", make([]byte, 48), make([]byte, 12), make([]byte, 32))
+	revocations := certmanager.NewRevocationManager()
+	revocations.Revoke("ghost")
+
+	checker := NewChecker("")
+	if err := checker.CheckBinStore(store, 0xFFFFFFFFFFFFF000); err != nil {
+		t.Fatalf("CheckBinStore failed: %v", err)
+	}
+	checker.CrossCheck(registry, keys, revocations)
+
+	issues := make(map[string]string)
+	for _, problem := range checker.Report().Problems {
+		issues[problem.Issue] = problem.Record
+	}
+	if issues[binmanager.IssueOrphaned] == "" {
+		t.Error("Orphaned bin not reported")
+	}
+	if issues["orphaned keys"] != "stranger" || issues["unknown revocation"] != "ghost" {
+		t.Errorf("Cross-check findings missing: %v", issues)
+	}
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"crypto/x509"
+	"net/http"
+	"strings"
+
+	"github.com/yourusername/secure-messaging-poc/internal/certmanager"
+)
+
+// Client certificate requirements of an AuthRule
+const (
+	// CertRequired refuses requests without a client certificate
+	CertRequired = "required"
+	// CertOptional serves requests with or without a certificate; one that
+	// is presented must hold the rule's roles
+	CertOptional = "optional"
+	// CertNone serves every request; certificates are left to the handler
+	CertNone = "none"
+)
+
+// RoleAdmin is held by certificates allowed to use the admin API. The other
+// roles a rule may require are those of certmanager.CertificateRoles.
+const RoleAdmin = "admin"
+
+// AuthRule sets the authentication required for the endpoints under Path,
+// either an endpoint pattern such as "/health" or a prefix ending in "/"
+// such as "/api/". The rule with the longest matching path applies, and
+// endpoints no rule matches only enforce what their handlers check. Rules
+// that serve clients without a certificate need the listener's client_auth
+// to be verify_if_given; no other mode that admits them verifies the
+// certificates that are presented.
+type AuthRule struct {
+	Path       string
+	ClientCert string   // CertRequired, CertOptional or CertNone
+	Roles      []string // Every role is required; only with a certificate
+}
+
+// WithAuthRules enforces rules on the HTTP endpoints and the WebSocket
+// upgrade. An exact rule for /metrics serves the metrics under that rule
+// instead of only to admins.
+func WithAuthRules(rules []AuthRule) Option {
+	return func(s *Server) {
+		s.authRules = append(s.authRules, rules...)
+	}
+}
+
+// authRuleFor returns the rule covering pattern, if any
+func (s *Server) authRuleFor(pattern string) (AuthRule, bool) {
+	var best AuthRule
+	found := false
+	for _, rule := range s.authRules {
+		matches := rule.Path == pattern || (strings.HasSuffix(rule.Path, "/") && strings.HasPrefix(pattern, rule.Path))
+		if matches && (!found || len(rule.Path) > len(best.Path)) {
+			best, found = rule, true
+		}
+	}
+	return best, found
+}
+
+// requireAuth enforces the rule covering pattern before next, or returns
+// next unchanged if there is none
+func (s *Server) requireAuth(pattern string, next http.HandlerFunc) http.HandlerFunc {
+	rule, ok := s.authRuleFor(pattern)
+	if !ok || rule.ClientCert == CertNone {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+			if rule.ClientCert == CertOptional {
+				next(w, r)
+				return
+			}
+			http.Error(w, "Client certificate required", http.StatusUnauthorized)
+			return
+		}
+
+		cert := r.TLS.PeerCertificates[0]
+		if s.revocationMgr.IsRevoked(s.certificateID(cert)) {
+			http.Error(w, "Certificate revoked", http.StatusForbidden)
+			return
+		}
+		for _, role := range rule.Roles {
+			if !s.holdsRole(cert, role) {
+				http.Error(w, "Certificate lacks role "+role, http.StatusForbidden)
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+// holdsRole reports whether cert holds role
+func (s *Server) holdsRole(cert *x509.Certificate, role string) bool {
+	if role == RoleAdmin {
+		return s.adminDenial(cert) == ""
+	}
+	for _, held := range certmanager.CertificateRoles(cert) {
+		if held == role {
+			return true
+		}
+	}
+	return false
+}
+
+// metricsRuled reports whether an auth rule names /metrics exactly, so the
+// metrics are served under it rather than to admins only. Prefix rules do
+// not count: a rule for "/" must not expose them by accident.
+func (s *Server) metricsRuled() bool {
+	rule, ok := s.authRuleFor("/metrics")
+	return ok && rule.Path == "/metrics"
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/yourusername/secure-messaging-poc/internal/certmanager"
+)
+
+// handleAcmeInvite lets an enrolled client mint a single-use invite for a service account
+func (s *Server) handleAcmeInvite(w http.ResponseWriter, r *http.Request) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		http.Error(w, "Client certificate required", http.StatusUnauthorized)
+		return
+	}
+
+	referrerID := s.certificateID(r.TLS.PeerCertificates[0])
+	if s.revocationMgr.IsRevoked(referrerID) {
+		http.Error(w, "Referrer certificate is revoked", http.StatusForbidden)
+		return
+	}
+
+	invite, err := s.enrollmentMgr.CreateInvite(referrerID)
+	if err != nil {
+		httpError(w, err, "Failed to create invite")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"invite":  invite.Code,
+		"expires": invite.Expires.Format(time.RFC3339),
+	})
+}
+
+// handleAcmeNewOrder opens an enrollment order bound to the presented
+// certificate, or to an invite code when the client has no certificate yet
+func (s *Server) handleAcmeNewOrder(w http.ResponseWriter, r *http.Request) {
+	var orderRequest struct {
+		Invite string `json:"invite"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&orderRequest); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var (
+		order *certmanager.Order
+		err   error
+	)
+
+	switch {
+	case orderRequest.Invite != "":
+		order, err = s.enrollmentMgr.NewOrderForInvite(orderRequest.Invite)
+	case r.TLS != nil && len(r.TLS.PeerCertificates) > 0:
+		cert := r.TLS.PeerCertificates[0]
+		if s.revocationMgr.IsRevoked(s.certificateID(cert)) {
+			http.Error(w, "Certificate is revoked", http.StatusForbidden)
+			return
+		}
+		order, err = s.enrollmentMgr.NewOrderForCertificate(cert)
+	default:
+		http.Error(w, "Client certificate or invite required", http.StatusUnauthorized)
+		return
+	}
+
+	if err != nil {
+		httpError(w, err, "Failed to create order")
+		return
+	}
+
+	writeOrder(w, http.StatusCreated, order)
+}
+
+// handleAcmeChallenge accepts the signed challenge token for a pending order
+func (s *Server) handleAcmeChallenge(w http.ResponseWriter, r *http.Request) {
+	var challengeRequest struct {
+		OrderID   string `json:"order_id"`
+		Signature []byte `json:"signature"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&challengeRequest); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	order, err := s.enrollmentMgr.RespondToChallenge(challengeRequest.OrderID, challengeRequest.Signature)
+	if err != nil {
+		httpError(w, err, "Failed to verify challenge")
+		return
+	}
+
+	writeOrder(w, http.StatusOK, order)
+}
+
+// handleAcmeFinalize signs the CSR of a ready order and returns the new certificate
+func (s *Server) handleAcmeFinalize(w http.ResponseWriter, r *http.Request) {
+	var finalizeRequest struct {
+		OrderID string `json:"order_id"`
+		CSR     []byte `json:"csr"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&finalizeRequest); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	csr, err := x509.ParseCertificateRequest(finalizeRequest.CSR)
+	if err != nil {
+		http.Error(w, "Invalid CSR: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	cert, referrerID, err := s.enrollmentMgr.Finalize(finalizeRequest.OrderID, csr)
+	if err != nil {
+		httpError(w, err, "Failed to sign CSR")
+		return
+	}
+
+	s.issued.Inc()
+
+	certID := s.certificateID(cert)
+	s.revocationMgr.RegisterCertificateUntil(certID, referrerID, cert.NotAfter)
+	log.Printf("Automated enrollment issued certificate %s", certID)
+
+	certPEM, err := certmanager.EncodeCertificatePEM(cert)
+	if err != nil {
+		http.Error(w, "Failed to encode certificate", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"order_id":       finalizeRequest.OrderID,
+		"status":         certmanager.OrderStatusValid,
+		"certificate":    string(certPEM),
+		"certificate_id": certID,
+		"not_after":      cert.NotAfter.Format(time.RFC3339),
+	})
+}
+
+// writeOrder writes an order as JSON
+func writeOrder(w http.ResponseWriter, status int, order *certmanager.Order) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(order)
+}
@@ -0,0 +1,533 @@
+package server
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yourusername/secure-messaging-poc/internal/binmanager"
+	"github.com/yourusername/secure-messaging-poc/internal/certmanager"
+	"github.com/yourusername/secure-messaging-poc/internal/metrics"
+	"github.com/yourusername/secure-messaging-poc/internal/recovery"
+)
+
+// maxGraphDocumentSize bounds imported referral graph documents
+const maxGraphDocumentSize = 64 << 20
+
+// maxRevocationListSize bounds batch revocation lists
+const maxRevocationListSize = 4 << 20
+
+// maxCertificateSize bounds PEM certificates submitted as trust anchors
+const maxCertificateSize = 64 << 10
+
+// defaultStatsBins is the number of largest bins reported by the stats endpoint
+const defaultStatsBins = 20
+
+// Defaults for the stats history endpoint
+const (
+	defaultHistoryRange  = time.Hour
+	defaultHistoryPoints = 120
+	maxHistoryPoints     = 2000
+)
+
+// binUsage is the retained usage of one bin in the stats response
+type binUsage struct {
+	BinID uint64 `json:"bin_id"`
+	binmanager.Usage
+}
+
+// WithAdmins enables the admin API for the given certificate IDs
+func WithAdmins(certIDs []string) Option {
+	return func(s *Server) {
+		s.adminIDs = make(map[string]bool, len(certIDs))
+		for _, certID := range certIDs {
+			s.adminIDs[certID] = true
+		}
+	}
+}
+
+// WithGraphIssuers trusts referral graphs signed by other instances' CAs in
+// addition to this server's own CA
+func WithGraphIssuers(issuers []*x509.Certificate) Option {
+	return func(s *Server) {
+		s.graphIssuers = append(s.graphIssuers, issuers...)
+	}
+}
+
+// WithRetentionController exposes the adaptive retention state on the admin API
+func WithRetentionController(rc *binmanager.RetentionController) Option {
+	return func(s *Server) {
+		s.retentionCtl = rc
+	}
+}
+
+// WithMaskController exposes the bin mask autoscaling state on the admin API
+func WithMaskController(mc *binmanager.MaskController) Option {
+	return func(s *Server) {
+		s.maskCtl = mc
+	}
+}
+
+// WithCompactor lets admins see and trigger bin store compaction
+func WithCompactor(c *binmanager.Compactor) Option {
+	return func(s *Server) {
+		s.compactor = c
+	}
+}
+
+// WithRecoveryReport serves the startup consistency check's report to admins
+func WithRecoveryReport(report recovery.Report) Option {
+	return func(s *Server) {
+		s.recoveryReport = &report
+	}
+}
+
+// WithMetrics serves the registry in the Prometheus format to admins
+func WithMetrics(registry *metrics.Registry) Option {
+	return func(s *Server) {
+		s.metrics = registry
+	}
+}
+
+// WithStatsHistory serves recorded metric history to admins
+func WithStatsHistory(h *metrics.History) Option {
+	return func(s *Server) {
+		s.statsHistory = h
+	}
+}
+
+// WithTrustStore lets admins manage the CAs trusted for client certificates
+func WithTrustStore(ts *certmanager.TrustStore) Option {
+	return func(s *Server) {
+		s.trustStore = ts
+	}
+}
+
+// WithFingerprintList lets admins block or allow certificate keys by SPKI
+// fingerprint, independently of the CA and referral tree
+func WithFingerprintList(fl *certmanager.FingerprintList) Option {
+	return func(s *Server) {
+		s.fingerprints = fl
+	}
+}
+
+// WithPinnedAdmins additionally requires admin certificates to be on the
+// fingerprint allowlist
+func WithPinnedAdmins() Option {
+	return func(s *Server) {
+		s.pinnedAdmins = true
+	}
+}
+
+// requireAdmin allows a request only from a configured admin certificate
+func (s *Server) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+			http.Error(w, "Client certificate required", http.StatusUnauthorized)
+			return
+		}
+
+		if denial := s.adminDenial(r.TLS.PeerCertificates[0]); denial != "" {
+			http.Error(w, denial, http.StatusForbidden)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// adminDenial returns why cert may not use the admin API, or "" if it may
+func (s *Server) adminDenial(cert *x509.Certificate) string {
+	certID := s.certificateID(cert)
+	if !s.adminIDs[certID] || s.revocationMgr.IsRevoked(certID) {
+		return "Admin certificate required"
+	}
+	if s.fingerprints != nil && s.fingerprints.Check(cert) != nil {
+		return "Admin certificate required"
+	}
+	if s.pinnedAdmins && (s.fingerprints == nil || !s.fingerprints.IsAllowed(cert)) {
+		return "Pinned admin certificate required"
+	}
+	return ""
+}
+
+// handleAdminGraphExport returns the referral graph and revocation set as a
+// document signed by this server's CA
+func (s *Server) handleAdminGraphExport(w http.ResponseWriter, r *http.Request) {
+	format := graphFormat(r.URL.Query().Get("format"), r.Header.Get("Accept"))
+
+	doc := s.revocationMgr.ExportGraph()
+	if err := s.certAuthority.SignGraph(doc); err != nil {
+		http.Error(w, "Failed to sign graph: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data, err := certmanager.MarshalGraph(doc, format)
+	if err != nil {
+		http.Error(w, "Failed to encode graph", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", graphContentType(format))
+	w.Write(data)
+}
+
+// handleAdminGraphImport verifies a signed referral graph and merges it into
+// the local one. Repeating an import is harmless.
+func (s *Server) handleAdminGraphImport(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading request", http.StatusBadRequest)
+		return
+	}
+
+	doc, err := certmanager.UnmarshalGraph(body, graphFormat("", r.Header.Get("Content-Type")))
+	if err != nil {
+		http.Error(w, "Invalid graph document: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	issuers := s.graphIssuers
+	if caCert, err := s.certAuthority.GetCACertificate(); err == nil {
+		issuers = append([]*x509.Certificate{caCert}, issuers...)
+	}
+
+	if err := certmanager.VerifyGraph(doc, issuers); err != nil {
+		httpError(w, err, "Failed to verify graph")
+		return
+	}
+
+	referrals, revocations := s.revocationMgr.MergeGraph(doc)
+	log.Printf("Imported referral graph from issuer %s: %d new referrals, %d new revocations",
+		doc.IssuerID, referrals, revocations)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":            "success",
+		"issuer_id":         doc.IssuerID,
+		"referrals_added":   referrals,
+		"revocations_added": revocations,
+	})
+}
+
+// handleAdminRevocations revokes a list of certificates in one step, for
+// incident response. Unlike revoking with children, only the listed
+// certificates are revoked. The list is JSON, or CSV when sent as text/csv.
+func (s *Server) handleAdminRevocations(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading request", http.StatusBadRequest)
+		return
+	}
+
+	format := certmanager.RevocationListJSON
+	if strings.Contains(r.Header.Get("Content-Type"), "text/csv") {
+		format = certmanager.RevocationListCSV
+	}
+	requests, err := certmanager.ParseRevocationList(body, format)
+	if err != nil {
+		httpError(w, err, "Failed to parse revocation list")
+		return
+	}
+
+	revoked, err := s.revocationMgr.RevokeBatch(requests)
+	if err != nil {
+		httpError(w, err, "Failed to revoke certificates")
+		return
+	}
+	log.Printf("Batch revocation by admin: %d listed, %d newly revoked", len(requests), revoked)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":    "success",
+		"listed":    len(requests),
+		"revoked":   revoked,
+		"timestamp": time.Now().Format(time.RFC3339),
+	})
+}
+
+// handleAdminRetention reports the effective retention and recent
+// adjustments made under storage pressure
+func (s *Server) handleAdminRetention(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.retentionCtl.Status())
+}
+
+// handleAdminAutoscale reports the bin mask, the load of the busiest bin
+// and recent mask changes made by the autoscaler
+func (s *Server) handleAdminAutoscale(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.maskCtl.Status())
+}
+
+// handleAdminRecovery returns the report of the startup consistency check
+func (s *Server) handleAdminRecovery(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.recoveryReport)
+}
+
+// handleAdminCompaction reports the bin store's disk usage and last
+// compaction on GET. POST starts a compaction pass in the background, as
+// one may outlast the request timeout, and answers 409 while one runs.
+func (s *Server) handleAdminCompaction(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		if !s.compactor.Trigger() {
+			http.Error(w, "Compaction already running", http.StatusConflict)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(s.compactor.Status())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.compactor.Status())
+}
+
+// handleAdminStats reports retained messages and bytes in total and for the
+// largest bins. The number of bins is set by the limit parameter.
+func (s *Server) handleAdminStats(w http.ResponseWriter, r *http.Request) {
+	limit := defaultStatsBins
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	perBin := s.binManager.BinUsage()
+	bins := make([]binUsage, 0, len(perBin))
+	for binID, usage := range perBin {
+		bins = append(bins, binUsage{BinID: binID, Usage: usage})
+	}
+	sort.Slice(bins, func(i, j int) bool {
+		return bins[i].Bytes > bins[j].Bytes
+	})
+	if len(bins) > limit {
+		bins = bins[:limit]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"retained":  s.binManager.Usage(),
+		"bin_count": len(perBin),
+		"bins":      bins,
+	})
+}
+
+// handleAdminStatsHistory returns recorded series for a dashboard. The series
+// parameter selects comma-separated metrics (all by default), range how far
+// back to look and points the maximum number of averaged points per series.
+func (s *Server) handleAdminStatsHistory(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	names := s.statsHistory.Names()
+	if value := query.Get("series"); value != "" {
+		names = strings.Split(value, ",")
+	}
+
+	lookback := defaultHistoryRange
+	if value := query.Get("range"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "Invalid range", http.StatusBadRequest)
+			return
+		}
+		lookback = parsed
+	}
+
+	points := defaultHistoryPoints
+	if value := query.Get("points"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 || parsed > maxHistoryPoints {
+			http.Error(w, "Invalid points", http.StatusBadRequest)
+			return
+		}
+		points = parsed
+	}
+
+	since := time.Now().Add(-lookback)
+	series := make(map[string][]metrics.Point, len(names))
+	for _, name := range names {
+		values, err := s.statsHistory.Query(name, since, points)
+		if errors.Is(err, metrics.ErrUnknownSeries) {
+			http.Error(w, "Unknown series: "+name, http.StatusNotFound)
+			return
+		}
+		series[name] = values
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"series": series,
+	})
+}
+
+// handleAdminFingerprints lists the fingerprint allowlist and denylist (GET),
+// adds a fingerprint to one of them (POST) or removes the fingerprint given by
+// the fingerprint parameter (DELETE)
+func (s *Server) handleAdminFingerprints(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"fingerprints": s.fingerprints.Entries(),
+		})
+
+	case http.MethodPost:
+		var request struct {
+			Fingerprint string `json:"fingerprint"`
+			List        string `json:"list"`
+			Note        string `json:"note"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		var (
+			entry certmanager.FingerprintEntry
+			err   error
+		)
+		switch request.List {
+		case certmanager.FingerprintAllow:
+			entry, err = s.fingerprints.Allow(request.Fingerprint, request.Note)
+		case certmanager.FingerprintDeny:
+			entry, err = s.fingerprints.Deny(request.Fingerprint, request.Note)
+		default:
+			http.Error(w, "List must be allow or deny", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			httpError(w, err, "Failed to update fingerprint list")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entry)
+
+	case http.MethodDelete:
+		if err := s.fingerprints.Remove(r.URL.Query().Get("fingerprint")); err != nil {
+			httpError(w, err, "Failed to update fingerprint list")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": "success",
+		})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleAdminCleanup removes expired messages immediately. With a before
+// parameter (RFC 3339) it removes every message older than that time instead.
+func (s *Server) handleAdminCleanup(w http.ResponseWriter, r *http.Request) {
+	var removed int
+	if value := r.URL.Query().Get("before"); value != "" {
+		before, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			http.Error(w, "Invalid before", http.StatusBadRequest)
+			return
+		}
+		removed = s.binManager.Flush(before)
+	} else {
+		removed = s.binManager.RunOnce()
+	}
+	log.Printf("Admin cleanup removed %d messages", removed)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"removed": removed,
+	})
+}
+
+// handleAdminTrust lists the trusted CAs (GET), trusts a PEM CA certificate
+// (POST) or removes the anchor given by the id parameter (DELETE)
+func (s *Server) handleAdminTrust(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"anchors": s.trustStore.Anchors(),
+		})
+
+	case http.MethodPost:
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "Error reading request", http.StatusBadRequest)
+			return
+		}
+
+		cert, err := certmanager.ParseCertificatePEM(body)
+		if err != nil {
+			http.Error(w, "Invalid certificate", http.StatusBadRequest)
+			return
+		}
+
+		anchor, err := s.trustStore.Add(cert)
+		if err != nil {
+			httpError(w, err, "Failed to add trust anchor")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(anchor)
+
+	case http.MethodDelete:
+		if err := s.trustStore.Remove(r.URL.Query().Get("id")); err != nil {
+			httpError(w, err, "Failed to remove trust anchor")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": "success",
+		})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleAdminTrustReload rereads the trust directory immediately
+func (s *Server) handleAdminTrustReload(w http.ResponseWriter, r *http.Request) {
+	added, removed, err := s.trustStore.Reload()
+	response := map[string]interface{}{
+		"added":   added,
+		"removed": removed,
+	}
+	if err != nil {
+		response["error"] = err.Error()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// graphFormat picks the document encoding from an explicit format parameter
+// or a media type, defaulting to JSON
+func graphFormat(format, mediaType string) string {
+	if format == certmanager.GraphFormatCBOR || strings.Contains(mediaType, "application/cbor") {
+		return certmanager.GraphFormatCBOR
+	}
+	return certmanager.GraphFormatJSON
+}
+
+// graphContentType returns the media type of a document encoding
+func graphContentType(format string) string {
+	if format == certmanager.GraphFormatCBOR {
+		return "application/cbor"
+	}
+	return "application/json"
+}
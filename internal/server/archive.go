@@ -0,0 +1,243 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yourusername/secure-messaging-poc/internal/binmanager"
+)
+
+// maxImportSize bounds an uploaded bin archive
+const maxImportSize = 256 << 20
+
+// exportChunkSize is roughly how many message bytes each chunk of a bin
+// export holds; a chunk always holds at least one message
+const exportChunkSize = 1 << 20
+
+// exportRequest is the body of a bin export
+type exportRequest struct {
+	BinID  uint64 `json:"bin_id"`
+	Proof  []byte `json:"proof"`            // Channel-ownership secret, also the archive key
+	Cursor string `json:"cursor,omitempty"` // From the last chunk received, to resume
+}
+
+// exportCursor marks the last message of an export chunk by its exposed
+// timestamp and ID
+type exportCursor struct {
+	timestamp time.Time
+	messageID string
+}
+
+// parseExportCursor parses a cursor from an archive chunk; an empty cursor
+// starts at the oldest message
+func parseExportCursor(cursor string) (exportCursor, error) {
+	if cursor == "" {
+		return exportCursor{}, nil
+	}
+	nanos, messageID, ok := strings.Cut(cursor, ".")
+	if !ok {
+		return exportCursor{}, fmt.Errorf("invalid cursor %q", cursor)
+	}
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return exportCursor{}, fmt.Errorf("invalid cursor %q", cursor)
+	}
+	return exportCursor{timestamp: time.Unix(0, n), messageID: messageID}, nil
+}
+
+// String formats the cursor for an archive chunk
+func (c exportCursor) String() string {
+	return strconv.FormatInt(c.timestamp.UnixNano(), 10) + "." + c.messageID
+}
+
+// resumeIndex returns the index of the first of messages, in exposed
+// timestamp order, that follows the cursor. Messages sharing the cursor's
+// timestamp are skipped through its message; if that has expired, they are
+// all sent again.
+func (c exportCursor) resumeIndex(messages []*binmanager.Message) int {
+	if c.timestamp.IsZero() {
+		return 0
+	}
+	i := sort.Search(len(messages), func(i int) bool {
+		return !messages[i].Timestamp.Before(c.timestamp)
+	})
+	for j := i; j < len(messages) && messages[j].Timestamp.Equal(c.timestamp); j++ {
+		if messages[j].MessageID == c.messageID {
+			return j + 1
+		}
+	}
+	return i
+}
+
+// handleBinExport streams the retained messages of a bin the caller owns as
+// an encrypted archive, one chunk at a time. The archive is keyed with the
+// ownership secret, which even the bin's creator must supply, and carries
+// timestamps as clients see them. An interrupted download is resumed by
+// sending the cursor of the last chunk received.
+func (s *Server) handleBinExport(w http.ResponseWriter, r *http.Request) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		http.Error(w, "Client certificate required", http.StatusUnauthorized)
+		return
+	}
+	certID := s.certificateID(r.TLS.PeerCertificates[0])
+
+	var req exportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(req.Proof) == 0 {
+		http.Error(w, "Ownership proof required", http.StatusBadRequest)
+		return
+	}
+	cursor, err := parseExportCursor(req.Cursor)
+	if err != nil {
+		http.Error(w, "Invalid cursor", http.StatusBadRequest)
+		return
+	}
+
+	binID := s.binManager.GetBinID(req.BinID)
+	stored, err := s.binManager.ExportMessages(binID, binmanager.RetentionClaim{CertID: certID, Proof: req.Proof})
+	if err != nil {
+		httpError(w, err, "Failed to export bin")
+		return
+	}
+	messages := make([]*binmanager.Message, len(stored))
+	for i, msg := range stored {
+		messages[i] = s.exposeMessage(msg)
+	}
+	messages = messages[cursor.resumeIndex(messages):]
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="bin-%X.archive"`, binID))
+	archive, err := binmanager.NewArchiveWriter(w, req.Proof)
+	if err != nil {
+		log.Printf("Failed to start export of bin %X: %v", binID, err)
+		return
+	}
+	flusher, _ := w.(http.Flusher)
+
+	for {
+		n, size := 0, int64(0)
+		for n < len(messages) && (n == 0 || size+messages[n].Size() <= exportChunkSize) {
+			size += messages[n].Size()
+			n++
+		}
+		chunk := &binmanager.ArchiveChunk{
+			BinID:    binID,
+			Messages: messages[:n],
+			Cursor:   req.Cursor,
+			Final:    n == len(messages),
+		}
+		if n > 0 {
+			last := messages[n-1]
+			chunk.Cursor = exportCursor{timestamp: last.Timestamp, messageID: last.MessageID}.String()
+		}
+		if err := archive.WriteChunk(chunk); err != nil {
+			log.Printf("Export of bin %X interrupted: %v", binID, err)
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if chunk.Final {
+			return
+		}
+		messages = messages[n:]
+	}
+}
+
+// handleBinImport stores an archive exported from another server in a bin
+// the caller owns here, as history only. The bin is named by ?bin_id= and
+// the ownership secret, which is also the archive key, travels base64
+// encoded in the X-Ownership-Proof header. A bin without an owner that holds
+// no messages yet is claimed by the import.
+func (s *Server) handleBinImport(w http.ResponseWriter, r *http.Request) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		http.Error(w, "Client certificate required", http.StatusUnauthorized)
+		return
+	}
+	certID := s.certificateID(r.TLS.PeerCertificates[0])
+
+	binID, err := strconv.ParseUint(r.URL.Query().Get("bin_id"), 0, 64)
+	if err != nil {
+		http.Error(w, "Invalid bin ID", http.StatusBadRequest)
+		return
+	}
+	proof, err := base64.StdEncoding.DecodeString(r.Header.Get("X-Ownership-Proof"))
+	if err != nil || len(proof) == 0 {
+		http.Error(w, "Ownership proof required", http.StatusBadRequest)
+		return
+	}
+
+	binID = s.binManager.GetBinID(binID)
+	if err := s.binManager.ClaimOwnership(binID, binmanager.RetentionClaim{CertID: certID, Proof: proof}); err != nil {
+		httpError(w, err, "Failed to import bin")
+		return
+	}
+	archive, err := binmanager.NewArchiveReader(r.Body, proof)
+	if err != nil {
+		importError(w, err)
+		return
+	}
+	result, err := s.ingest.ImportArchive(binID, archive)
+	if err != nil {
+		importError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// importError reports a failed import, including an archive over the size
+// limit
+func importError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, "Archive too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	httpError(w, err, "Failed to import bin")
+}
+
+// handleBinStats returns coarse statistics about a bin to the holder of its
+// ownership proof, which even the bin's creator must supply
+func (s *Server) handleBinStats(w http.ResponseWriter, r *http.Request) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		http.Error(w, "Client certificate required", http.StatusUnauthorized)
+		return
+	}
+	certID := s.certificateID(r.TLS.PeerCertificates[0])
+
+	var req struct {
+		BinID uint64 `json:"bin_id"`
+		Proof []byte `json:"proof"` // Channel-ownership secret
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(req.Proof) == 0 {
+		http.Error(w, "Ownership proof required", http.StatusBadRequest)
+		return
+	}
+
+	binID := s.binManager.GetBinID(req.BinID)
+	stats, err := s.binManager.OwnerStats(binID, binmanager.RetentionClaim{CertID: certID, Proof: req.Proof})
+	if err != nil {
+		httpError(w, err, "Failed to read bin statistics")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
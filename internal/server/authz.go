@@ -0,0 +1,84 @@
+package server
+
+import (
+	"errors"
+	"log"
+
+	"github.com/yourusername/secure-messaging-poc/internal/authz"
+	"github.com/yourusername/secure-messaging-poc/internal/binmanager"
+)
+
+// WithPublishAuthorizer adds a policy consulted before each publish is
+// stored. Authorizers run in the order they were added.
+func WithPublishAuthorizer(authorizer authz.PublishAuthorizer) Option {
+	return func(s *Server) {
+		s.publishAuthz = append(s.publishAuthz, authorizer)
+	}
+}
+
+// WithSubscribeAuthorizer adds a policy consulted before each subscription
+// and replay of retained messages. Authorizers run in the order they were
+// added.
+func WithSubscribeAuthorizer(authorizer authz.SubscribeAuthorizer) Option {
+	return func(s *Server) {
+		s.subscribeAuthz = append(s.subscribeAuthz, authorizer)
+	}
+}
+
+// WithRevokedReferrerReadOnly keeps certificates whose referrer is revoked
+// connected, but only to read bins they had already joined. The TLS
+// configuration must not reject such certificates for this to apply.
+func WithRevokedReferrerReadOnly() Option {
+	return func(s *Server) {
+		policy := authz.NewRevokedReferrer(s.revocationMgr.IsRevoked)
+		s.readOnlyRevokedReferrers = true
+		s.publishAuthz = append(s.publishAuthz, policy)
+		s.subscribeAuthz = append(s.subscribeAuthz, policy)
+	}
+}
+
+// WithBinCreationPoW requires messages that would create a bin to carry a
+// proof of work of difficulty leading zero bits, advertised in the server
+// info. Admins and the listed publisher certificates are exempt, as are
+// certificates the FeatureBinCreationPoW flag is off for.
+func WithBinCreationPoW(difficulty int, publisherCertIDs []string) Option {
+	return func(s *Server) {
+		policy := authz.NewBinCreation(difficulty, s.binManager.HasBin, publisherCertIDs...)
+		s.newBinPoW = difficulty
+		s.publishAuthz = append(s.publishAuthz, gatedPublishAuthorizer{s, FeatureBinCreationPoW, policy})
+	}
+}
+
+// subscribeErrorFrame reports a denied subscription to the client
+func subscribeErrorFrame(err error) ErrorFrame {
+	if errors.Is(err, authz.ErrForbidden) {
+		return newErrorFrame(ErrSubscribeDenied)
+	}
+	log.Printf("Subscribe authorizer failed: %v", err)
+	return newErrorFrame(ErrInternal)
+}
+
+// publishErrorFrame reports a denied publish to the client
+func publishErrorFrame(err error) ErrorFrame {
+	var rateErr *authz.RateLimitError
+	var powErr *authz.ProofOfWorkError
+	switch {
+	case errors.As(err, &rateErr):
+		return newErrorFrame(ErrRateLimited).withRetryAfter(rateErr.RetryAfter)
+	case errors.Is(err, authz.ErrRateLimited):
+		return newErrorFrame(ErrRateLimited)
+	case errors.As(err, &powErr):
+		return newErrorFrame(ErrProofOfWorkRequired).withDifficulty(powErr.Difficulty)
+	case errors.Is(err, authz.ErrForbidden):
+		return newErrorFrame(ErrPublishDenied)
+	case errors.Is(err, authz.ErrRejected):
+		return newErrorFrame(ErrPolicyRejected)
+	case errors.Is(err, binmanager.ErrRetentionOutOfBounds),
+		errors.Is(err, binmanager.ErrRetentionNotOwner),
+		errors.Is(err, binmanager.ErrRetentionOverrideDisabled):
+		return newErrorFrame(ErrRetentionDenied)
+	default:
+		log.Printf("Publish authorizer failed: %v", err)
+		return newErrorFrame(ErrInternal)
+	}
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"time"
+
+	"github.com/yourusername/secure-messaging-poc/internal/metrics"
+	"github.com/yourusername/secure-messaging-poc/internal/ratelimit"
+)
+
+// Bandwidth classes every deployment has; other classes list their members
+const (
+	BandwidthClassDefault = "default"
+	BandwidthClassAdmin   = "admin"
+)
+
+// BandwidthClass shapes the WebSocket writes of a class of certificates.
+// Rates are in bytes per second; zero leaves that limit off.
+type BandwidthClass struct {
+	Name            string
+	CertIDs         []string // Members; admins fall in "admin" and everyone else in "default"
+	ConnectionRate  float64  // Per connection
+	ConnectionBurst int
+	ClassRate       float64 // All connections of the class together
+	ClassBurst      int
+}
+
+// bandwidthClass is a configured class with its shared bucket and counters
+type bandwidthClass struct {
+	BandwidthClass
+	shared    *ratelimit.ByteBucket
+	bytes     *metrics.Counter
+	throttled *metrics.Counter
+}
+
+// WithBandwidthClasses shapes WebSocket writes per connection and per
+// certificate class, so one bulk reader cannot saturate the uplink. Bytes
+// sent and time spent waiting are counted per class.
+func WithBandwidthClasses(classes []BandwidthClass) Option {
+	return func(s *Server) {
+		s.bandwidthClasses = make(map[string]*bandwidthClass, len(classes))
+		s.bandwidthMembers = make(map[string]string)
+		for _, class := range classes {
+			s.bandwidthClasses[class.Name] = &bandwidthClass{BandwidthClass: class}
+			for _, certID := range class.CertIDs {
+				s.bandwidthMembers[certID] = class.Name
+			}
+		}
+	}
+}
+
+// setupBandwidthClasses creates the shared buckets and counters once the
+// metrics registry is known
+func (s *Server) setupBandwidthClasses(registry *metrics.Registry) {
+	for name, class := range s.bandwidthClasses {
+		if class.ClassRate > 0 {
+			class.shared = ratelimit.NewByteBucket(class.ClassRate, class.ClassBurst)
+		}
+		class.bytes = registry.Counter("anono_bandwidth_"+name+"_bytes_total",
+			"Bytes written to WebSocket clients in bandwidth class "+name)
+		class.throttled = registry.Counter("anono_bandwidth_"+name+"_throttled_milliseconds_total",
+			"Time writes in bandwidth class "+name+" waited for shaping")
+	}
+}
+
+// certificateClass returns the bandwidth class certID falls in, which also
+// selects it for feature flags
+func (s *Server) certificateClass(certID string) string {
+	if name, exists := s.bandwidthMembers[certID]; exists {
+		return name
+	}
+	if s.adminIDs[certID] {
+		return BandwidthClassAdmin
+	}
+	return BandwidthClassDefault
+}
+
+// shapeClient applies the bandwidth class of certID to a new client; without
+// a class its writes are only counted
+func (s *Server) shapeClient(client *Client, certID string) {
+	class, exists := s.bandwidthClasses[s.certificateClass(certID)]
+	if !exists {
+		client.SetShaping(func(bytes int, _ time.Duration) {
+			s.bytesWritten.Add(uint64(bytes))
+		})
+		return
+	}
+
+	var buckets []*ratelimit.ByteBucket
+	if class.ConnectionRate > 0 {
+		buckets = append(buckets, ratelimit.NewByteBucket(class.ConnectionRate, class.ConnectionBurst))
+	}
+	if class.shared != nil {
+		buckets = append(buckets, class.shared)
+	}
+	client.SetShaping(func(bytes int, delay time.Duration) {
+		s.bytesWritten.Add(uint64(bytes))
+		class.bytes.Add(uint64(bytes))
+		class.throttled.Add(uint64(delay / time.Millisecond))
+	}, buckets...)
+}
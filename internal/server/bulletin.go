@@ -0,0 +1,216 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/yourusername/secure-messaging-poc/internal/binmanager"
+	"github.com/yourusername/secure-messaging-poc/internal/bulletin"
+)
+
+// Limits on the number of entries in one bulletin feed response
+const (
+	defaultBulletinPage = 100
+	maxBulletinPage     = 1000
+)
+
+// bulletinBoard keeps the public logs of bulletin channels and the signed
+// head of each at its last size
+type bulletinBoard struct {
+	board *bulletin.Board
+	heads map[uint64]signedBulletinHead
+	mu    sync.Mutex
+}
+
+// signedBulletinHead is a log head with its JWS. As with /api/info, the JWS
+// payload is authoritative.
+type signedBulletinHead struct {
+	Head       bulletin.Head `json:"head"`
+	SignedHead string        `json:"signed_head"`
+}
+
+// WithBulletinBoard serves the history of the board's channels publicly, on
+// the main and discovery listeners, as signed hash-chained logs with
+// inclusion proofs. Every message stored in a bulletin channel's bin is
+// appended to its log; who may publish there is left to the publish policy.
+func WithBulletinBoard(board *bulletin.Board) Option {
+	return func(s *Server) {
+		s.bulletin = &bulletinBoard{board: board, heads: make(map[uint64]signedBulletinHead)}
+	}
+}
+
+// appendBulletin records a stored message in the log of every bulletin
+// channel that maps to its bin under the current mask
+func (s *Server) appendBulletin(msg *binmanager.Message) {
+	mask := s.binManager.GetCurrentMask()
+	for _, channel := range s.bulletin.board.Channels() {
+		if channel&mask != msg.BinID {
+			continue
+		}
+		l, err := s.bulletin.board.Log(channel)
+		if err != nil {
+			continue
+		}
+		if _, err := l.Append(msg.MessageID, msg.Ciphertext, s.exposeMessage(msg).Timestamp); err != nil {
+			log.Printf("Failed to append message to bulletin 0x%X: %v", channel, err)
+		}
+	}
+}
+
+// routeBulletin registers the bulletin endpoints on mux
+func (s *Server) routeBulletin(mux *http.ServeMux) {
+	s.route(mux, "/api/bulletin", noRequestBody, s.handleBulletin, http.MethodGet)
+	s.route(mux, "/api/bulletin/head", noRequestBody, s.handleBulletinHead, http.MethodGet)
+	s.route(mux, "/api/bulletin/proof", noRequestBody, s.handleBulletinProof, http.MethodGet)
+}
+
+// bulletinLog returns the log named by the channel parameter, writing the
+// error response if there is none
+func (s *Server) bulletinLog(w http.ResponseWriter, r *http.Request) (*bulletin.Log, bool) {
+	channel, err := strconv.ParseUint(r.URL.Query().Get("channel"), 0, 64)
+	if err != nil {
+		http.Error(w, "Invalid channel", http.StatusBadRequest)
+		return nil, false
+	}
+	l, err := s.bulletin.board.Log(channel)
+	if err != nil {
+		httpError(w, err, "Failed to open bulletin")
+		return nil, false
+	}
+	return l, true
+}
+
+// handleBulletin serves the entries of a bulletin log from the since index,
+// with the signed head they can be checked against
+func (s *Server) handleBulletin(w http.ResponseWriter, r *http.Request) {
+	l, ok := s.bulletinLog(w, r)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	var since uint64
+	if value := query.Get("since"); value != "" {
+		parsed, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid since", http.StatusBadRequest)
+			return
+		}
+		since = parsed
+	}
+	limit := defaultBulletinPage
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 || parsed > maxBulletinPage {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	head, err := s.signBulletinHead(l)
+	if err != nil {
+		httpError(w, err, "Failed to sign bulletin head")
+		return
+	}
+	// Entries appended after the head was signed are left for next time
+	entries := []bulletin.Entry{}
+	if since < head.Head.Size {
+		entries = l.Entries(since, limit)
+		if since+uint64(len(entries)) > head.Head.Size {
+			entries = entries[:head.Head.Size-since]
+		}
+	}
+	next := since + uint64(len(entries))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"entries":     entries,
+		"next":        next,
+		"head":        head.Head,
+		"signed_head": head.SignedHead,
+	})
+}
+
+// handleBulletinHead serves the signed head of a bulletin log. Clients that
+// compare heads across time and with each other detect a server showing
+// different histories.
+func (s *Server) handleBulletinHead(w http.ResponseWriter, r *http.Request) {
+	l, ok := s.bulletinLog(w, r)
+	if !ok {
+		return
+	}
+	head, err := s.signBulletinHead(l)
+	if err != nil {
+		httpError(w, err, "Failed to sign bulletin head")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(head)
+}
+
+// handleBulletinProof serves the inclusion proof of the entry at index in
+// the log at size, the current size by default, so clients can check it
+// against a head they already hold
+func (s *Server) handleBulletinProof(w http.ResponseWriter, r *http.Request) {
+	l, ok := s.bulletinLog(w, r)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	index, err := strconv.ParseUint(query.Get("index"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid index", http.StatusBadRequest)
+		return
+	}
+	size := l.Size()
+	if value := query.Get("size"); value != "" {
+		size, err = strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid size", http.StatusBadRequest)
+			return
+		}
+	}
+
+	path, err := l.InclusionProof(index, size)
+	if err != nil {
+		httpError(w, err, "Failed to prove bulletin entry")
+		return
+	}
+	entries := l.Entries(index, 1)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"entry":     entries[0],
+		"leaf_hash": entries[0].Hash(),
+		"size":      size,
+		"path":      path,
+	})
+}
+
+// signBulletinHead returns the signed current head of a log, signing it
+// only when the log has grown since the last one
+func (s *Server) signBulletinHead(l *bulletin.Log) (signedBulletinHead, error) {
+	head, err := l.Head(l.Size())
+	if err != nil {
+		return signedBulletinHead{}, err
+	}
+	channel := l.Channel()
+
+	s.bulletin.mu.Lock()
+	defer s.bulletin.mu.Unlock()
+	if cached, ok := s.bulletin.heads[channel]; ok && cached.Head.Size == head.Size {
+		return cached, nil
+	}
+	payload, err := json.Marshal(head)
+	if err != nil {
+		return signedBulletinHead{}, err
+	}
+	signed, err := s.certAuthority.SignJWS(payload)
+	if err != nil {
+		return signedBulletinHead{}, err
+	}
+	s.bulletin.heads[channel] = signedBulletinHead{Head: head, SignedHead: signed}
+	return s.bulletin.heads[channel], nil
+}
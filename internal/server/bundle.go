@@ -0,0 +1,105 @@
+package server
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/yourusername/secure-messaging-poc/internal/certmanager"
+)
+
+// Media types offered for issued certificates
+const (
+	mediaTypeJSON  = "application/json"
+	mediaTypePKCS7 = "application/pkcs7-mime"
+	mediaTypePEM   = "application/x-pem-file"
+	mediaTypeDER   = "application/pkix-cert"
+)
+
+// enrollmentBundle is the JSON response to a certificate request
+type enrollmentBundle struct {
+	Certificate   string   `json:"certificate"` // PEM
+	CAChain       []string `json:"ca_chain"`    // PEM, issuing CA first
+	CertificateID string   `json:"certificate_id"`
+	Serial        string   `json:"serial"` // Hex
+	NotBefore     string   `json:"not_before"`
+	NotAfter      string   `json:"not_after"`
+	ReferrerID    string   `json:"referrer_id,omitempty"`
+	Pseudonym     string   `json:"pseudonym,omitempty"` // Bound anono:// URI SAN
+}
+
+// writeCertificateBundle returns an issued certificate in the format the
+// client accepts: a JSON bundle, a PKCS#7 certs-only bundle, PEM (the
+// certificate followed by the CA chain) or, for legacy clients that send no
+// preference, the bare DER certificate
+func (s *Server) writeCertificateBundle(w http.ResponseWriter, r *http.Request, cert *x509.Certificate, referrerID string) {
+	chain := make([]*x509.Certificate, 0, 1)
+	if caCert, err := s.certAuthority.GetCACertificate(); err == nil {
+		chain = append(chain, caCert)
+	}
+
+	switch negotiateCertificateType(r.Header.Get("Accept")) {
+	case mediaTypeJSON:
+		bundle := enrollmentBundle{
+			Certificate:   encodePEM(cert),
+			CAChain:       make([]string, 0, len(chain)),
+			CertificateID: s.certificateID(cert),
+			Serial:        certmanager.SerialString(cert),
+			NotBefore:     cert.NotBefore.UTC().Format(time.RFC3339),
+			NotAfter:      cert.NotAfter.UTC().Format(time.RFC3339),
+			ReferrerID:    referrerID,
+		}
+		if pseudonym, ok := certmanager.ExtractPseudonym(cert); ok {
+			bundle.Pseudonym = certmanager.PseudonymURI(pseudonym).String()
+		}
+		for _, caCert := range chain {
+			bundle.CAChain = append(bundle.CAChain, encodePEM(caCert))
+		}
+		w.Header().Set("Content-Type", mediaTypeJSON)
+		json.NewEncoder(w).Encode(bundle)
+
+	case mediaTypePKCS7:
+		data, err := certmanager.EncodePKCS7Certificates(append([]*x509.Certificate{cert}, chain...)...)
+		if err != nil {
+			http.Error(w, "Failed to encode certificate bundle", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", mediaTypePKCS7+"; smime-type=certs-only")
+		w.Write(data)
+
+	case mediaTypePEM:
+		var pemData strings.Builder
+		for _, c := range append([]*x509.Certificate{cert}, chain...) {
+			pemData.WriteString(encodePEM(c))
+		}
+		w.Header().Set("Content-Type", mediaTypePEM)
+		w.Write([]byte(pemData.String()))
+
+	default:
+		w.Header().Set("Content-Type", mediaTypeDER)
+		w.Write(cert.Raw)
+	}
+}
+
+// negotiateCertificateType picks the first supported media type in an
+// Accept header, in the client's order; anything else gets DER
+func negotiateCertificateType(accept string) string {
+	for _, part := range strings.Split(accept, ",") {
+		mediaType := strings.TrimSpace(strings.SplitN(part, ";", 2)[0])
+		switch mediaType {
+		case mediaTypeJSON, mediaTypePKCS7, mediaTypePEM, mediaTypeDER:
+			return mediaType
+		case "application/x-pkcs7-certificates", "application/pkcs7":
+			return mediaTypePKCS7
+		}
+	}
+	return mediaTypeDER
+}
+
+// encodePEM returns a certificate in PEM form
+func encodePEM(cert *x509.Certificate) string {
+	data, _ := certmanager.EncodeCertificatePEM(cert)
+	return string(data)
+}
@@ -0,0 +1,268 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Frame captures record the frame sequence of one certificate's
+// connections for a bounded time, to debug client interop problems. Only
+// the shape of the traffic is kept: direction, frame type, size, timing
+// and error codes. Payloads, bin IDs, message IDs and session IDs never
+// are. Captures are started by an admin and expire on their own.
+const (
+	// defaultCaptureDuration is how long a capture records unless asked
+	defaultCaptureDuration = 5 * time.Minute
+	// maxCaptureDuration bounds how long a capture records
+	maxCaptureDuration = 15 * time.Minute
+	// captureRetention is how long a finished capture can be retrieved
+	captureRetention = time.Hour
+	// maxCapturedFrames bounds the frames one capture holds
+	maxCapturedFrames = 10000
+)
+
+// Directions of captured frames
+const (
+	captureIn  = "in"
+	captureOut = "out"
+)
+
+// capturedTypes are the frame types recorded by name; others are recorded
+// as "unknown" so client-chosen strings are not kept
+var capturedTypes = map[string]bool{
+	"message":          true,
+	frameSubscribe:     true,
+	frameCloseSession:  true,
+	frameSessionClosed: true,
+	"subscribe_ack":    true,
+	"publish_ack":      true,
+	"error":            true,
+}
+
+// capturedFrame is one frame of a capture
+type capturedFrame struct {
+	Connection int    `json:"connection"` // Numbered in order of first frame
+	Direction  string `json:"direction"`
+	Type       string `json:"type"`
+	Size       int    `json:"size"`
+	OffsetUS   int64  `json:"offset_us"` // Since the capture started
+	Code       int    `json:"code,omitempty"`
+}
+
+// frameCapture is the capture of one certificate's connections
+type frameCapture struct {
+	CertID    string          `json:"cert_id"`
+	Started   time.Time       `json:"started"`
+	Until     time.Time       `json:"until"`
+	Truncated bool            `json:"truncated,omitempty"`
+	Frames    []capturedFrame `json:"frames,omitempty"`
+
+	connections map[uint64]int
+}
+
+// captureSet holds the captures by certificate ID
+type captureSet struct {
+	active   int32 // Captures still recording, to skip the lock otherwise
+	captures map[string]*frameCapture
+	mu       sync.Mutex
+	now      func() time.Time
+}
+
+// newCaptureSet creates an empty set of captures
+func newCaptureSet() *captureSet {
+	return &captureSet{captures: make(map[string]*frameCapture), now: time.Now}
+}
+
+// start begins capturing a certificate's connections for duration,
+// replacing any earlier capture of it
+func (cs *captureSet) start(certID string, duration time.Duration) *frameCapture {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	now := cs.now()
+	cs.expireLocked(now)
+
+	capture := &frameCapture{
+		CertID:      certID,
+		Started:     now,
+		Until:       now.Add(duration),
+		connections: make(map[uint64]int),
+	}
+	cs.captures[certID] = capture
+	cs.countActiveLocked(now)
+	return capture
+}
+
+// record adds a frame sent or received on connection if its certificate is
+// being captured
+func (cs *captureSet) record(certID string, connection uint64, direction string, data []byte) {
+	if atomic.LoadInt32(&cs.active) == 0 {
+		return
+	}
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	now := cs.now()
+	capture := cs.captures[certID]
+	if capture == nil || !now.Before(capture.Until) {
+		cs.countActiveLocked(now)
+		return
+	}
+	if len(capture.Frames) >= maxCapturedFrames {
+		capture.Truncated = true
+		return
+	}
+
+	index, seen := capture.connections[connection]
+	if !seen {
+		index = len(capture.connections) + 1
+		capture.connections[connection] = index
+	}
+	frameType, code := describeFrame(data)
+	capture.Frames = append(capture.Frames, capturedFrame{
+		Connection: index,
+		Direction:  direction,
+		Type:       frameType,
+		Size:       len(data),
+		OffsetUS:   now.Sub(capture.Started).Microseconds(),
+		Code:       code,
+	})
+}
+
+// get returns a copy of a certificate's capture
+func (cs *captureSet) get(certID string) (frameCapture, bool) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.expireLocked(cs.now())
+	capture, ok := cs.captures[certID]
+	if !ok {
+		return frameCapture{}, false
+	}
+	copied := *capture
+	copied.Frames = append([]capturedFrame(nil), capture.Frames...)
+	return copied, true
+}
+
+// list returns every capture without its frames, by start time
+func (cs *captureSet) list() []frameCapture {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.expireLocked(cs.now())
+	list := make([]frameCapture, 0, len(cs.captures))
+	for _, capture := range cs.captures {
+		summary := *capture
+		summary.Frames = nil
+		list = append(list, summary)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Started.Before(list[j].Started) })
+	return list
+}
+
+// remove deletes a certificate's capture, ending it if still recording
+func (cs *captureSet) remove(certID string) bool {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	_, ok := cs.captures[certID]
+	delete(cs.captures, certID)
+	cs.countActiveLocked(cs.now())
+	return ok
+}
+
+// expireLocked drops captures that finished longer ago than the retention
+func (cs *captureSet) expireLocked(now time.Time) {
+	for certID, capture := range cs.captures {
+		if now.Sub(capture.Until) > captureRetention {
+			delete(cs.captures, certID)
+		}
+	}
+	cs.countActiveLocked(now)
+}
+
+// countActiveLocked updates the number of captures still recording
+func (cs *captureSet) countActiveLocked(now time.Time) {
+	var active int32
+	for _, capture := range cs.captures {
+		if now.Before(capture.Until) {
+			active++
+		}
+	}
+	atomic.StoreInt32(&cs.active, active)
+}
+
+// describeFrame returns the type of a JSON frame, and its code if it is an
+// error. Frames without a type are messages.
+func describeFrame(data []byte) (string, int) {
+	var header struct {
+		Type string `json:"type"`
+		Code int    `json:"code"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return "unparsable", 0
+	}
+	if header.Type == "" {
+		return "message", 0
+	}
+	if !capturedTypes[header.Type] {
+		return "unknown", 0
+	}
+	if header.Type == "error" {
+		return header.Type, header.Code
+	}
+	return header.Type, 0
+}
+
+// handleAdminCapture manages frame captures: POST starts capturing a
+// certificate's connections, GET lists the captures or with ?cert_id=
+// returns one, and DELETE removes one
+func (s *Server) handleAdminCapture(w http.ResponseWriter, r *http.Request) {
+	certID := r.URL.Query().Get("cert_id")
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		if certID == "" {
+			json.NewEncoder(w).Encode(map[string]interface{}{"captures": s.captures.list()})
+			return
+		}
+		capture, ok := s.captures.get(certID)
+		if !ok {
+			http.Error(w, "No capture for this certificate", http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(capture)
+
+	case http.MethodPost:
+		var request struct {
+			CertID          string `json:"cert_id"`
+			DurationSeconds int    `json:"duration_seconds"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.CertID == "" {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		duration := time.Duration(request.DurationSeconds) * time.Second
+		if duration <= 0 {
+			duration = defaultCaptureDuration
+		}
+		if duration > maxCaptureDuration {
+			duration = maxCaptureDuration
+		}
+		capture := s.captures.start(request.CertID, duration)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(capture)
+
+	case http.MethodDelete:
+		if !s.captures.remove(certID) {
+			http.Error(w, "No capture for this certificate", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
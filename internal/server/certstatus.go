@@ -0,0 +1,66 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/yourusername/secure-messaging-poc/internal/certmanager"
+)
+
+// handleCertificateStatus reports the standing of the caller's own
+// certificate, so clients can renew before it expires and warn their user
+// when a referrer above them was revoked
+func (s *Server) handleCertificateStatus(w http.ResponseWriter, r *http.Request) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		http.Error(w, "Client certificate required", http.StatusUnauthorized)
+		return
+	}
+	cert := r.TLS.PeerCertificates[0]
+	certID := s.certificateID(cert)
+	now := time.Now()
+
+	status := map[string]interface{}{
+		"cert_id":    certID,
+		"revoked":    s.revocationMgr.IsRevoked(certID),
+		"not_before": cert.NotBefore.Format(time.RFC3339),
+		"not_after":  cert.NotAfter.Format(time.RFC3339),
+		"expires_in": int(cert.NotAfter.Sub(now).Seconds()), // Seconds
+		"timestamp":  now.Format(time.RFC3339),
+	}
+
+	// The referral graph may have dropped the edge to the direct referrer,
+	// which the certificate itself names
+	chain := s.revocationMgr.ReferrerChain(certID)
+	if referrerID, err := certmanager.ExtractReferrerID(cert); err == nil && referrerID != "" {
+		status["referrer_revoked"] = s.revocationMgr.IsRevoked(referrerID)
+		if len(chain) == 0 {
+			chain = []string{referrerID}
+		}
+	}
+	intact := true
+	for _, id := range chain {
+		if s.revocationMgr.IsRevoked(id) {
+			intact = false
+			break
+		}
+	}
+	status["referrer_chain_intact"] = intact
+
+	if s.enrollmentMgr != nil {
+		if remaining, limited := s.enrollmentMgr.RemainingInvites(certID); limited {
+			status["invites_remaining"] = remaining
+		}
+	}
+
+	if ra, ok := s.publishLimiter.(interface{ RetryAfter(string) time.Duration }); ok {
+		retryAfter := ra.RetryAfter(certID)
+		status["publish_limited"] = retryAfter > 0
+		if retryAfter > 0 {
+			status["retry_after"] = int((retryAfter + time.Second - 1) / time.Second) // Seconds
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
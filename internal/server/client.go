@@ -1,13 +1,27 @@
 package server
 
 import (
+	"encoding/json"
+	"errors"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/yourusername/secure-messaging-poc/internal/binmanager"
+	"github.com/yourusername/secure-messaging-poc/internal/ratelimit"
+	"github.com/yourusername/secure-messaging-poc/pkg/protocol"
 )
 
+// Default write limits applied by NewClient
+const (
+	DefaultWriteTimeout     = 10 * time.Second
+	DefaultMaxPendingWrites = 64
+)
+
+// ErrClientBacklogged is returned when a client has too many writes queued
+var ErrClientBacklogged = errors.New("client has too many pending writes")
+
 // Client represents a connected WebSocket client
 type Client struct {
 	conn      *websocket.Conn
@@ -16,27 +30,268 @@ type Client struct {
 	closeMu   sync.Mutex
 	isClosed  bool
 	createdAt time.Time
+	
+	// Write limits; a client that cannot keep up is disconnected
+	writeTimeout     time.Duration
+	maxPendingWrites int32
+	pendingWrites    int32
+	
+	// Bandwidth shaping; each write waits for all buckets. Shaped frames
+	// wait in a queue for their own writer, so neither the sender nor other
+	// writes to the connection wait with them.
+	shapers []*ratelimit.ByteBucket
+	onWrite func(bytes int, delay time.Duration)
+	shaped  chan []byte
+	
+	// Closed when the client is closed
+	done chan struct{}
+	
+	// Control frames are padded to a multiple of this many bytes
+	padBlock int
+	
+	// Rewrites delivered messages, such as to coarsen their timestamps
+	expose func(*binmanager.Message) *binmanager.Message
+	
+	// Called when a write panics; the client is closed either way
+	onPanic func(interface{})
+	
+	// Records the frames sent and received, while a capture is running
+	capture func(direction string, data []byte)
+	
+	// Server-assigned connection number, for captures and plugin events
+	connection uint64
+	
+	// Trace ID of the connection, sent in error frames and logged
+	trace string
 }
 
 // NewClient creates a new client
 func NewClient(conn *websocket.Conn, certInfo map[string]interface{}) *Client {
 	return &Client{
-		conn:      conn,
-		certInfo:  certInfo,
-		createdAt: time.Now(),
+		conn:             conn,
+		certInfo:         certInfo,
+		createdAt:        time.Now(),
+		writeTimeout:     DefaultWriteTimeout,
+		maxPendingWrites: DefaultMaxPendingWrites,
+		done:             make(chan struct{}),
 	}
 }
 
+// SetWriteLimits sets the per-write deadline and the number of writes that
+// may wait for the connection before the client is dropped. Zero disables
+// the respective limit.
+func (c *Client) SetWriteLimits(writeTimeout time.Duration, maxPendingWrites int) {
+	c.writeTimeout = writeTimeout
+	c.maxPendingWrites = int32(maxPendingWrites)
+}
+
+// SetShaping delays writes until every bucket has room for them. onWrite,
+// if set, is called after each data write with its size and the time it
+// waited. With buckets, frames are queued to a writer goroutine, up to the
+// pending write limit, and written as the buckets allow; it must be called
+// after SetWriteLimits and before the first write.
+func (c *Client) SetShaping(onWrite func(bytes int, delay time.Duration), buckets ...*ratelimit.ByteBucket) {
+	c.shapers = buckets
+	c.onWrite = onWrite
+	if len(buckets) > 0 && c.shaped == nil {
+		queue := int(c.maxPendingWrites)
+		if queue <= 0 {
+			queue = DefaultMaxPendingWrites
+		}
+		c.shaped = make(chan []byte, queue)
+		go c.writeShaped()
+	}
+}
+
+// SetPadding pads control frames, such as acknowledgements and errors, to
+// a multiple of block bytes so their type is not revealed by their size.
+// Zero disables padding. Messages are not padded; their ciphertext is the
+// client's to pad.
+func (c *Client) SetPadding(block int) {
+	c.padBlock = block
+}
+
+// SetExposure rewrites each delivered message before it is sent, such as to
+// coarsen its timestamp. Nil delivers messages as stored.
+func (c *Client) SetExposure(expose func(*binmanager.Message) *binmanager.Message) {
+	c.expose = expose
+}
+
 // SendMessage sends a message to the client
 func (c *Client) SendMessage(msg *binmanager.Message) error {
+	if c.expose != nil {
+		msg = c.expose(msg)
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return c.send(data)
+}
+
+// SendFrame sends an arbitrary JSON control frame to the client
+func (c *Client) SendFrame(frame interface{}) error {
+	data, err := json.Marshal(frame)
+	if err != nil {
+		return err
+	}
+	return c.send(protocol.Pad(data, c.padBlock))
+}
+
+// send writes a text frame, or queues it when the client is shaped
+func (c *Client) send(data []byte) error {
+	if c.shaped != nil {
+		return c.enqueue(data)
+	}
+	return c.sendNow(data, 0)
+}
+
+// enqueue hands a frame to the shaped writer. A full queue closes the
+// client, like a backlogged write.
+func (c *Client) enqueue(data []byte) error {
+	if !c.IsActive() {
+		return websocket.ErrCloseSent
+	}
+	select {
+	case c.shaped <- data:
+		return nil
+	default:
+		c.Close()
+		return ErrClientBacklogged
+	}
+}
+
+// writeShaped writes queued frames as the buckets allow until the client is
+// closed. It waits without holding the write lock, so pings and close
+// frames are not held up; a frame that would wait longer than the write
+// deadline closes the client.
+func (c *Client) writeShaped() {
+	for {
+		select {
+		case <-c.done:
+			return
+		case data := <-c.shaped:
+			delay := c.shapingDelay(len(data))
+			if c.writeTimeout > 0 && delay > c.writeTimeout {
+				c.Close()
+				return
+			}
+			if delay > 0 {
+				timer := time.NewTimer(delay)
+				select {
+				case <-timer.C:
+				case <-c.done:
+					timer.Stop()
+					return
+				}
+			}
+			if c.sendNow(data, delay) != nil {
+				return
+			}
+		}
+	}
+}
+
+// sendNow writes a text frame that waited delay for shaping
+func (c *Client) sendNow(data []byte, delay time.Duration) error {
+	err := c.write(len(data), delay, func() error {
+		return c.conn.WriteMessage(websocket.TextMessage, data)
+	})
+	if err == nil {
+		c.recordFrame(captureOut, data)
+	}
+	return err
+}
+
+// recordFrame passes a frame to the client's capture, if any
+func (c *Client) recordFrame(direction string, data []byte) {
+	if c.capture != nil {
+		c.capture(direction, data)
+	}
+}
+
+// write serializes a write of size bytes, which waited delay for shaping,
+// under the client's limits. A write that misses its deadline or finds the
+// queue full closes the client, which also unblocks any writer stuck on the
+// connection. A panicking write closes only this client, so a broadcast
+// continues to the others.
+func (c *Client) write(size int, delay time.Duration, fn func() error) (err error) {
+	if c.maxPendingWrites > 0 {
+		if atomic.AddInt32(&c.pendingWrites, 1) > c.maxPendingWrites {
+			atomic.AddInt32(&c.pendingWrites, -1)
+			c.Close()
+			return ErrClientBacklogged
+		}
+		defer atomic.AddInt32(&c.pendingWrites, -1)
+	}
+	
 	c.writeMu.Lock()
 	defer c.writeMu.Unlock()
 	
-	if c.isClosed {
+	if !c.IsActive() {
 		return websocket.ErrCloseSent
 	}
 	
-	return c.conn.WriteJSON(msg)
+	if c.writeTimeout > 0 {
+		c.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	}
+	
+	defer func() {
+		if v := recover(); v != nil {
+			c.Close()
+			if c.onPanic != nil {
+				c.onPanic(v)
+			}
+			err = ErrClientPanic
+		}
+	}()
+	
+	if err := fn(); err != nil {
+		c.Close()
+		return err
+	}
+	if c.onWrite != nil && size > 0 {
+		c.onWrite(size, delay)
+	}
+	return nil
+}
+
+// shapingDelay reserves size bytes in every bucket and returns the longest
+// wait among them
+func (c *Client) shapingDelay(size int) time.Duration {
+	var delay time.Duration
+	for _, bucket := range c.shapers {
+		if wait := bucket.Reserve(size); wait > delay {
+			delay = wait
+		}
+	}
+	return delay
+}
+
+// SendError sends a structured error frame without closing the connection
+func (c *Client) SendError(frame ErrorFrame) error {
+	frame.TraceID = c.trace
+	return c.SendFrame(frame)
+}
+
+// CloseWithError sends a structured error frame, then closes the connection
+// using the error code as the WebSocket close code. The frame skips any
+// shaping queue, which closing would discard.
+func (c *Client) CloseWithError(frame ErrorFrame) {
+	frame.TraceID = c.trace
+	if data, err := json.Marshal(frame); err == nil {
+		c.sendNow(protocol.Pad(data, c.padBlock), 0)
+	}
+	
+	c.writeMu.Lock()
+	c.conn.WriteControl(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(int(frame.Code), frame.Message),
+		time.Now().Add(time.Second),
+	)
+	c.writeMu.Unlock()
+	
+	c.Close()
 }
 
 // GetCertificateInfo returns the client's certificate info
@@ -46,8 +301,8 @@ func (c *Client) GetCertificateInfo() map[string]interface{} {
 
 // GetCertificateID returns the client's certificate ID
 func (c *Client) GetCertificateID() string {
-	if serial, ok := c.certInfo["serial"].(string); ok {
-		return serial
+	if certID, ok := c.certInfo["cert_id"].(string); ok {
+		return certID
 	}
 	return ""
 }
@@ -59,6 +314,7 @@ func (c *Client) Close() {
 	
 	if !c.isClosed {
 		c.isClosed = true
+		close(c.done)
 		c.conn.Close()
 	}
 }
@@ -72,16 +328,11 @@ func (c *Client) IsActive() bool {
 
 // SendPing sends a ping message to check if client is still connected
 func (c *Client) SendPing() error {
-	c.writeMu.Lock()
-	defer c.writeMu.Unlock()
-	
-	if c.isClosed {
-		return websocket.ErrCloseSent
-	}
-	
-	return c.conn.WriteControl(
-		websocket.PingMessage,
-		[]byte{},
-		time.Now().Add(time.Second),
-	)
+	return c.write(0, 0, func() error {
+		return c.conn.WriteControl(
+			websocket.PingMessage,
+			[]byte{},
+			time.Now().Add(time.Second),
+		)
+	})
 }
\ No newline at end of file
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/yourusername/secure-messaging-poc/internal/directory"
+)
+
+// WithDirectory enables the channel directory
+func WithDirectory(d *directory.Directory) Option {
+	return func(s *Server) {
+		s.directory = d
+	}
+}
+
+// maxDirectoryRequestSize bounds a listing with its JSON encoding
+const maxDirectoryRequestSize = directory.MaxDescriptorSize * 2
+
+// directoryListing is the body of a directory publish
+type directoryListing struct {
+	Tag        string `json:"tag"`
+	Descriptor []byte `json:"descriptor"`
+	Nonce      []byte `json:"nonce"`
+	MAC        []byte `json:"mac"`
+}
+
+// handleDirectory serves the channel directory: GET lists the listings
+// under ?tag= a page at a time, POST files or replaces the caller's listing
+// under a tag and DELETE removes it
+func (s *Server) handleDirectory(w http.ResponseWriter, r *http.Request) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		http.Error(w, "Client certificate required", http.StatusUnauthorized)
+		return
+	}
+	certID := s.certificateID(r.TLS.PeerCertificates[0])
+	query := r.URL.Query()
+
+	switch r.Method {
+	case http.MethodGet:
+		limit, _ := strconv.Atoi(query.Get("limit"))
+		listings, next, err := s.directory.List(query.Get("tag"), query.Get("cursor"), limit)
+		if err != nil {
+			httpError(w, err, "Failed to list directory")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"listings":    listings,
+			"next_cursor": next,
+		})
+
+	case http.MethodPost:
+		var req directoryListing
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		listing, err := s.directory.Publish(certID, req.Tag, req.Descriptor, req.Nonce, req.MAC)
+		if err != nil {
+			httpError(w, err, "Failed to publish listing")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(listing)
+
+	case http.MethodDelete:
+		if err := s.directory.Remove(certID, query.Get("tag")); err != nil {
+			httpError(w, err, "Failed to remove listing")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
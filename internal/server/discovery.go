@@ -0,0 +1,140 @@
+package server
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/bits"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/yourusername/secure-messaging-poc/internal/ratelimit"
+)
+
+// WithDiscoveryListener serves an unauthenticated, rate-limited discovery
+// document on a separate listener that does not request client certificates
+func WithDiscoveryListener(address string, limiter ratelimit.Limiter) Option {
+	return func(s *Server) {
+		s.discoveryAddress = address
+		s.discoveryLimiter = limiter
+	}
+}
+
+// WithDiscoveryTLS sets the discovery listener's TLS policy instead of
+// deriving one from the main listener without client authentication
+func WithDiscoveryTLS(tlsConfig *tls.Config) Option {
+	return func(s *Server) {
+		s.discoveryTLS = tlsConfig
+	}
+}
+
+// newDiscoveryServer builds the HTTP server for the discovery listener
+func (s *Server) newDiscoveryServer() *http.Server {
+	// Same server identity, but clients are not asked for a certificate
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS13}
+	if s.tlsConfig != nil {
+		tlsConfig = s.tlsConfig.Clone()
+		tlsConfig.ClientAuth = tls.NoClientCert
+		tlsConfig.ClientCAs = nil
+		tlsConfig.VerifyPeerCertificate = nil
+		tlsConfig.GetConfigForClient = nil
+	}
+	if s.discoveryTLS != nil {
+		tlsConfig = s.discoveryTLS
+	}
+
+	mux := http.NewServeMux()
+	s.route(mux, "/api/discovery", noRequestBody, s.handleDiscovery, http.MethodGet)
+	s.route(mux, "/api/revocations", noRequestBody, s.handleRevocations, http.MethodGet)
+	s.route(mux, "/health", noRequestBody, s.handleHealth, http.MethodGet)
+	if s.bulletin != nil {
+		s.routeBulletin(mux)
+	}
+	if s.warrant != nil {
+		s.route(mux, warrantCanaryPath, noRequestBody, s.handleWarrantCanary, http.MethodGet)
+	}
+
+	return &http.Server{
+		Addr:              s.discoveryAddress,
+		Handler:           traceHTTP(s.recoverHTTP(mux)),
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+}
+
+// handleDiscovery returns the parameters a prospective client needs before enrolling
+func (s *Server) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	if s.discoveryLimiter != nil {
+		key := remoteHost(r)
+		if !s.discoveryLimiter.Allow(key) {
+			if ra, ok := s.discoveryLimiter.(interface{ RetryAfter(string) time.Duration }); ok {
+				seconds := int(ra.RetryAfter(key).Seconds()) + 1
+				w.Header().Set("Retry-After", strconv.Itoa(seconds))
+			}
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+	}
+
+	mask := s.binManager.GetCurrentMask()
+	certRequired := s.requiresClientCert()
+
+	info := map[string]interface{}{
+		"version":                 "0.1.0",
+		"bin_mask":                fmt.Sprintf("0x%X", mask),
+		"bin_mask_bits":           bits.OnesCount64(mask),
+		"bin_mask_semantics":      "bin_id = channel_id & bin_mask",
+		"message_retention_hours": s.binManager.GetRetentionHours(),
+		"enrollment": map[string]interface{}{
+			"client_certificate_required": certRequired,
+			// Without a certificate to present, only an invite or an
+			// existing certificate's referral leads to one
+			"referral_required":    certRequired,
+			"automated_enrollment": s.enrollmentMgr != nil,
+		},
+		"pow_difficulty": s.newBinPoW, // Leading zero bits for messages that create a bin
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=60")
+	json.NewEncoder(w).Encode(info)
+}
+
+// requiresClientCert reports whether the main listener refuses clients
+// that present no certificate, so a certificate cannot be requested
+// without one
+func (s *Server) requiresClientCert() bool {
+	if s.tlsConfig == nil {
+		return false
+	}
+	switch s.tlsConfig.ClientAuth {
+	case tls.RequireAnyClientCert, tls.RequireAndVerifyClientCert:
+		return true
+	}
+	return false
+}
+
+// startDiscovery runs the discovery listener until it is shut down
+func (s *Server) startDiscovery() {
+	log.Printf("Starting discovery listener on %s", s.discoveryAddress)
+	listener, err := s.listen(s.discoveryAddress)
+	if err != nil {
+		log.Printf("Discovery listener failed: %v", err)
+		return
+	}
+	if err := s.discoveryServer.ServeTLS(s.wrapListener(listener), "", ""); err != nil && err != http.ErrServerClosed {
+		log.Printf("Discovery listener failed: %v", err)
+	}
+}
+
+// remoteHost returns the client IP without the port
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
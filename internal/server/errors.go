@@ -0,0 +1,127 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/yourusername/secure-messaging-poc/internal/authz"
+	"github.com/yourusername/secure-messaging-poc/internal/binmanager"
+	"github.com/yourusername/secure-messaging-poc/internal/bulletin"
+	"github.com/yourusername/secure-messaging-poc/internal/certmanager"
+	"github.com/yourusername/secure-messaging-poc/internal/directory"
+	"github.com/yourusername/secure-messaging-poc/internal/features"
+	"github.com/yourusername/secure-messaging-poc/internal/keystore"
+	"github.com/yourusername/secure-messaging-poc/internal/push"
+	"github.com/yourusername/secure-messaging-poc/internal/trace"
+	"github.com/yourusername/secure-messaging-poc/internal/warrant"
+	"github.com/yourusername/secure-messaging-poc/pkg/protocol"
+)
+
+// errorStatuses maps the errors of the internal packages to the HTTP status
+// every endpoint reports them with. Errors are matched with errors.Is, so
+// wrapped errors map like the sentinel they wrap.
+var errorStatuses = []struct {
+	err    error
+	status int
+}{
+	// Requests that are malformed whoever sends them
+	{keystore.ErrInvalidCertID, http.StatusBadRequest},
+	{keystore.ErrInvalidSlot, http.StatusBadRequest},
+	{keystore.ErrInvalidNonce, http.StatusBadRequest},
+	{keystore.ErrUnknownFormat, http.StatusBadRequest},
+	{keystore.ErrAuthenticationFailed, http.StatusBadRequest},
+	{certmanager.ErrInvalidCSR, http.StatusBadRequest},
+	{certmanager.ErrUnsupportedKey, http.StatusBadRequest},
+	{certmanager.ErrInvalidPseudonym, http.StatusBadRequest},
+	{certmanager.ErrSelfReferral, http.StatusBadRequest},
+	{certmanager.ErrInvalidFingerprint, http.StatusBadRequest},
+	{certmanager.ErrInvalidRevocationList, http.StatusBadRequest},
+	{certmanager.ErrNotCA, http.StatusBadRequest},
+	{certmanager.ErrGraphVersion, http.StatusBadRequest},
+	{certmanager.ErrGraphFormat, http.StatusBadRequest},
+	{binmanager.ErrInvalidSnapshot, http.StatusBadRequest},
+	{binmanager.ErrRetentionOutOfBounds, http.StatusBadRequest},
+	{directory.ErrInvalidTag, http.StatusBadRequest},
+	{directory.ErrInvalidListing, http.StatusBadRequest},
+	{push.ErrInvalidRegistration, http.StatusBadRequest},
+	{push.ErrInvalidToken, http.StatusBadRequest},
+	{binmanager.ErrArchiveFormat, http.StatusBadRequest},
+	{binmanager.ErrArchiveCorrupt, http.StatusBadRequest},
+	{binmanager.ErrArchiveTruncated, http.StatusBadRequest},
+	{features.ErrInvalidFlag, http.StatusBadRequest},
+	{warrant.ErrInvalidCanary, http.StatusBadRequest},
+
+	// Credentials that do not authenticate the caller
+	{keystore.ErrGrantInvalid, http.StatusUnauthorized},
+	{keystore.ErrGrantExpired, http.StatusUnauthorized},
+
+	// Callers that are known but not allowed
+	{keystore.ErrAccessDenied, http.StatusForbidden},
+	{certmanager.ErrCertificateRevoked, http.StatusForbidden},
+	{certmanager.ErrReferrerRevoked, http.StatusForbidden},
+	{certmanager.ErrReferrerUnknown, http.StatusForbidden},
+	{certmanager.ErrReferrerExpired, http.StatusForbidden},
+	{certmanager.ErrFingerprintDenied, http.StatusForbidden},
+	{certmanager.ErrPseudonymNotAllowed, http.StatusForbidden},
+	{certmanager.ErrInvalidInvite, http.StatusForbidden},
+	{certmanager.ErrChallengeFailed, http.StatusForbidden},
+	{certmanager.ErrGraphSignature, http.StatusForbidden},
+	{warrant.ErrBadSignature, http.StatusForbidden},
+	{binmanager.ErrRetentionNotOwner, http.StatusForbidden},
+	{binmanager.ErrNotBinOwner, http.StatusForbidden},
+	{binmanager.ErrRetentionOverrideDisabled, http.StatusForbidden},
+	{authz.ErrForbidden, http.StatusForbidden},
+	{authz.ErrRejected, http.StatusForbidden},
+
+	// Things that do not exist
+	{keystore.ErrKeyNotFound, http.StatusNotFound},
+	{keystore.ErrKeyUnavailable, http.StatusNotFound},
+	{certmanager.ErrOrderNotFound, http.StatusNotFound},
+	{certmanager.ErrFingerprintNotFound, http.StatusNotFound},
+	{certmanager.ErrAnchorNotFound, http.StatusNotFound},
+	{directory.ErrNotListed, http.StatusNotFound},
+	{push.ErrNotRegistered, http.StatusNotFound},
+	{bulletin.ErrUnknownChannel, http.StatusNotFound},
+	{bulletin.ErrIndexOutOfRange, http.StatusNotFound},
+	{features.ErrUnknownFlag, http.StatusNotFound},
+
+	// Requests that conflict with current state
+	{certmanager.ErrOrderNotReady, http.StatusConflict},
+	{keystore.ErrSlotConflict, http.StatusConflict},
+	{certmanager.ErrPinnedAnchor, http.StatusConflict},
+	{directory.ErrTagFull, http.StatusConflict},
+	{warrant.ErrNotNewer, http.StatusConflict},
+
+	{binmanager.ErrMessageTooLarge, http.StatusRequestEntityTooLarge},
+	{binmanager.ErrFieldTooLarge, http.StatusRequestEntityTooLarge},
+	{keystore.ErrQuotaExceeded, http.StatusRequestEntityTooLarge},
+	{authz.ErrRateLimited, http.StatusTooManyRequests},
+	{certmanager.ErrInviteLimit, http.StatusTooManyRequests},
+	{certmanager.ErrEnrollmentBusy, http.StatusTooManyRequests},
+	{certmanager.ErrOrderLimit, http.StatusTooManyRequests},
+	{certmanager.ErrCANotInitialized, http.StatusServiceUnavailable},
+}
+
+// errorStatus returns the HTTP status for err, or 500 for errors that are
+// not part of any package's API
+func errorStatus(err error) int {
+	for _, entry := range errorStatuses {
+		if errors.Is(err, entry.err) {
+			return entry.status
+		}
+	}
+	return http.StatusInternalServerError
+}
+
+// httpError writes err with its status. Errors without a mapping are
+// logged under the request's trace ID and reported only as msg, so internal
+// details stay on the server.
+func httpError(w http.ResponseWriter, err error, msg string) {
+	status := errorStatus(err)
+	if status == http.StatusInternalServerError {
+		trace.Printf(w.Header().Get(protocol.TraceHeader), "%s: %v", msg, err)
+		http.Error(w, msg, status)
+		return
+	}
+	http.Error(w, err.Error(), status)
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/yourusername/secure-messaging-poc/internal/authz"
+	"github.com/yourusername/secure-messaging-poc/internal/binmanager"
+	"github.com/yourusername/secure-messaging-poc/internal/features"
+)
+
+// Feature flags the server gates behaviors with. A behavior whose flag is
+// not declared runs for every certificate, as it did before it had a flag.
+const (
+	// FeatureBinCreationPoW gates the proof of work WithBinCreationPoW
+	// requires for messages that create a bin
+	FeatureBinCreationPoW = "bin_creation_pow"
+	// FeatureEphemeralSignatures gates the session signing keys
+	// WithEphemeralSigning accepts
+	FeatureEphemeralSignatures = "ephemeral_signatures"
+)
+
+// FeatureFlags lists every flag the server knows, for features.NewSet
+var FeatureFlags = []string{FeatureBinCreationPoW, FeatureEphemeralSignatures}
+
+// WithFeatures rolls out gated behaviors by the flags in set, which admins
+// can override at runtime. Flags are evaluated by certificate ID and by the
+// certificate's bandwidth class when a connection subscribes or publishes.
+func WithFeatures(set *features.Set) Option {
+	return func(s *Server) {
+		s.features = set
+	}
+}
+
+// featureEnabled reports whether the behavior gated by the named flag runs
+// for certID
+func (s *Server) featureEnabled(name, certID string) bool {
+	flag, declared := s.features.Lookup(name)
+	if !declared {
+		return true
+	}
+	return flag.Enabled(features.Subject{CertID: certID, Class: s.certificateClass(certID)})
+}
+
+// gatedPublishAuthorizer consults an authorizer only for the certificates a
+// feature flag is enabled for
+type gatedPublishAuthorizer struct {
+	server *Server
+	flag   string
+	authz.PublishAuthorizer
+}
+
+// AuthorizePublish runs the wrapped authorizer if the flag is enabled
+func (g gatedPublishAuthorizer) AuthorizePublish(ctx context.Context, info authz.CertInfo, msg *binmanager.Message) error {
+	if !g.server.featureEnabled(g.flag, info.CertID) {
+		return nil
+	}
+	return g.PublishAuthorizer.AuthorizePublish(ctx, info, msg)
+}
+
+// handleAdminFeatures lists the known feature flags (GET), overrides one
+// with the flag in the body (POST) or resets the override of the flag
+// given by the name parameter (DELETE)
+func (s *Server) handleAdminFeatures(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"flags": s.features.States(),
+		})
+
+	case http.MethodPost:
+		var flag features.Flag
+		if err := json.NewDecoder(r.Body).Decode(&flag); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if err := s.features.Override(flag); err != nil {
+			httpError(w, err, "Failed to override feature flag")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(flag)
+
+	case http.MethodDelete:
+		if err := s.features.Reset(r.URL.Query().Get("name")); err != nil {
+			httpError(w, err, "Failed to reset feature flag")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": "success",
+		})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/yourusername/secure-messaging-poc/internal/binmanager"
+)
+
+// ErrorCode identifies an application-level WebSocket failure. Codes are in
+// the 4000-4999 range reserved for applications, so a fatal error uses the
+// same number as its close code.
+type ErrorCode int
+
+// WebSocket error and close codes
+const (
+	ErrBadRequest          ErrorCode = 4000 // Frame could not be parsed
+	ErrBadSubscribe        ErrorCode = 4001 // First frame was not a valid subscribe
+	ErrMessageTooLarge     ErrorCode = 4002 // Message or opaque field exceeds limits
+	ErrCertificateRevoked  ErrorCode = 4003 // Client certificate revoked mid-session
+	ErrReferrerRevoked     ErrorCode = 4004 // Referrer certificate revoked mid-session
+	ErrForbidden           ErrorCode = 4005 // Certificate may not use the requested mode
+	ErrCertificateBlocked  ErrorCode = 4006 // Certificate key denylisted mid-session
+	ErrPublishDenied       ErrorCode = 4007 // Certificate may not publish to the bin
+	ErrPolicyRejected      ErrorCode = 4008 // Message does not meet the publish policy
+	ErrSubscribeDenied     ErrorCode = 4009 // Certificate may not subscribe to the bins
+	ErrProofOfWorkRequired ErrorCode = 4010 // Creating the bin needs a proof of work
+	ErrRetentionDenied     ErrorCode = 4011 // Bin retention cannot be set as requested
+	ErrCertificateExpired  ErrorCode = 4012 // Client certificate expired mid-session
+	ErrUnknownSession      ErrorCode = 4013 // Frame names a session that is not open
+	ErrSessionLimit        ErrorCode = 4014 // Connection has too many open sessions
+	ErrUpgradeRequired     ErrorCode = 4015 // Client protocol version below the minimum
+	ErrInvalidSignature    ErrorCode = 4016 // Publish not signed by the session's ephemeral key
+	ErrMaskChanged         ErrorCode = 4017 // Publish bin computed with an outdated mask
+	ErrRateLimited         ErrorCode = 4029 // Publish rate exceeded
+	ErrInternal            ErrorCode = 4500 // Server failed to process the frame
+)
+
+// errorCatalogue holds the default message and retry semantics of each code
+var errorCatalogue = map[ErrorCode]struct {
+	message   string
+	retryable bool
+}{
+	ErrBadRequest:          {"malformed frame", false},
+	ErrBadSubscribe:        {"expected subscribe frame", false},
+	ErrMessageTooLarge:     {"message exceeds size limits", false},
+	ErrCertificateRevoked:  {"certificate has been revoked", false},
+	ErrReferrerRevoked:     {"referrer certificate has been revoked", false},
+	ErrForbidden:           {"operation requires an admin certificate", false},
+	ErrCertificateBlocked:  {"certificate has been blocked", false},
+	ErrPublishDenied:       {"publishing to this bin is not permitted", false},
+	ErrPolicyRejected:      {"message rejected by publish policy", false},
+	ErrSubscribeDenied:     {"subscription not permitted", false},
+	ErrProofOfWorkRequired: {"proof of work required to create a bin", true},
+	ErrRetentionDenied:     {"requested bin retention not permitted", false},
+	ErrCertificateExpired:  {"certificate has expired; renew and reconnect", false},
+	ErrUnknownSession:      {"no such session on this connection", false},
+	ErrSessionLimit:        {"too many sessions on this connection", false},
+	ErrUpgradeRequired:     {"client protocol version is no longer supported; see /api/client-update", false},
+	ErrInvalidSignature:    {"message signature does not match the session's signing key", false},
+	ErrMaskChanged:         {"bin mask changed; recompute the bin from the channel and publish again", true},
+	ErrRateLimited:         {"publish rate limit exceeded", true},
+	ErrInternal:            {"internal server error", true},
+}
+
+// ErrorFrame is sent to the client on every WebSocket failure path
+type ErrorFrame struct {
+	Type       string    `json:"type"`
+	Code       ErrorCode `json:"code"`
+	Message    string    `json:"message"`
+	Retryable  bool      `json:"retryable"`
+	RetryAfter int       `json:"retry_after,omitempty"` // Seconds
+	Difficulty int       `json:"difficulty,omitempty"`  // Proof-of-work bits to resend with
+	MessageID  string    `json:"message_id,omitempty"`  // Rejected publish
+	SessionID  string    `json:"session_id,omitempty"`  // Session of a multiplexed connection
+	BinMask    string    `json:"bin_mask,omitempty"`    // Current mask, when the publish used an outdated one
+	MaskEpoch  uint64    `json:"mask_epoch,omitempty"`
+	TraceID    string    `json:"trace_id,omitempty"` // Trace of the connection, for reports
+}
+
+// PublishAck confirms a publish that asked for an acknowledgement. Durable
+// is set once a durable store has synced the message; without one, an ack
+// for a durable request comes after in-memory accept with Durable unset.
+type PublishAck struct {
+	Type      string `json:"type"`
+	MessageID string `json:"message_id"`
+	BinID     uint64 `json:"bin_id"`
+	Durable   bool   `json:"durable"`
+	Duplicate bool   `json:"duplicate,omitempty"` // Already accepted earlier
+	BinMask   string `json:"bin_mask"`            // Mask BinID was normalized with
+	MaskEpoch uint64 `json:"mask_epoch"`
+	SessionID string `json:"session_id,omitempty"` // Session of a multiplexed connection
+	Timestamp string `json:"timestamp,omitempty"`
+
+	// Live subscribers reached, rounded down to the delivery count threshold
+	DeliveredAtLeast int `json:"delivered_at_least,omitempty"`
+}
+
+// Presence tells a bin's subscribers how many sessions it has, rounded down
+// to the presence threshold; none is sent below it
+type Presence struct {
+	Type        string `json:"type"`
+	BinID       uint64 `json:"bin_id"`
+	Subscribers int    `json:"subscribers_at_least"`
+	SessionID   string `json:"session_id,omitempty"` // Session of a multiplexed connection
+	Timestamp   string `json:"timestamp"`
+}
+
+// newErrorFrame builds an error frame from the catalogue
+func newErrorFrame(code ErrorCode) ErrorFrame {
+	entry := errorCatalogue[code]
+	return ErrorFrame{
+		Type:      "error",
+		Code:      code,
+		Message:   entry.message,
+		Retryable: entry.retryable,
+	}
+}
+
+// withRetryAfter sets the retry hint, rounding up to whole seconds
+func (f ErrorFrame) withRetryAfter(d time.Duration) ErrorFrame {
+	if d > 0 {
+		f.RetryAfter = int((d + time.Second - 1) / time.Second)
+	}
+	return f
+}
+
+// withDifficulty sets the proof-of-work difficulty the client must meet
+func (f ErrorFrame) withDifficulty(bits int) ErrorFrame {
+	f.Difficulty = bits
+	return f
+}
+
+// withMask names the current bin mask a publish must be recomputed with
+func (f ErrorFrame) withMask(mask binmanager.MaskState) ErrorFrame {
+	f.BinMask = fmt.Sprintf("0x%X", mask.Mask)
+	f.MaskEpoch = mask.Epoch
+	return f
+}
+
+// withMessageID ties the error to the publish it rejects
+func (f ErrorFrame) withMessageID(messageID string) ErrorFrame {
+	f.MessageID = messageID
+	return f
+}
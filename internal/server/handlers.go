@@ -1,18 +1,23 @@
 package server
 
 import (
+	"context"
 	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
-	"log"
+	"io"
 	"net/http"
-	"sync"
 	"time"
 
-	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
-	"github.com/yourusername/secure-messaging-poc/internal/binmanager"
+	"github.com/yourusername/secure-messaging-poc/internal/authz"
+	"github.com/yourusername/secure-messaging-poc/internal/certmanager"
+	"github.com/yourusername/secure-messaging-poc/internal/legalhold"
+	"github.com/yourusername/secure-messaging-poc/internal/trace"
+	cryptopkg "github.com/yourusername/secure-messaging-poc/pkg/crypto"
+	"github.com/yourusername/secure-messaging-poc/pkg/plugin"
+	"github.com/yourusername/secure-messaging-poc/pkg/protocol"
 )
 
 // handleServerInfo returns server information including the current bin mask
@@ -20,18 +25,78 @@ func (s *Server) handleServerInfo(w http.ResponseWriter, r *http.Request) {
 	// Extract client certificate info for logging
 	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
 		cert := r.TLS.PeerCertificates[0]
-		log.Printf("Server info requested by: %s", cert.Subject.CommonName)
+		trace.Logf(r.Context(), "Server info requested by: %s", cert.Subject.CommonName)
 	}
 
 	// Prepare response
+	mask := s.binManager.MaskState()
 	info := map[string]interface{}{
-		"bin_mask":        fmt.Sprintf("0x%X", s.binManager.GetCurrentMask()),
+		"bin_mask":        fmt.Sprintf("0x%X", mask.Mask),
+		"mask_epoch":      mask.Epoch,
 		"version":         "0.1.0",
 		"timestamp":       time.Now().Format(time.RFC3339),
 		"message_retention_hours": s.binManager.GetRetentionHours(),
+		"pow_difficulty":  s.newBinPoW, // Leading zero bits for messages that create a bin
+	}
+	if min, max := s.binManager.RetentionBounds(); max > 0 {
+		// Bounds for the retention a bin's creator may request, in seconds
+		info["bin_retention_bounds"] = []int64{int64(min / time.Second), int64(max / time.Second)}
+	}
+	if s.directory != nil {
+		info["directory"] = true
+	}
+	// How messages and logs are kept, with the retention actually enforced
+	// and the ceiling this build was built with
+	hold := s.legalHold
+	hold.Retention = s.binManager.Retention()
+	info["legal_hold"] = legalhold.Assert(hold)
+	if s.warrant != nil {
+		info["warrant_canary"] = s.warrant.Status(time.Now())
+	}
+	s.describeInstance(info)
+	if len(s.advertised) > 0 {
+		// Clients race these in order, IPv6 first; signing them keeps a
+		// proxy from steering clients to other endpoints
+		info["addresses"] = s.advertised
+	}
+	if s.clientUpdate != nil {
+		// Subscribes announcing an older protocol_version are refused
+		info["min_protocol_version"] = s.clientUpdate.MinProtocolVersion
+	}
+	if s.timestampGranularity > 0 {
+		// Delivered timestamps are coarsened to this many seconds
+		info["timestamp_granularity"] = s.timestampGranularity.Seconds()
+		info["timestamp_jitter"] = s.jitterKey != nil
+	}
+	if s.onion != nil {
+		// Clients may move to the onion endpoint; signing the address below
+		// keeps a clearnet proxy from pointing them elsewhere
+		info["onion_address"] = s.onion.address
+		info["onion_port"] = s.onion.port
+		if s.onion.altSvc != "" {
+			info["alt_svc"] = s.onion.altSvc
+		}
 	}
 
+	// Sign the parameters so clients behind proxies or mirrors can detect
+	// tampering; the JWS payload is authoritative, the plain fields remain
+	// for clients that do not verify
+	payload, err := json.Marshal(info)
+	if err != nil {
+		http.Error(w, "Failed to encode server info", http.StatusInternalServerError)
+		return
+	}
+	signedInfo, err := s.certAuthority.SignJWS(payload)
+	if err != nil {
+		http.Error(w, "Failed to sign server info", http.StatusInternalServerError)
+		return
+	}
+	info["signed_info"] = signedInfo
+
 	// Send response
+	if s.onion != nil && s.onion.altSvc != "" {
+		w.Header().Set("Alt-Svc", s.onion.altSvc)
+	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(info)
 }
@@ -45,138 +110,219 @@ func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 	}
 
 	cert := r.TLS.PeerCertificates[0]
-	certID := cert.SerialNumber.String()
+	certID := s.certificateID(cert)
 
 	// Extract certificate info
 	certInfo := certmanager.GetCertificateInfo(cert)
-	log.Printf("WebSocket connection from certificate: %s", certID)
+	referrerID, _ := certInfo["referrer_id"].(string)
+	ctx := r.Context()
+	trace.Logf(ctx, "WebSocket connection from certificate: %s", certID)
 
 	// Upgrade connection to WebSocket
-	conn, err := s.websocketUpgrader.Upgrade(w, r, nil)
+	conn, err := s.websocketUpgrader.Upgrade(w, r, s.upgradeHeader(trace.FromContext(ctx)))
 	if err != nil {
-		log.Printf("Failed to upgrade connection: %v", err)
+		trace.Logf(ctx, "Failed to upgrade connection: %v", err)
 		return
 	}
 
+	// Frames larger than this are rejected by the WebSocket layer itself
+	if s.maxMessageSize > 0 {
+		conn.SetReadLimit(frameReadLimit(s.maxMessageSize))
+	}
+
 	// Create client
 	client := s.RegisterClient(conn, certInfo)
+	client.trace = trace.FromContext(ctx)
 	defer client.Close()
-
-	// Handle subscription request
-	var subscriptionMsg struct {
-		Type      string   `json:"type"`
-		BinIDs    []uint64 `json:"bin_ids"`
-		ClientID  string   `json:"client_id"`
-	}
+	s.connections.Add(1)
+	defer s.connections.Add(-1)
+	defer s.recoverConnection(client)
+	s.plugins.Emit(plugin.Event{Type: plugin.ConnectionOpened, CertID: certID, Connection: client.connection})
+	defer s.plugins.Emit(plugin.Event{Type: plugin.ConnectionClosed, CertID: certID, Connection: client.connection})
 
 	// Wait for subscription message
-	if err := conn.ReadJSON(&subscriptionMsg); err != nil {
-		log.Printf("Error reading subscription message: %v", err)
+	var first subscribeFrame
+	_, data, err := conn.ReadMessage()
+	if err == nil {
+		client.recordFrame(captureIn, data)
+		err = json.Unmarshal(data, &first)
+	}
+	if err != nil {
+		trace.Logf(ctx, "Error reading subscription message: %v", err)
+		client.CloseWithError(newErrorFrame(ErrBadSubscribe))
 		return
 	}
 
-	if subscriptionMsg.Type != "subscribe" {
-		log.Printf("Expected subscribe message, got %s", subscriptionMsg.Type)
+	if first.Type != frameSubscribe {
+		trace.Logf(ctx, "Expected subscribe message, got %s", first.Type)
+		client.CloseWithError(newErrorFrame(ErrBadSubscribe))
 		return
 	}
 
-	// Generate client ID if not provided
-	clientID := subscriptionMsg.ClientID
-	if clientID == "" {
-		clientID = uuid.New().String()
+	// Clients too old to speak the current protocol are told to upgrade
+	if s.clientUpdate != nil && s.clientUpdate.UpgradeRequired(first.ProtocolVersion) {
+		client.CloseWithError(newErrorFrame(ErrUpgradeRequired))
+		return
 	}
 
-	// Subscribe to bins
-	var wg sync.WaitGroup
-	for _, binID := range subscriptionMsg.BinIDs {
-		binID := binID // Capture for goroutine
-		
-		// Subscribe to bin
-		s.binManager.Subscribe(binID, clientID, client)
-		
-		// Get recent messages
-		recentMessages := s.binManager.GetRecentMessages(binID)
-		
-		// Send recent messages
-		for _, msg := range recentMessages {
-			if err := conn.WriteJSON(msg); err != nil {
-				log.Printf("Error sending recent message: %v", err)
-				return
-			}
-		}
+	identity := authz.CertInfo{
+		CertID:      certID,
+		ReferrerID:  referrerID,
+		Admin:       s.adminIDs[certID],
+		Certificate: cert,
 	}
 
-	// Acknowledge subscription
-	ack := map[string]interface{}{
-		"type":      "subscribe_ack",
-		"client_id": clientID,
-		"bin_count": len(subscriptionMsg.BinIDs),
-		"timestamp": time.Now().Format(time.RFC3339),
+	// Naming the first session makes the connection multiplexed
+	sessions := newSessionSet(client, first.SessionID != "")
+	defer func() {
+		for _, sess := range sessions.drain() {
+			s.closeSession(sess)
+		}
+	}()
+	refused, err := s.openSession(ctx, sessions, identity, first)
+	if refused != nil {
+		client.CloseWithError(*refused)
+		return
 	}
-	if err := conn.WriteJSON(ack); err != nil {
-		log.Printf("Error sending subscription ack: %v", err)
+	if err != nil {
+		trace.Logf(ctx, "Error opening session: %v", err)
 		return
 	}
 
 	// Start a goroutine to handle incoming messages
+	ctx = context.WithValue(ctx, identityKey{}, identity)
+	done := make(chan struct{})
 	go func() {
+		defer close(done)
+		defer client.Close()
+		defer s.recoverConnection(client)
+
 		for {
-			var msg binmanager.Message
-			if err := conn.ReadJSON(&msg); err != nil {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
 				if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-					log.Printf("WebSocket error: %v", err)
+					trace.Logf(ctx, "WebSocket error: %v", err)
 				}
-				break
+				return
 			}
+			client.recordFrame(captureIn, data)
 
-			// Process message
-			s.binManager.AddMessage(&msg)
-		}
+			// Frames of a multiplexed connection are routed by session
+			sess := sessions.get("")
+			if sessions.multiplexed {
+				if sess, err = s.handleSessionFrame(ctx, sessions, identity, data); err != nil {
+					return
+				}
+				if sess == nil {
+					continue
+				}
+			}
 
-		// Unsubscribe from all bins when connection closes
-		for _, binID := range subscriptionMsg.BinIDs {
-			s.binManager.Unsubscribe(binID, clientID)
+			// Validate, authorize, store and broadcast
+			if s.publish(ctx, sess, certID, data) {
+				s.published.Inc()
+			}
 		}
-		
-		// Close client
-		client.Close()
 	}()
-	
-	// Start periodic dummy messages if needed (fixed-size interval)
-	ticker := time.NewTicker(10 * time.Second)
-	defer ticker.Stop()
-	
+
+	// Ping and re-check revocation at a jittered interval
+	keepalive := time.NewTimer(s.keepaliveInterval())
+	defer keepalive.Stop()
+
+	// Expiry is only checked at the handshake, so end the session when the
+	// certificate lapses; the client renews and reconnects
+	expiry := time.NewTimer(time.Until(cert.NotAfter))
+	defer expiry.Stop()
+
 	// Keep connection alive until closed
 	for {
 		select {
-		case <-ticker.C:
+		case <-done:
+			return
+		case <-expiry.C:
+			client.CloseWithError(newErrorFrame(ErrCertificateExpired))
+			return
+		case <-keepalive.C:
+			// Sessions outlive the handshake, so revocation is re-checked here
+			if s.revocationMgr.IsRevoked(certID) {
+				client.CloseWithError(newErrorFrame(ErrCertificateRevoked))
+				return
+			}
+			if referrerID != "" && !s.readOnlyRevokedReferrers && s.revocationMgr.IsRevoked(referrerID) {
+				client.CloseWithError(newErrorFrame(ErrReferrerRevoked))
+				return
+			}
+			if s.fingerprints != nil && s.fingerprints.Check(cert) != nil {
+				client.CloseWithError(newErrorFrame(ErrCertificateBlocked))
+				return
+			}
+
 			// Check if connection is still alive
-			if err := conn.WriteControl(websocket.PingMessage, []byte{}, time.Now().Add(time.Second)); err != nil {
-				log.Printf("Ping error: %v", err)
+			if err := client.SendPing(); err != nil {
+				trace.Logf(ctx, "Ping error: %v", err)
 				return
 			}
+			keepalive.Reset(s.keepaliveInterval())
 		}
 	}
+}
 
-	// Unsubscribe from all bins when connection closes
-	for _, binID := range subscriptionMsg.BinIDs {
-		s.binManager.Unsubscribe(binID, clientID)
+// maxPrefixSubscriptions bounds the bin ranges in one subscribe frame
+const maxPrefixSubscriptions = 64
+
+// filterBinIDs masks subscribed bin IDs with mask, dropping the duplicates
+// that an outdated mask can produce, and rejects bins that were not
+// computed with mask or with the mask before its last contraction. At most
+// perSubscribe bins are accepted, and at most remaining for the connection;
+// zero disables the first limit and a negative remaining the second.
+func filterBinIDs(binIDs []uint64, mask uint64, perSubscribe, remaining int) ([]uint64, []protocol.RejectedBin) {
+	var rejected []protocol.RejectedBin
+	seen := make(map[uint64]bool, len(binIDs))
+	accepted := make([]uint64, 0, len(binIDs))
+	for _, binID := range binIDs {
+		reason := ""
+		switch {
+		case !conformsToMask(binID, mask):
+			reason = protocol.RejectInvalidBin
+		case seen[binID&mask]:
+			continue
+		case perSubscribe > 0 && len(accepted) >= perSubscribe:
+			reason = protocol.RejectSubscribeLimit
+		case remaining >= 0 && len(accepted) >= remaining:
+			reason = protocol.RejectConnectionLimit
+		}
+		if reason != "" {
+			rejected = append(rejected, protocol.RejectedBin{BinID: binID, Reason: reason})
+			continue
+		}
+		seen[binID&mask] = true
+		accepted = append(accepted, binID&mask)
 	}
+	return accepted, rejected
+}
+
+// conformsToMask reports whether binID has no bits outside mask, allowing
+// the bit the last contraction removed since clients learn of mask changes
+// only when they next reconnect
+func conformsToMask(binID, mask uint64) bool {
+	lowest := mask & -mask
+	return binID&^(mask|lowest>>1) == 0
+}
+
+// frameReadLimit bounds a whole frame for a given ciphertext limit, allowing
+// for base64 expansion and the JSON envelope
+func frameReadLimit(maxMessageSize int) int64 {
+	return int64(maxMessageSize)*4/3 + 4096
 }
 
 // handleCertificateRequest handles certificate signing requests
 func (s *Server) handleCertificateRequest(w http.ResponseWriter, r *http.Request) {
 	// Only accept POST requests
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
 	// Verify client has a valid certificate for referral
 	var referrerID string
 	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
 		cert := r.TLS.PeerCertificates[0]
-		referrerID = cert.SerialNumber.String()
+		referrerID = s.certificateID(cert)
 		
 		// Check if referrer certificate is revoked
 		if s.revocationMgr.IsRevoked(referrerID) {
@@ -189,9 +335,14 @@ func (s *Server) handleCertificateRequest(w http.ResponseWriter, r *http.Request
 		referrerID = ""
 	}
 
-	// Read request body
-	body, err := ioutil.ReadAll(r.Body)
+	// Read request body, bounded here as well as by the route
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxCSRSize))
 	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "CSR too large", http.StatusRequestEntityTooLarge)
+			return
+		}
 		http.Error(w, "Error reading request", http.StatusBadRequest)
 		return
 	}
@@ -207,27 +358,22 @@ func (s *Server) handleCertificateRequest(w http.ResponseWriter, r *http.Request
 	validityDays := 90 // 3 months
 	cert, err := s.certAuthority.SignCSR(csr, referrerID, validityDays)
 	if err != nil {
-		http.Error(w, "Failed to sign CSR: "+err.Error(), http.StatusInternalServerError)
+		httpError(w, err, "Failed to sign CSR")
 		return
 	}
+	s.issued.Inc()
 
 	// Register certificate in revocation manager
-	certID := cert.SerialNumber.String()
-	s.revocationMgr.RegisterCertificate(certID, referrerID)
+	certID := s.certificateID(cert)
+	s.revocationMgr.RegisterCertificateUntil(certID, referrerID, cert.NotAfter)
 
-	// Return the signed certificate
-	w.Header().Set("Content-Type", "application/x-pem-file")
-	w.Write(cert.Raw)
+	// Return the signed certificate in the format the client asked for
+	s.writeCertificateBundle(w, r, cert, referrerID)
 }
 
 // handleCertificateRevoke handles certificate revocation requests
 func (s *Server) handleCertificateRevoke(w http.ResponseWriter, r *http.Request) {
 	// Only accept POST requests
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
 	// Verify client has a valid certificate
 	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
 		http.Error(w, "Client certificate required", http.StatusUnauthorized)
@@ -247,17 +393,15 @@ func (s *Server) handleCertificateRevoke(w http.ResponseWriter, r *http.Request)
 
 	// Get client certificate info
 	cert := r.TLS.PeerCertificates[0]
-	clientCertID := cert.SerialNumber.String()
+	clientCertID := s.certificateID(cert)
 	
-	// Only allow revocation of self or certificates referred by self
+	// Only allow revocation of self or certificates referred by self, as
+	// recorded in the referral graph when the target was issued
 	targetCertID := revokeRequest.CertificateID
-	if targetCertID != clientCertID {
-		// Check if target was referred by client
-		referrerID, err := certmanager.ExtractReferrerID(cert)
-		if err != nil || referrerID != clientCertID {
-			http.Error(w, "Unauthorized to revoke this certificate", http.StatusForbidden)
-			return
-		}
+	if !cryptopkg.ConstantTimeEqualString(targetCertID, clientCertID) &&
+		!s.revocationMgr.IsReferredBy(targetCertID, clientCertID) {
+		http.Error(w, "Unauthorized to revoke this certificate", http.StatusForbidden)
+		return
 	}
 	
 	// Revoke the certificate
@@ -273,4 +417,5 @@ func (s *Server) handleCertificateRevoke(w http.ResponseWriter, r *http.Request)
 		"status":         "success",
 		"certificate_id": targetCertID,
 		"timestamp":      time.Now().Format(time.RFC3339),
-	})
\ No newline at end of file
+	})
+}
@@ -0,0 +1,33 @@
+package server
+
+import (
+	"net"
+
+	"github.com/yourusername/secure-messaging-poc/internal/metrics"
+	"github.com/yourusername/secure-messaging-poc/internal/tlslimit"
+)
+
+// WithHandshakeLimit performs TLS handshakes on the main listener with at
+// most config.MaxConcurrent at once, queueing the rest, so a reconnect storm
+// cannot starve established connections of CPU
+func WithHandshakeLimit(config tlslimit.Config) Option {
+	return func(s *Server) {
+		s.handshakeLimit = &config
+	}
+}
+
+// serveLimited serves the main listener with handshakes done by a
+// tlslimit.Listener instead of by the HTTP server
+func (s *Server) serveLimited(listener net.Listener) error {
+	// As ServeTLS does, offer HTTP/2 unless the config says otherwise
+	tlsConfig := s.httpServer.TLSConfig.Clone()
+	if len(tlsConfig.NextProtos) == 0 {
+		tlsConfig.NextProtos = []string{"h2", "http/1.1"}
+	}
+	// Without a configured registry the metrics are kept but not exported
+	registry := s.metrics
+	if registry == nil {
+		registry = metrics.NewRegistry()
+	}
+	return s.httpServer.Serve(tlslimit.NewListener(listener, tlsConfig, *s.handshakeLimit, registry))
+}
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/yourusername/secure-messaging-poc/internal/authz"
+	"github.com/yourusername/secure-messaging-poc/internal/binmanager"
+	"github.com/yourusername/secure-messaging-poc/internal/trace"
+)
+
+// identityKey carries the publisher's authz.CertInfo through the ingestion
+// pipeline
+type identityKey struct{}
+
+// WithIngestOptions adds stages to the message ingestion pipeline, after
+// the server's own validators, authorizers and deliveries
+func WithIngestOptions(opts ...binmanager.PipelineOption) Option {
+	return func(s *Server) {
+		s.ingestOpts = append(s.ingestOpts, opts...)
+	}
+}
+
+// newIngestPipeline builds the pipeline WebSocket publishes go through: the
+// size limit, the publish authorizers, then storage, broadcast, push
+// wake-ups and bulletin logs, followed by any stages added with
+// WithIngestOptions
+func (s *Server) newIngestPipeline() *binmanager.Pipeline {
+	opts := []binmanager.PipelineOption{
+		binmanager.WithValidators(binmanager.MaxCiphertext(s.maxMessageSize)),
+		binmanager.WithAuthorizers(binmanager.AuthorizerFunc(s.authorizeIngest)),
+	}
+	if s.push != nil {
+		opts = append(opts, binmanager.WithFanouts(binmanager.FanoutFunc(func(msg *binmanager.Message) {
+			s.push.Notify(msg.BinID)
+		})))
+	}
+	if s.bulletin != nil {
+		opts = append(opts, binmanager.WithFanouts(binmanager.FanoutFunc(s.appendBulletin)))
+	}
+	return binmanager.NewPipeline(s.binManager, append(opts, s.ingestOpts...)...)
+}
+
+// authorizeIngest runs the publish authorizers for the identity in ctx and
+// applies a requested bin retention. The pipeline strips the proof of work
+// and retention fields afterwards.
+func (s *Server) authorizeIngest(ctx context.Context, msg *binmanager.Message) error {
+	identity, _ := ctx.Value(identityKey{}).(authz.CertInfo)
+	if err := s.publishAuthz.AuthorizePublish(ctx, identity, msg); err != nil {
+		return err
+	}
+	if msg.Retention != 0 {
+		claim := binmanager.RetentionClaim{CertID: identity.CertID, Proof: msg.RetentionProof}
+		retention := time.Duration(msg.Retention) * time.Second
+		if err := s.binManager.SetBinRetention(msg.BinID, retention, claim); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// publish submits a frame of a session to the ingestion pipeline, sends
+// the acknowledgement it asked for, if any, and reports whether it was
+// accepted. Rejections are reported to the client with the message ID when
+// known.
+func (s *Server) publish(ctx context.Context, sess *session, certID string, data []byte) bool {
+	client := sess.client
+	msg, err := s.ingest.Decode(data)
+	if err != nil {
+		frame := newErrorFrame(ErrBadRequest)
+		client.SendError(frame)
+		s.emitPublish(sess, certID, nil, frame.Message)
+		return false
+	}
+	if frame, ok := sess.signing.check(sess.signingKey, msg); !ok {
+		client.SendError(frame.withMessageID(msg.MessageID))
+		s.emitPublish(sess, certID, msg, frame.Message)
+		return false
+	}
+	ack := msg.Ack
+	msg.Ack = ""
+
+	var receipt binmanager.Receipt
+	switch ack {
+	case "", binmanager.AckAccepted:
+		receipt, err = s.ingest.SubmitReceipt(ctx, msg, false)
+	case binmanager.AckDurable:
+		receipt, err = s.ingest.SubmitReceipt(ctx, msg, true)
+	default:
+		frame := newErrorFrame(ErrBadRequest)
+		client.SendError(frame.withMessageID(msg.MessageID))
+		s.emitPublish(sess, certID, msg, frame.Message)
+		return false
+	}
+	duplicate := errors.Is(err, binmanager.ErrDuplicateMessage)
+	if err != nil && !(duplicate && ack != "") {
+		if frame, ok := ingestErrorFrame(ctx, certID, err); ok {
+			if frame.Code == ErrMaskChanged {
+				frame = frame.withMask(s.binManager.MaskState())
+			}
+			client.SendError(frame.withMessageID(msg.MessageID))
+			s.emitPublish(sess, certID, msg, frame.Message)
+		}
+		return false
+	}
+	if !duplicate {
+		s.emitPublish(sess, certID, msg, "")
+	}
+
+	// A retransmitted publish is acknowledged again, so the client stops
+	// retrying, but not counted
+	if ack != "" {
+		mask := s.binManager.MaskState()
+		frame := PublishAck{
+			Type:      "publish_ack",
+			MessageID: msg.MessageID,
+			BinID:     msg.BinID,
+			Durable:   receipt.Durable,
+			Duplicate: duplicate,
+			BinMask:   fmt.Sprintf("0x%X", mask.Mask),
+			MaskEpoch: mask.Epoch,
+		}
+		if !duplicate {
+			frame.Timestamp = s.exposeMessage(msg).Timestamp.Format(time.RFC3339Nano)
+			frame.DeliveredAtLeast = s.deliveryCount(receipt.Delivered)
+		}
+		client.sendPublishAck(frame)
+	}
+	return !duplicate
+}
+
+// ingestErrorFrame reports a rejected publish to the client. Duplicates are
+// dropped silently, so ok is false for them.
+func ingestErrorFrame(ctx context.Context, certID string, err error) (frame ErrorFrame, ok bool) {
+	var stageErr *binmanager.StageError
+	if !errors.As(err, &stageErr) {
+		trace.Logf(ctx, "Failed to ingest message: %v", err)
+		return newErrorFrame(ErrInternal), true
+	}
+
+	switch stageErr.Stage {
+	case binmanager.StageDecode:
+		return newErrorFrame(ErrBadRequest), true
+	case binmanager.StageValidate:
+		if errors.Is(err, binmanager.ErrStaleMask) {
+			return newErrorFrame(ErrMaskChanged), true
+		}
+		if !errors.Is(err, binmanager.ErrMessageTooLarge) {
+			trace.Logf(ctx, "Rejected message from %s: %v", certID, stageErr.Err)
+		}
+		return newErrorFrame(ErrMessageTooLarge), true
+	case binmanager.StageAuthorize:
+		return publishErrorFrame(stageErr.Err), true
+	case binmanager.StageDedup:
+		return ErrorFrame{}, false
+	default:
+		if errors.Is(err, binmanager.ErrStaleMask) {
+			// The mask gained bits while the message was in the pipeline
+			return newErrorFrame(ErrMaskChanged), true
+		}
+		trace.Logf(ctx, "Failed to store message: %v", stageErr.Err)
+		return newErrorFrame(ErrInternal), true
+	}
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/yourusername/secure-messaging-poc/pkg/protocol"
+)
+
+// WithInstance names this server instance and its region in the server
+// info, subscribe acknowledgements and WebSocket upgrades. Instances behind
+// one anycast address or load balancer need distinct IDs that stay the
+// same across restarts, so clients can tell when a reconnect reached
+// another instance, whose retained messages may differ, and replay from
+// scratch instead of resuming.
+func WithInstance(id, region string) Option {
+	return func(s *Server) {
+		s.instanceID = id
+		s.region = region
+	}
+}
+
+// describeInstance adds the instance identity, if configured, to an info
+// response or acknowledgement
+func (s *Server) describeInstance(fields map[string]interface{}) {
+	if s.instanceID != "" {
+		fields["instance_id"] = s.instanceID
+	}
+	if s.region != "" {
+		fields["region"] = s.region
+	}
+}
+
+// upgradeHeader returns the headers of WebSocket upgrade responses for the
+// connection traced as traceID
+func (s *Server) upgradeHeader(traceID string) http.Header {
+	header := http.Header{}
+	if s.instanceID != "" {
+		header.Set(protocol.InstanceHeader, s.instanceID)
+	}
+	if traceID != "" {
+		header.Set(protocol.TraceHeader, traceID)
+	}
+	return header
+}
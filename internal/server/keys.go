@@ -0,0 +1,275 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/yourusername/secure-messaging-poc/internal/keystore"
+)
+
+// handleKeyStore stores the caller's encrypted key. The slot is always the
+// certificate ID of the TLS peer; a cert_id in the body must match it.
+func (s *Server) handleKeyStore(w http.ResponseWriter, r *http.Request) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		http.Error(w, "Client certificate required", http.StatusUnauthorized)
+		return
+	}
+	callerID := s.certificateID(r.TLS.PeerCertificates[0])
+
+	var storeRequest struct {
+		CertID       string `json:"cert_id"`
+		Slot         string `json:"slot"`
+		EncryptedKey []byte `json:"encrypted_key"`
+		IV           []byte `json:"iv"`
+		HMAC         []byte `json:"hmac"`
+		// How the key was encrypted; the default format when omitted
+		Format *keystore.Format `json:"format"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&storeRequest); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	certID, err := s.keyPolicy.AuthorizeWrite(callerID, storeRequest.CertID)
+	if err != nil {
+		httpError(w, err, "Failed to authorize key write")
+		return
+	}
+
+	slot := storeRequest.Slot
+	if slot == "" {
+		slot = keystore.DefaultSlot
+	}
+
+	format := keystore.DefaultFormat
+	if storeRequest.Format != nil {
+		format = *storeRequest.Format
+	}
+
+	version, err := s.keyStore.StoreSlotWithFormat(certID, slot, format, storeRequest.EncryptedKey, storeRequest.IV, storeRequest.HMAC)
+	if err != nil {
+		httpError(w, err, "Failed to store key")
+		return
+	}
+	setQuotaWarning(w, s.keyStore.QuotaStatus(certID))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":    "success",
+		"cert_id":   certID,
+		"slot":      slot,
+		"version":   version,
+		"timestamp": time.Now().Format(time.RFC3339),
+	})
+}
+
+// handleKeyRetrieve returns an encrypted key. A GET reads the caller's own
+// slots; a POST may name another certificate together with a grant from its
+// owner. The slot defaults to the default slot.
+func (s *Server) handleKeyRetrieve(w http.ResponseWriter, r *http.Request) {
+	started := time.Now()
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		http.Error(w, "Client certificate required", http.StatusUnauthorized)
+		return
+	}
+	callerID := s.certificateID(r.TLS.PeerCertificates[0])
+
+	var retrieveRequest struct {
+		CertID string          `json:"cert_id"`
+		Slot   string          `json:"slot"`
+		Grant  *keystore.Grant `json:"grant"`
+	}
+	if r.Method == http.MethodPost {
+		if err := json.NewDecoder(r.Body).Decode(&retrieveRequest); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if retrieveRequest.Slot == "" {
+		retrieveRequest.Slot = r.URL.Query().Get("slot")
+	}
+	if retrieveRequest.Slot == "" {
+		retrieveRequest.Slot = keystore.DefaultSlot
+	}
+
+	certID, ok := s.authorizeKeyRead(w, started, callerID, retrieveRequest.CertID, retrieveRequest.Grant)
+	if !ok {
+		return
+	}
+
+	keyData, err := s.keyStore.GetSlot(certID, retrieveRequest.Slot)
+	if err != nil {
+		keyError(w, started, err, "Failed to retrieve key")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keyDataJSON(keyData))
+}
+
+// handleKeySync reconciles a client's slot manifest in one round trip. The
+// response carries only the slots whose version differs from the client's,
+// plus the server manifest so the client can upload slots the server lacks.
+func (s *Server) handleKeySync(w http.ResponseWriter, r *http.Request) {
+	started := time.Now()
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		http.Error(w, "Client certificate required", http.StatusUnauthorized)
+		return
+	}
+	callerID := s.certificateID(r.TLS.PeerCertificates[0])
+
+	var syncRequest struct {
+		CertID   string            `json:"cert_id"`
+		Grant    *keystore.Grant   `json:"grant"`
+		Manifest map[string]uint64 `json:"manifest"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&syncRequest); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(syncRequest.Manifest) > maxSyncManifestEntries {
+		http.Error(w, "Manifest too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	certID, ok := s.authorizeKeyRead(w, started, callerID, syncRequest.CertID, syncRequest.Grant)
+	if !ok {
+		return
+	}
+
+	changed, manifest := s.keyStore.Sync(certID, syncRequest.Manifest)
+
+	blobs := make([]map[string]interface{}, 0, len(changed))
+	for _, keyData := range changed {
+		blobs = append(blobs, keyDataJSON(keyData))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"cert_id":   certID,
+		"changed":   blobs,
+		"manifest":  manifest,
+		"timestamp": time.Now().Format(time.RFC3339),
+	})
+}
+
+// handleKeyDelete deletes one of the caller's slots, the default slot
+// unless one is named. Deleting a slot that does not exist fails exactly
+// like naming another certificate's slot.
+func (s *Server) handleKeyDelete(w http.ResponseWriter, r *http.Request) {
+	started := time.Now()
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		http.Error(w, "Client certificate required", http.StatusUnauthorized)
+		return
+	}
+	callerID := s.certificateID(r.TLS.PeerCertificates[0])
+
+	var deleteRequest struct {
+		CertID string `json:"cert_id"`
+		Slot   string `json:"slot"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&deleteRequest); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if deleteRequest.Slot == "" {
+		deleteRequest.Slot = keystore.DefaultSlot
+	}
+
+	certID, err := s.keyPolicy.AuthorizeWrite(callerID, deleteRequest.CertID)
+	if err != nil {
+		keyError(w, started, err, "Failed to authorize key delete")
+		return
+	}
+	if err := s.keyStore.DeleteSlot(certID, deleteRequest.Slot); err != nil {
+		keyError(w, started, err, "Failed to delete key")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":    "deleted",
+		"cert_id":   certID,
+		"slot":      deleteRequest.Slot,
+		"timestamp": time.Now().Format(time.RFC3339),
+	})
+}
+
+// handleUsage reports the caller's key storage against the quotas of its
+// certificate and its referral tree, so clients can act on a soft limit
+// before writes start failing
+func (s *Server) handleUsage(w http.ResponseWriter, r *http.Request) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		http.Error(w, "Client certificate required", http.StatusUnauthorized)
+		return
+	}
+	certID := s.certificateID(r.TLS.PeerCertificates[0])
+	statuses := s.keyStore.QuotaStatus(certID)
+	setQuotaWarning(w, statuses)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"cert_id":   certID,
+		"quotas":    statuses,
+		"timestamp": time.Now().Format(time.RFC3339),
+	})
+}
+
+// setQuotaWarning adds a Warning header naming every scope past its soft
+// quota
+func setQuotaWarning(w http.ResponseWriter, statuses []keystore.QuotaStatus) {
+	for _, status := range statuses {
+		if status.SoftExceeded {
+			w.Header().Add("Warning", fmt.Sprintf(`299 - "%s key storage soft quota exceeded"`, status.Scope))
+		}
+	}
+}
+
+// maxSyncManifestEntries bounds the client manifest accepted by a sync
+const maxSyncManifestEntries = 1024
+
+// authorizeKeyRead applies the keystore access policy and writes the error
+// response if the read is refused
+func (s *Server) authorizeKeyRead(w http.ResponseWriter, started time.Time, callerID, requestedID string, grant *keystore.Grant) (string, bool) {
+	certID, err := s.keyPolicy.AuthorizeRead(callerID, requestedID, grant)
+	if err != nil {
+		keyError(w, started, err, "Failed to authorize key read")
+		return "", false
+	}
+	return certID, true
+}
+
+// keyMissDuration is the least time a keystore request takes to fail with
+// ErrKeyUnavailable. Grants are verified only when presented and the store
+// is consulted only once access is granted, so without it the response time
+// would tell a missing key from a refused one.
+const keyMissDuration = 100 * time.Millisecond
+
+// keyError writes the response for a failed keystore request. Errors that
+// would reveal whether a certificate has stored keys are reported as
+// ErrKeyUnavailable, with the same status and body, and no sooner than
+// keyMissDuration after the request started.
+func keyError(w http.ResponseWriter, started time.Time, err error, msg string) {
+	err = keystore.Conceal(err)
+	if err == keystore.ErrKeyUnavailable {
+		time.Sleep(time.Until(started.Add(keyMissDuration)))
+	}
+	httpError(w, err, msg)
+}
+
+// keyDataJSON converts a stored key to its API representation
+func keyDataJSON(keyData keystore.EncryptedKeyData) map[string]interface{} {
+	return map[string]interface{}{
+		"cert_id":       keyData.CertID,
+		"slot":          keyData.Slot,
+		"version":       keyData.Version,
+		"format":        keyData.Format,
+		"encrypted_key": keyData.EncryptedKey,
+		"iv":            keyData.IV,
+		"hmac":          keyData.HMAC,
+		"updated_at":    keyData.UpdatedAt.Format(time.RFC3339),
+	}
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yourusername/secure-messaging-poc/internal/binmanager"
+	"github.com/yourusername/secure-messaging-poc/internal/certmanager"
+	"github.com/yourusername/secure-messaging-poc/internal/keystore"
+)
+
+// newKeyTestServer returns a server with an in-memory keystore and a CA
+// that issues the test clients' certificates
+func newKeyTestServer(t *testing.T) (*Server, *certmanager.CertificateAuthority) {
+	t.Helper()
+	dir := t.TempDir()
+	ca, err := certmanager.NewCertificateAuthority(filepath.Join(dir, "ca.crt"), filepath.Join(dir, "ca.key"), "Test Org")
+	if err != nil {
+		t.Fatalf("Failed to create CA: %v", err)
+	}
+	s := NewServer("127.0.0.1:0", nil,
+		binmanager.NewBinManager(0xFFFFFFFFFFFFF000, time.Hour),
+		certmanager.NewRevocationManager(),
+		ca,
+		keystore.NewEncryptedKeyStore(),
+	)
+	return s, ca
+}
+
+// issueClient issues a client certificate from ca
+func issueClient(t *testing.T, ca *certmanager.CertificateAuthority, commonName string) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: commonName},
+	}, key)
+	if err != nil {
+		t.Fatalf("Failed to create CSR: %v", err)
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		t.Fatalf("Failed to parse CSR: %v", err)
+	}
+	cert, err := ca.SignCSR(csr, "", 30)
+	if err != nil {
+		t.Fatalf("Failed to sign certificate: %v", err)
+	}
+	return cert
+}
+
+// keyResponse is what a keystore request returned and how long it took
+type keyResponse struct {
+	status  int
+	body    string
+	elapsed time.Duration
+}
+
+// callKeyHandler serves one request from cert to handler
+func callKeyHandler(handler http.HandlerFunc, cert *x509.Certificate, method, target, body string) keyResponse {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	w := httptest.NewRecorder()
+	started := time.Now()
+	handler(w, r)
+	return keyResponse{status: w.Code, body: w.Body.String(), elapsed: time.Since(started)}
+}
+
+// checkIndistinguishable fails unless a request for a missing key and one
+// refused for another certificate's key look alike
+func checkIndistinguishable(t *testing.T, name string, missing, refused keyResponse) {
+	t.Helper()
+	if missing.status != http.StatusNotFound || refused.status != missing.status {
+		t.Errorf("%s: missing key gave %d, refused key %d; want both %d", name, missing.status, refused.status, http.StatusNotFound)
+	}
+	if missing.body != refused.body {
+		t.Errorf("%s: missing key gave %q, refused key %q", name, missing.body, refused.body)
+	}
+	if missing.elapsed < keyMissDuration || refused.elapsed < keyMissDuration {
+		t.Errorf("%s: missing key took %v, refused key %v; want at least %v", name, missing.elapsed, refused.elapsed, keyMissDuration)
+	}
+}
+
+func TestKeyRetrieveConcealsOtherKeys(t *testing.T) {
+	s, ca := newKeyTestServer(t)
+	owner := issueClient(t, ca, "owner")
+	caller := issueClient(t, ca, "caller")
+	ownerID := s.certificateID(owner)
+	if _, err := s.keyStore.StoreSlot(ownerID, keystore.DefaultSlot, []byte("key"), []byte("iv"), []byte("hmac")); err != nil {
+		t.Fatalf("Failed to store key: %v", err)
+	}
+
+	missing := callKeyHandler(s.handleKeyRetrieve, caller, http.MethodGet, "/api/key/retrieve", "")
+	refused := callKeyHandler(s.handleKeyRetrieve, caller, http.MethodPost, "/api/key/retrieve", `{"cert_id":"`+ownerID+`"}`)
+	checkIndistinguishable(t, "retrieve", missing, refused)
+
+	if own := callKeyHandler(s.handleKeyRetrieve, owner, http.MethodGet, "/api/key/retrieve", ""); own.status != http.StatusOK {
+		t.Errorf("The owner should read its key, got %d %q", own.status, own.body)
+	}
+}
+
+func TestKeyDeleteConcealsOtherKeys(t *testing.T) {
+	s, ca := newKeyTestServer(t)
+	owner := issueClient(t, ca, "owner")
+	caller := issueClient(t, ca, "caller")
+	ownerID := s.certificateID(owner)
+	if _, err := s.keyStore.StoreSlot(ownerID, keystore.DefaultSlot, []byte("key"), []byte("iv"), []byte("hmac")); err != nil {
+		t.Fatalf("Failed to store key: %v", err)
+	}
+
+	missing := callKeyHandler(s.handleKeyDelete, caller, http.MethodPost, "/api/key/delete", `{}`)
+	refused := callKeyHandler(s.handleKeyDelete, caller, http.MethodPost, "/api/key/delete", `{"cert_id":"`+ownerID+`"}`)
+	checkIndistinguishable(t, "delete", missing, refused)
+
+	if _, err := s.keyStore.GetSlot(ownerID, keystore.DefaultSlot); err != nil {
+		t.Errorf("A refused delete should leave the key, got %v", err)
+	}
+}
+
+func TestKeyUsageReportsOnlyTheCaller(t *testing.T) {
+	s, ca := newKeyTestServer(t)
+	owner := issueClient(t, ca, "owner")
+	caller := issueClient(t, ca, "caller")
+	ownerID := s.certificateID(owner)
+	callerID := s.certificateID(caller)
+	if _, err := s.keyStore.StoreSlot(ownerID, keystore.DefaultSlot, []byte("key"), []byte("iv"), []byte("hmac")); err != nil {
+		t.Fatalf("Failed to store key: %v", err)
+	}
+
+	// Naming another certificate changes nothing, so usage cannot tell
+	// which certificates have stored keys
+	plain := callKeyHandler(s.handleUsage, caller, http.MethodGet, "/api/usage", "")
+	named := callKeyHandler(s.handleUsage, caller, http.MethodGet, "/api/usage?cert_id="+ownerID, "")
+	if plain.status != http.StatusOK || named.status != http.StatusOK {
+		t.Fatalf("Usage should succeed, got %d and %d", plain.status, named.status)
+	}
+	var usage struct {
+		CertID string `json:"cert_id"`
+	}
+	if err := json.Unmarshal([]byte(named.body), &usage); err != nil {
+		t.Fatalf("Failed to decode usage: %v", err)
+	}
+	if usage.CertID != callerID {
+		t.Errorf("Usage should report the caller %s, got %s", callerID, usage.CertID)
+	}
+	if strings.Contains(named.body, ownerID) {
+		t.Errorf("Usage names the other certificate: %s", named.body)
+	}
+}
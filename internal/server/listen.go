@@ -0,0 +1,36 @@
+package server
+
+import (
+	"net"
+
+	"github.com/yourusername/secure-messaging-poc/internal/listenaddr"
+)
+
+// WithListenFamily restricts every listener the server opens, including the
+// discovery listener and the SMTP gateway, to one IP family:
+// listenaddr.FamilyIPv4, FamilyIPv6 for IPv6-only hosts, or FamilyDual, the
+// default, which binds a wildcard address as a single dual-stack socket
+func WithListenFamily(family string) Option {
+	return func(s *Server) {
+		s.ipFamily = family
+	}
+}
+
+// listen listens on address in the server's IP family
+func (s *Server) listen(address string) (net.Listener, error) {
+	family := s.ipFamily
+	if family == "" {
+		family = listenaddr.FamilyDual
+	}
+	return listenaddr.Listen(family, address)
+}
+
+// WithAdvertisedAddresses names the host:port endpoints clients may connect
+// to in the signed server info, so clients can race the IPv6 and IPv4 ones
+// as happy eyeballs does. The addresses should be in the order returned by
+// listenaddr.Advertised.
+func WithAdvertisedAddresses(addresses []string) Option {
+	return func(s *Server) {
+		s.advertised = addresses
+	}
+}
@@ -0,0 +1,31 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/yourusername/secure-messaging-poc/internal/listenaddr"
+)
+
+func TestListenUsesFamily(t *testing.T) {
+	s := &Server{ipFamily: listenaddr.FamilyIPv4}
+	listener, err := s.listen(":0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	defer listener.Close()
+	if ip := listener.Addr().(*net.TCPAddr).IP; ip.To4() == nil {
+		t.Errorf("An IPv4 listener bound %s", ip)
+	}
+
+	if listener, err := s.listen("[::1]:0"); err == nil {
+		listener.Close()
+		t.Error("An IPv4 server should not listen on an IPv6 address")
+	}
+
+	s.ipFamily = "ipx"
+	if _, err := s.listen(":0"); !errors.Is(err, listenaddr.ErrInvalidAddress) {
+		t.Errorf("An unknown family should fail with ErrInvalidAddress, got %v", err)
+	}
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/yourusername/secure-messaging-poc/internal/apispec"
+	"github.com/yourusername/secure-messaging-poc/internal/trace"
+	"github.com/yourusername/secure-messaging-poc/pkg/protocol"
+)
+
+// Request body limits of the HTTP endpoints
+const (
+	// noRequestBody is the limit of endpoints that read no body
+	noRequestBody = 0
+	// maxCSRSize bounds PEM certificate signing requests
+	maxCSRSize = 16 << 10
+	// maxControlRequestSize bounds small JSON requests such as enrollment
+	// orders and revocations
+	maxControlRequestSize = 16 << 10
+	// DefaultMaxKeyRequestSize bounds key store requests unless configured
+	DefaultMaxKeyRequestSize = 256 << 10
+)
+
+// DefaultRequestTimeout is how long an HTTP request may take to be served
+const DefaultRequestTimeout = 30 * time.Second
+
+// ErrClientPanic is returned by a client write that panicked
+var ErrClientPanic = errors.New("client write panicked")
+
+// traceHTTP gives every request a fresh trace ID, returned in the
+// TraceHeader and attached to the request context for log lines. An ID
+// sent by the client is never reused, so IDs cannot be chosen to link
+// requests.
+func traceHTTP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := trace.NewID()
+		w.Header().Set(protocol.TraceHeader, id)
+		next.ServeHTTP(w, r.WithContext(trace.WithID(r.Context(), id)))
+	})
+}
+
+// recoverHTTP turns a panic in a handler into a 500 response for that request
+// only. http.ErrAbortHandler is passed through so net/http can abort quietly.
+func (s *Server) recoverHTTP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if v := recover(); v != nil {
+				if v == http.ErrAbortHandler {
+					panic(v)
+				}
+				s.logPanic(trace.FromContext(r.Context()), r.Method+" "+r.URL.Path, v)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// WithRequestLimits sets how long an HTTP request may take to be served and
+// the largest body the key store endpoints accept. Zero leaves a limit at
+// its default.
+func WithRequestLimits(timeout time.Duration, maxKeyRequestSize int64) Option {
+	return func(s *Server) {
+		if timeout > 0 {
+			s.requestTimeout = timeout
+		}
+		if maxKeyRequestSize > 0 {
+			s.maxKeyRequestSize = maxKeyRequestSize
+		}
+	}
+}
+
+// route registers an HTTP endpoint that accepts only the given methods and
+// reads at most maxBody bytes of request body, after the auth rule covering
+// it allows the request. Requests still being served after the request
+// timeout get a 503 instead.
+func (s *Server) route(mux *http.ServeMux, pattern string, maxBody int64, handler http.HandlerFunc, methods ...string) {
+	s.describe(mux, apispec.Endpoint{Path: pattern, Methods: methods, MaxBody: maxBody})
+	h := limitRequest(maxBody, s.requireAuth(pattern, handler), methods)
+	if s.requestTimeout > 0 {
+		h = http.TimeoutHandler(h, s.requestTimeout, "Request timed out")
+	}
+	mux.Handle(pattern, h)
+}
+
+// streamRoute registers an endpoint like route, but without the request
+// timeout, which would buffer the whole response. Streaming handlers bound
+// their own work.
+func (s *Server) streamRoute(mux *http.ServeMux, pattern string, maxBody int64, handler http.HandlerFunc, methods ...string) {
+	s.describe(mux, apispec.Endpoint{Path: pattern, Methods: methods, MaxBody: maxBody})
+	mux.Handle(pattern, limitRequest(maxBody, s.requireAuth(pattern, handler), methods))
+}
+
+// limitRequest accepts only the given methods and at most maxBody bytes of
+// request body before calling handler
+func limitRequest(maxBody int64, handler http.HandlerFunc, methods []string) http.Handler {
+	allow := strings.Join(methods, ", ")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !methodAllowed(r.Method, methods) {
+			w.Header().Set("Allow", allow)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxBody)
+		handler(w, r)
+	})
+}
+
+// methodAllowed reports whether method is one of methods
+func methodAllowed(method string, methods []string) bool {
+	for _, m := range methods {
+		if method == m {
+			return true
+		}
+	}
+	return false
+}
+
+// recoverConnection must be deferred directly in goroutines serving a
+// WebSocket client. A panic closes that client's connection with an internal
+// error instead of taking down the process.
+func (s *Server) recoverConnection(client *Client) {
+	if v := recover(); v != nil {
+		s.logPanic(client.trace, "WebSocket connection", v)
+		client.CloseWithError(newErrorFrame(ErrInternal))
+	}
+}
+
+// logPanic records a recovered panic with its stack under the trace ID of
+// the request or connection
+func (s *Server) logPanic(traceID, where string, v interface{}) {
+	s.panics.Inc()
+	trace.Printf(traceID, "Recovered panic in %s: %v\n%s", where, v, debug.Stack())
+}
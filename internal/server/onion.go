@@ -0,0 +1,27 @@
+package server
+
+import (
+	"time"
+
+	"github.com/yourusername/secure-messaging-poc/internal/onion"
+)
+
+// onionService is the Tor endpoint advertised in the server info
+type onionService struct {
+	address string
+	port    int
+	altSvc  string // Empty unless the Alt-Svc hint is enabled
+}
+
+// WithOnionService advertises an onion address of this server in the signed
+// server info, so clearnet clients can discover the onion endpoint and move
+// to it. With altSvcMaxAge > 0 the info also carries an Alt-Svc value for
+// the endpoint, which is sent as a header of the info response as well.
+func WithOnionService(address string, port int, altSvcMaxAge time.Duration) Option {
+	return func(s *Server) {
+		s.onion = &onionService{address: address, port: port}
+		if altSvcMaxAge > 0 {
+			s.onion.altSvc = onion.AltSvc(address, port, altSvcMaxAge)
+		}
+	}
+}
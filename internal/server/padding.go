@@ -0,0 +1,30 @@
+package server
+
+import (
+	"math/rand"
+	"time"
+)
+
+// keepaliveBase is the mean interval between pings and revocation checks
+const keepaliveBase = 10 * time.Second
+
+// WithTransportPadding makes protocol state harder to infer from passive
+// observation of record sizes and timing. Control frames are padded to a
+// multiple of padBlock bytes, and each keepalive interval varies by up to
+// pingJitter (a fraction of the base interval) either way. Zero disables
+// the respective measure.
+func WithTransportPadding(padBlock int, pingJitter float64) Option {
+	return func(s *Server) {
+		s.padBlock = padBlock
+		s.pingJitter = pingJitter
+	}
+}
+
+// keepaliveInterval returns the wait before the next ping of a session
+func (s *Server) keepaliveInterval() time.Duration {
+	if s.pingJitter <= 0 {
+		return keepaliveBase
+	}
+	offset := (rand.Float64()*2 - 1) * s.pingJitter
+	return time.Duration(float64(keepaliveBase) * (1 + offset))
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"github.com/yourusername/secure-messaging-poc/internal/binmanager"
+	"github.com/yourusername/secure-messaging-poc/internal/certmanager"
+	"github.com/yourusername/secure-messaging-poc/internal/metrics"
+	"github.com/yourusername/secure-messaging-poc/pkg/plugin"
+)
+
+// WithPlugins delivers lifecycle events to plugins: WebSocket connections
+// opening and closing, subscriptions, accepted and rejected publishes, and
+// certificate revocations. Each plugin buffers up to queueSize events;
+// events a slow plugin cannot take are dropped and counted. The plugins
+// are closed on Shutdown.
+func WithPlugins(queueSize int, plugins ...plugin.Plugin) Option {
+	return func(s *Server) {
+		if len(plugins) > 0 {
+			s.plugins = plugin.NewDispatcher(queueSize, plugins...)
+		}
+	}
+}
+
+// setupPlugins forwards revocations to the plugins and counts the events
+// they drop
+func (s *Server) setupPlugins(registry *metrics.Registry) {
+	if s.plugins == nil {
+		return
+	}
+	dropped := registry.Counter("anono_plugin_events_dropped_total", "Lifecycle events dropped by full plugin queues")
+	s.plugins.OnDrop(func(plugin.Event) {
+		dropped.Inc()
+	})
+	s.revocationMgr.OnRevoke(func(event certmanager.RevocationEvent) {
+		s.plugins.Emit(plugin.Event{Type: plugin.CertificateRevoked, CertID: event.CertID, Reason: event.Reason})
+	})
+}
+
+// emitPublish reports the outcome of a publish to the plugins. msg is nil
+// if the frame could not be decoded; reason is empty for accepted ones.
+func (s *Server) emitPublish(sess *session, certID string, msg *binmanager.Message, reason string) {
+	if s.plugins == nil {
+		return
+	}
+	event := plugin.Event{
+		Type:       plugin.PublishAccepted,
+		CertID:     certID,
+		Connection: sess.client.connection,
+		SessionID:  sess.client.sessionID,
+		Reason:     reason,
+	}
+	if reason != "" {
+		event.Type = plugin.PublishRejected
+	}
+	if msg != nil {
+		event.BinID = msg.BinID
+		event.MessageID = msg.MessageID
+		event.Size = len(msg.Ciphertext)
+	}
+	s.plugins.Emit(event)
+}
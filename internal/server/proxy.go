@@ -0,0 +1,25 @@
+package server
+
+import (
+	"net"
+
+	"github.com/yourusername/secure-messaging-poc/internal/proxyproto"
+)
+
+// WithProxyProtocol serves both listeners behind reverse proxies that send
+// the PROXY protocol, so client addresses, and the limits keyed by them,
+// are those of the clients rather than the proxy. Only the trusted proxies
+// may connect.
+func WithProxyProtocol(trusted []*net.IPNet) Option {
+	return func(s *Server) {
+		s.trustedProxies = trusted
+	}
+}
+
+// wrapListener applies the PROXY protocol to a listener if it is enabled
+func (s *Server) wrapListener(listener net.Listener) net.Listener {
+	if len(s.trustedProxies) == 0 {
+		return listener
+	}
+	return proxyproto.NewListener(listener, s.trustedProxies, proxyproto.DefaultHeaderTimeout)
+}
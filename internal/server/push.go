@@ -0,0 +1,75 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/yourusername/secure-messaging-poc/internal/push"
+)
+
+// WithPush enables push registrations and wake-ups for clients that are not
+// subscribed when a message arrives. Revoked certificates are not woken.
+func WithPush(dispatcher *push.Dispatcher) Option {
+	return func(s *Server) {
+		dispatcher.SkipCertificates(s.revocationMgr.IsRevoked)
+		s.push = dispatcher
+	}
+}
+
+// maxPushRegistrationSize bounds a registration with a full token and bin
+// list
+const maxPushRegistrationSize = push.MaxTokenLength + push.MaxBins*24 + 1024
+
+// pushRegistration is the body of a push registration and the response to
+// a lookup; the token is never returned
+type pushRegistration struct {
+	Provider string   `json:"provider"`
+	Token    string   `json:"token,omitempty"`
+	BinIDs   []uint64 `json:"bin_ids"`
+}
+
+// handlePushRegister manages the push registration of the calling
+// certificate: POST registers or replaces it, GET shows it and DELETE
+// removes it
+func (s *Server) handlePushRegister(w http.ResponseWriter, r *http.Request) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		http.Error(w, "Client certificate required", http.StatusUnauthorized)
+		return
+	}
+	certID := s.certificateID(r.TLS.PeerCertificates[0])
+
+	switch r.Method {
+	case http.MethodPost:
+		var registration pushRegistration
+		if err := json.NewDecoder(r.Body).Decode(&registration); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		err := s.push.Register(certID, registration.Provider, registration.Token, registration.BinIDs)
+		if err != nil {
+			httpError(w, err, "Failed to save registration")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case http.MethodGet:
+		provider, binIDs, exists := s.push.Registry().Lookup(certID)
+		if !exists {
+			http.Error(w, "Not registered", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(pushRegistration{Provider: provider, BinIDs: binIDs})
+
+	case http.MethodDelete:
+		if err := s.push.Registry().Unregister(certID); err != nil {
+			httpError(w, err, "Failed to remove registration")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,23 @@
+package server
+
+// WithDeliveryCounts tells publishers that ask for an acknowledgement how
+// many live subscribers their message reached, but only in aggregate:
+// counts are rounded down to a multiple of threshold and left out below it,
+// so an ack says "delivered to at least 5" rather than who received it or
+// whether a single subscriber is online. Only sends are counted; nothing
+// is learned about what recipients did with the message, and sessions of
+// the publisher subscribed to the bin count like any other.
+func WithDeliveryCounts(threshold int) Option {
+	return func(s *Server) {
+		s.deliveryThreshold = threshold
+	}
+}
+
+// deliveryCount returns the count a publish ack may reveal for a message
+// sent to delivered subscribers; zero reveals nothing
+func (s *Server) deliveryCount(delivered int) int {
+	if s.deliveryThreshold <= 0 || delivered < s.deliveryThreshold {
+		return 0
+	}
+	return delivered - delivered%s.deliveryThreshold
+}
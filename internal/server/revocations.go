@@ -0,0 +1,71 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/yourusername/secure-messaging-poc/internal/certmanager"
+)
+
+// Limits on the number of entries in one revocation feed response
+const (
+	defaultRevocationPage = 500
+	maxRevocationPage     = 5000
+)
+
+// revocationPage is one response of the revocation delta feed
+type revocationPage struct {
+	Entries [][]byte `cbor:"entries" json:"entries"` // COSE_Sign1, base64 in JSON
+	Next    uint64   `cbor:"next" json:"next"`
+}
+
+// handleRevocations serves the revocation delta feed. Each entry is a
+// COSE_Sign1 structure signed by the CA, so clients and federated peers can
+// verify, cache and re-share entries without trusting the transport. The
+// since parameter is the last sequence number already seen.
+func (s *Server) handleRevocations(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	var since uint64
+	if value := query.Get("since"); value != "" {
+		parsed, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid since", http.StatusBadRequest)
+			return
+		}
+		since = parsed
+	}
+
+	limit := defaultRevocationPage
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 || parsed > maxRevocationPage {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	entries, next, err := s.revocationFeed.Since(since, limit)
+	if err != nil {
+		log.Printf("Failed to sign revocation entries: %v", err)
+		http.Error(w, "Failed to sign revocation entries", http.StatusInternalServerError)
+		return
+	}
+	page := revocationPage{Entries: entries, Next: next}
+
+	format := graphFormat(query.Get("format"), r.Header.Get("Accept"))
+	w.Header().Set("Content-Type", graphContentType(format))
+	if format == certmanager.GraphFormatCBOR {
+		data, err := cbor.Marshal(page)
+		if err != nil {
+			http.Error(w, "Failed to encode revocations", http.StatusInternalServerError)
+			return
+		}
+		w.Write(data)
+		return
+	}
+	json.NewEncoder(w).Encode(page)
+}
@@ -3,14 +3,31 @@ package server
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"log"
+	"net"
 	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/yourusername/secure-messaging-poc/internal/apispec"
+	"github.com/yourusername/secure-messaging-poc/internal/authz"
 	"github.com/yourusername/secure-messaging-poc/internal/binmanager"
 	"github.com/yourusername/secure-messaging-poc/internal/certmanager"
+	"github.com/yourusername/secure-messaging-poc/internal/directory"
+	"github.com/yourusername/secure-messaging-poc/internal/features"
 	"github.com/yourusername/secure-messaging-poc/internal/keystore"
+	"github.com/yourusername/secure-messaging-poc/internal/legalhold"
+	"github.com/yourusername/secure-messaging-poc/internal/metrics"
+	"github.com/yourusername/secure-messaging-poc/internal/push"
+	"github.com/yourusername/secure-messaging-poc/internal/ratelimit"
+	"github.com/yourusername/secure-messaging-poc/internal/recovery"
+	"github.com/yourusername/secure-messaging-poc/internal/tlslimit"
+	"github.com/yourusername/secure-messaging-poc/internal/warrant"
+	"github.com/yourusername/secure-messaging-poc/pkg/plugin"
+	"github.com/yourusername/secure-messaging-poc/pkg/protocol"
 )
 
 // Server represents the messaging server
@@ -21,10 +38,146 @@ type Server struct {
 	revocationMgr  *certmanager.RevocationManager
 	certAuthority  *certmanager.CertificateAuthority
 	keyStore       *keystore.EncryptedKeyStore
+	keyPolicy      *keystore.AccessPolicy
+	enrollmentMgr  *certmanager.EnrollmentManager
 	httpServer     *http.Server
+	discoveryAddress string
+	discoveryLimiter ratelimit.Limiter
+	discoveryServer  *http.Server
+	seenCerts        seenCertificates
+	discoveryTLS     *tls.Config
+	trustedProxies   []*net.IPNet
+	handshakeLimit   *tlslimit.Config
+	maxMessageSize   int
+	publishAuthz     authz.PublishChain
+	publishLimiter   ratelimit.Limiter // Also consulted by the certificate status
+	subscribeAuthz   authz.SubscribeChain
+	readOnlyRevokedReferrers bool
+	newBinPoW        int
+	writeTimeout     time.Duration
+	maxPendingWrites int
+	maxSubscribeBins  int
+	maxConnectionBins int
+	adminIDs         map[string]bool
+	graphIssuers     []*x509.Certificate
+	retentionCtl     *binmanager.RetentionController
+	maskCtl          *binmanager.MaskController
+	warrant          *warrant.Store
+	legalHold        legalhold.Settings
+	compactor        *binmanager.Compactor
+	recoveryReport   *recovery.Report
+	metrics          *metrics.Registry
+	trustStore       *certmanager.TrustStore
+	fingerprints     *certmanager.FingerprintList
+	revocationFeed   *certmanager.RevocationFeed
+	pinnedAdmins     bool
+	authRules        []AuthRule
+	onion            *onionService
+	smtp             *smtpGateway
+	bulletin         *bulletinBoard
+	panics           *metrics.Counter
+	connections      *metrics.Gauge
+	published        *metrics.Counter
+	issued           *metrics.Counter
+	statsHistory     *metrics.History
+	wrapSubscriber   func(binmanager.Client) binmanager.Client
+	push             *push.Dispatcher
+	directory        *directory.Directory
+	bandwidthClasses map[string]*bandwidthClass
+	bandwidthMembers map[string]string
+	bytesWritten     *metrics.Counter
+	ingest           *binmanager.Pipeline
+	ingestOpts       []binmanager.PipelineOption
+	padBlock         int
+	pingJitter       float64
+	requestTimeout    time.Duration
+	timestampGranularity time.Duration
+	timestampJitter      bool
+	jitterKey            []byte
+	signing              *signingKeys // Ephemeral session signing keys, if enabled
+	plugins              *plugin.Dispatcher // Lifecycle event plugins, if any
+	features             *features.Set      // Rollout of gated behaviors, if configured
+	instanceID           string             // Stable name of this instance, if configured
+	deliveryThreshold    int                // Delivery counts in acks are multiples of this; 0 for none
+	region               string
+	ipFamily             string   // Family of the listener; dual-stack if empty
+	advertised           []string // Addresses in the server info, in RFC 8305 order
+	maxKeyRequestSize int64
+	endpoints         map[*http.ServeMux][]apispec.Endpoint // Registered endpoints, for /api/spec
+	clientUpdate      *protocol.ClientUpdate
+	captures          *captureSet // Admin-triggered frame captures
+	connectionSeq     uint64      // Numbers WebSocket connections for captures
 	websocketUpgrader *websocket.Upgrader
 }
 
+// Option configures optional server subsystems
+type Option func(*Server)
+
+// WithEnrollmentManager enables the automated enrollment endpoints
+func WithEnrollmentManager(em *certmanager.EnrollmentManager) Option {
+	return func(s *Server) {
+		s.enrollmentMgr = em
+	}
+}
+
+// WithMaxMessageSize limits the ciphertext size of published messages
+func WithMaxMessageSize(maxMessageSize int) Option {
+	return func(s *Server) {
+		s.maxMessageSize = maxMessageSize
+	}
+}
+
+// WithPublishLimiter rate limits publishes per client certificate
+func WithPublishLimiter(limiter ratelimit.Limiter) Option {
+	return func(s *Server) {
+		s.publishLimiter = limiter
+		s.publishAuthz = append(s.publishAuthz, authz.RateLimit(limiter))
+	}
+}
+
+// WithWebSocketBuffers sets the upgrader's read and write buffer sizes
+func WithWebSocketBuffers(readBufferSize, writeBufferSize int) Option {
+	return func(s *Server) {
+		s.websocketUpgrader.ReadBufferSize = readBufferSize
+		s.websocketUpgrader.WriteBufferSize = writeBufferSize
+	}
+}
+
+// WithWriteLimits bounds how long a write to a client may block and how
+// many writes may queue behind it before the client is disconnected
+func WithWriteLimits(writeTimeout time.Duration, maxPendingWrites int) Option {
+	return func(s *Server) {
+		s.writeTimeout = writeTimeout
+		s.maxPendingWrites = maxPendingWrites
+	}
+}
+
+// WithSubscribeLimits bounds the bins one subscribe frame may name and the
+// bins all sessions of a connection may receive. Bins over either limit are
+// rejected and listed in the subscribe_ack; zero disables a limit.
+func WithSubscribeLimits(maxPerSubscribe, maxPerConnection int) Option {
+	return func(s *Server) {
+		s.maxSubscribeBins = maxPerSubscribe
+		s.maxConnectionBins = maxPerConnection
+	}
+}
+
+// WithSubscriberWrapper wraps every WebSocket client before it is subscribed
+// to bins, e.g. to inject delivery faults in test builds
+func WithSubscriberWrapper(wrap func(binmanager.Client) binmanager.Client) Option {
+	return func(s *Server) {
+		s.wrapSubscriber = wrap
+	}
+}
+
+// WithLegalHold sets the storage and logging /api/info reports in its
+// legal_hold field; the retention is read from the bin manager
+func WithLegalHold(settings legalhold.Settings) Option {
+	return func(s *Server) {
+		s.legalHold = settings
+	}
+}
+
 // NewServer creates a new server instance
 func NewServer(
 	address string,
@@ -33,6 +186,7 @@ func NewServer(
 	revocationMgr *certmanager.RevocationManager,
 	certAuthority *certmanager.CertificateAuthority,
 	keyStore *keystore.EncryptedKeyStore,
+	opts ...Option,
 ) *Server {
 	server := &Server{
 		address:        address,
@@ -41,6 +195,13 @@ func NewServer(
 		revocationMgr:  revocationMgr,
 		certAuthority:  certAuthority,
 		keyStore:       keyStore,
+		writeTimeout:     DefaultWriteTimeout,
+		maxPendingWrites: DefaultMaxPendingWrites,
+		maxSubscribeBins:  DefaultMaxBinsPerSubscribe,
+		maxConnectionBins: DefaultMaxBinsPerConnection,
+		requestTimeout:    DefaultRequestTimeout,
+		maxKeyRequestSize: DefaultMaxKeyRequestSize,
+		captures:          newCaptureSet(),
 		websocketUpgrader: &websocket.Upgrader{
 			ReadBufferSize:  1024,
 			WriteBufferSize: 1024,
@@ -51,31 +212,168 @@ func NewServer(
 		},
 	}
 	
+	for _, opt := range opts {
+		opt(server)
+	}
+	server.setupTimestampJitter()
+	
+	// Without a configured registry the counters are kept but not exported
+	registry := server.metrics
+	if registry == nil {
+		registry = metrics.NewRegistry()
+	}
+	server.panics = registry.Counter("anono_panics_total", "Panics recovered in handlers and connections")
+	server.connections = registry.Gauge("anono_websocket_connections", "Open WebSocket connections")
+	server.published = registry.Counter("anono_messages_published_total", "Messages accepted from publishers")
+	server.issued = registry.Counter("anono_certificates_issued_total", "Client certificates issued")
+	server.bytesWritten = registry.Counter("anono_websocket_bytes_written_total", "Bytes written to WebSocket clients")
+	server.setupBandwidthClasses(registry)
+	server.setupPlugins(registry)
+	
+	// Key slots are partitioned by certificate; grants must chain to this CA
+	roots := x509.NewCertPool()
+	if caCert, err := certAuthority.GetCACertificate(); err == nil {
+		roots.AddCert(caCert)
+	}
+	server.keyPolicy = keystore.NewAccessPolicy(roots, revocationMgr.IsRevoked)
+	server.revocationFeed = certmanager.NewRevocationFeed(certAuthority, revocationMgr)
+	server.ingest = server.newIngestPipeline()
+	
 	// Setup HTTP router
 	mux := http.NewServeMux()
 	
-	// WebSocket endpoint for message streaming
-	mux.HandleFunc("/ws", server.handleWebSocket)
+	// WebSocket endpoint for message streaming. Sessions outlive any request
+	// timeout, so the upgrade is registered directly.
+	mux.HandleFunc("/ws", server.requireAuth("/ws", server.handleWebSocket))
+	server.describe(mux, apispec.Endpoint{
+		Path:    "/ws",
+		Methods: []string{http.MethodGet},
+		Summary: "WebSocket upgrade; frames are described by /api/spec?format=asyncapi",
+	})
 	
 	// Certificate management endpoints
-	mux.HandleFunc("/api/certificate/request", server.handleCertificateRequest)
-	mux.HandleFunc("/api/certificate/revoke", server.handleCertificateRevoke)
+	server.route(mux, "/api/certificate/request", maxCSRSize, server.handleCertificateRequest, http.MethodPost)
+	server.route(mux, "/api/certificate/revoke", maxControlRequestSize, server.handleCertificateRevoke, http.MethodPost)
+	server.route(mux, "/api/certificate/status", noRequestBody, server.handleCertificateStatus, http.MethodGet)
+	server.route(mux, "/api/revocations", noRequestBody, server.handleRevocations, http.MethodGet)
+	
+	// Automated enrollment endpoints for service accounts
+	if server.enrollmentMgr != nil {
+		server.route(mux, "/api/acme/invite", noRequestBody, server.handleAcmeInvite, http.MethodPost)
+		server.route(mux, "/api/acme/new-order", maxControlRequestSize, server.handleAcmeNewOrder, http.MethodPost)
+		server.route(mux, "/api/acme/challenge", maxControlRequestSize, server.handleAcmeChallenge, http.MethodPost)
+		server.route(mux, "/api/acme/finalize", 2*maxCSRSize, server.handleAcmeFinalize, http.MethodPost)
+	}
+	
+	// Admin endpoints, only for configured admin certificates
+	if len(server.adminIDs) > 0 {
+		server.route(mux, "/api/admin/graph/export", noRequestBody, server.requireAdmin(server.handleAdminGraphExport), http.MethodGet)
+		server.route(mux, "/api/admin/graph/import", maxGraphDocumentSize, server.requireAdmin(server.handleAdminGraphImport), http.MethodPost)
+		server.route(mux, "/api/admin/revocations", maxRevocationListSize, server.requireAdmin(server.handleAdminRevocations), http.MethodPost)
+		server.route(mux, "/api/admin/stats", noRequestBody, server.requireAdmin(server.handleAdminStats), http.MethodGet)
+		server.route(mux, "/api/admin/cleanup", noRequestBody, server.requireAdmin(server.handleAdminCleanup), http.MethodPost)
+		server.route(mux, "/api/admin/capture", maxControlRequestSize, server.requireAdmin(server.handleAdminCapture),
+			http.MethodGet, http.MethodPost, http.MethodDelete)
+		if server.statsHistory != nil {
+			server.route(mux, "/api/admin/stats/history", noRequestBody, server.requireAdmin(server.handleAdminStatsHistory), http.MethodGet)
+		}
+		if server.fingerprints != nil {
+			server.route(mux, "/api/admin/fingerprints", maxControlRequestSize, server.requireAdmin(server.handleAdminFingerprints),
+				http.MethodGet, http.MethodPost, http.MethodDelete)
+		}
+		if server.trustStore != nil {
+			server.route(mux, "/api/admin/trust", maxCertificateSize, server.requireAdmin(server.handleAdminTrust),
+				http.MethodGet, http.MethodPost, http.MethodDelete)
+			server.route(mux, "/api/admin/trust/reload", noRequestBody, server.requireAdmin(server.handleAdminTrustReload), http.MethodPost)
+		}
+		if server.retentionCtl != nil {
+			server.route(mux, "/api/admin/retention", noRequestBody, server.requireAdmin(server.handleAdminRetention), http.MethodGet)
+		}
+		if server.maskCtl != nil {
+			server.route(mux, "/api/admin/bins/autoscale", noRequestBody, server.requireAdmin(server.handleAdminAutoscale), http.MethodGet)
+		}
+		if server.warrant != nil {
+			server.route(mux, "/api/admin/warrant-canary", maxControlRequestSize, server.requireAdmin(server.handleAdminWarrantCanary), http.MethodPost)
+		}
+		if server.recoveryReport != nil {
+			server.route(mux, "/api/admin/recovery", noRequestBody, server.requireAdmin(server.handleAdminRecovery), http.MethodGet)
+		}
+		if server.features != nil {
+			server.route(mux, "/api/admin/features", maxControlRequestSize, server.requireAdmin(server.handleAdminFeatures),
+				http.MethodGet, http.MethodPost, http.MethodDelete)
+		}
+		if server.compactor != nil {
+			server.route(mux, "/api/admin/compaction", noRequestBody, server.requireAdmin(server.handleAdminCompaction),
+				http.MethodGet, http.MethodPost)
+		}
+		if server.metrics != nil && !server.metricsRuled() {
+			server.route(mux, "/metrics", noRequestBody, server.requireAdmin(server.metrics.Handler()), http.MethodGet)
+		}
+	}
+	
+	// Metrics opened up by an explicit auth rule
+	if server.metrics != nil && server.metricsRuled() {
+		server.route(mux, "/metrics", noRequestBody, server.metrics.Handler(), http.MethodGet)
+	}
 	
 	// Key storage endpoints
-	mux.HandleFunc("/api/key/store", server.handleKeyStore)
-	mux.HandleFunc("/api/key/retrieve", server.handleKeyRetrieve)
+	server.route(mux, "/api/key/store", server.maxKeyRequestSize, server.handleKeyStore, http.MethodPost)
+	server.route(mux, "/api/key/retrieve", server.maxKeyRequestSize, server.handleKeyRetrieve, http.MethodGet, http.MethodPost)
+	server.route(mux, "/api/key/sync", server.maxKeyRequestSize, server.handleKeySync, http.MethodPost)
+	server.route(mux, "/api/key/delete", server.maxKeyRequestSize, server.handleKeyDelete, http.MethodPost)
+	server.route(mux, "/api/usage", noRequestBody, server.handleUsage, http.MethodGet)
+	
+	// Push registration for clients without a permanent connection
+	if server.push != nil {
+		server.route(mux, "/api/push/register", maxPushRegistrationSize, server.handlePushRegister,
+			http.MethodGet, http.MethodPost, http.MethodDelete)
+	}
+	
+	// Channel directory
+	if server.directory != nil {
+		server.route(mux, "/api/directory", maxDirectoryRequestSize, server.handleDirectory,
+			http.MethodGet, http.MethodPost, http.MethodDelete)
+	}
+	
+	// Archive export and import of bins the caller owns
+	server.streamRoute(mux, "/api/bins/export", maxControlRequestSize, server.handleBinExport, http.MethodPost)
+	server.streamRoute(mux, "/api/bins/import", maxImportSize, server.handleBinImport, http.MethodPost)
+	server.route(mux, "/api/bins/stats", maxControlRequestSize, server.handleBinStats, http.MethodPost)
+	
+	// Signed client update manifest
+	if server.clientUpdate != nil {
+		server.route(mux, "/api/client-update", noRequestBody, server.handleClientUpdate, http.MethodGet)
+	}
+	
+	// Public bulletin feeds, also on the discovery listener
+	if server.bulletin != nil {
+		server.routeBulletin(mux)
+	}
+	
+	// Operator-signed warrant canary, also on the discovery listener
+	if server.warrant != nil {
+		server.route(mux, warrantCanaryPath, noRequestBody, server.handleWarrantCanary, http.MethodGet)
+	}
+	
+	// Protocol documents for client code generation
+	server.route(mux, "/api/spec", noRequestBody, server.handleSpec(mux), http.MethodGet)
 	
 	// Server info endpoint
-	mux.HandleFunc("/api/info", server.handleServerInfo)
+	server.route(mux, "/api/info", noRequestBody, server.handleServerInfo, http.MethodGet)
 	
 	// Health check endpoint
-	mux.HandleFunc("/health", server.handleHealth)
+	server.route(mux, "/health", noRequestBody, server.handleHealth, http.MethodGet)
 	
 	// Create HTTP server
 	server.httpServer = &http.Server{
-		Addr:      address,
-		Handler:   mux,
-		TLSConfig: tlsConfig,
+		Addr:              address,
+		Handler:           traceHTTP(server.recoverHTTP(mux)),
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	
+	if server.discoveryAddress != "" {
+		server.discoveryServer = server.newDiscoveryServer()
 	}
 	
 	return server
@@ -85,13 +383,45 @@ func NewServer(
 func (s *Server) Start() error {
 	log.Printf("Starting server on %s", s.address)
 	
-	// Start with TLS
-	return s.httpServer.ListenAndServeTLS("", "")
+	listener, err := s.listen(s.httpServer.Addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(listener)
+}
+
+// Serve accepts TLS connections on an existing listener, for callers that
+// pick the address themselves, such as an embedded server on port 0
+func (s *Server) Serve(listener net.Listener) error {
+	if s.discoveryServer != nil {
+		go s.startDiscovery()
+	}
+	if s.smtp != nil {
+		go s.startSMTP()
+	}
+	if s.handshakeLimit != nil {
+		return s.serveLimited(s.wrapListener(listener))
+	}
+	return s.httpServer.ServeTLS(s.wrapListener(listener), "", "")
 }
 
 // Shutdown gracefully shuts down the server
 func (s *Server) Shutdown(ctx context.Context) error {
-	return s.httpServer.Shutdown(ctx)
+	if s.discoveryServer != nil {
+		if err := s.discoveryServer.Shutdown(ctx); err != nil {
+			log.Printf("Discovery listener shutdown error: %v", err)
+		}
+	}
+	if s.smtp != nil {
+		if err := s.smtp.gateway.Shutdown(ctx); err != nil {
+			log.Printf("SMTP gateway shutdown error: %v", err)
+		}
+	}
+	err := s.httpServer.Shutdown(ctx)
+	if perr := s.plugins.Close(ctx); perr != nil {
+		log.Printf("Plugin shutdown error: %v", perr)
+	}
+	return err
 }
 
 // GetCurrentBinMask returns the current bin mask
@@ -102,51 +432,95 @@ func (s *Server) GetCurrentBinMask() uint64 {
 // RegisterClient registers a client connection with certificate information
 func (s *Server) RegisterClient(conn *websocket.Conn, certInfo map[string]interface{}) *Client {
 	client := NewClient(conn, certInfo)
+	client.SetWriteLimits(s.writeTimeout, s.maxPendingWrites)
+	client.SetPadding(s.padBlock)
+	client.SetExposure(s.exposeMessage)
+	client.onPanic = func(v interface{}) {
+		s.logPanic(client.trace, "WebSocket write", v)
+	}
 	
 	// Extract certificate ID and referrer ID
-	certID, _ := certInfo["serial"].(string)
+	certID, _ := certInfo["cert_id"].(string)
 	referrerID, _ := certInfo["referrer_id"].(string)
 	
+	// Count and shape writes by the certificate's bandwidth class
+	s.shapeClient(client, certID)
+	
+	// Frames are recorded while an admin captures the certificate
+	connection := atomic.AddUint64(&s.connectionSeq, 1)
+	client.connection = connection
+	client.capture = func(direction string, data []byte) {
+		s.captures.record(certID, connection, direction, data)
+	}
+	
 	// Register certificate in revocation manager
 	if certID != "" && referrerID != "" {
-		s.revocationMgr.RegisterCertificate(certID, referrerID)
+		notAfter, _ := certInfo["not_after"].(time.Time)
+		s.revocationMgr.RegisterCertificateUntil(certID, referrerID, notAfter)
 	}
 	
 	return client
 }
 
-// handleHealth handles health check requests
-func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	w.Write([]byte(`{"status":"healthy","timestamp":"` + time.Now().Format(time.RFC3339) + `"}`))
-}
-
-// handleServerInfo returns server information including the current bin mask
-func (s *Server) handleServerInfo(w http.ResponseWriter, r *http.Request) {
-	// Implementation details in handlers.go
+// certificateID returns the opaque ID of a certificate. The first time a
+// certificate is seen, any state still keyed by its legacy serial number is
+// migrated and its expiry is recorded with the CA, so certificates it
+// refers inherit its validity.
+func (s *Server) certificateID(cert *x509.Certificate) string {
+	certID := certmanager.CertificateID(cert)
+	serial := cert.SerialNumber.String()
+	
+	if s.seenCerts.firstSight(serial+"/"+certID, cert.NotAfter) {
+		s.revocationMgr.RegisterAlias(serial, certID)
+		s.keyStore.MigrateID(serial, certID)
+		s.certAuthority.RecordCertificate(cert)
+	}
+	
+	return certID
 }
 
-// handleWebSocket handles WebSocket connections
-func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
-	// Implementation details in handlers.go
-}
+// maxSeenCertificates bounds the certificates remembered as migrated. Past
+// it expired ones are forgotten, and all of them if over half remain; a
+// forgotten certificate is migrated again, which is harmless.
+const maxSeenCertificates = 65536
 
-// handleCertificateRequest handles certificate signing requests
-func (s *Server) handleCertificateRequest(w http.ResponseWriter, r *http.Request) {
-	// Implementation details in handlers.go
+// seenCertificates remembers the certificates certificateID has migrated,
+// by serial and ID, with their expiry
+type seenCertificates struct {
+	expiry map[string]time.Time
+	mu     sync.Mutex
 }
 
-// handleCertificateRevoke handles certificate revocation requests
-func (s *Server) handleCertificateRevoke(w http.ResponseWriter, r *http.Request) {
-	// Implementation details in handlers.go
+// firstSight records a certificate and reports whether it was new
+func (sc *seenCertificates) firstSight(key string, notAfter time.Time) bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	
+	if _, seen := sc.expiry[key]; seen {
+		return false
+	}
+	switch {
+	case sc.expiry == nil:
+		sc.expiry = make(map[string]time.Time)
+	case len(sc.expiry) >= maxSeenCertificates:
+		now := time.Now()
+		for k, expiry := range sc.expiry {
+			if !expiry.After(now) {
+				delete(sc.expiry, k)
+			}
+		}
+		// Forgetting all at once beats pruning a few on every call
+		if len(sc.expiry) > maxSeenCertificates/2 {
+			sc.expiry = make(map[string]time.Time)
+		}
+	}
+	sc.expiry[key] = notAfter
+	return true
 }
 
-// handleKeyStore handles encrypted key storage requests
-func (s *Server) handleKeyStore(w http.ResponseWriter, r *http.Request) {
-	// Implementation details in handlers.go
+// handleHealth handles health check requests
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"status":"healthy","timestamp":"` + time.Now().Format(time.RFC3339) + `"}`))
 }
 
-// handleKeyRetrieve handles encrypted key retrieval requests
-func (s *Server) handleKeyRetrieve(w http.ResponseWriter, r *http.Request) {
-	// Implementation details in handlers.go
-}
\ No newline at end of file
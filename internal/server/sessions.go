@@ -0,0 +1,402 @@
+package server
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yourusername/secure-messaging-poc/internal/authz"
+	"github.com/yourusername/secure-messaging-poc/internal/binmanager"
+	cryptopkg "github.com/yourusername/secure-messaging-poc/pkg/crypto"
+	"github.com/yourusername/secure-messaging-poc/pkg/plugin"
+	"github.com/yourusername/secure-messaging-poc/pkg/protocol"
+)
+
+// A WebSocket connection carries one or more logical sessions, each with
+// its own client ID and subscriptions, so a client with several identities
+// or device profiles needs only one connection. A connection is multiplexed
+// when its first subscribe frame names a session_id; every later frame in
+// either direction then names the session it belongs to. Further sessions
+// are opened with subscribe frames and ended with close_session frames.
+// Sessions share the connection's certificate, so they share its identity,
+// authorization and limits.
+const (
+	// maxSessionsPerConnection bounds the open sessions of one connection
+	maxSessionsPerConnection = 16
+	// maxSessionIDLength bounds a client-chosen session ID
+	maxSessionIDLength = 64
+)
+
+// Default subscription limits; bins beyond them are rejected individually
+// and listed in the subscribe_ack
+const (
+	// DefaultMaxBinsPerSubscribe bounds the bins one subscribe frame names
+	DefaultMaxBinsPerSubscribe = 256
+	// DefaultMaxBinsPerConnection bounds the bins all sessions of a
+	// connection receive together
+	DefaultMaxBinsPerConnection = 1024
+)
+
+// Frame types of multiplexed connections
+const (
+	frameSubscribe     = "subscribe"
+	frameCloseSession  = "close_session"
+	frameSessionClosed = "session_closed"
+)
+
+// subscribeFrame opens a session
+type subscribeFrame struct {
+	Type            string                          `json:"type"`
+	SessionID       string                          `json:"session_id,omitempty"`
+	BinIDs          []uint64                        `json:"bin_ids"`
+	Prefixes        []binmanager.PrefixSubscription `json:"prefixes"`
+	ClientID        string                          `json:"client_id"`
+	ProtocolVersion int                             `json:"protocol_version,omitempty"`
+	SigningKey      []byte                          `json:"signing_key,omitempty"`
+}
+
+// frameHeader routes a frame of a multiplexed connection
+type frameHeader struct {
+	Type      string `json:"type"`
+	SessionID string `json:"session_id"`
+}
+
+// sessionClient delivers to one session of a connection, naming the session
+// in everything it sends. A connection that is not multiplexed has a single
+// session with an empty ID, whose frames are sent unchanged.
+type sessionClient struct {
+	*Client
+	sessionID string
+}
+
+// SendMessage sends a message tagged with the session
+func (c *sessionClient) SendMessage(msg *binmanager.Message) error {
+	if c.sessionID != "" {
+		tagged := *msg
+		tagged.SessionID = c.sessionID
+		msg = &tagged
+	}
+	return c.Client.SendMessage(msg)
+}
+
+// SendError sends an error frame tagged with the session
+func (c *sessionClient) SendError(frame ErrorFrame) error {
+	frame.SessionID = c.sessionID
+	return c.Client.SendError(frame)
+}
+
+// sendPublishAck sends a publish acknowledgement tagged with the session
+func (c *sessionClient) sendPublishAck(ack PublishAck) error {
+	ack.SessionID = c.sessionID
+	return c.SendFrame(ack)
+}
+
+// SendPresence sends a bin's presence summary tagged with the session
+func (c *sessionClient) SendPresence(binID uint64, subscribers int) error {
+	return c.SendFrame(Presence{
+		Type:        "presence",
+		BinID:       binID,
+		Subscribers: subscribers,
+		SessionID:   c.sessionID,
+		Timestamp:   time.Now().Format(time.RFC3339),
+	})
+}
+
+// session is one logical session of a connection
+type session struct {
+	client     *sessionClient
+	clientID   string
+	binIDs     []uint64
+	prefixes   bool
+	release    func()            // Ends the session's push wake-up suppression
+	signingKey ed25519.PublicKey // Ephemeral key the session's publishes are signed with
+	signing    *signingKeys      // Nil if signing is off for the session
+}
+
+// sessionSet holds the open sessions of a connection
+type sessionSet struct {
+	client      *Client
+	multiplexed bool
+	sessions    map[string]*session
+	bins        int // Bins subscribed by all open sessions
+	mu          sync.Mutex
+}
+
+// newSessionSet creates the session set of a connection
+func newSessionSet(client *Client, multiplexed bool) *sessionSet {
+	return &sessionSet{
+		client:      client,
+		multiplexed: multiplexed,
+		sessions:    make(map[string]*session),
+	}
+}
+
+// get returns an open session, or nil
+func (ss *sessionSet) get(sessionID string) *session {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return ss.sessions[sessionID]
+}
+
+// add registers a session unless its ID or client ID is taken or the
+// connection is full, returning the error frame to refuse it with otherwise
+func (ss *sessionSet) add(sess *session) (ErrorFrame, bool) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if len(ss.sessions) >= maxSessionsPerConnection {
+		return newErrorFrame(ErrSessionLimit), false
+	}
+	if _, taken := ss.sessions[sess.client.sessionID]; taken {
+		return newErrorFrame(ErrBadSubscribe), false
+	}
+	for _, other := range ss.sessions {
+		if cryptopkg.ConstantTimeEqualString(other.clientID, sess.clientID) {
+			return newErrorFrame(ErrBadSubscribe), false
+		}
+	}
+	ss.sessions[sess.client.sessionID] = sess
+	ss.bins += len(sess.binIDs)
+	return ErrorFrame{}, true
+}
+
+// remainingBins returns how many more bins the connection may subscribe
+// under limit, or -1 if limit is zero
+func (ss *sessionSet) remainingBins(limit int) int {
+	if limit <= 0 {
+		return -1
+	}
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if ss.bins >= limit {
+		return 0
+	}
+	return limit - ss.bins
+}
+
+// remove unregisters a session and reports whether it was open
+func (ss *sessionSet) remove(sessionID string) (*session, bool) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	sess, exists := ss.sessions[sessionID]
+	if exists {
+		ss.bins -= len(sess.binIDs)
+		delete(ss.sessions, sessionID)
+	}
+	return sess, exists
+}
+
+// drain unregisters and returns every open session
+func (ss *sessionSet) drain() []*session {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	drained := make([]*session, 0, len(ss.sessions))
+	for id, sess := range ss.sessions {
+		drained = append(drained, sess)
+		delete(ss.sessions, id)
+	}
+	ss.bins = 0
+	return drained
+}
+
+// checkSubscribe validates a subscribe frame on its own. Sessions of a
+// multiplexed connection must be named, and only they may be.
+func checkSubscribe(frame subscribeFrame, identity authz.CertInfo, multiplexed bool) (ErrorFrame, bool) {
+	switch {
+	case frame.Type != frameSubscribe,
+		multiplexed != (frame.SessionID != ""),
+		len(frame.SessionID) > maxSessionIDLength,
+		len(frame.Prefixes) > maxPrefixSubscriptions:
+		return newErrorFrame(ErrBadSubscribe), false
+	case len(frame.Prefixes) > 0 && !identity.Admin:
+		// Bin range subscriptions are for operator services such as bridges
+		return newErrorFrame(ErrForbidden), false
+	}
+	return ErrorFrame{}, true
+}
+
+// openSession authorizes a subscribe frame, then subscribes a new session
+// to its bins and ranges, replays what they retain and acknowledges it.
+// refused is set if the session was not opened; err is set if the
+// connection failed during the replay.
+func (s *Server) openSession(ctx context.Context, sessions *sessionSet, identity authz.CertInfo, frame subscribeFrame) (refused *ErrorFrame, err error) {
+	refuse := func(errFrame ErrorFrame) (*ErrorFrame, error) {
+		errFrame.SessionID = frame.SessionID
+		return &errFrame, nil
+	}
+	if errFrame, ok := checkSubscribe(frame, identity, sessions.multiplexed); !ok {
+		return refuse(errFrame)
+	}
+
+	// Bins computed with an outdated mask are moved to the current one;
+	// malformed bins and those over the limits are rejected individually
+	mask := s.binManager.MaskState()
+	var rejected []protocol.RejectedBin
+	frame.BinIDs, rejected = filterBinIDs(frame.BinIDs, mask.Mask,
+		s.maxSubscribeBins, sessions.remainingBins(s.maxConnectionBins))
+
+	// Deployment policies may restrict which bins a certificate reads
+	subscribeRequest := authz.SubscribeRequest{
+		BinIDs:   frame.BinIDs,
+		Prefixes: frame.Prefixes,
+		Backfill: true,
+	}
+	if err := s.subscribeAuthz.AuthorizeSubscribe(ctx, identity, subscribeRequest); err != nil {
+		return refuse(subscribeErrorFrame(err))
+	}
+
+	// Generate client ID if not provided
+	clientID := frame.ClientID
+	if clientID == "" {
+		clientID = uuid.New().String()
+	}
+	// An ephemeral signing key is bound to this session alone
+	signing := s.signing
+	if !s.featureEnabled(FeatureEphemeralSignatures, identity.CertID) {
+		signing = nil
+	}
+	signingKey, errFrame, ok := signing.register(frame.SigningKey)
+	if !ok {
+		return refuse(errFrame)
+	}
+	sess := &session{
+		client:     &sessionClient{Client: sessions.client, sessionID: frame.SessionID},
+		clientID:   clientID,
+		binIDs:     frame.BinIDs,
+		prefixes:   len(frame.Prefixes) > 0,
+		signingKey: signingKey,
+		signing:    signing,
+	}
+	if errFrame, ok := sessions.add(sess); !ok {
+		signing.release(signingKey)
+		return refuse(errFrame)
+	}
+
+	// Subscribers see the session through the optional wrapper
+	var subscriber binmanager.Client = sess.client
+	if s.wrapSubscriber != nil {
+		subscriber = s.wrapSubscriber(sess.client)
+	}
+
+	// Subscribe to bins and replay what they retain
+	for _, binID := range frame.BinIDs {
+		s.binManager.Subscribe(binID, clientID, subscriber)
+		for _, msg := range s.binManager.GetRecentMessages(binID) {
+			if err := sess.client.SendMessage(msg); err != nil {
+				return nil, fmt.Errorf("replay: %w", err)
+			}
+		}
+	}
+
+	// Subscribe to bin ranges and replay what they already hold
+	if sess.prefixes {
+		s.binManager.SubscribePrefix(clientID, subscriber, frame.Prefixes)
+		for _, binID := range s.binManager.MatchingBins(frame.Prefixes) {
+			for _, msg := range s.binManager.GetRecentMessages(binID) {
+				if err := sess.client.SendMessage(msg); err != nil {
+					return nil, fmt.Errorf("replay: %w", err)
+				}
+			}
+		}
+	}
+
+	// Wake-ups are not needed for bins this connection receives directly
+	if s.push != nil {
+		sess.release = s.push.Connected(identity.CertID, frame.BinIDs)
+	}
+
+	// Acknowledge subscription
+	ack := map[string]interface{}{
+		"type":         "subscribe_ack",
+		"client_id":    clientID,
+		"bin_count":    len(frame.BinIDs),
+		"prefix_count": len(frame.Prefixes),
+		"bin_mask":     fmt.Sprintf("0x%X", mask.Mask),
+		"mask_epoch":   mask.Epoch,
+		"timestamp":    time.Now().Format(time.RFC3339),
+	}
+	if frame.SessionID != "" {
+		ack["session_id"] = frame.SessionID
+	}
+	if len(rejected) > 0 {
+		ack["rejected_bins"] = rejected
+	}
+	s.describeInstance(ack)
+	if err := sess.client.SendFrame(ack); err != nil {
+		return nil, fmt.Errorf("subscription ack: %w", err)
+	}
+	s.plugins.Emit(plugin.Event{
+		Type:       plugin.Subscribed,
+		CertID:     identity.CertID,
+		Connection: sessions.client.connection,
+		SessionID:  frame.SessionID,
+		BinIDs:     frame.BinIDs,
+	})
+	return nil, nil
+}
+
+// closeSession unsubscribes a session from everything it receives
+func (s *Server) closeSession(sess *session) {
+	for _, binID := range sess.binIDs {
+		s.binManager.Unsubscribe(binID, sess.clientID)
+	}
+	if sess.prefixes {
+		s.binManager.UnsubscribePrefix(sess.clientID)
+	}
+	if sess.release != nil {
+		sess.release()
+	}
+	sess.signing.release(sess.signingKey)
+}
+
+// handleSessionFrame acts on a frame of a multiplexed connection and returns
+// the session a publish frame belongs to. Frames that open or close sessions
+// are handled here and return nil, as do frames for unknown sessions, which
+// are refused. err is set if the connection failed.
+func (s *Server) handleSessionFrame(ctx context.Context, sessions *sessionSet, identity authz.CertInfo, data []byte) (*session, error) {
+	var header frameHeader
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, sessions.client.SendError(newErrorFrame(ErrBadRequest))
+	}
+
+	switch header.Type {
+	case frameSubscribe:
+		var frame subscribeFrame
+		if err := json.Unmarshal(data, &frame); err != nil {
+			return nil, sessions.client.SendError(newErrorFrame(ErrBadSubscribe))
+		}
+		refused, err := s.openSession(ctx, sessions, identity, frame)
+		if refused != nil {
+			return nil, sessions.client.SendError(*refused)
+		}
+		return nil, err
+
+	case frameCloseSession:
+		sess, open := sessions.remove(header.SessionID)
+		if !open {
+			return nil, s.refuseUnknownSession(sessions, header.SessionID)
+		}
+		s.closeSession(sess)
+		return nil, sessions.client.SendFrame(map[string]interface{}{
+			"type":       frameSessionClosed,
+			"session_id": header.SessionID,
+		})
+	}
+
+	sess := sessions.get(header.SessionID)
+	if sess == nil {
+		return nil, s.refuseUnknownSession(sessions, header.SessionID)
+	}
+	return sess, nil
+}
+
+// refuseUnknownSession reports a frame for a session that is not open
+func (s *Server) refuseUnknownSession(sessions *sessionSet, sessionID string) error {
+	frame := newErrorFrame(ErrUnknownSession)
+	frame.SessionID = sessionID
+	return sessions.client.SendError(frame)
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"sync"
+
+	"github.com/yourusername/secure-messaging-poc/internal/binmanager"
+	"github.com/yourusername/secure-messaging-poc/pkg/protocol"
+)
+
+// WithEphemeralSigning lets sessions register an ephemeral Ed25519 key in
+// their subscribe frame. A session with a key must sign every publish with
+// it; the server verifies the signature and attaches the key, so
+// recipients can tell messages of one session apart from spoofed ones
+// without learning the certificate behind it. A key is bound to one open
+// session at a time. With required set, subscribes without a key are
+// refused. Certificates the FeatureEphemeralSignatures flag is off for are
+// treated as if signing was not enabled.
+func WithEphemeralSigning(required bool) Option {
+	return func(s *Server) {
+		s.signing = &signingKeys{required: required, active: make(map[string]bool)}
+	}
+}
+
+// signingKeys tracks the ephemeral keys of open sessions. A nil
+// *signingKeys ignores registered keys and strips signatures.
+type signingKeys struct {
+	required bool
+	active   map[string]bool
+	mu       sync.Mutex
+}
+
+// register binds key to a new session. It returns the key to keep with the
+// session, nil if there is none, or the error frame to refuse the subscribe
+// with.
+func (sk *signingKeys) register(key []byte) (ed25519.PublicKey, ErrorFrame, bool) {
+	switch {
+	case sk == nil:
+		return nil, ErrorFrame{}, true
+	case len(key) == 0 && !sk.required:
+		return nil, ErrorFrame{}, true
+	case len(key) != ed25519.PublicKeySize:
+		return nil, newErrorFrame(ErrBadSubscribe), false
+	}
+
+	sk.mu.Lock()
+	defer sk.mu.Unlock()
+
+	// A key in use by another session could be used to replay its messages
+	if sk.active[string(key)] {
+		return nil, newErrorFrame(ErrBadSubscribe), false
+	}
+	sk.active[string(key)] = true
+	return ed25519.PublicKey(key), ErrorFrame{}, true
+}
+
+// release frees the key of a closed session
+func (sk *signingKeys) release(key ed25519.PublicKey) {
+	if sk == nil || key == nil {
+		return
+	}
+	sk.mu.Lock()
+	defer sk.mu.Unlock()
+	delete(sk.active, string(key))
+}
+
+// check verifies a decoded publish against the key of the session it came
+// from and attaches the key. Signer keys supplied by the client are never
+// trusted. Signatures cover the bin ID as the client sent it, before it is
+// normalized to the current mask.
+func (sk *signingKeys) check(key ed25519.PublicKey, msg *binmanager.Message) (ErrorFrame, bool) {
+	signature := msg.Signature
+	msg.SignerKey = nil
+	if sk == nil {
+		msg.Signature = nil
+		return ErrorFrame{}, true
+	}
+	if key == nil {
+		// Without a registered key a signature cannot be attributed
+		if len(signature) > 0 {
+			return newErrorFrame(ErrInvalidSignature), false
+		}
+		return ErrorFrame{}, true
+	}
+
+	payload := protocol.SignaturePayload(msg.BinID, msg.MessageID, msg.Ciphertext, msg.ReplyToID, msg.ThreadTag)
+	if !ed25519.Verify(key, payload, signature) {
+		return newErrorFrame(ErrInvalidSignature), false
+	}
+	msg.SignerKey = key
+	return ErrorFrame{}, true
+}
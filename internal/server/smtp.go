@@ -0,0 +1,76 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/google/uuid"
+
+	"github.com/yourusername/secure-messaging-poc/internal/authz"
+	"github.com/yourusername/secure-messaging-poc/internal/binmanager"
+	"github.com/yourusername/secure-messaging-poc/internal/smtpgate"
+)
+
+// SMTPGatewayID is the publisher identity of messages from the SMTP
+// gateway. Publish policies see it as the certificate ID; it never matches a
+// real certificate.
+const SMTPGatewayID = "smtp-gateway"
+
+// smtpGateway publishes mailed tips to a drop-box channel
+type smtpGateway struct {
+	address string
+	channel uint64
+	gateway *smtpgate.Gateway
+}
+
+// WithSMTPGateway accepts PGP or age encrypted mail on address and publishes
+// each payload to the bin of channel, discarding headers and everything
+// else. Without a TLS configuration of its own the gateway offers STARTTLS
+// with the server's certificate.
+func WithSMTPGateway(address string, channel uint64, config smtpgate.Config) Option {
+	return func(s *Server) {
+		s.smtp = &smtpGateway{address: address, channel: channel}
+		if config.TLSConfig == nil && s.tlsConfig != nil {
+			tlsConfig := s.tlsConfig.Clone()
+			tlsConfig.ClientAuth = tls.NoClientCert
+			tlsConfig.ClientCAs = nil
+			tlsConfig.VerifyPeerCertificate = nil
+			tlsConfig.GetConfigForClient = nil
+			config.TLSConfig = tlsConfig
+		}
+		s.smtp.gateway = smtpgate.New(config, s.submitTip)
+	}
+}
+
+// submitTip publishes a mailed payload through the ingestion pipeline as
+// the gateway's identity. Payloads the pipeline refuses for their content
+// are refused to the sender for good; anything else is worth a retry.
+func (s *Server) submitTip(ctx context.Context, payload []byte) error {
+	msg := binmanager.NewMessage(s.smtp.channel, uuid.New().String(), payload)
+	ctx = context.WithValue(ctx, identityKey{}, authz.CertInfo{CertID: SMTPGatewayID})
+	_, err := s.ingest.SubmitDurable(ctx, msg)
+	var stageErr *binmanager.StageError
+	if errors.As(err, &stageErr) && stageErr.Stage == binmanager.StageValidate {
+		return fmt.Errorf("%w: %v", smtpgate.ErrRejected, err)
+	}
+	if err == nil {
+		s.published.Inc()
+	}
+	return err
+}
+
+// startSMTP runs the SMTP gateway until Shutdown
+func (s *Server) startSMTP() {
+	log.Printf("Starting SMTP gateway on %s", s.smtp.address)
+	listener, err := s.listen(s.smtp.address)
+	if err != nil {
+		log.Printf("SMTP gateway failed: %v", err)
+		return
+	}
+	if err := s.smtp.gateway.Serve(listener); err != nil {
+		log.Printf("SMTP gateway failed: %v", err)
+	}
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/yourusername/secure-messaging-poc/internal/apispec"
+	"github.com/yourusername/secure-messaging-poc/pkg/protocol"
+)
+
+// specTitle and specVersion head the protocol documents
+const (
+	specTitle   = "anono.fi"
+	specVersion = "0.1.0"
+)
+
+// Frames clients send on /ws
+var clientFrames = []apispec.Frame{
+	{Name: frameSubscribe, Summary: "Open a session; the first frame of every connection", Type: subscribeFrame{}},
+	{Name: "message", Summary: "Publish a message", Type: protocol.Message{}},
+	{Name: frameCloseSession, Summary: "End a session of a multiplexed connection", Type: protocol.CloseSession{}},
+}
+
+// Frames the server sends on /ws
+var serverFrames = []apispec.Frame{
+	{Name: "message", Summary: "A message in a subscribed bin", Type: protocol.Message{}},
+	{Name: protocol.TypeSubscribeAck, Summary: "A session is open and its bins replayed", Type: protocol.SubscribeAck{}},
+	{Name: protocol.TypePublishAck, Summary: "A publish that requested an acknowledgement was accepted", Type: PublishAck{}},
+	{Name: frameSessionClosed, Summary: "Confirms a close_session", Type: protocol.SessionClosed{}},
+	{Name: protocol.TypeError, Summary: "A frame was refused or the connection is closing", Type: ErrorFrame{}},
+}
+
+// describe records an endpoint registered on mux for its protocol document
+func (s *Server) describe(mux *http.ServeMux, endpoint apispec.Endpoint) {
+	if s.endpoints == nil {
+		s.endpoints = make(map[*http.ServeMux][]apispec.Endpoint)
+	}
+	s.endpoints[mux] = append(s.endpoints[mux], endpoint)
+}
+
+// handleSpec serves an OpenAPI document of the endpoints registered on mux,
+// or with ?format=asyncapi an AsyncAPI document of the WebSocket frames,
+// so clients can generate bindings that follow the protocol as it evolves
+func (s *Server) handleSpec(mux *http.ServeMux) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var doc map[string]interface{}
+		switch r.URL.Query().Get("format") {
+		case "", "openapi":
+			doc = apispec.OpenAPI(specTitle, specVersion, s.endpoints[mux])
+		case "asyncapi":
+			doc = apispec.AsyncAPI(specTitle, specVersion, "/ws", clientFrames, serverFrames)
+		default:
+			http.Error(w, "Unknown format", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(doc)
+	}
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"log"
+	"time"
+
+	"github.com/yourusername/secure-messaging-poc/internal/binmanager"
+)
+
+// WithTimestampGranularity coarsens the server timestamps exposed to
+// clients, on delivered and replayed messages and publish acknowledgements,
+// to granularity. They are truncated to the start of their window or, with
+// jitter, moved to a pseudorandom point of it that is stable per bin and
+// window. Stored messages keep their exact time for retention. Zero exposes
+// exact timestamps.
+func WithTimestampGranularity(granularity time.Duration, jitter bool) Option {
+	return func(s *Server) {
+		s.timestampGranularity = granularity
+		s.timestampJitter = jitter
+	}
+}
+
+// setupTimestampJitter derives the jitter key from the CA key, so exposed
+// timestamps stay the same across restarts. Without one, timestamps are
+// truncated instead.
+func (s *Server) setupTimestampJitter() {
+	if !s.timestampJitter || s.timestampGranularity <= 0 {
+		return
+	}
+	key, err := s.certAuthority.DeriveSecret("anono timestamp jitter")
+	if err != nil {
+		log.Printf("Timestamp jitter disabled, truncating instead: %v", err)
+		s.timestampJitter = false
+		return
+	}
+	s.jitterKey = key
+}
+
+// exposeMessage returns msg with its timestamp as clients may see it
+func (s *Server) exposeMessage(msg *binmanager.Message) *binmanager.Message {
+	if s.jitterKey != nil {
+		return msg.Jittered(s.timestampGranularity, s.jitterKey)
+	}
+	return msg.Coarsened(s.timestampGranularity)
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/yourusername/secure-messaging-poc/pkg/protocol"
+)
+
+// WithClientUpdate publishes an update manifest at /api/client-update and
+// refuses WebSocket clients below its minimum protocol version
+func WithClientUpdate(manifest protocol.ClientUpdate) Option {
+	return func(s *Server) {
+		s.clientUpdate = &manifest
+	}
+}
+
+// handleClientUpdate serves the update manifest, signed by the CA so
+// clients can trust the download hashes even through mirrors. As with
+// /api/info, the JWS payload is authoritative.
+func (s *Server) handleClientUpdate(w http.ResponseWriter, r *http.Request) {
+	manifest := *s.clientUpdate
+	manifest.IssuedAt = time.Now().UTC().Truncate(time.Second)
+
+	payload, err := json.Marshal(manifest)
+	if err != nil {
+		http.Error(w, "Failed to encode update manifest", http.StatusInternalServerError)
+		return
+	}
+	signed, err := s.certAuthority.SignJWS(payload)
+	if err != nil {
+		http.Error(w, "Failed to sign update manifest", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"manifest":        manifest,
+		"signed_manifest": signed,
+	})
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/yourusername/secure-messaging-poc/internal/warrant"
+)
+
+// warrantCanaryPath is where the signed warrant canary is published
+const warrantCanaryPath = "/.well-known/warrant-canary"
+
+// WithWarrantCanary publishes the operator-signed warrant canary at
+// /.well-known/warrant-canary, on the discovery listener too, and reports
+// its freshness in /api/info. Admins replace it with a newer signed
+// document; the server cannot sign one itself.
+func WithWarrantCanary(store *warrant.Store) Option {
+	return func(s *Server) {
+		s.warrant = store
+	}
+}
+
+// handleWarrantCanary serves the current signed canary. Clients verify it
+// against the operator key they obtained out of band and check next_update
+// themselves; the server's own staleness report is a convenience.
+func (s *Server) handleWarrantCanary(w http.ResponseWriter, r *http.Request) {
+	doc := s.warrant.Document()
+	if doc == nil {
+		http.Error(w, "No warrant canary published", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	json.NewEncoder(w).Encode(doc)
+}
+
+// handleAdminWarrantCanary replaces the canary with a newer document
+// signed by the operator key
+func (s *Server) handleAdminWarrantCanary(w http.ResponseWriter, r *http.Request) {
+	var doc warrant.Document
+	if err := json.NewDecoder(r.Body).Decode(&doc); err != nil {
+		http.Error(w, "Invalid canary document", http.StatusBadRequest)
+		return
+	}
+	canary, err := s.warrant.Update(doc)
+	if err != nil {
+		httpError(w, err, "Failed to store warrant canary")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":      "success",
+		"issued":      canary.Issued.Format(time.RFC3339),
+		"next_update": canary.NextUpdate.Format(time.RFC3339),
+	})
+}
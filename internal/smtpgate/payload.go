@@ -0,0 +1,133 @@
+package smtpgate
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+)
+
+// maxMIMEDepth bounds the nesting of multipart bodies searched for a payload
+const maxMIMEDepth = 8
+
+var (
+	// ErrNotEncrypted is returned for mail without a PGP or age payload
+	ErrNotEncrypted = errors.New("smtpgate: message is not PGP or age encrypted")
+	// ErrMalformed is returned for mail that cannot be parsed
+	ErrMalformed = errors.New("smtpgate: malformed message")
+)
+
+// armor is the ASCII armor of an encrypted payload
+type armor struct {
+	begin, end []byte
+}
+
+// armors are the encrypted payloads recognized in text bodies
+var armors = []armor{
+	{[]byte("-----BEGIN PGP MESSAGE-----"), []byte("-----END PGP MESSAGE-----")},
+	{[]byte("-----BEGIN AGE ENCRYPTED FILE-----"), []byte("-----END AGE ENCRYPTED FILE-----")},
+}
+
+// ageHeader starts a binary age file
+var ageHeader = []byte("age-encryption.org/v1\n")
+
+// ExtractPayload returns the first PGP or age encrypted payload of a mail
+// message: an armored block in a text body or attachment, a binary age or
+// OpenPGP attachment, or the encrypted part of a PGP/MIME message. The
+// headers and everything else in the message are discarded.
+func ExtractPayload(raw []byte) ([]byte, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	return extractEntity(textproto.MIMEHeader(msg.Header), msg.Body, 0)
+}
+
+// extractEntity searches one MIME entity, descending into multiparts
+func extractEntity(header textproto.MIMEHeader, body io.Reader, depth int) ([]byte, error) {
+	mediaType, params, typeErr := mime.ParseMediaType(header.Get("Content-Type"))
+	if typeErr == nil && strings.HasPrefix(mediaType, "multipart/") {
+		if depth >= maxMIMEDepth || params["boundary"] == "" {
+			return nil, ErrMalformed
+		}
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextRawPart()
+			if err == io.EOF {
+				return nil, ErrNotEncrypted
+			}
+			if err != nil {
+				return nil, ErrMalformed
+			}
+			payload, err := extractEntity(part.Header, part, depth+1)
+			if !errors.Is(err, ErrNotEncrypted) {
+				return payload, err
+			}
+		}
+	}
+
+	data, err := io.ReadAll(decodeTransfer(header.Get("Content-Transfer-Encoding"), body))
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	// Text may start with bytes that look like a binary payload
+	binary := typeErr == nil && mediaType != "" && !strings.HasPrefix(mediaType, "text/")
+	return findPayload(data, binary)
+}
+
+// decodeTransfer undoes a content transfer encoding
+func decodeTransfer(encoding string, body io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		return quotedprintable.NewReader(body)
+	default:
+		return body
+	}
+}
+
+// findPayload returns the encrypted payload of a decoded body. Binary
+// payloads are only recognized in binary parts.
+func findPayload(data []byte, binary bool) ([]byte, error) {
+	for _, a := range armors {
+		begin := bytes.Index(data, a.begin)
+		if begin < 0 {
+			continue
+		}
+		end := bytes.Index(data[begin:], a.end)
+		if end < 0 {
+			return nil, ErrMalformed
+		}
+		block := data[begin : begin+end+len(a.end)]
+		// Mail clients may have turned line endings into CRLF
+		block = bytes.ReplaceAll(block, []byte("\r\n"), []byte("\n"))
+		return append(block, '\n'), nil
+	}
+	if binary && (bytes.HasPrefix(data, ageHeader) || isOpenPGPMessage(data)) {
+		return data, nil
+	}
+	return nil, ErrNotEncrypted
+}
+
+// isOpenPGPMessage reports whether data starts with the packet an OpenPGP
+// encrypted message starts with: a public-key or symmetric-key encrypted
+// session key (RFC 4880, section 4.2)
+func isOpenPGPMessage(data []byte) bool {
+	if len(data) < 2 || data[0]&0x80 == 0 {
+		return false
+	}
+	var tag byte
+	if data[0]&0x40 != 0 {
+		tag = data[0] & 0x3F // New format
+	} else {
+		tag = (data[0] >> 2) & 0x0F // Old format
+	}
+	return tag == 1 || tag == 3
+}
@@ -0,0 +1,60 @@
+package smtpgate
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+const armoredPGP = "-----BEGIN PGP MESSAGE-----\n\nhQEMA1234\n=abcd\n-----END PGP MESSAGE-----\n"
+
+func TestExtractPayloadArmoredBody(t *testing.T) {
+	raw := "From: source@example.org\r\nSubject: tip\r\nReceived: from somewhere\r\n\r\nPlease read:\r\n" +
+		strings.ReplaceAll(armoredPGP, "\n", "\r\n") + "Thanks\r\n"
+	payload, err := ExtractPayload([]byte(raw))
+	if err != nil {
+		t.Fatalf("ExtractPayload failed: %v", err)
+	}
+	if string(payload) != armoredPGP {
+		t.Errorf("Expected only the armored block, got %q", payload)
+	}
+}
+
+func TestExtractPayloadPGPMIME(t *testing.T) {
+	raw := "From: source@example.org\r\n" +
+		"Content-Type: multipart/encrypted; protocol=\"application/pgp-encrypted\"; boundary=\"b1\"\r\n\r\n" +
+		"--b1\r\nContent-Type: application/pgp-encrypted\r\n\r\nVersion: 1\r\n" +
+		"--b1\r\nContent-Type: application/octet-stream\r\n\r\n" + strings.ReplaceAll(armoredPGP, "\n", "\r\n") +
+		"--b1--\r\n"
+	payload, err := ExtractPayload([]byte(raw))
+	if err != nil || string(payload) != armoredPGP {
+		t.Errorf("Expected the encrypted part, got %q, %v", payload, err)
+	}
+}
+
+func TestExtractPayloadBinaryAttachment(t *testing.T) {
+	age := []byte("age-encryption.org/v1\n-> X25519 abc\nbody\x00\x01")
+	raw := "Content-Type: multipart/mixed; boundary=b2\r\n\r\n" +
+		"--b2\r\nContent-Type: text/plain\r\n\r\nSee attachment\r\n" +
+		"--b2\r\nContent-Type: application/octet-stream\r\nContent-Transfer-Encoding: base64\r\n\r\n" +
+		base64.StdEncoding.EncodeToString(age) + "\r\n" +
+		"--b2--\r\n"
+	payload, err := ExtractPayload([]byte(raw))
+	if err != nil || !bytes.Equal(payload, age) {
+		t.Errorf("Expected the age attachment, got %q, %v", payload, err)
+	}
+}
+
+func TestExtractPayloadRefusesPlaintext(t *testing.T) {
+	for name, raw := range map[string]string{
+		"plain":  "Subject: tip\r\n\r\nThe secret is out\r\n",
+		"utf8":   "Content-Type: text/plain; charset=utf-8\r\n\r\nÃ©té\r\n",
+		"binary": "Content-Type: application/octet-stream\r\n\r\nnot encrypted",
+	} {
+		if _, err := ExtractPayload([]byte(raw)); !errors.Is(err, ErrNotEncrypted) {
+			t.Errorf("%s: expected ErrNotEncrypted, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,428 @@
+// Package smtpgate accepts encrypted tips by email. It is a minimal SMTP
+// listener that takes PGP or age encrypted mail from plain email clients,
+// keeps only the encrypted payload and hands it to the server to publish
+// into a drop-box bin. Headers, envelope addresses and unencrypted text are
+// discarded without being logged, and unencrypted mail is refused.
+package smtpgate
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yourusername/secure-messaging-poc/internal/listenaddr"
+)
+
+// Gateway defaults
+const (
+	DefaultMaxSize        = 1 << 20
+	DefaultMaxConnections = 100
+	DefaultTimeout        = 5 * time.Minute
+	maxLineLength         = 1000 // RFC 5321, section 4.5.3.1.6
+	maxRecipients         = 100
+)
+
+// ErrRejected is wrapped by a SubmitFunc for payloads the server refuses for
+// good, such as oversized ones. Other errors are reported to the sender as
+// temporary, so their mail server retries.
+var ErrRejected = errors.New("smtpgate: payload rejected")
+
+// SubmitFunc publishes the encrypted payload of an accepted message
+type SubmitFunc func(ctx context.Context, payload []byte) error
+
+// Config configures a Gateway
+type Config struct {
+	Hostname       string      // Announced in the greeting
+	MaxSize        int64       // Largest message accepted, in bytes; DefaultMaxSize if zero
+	Recipients     []string    // Accepted recipient addresses; any if empty
+	TLSConfig      *tls.Config // Offers STARTTLS if set
+	MaxConnections int         // Concurrent sessions; DefaultMaxConnections if zero
+	Timeout        time.Duration
+	Family         string // listenaddr family ListenAndServe listens in; dual-stack if empty
+}
+
+// Gateway is the SMTP listener
+type Gateway struct {
+	config     Config
+	submit     SubmitFunc
+	recipients map[string]bool
+	slots      chan struct{}
+
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	closed   bool
+	mu       sync.Mutex
+	wg       sync.WaitGroup
+}
+
+// New creates a gateway that passes payloads to submit
+func New(config Config, submit SubmitFunc) *Gateway {
+	if config.Hostname == "" {
+		config.Hostname = "localhost"
+	}
+	if config.MaxSize <= 0 {
+		config.MaxSize = DefaultMaxSize
+	}
+	if config.MaxConnections <= 0 {
+		config.MaxConnections = DefaultMaxConnections
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultTimeout
+	}
+	recipients := make(map[string]bool, len(config.Recipients))
+	for _, address := range config.Recipients {
+		recipients[strings.ToLower(address)] = true
+	}
+	return &Gateway{
+		config:     config,
+		submit:     submit,
+		recipients: recipients,
+		slots:      make(chan struct{}, config.MaxConnections),
+		conns:      make(map[net.Conn]struct{}),
+	}
+}
+
+// ListenAndServe listens on address in the configured IP family and serves
+// sessions until Shutdown
+func (g *Gateway) ListenAndServe(address string) error {
+	family := g.config.Family
+	if family == "" {
+		family = listenaddr.FamilyDual
+	}
+	listener, err := listenaddr.Listen(family, address)
+	if err != nil {
+		return err
+	}
+	return g.Serve(listener)
+}
+
+// Serve serves sessions on listener until Shutdown. Connections over the
+// session limit are refused with a temporary error.
+func (g *Gateway) Serve(listener net.Listener) error {
+	g.mu.Lock()
+	if g.closed {
+		g.mu.Unlock()
+		listener.Close()
+		return net.ErrClosed
+	}
+	g.listener = listener
+	g.mu.Unlock()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			g.mu.Lock()
+			closed := g.closed
+			g.mu.Unlock()
+			if closed {
+				return nil
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+
+		select {
+		case g.slots <- struct{}{}:
+		default:
+			conn.SetWriteDeadline(time.Now().Add(time.Second))
+			fmt.Fprintf(conn, "421 4.3.2 %s too many connections\r\n", g.config.Hostname)
+			conn.Close()
+			continue
+		}
+		if !g.track(conn) {
+			<-g.slots
+			conn.Close()
+			return nil
+		}
+		g.wg.Add(1)
+		go func() {
+			defer g.wg.Done()
+			defer func() { <-g.slots }()
+			defer g.untrack(conn)
+			newSession(g, conn).serve()
+		}()
+	}
+}
+
+// Shutdown stops accepting connections and waits for sessions to end, or
+// closes them when ctx is done
+func (g *Gateway) Shutdown(ctx context.Context) error {
+	g.mu.Lock()
+	g.closed = true
+	if g.listener != nil {
+		g.listener.Close()
+	}
+	g.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		g.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		g.mu.Lock()
+		for conn := range g.conns {
+			conn.Close()
+		}
+		g.mu.Unlock()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// track records an open connection, or reports false after Shutdown
+func (g *Gateway) track(conn net.Conn) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.closed {
+		return false
+	}
+	g.conns[conn] = struct{}{}
+	return true
+}
+
+// untrack closes and forgets a connection
+func (g *Gateway) untrack(conn net.Conn) {
+	conn.Close()
+	g.mu.Lock()
+	delete(g.conns, conn)
+	g.mu.Unlock()
+}
+
+// session is one SMTP conversation
+type session struct {
+	gateway *Gateway
+	conn    net.Conn
+	reader  *bufio.Reader
+	tls     bool
+
+	greeted    bool
+	mail       bool
+	recipients int
+}
+
+// newSession wraps an accepted connection
+func newSession(g *Gateway, conn net.Conn) *session {
+	return &session{gateway: g, conn: conn, reader: bufio.NewReaderSize(conn, maxLineLength+2)}
+}
+
+// serve runs the conversation until QUIT, an error or a timeout
+func (s *session) serve() {
+	s.reply("220 %s ESMTP", s.gateway.config.Hostname)
+	for {
+		s.conn.SetDeadline(time.Now().Add(s.gateway.config.Timeout))
+		line, err := s.readLine()
+		if errors.Is(err, bufio.ErrBufferFull) {
+			s.reply("500 5.5.2 Line too long")
+			return
+		}
+		if err != nil {
+			return
+		}
+
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "HELO":
+			s.reset()
+			s.greeted = true
+			s.reply("250 %s", s.gateway.config.Hostname)
+		case "EHLO":
+			s.reset()
+			s.greeted = true
+			s.ehlo()
+		case "STARTTLS":
+			if !s.startTLS() {
+				return
+			}
+		case "MAIL":
+			s.mailFrom(arg)
+		case "RCPT":
+			s.rcptTo(arg)
+		case "DATA":
+			s.data()
+		case "RSET":
+			s.reset()
+			s.reply("250 2.0.0 OK")
+		case "NOOP":
+			s.reply("250 2.0.0 OK")
+		case "VRFY":
+			s.reply("252 2.5.0 Cannot verify")
+		case "QUIT":
+			s.reply("221 2.0.0 Bye")
+			return
+		default:
+			s.reply("502 5.5.1 Command not implemented")
+		}
+	}
+}
+
+// ehlo lists the extensions
+func (s *session) ehlo() {
+	lines := []string{
+		s.gateway.config.Hostname,
+		"SIZE " + strconv.FormatInt(s.gateway.config.MaxSize, 10),
+		"8BITMIME",
+	}
+	if s.gateway.config.TLSConfig != nil && !s.tls {
+		lines = append(lines, "STARTTLS")
+	}
+	for i, line := range lines {
+		separator := "-"
+		if i == len(lines)-1 {
+			separator = " "
+		}
+		s.reply("250%s%s", separator, line)
+	}
+}
+
+// startTLS upgrades the connection, reporting false if the session is over
+func (s *session) startTLS() bool {
+	if s.gateway.config.TLSConfig == nil || s.tls {
+		s.reply("502 5.5.1 Command not implemented")
+		return true
+	}
+	s.reply("220 2.0.0 Ready to start TLS")
+	conn := tls.Server(s.conn, s.gateway.config.TLSConfig)
+	if err := conn.Handshake(); err != nil {
+		return false
+	}
+	s.conn = conn
+	s.reader = bufio.NewReaderSize(conn, maxLineLength+2)
+	s.tls = true
+	s.greeted = false
+	s.reset()
+	return true
+}
+
+// mailFrom starts a transaction. The sender is not recorded.
+func (s *session) mailFrom(arg string) {
+	switch {
+	case !s.greeted:
+		s.reply("503 5.5.1 Say hello first")
+		return
+	case s.mail:
+		s.reply("503 5.5.1 Nested MAIL command")
+		return
+	case !strings.HasPrefix(strings.ToUpper(arg), "FROM:"):
+		s.reply("501 5.5.4 Syntax: MAIL FROM:<address>")
+		return
+	}
+	for _, param := range strings.Fields(arg)[1:] {
+		name, value, _ := strings.Cut(param, "=")
+		if strings.EqualFold(name, "SIZE") {
+			if size, err := strconv.ParseInt(value, 10, 64); err == nil && size > s.gateway.config.MaxSize {
+				s.reply("552 5.3.4 Message too large")
+				return
+			}
+		}
+	}
+	s.mail = true
+	s.reply("250 2.1.0 OK")
+}
+
+// rcptTo accepts a recipient if the gateway serves it
+func (s *session) rcptTo(arg string) {
+	if !s.mail {
+		s.reply("503 5.5.1 Need MAIL first")
+		return
+	}
+	if !strings.HasPrefix(strings.ToUpper(arg), "TO:") {
+		s.reply("501 5.5.4 Syntax: RCPT TO:<address>")
+		return
+	}
+	if s.recipients >= maxRecipients {
+		s.reply("452 4.5.3 Too many recipients")
+		return
+	}
+	address := strings.TrimSpace(arg[len("TO:"):])
+	if fields := strings.Fields(address); len(fields) > 0 {
+		address = fields[0]
+	}
+	address = strings.ToLower(strings.Trim(address, "<>"))
+	if len(s.gateway.recipients) > 0 && !s.gateway.recipients[address] {
+		s.reply("550 5.1.1 No such recipient")
+		return
+	}
+	s.recipients++
+	s.reply("250 2.1.5 OK")
+}
+
+// data receives the message and publishes its payload once, however many
+// recipients it was addressed to
+func (s *session) data() {
+	if s.recipients == 0 {
+		s.reply("503 5.5.1 Need RCPT first")
+		return
+	}
+	s.reply("354 End data with <CR><LF>.<CR><LF>")
+
+	maxSize := s.gateway.config.MaxSize
+	raw, err := io.ReadAll(io.LimitReader(textproto.NewReader(s.reader).DotReader(), maxSize+1))
+	if err != nil {
+		s.reset()
+		return
+	}
+	defer s.reset()
+	if int64(len(raw)) > maxSize {
+		// Read the rest, so the reply lines up with the end of the data
+		io.Copy(io.Discard, textproto.NewReader(s.reader).DotReader())
+		s.reply("552 5.3.4 Message too large")
+		return
+	}
+
+	payload, err := ExtractPayload(raw)
+	if err != nil {
+		s.reply("554 5.6.0 Only PGP or age encrypted mail is accepted")
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := s.gateway.submit(ctx, payload); err != nil {
+		if errors.Is(err, ErrRejected) {
+			s.reply("554 5.6.0 Message refused")
+			return
+		}
+		log.Printf("SMTP gateway failed to publish a message: %v", err)
+		s.reply("451 4.3.0 Try again later")
+		return
+	}
+	s.reply("250 2.0.0 Accepted")
+}
+
+// reset ends the current transaction
+func (s *session) reset() {
+	s.mail = false
+	s.recipients = 0
+}
+
+// readLine reads a command line without its line ending
+func (s *session) readLine() (string, error) {
+	line, err := s.reader.ReadSlice('\n')
+	if err != nil {
+		return "", err
+	}
+	return string(bytes.TrimRight(line, "\r\n")), nil
+}
+
+// reply writes a reply line
+func (s *session) reply(format string, args ...interface{}) {
+	fmt.Fprintf(s.conn, format+"\r\n", args...)
+}
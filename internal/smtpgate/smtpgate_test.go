@@ -0,0 +1,134 @@
+package smtpgate
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yourusername/secure-messaging-poc/internal/listenaddr"
+)
+
+// startGateway serves a gateway on a local port and returns its address
+func startGateway(t *testing.T, config Config, submit SubmitFunc) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	gateway := New(config, submit)
+	go gateway.Serve(listener)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		gateway.Shutdown(ctx)
+	})
+	return listener.Addr().String()
+}
+
+// smtpConn is the client side of a test session
+type smtpConn struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// expect sends a command, unless empty, and checks the reply code of the
+// last reply line
+func (c *smtpConn) expect(command, code string) {
+	c.t.Helper()
+	if command != "" {
+		fmt.Fprintf(c.conn, "%s\r\n", command)
+	}
+	for {
+		line, err := c.reader.ReadString('\n')
+		if err != nil {
+			c.t.Fatalf("%q: reading reply: %v", command, err)
+		}
+		if len(line) > 3 && line[3] == '-' {
+			continue
+		}
+		if !strings.HasPrefix(line, code) {
+			c.t.Fatalf("%q: expected %s, got %q", command, code, line)
+		}
+		return
+	}
+}
+
+func dialGateway(t *testing.T, address string) *smtpConn {
+	t.Helper()
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	c := &smtpConn{t: t, conn: conn, reader: bufio.NewReader(conn)}
+	c.expect("", "220")
+	return c
+}
+
+func TestGatewayPublishesEncryptedMail(t *testing.T) {
+	var mu sync.Mutex
+	var payloads [][]byte
+	address := startGateway(t, Config{Recipients: []string{"tips@example.org"}}, func(ctx context.Context, payload []byte) error {
+		mu.Lock()
+		defer mu.Unlock()
+		payloads = append(payloads, payload)
+		return nil
+	})
+
+	c := dialGateway(t, address)
+	c.expect("RCPT TO:<tips@example.org>", "503")
+	c.expect("EHLO client", "250")
+	c.expect("MAIL FROM:<source@example.org> SIZE=100", "250")
+	c.expect("RCPT TO:<someone@example.org>", "550")
+	c.expect("RCPT TO:<Tips@example.org>", "250")
+	c.expect("DATA", "354")
+	c.expect("Subject: tip\r\n\r\n..leading dot\r\n"+strings.ReplaceAll(armoredPGP, "\n", "\r\n")+".", "250")
+
+	// Unencrypted mail is refused
+	c.expect("MAIL FROM:<source@example.org>", "250")
+	c.expect("RCPT TO:<tips@example.org>", "250")
+	c.expect("DATA", "354")
+	c.expect("Subject: tip\r\n\r\nIn the clear\r\n.", "554")
+	c.expect("QUIT", "221")
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(payloads) != 1 || string(payloads[0]) != armoredPGP {
+		t.Errorf("Expected one armored payload, got %q", payloads)
+	}
+}
+
+func TestGatewayLimitsSize(t *testing.T) {
+	address := startGateway(t, Config{MaxSize: 64}, func(ctx context.Context, payload []byte) error {
+		t.Error("An oversized message should not be published")
+		return nil
+	})
+
+	c := dialGateway(t, address)
+	c.expect("HELO client", "250")
+	c.expect("MAIL FROM:<> SIZE=65", "552")
+	c.expect("MAIL FROM:<>", "250")
+	c.expect("RCPT TO:<tips@example.org>", "250")
+	c.expect("DATA", "354")
+	c.expect(strings.Repeat("x", 100)+"\r\n.", "552")
+	c.expect("NOOP", "250")
+}
+
+func TestListenAndServeUsesFamily(t *testing.T) {
+	gateway := New(Config{Family: listenaddr.FamilyIPv4}, nil)
+	if err := gateway.ListenAndServe("[::1]:0"); err == nil {
+		t.Error("An IPv4 gateway should not listen on an IPv6 address")
+	}
+
+	gateway = New(Config{Family: "ipx"}, nil)
+	if err := gateway.ListenAndServe(":0"); !errors.Is(err, listenaddr.ErrInvalidAddress) {
+		t.Errorf("An unknown family should fail with ErrInvalidAddress, got %v", err)
+	}
+}
@@ -0,0 +1,116 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/yourusername/secure-messaging-poc/internal/binmanager"
+)
+
+// ForBin returns the BinStore view for a single bin; it matches
+// binmanager.StoreFactory
+func (s *Store) ForBin(binID uint64) binmanager.BinStore {
+	return &binStore{db: s.db, binID: int64(binID)}
+}
+
+// Bins lists the IDs of all bins that have stored messages
+func (s *Store) Bins() ([]uint64, error) {
+	rows, err := s.db.Query(`SELECT DISTINCT bin_id FROM bin_messages`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	binIDs := make([]uint64, 0)
+	for rows.Next() {
+		var binID int64
+		if err := rows.Scan(&binID); err != nil {
+			return nil, err
+		}
+		binIDs = append(binIDs, uint64(binID))
+	}
+	return binIDs, rows.Err()
+}
+
+// binStore is the per-bin view of a Store. Bin IDs are stored as their
+// two's complement, since SQL has no unsigned 64-bit integer.
+type binStore struct {
+	db    *sql.DB
+	binID int64
+}
+
+// AppendMessage inserts the message; a message already stored at the same
+// time under the same ID is left as it is
+func (s *binStore) AppendMessage(msg *binmanager.Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`INSERT INTO bin_messages (bin_id, ts, message_id, size, data)
+		VALUES ($1, $2, $3, $4, $5) ON CONFLICT DO NOTHING`,
+		s.binID, nanos(msg.Timestamp), msg.MessageID, msg.Size(), data)
+	return err
+}
+
+// AppendMessageDurable inserts the message; every insert is committed
+// before it returns
+func (s *binStore) AppendMessageDurable(msg *binmanager.Message) error {
+	return s.AppendMessage(msg)
+}
+
+// RangeByTime selects the bin's messages between the two timestamps
+func (s *binStore) RangeByTime(from, to time.Time) ([]*binmanager.Message, error) {
+	rows, err := s.db.Query(`SELECT data FROM bin_messages
+		WHERE bin_id = $1 AND ts > $2 AND ts <= $3 ORDER BY ts, message_id`,
+		s.binID, nanos(from), nanos(to))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]*binmanager.Message, 0)
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var msg binmanager.Message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, err
+		}
+		result = append(result, &msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// DeleteBefore removes the bin's messages up to and including cutoff
+func (s *binStore) DeleteBefore(cutoff time.Time) (int, error) {
+	result, err := s.db.Exec(`DELETE FROM bin_messages WHERE bin_id = $1 AND ts <= $2`,
+		s.binID, nanos(cutoff))
+	if err != nil {
+		return 0, err
+	}
+	removed, err := result.RowsAffected()
+	return int(removed), err
+}
+
+// Stats summarizes the bin's messages in one query
+func (s *binStore) Stats() binmanager.StoreStats {
+	var stats binmanager.StoreStats
+	var oldest, newest sql.NullInt64
+	err := s.db.QueryRow(`SELECT COUNT(*), COALESCE(SUM(size), 0), MIN(ts), MAX(ts)
+		FROM bin_messages WHERE bin_id = $1`, s.binID).
+		Scan(&stats.MessageCount, &stats.Bytes, &oldest, &newest)
+	if err != nil {
+		return binmanager.StoreStats{}
+	}
+	if oldest.Valid {
+		stats.Oldest = time.Unix(0, oldest.Int64)
+		stats.Newest = time.Unix(0, newest.Int64)
+	}
+	return stats
+}
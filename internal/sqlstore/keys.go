@@ -0,0 +1,95 @@
+package sqlstore
+
+import (
+	"database/sql"
+
+	"github.com/yourusername/secure-messaging-poc/internal/keystore"
+)
+
+// KeyBackend returns the keystore.Backend kept in the database
+func (s *Store) KeyBackend() keystore.Backend {
+	return &keyBackend{db: s.db}
+}
+
+// keyBackend stores each key slot as its envelope, next to the slot's
+// version so writes can be checked against it
+type keyBackend struct {
+	db *sql.DB
+}
+
+// Slots reads and upgrades every envelope of a certificate
+func (b *keyBackend) Slots(certID string) ([]keystore.EncryptedKeyData, error) {
+	rows, err := b.db.Query(`SELECT envelope FROM key_slots WHERE cert_id = $1 ORDER BY slot`, certID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := make([]keystore.EncryptedKeyData, 0)
+	for rows.Next() {
+		var envelope []byte
+		if err := rows.Scan(&envelope); err != nil {
+			return nil, err
+		}
+		keyData, _, err := keystore.UnmarshalEnvelope(envelope)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, keyData)
+	}
+	return records, rows.Err()
+}
+
+// CertIDs lists the certificates that have slots, in order
+func (b *keyBackend) CertIDs() ([]string, error) {
+	rows, err := b.db.Query(`SELECT DISTINCT cert_id FROM key_slots ORDER BY cert_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	certIDs := make([]string, 0)
+	for rows.Next() {
+		var certID string
+		if err := rows.Scan(&certID); err != nil {
+			return nil, err
+		}
+		certIDs = append(certIDs, certID)
+	}
+	return certIDs, rows.Err()
+}
+
+// Put inserts a slot or replaces one of an older version in one statement,
+// so two instances writing the same slot cannot both succeed
+func (b *keyBackend) Put(keyData keystore.EncryptedKeyData) error {
+	envelope, err := keystore.MarshalEnvelope(keyData)
+	if err != nil {
+		return err
+	}
+	result, err := b.db.Exec(`INSERT INTO key_slots (cert_id, slot, version, envelope) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (cert_id, slot) DO UPDATE SET version = excluded.version, envelope = excluded.envelope
+		WHERE key_slots.version < excluded.version`,
+		keyData.CertID, keyData.Slot, int64(keyData.Version), envelope)
+	if err != nil {
+		return err
+	}
+	written, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if written == 0 {
+		return keystore.ErrSlotConflict
+	}
+	return nil
+}
+
+// Delete removes one slot, or every slot of the certificate if slot is ""
+func (b *keyBackend) Delete(certID, slot string) error {
+	var err error
+	if slot == "" {
+		_, err = b.db.Exec(`DELETE FROM key_slots WHERE cert_id = $1`, certID)
+	} else {
+		_, err = b.db.Exec(`DELETE FROM key_slots WHERE cert_id = $1 AND slot = $2`, certID, slot)
+	}
+	return err
+}
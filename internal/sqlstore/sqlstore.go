@@ -0,0 +1,174 @@
+// Package sqlstore keeps bin messages and key store slots in a SQL
+// database, so several server instances can share them. SQLite suits a
+// single host; Postgres serves deployments of more than one instance. The
+// schema is created and upgraded by Open, so a server starting against an
+// older database migrates it before serving.
+//
+// SQLite goes through github.com/mattn/go-sqlite3 and so needs cgo.
+package sqlstore
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"           // Registers the postgres driver
+	_ "github.com/mattn/go-sqlite3" // Registers the sqlite3 driver
+)
+
+var (
+	// ErrUnsupportedDSN is returned for a DSN naming no supported database
+	ErrUnsupportedDSN = errors.New("sqlstore: DSN must start with postgres://, postgresql:// or sqlite:")
+
+	// ErrSchemaVersion is returned for a database migrated by a newer
+	// server than this one
+	ErrSchemaVersion = errors.New("sqlstore: database schema is newer than this server")
+)
+
+// dialect holds what differs between the supported databases
+type dialect struct {
+	driver string
+	blob   string // Column type of binary data
+	lock   string // Serializes migrations between instances, if needed
+}
+
+var (
+	postgresDialect = dialect{
+		driver: "postgres",
+		blob:   "BYTEA",
+		lock:   "SELECT pg_advisory_xact_lock(7205759403792793)",
+	}
+	// Transactions on SQLite take the write lock when they begin, so
+	// instances opening the same file migrate it one at a time
+	sqliteDialect = dialect{
+		driver: "sqlite3",
+		blob:   "BLOB",
+	}
+)
+
+// migrations upgrade the schema, one version each, in order. {blob} is
+// replaced by the dialect's binary column type. Append new migrations;
+// never edit one that has shipped.
+var migrations = []string{
+	// 1: bin messages, keyed by time within a bin, and key slots stored as
+	// the same envelopes the key store file holds
+	`CREATE TABLE bin_messages (
+		bin_id     BIGINT NOT NULL,
+		ts         BIGINT NOT NULL,
+		message_id TEXT NOT NULL,
+		size       BIGINT NOT NULL,
+		data       {blob} NOT NULL,
+		PRIMARY KEY (bin_id, ts, message_id)
+	);
+	CREATE TABLE key_slots (
+		cert_id  TEXT NOT NULL,
+		slot     TEXT NOT NULL,
+		version  BIGINT NOT NULL,
+		envelope {blob} NOT NULL,
+		PRIMARY KEY (cert_id, slot)
+	);`,
+}
+
+// Store is a SQL database holding bin messages and key slots
+type Store struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+// Open connects to the database named by dsn and migrates its schema to
+// the current version. A DSN is a postgres:// or postgresql:// URL, or
+// sqlite: followed by a file path.
+func Open(dsn string) (*Store, error) {
+	var d dialect
+	switch {
+	case strings.HasPrefix(dsn, "postgres://"), strings.HasPrefix(dsn, "postgresql://"):
+		d = postgresDialect
+	case strings.HasPrefix(dsn, "sqlite:"):
+		d = sqliteDialect
+		dsn = strings.TrimPrefix(strings.TrimPrefix(dsn, "sqlite:"), "//")
+		if !strings.Contains(dsn, "?") {
+			dsn += "?_journal_mode=WAL&_busy_timeout=5000&_txlock=immediate"
+		}
+	default:
+		return nil, ErrUnsupportedDSN
+	}
+
+	db, err := sql.Open(d.driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{db: db, dialect: d}
+	if err := s.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Close closes the database
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// SchemaVersion returns the number of migrations applied to the database
+func (s *Store) SchemaVersion() (int, error) {
+	var version int
+	err := s.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	return version, err
+}
+
+// migrate applies the migrations the database lacks in one transaction
+func (s *Store) migrate() error {
+	if _, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		applied_at BIGINT NOT NULL
+	)`); err != nil {
+		return fmt.Errorf("sqlstore: create migrations table: %w", err)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if s.dialect.lock != "" {
+		if _, err := tx.Exec(s.dialect.lock); err != nil {
+			return fmt.Errorf("sqlstore: lock migrations: %w", err)
+		}
+	}
+	var current int
+	if err := tx.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+		return err
+	}
+	if current > len(migrations) {
+		return fmt.Errorf("%w: version %d, expected at most %d", ErrSchemaVersion, current, len(migrations))
+	}
+
+	blob := strings.NewReplacer("{blob}", s.dialect.blob)
+	for version := current + 1; version <= len(migrations); version++ {
+		if _, err := tx.Exec(blob.Replace(migrations[version-1])); err != nil {
+			return fmt.Errorf("sqlstore: migration %d: %w", version, err)
+		}
+		if _, err := tx.Exec(`INSERT INTO schema_migrations (version, applied_at) VALUES ($1, $2)`,
+			version, time.Now().Unix()); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// nanos returns ts in nanoseconds since the epoch, clamped to the range
+// of a BIGINT
+func nanos(ts time.Time) int64 {
+	switch {
+	case ts.Before(time.Unix(0, math.MinInt64)):
+		return math.MinInt64
+	case ts.After(time.Unix(0, math.MaxInt64)):
+		return math.MaxInt64
+	}
+	return ts.UnixNano()
+}
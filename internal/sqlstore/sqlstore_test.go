@@ -0,0 +1,146 @@
+package sqlstore
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/yourusername/secure-messaging-poc/internal/binmanager"
+	"github.com/yourusername/secure-messaging-poc/internal/keystore"
+)
+
+// openTestStore opens a SQLite store in a temporary directory
+func openTestStore(t *testing.T) (*Store, string) {
+	dsn := "sqlite:" + filepath.Join(t.TempDir(), "anono.db")
+	store, err := Open(dsn)
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store, dsn
+}
+
+func TestOpenMigrates(t *testing.T) {
+	store, dsn := openTestStore(t)
+	if version, err := store.SchemaVersion(); err != nil || version != len(migrations) {
+		t.Fatalf("SchemaVersion = %d, %v, want %d", version, err, len(migrations))
+	}
+
+	// Opening a migrated database again applies nothing
+	again, err := Open(dsn)
+	if err != nil {
+		t.Fatalf("Failed to reopen store: %v", err)
+	}
+	again.Close()
+
+	if _, err := Open("mysql://localhost/anono"); !errors.Is(err, ErrUnsupportedDSN) {
+		t.Errorf("Expected ErrUnsupportedDSN, got %v", err)
+	}
+}
+
+func TestBinStore(t *testing.T) {
+	store, _ := openTestStore(t)
+	now := time.Now()
+
+	bin := store.ForBin(0x1000)
+	messages := []*binmanager.Message{
+		{BinID: 0x1000, MessageID: "msg1", Ciphertext: []byte("data1"), Timestamp: now.Add(-3 * time.Hour)},
+		{BinID: 0x1000, MessageID: "msg2", Ciphertext: []byte("data2"), Timestamp: now.Add(-2 * time.Hour)},
+		{BinID: 0x1000, MessageID: "msg3", Ciphertext: []byte("data3"), Timestamp: now.Add(-1 * time.Hour)},
+	}
+	for _, msg := range messages {
+		if err := bin.AppendMessage(msg); err != nil {
+			t.Fatalf("Failed to append message: %v", err)
+		}
+	}
+	if err := bin.AppendMessage(messages[0]); err != nil {
+		t.Errorf("Appending a message twice should be ignored, got %v", err)
+	}
+
+	stats := bin.Stats()
+	if stats.MessageCount != 3 || stats.Bytes != messages[0].Size()*3 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+	if !stats.Oldest.Equal(messages[0].Timestamp) || !stats.Newest.Equal(messages[2].Timestamp) {
+		t.Errorf("Stats time range incorrect: %v - %v", stats.Oldest, stats.Newest)
+	}
+
+	// Range is exclusive of from and inclusive of to
+	result, err := bin.RangeByTime(messages[0].Timestamp, messages[1].Timestamp)
+	if err != nil || len(result) != 1 || result[0].MessageID != "msg2" {
+		t.Errorf("RangeByTime = %v, %v", result, err)
+	}
+
+	if removed, err := bin.DeleteBefore(now.Add(-90 * time.Minute)); err != nil || removed != 2 {
+		t.Errorf("DeleteBefore = %d, %v, want 2 removed", removed, err)
+	}
+	result, err = bin.RangeByTime(time.Time{}, time.Unix(1<<40, 0))
+	if err != nil || len(result) != 1 || result[0].MessageID != "msg3" {
+		t.Errorf("Only msg3 should remain, got %v, %v", result, err)
+	}
+
+	// Bin IDs above the signed range round-trip
+	high := uint64(0xFFFFFFFFFFFFF000)
+	if err := store.ForBin(high).AppendMessage(&binmanager.Message{BinID: high, MessageID: "high", Timestamp: now}); err != nil {
+		t.Fatalf("Failed to append message: %v", err)
+	}
+	binIDs, err := store.Bins()
+	if err != nil || len(binIDs) != 2 {
+		t.Fatalf("Bins = %X, %v", binIDs, err)
+	}
+	if binIDs[0] != high && binIDs[1] != high {
+		t.Errorf("Bins should include %X, got %X", high, binIDs)
+	}
+}
+
+func TestKeyBackendSharedBetweenInstances(t *testing.T) {
+	store, dsn := openTestStore(t)
+	other, err := Open(dsn)
+	if err != nil {
+		t.Fatalf("Failed to open second store: %v", err)
+	}
+	defer other.Close()
+
+	first := keystore.NewEncryptedKeyStoreWithBackend(store.KeyBackend())
+	second := keystore.NewEncryptedKeyStoreWithBackend(other.KeyBackend())
+
+	if _, err := first.StoreSlot("cert", "phone", []byte("key"), []byte("iv"), []byte("mac")); err != nil {
+		t.Fatalf("StoreSlot failed: %v", err)
+	}
+
+	// The second instance sees the slot and continues its versions
+	keyData, err := second.GetSlot("cert", "phone")
+	if err != nil || string(keyData.EncryptedKey) != "key" {
+		t.Fatalf("GetSlot = %+v, %v", keyData, err)
+	}
+	if version, err := second.StoreSlot("cert", "phone", []byte("key2"), []byte("iv"), []byte("mac")); err != nil || version != 2 {
+		t.Fatalf("StoreSlot = %d, %v, want version 2", version, err)
+	}
+	if manifest := first.Manifest("cert"); manifest["phone"] != 2 {
+		t.Errorf("First instance should see version 2, got %v", manifest)
+	}
+
+	// A write based on an outdated version is refused
+	keyData.EncryptedKey = []byte("stale")
+	if err := store.KeyBackend().Put(keyData); !errors.Is(err, keystore.ErrSlotConflict) {
+		t.Errorf("Expected ErrSlotConflict, got %v", err)
+	}
+
+	if !first.MigrateID("cert", "cert2") {
+		t.Fatal("MigrateID should move the slots")
+	}
+	if _, err := second.GetSlot("cert", "phone"); !errors.Is(err, keystore.ErrKeyNotFound) {
+		t.Errorf("Migrated slot should be gone from the old ID, got %v", err)
+	}
+	if keys := second.ListKeys(); len(keys) != 1 || keys[0] != "cert2" {
+		t.Errorf("ListKeys = %v", keys)
+	}
+
+	if err := second.DeleteKey("cert2"); err != nil {
+		t.Fatalf("DeleteKey failed: %v", err)
+	}
+	if _, err := first.GetSlot("cert2", "phone"); !errors.Is(err, keystore.ErrKeyNotFound) {
+		t.Errorf("Deleted slot should be gone, got %v", err)
+	}
+}
@@ -0,0 +1,297 @@
+// Package tlsconfig builds listener TLS configurations from a configured
+// policy: protocol version, cipher suites, curves, session tickets, client
+// authentication mode and ALPN protocols.
+package tlsconfig
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/yourusername/secure-messaging-poc/internal/certmanager"
+)
+
+// Client authentication modes accepted in a Policy
+const (
+	ClientAuthNone             = "none"
+	ClientAuthRequest          = "request"
+	ClientAuthRequire          = "require"
+	ClientAuthVerifyIfGiven    = "verify_if_given"
+	ClientAuthRequireAndVerify = "require_and_verify"
+)
+
+var (
+	// ErrUnknownVersion is returned for a minimum version other than 1.2 or 1.3
+	ErrUnknownVersion = errors.New("tlsconfig: unknown TLS version")
+	// ErrUnknownCipherSuite is returned for an unknown or insecure cipher suite
+	ErrUnknownCipherSuite = errors.New("tlsconfig: unknown or insecure cipher suite")
+	// ErrUnknownCurve is returned for an unsupported curve name
+	ErrUnknownCurve = errors.New("tlsconfig: unknown curve")
+	// ErrUnknownClientAuth is returned for an unknown client authentication mode
+	ErrUnknownClientAuth = errors.New("tlsconfig: unknown client auth mode")
+	// ErrNoTrustStore is returned when a policy verifies client certificates
+	// but the builder has nothing to verify them against
+	ErrNoTrustStore = errors.New("tlsconfig: client verification requires a trust store")
+	// ErrNoSuiteForKey is returned when TLS 1.2 cipher suites are configured
+	// but none of them can be used with a certificate's key type
+	ErrNoSuiteForKey = errors.New("tlsconfig: no configured cipher suite for certificate key")
+	// ErrUnverifiedClientAuth is returned for a client auth mode that does
+	// not verify certificates on a listener that identifies clients by them
+	ErrUnverifiedClientAuth = errors.New("tlsconfig: client auth mode does not verify the certificates that identify clients")
+	// ErrUnverifiedPeer is returned for a client certificate that was
+	// presented but not verified against the trust store
+	ErrUnverifiedPeer = errors.New("tlsconfig: client certificate not verified")
+)
+
+// Policy is the TLS configuration of one listener. Empty fields keep the
+// defaults: TLS 1.3, Go's cipher suites and curves, no client certificates.
+type Policy struct {
+	MinVersion     string   // "1.2" or "1.3"
+	CipherSuites   []string // IANA names; only used for TLS 1.2
+	Curves         []string // X25519, P256, P384, P521, in preference order
+	SessionTickets bool
+	ClientAuth     string
+	ALPN           []string
+}
+
+// curves maps accepted curve names to their IDs
+var curves = map[string]tls.CurveID{
+	"x25519": tls.X25519,
+	"p256":   tls.CurveP256,
+	"p-256":  tls.CurveP256,
+	"p384":   tls.CurveP384,
+	"p-384":  tls.CurveP384,
+	"p521":   tls.CurveP521,
+	"p-521":  tls.CurveP521,
+}
+
+// clientAuthModes maps accepted client auth modes to their tls values
+var clientAuthModes = map[string]tls.ClientAuthType{
+	"":                         tls.NoClientCert,
+	ClientAuthNone:             tls.NoClientCert,
+	ClientAuthRequest:          tls.RequestClientCert,
+	ClientAuthRequire:          tls.RequireAnyClientCert,
+	ClientAuthVerifyIfGiven:    tls.VerifyClientCertIfGiven,
+	ClientAuthRequireAndVerify: tls.RequireAndVerifyClientCert,
+}
+
+// Builder turns a Policy into a *tls.Config for a listener
+type Builder struct {
+	policy       Policy
+	trust        *certmanager.TrustStore
+	revocation   *certmanager.RevocationManager
+	fingerprints *certmanager.FingerprintList
+	referrers    bool
+	identifies   bool
+	certificates []tls.Certificate
+}
+
+// New starts building a configuration for policy
+func New(policy Policy) *Builder {
+	return &Builder{policy: policy, referrers: true}
+}
+
+// WithTrustStore verifies client certificates against the trust store's
+// current anchors, picking up reloads on each handshake
+func (b *Builder) WithTrustStore(trust *certmanager.TrustStore) *Builder {
+	b.trust = trust
+	return b
+}
+
+// WithRevocation rejects revoked client certificates and certificates whose
+// referrer is revoked
+func (b *Builder) WithRevocation(rm *certmanager.RevocationManager) *Builder {
+	b.revocation = rm
+	return b
+}
+
+// IdentifyClients marks a listener whose handlers identify clients by their
+// certificate. Build then refuses every client auth mode but
+// verify_if_given and require_and_verify, since the others pass on
+// certificates nothing has verified, such as self-signed ones.
+func (b *Builder) IdentifyClients() *Builder {
+	b.identifies = true
+	return b
+}
+
+// AllowRevokedReferrers accepts certificates whose referrer is revoked, for
+// servers that restrict them by policy instead of refusing the handshake
+func (b *Builder) AllowRevokedReferrers() *Builder {
+	b.referrers = false
+	return b
+}
+
+// WithFingerprints rejects client certificates whose key is on the
+// fingerprint denylist, before any revocation check
+func (b *Builder) WithFingerprints(fl *certmanager.FingerprintList) *Builder {
+	b.fingerprints = fl
+	return b
+}
+
+// WithCertificate adds a certificate the listener presents to clients
+func (b *Builder) WithCertificate(cert tls.Certificate) *Builder {
+	b.certificates = append(b.certificates, cert)
+	return b
+}
+
+// Build validates the policy and returns the configuration
+func (b *Builder) Build() (*tls.Config, error) {
+	config := &tls.Config{
+		SessionTicketsDisabled: !b.policy.SessionTickets,
+		NextProtos:             append([]string(nil), b.policy.ALPN...),
+		Certificates:           append([]tls.Certificate(nil), b.certificates...),
+	}
+
+	switch b.policy.MinVersion {
+	case "", "1.3":
+		config.MinVersion = tls.VersionTLS13
+	case "1.2":
+		config.MinVersion = tls.VersionTLS12
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownVersion, b.policy.MinVersion)
+	}
+
+	for _, name := range b.policy.CipherSuites {
+		id, err := cipherSuite(name)
+		if err != nil {
+			return nil, err
+		}
+		config.CipherSuites = append(config.CipherSuites, id)
+	}
+	if config.MinVersion == tls.VersionTLS12 && len(config.CipherSuites) > 0 {
+		for _, cert := range config.Certificates {
+			if err := checkSuitesForKey(b.policy.CipherSuites, cert.PrivateKey); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for _, name := range b.policy.Curves {
+		id, ok := curves[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownCurve, name)
+		}
+		config.CurvePreferences = append(config.CurvePreferences, id)
+	}
+
+	clientAuth, ok := clientAuthModes[b.policy.ClientAuth]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownClientAuth, b.policy.ClientAuth)
+	}
+	config.ClientAuth = clientAuth
+
+	verifies := clientAuth == tls.VerifyClientCertIfGiven || clientAuth == tls.RequireAndVerifyClientCert
+	if b.identifies && !verifies {
+		return nil, fmt.Errorf("%w: %s", ErrUnverifiedClientAuth, b.policy.ClientAuth)
+	}
+	if verifies && b.trust == nil {
+		return nil, ErrNoTrustStore
+	}
+	if clientAuth == tls.NoClientCert {
+		return config, nil
+	}
+
+	if b.revocation != nil || b.fingerprints != nil {
+		config.VerifyPeerCertificate = verifyPeer(b.fingerprints, b.revocation, b.referrers)
+		config.VerifyConnection = verifyResumed(b.fingerprints, b.revocation, b.referrers)
+	}
+	if b.trust != nil {
+		config = b.trust.TLSConfig(config)
+	}
+	return config, nil
+}
+
+// cipherSuite looks up a secure cipher suite by its IANA name
+func cipherSuite(name string) (uint16, error) {
+	for _, suite := range tls.CipherSuites() {
+		if suite.Name == name {
+			return suite.ID, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %s", ErrUnknownCipherSuite, name)
+}
+
+// checkSuitesForKey makes sure TLS 1.2 clients can complete a handshake
+// with a certificate whose private key is key: RSA keys need an _RSA_ suite,
+// while ECDSA and Ed25519 keys need an _ECDSA_ suite
+func checkSuitesForKey(names []string, key crypto.PrivateKey) error {
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil
+	}
+	want := "_ECDSA_"
+	if _, ok := signer.Public().(*rsa.PublicKey); ok {
+		want = "_RSA_"
+	}
+	for _, name := range names {
+		if strings.Contains(name, want) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %T needs a %s suite", ErrNoSuiteForKey, signer.Public(), strings.Trim(want, "_"))
+}
+
+// verifyPeer rejects client certificates on the fingerprint denylist, then
+// those that are revoked or, if referrers is set, whose referrer is revoked.
+// Either check may be nil. A certificate the client auth mode did not
+// verify, as with request or require, is refused rather than passed on
+// unchecked.
+func verifyPeer(fl *certmanager.FingerprintList, rm *certmanager.RevocationManager, referrers bool) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		if len(verifiedChains) == 0 || len(verifiedChains[0]) == 0 {
+			if len(rawCerts) > 0 {
+				return ErrUnverifiedPeer
+			}
+			return nil // No certificate, which the mode allowed
+		}
+		return checkPeer(verifiedChains[0][0], fl, rm, referrers)
+	}
+}
+
+// verifyResumed runs the verifyPeer checks again on resumed sessions, which
+// skip VerifyPeerCertificate, so a certificate revoked after its session
+// ticket was issued cannot resume. Full handshakes were checked already.
+func verifyResumed(fl *certmanager.FingerprintList, rm *certmanager.RevocationManager, referrers bool) func(tls.ConnectionState) error {
+	return func(state tls.ConnectionState) error {
+		if !state.DidResume || len(state.PeerCertificates) == 0 {
+			return nil
+		}
+		return checkPeer(state.PeerCertificates[0], fl, rm, referrers)
+	}
+}
+
+// checkPeer applies the denylist and revocation checks to a client
+// certificate
+func checkPeer(cert *x509.Certificate, fl *certmanager.FingerprintList, rm *certmanager.RevocationManager, referrers bool) error {
+	if fl != nil {
+		if err := fl.Check(cert); err != nil {
+			return err
+		}
+	}
+	if rm == nil {
+		return nil
+	}
+
+	certID := certmanager.CertificateID(cert)
+
+	// Migrate any state recorded under the legacy serial identifier
+	rm.RegisterAlias(cert.SerialNumber.String(), certID)
+
+	if rm.IsRevoked(certID) {
+		return certmanager.ErrCertificateRevoked
+	}
+
+	if !referrers {
+		return nil
+	}
+	referrerID, err := certmanager.ExtractReferrerID(cert)
+	if err == nil && referrerID != "" && rm.IsRevoked(referrerID) {
+		return certmanager.ErrReferrerRevoked
+	}
+
+	return nil
+}
@@ -0,0 +1,333 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/yourusername/secure-messaging-poc/internal/certmanager"
+)
+
+// newTestCert creates a self-signed certificate
+func newTestCert(t *testing.T) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %v", err)
+	}
+	return cert
+}
+
+func TestBuildDefaults(t *testing.T) {
+	config, err := New(Policy{}).Build()
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	if config.MinVersion != tls.VersionTLS13 {
+		t.Errorf("Expected TLS 1.3 minimum, got %x", config.MinVersion)
+	}
+	if config.ClientAuth != tls.NoClientCert {
+		t.Errorf("Expected no client auth, got %v", config.ClientAuth)
+	}
+	if !config.SessionTicketsDisabled {
+		t.Errorf("Session tickets should be off unless enabled")
+	}
+}
+
+func TestBuildPolicy(t *testing.T) {
+	ca := newTestCert(t)
+	trust := certmanager.NewTrustStore("", ca)
+	policy := Policy{
+		MinVersion:     "1.2",
+		CipherSuites:   []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"},
+		Curves:         []string{"X25519", "P-256"},
+		SessionTickets: true,
+		ClientAuth:     ClientAuthRequireAndVerify,
+		ALPN:           []string{"http/1.1"},
+	}
+
+	config, err := New(policy).WithTrustStore(trust).WithRevocation(certmanager.NewRevocationManager()).Build()
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	if config.MinVersion != tls.VersionTLS12 || len(config.CipherSuites) != 1 {
+		t.Errorf("Version or cipher suites not applied: %x %v", config.MinVersion, config.CipherSuites)
+	}
+	if len(config.CurvePreferences) != 2 || config.CurvePreferences[0] != tls.X25519 {
+		t.Errorf("Curves not applied: %v", config.CurvePreferences)
+	}
+	if config.SessionTicketsDisabled || config.ClientAuth != tls.RequireAndVerifyClientCert {
+		t.Errorf("Session tickets or client auth not applied")
+	}
+	if config.ClientCAs == nil || config.GetConfigForClient == nil ||
+		config.VerifyPeerCertificate == nil || config.VerifyConnection == nil {
+		t.Errorf("Trust store and revocation checks not installed")
+	}
+	if len(config.NextProtos) != 1 || config.NextProtos[0] != "http/1.1" {
+		t.Errorf("ALPN not applied: %v", config.NextProtos)
+	}
+}
+
+func TestBuildRejectsInvalidPolicy(t *testing.T) {
+	tests := []struct {
+		policy Policy
+		err    error
+	}{
+		{Policy{MinVersion: "1.0"}, ErrUnknownVersion},
+		{Policy{CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}}, ErrUnknownCipherSuite},
+		{Policy{Curves: []string{"P192"}}, ErrUnknownCurve},
+		{Policy{ClientAuth: "sometimes"}, ErrUnknownClientAuth},
+		{Policy{ClientAuth: ClientAuthRequireAndVerify}, ErrNoTrustStore},
+	}
+	for _, tt := range tests {
+		if _, err := New(tt.policy).Build(); !errors.Is(err, tt.err) {
+			t.Errorf("Policy %+v: expected %v, got %v", tt.policy, tt.err, err)
+		}
+	}
+
+	// A listener that identifies clients only takes verifying modes
+	trust := certmanager.NewTrustStore("", newTestCert(t))
+	for _, mode := range []string{"", ClientAuthNone, ClientAuthRequest, ClientAuthRequire} {
+		_, err := New(Policy{ClientAuth: mode}).WithTrustStore(trust).IdentifyClients().Build()
+		if !errors.Is(err, ErrUnverifiedClientAuth) {
+			t.Errorf("Mode %q: expected ErrUnverifiedClientAuth, got %v", mode, err)
+		}
+	}
+	for _, mode := range []string{ClientAuthVerifyIfGiven, ClientAuthRequireAndVerify} {
+		if _, err := New(Policy{ClientAuth: mode}).WithTrustStore(trust).IdentifyClients().Build(); err != nil {
+			t.Errorf("Mode %q should be accepted, got %v", mode, err)
+		}
+	}
+}
+
+func TestBuildChecksSuitesForKey(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	cert := tls.Certificate{Certificate: [][]byte{newTestCert(t).Raw}, PrivateKey: key}
+
+	rsaOnly := Policy{MinVersion: "1.2", CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}}
+	if _, err := New(rsaOnly).WithCertificate(cert).Build(); !errors.Is(err, ErrNoSuiteForKey) {
+		t.Errorf("Expected ErrNoSuiteForKey for an ECDSA key with RSA suites, got %v", err)
+	}
+
+	rsaOnly.CipherSuites = append(rsaOnly.CipherSuites, "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256")
+	if _, err := New(rsaOnly).WithCertificate(cert).Build(); err != nil {
+		t.Errorf("Expected an ECDSA suite to be accepted, got %v", err)
+	}
+}
+
+func TestVerifyPeer(t *testing.T) {
+	cert := newTestCert(t)
+	rm := certmanager.NewRevocationManager()
+	fl, _ := certmanager.NewFingerprintList("")
+	verify := verifyPeer(fl, rm, true)
+
+	chains := [][]*x509.Certificate{{cert}}
+	if err := verify(nil, chains); err != nil {
+		t.Fatalf("Unrevoked certificate rejected: %v", err)
+	}
+
+	// The denylist applies without touching the revocation state
+	fl.Deny(certmanager.SPKIFingerprint(cert), "")
+	if err := verify(nil, chains); err != certmanager.ErrFingerprintDenied {
+		t.Errorf("Expected ErrFingerprintDenied, got %v", err)
+	}
+	fl.Remove(certmanager.SPKIFingerprint(cert))
+
+	rm.Revoke(certmanager.CertificateID(cert))
+	if err := verify(nil, chains); err != certmanager.ErrCertificateRevoked {
+		t.Errorf("Expected ErrCertificateRevoked, got %v", err)
+	}
+
+	// A certificate the mode did not verify is not passed on
+	if err := verify([][]byte{cert.Raw}, nil); err != ErrUnverifiedPeer {
+		t.Errorf("Expected ErrUnverifiedPeer, got %v", err)
+	}
+	if err := verify(nil, nil); err != nil {
+		t.Errorf("A connection without a certificate should pass, got %v", err)
+	}
+}
+
+func TestSelfSignedClientCertificateRejected(t *testing.T) {
+	serverCert, _ := newTestLeaf(t, x509.ExtKeyUsageServerAuth)
+	_, ca := newTestLeaf(t, x509.ExtKeyUsageClientAuth)
+	selfSigned, _ := newTestLeaf(t, x509.ExtKeyUsageClientAuth)
+	clientConfig := &tls.Config{
+		Certificates:       []tls.Certificate{selfSigned},
+		InsecureSkipVerify: true,
+	}
+
+	// The verifying modes refuse a certificate from another issuer
+	for _, mode := range []string{ClientAuthVerifyIfGiven, ClientAuthRequireAndVerify} {
+		serverConfig, err := New(Policy{ClientAuth: mode}).
+			WithTrustStore(certmanager.NewTrustStore("", ca)).
+			WithRevocation(certmanager.NewRevocationManager()).
+			WithCertificate(serverCert).
+			IdentifyClients().
+			Build()
+		if err != nil {
+			t.Fatalf("Build failed: %v", err)
+		}
+		if _, err := testHandshake(t, serverConfig, clientConfig); err == nil {
+			t.Errorf("Mode %s accepted a certificate from an untrusted issuer", mode)
+		}
+	}
+
+	// Modes that skip verification refuse it too, if checks are installed
+	serverConfig, err := New(Policy{ClientAuth: ClientAuthRequire}).
+		WithRevocation(certmanager.NewRevocationManager()).
+		WithCertificate(serverCert).
+		Build()
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	if _, err := testHandshake(t, serverConfig, clientConfig); !errors.Is(err, ErrUnverifiedPeer) {
+		t.Errorf("Expected ErrUnverifiedPeer for an unverified certificate, got %v", err)
+	}
+}
+
+// newTestLeaf issues a certificate for extKeyUsage signed by a fresh CA and
+// returns it with the CA certificate
+func newTestLeaf(t *testing.T, extKeyUsage x509.ExtKeyUsage) (tls.Certificate, *x509.Certificate) {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("Failed to create CA certificate: %v", err)
+	}
+	ca, _ := x509.ParseCertificate(caDER)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "test leaf"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{extKeyUsage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, ca
+}
+
+// testHandshake connects a client to a server using the two configurations
+// and reports whether the session was resumed
+func testHandshake(t *testing.T, serverConfig, clientConfig *tls.Config) (bool, error) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			serverErr <- err
+			return
+		}
+		defer conn.Close()
+		tlsConn := tls.Server(conn, serverConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			serverErr <- err
+			return
+		}
+		_, err = tlsConn.Write([]byte{1})
+		serverErr <- err
+	}()
+
+	conn, err := tls.Dial("tcp", listener.Addr().String(), clientConfig)
+	if err != nil {
+		<-serverErr
+		return false, err
+	}
+	defer conn.Close()
+
+	// Reading processes the session ticket sent after the handshake
+	_, readErr := conn.Read(make([]byte, 1))
+	resumed := conn.ConnectionState().DidResume
+	if err := <-serverErr; err != nil {
+		return resumed, err
+	}
+	return resumed, readErr
+}
+
+func TestRevokedCertificateCannotResume(t *testing.T) {
+	serverCert, _ := newTestLeaf(t, x509.ExtKeyUsageServerAuth)
+	clientCert, ca := newTestLeaf(t, x509.ExtKeyUsageClientAuth)
+	rm := certmanager.NewRevocationManager()
+
+	serverConfig, err := New(Policy{SessionTickets: true, ClientAuth: ClientAuthRequireAndVerify}).
+		WithTrustStore(certmanager.NewTrustStore("", ca)).
+		WithRevocation(rm).
+		WithCertificate(serverCert).
+		Build()
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	clientConfig := &tls.Config{
+		Certificates:       []tls.Certificate{clientCert},
+		InsecureSkipVerify: true,
+		ClientSessionCache: tls.NewLRUClientSessionCache(4),
+	}
+
+	if _, err := testHandshake(t, serverConfig, clientConfig); err != nil {
+		t.Fatalf("Initial handshake failed: %v", err)
+	}
+	resumed, err := testHandshake(t, serverConfig, clientConfig)
+	if err != nil || !resumed {
+		t.Fatalf("Expected the session to resume, got resumed=%v err=%v", resumed, err)
+	}
+
+	leaf, _ := x509.ParseCertificate(clientCert.Certificate[0])
+	rm.Revoke(certmanager.CertificateID(leaf))
+	if _, err := testHandshake(t, serverConfig, clientConfig); !errors.Is(err, certmanager.ErrCertificateRevoked) {
+		t.Errorf("Expected a revoked certificate to be refused on resumption, got %v", err)
+	}
+}
@@ -0,0 +1,214 @@
+// Package tlslimit bounds the CPU spent on TLS handshakes. Certificate
+// verification against large RSA keys is expensive, and a reconnect storm
+// can start thousands of handshakes at once. A Listener accepts connections
+// as they arrive but performs their handshakes on a fixed number of workers;
+// connections wait in a bounded queue for a worker and are dropped if the
+// queue is full or they wait too long. Established connections are not
+// counted, so the limit is independent of how many clients stay connected.
+package tlslimit
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/yourusername/secure-messaging-poc/internal/metrics"
+)
+
+// Defaults for a zero Config field
+const (
+	DefaultMaxQueued        = 1024
+	DefaultQueueTimeout     = 5 * time.Second
+	DefaultHandshakeTimeout = 10 * time.Second
+)
+
+// errQueueTimeout marks connections that waited too long for a worker
+var errQueueTimeout = errors.New("tlslimit: timed out waiting for a handshake worker")
+
+// Config bounds handshakes
+type Config struct {
+	MaxConcurrent    int           // Handshakes performed at once; at least 1
+	MaxQueued        int           // Connections waiting for a worker; more are closed
+	QueueTimeout     time.Duration // Longest a connection waits for a worker
+	HandshakeTimeout time.Duration // Longest a handshake may take once started
+}
+
+// Listener accepts connections and returns them once their TLS handshake
+// has completed
+type Listener struct {
+	inner     net.Listener
+	tlsConfig *tls.Config
+	config    Config
+
+	workers chan struct{} // Holds a token per handshake in progress
+	ready   chan net.Conn
+	done    chan struct{}
+	queued  int32
+
+	startOnce sync.Once
+	closeOnce sync.Once
+	errMu     sync.Mutex
+	err       error // Why the accept loop stopped
+	failed    chan struct{}
+
+	inFlight  *metrics.Gauge
+	waiting   *metrics.Gauge
+	queueWait *metrics.Gauge
+	completed *metrics.Counter
+	failures  *metrics.Counter
+	shed      *metrics.Counter
+}
+
+// NewListener wraps inner, which accepts plain connections, so Accept
+// returns handshaken TLS connections. Metrics are registered with
+// registry, or metrics.Default if it is nil.
+func NewListener(inner net.Listener, tlsConfig *tls.Config, config Config, registry *metrics.Registry) *Listener {
+	if config.MaxConcurrent <= 0 {
+		config.MaxConcurrent = 1
+	}
+	if config.MaxQueued <= 0 {
+		config.MaxQueued = DefaultMaxQueued
+	}
+	if config.QueueTimeout <= 0 {
+		config.QueueTimeout = DefaultQueueTimeout
+	}
+	if config.HandshakeTimeout <= 0 {
+		config.HandshakeTimeout = DefaultHandshakeTimeout
+	}
+	if registry == nil {
+		registry = metrics.Default
+	}
+	return &Listener{
+		inner:     inner,
+		tlsConfig: tlsConfig,
+		config:    config,
+		workers:   make(chan struct{}, config.MaxConcurrent),
+		ready:     make(chan net.Conn),
+		done:      make(chan struct{}),
+		failed:    make(chan struct{}),
+		inFlight:  registry.Gauge("anono_tls_handshakes_in_flight", "TLS handshakes in progress"),
+		waiting:   registry.Gauge("anono_tls_handshakes_queued", "Connections waiting for a TLS handshake worker"),
+		queueWait: registry.Gauge("anono_tls_handshake_queue_wait_seconds", "Time the last connection waited for a TLS handshake worker"),
+		completed: registry.Counter("anono_tls_handshakes_total", "TLS handshakes completed"),
+		failures:  registry.Counter("anono_tls_handshake_errors_total", "TLS handshakes that failed or timed out"),
+		shed:      registry.Counter("anono_tls_handshakes_shed_total", "Connections closed because the handshake queue was full or too slow"),
+	}
+}
+
+// Accept returns the next connection whose handshake succeeded
+func (l *Listener) Accept() (net.Conn, error) {
+	l.startOnce.Do(func() { go l.acceptLoop() })
+
+	select {
+	case conn := <-l.ready:
+		return conn, nil
+	case <-l.failed:
+		l.errMu.Lock()
+		defer l.errMu.Unlock()
+		return nil, l.err
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close stops accepting connections. Handshakes in progress are abandoned.
+func (l *Listener) Close() error {
+	var err error
+	l.closeOnce.Do(func() {
+		close(l.done)
+		err = l.inner.Close()
+	})
+	return err
+}
+
+// Addr returns the inner listener's address
+func (l *Listener) Addr() net.Addr {
+	return l.inner.Addr()
+}
+
+// acceptLoop accepts plain connections and queues them for a worker
+func (l *Listener) acceptLoop() {
+	for {
+		conn, err := l.inner.Accept()
+		if err != nil {
+			select {
+			case <-l.done:
+				return
+			default:
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				time.Sleep(5 * time.Millisecond)
+				continue
+			}
+			l.errMu.Lock()
+			l.err = err
+			l.errMu.Unlock()
+			close(l.failed)
+			return
+		}
+
+		if atomic.AddInt32(&l.queued, 1) > int32(l.config.MaxQueued) {
+			atomic.AddInt32(&l.queued, -1)
+			l.shed.Inc()
+			conn.Close()
+			continue
+		}
+		l.waiting.Add(1)
+		go l.handshake(conn)
+	}
+}
+
+// handshake waits for a worker, then performs the handshake and hands the
+// connection to Accept
+func (l *Listener) handshake(conn net.Conn) {
+	queuedAt := time.Now()
+	timer := time.NewTimer(l.config.QueueTimeout)
+	defer timer.Stop()
+
+	var err error
+	select {
+	case l.workers <- struct{}{}:
+	case <-timer.C:
+		err = errQueueTimeout
+	case <-l.done:
+		err = net.ErrClosed
+	}
+	atomic.AddInt32(&l.queued, -1)
+	l.waiting.Add(-1)
+	l.queueWait.Set(time.Since(queuedAt).Seconds())
+	if err != nil {
+		if err == errQueueTimeout {
+			l.shed.Inc()
+		}
+		conn.Close()
+		return
+	}
+
+	l.inFlight.Add(1)
+	ctx, cancel := context.WithTimeout(context.Background(), l.config.HandshakeTimeout)
+	tlsConn := tls.Server(conn, l.tlsConfig)
+	err = tlsConn.HandshakeContext(ctx)
+	cancel()
+	l.inFlight.Add(-1)
+	<-l.workers
+
+	if err != nil {
+		l.failures.Inc()
+		log.Printf("TLS handshake error from %s: %v", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+	l.completed.Inc()
+
+	select {
+	case l.ready <- tlsConn:
+	case <-l.done:
+		tlsConn.Close()
+	}
+}
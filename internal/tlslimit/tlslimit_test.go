@@ -0,0 +1,144 @@
+package tlslimit
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/yourusername/secure-messaging-poc/internal/metrics"
+)
+
+// newTestConfig creates a server TLS config with a self-signed certificate
+func newTestConfig(t *testing.T) *tls.Config {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+}
+
+// newTestListener listens on a local port through a Listener
+func newTestListener(t *testing.T, config Config) (*Listener, *metrics.Registry) {
+	t.Helper()
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	registry := metrics.NewRegistry()
+	listener := NewListener(inner, newTestConfig(t), config, registry)
+	t.Cleanup(func() { listener.Close() })
+	return listener, registry
+}
+
+func TestListenerHandshakes(t *testing.T) {
+	listener, registry := newTestListener(t, Config{MaxConcurrent: 2})
+
+	const clients = 5
+	for i := 0; i < clients; i++ {
+		go func() {
+			conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			io.WriteString(conn, "hello")
+			io.ReadAll(conn)
+		}()
+	}
+
+	for i := 0; i < clients; i++ {
+		conn, err := listener.Accept()
+		if err != nil {
+			t.Fatalf("Accept failed: %v", err)
+		}
+		tlsConn, ok := conn.(*tls.Conn)
+		if !ok || !tlsConn.ConnectionState().HandshakeComplete {
+			t.Fatalf("Expected a handshaken TLS connection, got %T", conn)
+		}
+		data := make([]byte, 5)
+		if _, err := io.ReadFull(conn, data); err != nil || string(data) != "hello" {
+			t.Errorf("Expected the client's data, got %q, %v", data, err)
+		}
+		conn.Close()
+	}
+
+	if value, _, _ := registry.Read("anono_tls_handshakes_total"); value != clients {
+		t.Errorf("Expected %d completed handshakes, got %v", clients, value)
+	}
+	if value, _, _ := registry.Read("anono_tls_handshakes_in_flight"); value != 0 {
+		t.Errorf("Expected no handshakes in flight, got %v", value)
+	}
+}
+
+func TestListenerSheds(t *testing.T) {
+	listener, registry := newTestListener(t, Config{
+		MaxConcurrent:    1,
+		MaxQueued:        1,
+		QueueTimeout:     50 * time.Millisecond,
+		HandshakeTimeout: time.Second,
+	})
+	go listener.Accept()
+
+	// A client that never sends its hello holds the only worker
+	stalled, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer stalled.Close()
+	time.Sleep(20 * time.Millisecond)
+
+	// The next waits for the worker until the queue timeout closes it
+	queued, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer queued.Close()
+	queued.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := queued.Read(make([]byte, 1)); err == nil {
+		t.Fatal("Expected the queued connection to be closed")
+	}
+
+	if value, _, _ := registry.Read("anono_tls_handshakes_shed_total"); value != 1 {
+		t.Errorf("Expected one shed connection, got %v", value)
+	}
+	if value, _, _ := registry.Read("anono_tls_handshakes_in_flight"); value != 1 {
+		t.Errorf("Expected the stalled handshake in flight, got %v", value)
+	}
+}
+
+func TestListenerClose(t *testing.T) {
+	listener, _ := newTestListener(t, Config{MaxConcurrent: 1})
+	errs := make(chan error, 1)
+	go func() {
+		_, err := listener.Accept()
+		errs <- err
+	}()
+	listener.Close()
+	select {
+	case err := <-errs:
+		if err == nil {
+			t.Error("Expected Accept to fail after Close")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Accept did not return after Close")
+	}
+}
@@ -0,0 +1,73 @@
+// Package trace tags HTTP requests and WebSocket connections with random
+// IDs. The ID is returned to the client, in a response header and in error
+// frames, and prefixes the log lines written about the request, so a user
+// reporting a problem can quote it and an operator can find the matching
+// lines. IDs are random and carry nothing about the client or the request.
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+)
+
+// idKey carries the trace ID in a context
+type idKey struct{}
+
+// NewID returns a fresh random trace ID
+func NewID() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// WithID returns ctx carrying the trace ID id
+func WithID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, idKey{}, id)
+}
+
+// FromContext returns the trace ID of ctx, or "" if it has none
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(idKey{}).(string)
+	return id
+}
+
+// Logf logs a line prefixed with the trace ID of ctx. Byte slice arguments,
+// which would be frame or message payloads, are logged as their length
+// only.
+func Logf(ctx context.Context, format string, args ...interface{}) {
+	Printf(FromContext(ctx), format, args...)
+}
+
+// Printf logs a line prefixed with the trace ID id, redacting byte slice
+// arguments like Logf
+func Printf(id string, format string, args ...interface{}) {
+	for i, arg := range args {
+		if payload, ok := arg.([]byte); ok {
+			args[i] = Redacted(payload)
+		}
+	}
+	if id == "" {
+		log.Printf(format, args...)
+		return
+	}
+	log.Printf("trace=%s "+format, append([]interface{}{id}, args...)...)
+}
+
+// Redacted stands in for a payload in log lines
+type Redacted []byte
+
+// String describes the payload by its length
+func (r Redacted) String() string {
+	return fmt.Sprintf("[%d bytes redacted]", len(r))
+}
+
+// Format writes the description for every verb, so %x or %q cannot leak
+// the payload either
+func (r Redacted) Format(f fmt.State, verb rune) {
+	fmt.Fprint(f, r.String())
+}
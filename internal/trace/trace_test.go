@@ -0,0 +1,45 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestTraceIDs(t *testing.T) {
+	first, second := NewID(), NewID()
+	if len(first) != 16 || first == second {
+		t.Fatalf("NewID should return distinct 16 hex digit IDs, got %q and %q", first, second)
+	}
+
+	ctx := WithID(context.Background(), first)
+	if got := FromContext(ctx); got != first {
+		t.Errorf("FromContext = %q, want %q", got, first)
+	}
+	if got := FromContext(context.Background()); got != "" {
+		t.Errorf("FromContext without an ID = %q, want empty", got)
+	}
+}
+
+func TestLogfRedactsPayloads(t *testing.T) {
+	var buf bytes.Buffer
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(&buf)
+
+	ctx := WithID(context.Background(), "00112233aabbccdd")
+	Logf(ctx, "frame %x from %s: %q", []byte("secret payload"), "client", []byte("secret payload"))
+
+	line := buf.String()
+	if !strings.Contains(line, "trace=00112233aabbccdd frame ") {
+		t.Errorf("Log line should start with the trace ID: %s", line)
+	}
+	if strings.Contains(line, "secret") || strings.Contains(line, fmt.Sprintf("%x", "secret")) {
+		t.Errorf("Log line should not contain the payload: %s", line)
+	}
+	if !strings.Contains(line, "[14 bytes redacted]") || !strings.Contains(line, "from client") {
+		t.Errorf("Log line should describe the payload and keep other arguments: %s", line)
+	}
+}
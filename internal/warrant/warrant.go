@@ -0,0 +1,212 @@
+// Package warrant keeps the server's warrant canary: a statement the
+// operator signs with an Ed25519 key held off the server and renews before
+// its next_update time. The server only verifies and serves the document;
+// it cannot issue one itself, so a server that is seized or compelled
+// cannot keep the canary alive without the operator. A document past its
+// next_update time is stale, which clients should read as a dead canary.
+package warrant
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrInvalidKey is returned for an operator key of the wrong size
+	ErrInvalidKey = errors.New("warrant: invalid operator key")
+	// ErrBadSignature is returned for a document not signed by the
+	// operator key
+	ErrBadSignature = errors.New("warrant: canary signature does not verify")
+	// ErrNotNewer is returned for a document issued no later than the one
+	// it would replace
+	ErrNotNewer = errors.New("warrant: canary is not newer than the current one")
+	// ErrInvalidCanary is returned for a canary with an empty statement or
+	// a next update not after its issue time
+	ErrInvalidCanary = errors.New("warrant: canary needs a statement and a next update after its issue time")
+)
+
+// Canary is the signed statement
+type Canary struct {
+	Statement  string    `json:"statement"`
+	Issued     time.Time `json:"issued"`
+	NextUpdate time.Time `json:"next_update"` // The canary is stale after this
+}
+
+// Document is a canary as published: the exact bytes signed and the
+// operator's signature over them, both base64 in JSON
+type Document struct {
+	Payload   []byte `json:"payload"`
+	Signature []byte `json:"signature"`
+}
+
+// Status summarizes the canary for /api/info
+type Status struct {
+	Present    bool      `json:"present"`
+	Issued     time.Time `json:"issued,omitempty"`
+	NextUpdate time.Time `json:"next_update,omitempty"`
+	Stale      bool      `json:"stale"`
+}
+
+// GenerateKeyPair returns a new operator key pair, base64-encoded
+func GenerateKeyPair() (publicKey, privateKey string, err error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	return base64.StdEncoding.EncodeToString(pub), base64.StdEncoding.EncodeToString(priv), nil
+}
+
+// ParsePublicKey decodes a base64 operator public key
+func ParsePublicKey(encoded string) (ed25519.PublicKey, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, ErrInvalidKey
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// ParsePrivateKey decodes a base64 operator private key
+func ParsePrivateKey(encoded string) (ed25519.PrivateKey, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != ed25519.PrivateKeySize {
+		return nil, ErrInvalidKey
+	}
+	return ed25519.PrivateKey(key), nil
+}
+
+// Sign signs a canary with the operator key
+func Sign(canary Canary, key ed25519.PrivateKey) (Document, error) {
+	if err := canary.check(); err != nil {
+		return Document{}, err
+	}
+	payload, err := json.Marshal(canary)
+	if err != nil {
+		return Document{}, err
+	}
+	return Document{Payload: payload, Signature: ed25519.Sign(key, payload)}, nil
+}
+
+// Verify checks a document against the operator key and returns its canary
+func Verify(doc Document, key ed25519.PublicKey) (Canary, error) {
+	if !ed25519.Verify(key, doc.Payload, doc.Signature) {
+		return Canary{}, ErrBadSignature
+	}
+	var canary Canary
+	if err := json.Unmarshal(doc.Payload, &canary); err != nil {
+		return Canary{}, fmt.Errorf("warrant: %w", err)
+	}
+	if err := canary.check(); err != nil {
+		return Canary{}, err
+	}
+	return canary, nil
+}
+
+// check validates the fields of a canary
+func (c Canary) check() error {
+	if c.Statement == "" || !c.NextUpdate.After(c.Issued) {
+		return ErrInvalidCanary
+	}
+	return nil
+}
+
+// Store holds the current canary, kept in a file so it survives restarts
+type Store struct {
+	path   string
+	key    ed25519.PublicKey
+	doc    *Document
+	canary Canary
+	mu     sync.RWMutex
+}
+
+// Open loads the canary at path, if there is one, and verifies it against
+// the operator key. A missing file leaves the store empty, which reads as
+// stale until the operator publishes a canary.
+func Open(path string, key ed25519.PublicKey) (*Store, error) {
+	s := &Store{path: path, key: key}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var doc Document
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("warrant: %s: %w", path, err)
+	}
+	canary, err := Verify(doc, key)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	s.doc, s.canary = &doc, canary
+	return s, nil
+}
+
+// Update verifies a document and replaces the current canary with it. It
+// must have been issued after the current one, so an old canary cannot be
+// replayed over a newer one.
+func (s *Store) Update(doc Document) (Canary, error) {
+	canary, err := Verify(doc, s.key)
+	if err != nil {
+		return Canary{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.doc != nil && !canary.Issued.After(s.canary.Issued) {
+		return Canary{}, ErrNotNewer
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return Canary{}, err
+	}
+	if err := writeFile(s.path, data); err != nil {
+		return Canary{}, err
+	}
+	s.doc, s.canary = &doc, canary
+	return canary, nil
+}
+
+// Document returns the current signed document, or nil if there is none
+func (s *Store) Document() *Document {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.doc
+}
+
+// Status reports the current canary at now
+func (s *Store) Status(now time.Time) Status {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.doc == nil {
+		return Status{Stale: true}
+	}
+	return Status{
+		Present:    true,
+		Issued:     s.canary.Issued,
+		NextUpdate: s.canary.NextUpdate,
+		Stale:      now.After(s.canary.NextUpdate),
+	}
+}
+
+// writeFile replaces path atomically
+func writeFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
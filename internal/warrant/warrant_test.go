@@ -0,0 +1,78 @@
+package warrant
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testKeys returns a fresh operator key pair
+func testKeys(t *testing.T) (string, string) {
+	pub, priv, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("GenerateKeyPair failed: %v", err)
+	}
+	return pub, priv
+}
+
+func TestStoreUpdateAndReopen(t *testing.T) {
+	pubEncoded, privEncoded := testKeys(t)
+	pub, _ := ParsePublicKey(pubEncoded)
+	priv, _ := ParsePrivateKey(privEncoded)
+	path := filepath.Join(t.TempDir(), "canary.json")
+
+	store, err := Open(path, pub)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if status := store.Status(time.Now()); status.Present || !status.Stale {
+		t.Errorf("An empty store should be stale, got %+v", status)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	doc, err := Sign(Canary{Statement: "No warrants received.", Issued: now, NextUpdate: now.Add(30 * 24 * time.Hour)}, priv)
+	if err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+	if _, err := store.Update(doc); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if status := store.Status(now.Add(time.Hour)); !status.Present || status.Stale {
+		t.Errorf("Fresh canary should not be stale, got %+v", status)
+	}
+	if status := store.Status(now.Add(31 * 24 * time.Hour)); !status.Stale {
+		t.Errorf("Canary past its next update should be stale, got %+v", status)
+	}
+
+	// Replaying the same document, or an older one, is refused
+	if _, err := store.Update(doc); !errors.Is(err, ErrNotNewer) {
+		t.Errorf("Expected ErrNotNewer, got %v", err)
+	}
+
+	// Documents signed by another key or altered after signing are refused
+	_, otherEncoded := testKeys(t)
+	other, _ := ParsePrivateKey(otherEncoded)
+	forged, _ := Sign(Canary{Statement: "No warrants received.", Issued: now.Add(time.Hour), NextUpdate: now.Add(time.Hour + time.Minute)}, other)
+	if _, err := store.Update(forged); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("Expected ErrBadSignature for another key, got %v", err)
+	}
+	altered := doc
+	altered.Payload = append([]byte(nil), doc.Payload...)
+	altered.Payload[len(altered.Payload)-2] ^= 1
+	if _, err := store.Update(altered); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("Expected ErrBadSignature for an altered payload, got %v", err)
+	}
+
+	reopened, err := Open(path, pub)
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	if status := reopened.Status(now); !status.Present || !status.Issued.Equal(now) {
+		t.Errorf("Reopened store should hold the canary, got %+v", status)
+	}
+
+	if _, err := Sign(Canary{Statement: "", Issued: now, NextUpdate: now.Add(time.Hour)}, priv); !errors.Is(err, ErrInvalidCanary) {
+		t.Errorf("Expected ErrInvalidCanary, got %v", err)
+	}
+}
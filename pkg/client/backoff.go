@@ -0,0 +1,59 @@
+package client
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Backoff decides how long to wait before reconnect attempt n, counting
+// from 1 after each connection that was lost or could not be made
+type Backoff interface {
+	Delay(attempt int) time.Duration
+}
+
+// BackoffFunc adapts a function to Backoff
+type BackoffFunc func(attempt int) time.Duration
+
+// Delay calls f
+func (f BackoffFunc) Delay(attempt int) time.Duration {
+	return f(attempt)
+}
+
+// ExponentialBackoff multiplies the delay after every failed attempt, up to
+// Max, and randomizes part of it so clients dropped together by a restart
+// do not reconnect in lockstep
+type ExponentialBackoff struct {
+	Initial    time.Duration
+	Max        time.Duration
+	Multiplier float64
+	Jitter     float64 // Fraction of the delay that is random, 0 to 1
+}
+
+// DefaultBackoff is used when a Config sets no Backoff. It is fully
+// jittered: each delay is uniform between zero and the exponential bound.
+var DefaultBackoff = ExponentialBackoff{
+	Initial:    500 * time.Millisecond,
+	Max:        30 * time.Second,
+	Multiplier: 2,
+	Jitter:     1,
+}
+
+// Delay returns the jittered delay for attempt
+func (b ExponentialBackoff) Delay(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	multiplier := b.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+
+	bound := float64(b.Initial) * math.Pow(multiplier, float64(attempt-1))
+	if b.Max > 0 && bound > float64(b.Max) {
+		bound = float64(b.Max)
+	}
+
+	jitter := math.Min(math.Max(b.Jitter, 0), 1)
+	return time.Duration(bound*(1-jitter) + bound*jitter*rand.Float64())
+}
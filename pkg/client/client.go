@@ -0,0 +1,434 @@
+// Package client is a Go client for the messaging server. It keeps a
+// WebSocket subscription alive on behalf of the application: lost
+// connections are re-established with jittered exponential backoff, the
+// subscription is renewed each time, replayed messages already delivered are
+// filtered out, and bin mask changes are followed automatically.
+package client
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/yourusername/secure-messaging-poc/pkg/protocol"
+)
+
+// DefaultInfoInterval is how often the bin mask is checked while connected
+const DefaultInfoInterval = time.Minute
+
+var (
+	// ErrNotConnected is returned by Publish while no session is open
+	ErrNotConnected = errors.New("client: not connected")
+	// ErrNoChannels is returned by New when there is nothing to subscribe to
+	ErrNoChannels = errors.New("client: no channels to subscribe to")
+
+	// errMaskChanged ends a session so it is renewed with the new bins
+	errMaskChanged = errors.New("client: bin mask changed")
+)
+
+// Message is a message as carried on the wire
+type Message = protocol.Message
+
+// ErrorFrame is an error reported by the server on the WebSocket
+type ErrorFrame = protocol.ErrorFrame
+
+// PublishAck confirms a publish that set Message.Ack
+type PublishAck = protocol.PublishAck
+
+// CloseError is returned by Run when the server ends the session with a
+// code that reconnecting cannot fix, such as a revoked certificate
+type CloseError struct {
+	Code int
+	Text string
+}
+
+// Error implements error
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("client: closed by server (%d): %s", e.Code, e.Text)
+}
+
+// Config configures a Client
+type Config struct {
+	ServerURL   string      // Base URL, e.g. https://example.com:8443
+	TLSConfig   *tls.Config // Client certificate and server trust
+	Channels    []uint64    // Channel IDs; bins are derived from the current mask
+	ClientID    string      // Kept across reconnects; generated if empty
+	ResumeToken string      // From Client.ResumeToken, to skip messages seen before a restart
+	Store       Store       // Optional; saves messages and, without a ResumeToken, supplies it
+
+	Backoff      Backoff       // Reconnect delays; DefaultBackoff if nil
+	InfoInterval time.Duration // Bin mask polling; DefaultInfoInterval if zero
+	PadBlock     int           // Pad the subscribe frame to a multiple of this many bytes; 0 for none
+	SignMessages bool          // Register a fresh Ed25519 key with every connection and sign publishes with it
+
+	OnMessage    func(msg *Message)                     // Called for every new message, in order
+	OnError      func(frame ErrorFrame)                 // Non-fatal errors, e.g. a refused publish
+	OnPublishAck func(ack PublishAck)                   // Publishes that requested an acknowledgement
+	OnConnect    func(mask uint64, bins []uint64)       // After each subscription is acknowledged
+	OnDisconnect func(err error, retryIn time.Duration) // Before waiting to reconnect
+	OnMaskChange func(oldMask, newMask uint64)          // Before resubscribing to the new bins
+	OnStoreError func(msg *Message, err error)          // A message the Store failed to save, still delivered
+}
+
+// Client maintains a subscription and publishes messages
+type Client struct {
+	config     Config
+	backoff    Backoff
+	resume     *resumeState
+	httpClient *http.Client
+	dialer     *websocket.Dialer
+
+	conn    *websocket.Conn
+	mask    uint64
+	epoch   *uint64            // Mask epoch acknowledged for the current session, if reported
+	signer  ed25519.PrivateKey // Ephemeral key of the current connection
+	mu      sync.Mutex
+	writeMu sync.Mutex
+}
+
+// New validates the configuration and creates a client. Call Run to connect.
+func New(config Config) (*Client, error) {
+	if len(config.Channels) == 0 {
+		return nil, ErrNoChannels
+	}
+	if _, err := url.Parse(config.ServerURL); err != nil {
+		return nil, err
+	}
+	if config.Store != nil && config.ResumeToken == "" {
+		token, err := config.Store.ResumeToken()
+		if err != nil {
+			return nil, err
+		}
+		config.ResumeToken = token
+	}
+	resume, err := newResumeState(config.ResumeToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.ClientID == "" {
+		config.ClientID = uuid.New().String()
+	}
+	if config.InfoInterval <= 0 {
+		config.InfoInterval = DefaultInfoInterval
+	}
+	backoff := config.Backoff
+	if backoff == nil {
+		backoff = DefaultBackoff
+	}
+
+	return &Client{
+		config:     config,
+		backoff:    backoff,
+		resume:     resume,
+		httpClient: &http.Client{Timeout: 30 * time.Second, Transport: &http.Transport{TLSClientConfig: config.TLSConfig}},
+		dialer:     &websocket.Dialer{TLSClientConfig: config.TLSConfig, HandshakeTimeout: 30 * time.Second},
+	}, nil
+}
+
+// Run connects and keeps the subscription alive until ctx is done or the
+// server refuses the client for good, in which case a *CloseError is
+// returned
+func (c *Client) Run(ctx context.Context) error {
+	attempt := 0
+	for {
+		established, err := c.session(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		var closeErr *CloseError
+		if errors.As(err, &closeErr) && protocol.PermanentCloseCodes[closeErr.Code] {
+			return err
+		}
+
+		// A session that got going starts the backoff over; a mask change
+		// resubscribes at once
+		if established {
+			attempt = 0
+		}
+		var delay time.Duration
+		if err != errMaskChanged {
+			attempt++
+			delay = c.backoff.Delay(attempt)
+		}
+		if c.config.OnDisconnect != nil {
+			c.config.OnDisconnect(err, delay)
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Publish sends a message to a channel over the current session, filling in
+// the bin ID and, if empty, the message ID. With SignMessages, the message
+// is signed with the connection's ephemeral key.
+func (c *Client) Publish(channelID uint64, msg *Message) error {
+	c.mu.Lock()
+	conn, mask, epoch, signer := c.conn, c.mask, c.epoch, c.signer
+	c.mu.Unlock()
+	if conn == nil {
+		return ErrNotConnected
+	}
+
+	// The epoch lets the server re-bin a publish that crosses a mask change
+	msg.BinID = channelID & mask
+	msg.MaskEpoch = epoch
+	if msg.MessageID == "" {
+		msg.MessageID = uuid.New().String()
+	}
+	if signer != nil {
+		msg.Sign(signer)
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return conn.WriteJSON(msg)
+}
+
+// Mask returns the bin mask of the current or last session
+func (c *Client) Mask() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.mask
+}
+
+// ResumeToken returns an opaque token recording which messages have been
+// delivered, for a Config after the application restarts
+func (c *Client) ResumeToken() string {
+	return c.resume.token()
+}
+
+// session runs one connection: it fetches the bin mask, subscribes, and
+// delivers messages until the connection fails or the mask changes. It
+// reports whether the subscription was acknowledged.
+func (c *Client) session(ctx context.Context) (bool, error) {
+	mask, err := c.fetchMask(ctx)
+	if err != nil {
+		return false, err
+	}
+	c.mu.Lock()
+	previous := c.mask
+	c.mask = mask
+	c.mu.Unlock()
+	if previous != 0 && previous != mask {
+		c.changeMask(previous, mask)
+	}
+	subscribe := protocol.NewSubscribe(c.config.ClientID, c.config.Channels, mask)
+
+	// A fresh key per connection keeps signatures unlinkable across them
+	var signer ed25519.PrivateKey
+	if c.config.SignMessages {
+		public, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return false, err
+		}
+		subscribe.SigningKey = public
+		signer = private
+	}
+
+	conn, resp, err := c.dialer.DialContext(ctx, c.websocketURL(), nil)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	// Behind anycast or a load balancer this may be another instance than
+	// the last one, which replays its own messages
+	if resp != nil {
+		c.resume.served(resp.Header.Get(protocol.InstanceHeader))
+	}
+
+	data, err := json.Marshal(subscribe)
+	if err != nil {
+		return false, err
+	}
+	if err := conn.WriteMessage(websocket.TextMessage, protocol.Pad(data, c.config.PadBlock)); err != nil {
+		return false, err
+	}
+
+	c.mu.Lock()
+	c.conn, c.signer = conn, signer
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		c.conn, c.epoch, c.signer = nil, nil, nil
+		c.mu.Unlock()
+	}()
+
+	// Stop reading when the context ends or the mask changes
+	stop := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+	go c.watch(ctx, conn, mask, stop, done)
+
+	established := false
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			select {
+			case reason := <-stop:
+				return established, reason
+			default:
+			}
+			var wsClose *websocket.CloseError
+			if errors.As(err, &wsClose) {
+				return established, &CloseError{Code: wsClose.Code, Text: wsClose.Text}
+			}
+			return established, err
+		}
+
+		frame, err := protocol.DecodeFrame(data)
+		if err != nil {
+			continue
+		}
+		switch {
+		case frame.Ack != nil:
+			// The mask changed between fetching it and subscribing
+			if maskChanged(frame.Ack.BinMask, mask) {
+				return established, errMaskChanged
+			}
+			established = true
+			if frame.Ack.MaskEpoch > 0 {
+				epoch := frame.Ack.MaskEpoch
+				c.mu.Lock()
+				c.epoch = &epoch
+				c.mu.Unlock()
+			}
+			if c.config.OnConnect != nil {
+				c.config.OnConnect(mask, subscribe.BinIDs)
+			}
+		case frame.PublishAck != nil:
+			if c.config.OnPublishAck != nil {
+				c.config.OnPublishAck(*frame.PublishAck)
+			}
+			if maskChanged(frame.PublishAck.BinMask, mask) {
+				return established, errMaskChanged
+			}
+		case frame.Error != nil:
+			if c.config.OnError != nil {
+				c.config.OnError(*frame.Error)
+			}
+			// The rejected publish needs the new mask; the application
+			// publishes it again after reconnecting
+			if frame.Error.Code == protocol.ErrorMaskChanged {
+				return established, errMaskChanged
+			}
+		case frame.Message != nil:
+			if !c.resume.deliver(frame.Message) {
+				continue
+			}
+			c.save(frame.Message)
+			if c.config.OnMessage != nil {
+				c.config.OnMessage(frame.Message)
+			}
+		}
+	}
+}
+
+// save records a delivered message in the store, if there is one, before
+// the application sees it
+func (c *Client) save(msg *Message) {
+	if c.config.Store == nil {
+		return
+	}
+	if err := c.config.Store.Save(msg, c.resume.token()); err != nil && c.config.OnStoreError != nil {
+		c.config.OnStoreError(msg, err)
+	}
+}
+
+// watch closes conn when ctx ends or the server's bin mask changes,
+// leaving the reason in stop
+func (c *Client) watch(ctx context.Context, conn *websocket.Conn, mask uint64, stop chan<- error, done <-chan struct{}) {
+	ticker := time.NewTicker(c.config.InfoInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ctx.Done():
+			stop <- ctx.Err()
+			conn.Close()
+			return
+		case <-ticker.C:
+			current, err := c.fetchMask(ctx)
+			if err == nil && current != mask {
+				stop <- errMaskChanged
+				conn.Close()
+				return
+			}
+		}
+	}
+}
+
+// changeMask moves delivery state to the bins of the new mask
+func (c *Client) changeMask(oldMask, newMask uint64) {
+	c.resume.remap(c.config.Channels, oldMask, newMask)
+	if c.config.OnMaskChange != nil {
+		c.config.OnMaskChange(oldMask, newMask)
+	}
+}
+
+// maskChanged reports whether a mask reported in a frame differs from the
+// session's. Servers that do not report one never trigger a change.
+func maskChanged(reported string, mask uint64) bool {
+	if reported == "" {
+		return false
+	}
+	current, err := protocol.ParseMask(reported)
+	return err == nil && current != mask
+}
+
+// fetchMask reads the current bin mask from the server info endpoint
+func (c *Client) fetchMask(ctx context.Context) (uint64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(c.config.ServerURL, "/")+"/api/info", nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("client: server info returned %s", resp.Status)
+	}
+
+	var info struct {
+		BinMask string `json:"bin_mask"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return 0, err
+	}
+	return protocol.ParseMask(info.BinMask)
+}
+
+// websocketURL derives the WebSocket endpoint from the server URL
+func (c *Client) websocketURL() string {
+	u, _ := url.Parse(strings.TrimRight(c.config.ServerURL, "/"))
+	switch u.Scheme {
+	case "https":
+		u.Scheme = "wss"
+	case "http":
+		u.Scheme = "ws"
+	}
+	u.Path += "/ws"
+	return u.String()
+}
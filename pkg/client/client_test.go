@@ -0,0 +1,354 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// fakeServer speaks enough of the server protocol to exercise reconnects:
+// it serves a bin mask, acknowledges subscriptions and replays the
+// messages it holds, then runs the session with the given handler
+type fakeServer struct {
+	*httptest.Server
+	mask       uint64
+	messages   []Message
+	subscribed chan []uint64
+	session    func(conn *websocket.Conn, n int)
+	sessions   int32
+	mu         sync.Mutex
+}
+
+func newFakeServer(t *testing.T, mask uint64) *fakeServer {
+	fs := &fakeServer{mask: mask, subscribed: make(chan []uint64, 16)}
+	upgrader := websocket.Upgrader{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/info", func(w http.ResponseWriter, r *http.Request) {
+		fs.mu.Lock()
+		defer fs.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]interface{}{"bin_mask": fmt.Sprintf("0x%X", fs.mask)})
+	})
+	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		var subscribe struct {
+			BinIDs []uint64 `json:"bin_ids"`
+		}
+		if err := conn.ReadJSON(&subscribe); err != nil {
+			return
+		}
+		fs.subscribed <- subscribe.BinIDs
+
+		fs.mu.Lock()
+		replay := append([]Message(nil), fs.messages...)
+		fs.mu.Unlock()
+		for _, msg := range replay {
+			conn.WriteJSON(msg)
+		}
+		conn.WriteJSON(map[string]interface{}{"type": "subscribe_ack"})
+
+		fs.session(conn, int(atomic.AddInt32(&fs.sessions, 1)))
+	})
+	fs.Server = httptest.NewServer(mux)
+	t.Cleanup(fs.Close)
+	return fs
+}
+
+// add stores a message for replay and returns it
+func (fs *fakeServer) add(binID uint64, id string) Message {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	msg := Message{BinID: binID, MessageID: id, Ciphertext: []byte(id), Timestamp: time.Now().UTC()}
+	fs.messages = append(fs.messages, msg)
+	return msg
+}
+
+// setMask changes the mask served by the info endpoint
+func (fs *fakeServer) setMask(mask uint64) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.mask = mask
+}
+
+// quickBackoff keeps tests fast
+var quickBackoff = BackoffFunc(func(int) time.Duration { return 10 * time.Millisecond })
+
+func TestClientReconnectsWithoutRedelivery(t *testing.T) {
+	fs := newFakeServer(t, 0xFFFFFFFFFFFFF000)
+	fs.add(0x1000, "first")
+	fs.add(0x1000, "second")
+	fs.session = func(conn *websocket.Conn, n int) {
+		if n == 1 {
+			return // Drop the connection
+		}
+		conn.WriteJSON(fs.add(0x1000, "third"))
+		conn.ReadMessage()
+	}
+
+	var (
+		delivered []string
+		mu        sync.Mutex
+		connects  int32
+	)
+	third := make(chan struct{})
+	c, err := New(Config{
+		ServerURL: fs.URL,
+		Channels:  []uint64{0x1234},
+		Backoff:   quickBackoff,
+		OnMessage: func(msg *Message) {
+			mu.Lock()
+			delivered = append(delivered, msg.MessageID)
+			mu.Unlock()
+			if msg.MessageID == "third" {
+				close(third)
+			}
+		},
+		OnConnect: func(uint64, []uint64) { atomic.AddInt32(&connects, 1) },
+	})
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() { result <- c.Run(ctx) }()
+
+	select {
+	case <-third:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the message after reconnecting")
+	}
+	cancel()
+	if err := <-result; !errors.Is(err, context.Canceled) {
+		t.Errorf("Run should end with the context, got %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if bins := <-fs.subscribed; len(bins) != 1 || bins[0] != 0x1000 {
+			t.Errorf("Subscription %d to unexpected bins %v", i+1, bins)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if fmt.Sprint(delivered) != "[first second third]" {
+		t.Errorf("Replayed messages should be delivered once, got %v", delivered)
+	}
+	if atomic.LoadInt32(&connects) != 2 {
+		t.Errorf("Expected two acknowledged sessions, got %d", connects)
+	}
+
+	// A restarted client resumes from the token
+	replayed := 0
+	restarted, err := New(Config{
+		ServerURL:   fs.URL,
+		Channels:    []uint64{0x1234},
+		ResumeToken: c.ResumeToken(),
+		OnMessage:   func(*Message) { replayed++ },
+	})
+	if err != nil {
+		t.Fatalf("Failed to restore from token: %v", err)
+	}
+	for _, msg := range fs.messages {
+		msg := msg
+		if restarted.resume.deliver(&msg) {
+			replayed++
+		}
+	}
+	if replayed != 0 {
+		t.Errorf("Resumed client should skip delivered messages, got %d", replayed)
+	}
+}
+
+func TestResumeStateFollowsInstance(t *testing.T) {
+	state, _ := newResumeState("")
+	msg := Message{BinID: 0x1000, MessageID: "first", Timestamp: time.Now()}
+	state.served("a")
+	state.deliver(&msg)
+
+	// The token keeps the instance along with the cursors
+	restored, err := newResumeState(state.token())
+	if err != nil {
+		t.Fatalf("Failed to restore token: %v", err)
+	}
+	if restored.served("a") || restored.deliver(&msg) {
+		t.Error("The same instance should resume where it left off")
+	}
+
+	// Another instance replays its own messages in full
+	if !restored.served("b") || !restored.deliver(&msg) {
+		t.Error("Another instance should drop the cursors")
+	}
+
+	// Tokens from before instances were recorded still restore
+	legacy := base64.RawURLEncoding.EncodeToString([]byte(`{"4096":{"last":"2024-01-01T00:00:00Z","ids":["old"]}}`))
+	old, err := newResumeState(legacy)
+	if err != nil || len(old.bins) != 1 {
+		t.Fatalf("Failed to restore legacy token: %v", err)
+	}
+	if old.served("a") || len(old.bins) != 1 {
+		t.Error("The first instance named should not drop the cursors")
+	}
+}
+
+func TestClientFollowsMaskChange(t *testing.T) {
+	fs := newFakeServer(t, 0xFFFFFFFFFFFFF000)
+	fs.session = func(conn *websocket.Conn, n int) {
+		conn.ReadMessage()
+	}
+
+	changed := make(chan [2]uint64, 1)
+	c, _ := New(Config{
+		ServerURL:    fs.URL,
+		Channels:     []uint64{0x1234, 0x1F00},
+		Backoff:      quickBackoff,
+		InfoInterval: 20 * time.Millisecond,
+		OnMaskChange: func(oldMask, newMask uint64) { changed <- [2]uint64{oldMask, newMask} },
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.Run(ctx)
+
+	if bins := <-fs.subscribed; fmt.Sprint(bins) != "[4096]" {
+		t.Fatalf("Unexpected initial bins %v", bins)
+	}
+	fs.setMask(0xFFFFFFFFFFFFFF00)
+
+	select {
+	case masks := <-changed:
+		if masks != [2]uint64{0xFFFFFFFFFFFFF000, 0xFFFFFFFFFFFFFF00} {
+			t.Errorf("Unexpected mask change %x", masks)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Mask change not noticed")
+	}
+	if bins := <-fs.subscribed; fmt.Sprint(bins) != "[4608 7936]" {
+		t.Errorf("Should resubscribe to the new bins, got %v", bins)
+	}
+}
+
+// memoryStore is a Store kept in memory
+type memoryStore struct {
+	saved []string
+	token string
+	mu    sync.Mutex
+}
+
+func (ms *memoryStore) Save(msg *Message, resumeToken string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.saved = append(ms.saved, msg.MessageID)
+	ms.token = resumeToken
+	return nil
+}
+
+func (ms *memoryStore) ResumeToken() (string, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return ms.token, nil
+}
+
+func TestClientResumesFromStore(t *testing.T) {
+	fs := newFakeServer(t, 0xFFFFFFFFFFFFF000)
+	fs.add(0x1000, "first")
+	fs.add(0x1000, "second")
+	fs.session = func(conn *websocket.Conn, n int) {
+		conn.ReadMessage()
+	}
+	store := &memoryStore{}
+
+	// run delivers messages until want arrives, then stops the client
+	run := func(want string) []string {
+		var delivered []string
+		done := make(chan struct{})
+		c, err := New(Config{
+			ServerURL: fs.URL,
+			Channels:  []uint64{0x1234},
+			Backoff:   quickBackoff,
+			Store:     store,
+			OnMessage: func(msg *Message) {
+				delivered = append(delivered, msg.MessageID)
+				if msg.MessageID == want {
+					close(done)
+				}
+			},
+		})
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go c.Run(ctx)
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s not delivered", want)
+		}
+		return delivered
+	}
+
+	if delivered := run("second"); fmt.Sprint(delivered) != "[first second]" {
+		t.Fatalf("Unexpected first delivery %v", delivered)
+	}
+
+	// A client restarted from the store only sees the new message
+	fs.add(0x1000, "third")
+	if delivered := run("third"); fmt.Sprint(delivered) != "[third]" {
+		t.Errorf("Expected only the new message after a restart, got %v", delivered)
+	}
+	if fmt.Sprint(store.saved) != "[first second third]" {
+		t.Errorf("Unexpected saved messages %v", store.saved)
+	}
+}
+
+func TestClientStopsOnPermanentClose(t *testing.T) {
+	fs := newFakeServer(t, 0xFFFFFFFFFFFFF000)
+	fs.session = func(conn *websocket.Conn, n int) {
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(4003, "certificate has been revoked"), time.Now().Add(time.Second))
+	}
+
+	c, _ := New(Config{ServerURL: fs.URL, Channels: []uint64{1}, Backoff: quickBackoff})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := c.Run(ctx)
+	var closeErr *CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != 4003 {
+		t.Fatalf("Expected a CloseError with code 4003, got %v", err)
+	}
+	if sessions := atomic.LoadInt32(&fs.sessions); sessions != 1 {
+		t.Errorf("A revoked client should not reconnect, got %d sessions", sessions)
+	}
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	b := ExponentialBackoff{Initial: 100 * time.Millisecond, Max: time.Second, Multiplier: 2}
+	expected := []time.Duration{100, 200, 400, 800, 1000, 1000}
+	for i, want := range expected {
+		if got := b.Delay(i + 1); got != want*time.Millisecond {
+			t.Errorf("Attempt %d: expected %v, got %v", i+1, want*time.Millisecond, got)
+		}
+	}
+
+	jittered := ExponentialBackoff{Initial: 100 * time.Millisecond, Max: time.Second, Multiplier: 2, Jitter: 0.5}
+	for i := 0; i < 100; i++ {
+		if d := jittered.Delay(3); d < 200*time.Millisecond || d > 400*time.Millisecond {
+			t.Fatalf("Jittered delay %v outside [200ms, 400ms]", d)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package client
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrInvalidResumeToken is returned for a token that cannot be decoded
+var ErrInvalidResumeToken = errors.New("client: invalid resume token")
+
+// cursor is the newest message delivered from a bin: its timestamp and the
+// IDs of every delivered message with that same timestamp
+type cursor struct {
+	Last time.Time `json:"last"`
+	IDs  []string  `json:"ids,omitempty"`
+}
+
+// resumeState remembers what has been delivered per bin, and by which
+// server instance. The server replays every retained message on each
+// subscription, so after a reconnect, or a restart from a saved token,
+// replayed messages already delivered are dropped instead of being handed
+// to the application again.
+type resumeState struct {
+	instance string
+	bins     map[uint64]*cursor
+	mu       sync.Mutex
+}
+
+// encodedResume is the form of a resumeState in a token. Tokens from before
+// instances were recorded hold the bins map alone.
+type encodedResume struct {
+	Instance string             `json:"instance,omitempty"`
+	Bins     map[uint64]*cursor `json:"bins"`
+}
+
+// newResumeState restores state from a token; an empty token starts afresh
+func newResumeState(token string) (*resumeState, error) {
+	state := &resumeState{bins: make(map[uint64]*cursor)}
+	if token == "" {
+		return state, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidResumeToken
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, ErrInvalidResumeToken
+	}
+	if _, current := fields["bins"]; current {
+		var encoded encodedResume
+		if err := json.Unmarshal(data, &encoded); err != nil {
+			return nil, ErrInvalidResumeToken
+		}
+		state.instance = encoded.Instance
+		if encoded.Bins != nil {
+			state.bins = encoded.Bins
+		}
+	} else if err := json.Unmarshal(data, &state.bins); err != nil {
+		return nil, ErrInvalidResumeToken
+	}
+	for binID, c := range state.bins {
+		if c == nil {
+			delete(state.bins, binID)
+		}
+	}
+	return state, nil
+}
+
+// token encodes the state so it can be saved and passed back in a Config
+func (rs *resumeState) token() string {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	data, _ := json.Marshal(encodedResume{Instance: rs.instance, Bins: rs.bins})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// served records the server instance a connection reached, before its
+// replay. Another instance retains its own messages, with timestamps the
+// cursors cannot be compared to, so the cursors are dropped and its replay
+// is delivered in full; duplicates are preferred to losses. An instance
+// that does not name itself changes nothing. It reports whether the
+// cursors were dropped.
+func (rs *resumeState) served(instance string) bool {
+	if instance == "" {
+		return false
+	}
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	previous := rs.instance
+	rs.instance = instance
+	if previous == "" || previous == instance {
+		return false
+	}
+	rs.bins = make(map[uint64]*cursor)
+	return true
+}
+
+// deliver reports whether msg is new and, if so, advances its bin's
+// cursor. Messages without a timestamp cannot be ordered and are always
+// delivered.
+func (rs *resumeState) deliver(msg *Message) bool {
+	if msg.Timestamp.IsZero() {
+		return true
+	}
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	c, exists := rs.bins[msg.BinID]
+	if !exists {
+		rs.bins[msg.BinID] = &cursor{Last: msg.Timestamp, IDs: []string{msg.MessageID}}
+		return true
+	}
+
+	switch {
+	case msg.Timestamp.Before(c.Last):
+		return false
+	case msg.Timestamp.Equal(c.Last):
+		for _, id := range c.IDs {
+			if id == msg.MessageID {
+				return false
+			}
+		}
+		c.IDs = append(c.IDs, msg.MessageID)
+	default:
+		c.Last = msg.Timestamp
+		c.IDs = []string{msg.MessageID}
+	}
+	return true
+}
+
+// remap carries cursors over to the bins the channels map to under a new
+// mask. When several old bins merge into one, the oldest cursor wins so
+// nothing is skipped; duplicates are preferred to losses.
+func (rs *resumeState) remap(channels []uint64, oldMask, newMask uint64) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	remapped := make(map[uint64]*cursor)
+	for _, channelID := range channels {
+		old, exists := rs.bins[channelID&oldMask]
+		if !exists {
+			continue
+		}
+		binID := channelID & newMask
+		if current, seen := remapped[binID]; !seen || old.Last.Before(current.Last) {
+			remapped[binID] = &cursor{Last: old.Last, IDs: append([]string(nil), old.IDs...)}
+		}
+	}
+	rs.bins = remapped
+}
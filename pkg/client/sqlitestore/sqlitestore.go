@@ -0,0 +1,230 @@
+// Package sqlitestore is an encrypted SQLite client.Store. Every message
+// and the resume token are sealed with AES-256-GCM before they are written,
+// so the database file reveals only how many messages were received in each
+// bin. The key is derived from the key protecting the client's keystore
+// backup, so whoever can restore the backup can also read the cache.
+//
+// The package uses cgo through github.com/mattn/go-sqlite3; applications
+// that do not import it build without cgo.
+package sqlitestore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+
+	_ "github.com/mattn/go-sqlite3" // Registers the sqlite3 driver
+	"golang.org/x/crypto/hkdf"
+
+	"github.com/yourusername/secure-messaging-poc/pkg/client"
+)
+
+// KeySize is the size of a cache key
+const KeySize = 32
+
+// cacheKeyInfo separates the cache key from other keys derived from the
+// same backup key
+const cacheKeyInfo = "anono client message cache v1"
+
+var (
+	// ErrInvalidKey is returned for a key that is not KeySize bytes
+	ErrInvalidKey = errors.New("sqlitestore: key must be 32 bytes")
+	// ErrCorrupt is returned for a row that does not decrypt under the key
+	ErrCorrupt = errors.New("sqlitestore: row does not decrypt")
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS messages (
+	seq    INTEGER PRIMARY KEY AUTOINCREMENT,
+	bin_id INTEGER NOT NULL,
+	sealed BLOB NOT NULL
+);
+CREATE INDEX IF NOT EXISTS messages_bin ON messages (bin_id, seq);
+CREATE TABLE IF NOT EXISTS state (
+	name   TEXT PRIMARY KEY,
+	sealed BLOB NOT NULL
+);`
+
+// resumeTokenName is the state row holding the resume token
+const resumeTokenName = "resume_token"
+
+// StoredMessage is a cached message and its position in the cache
+type StoredMessage struct {
+	Seq     int64
+	Message *client.Message
+}
+
+// Store is a client.Store in a SQLite database
+type Store struct {
+	db   *sql.DB
+	aead cipher.AEAD
+}
+
+// DeriveKey derives the cache key from the key that encrypts the client's
+// keystore backup, such as keystore.KeyPair.EncryptionKey
+func DeriveKey(backupKey []byte) ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, backupKey, nil, []byte(cacheKeyInfo)), key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Open opens or creates the cache at path, encrypted with key
+func Open(path string, key []byte) (*Store, error) {
+	if len(key) != KeySize {
+		return nil, ErrInvalidKey
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("sqlite3", path+"?_journal_mode=WAL&_busy_timeout=5000")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Store{db: db, aead: aead}, nil
+}
+
+// Close closes the database
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Save implements client.Store
+func (s *Store) Save(msg *client.Message, resumeToken string) error {
+	plaintext, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	sealedMsg, err := s.seal(plaintext, messageAD(msg.BinID))
+	if err != nil {
+		return err
+	}
+	sealedToken, err := s.seal([]byte(resumeToken), stateAD(resumeTokenName))
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`INSERT INTO messages (bin_id, sealed) VALUES (?, ?)`, int64(msg.BinID), sealedMsg); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`INSERT OR REPLACE INTO state (name, sealed) VALUES (?, ?)`, resumeTokenName, sealedToken); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ResumeToken implements client.Store
+func (s *Store) ResumeToken() (string, error) {
+	var sealed []byte
+	err := s.db.QueryRow(`SELECT sealed FROM state WHERE name = ?`, resumeTokenName).Scan(&sealed)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	token, err := s.open(sealed, stateAD(resumeTokenName))
+	if err != nil {
+		return "", err
+	}
+	return string(token), nil
+}
+
+// Messages returns up to limit messages of a bin saved after position
+// after, oldest first. Pass the Seq of the last message returned to page
+// through the cache.
+func (s *Store) Messages(binID uint64, after int64, limit int) ([]StoredMessage, error) {
+	rows, err := s.db.Query(`SELECT seq, sealed FROM messages WHERE bin_id = ? AND seq > ? ORDER BY seq LIMIT ?`,
+		int64(binID), after, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := make([]StoredMessage, 0)
+	for rows.Next() {
+		var (
+			seq    int64
+			sealed []byte
+		)
+		if err := rows.Scan(&seq, &sealed); err != nil {
+			return nil, err
+		}
+		plaintext, err := s.open(sealed, messageAD(binID))
+		if err != nil {
+			return nil, err
+		}
+		var msg client.Message
+		if err := json.Unmarshal(plaintext, &msg); err != nil {
+			return nil, ErrCorrupt
+		}
+		messages = append(messages, StoredMessage{Seq: seq, Message: &msg})
+	}
+	return messages, rows.Err()
+}
+
+// Prune deletes the messages of every bin saved at or before position upTo
+func (s *Store) Prune(upTo int64) (int64, error) {
+	result, err := s.db.Exec(`DELETE FROM messages WHERE seq <= ?`, upTo)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// seal encrypts plaintext bound to ad, prefixed with its nonce
+func (s *Store) seal(plaintext, ad []byte) ([]byte, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return s.aead.Seal(nonce, nonce, plaintext, ad), nil
+}
+
+// open decrypts a value written by seal
+func (s *Store) open(sealed, ad []byte) ([]byte, error) {
+	if len(sealed) < s.aead.NonceSize() {
+		return nil, ErrCorrupt
+	}
+	nonce, ciphertext := sealed[:s.aead.NonceSize()], sealed[s.aead.NonceSize():]
+	plaintext, err := s.aead.Open(nil, nonce, ciphertext, ad)
+	if err != nil {
+		return nil, ErrCorrupt
+	}
+	return plaintext, nil
+}
+
+// messageAD binds a sealed message to its bin, so rows cannot be moved
+// between bins unnoticed
+func messageAD(binID uint64) []byte {
+	ad := make([]byte, 0, len("message")+8)
+	ad = append(ad, "message"...)
+	return binary.BigEndian.AppendUint64(ad, binID)
+}
+
+// stateAD binds a sealed state value to its name
+func stateAD(name string) []byte {
+	return []byte("state:" + name)
+}
@@ -0,0 +1,95 @@
+package sqlitestore
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/yourusername/secure-messaging-poc/pkg/client"
+)
+
+// openTestStore opens a store in a temporary directory
+func openTestStore(t *testing.T, path string, key []byte) *Store {
+	t.Helper()
+	store, err := Open(path, key)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestStoreSavesMessagesAndResumeToken(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.db")
+	key, err := DeriveKey([]byte("backup encryption key"))
+	if err != nil {
+		t.Fatalf("DeriveKey failed: %v", err)
+	}
+	store := openTestStore(t, path, key)
+
+	if token, err := store.ResumeToken(); err != nil || token != "" {
+		t.Fatalf("Expected no token in a new store, got %q, %v", token, err)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	for i, id := range []string{"first", "second"} {
+		msg := &client.Message{BinID: 0x1000, MessageID: id, Ciphertext: []byte(id), Timestamp: now}
+		if err := store.Save(msg, "token-"+id); err != nil {
+			t.Fatalf("Save %d failed: %v", i, err)
+		}
+	}
+	if err := store.Save(&client.Message{BinID: 0x2000, MessageID: "other"}, "token-other"); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	// Reopening with the same key reads everything back
+	store.Close()
+	store = openTestStore(t, path, key)
+	if token, err := store.ResumeToken(); err != nil || token != "token-other" {
+		t.Errorf("Expected the last token, got %q, %v", token, err)
+	}
+	messages, err := store.Messages(0x1000, 0, 10)
+	if err != nil {
+		t.Fatalf("Messages failed: %v", err)
+	}
+	if len(messages) != 2 || messages[0].Message.MessageID != "first" || !bytes.Equal(messages[1].Message.Ciphertext, []byte("second")) {
+		t.Fatalf("Unexpected messages %+v", messages)
+	}
+
+	// Paging continues after the last position
+	if rest, err := store.Messages(0x1000, messages[0].Seq, 10); err != nil || len(rest) != 1 || rest[0].Message.MessageID != "second" {
+		t.Errorf("Unexpected page %+v, %v", rest, err)
+	}
+
+	if pruned, err := store.Prune(messages[1].Seq); err != nil || pruned != 2 {
+		t.Errorf("Expected two messages pruned, got %d, %v", pruned, err)
+	}
+	if others, err := store.Messages(0x2000, 0, 10); err != nil || len(others) != 1 {
+		t.Errorf("Pruning should keep later messages, got %+v, %v", others, err)
+	}
+}
+
+func TestStoreRequiresItsKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.db")
+	key, _ := DeriveKey([]byte("backup encryption key"))
+	store := openTestStore(t, path, key)
+	if err := store.Save(&client.Message{BinID: 1, MessageID: "secret"}, "token"); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	store.Close()
+
+	otherKey, _ := DeriveKey([]byte("another backup key"))
+	other := openTestStore(t, path, otherKey)
+	if _, err := other.ResumeToken(); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Expected ErrCorrupt under another key, got %v", err)
+	}
+	if _, err := other.Messages(1, 0, 10); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Expected ErrCorrupt under another key, got %v", err)
+	}
+
+	if _, err := Open(path, []byte("short")); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("Expected ErrInvalidKey, got %v", err)
+	}
+}
@@ -0,0 +1,13 @@
+package client
+
+// Store persists received messages together with the resume position, so
+// bots and bridges restarted from it neither miss nor repeat messages.
+// pkg/client/sqlitestore provides an encrypted SQLite implementation.
+type Store interface {
+	// Save records a newly delivered message and the resume token that
+	// includes it, atomically
+	Save(msg *Message, resumeToken string) error
+	// ResumeToken returns the token of the last Save, or "" for an empty
+	// store
+	ResumeToken() (string, error)
+}
@@ -134,23 +134,25 @@ func TestAESCBC(t *testing.T) {
 				t.Errorf("Decrypted text doesn't match original: got %s, want %s", decrypted, plaintext)
 			}
 
-			// Test with incorrect key (should fail)
+			// Test with incorrect key. The padding check rejects most wrong
+			// keys, but garbled padding can still look valid by chance.
 			wrongKey := make([]byte, len(key))
 			copy(wrongKey, key)
 			wrongKey[0] ^= 0x01 // flip a bit
-			_, err = AESCBCDecrypt(ciphertext, wrongKey, iv)
-			if err == nil {
-				t.Errorf("Decryption should have failed with incorrect key")
+			decrypted, err = AESCBCDecrypt(ciphertext, wrongKey, iv)
+			if err == nil && bytes.Equal(decrypted, plaintext) {
+				t.Errorf("Decryption with incorrect key should not recover the plaintext")
 			}
 
-			// Test with incorrect IV (should fail)
+			// Test with incorrect IV. CBC is unauthenticated and a wrong IV
+			// only garbles the first block, so decryption may succeed; it
+			// must not recover the plaintext.
 			wrongIV := make([]byte, len(iv))
 			copy(wrongIV, iv)
 			wrongIV[0] ^= 0x01 // flip a bit
-			_, err = AESCBCDecrypt(ciphertext, key, wrongIV)
-			if err == nil && len(plaintext) > 0 {
-				// For empty plaintext, CBC mode might actually decrypt successfully with wrong IV due to padding
-				t.Errorf("Decryption should have failed with incorrect IV")
+			decrypted, err = AESCBCDecrypt(ciphertext, key, wrongIV)
+			if err == nil && bytes.Equal(decrypted, plaintext) {
+				t.Errorf("Decryption with incorrect IV should not recover the plaintext")
 			}
 		})
 	}
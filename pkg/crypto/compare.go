@@ -0,0 +1,19 @@
+package crypto
+
+import "crypto/subtle"
+
+// Comparisons of secrets and of values derived from them, such as
+// certificate IDs, tokens, invite codes and MACs, must not take time that
+// depends on where the values first differ. Only the lengths may leak.
+
+// ConstantTimeEqual reports whether a and b are equal in time that depends
+// only on their lengths
+func ConstantTimeEqual(a, b []byte) bool {
+	return subtle.ConstantTimeCompare(a, b) == 1
+}
+
+// ConstantTimeEqualString reports whether a and b are equal in time that
+// depends only on their lengths
+func ConstantTimeEqualString(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
@@ -0,0 +1,166 @@
+package crypto
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestConstantTimeEqual(t *testing.T) {
+	tests := []struct {
+		a, b  string
+		equal bool
+	}{
+		{"", "", true},
+		{"invite-code", "invite-code", true},
+		{"invite-code", "invite-codf", false},
+		{"invite-code", "invite", false},
+		{"", "x", false},
+	}
+	for _, tt := range tests {
+		if got := ConstantTimeEqualString(tt.a, tt.b); got != tt.equal {
+			t.Errorf("ConstantTimeEqualString(%q, %q) = %v", tt.a, tt.b, got)
+		}
+		if got := ConstantTimeEqual([]byte(tt.a), []byte(tt.b)); got != tt.equal {
+			t.Errorf("ConstantTimeEqual(%q, %q) = %v", tt.a, tt.b, got)
+		}
+	}
+}
+
+// secretName matches identifiers that hold secrets or values derived from
+// them, which must be compared with ConstantTimeEqual. Any name ending in id
+// is assumed to identify a client until publicID says otherwise.
+var secretName = regexp.MustCompile(`(?i)(id|serial|token|invite|secret|proof|hmac|mac|digest|thumbprint)s?$`)
+
+// publicID matches identifiers that are not secret: bin IDs are public
+// routing values and message IDs are delivered to every subscriber. A
+// comparison with either operand named so is allowed.
+var publicID = regexp.MustCompile(`(?i)^(bin|message)ids?$`)
+
+// TestNoDirectSecretComparisons flags == and != between secret-bearing
+// values anywhere in the module outside tests. Comparisons with nil, empty
+// strings and other literals are allowed, as they reveal nothing.
+func TestNoDirectSecretComparisons(t *testing.T) {
+	root := moduleRoot(t)
+	fset := token.NewFileSet()
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if name := info.Name(); path != root && (strings.HasPrefix(name, ".") || name == "vendor" || name == "testdata") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(path, ".go") || strings.HasSuffix(path, "_test.go") {
+			return nil
+		}
+
+		// Files that do not parse are still checked as far as they go
+		file, _ := parser.ParseFile(fset, path, nil, 0)
+		if file == nil {
+			return nil
+		}
+		ast.Inspect(file, func(n ast.Node) bool {
+			expr, ok := n.(*ast.BinaryExpr)
+			if !ok || (expr.Op != token.EQL && expr.Op != token.NEQ) {
+				return true
+			}
+			if isLiteral(expr.X) || isLiteral(expr.Y) || isPublicID(expr.X) || isPublicID(expr.Y) {
+				return true
+			}
+			if name := secretOperand(expr.X, expr.Y); name != "" {
+				rel, _ := filepath.Rel(root, path)
+				t.Errorf("%s:%d: %s compared with %s; use ConstantTimeEqual",
+					rel, fset.Position(expr.Pos()).Line, name, expr.Op)
+			}
+			return true
+		})
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to walk the module: %v", err)
+	}
+}
+
+// secretOperand returns the name of the first operand that holds a secret
+func secretOperand(operands ...ast.Expr) string {
+	for _, operand := range operands {
+		if name := operandName(operand); name != "" && !strings.HasPrefix(name, "Err") && secretName.MatchString(name) {
+			return name
+		}
+	}
+	return ""
+}
+
+// isPublicID reports whether expr names a value publicID allows
+func isPublicID(expr ast.Expr) bool {
+	return publicID.MatchString(operandName(expr))
+}
+
+// operandName names a variable, field, element or call result
+func operandName(expr ast.Expr) string {
+	switch e := expr.(type) {
+	case *ast.Ident:
+		return e.Name
+	case *ast.SelectorExpr:
+		return e.Sel.Name
+	case *ast.IndexExpr:
+		return operandName(e.X)
+	case *ast.ParenExpr:
+		return operandName(e.X)
+	case *ast.CallExpr:
+		// Conversions and calls such as CertificateID(cert) are named
+		// after the function
+		return operandName(e.Fun)
+	case *ast.BinaryExpr:
+		// Masking keeps the kind of value, so binID&mask is still a bin ID
+		switch e.Op {
+		case token.AND, token.AND_NOT, token.OR, token.XOR:
+			return operandName(e.X)
+		}
+	}
+	return ""
+}
+
+// isLiteral reports whether expr is nil, a basic literal or a constant-like
+// identifier that cannot be secret
+func isLiteral(expr ast.Expr) bool {
+	switch e := expr.(type) {
+	case *ast.BasicLit:
+		return true
+	case *ast.Ident:
+		return e.Name == "nil" || e.Name == "true" || e.Name == "false"
+	case *ast.CallExpr:
+		// len(x) and friends
+		if fun, ok := e.Fun.(*ast.Ident); ok && (fun.Name == "len" || fun.Name == "cap") {
+			return true
+		}
+	}
+	return false
+}
+
+// moduleRoot finds the directory holding go.mod
+func moduleRoot(t *testing.T) string {
+	t.Helper()
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Getwd failed: %v", err)
+	}
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			t.Fatal("go.mod not found")
+		}
+		dir = parent
+	}
+}
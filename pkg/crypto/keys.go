@@ -0,0 +1,145 @@
+package crypto
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// KeyAlgorithm names a key type and size for CA and certificate keys
+type KeyAlgorithm string
+
+// Supported key algorithms
+const (
+	RSA2048   KeyAlgorithm = "rsa-2048"
+	RSA3072   KeyAlgorithm = "rsa-3072"
+	RSA4096   KeyAlgorithm = "rsa-4096"
+	ECDSAP256 KeyAlgorithm = "ecdsa-p256"
+	ECDSAP384 KeyAlgorithm = "ecdsa-p384"
+	Ed25519   KeyAlgorithm = "ed25519"
+)
+
+// DefaultKeyAlgorithm is used for new keys unless configured otherwise. It
+// is much cheaper than RSA on mobile clients, for handshakes and signatures
+// alike.
+const DefaultKeyAlgorithm = ECDSAP256
+
+// Common PEM block types of private keys other than PKCS #1 RSA keys
+const (
+	PKCS8PrivateKeyBlockType = "PRIVATE KEY"
+	ECPrivateKeyBlockType    = "EC PRIVATE KEY"
+)
+
+// minRSABits is the smallest RSA key accepted in a certificate
+const minRSABits = 2048
+
+var (
+	// ErrUnknownKeyAlgorithm is returned for an unsupported algorithm name
+	ErrUnknownKeyAlgorithm = errors.New("unknown key algorithm")
+	// ErrUnsupportedKeyType is returned for a key of an unsupported type,
+	// curve or size
+	ErrUnsupportedKeyType = errors.New("unsupported key type")
+)
+
+// ParseKeyAlgorithm parses an algorithm name such as "ecdsa-p256"; an
+// empty name is DefaultKeyAlgorithm
+func ParseKeyAlgorithm(name string) (KeyAlgorithm, error) {
+	if name == "" {
+		return DefaultKeyAlgorithm, nil
+	}
+	algorithm := KeyAlgorithm(strings.ToLower(name))
+	switch algorithm {
+	case RSA2048, RSA3072, RSA4096, ECDSAP256, ECDSAP384, Ed25519:
+		return algorithm, nil
+	}
+	return "", fmt.Errorf("%w: %s", ErrUnknownKeyAlgorithm, name)
+}
+
+// GenerateKey generates a private key of the algorithm
+func GenerateKey(algorithm KeyAlgorithm) (crypto.Signer, error) {
+	switch algorithm {
+	case RSA2048:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case RSA3072:
+		return rsa.GenerateKey(rand.Reader, 3072)
+	case RSA4096:
+		return rsa.GenerateKey(rand.Reader, 4096)
+	case ECDSAP256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case ECDSAP384:
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case Ed25519:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownKeyAlgorithm, algorithm)
+}
+
+// CheckPublicKey accepts the public keys certificates may carry: RSA of at
+// least 2048 bits, ECDSA on P-256, P-384 or P-521, and Ed25519
+func CheckPublicKey(pub crypto.PublicKey) error {
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
+		if key.N.BitLen() >= minRSABits {
+			return nil
+		}
+		return fmt.Errorf("%w: RSA key of %d bits", ErrUnsupportedKeyType, key.N.BitLen())
+	case *ecdsa.PublicKey:
+		switch key.Curve {
+		case elliptic.P256(), elliptic.P384(), elliptic.P521():
+			return nil
+		}
+		return fmt.Errorf("%w: ECDSA curve %s", ErrUnsupportedKeyType, key.Curve.Params().Name)
+	case ed25519.PublicKey:
+		return nil
+	}
+	return fmt.Errorf("%w: %T", ErrUnsupportedKeyType, pub)
+}
+
+// MarshalSignerToPEM encodes a private key as PEM: RSA keys as PKCS #1, as
+// MarshalPrivateKeyToPEM does, and other keys as PKCS #8
+func MarshalSignerToPEM(key crypto.Signer) ([]byte, error) {
+	if rsaKey, ok := key.(*rsa.PrivateKey); ok {
+		return MarshalPrivateKeyToPEM(rsaKey)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: PKCS8PrivateKeyBlockType, Bytes: der}), nil
+}
+
+// ParseSignerFromPEM parses a PEM encoded private key of any supported type,
+// in PKCS #1, SEC 1 or PKCS #8 form
+func ParseSignerFromPEM(keyPEM []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.New("failed to parse PEM block containing private key")
+	}
+
+	switch block.Type {
+	case RSAPrivateKeyBlockType:
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case ECPrivateKeyBlockType:
+		return x509.ParseECPrivateKey(block.Bytes)
+	case PKCS8PrivateKeyBlockType:
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("%w: %T", ErrUnsupportedKeyType, key)
+		}
+		return signer, nil
+	}
+	return nil, fmt.Errorf("unexpected PEM block type %q for a private key", block.Type)
+}
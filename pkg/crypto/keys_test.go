@@ -0,0 +1,79 @@
+package crypto
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestKeyAlgorithms(t *testing.T) {
+	for _, algorithm := range []KeyAlgorithm{RSA2048, ECDSAP256, ECDSAP384, Ed25519} {
+		t.Run(string(algorithm), func(t *testing.T) {
+			key, err := GenerateKey(algorithm)
+			if err != nil {
+				t.Fatalf("GenerateKey failed: %v", err)
+			}
+			if err := CheckPublicKey(key.Public()); err != nil {
+				t.Errorf("CheckPublicKey refused a generated key: %v", err)
+			}
+
+			keyPEM, err := MarshalSignerToPEM(key)
+			if err != nil {
+				t.Fatalf("MarshalSignerToPEM failed: %v", err)
+			}
+			parsed, err := ParseSignerFromPEM(keyPEM)
+			if err != nil {
+				t.Fatalf("ParseSignerFromPEM failed: %v", err)
+			}
+			if !reflect.DeepEqual(parsed.Public(), key.Public()) {
+				t.Error("Parsed key does not match the generated one")
+			}
+
+			csrPEM, err := CreateCSR("client.example.com", nil, key)
+			if err != nil {
+				t.Fatalf("CreateCSR failed: %v", err)
+			}
+			caCertPEM, err := CreateSelfSignedCert("CA Example", nil, key, 1)
+			if err != nil {
+				t.Fatalf("CreateSelfSignedCert failed: %v", err)
+			}
+			if _, err := SignCSRWithCA(csrPEM, caCertPEM, keyPEM, 1); err != nil {
+				t.Errorf("SignCSRWithCA failed: %v", err)
+			}
+		})
+	}
+}
+
+func TestParseKeyAlgorithm(t *testing.T) {
+	if algorithm, err := ParseKeyAlgorithm(""); err != nil || algorithm != DefaultKeyAlgorithm {
+		t.Errorf("Expected the default for an empty name, got %q, %v", algorithm, err)
+	}
+	if algorithm, err := ParseKeyAlgorithm("ECDSA-P384"); err != nil || algorithm != ECDSAP384 {
+		t.Errorf("Expected ecdsa-p384, got %q, %v", algorithm, err)
+	}
+	if _, err := ParseKeyAlgorithm("dsa-1024"); !errors.Is(err, ErrUnknownKeyAlgorithm) {
+		t.Errorf("Expected ErrUnknownKeyAlgorithm, got %v", err)
+	}
+}
+
+func TestCheckPublicKeyRefusesWeakKeys(t *testing.T) {
+	small, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	if err := CheckPublicKey(&small.PublicKey); !errors.Is(err, ErrUnsupportedKeyType) {
+		t.Errorf("Expected a 1024-bit RSA key to be refused, got %v", err)
+	}
+
+	p224, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	if err := CheckPublicKey(&p224.PublicKey); !errors.Is(err, ErrUnsupportedKeyType) {
+		t.Errorf("Expected a P-224 key to be refused, got %v", err)
+	}
+}
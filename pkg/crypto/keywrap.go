@@ -0,0 +1,171 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+)
+
+// Key wrapping with AES-KW (RFC 3394) and AES-KWP (RFC 5649), the formats
+// other implementations such as WebCrypto and JOSE use to carry keys under a
+// key-encryption key. A wrapped key is 8 bytes longer than the key, or than
+// the key padded to a multiple of 8 bytes with KWP.
+
+// keyWrapIV is the default initial value of AES-KW
+var keyWrapIV = [8]byte{0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6}
+
+// keyWrapPadIV is the constant half of the AES-KWP initial value, which
+// ends with the key's length
+var keyWrapPadIV = [4]byte{0xA6, 0x59, 0x59, 0xA6}
+
+var (
+	// ErrKeyWrapLength is returned for a key or wrapped key of a length
+	// the mode cannot take
+	ErrKeyWrapLength = errors.New("invalid key wrap input length")
+	// ErrKeyUnwrap is returned for a wrapped key that fails its integrity
+	// check, because it was altered or wrapped under another key
+	ErrKeyUnwrap = errors.New("key unwrap failed")
+)
+
+// WrapKey wraps key under kek with AES-KW. key must be a multiple of 8
+// bytes and at least 16.
+func WrapKey(kek, key []byte) ([]byte, error) {
+	if len(key) < 16 || len(key)%8 != 0 {
+		return nil, ErrKeyWrapLength
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	return wrap(block, keyWrapIV, key), nil
+}
+
+// UnwrapKey unwraps a key wrapped with WrapKey
+func UnwrapKey(kek, wrapped []byte) ([]byte, error) {
+	if len(wrapped) < 24 || len(wrapped)%8 != 0 {
+		return nil, ErrKeyWrapLength
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	iv, key := unwrap(block, wrapped)
+	if subtle.ConstantTimeCompare(iv[:], keyWrapIV[:]) != 1 {
+		return nil, ErrKeyUnwrap
+	}
+	return key, nil
+}
+
+// WrapKeyWithPadding wraps key under kek with AES-KWP, which takes keys of
+// any non-zero length
+func WrapKeyWithPadding(kek, key []byte) ([]byte, error) {
+	if len(key) == 0 || uint64(len(key)) > 0xFFFFFFFF {
+		return nil, ErrKeyWrapLength
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	var iv [8]byte
+	copy(iv[:], keyWrapPadIV[:])
+	binary.BigEndian.PutUint32(iv[4:], uint32(len(key)))
+	padded := make([]byte, (len(key)+7)/8*8)
+	copy(padded, key)
+
+	// A single block is encrypted directly
+	if len(padded) == 8 {
+		wrapped := make([]byte, 16)
+		copy(wrapped, iv[:])
+		copy(wrapped[8:], padded)
+		block.Encrypt(wrapped, wrapped)
+		return wrapped, nil
+	}
+	return wrap(block, iv, padded), nil
+}
+
+// UnwrapKeyWithPadding unwraps a key wrapped with WrapKeyWithPadding
+func UnwrapKeyWithPadding(kek, wrapped []byte) ([]byte, error) {
+	if len(wrapped) < 16 || len(wrapped)%8 != 0 {
+		return nil, ErrKeyWrapLength
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	var iv [8]byte
+	var padded []byte
+	if len(wrapped) == 16 {
+		plain := make([]byte, 16)
+		block.Decrypt(plain, wrapped)
+		copy(iv[:], plain)
+		padded = plain[8:]
+	} else {
+		iv, padded = unwrap(block, wrapped)
+	}
+
+	// The length must fall in the last block and the padding must be zero.
+	// Every check runs so failures take the same time.
+	length := binary.BigEndian.Uint32(iv[4:])
+	valid := subtle.ConstantTimeCompare(iv[:4], keyWrapPadIV[:])
+	valid &= subtle.ConstantTimeLessOrEq(len(padded)-7, int(length))
+	valid &= subtle.ConstantTimeLessOrEq(int(length), len(padded))
+	var nonZero byte
+	for i := len(padded) - 7; i < len(padded); i++ {
+		inPadding := subtle.ConstantTimeLessOrEq(int(length), i)
+		nonZero |= byte(subtle.ConstantTimeSelect(inPadding, int(padded[i]), 0))
+	}
+	valid &= subtle.ConstantTimeByteEq(nonZero, 0)
+	if valid != 1 {
+		return nil, ErrKeyUnwrap
+	}
+	return padded[:length], nil
+}
+
+// wrap is the RFC 3394 wrapping process over 8-byte blocks of plaintext
+func wrap(block cipher.Block, iv [8]byte, plaintext []byte) []byte {
+	n := len(plaintext) / 8
+	out := make([]byte, 8+len(plaintext))
+	copy(out[8:], plaintext)
+
+	a := iv
+	var b [16]byte
+	for j := 0; j < 6; j++ {
+		for i := 1; i <= n; i++ {
+			r := out[8*i : 8*i+8]
+			copy(b[:8], a[:])
+			copy(b[8:], r)
+			block.Encrypt(b[:], b[:])
+			binary.BigEndian.PutUint64(a[:], binary.BigEndian.Uint64(b[:8])^uint64(n*j+i))
+			copy(r, b[8:])
+		}
+	}
+	copy(out, a[:])
+	return out
+}
+
+// unwrap is the RFC 3394 unwrapping process, returning the recovered
+// initial value for the caller to check
+func unwrap(block cipher.Block, ciphertext []byte) ([8]byte, []byte) {
+	n := len(ciphertext)/8 - 1
+	out := make([]byte, len(ciphertext)-8)
+	copy(out, ciphertext[8:])
+
+	var a [8]byte
+	copy(a[:], ciphertext[:8])
+	var b [16]byte
+	for j := 5; j >= 0; j-- {
+		for i := n; i >= 1; i-- {
+			r := out[8*(i-1) : 8*i]
+			binary.BigEndian.PutUint64(b[:8], binary.BigEndian.Uint64(a[:])^uint64(n*j+i))
+			copy(b[8:], r)
+			block.Decrypt(b[:], b[:])
+			copy(a[:], b[:8])
+			copy(r, b[8:])
+		}
+	}
+	return a, out
+}
@@ -0,0 +1,134 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func TestKeyWrap(t *testing.T) {
+	// Test vectors from RFC 3394 section 4
+	tests := []struct {
+		name    string
+		kek     string
+		key     string
+		wrapped string
+	}{
+		{
+			"128-bit key with a 128-bit KEK",
+			"000102030405060708090A0B0C0D0E0F",
+			"00112233445566778899AABBCCDDEEFF",
+			"1FA68B0A8112B447AEF34BD8FB5A7B829D3E862371D2CFE5",
+		},
+		{
+			"256-bit key with a 256-bit KEK",
+			"000102030405060708090A0B0C0D0E0F101112131415161718191A1B1C1D1E1F",
+			"00112233445566778899AABBCCDDEEFF000102030405060708090A0B0C0D0E0F",
+			"28C9F404C4B810F4CBCCB35CFB87F8263F5786E2D80ED326CBC7F0E71A99F43BFB988B9B7A02DD21",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kek, key, expected := mustHex(t, tt.kek), mustHex(t, tt.key), mustHex(t, tt.wrapped)
+
+			wrapped, err := WrapKey(kek, key)
+			if err != nil {
+				t.Fatalf("WrapKey failed: %v", err)
+			}
+			if !bytes.Equal(wrapped, expected) {
+				t.Fatalf("Expected %X, got %X", expected, wrapped)
+			}
+			unwrapped, err := UnwrapKey(kek, wrapped)
+			if err != nil {
+				t.Fatalf("UnwrapKey failed: %v", err)
+			}
+			if !bytes.Equal(unwrapped, key) {
+				t.Errorf("Expected %X, got %X", key, unwrapped)
+			}
+
+			wrapped[len(wrapped)-1] ^= 1
+			if _, err := UnwrapKey(kek, wrapped); !errors.Is(err, ErrKeyUnwrap) {
+				t.Errorf("Expected ErrKeyUnwrap for an altered key, got %v", err)
+			}
+		})
+	}
+
+	kek := make([]byte, 16)
+	if _, err := WrapKey(kek, make([]byte, 20)); !errors.Is(err, ErrKeyWrapLength) {
+		t.Errorf("Expected ErrKeyWrapLength for a partial block, got %v", err)
+	}
+	if _, err := WrapKey(kek, make([]byte, 8)); !errors.Is(err, ErrKeyWrapLength) {
+		t.Errorf("Expected ErrKeyWrapLength for a single block, got %v", err)
+	}
+	if _, err := UnwrapKey(kek, make([]byte, 16)); !errors.Is(err, ErrKeyWrapLength) {
+		t.Errorf("Expected ErrKeyWrapLength for a short wrapped key, got %v", err)
+	}
+}
+
+func TestKeyWrapWithPadding(t *testing.T) {
+	// Test vectors from RFC 5649 section 6
+	kek := mustHex(t, "5840df6e29b02af1ab493b705bf16ea1ae8338f4dcc176a8")
+	tests := []struct {
+		name    string
+		key     string
+		wrapped string
+	}{
+		{"20-byte key", "c37b7e6492584340bed12207808941155068f738", "138bdeaa9b8fa7fc61f97742e72248ee5ae6ae5360d1ae6a5f54f373fa543b6a"},
+		{"7-byte key", "466f7250617369", "afbeb0f07dfbf5419200f2ccb50bb24f"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, expected := mustHex(t, tt.key), mustHex(t, tt.wrapped)
+
+			wrapped, err := WrapKeyWithPadding(kek, key)
+			if err != nil {
+				t.Fatalf("WrapKeyWithPadding failed: %v", err)
+			}
+			if !bytes.Equal(wrapped, expected) {
+				t.Fatalf("Expected %x, got %x", expected, wrapped)
+			}
+			unwrapped, err := UnwrapKeyWithPadding(kek, wrapped)
+			if err != nil {
+				t.Fatalf("UnwrapKeyWithPadding failed: %v", err)
+			}
+			if !bytes.Equal(unwrapped, key) {
+				t.Errorf("Expected %x, got %x", key, unwrapped)
+			}
+
+			wrapped[0] ^= 1
+			if _, err := UnwrapKeyWithPadding(kek, wrapped); !errors.Is(err, ErrKeyUnwrap) {
+				t.Errorf("Expected ErrKeyUnwrap for an altered key, got %v", err)
+			}
+		})
+	}
+
+	// Keys of every length round trip, and a KW key is not a KWP key
+	for length := 1; length <= 40; length++ {
+		key := bytes.Repeat([]byte{byte(length)}, length)
+		wrapped, err := WrapKeyWithPadding(kek, key)
+		if err != nil {
+			t.Fatalf("WrapKeyWithPadding of %d bytes failed: %v", length, err)
+		}
+		if unwrapped, err := UnwrapKeyWithPadding(kek, wrapped); err != nil || !bytes.Equal(unwrapped, key) {
+			t.Fatalf("Round trip of %d bytes failed: %x, %v", length, unwrapped, err)
+		}
+	}
+	wrapped, _ := WrapKey(kek, make([]byte, 16))
+	if _, err := UnwrapKeyWithPadding(kek, wrapped); !errors.Is(err, ErrKeyUnwrap) {
+		t.Errorf("Expected ErrKeyUnwrap for an AES-KW key, got %v", err)
+	}
+	if _, err := WrapKeyWithPadding(kek, nil); !errors.Is(err, ErrKeyWrapLength) {
+		t.Errorf("Expected ErrKeyWrapLength for an empty key, got %v", err)
+	}
+}
+
+// mustHex decodes a hex test vector
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatalf("Invalid test vector %q: %v", s, err)
+	}
+	return b
+}
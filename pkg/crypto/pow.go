@@ -0,0 +1,70 @@
+package crypto
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"math/bits"
+)
+
+// MaxProofOfWorkBits bounds the difficulty a server may ask for
+const MaxProofOfWorkBits = 32
+
+// powDomain separates proof-of-work hashes from other uses of SHA-256
+var powDomain = []byte("anono-pow-v1")
+
+// proofOfWorkHash binds a nonce to the bin and the ciphertext, so a solution
+// cannot be reused for another bin or message
+func proofOfWorkHash(binID uint64, ciphertext, nonce []byte) [32]byte {
+	digest := sha256.Sum256(ciphertext)
+
+	h := sha256.New()
+	h.Write(powDomain)
+	var id [8]byte
+	binary.BigEndian.PutUint64(id[:], binID)
+	h.Write(id[:])
+	h.Write(digest[:])
+	h.Write(nonce)
+
+	var sum [32]byte
+	h.Sum(sum[:0])
+	return sum
+}
+
+// leadingZeroBits counts the zero bits at the start of sum
+func leadingZeroBits(sum [32]byte) int {
+	count := 0
+	for _, b := range sum {
+		if b != 0 {
+			return count + bits.LeadingZeros8(b)
+		}
+		count += 8
+	}
+	return count
+}
+
+// VerifyProofOfWork reports whether nonce solves the puzzle for a message
+// to binID at the given difficulty, in leading zero bits
+func VerifyProofOfWork(binID uint64, ciphertext, nonce []byte, difficulty int) bool {
+	if difficulty <= 0 {
+		return true
+	}
+	if difficulty > MaxProofOfWorkBits || len(nonce) == 0 || len(nonce) > 16 {
+		return false
+	}
+	return leadingZeroBits(proofOfWorkHash(binID, ciphertext, nonce)) >= difficulty
+}
+
+// SolveProofOfWork searches for a nonce that satisfies VerifyProofOfWork.
+// Each extra bit of difficulty doubles the expected work.
+func SolveProofOfWork(binID uint64, ciphertext []byte, difficulty int) []byte {
+	if difficulty > MaxProofOfWorkBits {
+		difficulty = MaxProofOfWorkBits
+	}
+	nonce := make([]byte, 8)
+	for counter := uint64(0); ; counter++ {
+		binary.BigEndian.PutUint64(nonce, counter)
+		if VerifyProofOfWork(binID, ciphertext, nonce, difficulty) {
+			return nonce
+		}
+	}
+}
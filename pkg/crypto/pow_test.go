@@ -0,0 +1,33 @@
+package crypto
+
+import (
+	"testing"
+)
+
+func TestProofOfWork(t *testing.T) {
+	ciphertext := []byte("first message in a new bin")
+
+	nonce := SolveProofOfWork(0x1000, ciphertext, 12)
+	if !VerifyProofOfWork(0x1000, ciphertext, nonce, 12) {
+		t.Fatal("Solution should verify")
+	}
+
+	// Bound to the bin and the message
+	if VerifyProofOfWork(0x2000, ciphertext, nonce, 12) && VerifyProofOfWork(0x3000, ciphertext, nonce, 12) {
+		t.Error("Solution should not carry over to other bins")
+	}
+	if VerifyProofOfWork(0x1000, []byte("another message"), nonce, 12) &&
+		VerifyProofOfWork(0x1000, []byte("yet another message"), nonce, 12) {
+		t.Error("Solution should not carry over to other messages")
+	}
+
+	if !VerifyProofOfWork(0x1000, ciphertext, nil, 0) {
+		t.Error("Zero difficulty should need no nonce")
+	}
+	if VerifyProofOfWork(0x1000, ciphertext, nil, 1) {
+		t.Error("A missing nonce should not verify")
+	}
+	if VerifyProofOfWork(0x1000, ciphertext, nonce, MaxProofOfWorkBits+1) {
+		t.Error("Difficulty above the maximum should never verify")
+	}
+}
@@ -0,0 +1,54 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"errors"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/box"
+)
+
+// SealedBoxOverhead is how much longer a sealed box is than its message:
+// the sender's ephemeral public key and the authenticator
+const SealedBoxOverhead = box.AnonymousOverhead
+
+var (
+	// ErrLowOrderPoint is returned by X25519 for a peer key that would give
+	// an all-zero shared secret
+	ErrLowOrderPoint = errors.New("x25519: low order point")
+	// ErrSealedBoxOpen is returned for a sealed box that was altered or
+	// sealed to another key
+	ErrSealedBoxOpen = errors.New("sealed box: decryption failed")
+)
+
+// GenerateX25519Key generates an X25519 key pair for key agreement and
+// sealed boxes
+func GenerateX25519Key() (publicKey, privateKey *[32]byte, err error) {
+	return box.GenerateKey(rand.Reader)
+}
+
+// X25519 computes the shared secret of privateKey and a peer's public key
+// (RFC 7748). The result is raw key material: derive keys from it with a
+// KDF rather than using it directly.
+func X25519(privateKey, peerPublicKey *[32]byte) ([]byte, error) {
+	shared, err := curve25519.X25519(privateKey[:], peerPublicKey[:])
+	if err != nil {
+		return nil, ErrLowOrderPoint
+	}
+	return shared, nil
+}
+
+// SealAnonymous encrypts message to a recipient's public key without
+// identifying the sender, compatible with libsodium's crypto_box_seal
+func SealAnonymous(message []byte, recipient *[32]byte) ([]byte, error) {
+	return box.SealAnonymous(nil, message, recipient, rand.Reader)
+}
+
+// OpenAnonymous decrypts a box sealed with SealAnonymous
+func OpenAnonymous(sealed []byte, publicKey, privateKey *[32]byte) ([]byte, error) {
+	message, ok := box.OpenAnonymous(nil, sealed, publicKey, privateKey)
+	if !ok {
+		return nil, ErrSealedBoxOpen
+	}
+	return message, nil
+}
@@ -0,0 +1,78 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestX25519(t *testing.T) {
+	// Test vector from RFC 7748 section 6.1
+	key := func(s string) *[32]byte {
+		var k [32]byte
+		copy(k[:], mustHex(t, s))
+		return &k
+	}
+	alicePrivate := key("77076d0a7318a57d3c16c17251b26645df4c2f87ebc0992ab177fba51db92c2a")
+	alicePublic := key("8520f0098930a754748b7ddcb43ef75a0dbf3a0d26381af4eba4a98eaa9b4e6a")
+	bobPrivate := key("5dab087e624a8a4b79e17f8b83800ee66f3bb1292618b6fd1c2f8b27ff88e0eb")
+	bobPublic := key("de9edb7d7b7dc1b4d35b61c2ece435373f8343c85b78674dadfc7e146f882b4f")
+	expected := mustHex(t, "4a5d9d5ba4ce2de1728e3bf480350f25e07e21c947d19e3376f09b3c1e161742")
+
+	shared, err := X25519(alicePrivate, bobPublic)
+	if err != nil {
+		t.Fatalf("X25519 failed: %v", err)
+	}
+	if !bytes.Equal(shared, expected) {
+		t.Errorf("Expected %x, got %x", expected, shared)
+	}
+	shared, err = X25519(bobPrivate, alicePublic)
+	if err != nil || !bytes.Equal(shared, expected) {
+		t.Errorf("Expected both sides to agree, got %x, %v", shared, err)
+	}
+
+	if _, err := X25519(alicePrivate, &[32]byte{}); !errors.Is(err, ErrLowOrderPoint) {
+		t.Errorf("Expected ErrLowOrderPoint for the zero point, got %v", err)
+	}
+}
+
+func TestSealedBox(t *testing.T) {
+	public, private, err := GenerateX25519Key()
+	if err != nil {
+		t.Fatalf("GenerateX25519Key failed: %v", err)
+	}
+	message := []byte("channel key for the new member")
+
+	sealed, err := SealAnonymous(message, public)
+	if err != nil {
+		t.Fatalf("SealAnonymous failed: %v", err)
+	}
+	if len(sealed) != len(message)+SealedBoxOverhead {
+		t.Errorf("Expected %d bytes, got %d", len(message)+SealedBoxOverhead, len(sealed))
+	}
+	opened, err := OpenAnonymous(sealed, public, private)
+	if err != nil {
+		t.Fatalf("OpenAnonymous failed: %v", err)
+	}
+	if !bytes.Equal(opened, message) {
+		t.Errorf("Expected %q, got %q", message, opened)
+	}
+
+	// Each seal uses a fresh ephemeral key
+	again, _ := SealAnonymous(message, public)
+	if bytes.Equal(again, sealed) {
+		t.Error("Expected sealing to be randomized")
+	}
+
+	otherPublic, otherPrivate, _ := GenerateX25519Key()
+	if _, err := OpenAnonymous(sealed, otherPublic, otherPrivate); !errors.Is(err, ErrSealedBoxOpen) {
+		t.Errorf("Expected ErrSealedBoxOpen for another key, got %v", err)
+	}
+	sealed[len(sealed)-1] ^= 1
+	if _, err := OpenAnonymous(sealed, public, private); !errors.Is(err, ErrSealedBoxOpen) {
+		t.Errorf("Expected ErrSealedBoxOpen for an altered box, got %v", err)
+	}
+	if _, err := OpenAnonymous(sealed[:SealedBoxOverhead-1], public, private); !errors.Is(err, ErrSealedBoxOpen) {
+		t.Errorf("Expected ErrSealedBoxOpen for a truncated box, got %v", err)
+	}
+}
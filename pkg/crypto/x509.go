@@ -2,6 +2,7 @@ package crypto
 
 import (
 	"bytes"
+	"crypto"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
@@ -50,14 +51,14 @@ func ParsePrivateKeyFromPEM(privateKeyPEM []byte) (*rsa.PrivateKey, error) {
 	return x509.ParsePKCS1PrivateKey(block.Bytes)
 }
 
-// CreateCSR creates a new Certificate Signing Request
-func CreateCSR(commonName string, organization []string, privateKey *rsa.PrivateKey) ([]byte, error) {
+// CreateCSR creates a new Certificate Signing Request, signed with the
+// default algorithm for the key's type
+func CreateCSR(commonName string, organization []string, privateKey crypto.Signer) ([]byte, error) {
 	template := &x509.CertificateRequest{
 		Subject: pkix.Name{
 			CommonName:   commonName,
 			Organization: organization,
 		},
-		SignatureAlgorithm: x509.SHA256WithRSA,
 	}
 	
 	csrBytes, err := x509.CreateCertificateRequest(rand.Reader, template, privateKey)
@@ -89,7 +90,7 @@ func ParseCSRFromPEM(csrPEM []byte) (*x509.CertificateRequest, error) {
 }
 
 // CreateSelfSignedCert creates a self-signed certificate
-func CreateSelfSignedCert(commonName string, organization []string, privateKey *rsa.PrivateKey, daysValid int) ([]byte, error) {
+func CreateSelfSignedCert(commonName string, organization []string, privateKey crypto.Signer, daysValid int) ([]byte, error) {
 	// Generate a random serial number
 	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
 	if err != nil {
@@ -112,7 +113,7 @@ func CreateSelfSignedCert(commonName string, organization []string, privateKey *
 	}
 	
 	// Create certificate
-	certBytes, err := x509.CreateCertificate(rand.Reader, template, template, &privateKey.PublicKey, privateKey)
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, template, privateKey.Public(), privateKey)
 	if err != nil {
 		return nil, err
 	}
@@ -160,8 +161,8 @@ func SignCSRWithCA(csrPEM, caCertPEM, caKeyPEM []byte, daysValid int) ([]byte, e
 		return nil, err
 	}
 	
-	// Parse CA private key
-	caKey, err := ParsePrivateKeyFromPEM(caKeyPEM)
+	// Parse CA private key, of any supported type
+	caKey, err := ParseSignerFromPEM(caKeyPEM)
 	if err != nil {
 		return nil, err
 	}
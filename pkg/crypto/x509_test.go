@@ -31,7 +31,7 @@ func TestGenerateRSAKey(t *testing.T) {
 
 			// Verify key can be used for encryption/decryption (private key test)
 			// This is a simple sanity check that key operations work
-			if !key.Validate() {
+			if key.Validate() != nil {
 				t.Error("Key validation failed")
 			}
 		})
@@ -184,7 +184,8 @@ func TestCreateSelfSignedCert(t *testing.T) {
 	roots := x509.NewCertPool()
 	roots.AddCert(cert)
 	opts := x509.VerifyOptions{
-		Roots: roots,
+		Roots:     roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
 	}
 	_, err = cert.Verify(opts)
 	if err != nil {
@@ -254,7 +255,8 @@ func TestSignCSRWithCA(t *testing.T) {
 	roots := x509.NewCertPool()
 	roots.AddCert(caCert)
 	opts := x509.VerifyOptions{
-		Roots: roots,
+		Roots:     roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
 	}
 	_, err = clientCert.Verify(opts)
 	if err != nil {
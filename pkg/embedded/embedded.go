@@ -0,0 +1,329 @@
+// Package embedded runs the complete messaging server inside another Go
+// process: certificate authority, bin manager, key store and TLS listener,
+// configured in code rather than from a file. It is meant for test
+// harnesses and for desktop clients that bundle a local relay.
+package embedded
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/yourusername/secure-messaging-poc/internal/binmanager"
+	"github.com/yourusername/secure-messaging-poc/internal/certmanager"
+	"github.com/yourusername/secure-messaging-poc/internal/keystore"
+	"github.com/yourusername/secure-messaging-poc/internal/listenaddr"
+	"github.com/yourusername/secure-messaging-poc/internal/ratelimit"
+	"github.com/yourusername/secure-messaging-poc/internal/server"
+	"github.com/yourusername/secure-messaging-poc/internal/tlsconfig"
+	"github.com/yourusername/secure-messaging-poc/pkg/plugin"
+)
+
+// Defaults used when a Config leaves a field unset
+const (
+	DefaultAddress   = "127.0.0.1:0"
+	DefaultMask      = 0xFFFFFFFFFFFFF000
+	DefaultRetention = 24 * time.Hour
+)
+
+// validityDays is the lifetime of certificates the embedded CA issues
+const validityDays = 90
+
+var (
+	// ErrAlreadyStarted is returned by Start on a running server
+	ErrAlreadyStarted = errors.New("embedded: server already started")
+	// ErrNotStarted is returned when the server has not been started
+	ErrNotStarted = errors.New("embedded: server not started")
+	// ErrClosed is returned by Start after Shutdown; create a new Server
+	ErrClosed = errors.New("embedded: server shut down")
+)
+
+// Config configures an embedded server. The zero value is usable: a
+// loopback listener on a free port and a throwaway CA.
+type Config struct {
+	Address      string   // Listener address; DefaultAddress if empty
+	IPFamily     string   // "ipv4" or "ipv6" to listen on one family only; dual-stack if empty
+	DataDir      string   // Holds the CA key pair; a temporary directory removed on Shutdown if empty
+	Organization string   // Organization in issued certificates
+	Hosts        []string // Names in the listener certificate; loopback names if empty
+
+	InitialMask    uint64        // DefaultMask if zero
+	Retention      time.Duration // DefaultRetention if zero
+	MaxMessageSize int           // Ciphertext limit; 0 for none
+	PublishRate    float64       // Messages per second per certificate; 0 for no limit
+	PublishBurst   int
+	AdminCertIDs   []string        // Certificate IDs allowed to use the admin API
+	CleanupEvery   time.Duration   // Retention sweep interval; one minute if zero
+	Plugins        []plugin.Plugin // Receive lifecycle events; closed on Shutdown
+}
+
+// Server is an embedded messaging server
+type Server struct {
+	config     Config
+	dataDir    string
+	temporary  bool
+	ca         *certmanager.CertificateAuthority
+	revocation *certmanager.RevocationManager
+	bins       *binmanager.BinManager
+	keys       *keystore.EncryptedKeyStore
+	srv        *server.Server
+
+	listener net.Listener
+	served   chan error
+	closed   bool
+	mu       sync.Mutex
+}
+
+// New creates the CA, stores and server. Nothing listens until Start.
+func New(config Config) (*Server, error) {
+	if config.Address == "" {
+		config.Address = DefaultAddress
+	}
+	if config.Organization == "" {
+		config.Organization = "Embedded Relay"
+	}
+	if len(config.Hosts) == 0 {
+		config.Hosts = []string{"localhost", "127.0.0.1", "::1"}
+	}
+	if config.InitialMask == 0 {
+		config.InitialMask = DefaultMask
+	}
+	if config.Retention <= 0 {
+		config.Retention = DefaultRetention
+	}
+	if config.CleanupEvery <= 0 {
+		config.CleanupEvery = time.Minute
+	}
+
+	s := &Server{config: config, dataDir: config.DataDir}
+	if s.dataDir == "" {
+		dir, err := os.MkdirTemp("", "anono-embedded")
+		if err != nil {
+			return nil, err
+		}
+		s.dataDir, s.temporary = dir, true
+	} else if err := os.MkdirAll(s.dataDir, 0700); err != nil {
+		return nil, err
+	}
+
+	if err := s.build(); err != nil {
+		if s.temporary {
+			os.RemoveAll(s.dataDir)
+		}
+		return nil, err
+	}
+	return s, nil
+}
+
+// build wires the subsystems together the way the server command does
+func (s *Server) build() error {
+	ca, err := certmanager.NewCertificateAuthority(
+		filepath.Join(s.dataDir, "ca.crt"),
+		filepath.Join(s.dataDir, "ca.key"),
+		s.config.Organization,
+	)
+	if err != nil {
+		return err
+	}
+	registry, err := certmanager.NewIssuanceRegistry(filepath.Join(s.dataDir, "issued.jsonl"))
+	if err != nil {
+		return err
+	}
+	ca.SetIssuanceRegistry(registry)
+	caCert, err := ca.GetCACertificate()
+	if err != nil {
+		return err
+	}
+	listenerCert, err := ca.IssueServerCertificate(s.config.Hosts, validityDays)
+	if err != nil {
+		return err
+	}
+
+	s.ca = ca
+	s.revocation = certmanager.NewRevocationManager()
+	registry.RestoreReferrals(s.revocation)
+	s.bins = binmanager.NewBinManager(s.config.InitialMask, s.config.Retention)
+	s.keys = keystore.NewEncryptedKeyStore()
+
+	tlsConfig, err := tlsconfig.New(tlsconfig.Policy{ClientAuth: tlsconfig.ClientAuthRequireAndVerify}).
+		WithTrustStore(certmanager.NewTrustStore("", caCert)).
+		WithRevocation(s.revocation).
+		WithCertificate(*listenerCert).
+		IdentifyClients().
+		Build()
+	if err != nil {
+		return err
+	}
+
+	opts := []server.Option{
+		server.WithMaxMessageSize(s.config.MaxMessageSize),
+	}
+	if s.config.PublishRate > 0 {
+		opts = append(opts, server.WithPublishLimiter(ratelimit.NewTokenBucket(s.config.PublishRate, s.config.PublishBurst)))
+	}
+	if len(s.config.AdminCertIDs) > 0 {
+		opts = append(opts, server.WithAdmins(s.config.AdminCertIDs))
+	}
+	if len(s.config.Plugins) > 0 {
+		opts = append(opts, server.WithPlugins(plugin.DefaultQueueSize, s.config.Plugins...))
+	}
+
+	s.srv = server.NewServer(s.config.Address, tlsConfig, s.bins, s.revocation, ca, s.keys, opts...)
+	return nil
+}
+
+// Start listens and serves in the background
+func (s *Server) Start() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	if s.listener != nil {
+		return ErrAlreadyStarted
+	}
+
+	family := s.config.IPFamily
+	if family == "" {
+		family = listenaddr.FamilyDual
+	}
+	listener, err := listenaddr.Listen(family, s.config.Address)
+	if err != nil {
+		return err
+	}
+	s.listener = listener
+	s.served = make(chan error, 1)
+	s.bins.StartCleanupService(s.config.CleanupEvery)
+
+	go func() {
+		err := s.srv.Serve(listener)
+		if err == http.ErrServerClosed {
+			err = nil
+		}
+		s.served <- err
+	}()
+	return nil
+}
+
+// Shutdown stops accepting connections, waits for requests in flight until
+// ctx is done, and stops the background services. A temporary data
+// directory is removed. The server cannot be started again.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener == nil {
+		return ErrNotStarted
+	}
+
+	err := s.srv.Shutdown(ctx)
+	if serveErr := <-s.served; err == nil {
+		err = serveErr
+	}
+	s.bins.Stop()
+	s.listener = nil
+	s.closed = true
+
+	if s.temporary {
+		os.RemoveAll(s.dataDir)
+	}
+	return err
+}
+
+// Addr returns the address the server listens on, or nil before Start
+func (s *Server) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// URL returns the base URL of the server, e.g. https://127.0.0.1:41234
+func (s *Server) URL() string {
+	addr := s.Addr()
+	if addr == nil {
+		return ""
+	}
+	return "https://" + addr.String()
+}
+
+// CACertificate returns the CA that signs client and listener certificates
+func (s *Server) CACertificate() *x509.Certificate {
+	caCert, _ := s.ca.GetCACertificate()
+	return caCert
+}
+
+// BinMask returns the current bin mask
+func (s *Server) BinMask() uint64 {
+	return s.bins.GetCurrentMask()
+}
+
+// IssueClientCertificate creates a key pair and a client certificate for
+// it, as enrollment would, referred by referrerID if not empty
+func (s *Server) IssueClientCertificate(commonName, referrerID string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: commonName},
+	}, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	cert, err := s.ca.SignCSR(csr, referrerID, validityDays)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	s.revocation.RegisterCertificateUntil(certmanager.CertificateID(cert), referrerID, cert.NotAfter)
+
+	return tls.Certificate{
+		Certificate: [][]byte{cert.Raw},
+		PrivateKey:  key,
+		Leaf:        cert,
+	}, nil
+}
+
+// CertificateID returns the ID the server uses for a certificate
+func CertificateID(cert *x509.Certificate) string {
+	return certmanager.CertificateID(cert)
+}
+
+// Revoke revokes a certificate by ID, and the certificates it referred if
+// children is set
+func (s *Server) Revoke(certID string, children bool) {
+	if children {
+		s.revocation.RevokeWithChildren(certID)
+	} else {
+		s.revocation.Revoke(certID)
+	}
+}
+
+// ClientTLSConfig returns a configuration for connecting to this server
+// with cert
+func (s *Server) ClientTLSConfig(cert tls.Certificate) *tls.Config {
+	roots := x509.NewCertPool()
+	roots.AddCert(s.CACertificate())
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      roots,
+		MinVersion:   tls.VersionTLS13,
+	}
+}
@@ -0,0 +1,166 @@
+package embedded
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yourusername/secure-messaging-poc/pkg/client"
+	"github.com/yourusername/secure-messaging-poc/pkg/protocol"
+)
+
+func TestEmbeddedServerRoundTrip(t *testing.T) {
+	srv, err := New(Config{})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer srv.Shutdown(context.Background())
+
+	if err := srv.Start(); err != ErrAlreadyStarted {
+		t.Errorf("Expected ErrAlreadyStarted, got %v", err)
+	}
+
+	cert, err := srv.IssueClientCertificate("alice", "")
+	if err != nil {
+		t.Fatalf("Failed to issue client certificate: %v", err)
+	}
+
+	// The listener certificate chains to the embedded CA
+	httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: srv.ClientTLSConfig(cert)}}
+	resp, err := httpClient.Get(srv.URL() + "/health")
+	if err != nil {
+		t.Fatalf("Health check failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Health check returned %s", resp.Status)
+	}
+
+	// Without a client certificate the handshake is refused
+	anonymous := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: srv.ClientTLSConfig(cert).RootCAs}}}
+	if resp, err := anonymous.Get(srv.URL() + "/health"); err == nil {
+		resp.Body.Close()
+		t.Error("Connections without a client certificate should fail")
+	}
+
+	received := make(chan *client.Message, 1)
+	acked := make(chan client.PublishAck, 1)
+	connected := make(chan struct{}, 1)
+	c, err := client.New(client.Config{
+		ServerURL:    srv.URL(),
+		TLSConfig:    srv.ClientTLSConfig(cert),
+		Channels:     []uint64{0x1234},
+		OnMessage:    func(msg *client.Message) { received <- msg },
+		OnConnect:    func(uint64, []uint64) { connected <- struct{}{} },
+		OnPublishAck: func(ack client.PublishAck) { acked <- ack },
+	})
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.Run(ctx)
+
+	select {
+	case <-connected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Client did not connect")
+	}
+	if err := c.Publish(0x1234, &client.Message{MessageID: "m1", Ciphertext: []byte("hello"), Ack: protocol.AckDurable}); err != nil {
+		t.Fatalf("Failed to publish: %v", err)
+	}
+
+	// The embedded server keeps messages in memory, so the ack is not durable
+	select {
+	case ack := <-acked:
+		if ack.MessageID != "m1" || ack.Durable {
+			t.Errorf("Unexpected publish ack %+v", ack)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Publish was not acknowledged")
+	}
+
+	select {
+	case msg := <-received:
+		if string(msg.Ciphertext) != "hello" || msg.BinID != 0x1234&srv.BinMask() {
+			t.Errorf("Unexpected message %+v", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Published message not delivered")
+	}
+
+	cancel()
+	if err := srv.Shutdown(context.Background()); err != nil {
+		t.Errorf("Shutdown failed: %v", err)
+	}
+	if err := srv.Start(); err != ErrClosed {
+		t.Errorf("Expected ErrClosed after shutdown, got %v", err)
+	}
+}
+
+func TestEmbeddedServerIPv6Only(t *testing.T) {
+	if probe, err := net.Listen("tcp6", "[::1]:0"); err != nil {
+		t.Skipf("IPv6 loopback unavailable: %v", err)
+	} else {
+		probe.Close()
+	}
+
+	srv, err := New(Config{Address: "[::1]:0", IPFamily: "ipv6"})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer srv.Shutdown(context.Background())
+
+	if !strings.HasPrefix(srv.URL(), "https://[::1]:") {
+		t.Fatalf("Expected a bracketed IPv6 URL, got %s", srv.URL())
+	}
+
+	cert, err := srv.IssueClientCertificate("alice", "")
+	if err != nil {
+		t.Fatalf("Failed to issue client certificate: %v", err)
+	}
+	httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: srv.ClientTLSConfig(cert)}}
+	resp, err := httpClient.Get(srv.URL() + "/health")
+	if err != nil {
+		t.Fatalf("Health check over IPv6 failed: %v", err)
+	}
+	resp.Body.Close()
+
+	// Nothing answers on the IPv4 loopback at the same port
+	port := srv.Addr().(*net.TCPAddr).Port
+	if conn, err := net.DialTimeout("tcp4", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)), time.Second); err == nil {
+		conn.Close()
+		t.Error("An IPv6-only server should not accept IPv4 connections")
+	}
+
+	connected := make(chan struct{}, 1)
+	c, err := client.New(client.Config{
+		ServerURL: srv.URL(),
+		TLSConfig: srv.ClientTLSConfig(cert),
+		Channels:  []uint64{0x1234},
+		OnConnect: func(uint64, []uint64) { connected <- struct{}{} },
+	})
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.Run(ctx)
+
+	select {
+	case <-connected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Client did not connect over IPv6")
+	}
+}
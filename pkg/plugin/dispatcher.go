@@ -0,0 +1,133 @@
+package plugin
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// DefaultQueueSize is the number of events buffered per plugin when
+// NewDispatcher is given no size
+const DefaultQueueSize = 1024
+
+// Dispatcher delivers events to plugins. Each plugin has its own queue and
+// goroutine; an event that finds a queue full is dropped for that plugin
+// rather than delaying the server. A nil *Dispatcher drops every event, so
+// callers need not check whether plugins are configured.
+type Dispatcher struct {
+	queues []chan Event
+	ctx    context.Context
+	cancel context.CancelFunc
+	onDrop func(Event)
+	closed bool
+	wg     sync.WaitGroup
+	mu     sync.RWMutex
+}
+
+// NewDispatcher starts delivering events to plugins, buffering up to
+// queueSize events for each
+func NewDispatcher(queueSize int, plugins ...Plugin) *Dispatcher {
+	if queueSize <= 0 {
+		queueSize = DefaultQueueSize
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &Dispatcher{ctx: ctx, cancel: cancel}
+	for _, p := range plugins {
+		queue := make(chan Event, queueSize)
+		d.queues = append(d.queues, queue)
+		d.wg.Add(1)
+		go d.run(p, queue)
+	}
+	return d
+}
+
+// OnDrop registers a callback invoked for every event a full queue drops
+func (d *Dispatcher) OnDrop(fn func(Event)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.onDrop = fn
+}
+
+// Emit queues event for every plugin without blocking. A zero Time is set
+// to the current time.
+func (d *Dispatcher) Emit(event Event) {
+	if d == nil {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.closed {
+		return
+	}
+	for _, queue := range d.queues {
+		select {
+		case queue <- event:
+		default:
+			if d.onDrop != nil {
+				d.onDrop(event)
+			}
+		}
+	}
+}
+
+// Close stops accepting events, waits for the queued ones to be handled,
+// then closes the plugins that implement Closer. Plugins still handling
+// events when ctx is done see their context cancelled.
+func (d *Dispatcher) Close(ctx context.Context) error {
+	if d == nil {
+		return nil
+	}
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return nil
+	}
+	d.closed = true
+	for _, queue := range d.queues {
+		close(queue)
+	}
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		d.cancel()
+		return nil
+	case <-ctx.Done():
+		d.cancel()
+		return ctx.Err()
+	}
+}
+
+// run delivers the events of one queue to its plugin, then closes it
+func (d *Dispatcher) run(p Plugin, queue <-chan Event) {
+	defer d.wg.Done()
+	for event := range queue {
+		d.deliver(p, event)
+	}
+	if closer, ok := p.(Closer); ok {
+		if err := closer.Close(); err != nil {
+			log.Printf("Plugin close error: %v", err)
+		}
+	}
+}
+
+// deliver hands one event to a plugin; a panicking plugin loses the event
+// but keeps receiving the ones after it
+func (d *Dispatcher) deliver(p Plugin, event Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Plugin panic handling %s: %v", event.Type, r)
+		}
+	}()
+	p.HandleEvent(d.ctx, event)
+}
@@ -0,0 +1,130 @@
+// Package plugin lets deployments observe the lifecycle of a server without
+// forking it: connections opening and closing, subscriptions, accepted and
+// rejected publishes, and certificate revocations. Plugins are compiled
+// into the server binary and register themselves by name from an init
+// function, the way database/sql drivers do:
+//
+//	func init() {
+//		plugin.Register("alerts", func(settings map[string]interface{}) (plugin.Plugin, error) {
+//			return newAlerts(settings)
+//		})
+//	}
+//
+// A blank import of the plugin's package in the server command makes it
+// available to the plugins section of the configuration file. Events never
+// carry ciphertext, only what the server itself logs about a connection.
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// EventType identifies a lifecycle event
+type EventType string
+
+// Lifecycle events delivered to plugins
+const (
+	ConnectionOpened   EventType = "connection_opened"
+	ConnectionClosed   EventType = "connection_closed"
+	Subscribed         EventType = "subscribed"
+	PublishAccepted    EventType = "publish_accepted"
+	PublishRejected    EventType = "publish_rejected"
+	CertificateRevoked EventType = "certificate_revoked"
+)
+
+// Event describes one lifecycle event. Fields that do not apply to the
+// event type are left zero.
+type Event struct {
+	Type       EventType `json:"type"`
+	Time       time.Time `json:"time"`
+	CertID     string    `json:"cert_id,omitempty"`
+	Connection uint64    `json:"connection,omitempty"` // Server-assigned connection number
+	SessionID  string    `json:"session_id,omitempty"` // Session of a multiplexed connection
+	BinIDs     []uint64  `json:"bin_ids,omitempty"`    // Subscribed bins
+	BinID      uint64    `json:"bin_id,omitempty"`     // Bin of a publish
+	MessageID  string    `json:"message_id,omitempty"`
+	Size       int       `json:"size,omitempty"`   // Ciphertext bytes of a publish
+	Reason     string    `json:"reason,omitempty"` // Why a publish was rejected or a certificate revoked
+}
+
+// Plugin receives lifecycle events. HandleEvent is called from a single
+// goroutine per plugin, in the order the events happened, and never on the
+// path of the connection that caused them, so a slow plugin delays only its
+// own events. The context is cancelled when the server shuts down.
+type Plugin interface {
+	HandleEvent(ctx context.Context, event Event)
+}
+
+// Closer is implemented by plugins that release resources on shutdown
+type Closer interface {
+	Close() error
+}
+
+// Func adapts a function to the Plugin interface
+type Func func(ctx context.Context, event Event)
+
+// HandleEvent calls f
+func (f Func) HandleEvent(ctx context.Context, event Event) {
+	f(ctx, event)
+}
+
+// Factory creates a plugin from its settings in the configuration file
+type Factory func(settings map[string]interface{}) (Plugin, error)
+
+// ErrUnknownPlugin is returned by New for a name nothing registered
+var ErrUnknownPlugin = errors.New("plugin: unknown plugin")
+
+var (
+	factories   = make(map[string]Factory)
+	factoriesMu sync.RWMutex
+)
+
+// Register makes a plugin available under name. It panics if name is
+// already registered or factory is nil, since both are programming errors
+// caught at startup.
+func Register(name string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+
+	if factory == nil {
+		panic("plugin: Register factory is nil for " + name)
+	}
+	if _, exists := factories[name]; exists {
+		panic("plugin: Register called twice for " + name)
+	}
+	factories[name] = factory
+}
+
+// New creates the plugin registered under name
+func New(name string, settings map[string]interface{}) (Plugin, error) {
+	factoriesMu.RLock()
+	factory, exists := factories[name]
+	factoriesMu.RUnlock()
+
+	if !exists {
+		return nil, fmt.Errorf("%w %q", ErrUnknownPlugin, name)
+	}
+	p, err := factory(settings)
+	if err != nil {
+		return nil, fmt.Errorf("plugin %s: %w", name, err)
+	}
+	return p, nil
+}
+
+// Names returns the registered plugin names, sorted
+func Names() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
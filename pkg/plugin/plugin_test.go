@@ -0,0 +1,113 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+// recorder collects the events it receives
+type recorder struct {
+	events []Event
+	closed bool
+	mu     sync.Mutex
+}
+
+func (r *recorder) HandleEvent(ctx context.Context, event Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+}
+
+func (r *recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	return nil
+}
+
+func TestRegistry(t *testing.T) {
+	Register("test-recorder", func(settings map[string]interface{}) (Plugin, error) {
+		if settings["fail"] == true {
+			return nil, errors.New("bad settings")
+		}
+		return &recorder{}, nil
+	})
+
+	if _, err := New("test-recorder", nil); err != nil {
+		t.Errorf("Failed to create registered plugin: %v", err)
+	}
+	if _, err := New("test-recorder", map[string]interface{}{"fail": true}); err == nil {
+		t.Error("Expected factory error")
+	}
+	if _, err := New("missing", nil); !errors.Is(err, ErrUnknownPlugin) {
+		t.Errorf("Expected ErrUnknownPlugin, got %v", err)
+	}
+
+	found := false
+	for _, name := range Names() {
+		found = found || name == "test-recorder"
+	}
+	if !found {
+		t.Error("Registered plugin missing from Names")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected duplicate registration to panic")
+		}
+	}()
+	Register("test-recorder", func(map[string]interface{}) (Plugin, error) { return nil, nil })
+}
+
+func TestDispatcherDeliversInOrder(t *testing.T) {
+	rec := &recorder{}
+	panicking := Func(func(ctx context.Context, event Event) { panic("broken plugin") })
+	d := NewDispatcher(16, panicking, rec)
+
+	d.Emit(Event{Type: ConnectionOpened, Connection: 1})
+	d.Emit(Event{Type: PublishAccepted, Connection: 1, MessageID: "msg1"})
+	d.Emit(Event{Type: ConnectionClosed, Connection: 1})
+	if err := d.Close(context.Background()); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	d.Emit(Event{Type: ConnectionOpened, Connection: 2})
+
+	if len(rec.events) != 3 || !rec.closed {
+		t.Fatalf("Expected 3 events and a closed plugin, got %d (closed %v)", len(rec.events), rec.closed)
+	}
+	want := []EventType{ConnectionOpened, PublishAccepted, ConnectionClosed}
+	for i, event := range rec.events {
+		if event.Type != want[i] || event.Time.IsZero() {
+			t.Errorf("Event %d: got %s at %v, want %s", i, event.Type, event.Time, want[i])
+		}
+	}
+}
+
+func TestDispatcherDropsWhenFull(t *testing.T) {
+	started, release := make(chan struct{}, 1), make(chan struct{})
+	blocked := Func(func(ctx context.Context, event Event) {
+		started <- struct{}{}
+		<-release
+	})
+	d := NewDispatcher(1, blocked)
+
+	var dropped int
+	d.OnDrop(func(Event) { dropped++ })
+
+	// The first event is being handled and the second fills the queue
+	d.Emit(Event{Type: Subscribed})
+	<-started
+	d.Emit(Event{Type: Subscribed})
+	d.Emit(Event{Type: Subscribed})
+	if dropped != 1 {
+		t.Errorf("Expected 1 dropped event, got %d", dropped)
+	}
+
+	close(release)
+	d.Close(context.Background())
+
+	var nilDispatcher *Dispatcher
+	nilDispatcher.Emit(Event{Type: Subscribed})
+}
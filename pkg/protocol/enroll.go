@@ -0,0 +1,65 @@
+package protocol
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// PseudonymScheme is the URI scheme of pseudonym SANs, anono://<hash>
+const PseudonymScheme = "anono"
+
+// ErrInvalidPseudonym is returned for a pseudonym that is not 64 lowercase
+// hex characters
+var ErrInvalidPseudonym = errors.New("protocol: invalid pseudonym")
+
+// EnrollmentRequest is a CSR for /api/certificate/request or ACME finalize,
+// with the private key it was made with
+type EnrollmentRequest struct {
+	CSR []byte // DER
+	Key *ecdsa.PrivateKey
+}
+
+// NewEnrollmentRequest generates a P-256 key and a CSR for it. A non-empty
+// pseudonym, the hex SHA-256 the client is known by, is requested as an
+// anono:// URI SAN; servers only embed it if their policy allows.
+func NewEnrollmentRequest(commonName, pseudonym string) (*EnrollmentRequest, error) {
+	template := &x509.CertificateRequest{Subject: pkix.Name{CommonName: commonName}}
+	if pseudonym != "" {
+		if _, err := hex.DecodeString(pseudonym); err != nil || len(pseudonym) != 64 || strings.ToLower(pseudonym) != pseudonym {
+			return nil, ErrInvalidPseudonym
+		}
+		template.URIs = []*url.URL{{Scheme: PseudonymScheme, Host: pseudonym}}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, template, key)
+	if err != nil {
+		return nil, err
+	}
+	return &EnrollmentRequest{CSR: csr, Key: key}, nil
+}
+
+// KeyPEM returns the private key as a PEM "PRIVATE KEY" block
+func (er *EnrollmentRequest) KeyPEM() ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(er.Key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// CSRPEM returns the CSR as a PEM block
+func (er *EnrollmentRequest) CSRPEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: er.CSR})
+}
@@ -0,0 +1,285 @@
+// Package protocol holds the client side of the wire protocol: the
+// WebSocket frames, bin derivation and enrollment requests. It has no
+// transport of its own and no dependencies beyond the standard library, so
+// the same code serves native clients and browsers running it as WASM.
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Frame types
+const (
+	TypeSubscribe    = "subscribe"
+	TypeSubscribeAck = "subscribe_ack"
+	TypePublishAck   = "publish_ack"
+	TypePresence     = "presence"
+	TypeError        = "error"
+
+	// Frames of multiplexed connections, which carry several sessions
+	TypeCloseSession  = "close_session"
+	TypeSessionClosed = "session_closed"
+)
+
+// Acknowledgements a publish may request in Message.Ack
+const (
+	AckAccepted = "accepted" // Once the server has accepted the message
+	AckDurable  = "durable"  // Once a durable store has synced it, if the server has one
+)
+
+// ProtocolVersion is the version of the wire protocol this package speaks.
+// Clients announce it when subscribing; servers refuse versions below the
+// minimum of their update manifest with CloseUpgradeRequired.
+const ProtocolVersion = 1
+
+// ErrUnknownFrame is returned for a frame of an unrecognized type
+var ErrUnknownFrame = errors.New("protocol: unknown frame type")
+
+// Message is a message as carried on the wire
+type Message struct {
+	BinID          uint64    `json:"bin_id"`
+	MessageID      string    `json:"message_id"`
+	Ciphertext     []byte    `json:"ciphertext"`
+	ReplyToID      string    `json:"reply_to_id,omitempty"`
+	ThreadTag      []byte    `json:"thread_tag,omitempty"`
+	PoW            []byte    `json:"pow,omitempty"`
+	Ack            string    `json:"ack,omitempty"`
+	Retention      int64     `json:"retention,omitempty"`       // Requested bin retention in seconds
+	RetentionProof []byte    `json:"retention_proof,omitempty"` // Channel-ownership secret
+	Imported       bool      `json:"imported,omitempty"`        // Migrated from another server, history only
+	SessionID      string    `json:"session_id,omitempty"`      // Session of a multiplexed connection
+	MaskEpoch      *uint64   `json:"mask_epoch,omitempty"`      // Epoch of the mask BinID was computed with
+	Signature      []byte    `json:"signature,omitempty"`       // By the publishing session's ephemeral key
+	SignerKey      []byte    `json:"signer_key,omitempty"`      // Set by the server once Signature is verified
+	Timestamp      time.Time `json:"timestamp,omitempty"`
+}
+
+// Subscribe is the first frame a client sends. Naming a session in the
+// first frame makes the connection multiplexed: further sessions are opened
+// with more subscribe frames, and every frame in either direction names its
+// session. A SigningKey registers an ephemeral Ed25519 key the session signs
+// its publishes with.
+type Subscribe struct {
+	Type            string   `json:"type"`
+	SessionID       string   `json:"session_id,omitempty"`
+	BinIDs          []uint64 `json:"bin_ids"`
+	ClientID        string   `json:"client_id,omitempty"`
+	ProtocolVersion int      `json:"protocol_version,omitempty"`
+	SigningKey      []byte   `json:"signing_key,omitempty"`
+}
+
+// CloseSession ends one session of a multiplexed connection
+type CloseSession struct {
+	Type      string `json:"type"`
+	SessionID string `json:"session_id"`
+}
+
+// SessionClosed confirms a CloseSession
+type SessionClosed struct {
+	Type      string `json:"type"`
+	SessionID string `json:"session_id"`
+}
+
+// SubscribeAck acknowledges a subscription, after retained messages have
+// been replayed. BinMask is the mask the subscribed bins were normalized
+// with; MaskEpoch increases with every mask change. Bins the server refused
+// are listed in RejectedBins; the rest of the subscription stands.
+// InstanceID and Region name the server instance that holds the session;
+// the same ID is sent in the InstanceHeader of the WebSocket upgrade, so a
+// client reaching another instance behind anycast or a load balancer learns
+// it before the replay.
+type SubscribeAck struct {
+	Type         string        `json:"type"`
+	ClientID     string        `json:"client_id"`
+	BinCount     int           `json:"bin_count"`
+	PrefixCount  int           `json:"prefix_count"`
+	BinMask      string        `json:"bin_mask,omitempty"`
+	MaskEpoch    uint64        `json:"mask_epoch,omitempty"`
+	SessionID    string        `json:"session_id,omitempty"`
+	Timestamp    string        `json:"timestamp"`
+	RejectedBins []RejectedBin `json:"rejected_bins,omitempty"`
+	InstanceID   string        `json:"instance_id,omitempty"`
+	Region       string        `json:"region,omitempty"`
+}
+
+// InstanceHeader carries the server instance ID in the WebSocket upgrade
+// response
+const InstanceHeader = "X-Anono-Instance"
+
+// TraceHeader carries the trace ID of an HTTP request or WebSocket
+// connection in the response. Operators find the server's log lines for a
+// reported problem by it; it reveals nothing else about the request.
+const TraceHeader = "X-Anono-Trace"
+
+// Reasons a bin of a subscription is rejected
+const (
+	RejectInvalidBin      = "invalid_bin"      // Not computed with the current bin mask
+	RejectSubscribeLimit  = "subscribe_limit"  // Over the bins one subscribe frame may name
+	RejectConnectionLimit = "connection_limit" // Over the bins one connection may receive
+)
+
+// RejectedBin is a bin a subscription named but does not receive
+type RejectedBin struct {
+	BinID  uint64 `json:"bin_id"`
+	Reason string `json:"reason"`
+}
+
+// PublishAck confirms a publish that requested an acknowledgement. Durable
+// is false if the server has no durable store; Duplicate is set if the
+// message had already been accepted. BinID is the bin the message was
+// stored in, normalized with BinMask. Servers that report delivery counts
+// set DeliveredAtLeast to the live subscribers reached, rounded down to a
+// threshold; it is zero below the threshold or when not reported.
+type PublishAck struct {
+	Type      string `json:"type"`
+	MessageID string `json:"message_id"`
+	BinID     uint64 `json:"bin_id"`
+	Durable   bool   `json:"durable"`
+	Duplicate bool   `json:"duplicate,omitempty"`
+	BinMask   string `json:"bin_mask,omitempty"`
+	MaskEpoch uint64 `json:"mask_epoch,omitempty"`
+	SessionID string `json:"session_id,omitempty"`
+	Timestamp string `json:"timestamp,omitempty"`
+
+	DeliveredAtLeast int `json:"delivered_at_least,omitempty"`
+}
+
+// Presence summarizes how many sessions are subscribed to a bin. Servers
+// that send summaries do so when the count changes, rounded down to a
+// threshold and only at or above it, so small groups send none and single
+// joins and departures go unseen.
+type Presence struct {
+	Type        string `json:"type"`
+	BinID       uint64 `json:"bin_id"`
+	Subscribers int    `json:"subscribers_at_least"`
+	SessionID   string `json:"session_id,omitempty"`
+	Timestamp   string `json:"timestamp,omitempty"`
+}
+
+// ErrorFrame is an error reported by the server
+type ErrorFrame struct {
+	Type       string `json:"type"`
+	Code       int    `json:"code"`
+	Message    string `json:"message"`
+	Retryable  bool   `json:"retryable"`
+	RetryAfter int    `json:"retry_after,omitempty"` // Seconds
+	Difficulty int    `json:"difficulty,omitempty"`  // Proof-of-work bits
+	MessageID  string `json:"message_id,omitempty"`  // Rejected publish
+	SessionID  string `json:"session_id,omitempty"`  // Session of a multiplexed connection
+	BinMask    string `json:"bin_mask,omitempty"`    // Current mask, with ErrorMaskChanged
+	MaskEpoch  uint64 `json:"mask_epoch,omitempty"`
+	TraceID    string `json:"trace_id,omitempty"` // Quote when reporting the error
+}
+
+// ErrorMaskChanged rejects a publish tagged with a mask epoch whose mask
+// has since gained bits, so its bin ID no longer determines the bin. The
+// frame carries the current mask; recompute the bin ID from the channel ID
+// and publish again.
+const ErrorMaskChanged = 4017
+
+// Frame is a decoded server frame; exactly one field is set
+type Frame struct {
+	Message       *Message
+	Ack           *SubscribeAck
+	PublishAck    *PublishAck
+	Presence      *Presence
+	Error         *ErrorFrame
+	SessionClosed *SessionClosed
+}
+
+// PermanentCloseCodes are the server close codes after which reconnecting
+// cannot help, such as a revoked certificate
+var PermanentCloseCodes = map[int]bool{
+	4001: true, // Bad subscribe
+	4003: true, // Certificate revoked
+	4004: true, // Referrer revoked
+	4005: true, // Forbidden
+	4006: true, // Certificate blocked
+	4009: true, // Subscribe denied
+	4014: true, // Too many sessions
+	4015: true, // Upgrade required
+}
+
+// CloseCertificateExpired ends a session whose certificate has expired. It
+// is not permanent: a client that renews, for example through
+// tls.Config.GetClientCertificate, reconnects with the new certificate.
+const CloseCertificateExpired = 4012
+
+// CloseUpgradeRequired ends a connection whose protocol version is below
+// the server's minimum; the update manifest lists newer clients
+const CloseUpgradeRequired = 4015
+
+// NewSubscribe builds the subscribe frame for the bins of channels
+func NewSubscribe(clientID string, channels []uint64, mask uint64) Subscribe {
+	return Subscribe{
+		Type:            TypeSubscribe,
+		BinIDs:          Bins(channels, mask),
+		ClientID:        clientID,
+		ProtocolVersion: ProtocolVersion,
+	}
+}
+
+// DecodeFrame decodes a frame received from the server. Messages are the
+// only frames without a type.
+func DecodeFrame(data []byte) (Frame, error) {
+	var header struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return Frame{}, err
+	}
+
+	var frame Frame
+	var target interface{}
+	switch header.Type {
+	case "":
+		frame.Message = &Message{}
+		target = frame.Message
+	case TypeSubscribeAck:
+		frame.Ack = &SubscribeAck{}
+		target = frame.Ack
+	case TypePublishAck:
+		frame.PublishAck = &PublishAck{}
+		target = frame.PublishAck
+	case TypePresence:
+		frame.Presence = &Presence{}
+		target = frame.Presence
+	case TypeError:
+		frame.Error = &ErrorFrame{}
+		target = frame.Error
+	case TypeSessionClosed:
+		frame.SessionClosed = &SessionClosed{}
+		target = frame.SessionClosed
+	default:
+		return Frame{}, ErrUnknownFrame
+	}
+	if err := json.Unmarshal(data, target); err != nil {
+		return Frame{}, err
+	}
+	return frame, nil
+}
+
+// Bins returns the distinct bins, in ascending order, that channels map to
+// under mask
+func Bins(channels []uint64, mask uint64) []uint64 {
+	seen := make(map[uint64]bool, len(channels))
+	bins := make([]uint64, 0, len(channels))
+	for _, channelID := range channels {
+		binID := channelID & mask
+		if !seen[binID] {
+			seen[binID] = true
+			bins = append(bins, binID)
+		}
+	}
+	sort.Slice(bins, func(i, j int) bool { return bins[i] < bins[j] })
+	return bins
+}
+
+// ParseMask parses a bin mask as the server info endpoint reports it
+func ParseMask(mask string) (uint64, error) {
+	return strconv.ParseUint(mask, 0, 64)
+}
@@ -0,0 +1,19 @@
+package protocol
+
+import "bytes"
+
+// DefaultPadBlock is the block size control frames are padded to. Subscribe
+// frames, acknowledgements and errors all fit within one block unless they
+// list many bins.
+const DefaultPadBlock = 256
+
+// Pad appends JSON whitespace to a marshaled frame so its length is a
+// multiple of block. Parsers ignore trailing whitespace, so frames of one
+// kind cannot be told apart by their size on the wire. A block of zero or
+// less returns data unchanged.
+func Pad(data []byte, block int) []byte {
+	if block <= 0 || len(data)%block == 0 {
+		return data
+	}
+	return append(data, bytes.Repeat([]byte{' '}, block-len(data)%block)...)
+}
@@ -0,0 +1,159 @@
+package protocol
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestDecodeFrame(t *testing.T) {
+	tests := []struct {
+		data  string
+		check func(Frame) bool
+	}{
+		{`{"bin_id":18446744073709547520,"message_id":"m","ciphertext":"eA=="}`, func(f Frame) bool {
+			return f.Message != nil && f.Message.BinID == 0xFFFFFFFFFFFFF000 && string(f.Message.Ciphertext) == "x"
+		}},
+		{`{"type":"subscribe_ack","client_id":"c","bin_count":2}`, func(f Frame) bool {
+			return f.Ack != nil && f.Ack.BinCount == 2
+		}},
+		{`{"type":"subscribe_ack","client_id":"c","bin_count":1,"rejected_bins":[{"bin_id":4097,"reason":"invalid_bin"}]}`, func(f Frame) bool {
+			return f.Ack != nil && len(f.Ack.RejectedBins) == 1 && f.Ack.RejectedBins[0].Reason == RejectInvalidBin
+		}},
+		{`{"type":"publish_ack","message_id":"m","bin_id":4096,"durable":true}`, func(f Frame) bool {
+			return f.PublishAck != nil && f.PublishAck.MessageID == "m" && f.PublishAck.Durable
+		}},
+		{`{"type":"presence","bin_id":4096,"subscribers_at_least":10}`, func(f Frame) bool {
+			return f.Presence != nil && f.Presence.BinID == 0x1000 && f.Presence.Subscribers == 10
+		}},
+		{`{"type":"error","code":4010,"message":"proof of work required","retryable":true,"difficulty":12}`, func(f Frame) bool {
+			return f.Error != nil && f.Error.Code == 4010 && f.Error.Difficulty == 12
+		}},
+		{`{"type":"session_closed","session_id":"work"}`, func(f Frame) bool {
+			return f.SessionClosed != nil && f.SessionClosed.SessionID == "work"
+		}},
+		{`{"bin_id":4096,"message_id":"m","ciphertext":"eA==","session_id":"home"}`, func(f Frame) bool {
+			return f.Message != nil && f.Message.SessionID == "home"
+		}},
+	}
+	for _, tt := range tests {
+		frame, err := DecodeFrame([]byte(tt.data))
+		if err != nil || !tt.check(frame) {
+			t.Errorf("Unexpected decoding of %s: %+v, %v", tt.data, frame, err)
+		}
+	}
+
+	if _, err := DecodeFrame([]byte(`{"type":"surprise"}`)); err != ErrUnknownFrame {
+		t.Errorf("Expected ErrUnknownFrame, got %v", err)
+	}
+}
+
+func TestNewSubscribe(t *testing.T) {
+	mask, err := ParseMask("0xFFFFFFFFFFFFF000")
+	if err != nil {
+		t.Fatalf("Failed to parse mask: %v", err)
+	}
+	frame := NewSubscribe("client", []uint64{0x2345, 0x1234, 0x1FFF}, mask)
+
+	data, _ := json.Marshal(frame)
+	if string(data) != `{"type":"subscribe","bin_ids":[4096,8192],"client_id":"client","protocol_version":1}` {
+		t.Errorf("Unexpected subscribe frame %s", data)
+	}
+}
+
+func TestClientUpdate(t *testing.T) {
+	update := ClientUpdate{MinProtocolVersion: 2, RecommendedProtocolVersion: 3}
+	tests := []struct {
+		version               int
+		required, recommended bool
+	}{
+		{0, true, true},
+		{1, true, true},
+		{2, false, true},
+		{3, false, false},
+		{4, false, false},
+	}
+	for _, tt := range tests {
+		if got := update.UpgradeRequired(tt.version); got != tt.required {
+			t.Errorf("UpgradeRequired(%d) = %v", tt.version, got)
+		}
+		if got := update.UpgradeRecommended(tt.version); got != tt.recommended {
+			t.Errorf("UpgradeRecommended(%d) = %v", tt.version, got)
+		}
+	}
+	if !PermanentCloseCodes[CloseUpgradeRequired] {
+		t.Error("Reconnecting without an upgrade cannot help")
+	}
+}
+
+func TestNewEnrollmentRequest(t *testing.T) {
+	pseudonym := strings.Repeat("0f", 32)
+	request, err := NewEnrollmentRequest("browser", pseudonym)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+
+	csr, err := x509.ParseCertificateRequest(request.CSR)
+	if err != nil || csr.CheckSignature() != nil {
+		t.Fatalf("CSR does not parse or verify: %v", err)
+	}
+	if csr.Subject.CommonName != "browser" || len(csr.URIs) != 1 || csr.URIs[0].String() != "anono://"+pseudonym {
+		t.Errorf("Unexpected CSR contents: %s %v", csr.Subject.CommonName, csr.URIs)
+	}
+	if keyPEM, err := request.KeyPEM(); err != nil || !strings.Contains(string(keyPEM), "PRIVATE KEY") {
+		t.Errorf("Key should encode to PEM: %v", err)
+	}
+
+	if _, err := NewEnrollmentRequest("browser", "not-a-hash"); err != ErrInvalidPseudonym {
+		t.Errorf("Expected ErrInvalidPseudonym, got %v", err)
+	}
+}
+
+func TestPad(t *testing.T) {
+	data, err := json.Marshal(PublishAck{Type: TypePublishAck, MessageID: "m", BinID: 0x1000})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	padded := Pad(data, DefaultPadBlock)
+	if len(padded) != DefaultPadBlock {
+		t.Fatalf("Expected %d bytes, got %d", DefaultPadBlock, len(padded))
+	}
+	frame, err := DecodeFrame(padded)
+	if err != nil || frame.PublishAck == nil || frame.PublishAck.MessageID != "m" {
+		t.Fatalf("Padded frame did not decode: %+v, %v", frame, err)
+	}
+
+	if got := Pad(padded, DefaultPadBlock); len(got) != DefaultPadBlock {
+		t.Errorf("Padding an aligned frame changed its length to %d", len(got))
+	}
+	if got := Pad(data, 0); len(got) != len(data) {
+		t.Errorf("Block 0 should leave the frame unchanged")
+	}
+}
+
+func TestMessageSignature(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	msg := &Message{BinID: 0x1000, MessageID: "m", Ciphertext: []byte("x"), ThreadTag: []byte("t")}
+	msg.Sign(private)
+	if msg.VerifySignature() {
+		t.Error("Signature verified without a signer key")
+	}
+
+	msg.SignerKey = public
+	if !msg.VerifySignature() {
+		t.Error("Valid signature rejected")
+	}
+
+	// Moving bytes between fields changes the payload
+	msg.MessageID, msg.Ciphertext = "mx", nil
+	if msg.VerifySignature() {
+		t.Error("Signature verified after fields were altered")
+	}
+}
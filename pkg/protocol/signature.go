@@ -0,0 +1,43 @@
+package protocol
+
+import (
+	"crypto/ed25519"
+	"encoding/binary"
+)
+
+// signatureContext separates message signatures from any other use of an
+// ephemeral key
+const signatureContext = "anono message signature v1\x00"
+
+// SignaturePayload returns the bytes a message signature covers: the bin
+// the publisher addressed and every field that is relayed to recipients.
+// Variable-length fields are length-prefixed so they cannot be shifted
+// into one another.
+func SignaturePayload(binID uint64, messageID string, ciphertext []byte, replyToID string, threadTag []byte) []byte {
+	payload := make([]byte, 0, len(signatureContext)+8+4*4+len(messageID)+len(ciphertext)+len(replyToID)+len(threadTag))
+	payload = append(payload, signatureContext...)
+	payload = binary.BigEndian.AppendUint64(payload, binID)
+	for _, field := range [][]byte{[]byte(messageID), ciphertext, []byte(replyToID), threadTag} {
+		payload = binary.BigEndian.AppendUint32(payload, uint32(len(field)))
+		payload = append(payload, field...)
+	}
+	return payload
+}
+
+// Sign signs the message with a session's ephemeral key. BinID and the
+// relayed fields must be final.
+func (m *Message) Sign(key ed25519.PrivateKey) {
+	m.Signature = ed25519.Sign(key, SignaturePayload(m.BinID, m.MessageID, m.Ciphertext, m.ReplyToID, m.ThreadTag))
+}
+
+// VerifySignature reports whether a delivered message carries a valid
+// signature by SignerKey. Signatures cover the bin the publisher addressed,
+// which differs from BinID if the server moved the message to a bin of a
+// newer mask.
+func (m *Message) VerifySignature() bool {
+	if len(m.SignerKey) != ed25519.PublicKeySize {
+		return false
+	}
+	payload := SignaturePayload(m.BinID, m.MessageID, m.Ciphertext, m.ReplyToID, m.ThreadTag)
+	return ed25519.Verify(ed25519.PublicKey(m.SignerKey), payload, m.Signature)
+}
@@ -0,0 +1,35 @@
+package protocol
+
+import "time"
+
+// ClientUpdate is the update manifest a server publishes at
+// /api/client-update, signed by its CA. Clients below MinProtocolVersion
+// are refused; clients below RecommendedProtocolVersion should offer an
+// update.
+type ClientUpdate struct {
+	MinProtocolVersion         int              `json:"min_protocol_version"`
+	RecommendedProtocolVersion int              `json:"recommended_protocol_version"`
+	Downloads                  []ClientDownload `json:"downloads,omitempty"`
+	IssuedAt                   time.Time        `json:"issued_at"`
+}
+
+// ClientDownload is a client build listed in an update manifest
+type ClientDownload struct {
+	Platform        string `json:"platform"`
+	Version         string `json:"version"`
+	ProtocolVersion int    `json:"protocol_version"`
+	URL             string `json:"url"`
+	SHA256          string `json:"sha256"` // Hex digest of the download
+}
+
+// UpgradeRequired reports whether a client speaking version must update
+// before it can connect
+func (u ClientUpdate) UpgradeRequired(version int) bool {
+	return version < u.MinProtocolVersion
+}
+
+// UpgradeRecommended reports whether a client speaking version should
+// offer an update
+func (u ClientUpdate) UpgradeRecommended(version int) bool {
+	return version < u.RecommendedProtocolVersion || u.UpgradeRequired(version)
+}